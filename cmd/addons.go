@@ -0,0 +1,80 @@
+/*
+Package cmd is the entrypoint the for cli
+*/
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"tidalwave/internal/addons"
+	"tidalwave/internal/bootstrap"
+	"tidalwave/internal/tidalwave"
+
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+)
+
+// addonsOut is the directory the rendered kustomizations are written to
+var addonsOut string
+
+// addonsCmd represents the addons command
+var addonsCmd = &cobra.Command{
+	Use:   "addons",
+	Short: "Work with the bundled add-on manifests",
+	Long:  "Work with the bundled add-on manifests",
+}
+
+// addonsRenderCmd represents the addons render command
+var addonsRenderCmd = &cobra.Command{
+	Use:   "render [kustomization...]",
+	Short: "Render the bundled add-on manifests from the config",
+	Long: `Render the bundled kustomizations with the versions, Helm parameters, namespaces and
+enabled add-ons of spec.addons and print every object, the output kustomize build would
+give, so GitOps repos can commit it. --out writes the rendered kustomizations to a
+directory instead. The kustomizations are spec.bootstrap.kustomizations unless they are
+given as arguments, the bundled ones are:
+
+  ` + fmt.Sprint(addons.Kustomizations),
+	Run: func(cmd *cobra.Command, args []string) {
+		cfg, err := loadConfig()
+		if err != nil {
+			log.Fatal(err)
+		}
+		kustomizations := args
+		if len(kustomizations) == 0 {
+			kustomizations = cfg.Spec.Bootstrap.Kustomizations
+		}
+		if addonsOut != "" {
+			if err := addons.Write(addonsOut, cfg.Spec.Addons, kustomizations); err != nil {
+				log.Fatal(err)
+			}
+			tidalwave.Infof(cmd.Context(), ":package:", "Rendered %d kustomizations to %s", len(kustomizations), addonsOut)
+			return
+		}
+		out := &bytes.Buffer{}
+		enc := yaml.NewEncoder(out)
+		enc.SetIndent(2)
+		for _, k := range kustomizations {
+			objs, err := bootstrap.Kustomize(addons.FS(cfg.Spec.Addons), k)
+			if err != nil {
+				log.Fatal(err)
+			}
+			for _, obj := range objs {
+				if err := enc.Encode(obj); err != nil {
+					log.Fatal(err)
+				}
+			}
+		}
+		if err := enc.Close(); err != nil {
+			log.Fatal(err)
+		}
+		fmt.Print(out.String())
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(addonsCmd)
+	addonsCmd.AddCommand(addonsRenderCmd)
+	addonsRenderCmd.Flags().StringVar(&addonsOut, "out", "", "directory to write the rendered kustomizations to instead of printing the objects")
+}
@@ -0,0 +1,116 @@
+/*
+Package cmd is the entrypoint the for cli
+*/
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"tidalwave/internal/aws"
+	"tidalwave/internal/config"
+	"tidalwave/internal/tidalwave"
+	"time"
+)
+
+func init() {
+	tidalwave.Register("aws", func(ctx context.Context, cfg *config.Config) (tidalwave.Controlplane, error) {
+		return CreateAWSControlplane(ctx, cfg)
+	})
+}
+
+// CreateAWSControlplane creates aws.Controlplane from a validated config
+func CreateAWSControlplane(ctx context.Context, cfg *config.Config) (*aws.Controlplane, error) {
+	spec := cfg.Spec
+	name := cfg.Metadata.Name
+	region := spec.Region
+	tidalwave.Infof(ctx, ":bullseye:", "Region: %s", region)
+	endpoint := spec.AWS.Endpoint
+	if endpoint != "" {
+		tidalwave.Infof(ctx, ":bullseye:", "Endpoint: %s", endpoint)
+	}
+	vpcCidr := spec.Cidrs.Vpc
+	publicAccessCidrs := []string{}
+	for _, b := range spec.Cluster.MasterAuthBlock {
+		publicAccessCidrs = append(publicAccessCidrs, b.CidrBlock)
+	}
+	timeouts := map[string]time.Duration{}
+	for kind := range config.Timeouts("aws") {
+		timeouts[kind] = spec.Timeout(kind)
+	}
+	cp := aws.Controlplane{
+		Parallelism: spec.Parallelism,
+		Timeouts:    timeouts,
+		Region:      region,
+		Endpoint:    endpoint,
+		Zones:       spec.AWS.Zones,
+		Vpc: aws.Vpc{
+			Name: name,
+			Cidr: vpcCidr,
+		},
+		Gateway: aws.Gateway{
+			Name: name,
+			Cidr: spec.Cidrs.Public,
+		},
+		Nat: aws.Nat{
+			Name: name,
+		},
+		Subnets: aws.Subnets{
+			Name:  name,
+			Cidrs: spec.Cidrs.Private,
+		},
+		Key: aws.Key{
+			Name: name,
+		},
+		Roles: aws.Roles{
+			Name: name,
+		},
+		Cluster: aws.Cluster{
+			Name:              name,
+			Version:           spec.Cluster.Version,
+			PublicAccessCidrs: publicAccessCidrs,
+		},
+		NodeGroup: aws.NodeGroup{
+			Name:          "default-pool",
+			InstanceTypes: []string{spec.Cluster.MachineType},
+			DiskSize:      spec.Cluster.DiskSize,
+			MinSize:       spec.Cluster.MinNodeCount,
+			MaxSize:       spec.Cluster.MaxNodeCount,
+		},
+		SecurityGroups: []aws.SecurityGroup{
+			{
+				Name:         fmt.Sprintf("%s-intra-cluster-egress", name),
+				Description:  "Allow the cluster to reach everything in the VPC",
+				Controlplane: name,
+				Egress: []aws.Rule{
+					{
+						Protocol: "-1",
+						Cidrs:    []string{vpcCidr},
+					},
+				},
+			},
+			{
+				Name:         fmt.Sprintf("%s-webhooks", name),
+				Description:  "Allow the controlplane to call admission webhooks",
+				Controlplane: name,
+				Ingress: []aws.Rule{
+					{
+						Protocol: "tcp",
+						Port:     8443,
+						Cidrs:    []string{vpcCidr},
+					},
+					{
+						Protocol: "tcp",
+						Port:     9443,
+						Cidrs:    []string{vpcCidr},
+					},
+					{
+						Protocol: "tcp",
+						Port:     15017,
+						Cidrs:    []string{vpcCidr},
+					},
+				},
+			},
+		},
+	}
+	return &cp, nil
+}
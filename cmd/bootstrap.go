@@ -0,0 +1,78 @@
+/*
+Package cmd is the entrypoint the for cli
+*/
+package cmd
+
+import (
+	"context"
+	"log"
+	"os"
+	"tidalwave/internal/addons"
+	"tidalwave/internal/bootstrap"
+	"tidalwave/internal/config"
+	"tidalwave/internal/kube"
+	"tidalwave/internal/tidalwave"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// bootstrapCmd represents the bootstrap command
+var bootstrapCmd = &cobra.Command{
+	Use:   "bootstrap",
+	Short: "Install Argo CD and the addons into the DevOps controlplane cluster",
+	Long: `Install Argo CD into the argocd namespace of the cluster, create the cluster-addons
+AppProject and apply the kustomizations of spec.bootstrap.kustomizations, then wait for
+their Applications to be Synced and Healthy. controlplane create does this once the cluster
+exists unless spec.bootstrap.enabled is false, run it again to retry or to pick up changes
+to the manifests. Everything is applied with server-side apply so reruns are safe.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		cfg, err := loadConfig()
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := runBootstrap(cmd.Context(), cfg); err != nil {
+			fatal(cmd.Context(), err)
+		}
+	},
+}
+
+// runBootstrap installs Argo CD and the kustomizations of cfg into the cluster
+func runBootstrap(ctx context.Context, cfg *config.Config) error {
+	b := cfg.Spec.Bootstrap
+	c, err := newControlplane(ctx, cfg)
+	if err != nil {
+		return err
+	}
+	creds, err := c.Credentials(ctx, false)
+	closeControlplane(c)
+	if err != nil {
+		return err
+	}
+	server, err := clusterServer(creds, b.PrivateEndpoint, "spec.bootstrap.privateEndpoint")
+	if err != nil {
+		return err
+	}
+	client, err := kube.New(server, creds)
+	if err != nil {
+		return err
+	}
+	// validated when the config was loaded
+	timeout, _ := time.ParseDuration(b.Timeout)
+	manifests := addons.FS(cfg.Spec.Addons)
+	if b.Manifests != "" {
+		manifests = os.DirFS(b.Manifests)
+	}
+	tidalwave.Infof(ctx, ":octopus:", "Bootstrap Argo CD %s into %s", b.ArgoCD.Version, server)
+	return bootstrap.Run(ctx, client, bootstrap.Options{
+		Manifest:       b.ArgoCD.Manifest,
+		Manifests:      manifests,
+		Kustomizations: b.Kustomizations,
+		Timeout:        timeout,
+		Parallelism:    cfg.Spec.Parallelism,
+	})
+}
+
+func init() {
+	controlplaneCmd.AddCommand(bootstrapCmd)
+}
@@ -0,0 +1,230 @@
+/*
+Package cmd is the entrypoint the for cli
+*/
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"tidalwave/internal/config"
+	"tidalwave/internal/tidalwave"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// configCmd represents the config command
+var configCmd = &cobra.Command{
+	Use:   "config",
+	Short: "Check the tidalwave config file",
+	Long:  "Check the tidalwave config file",
+}
+
+// configValidateCmd represents the config validate command
+var configValidateCmd = &cobra.Command{
+	Use:   "validate",
+	Short: "Validate the config file without calling any cloud API",
+	Long: `Decode the config file strictly, rejecting unknown fields, and check the values
+make sense: CIDR blocks parse and do not overlap, node counts, region and name.
+Every problem is printed with the line it is on.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		path, err := configFile()
+		if err != nil {
+			log.Fatal(err)
+		}
+		if _, err := config.Load(path, environment); err != nil {
+			var errs config.Errors
+			if !errors.As(err, &errs) {
+				log.Fatal(err)
+			}
+			for _, e := range errs {
+				location := path
+				if e.Line != 0 {
+					location = fmt.Sprintf("%s:%d", path, e.Line)
+				}
+				tidalwave.Emit(cmd.Context(), tidalwave.Event{
+					Action:  "validate",
+					Phase:   tidalwave.PhaseFailed,
+					Emoji:   ":cross_mark:",
+					Message: fmt.Sprintf("%s: %s: %s", location, e.Field, e.Message),
+					Error:   e.Message,
+				})
+			}
+			os.Exit(1)
+		}
+		tidalwave.Infof(cmd.Context(), ":check_mark_button:", "%s is valid", path)
+	},
+}
+
+// configSchemaCmd represents the config schema command
+var configSchemaCmd = &cobra.Command{
+	Use:   "schema",
+	Short: "Print the JSON Schema of the config file",
+	Long: `Print the JSON Schema (draft 2020-12) of the config file, generated from the
+config types, for editor autocompletion and linting.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		schema, err := config.Schema()
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Print(string(schema))
+	},
+}
+
+// configRenderCmd represents the config render command
+var configRenderCmd = &cobra.Command{
+	Use:   "render",
+	Short: "Print the effective config",
+	Long: `Print the config every other command uses, the overlay of --env merged over the base
+config and defaults filled in.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		cfg, err := loadConfig()
+		if err != nil {
+			log.Fatal(err)
+		}
+		out, err := cfg.Render()
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Print(string(out))
+	},
+}
+
+// configMigrateCmd represents the config migrate command
+var configMigrateCmd = &cobra.Command{
+	Use:   "migrate",
+	Short: "Upgrade the config file to the current apiVersion",
+	Long: `Rewrite the config file for the current apiVersion, moving fields whose place changed
+between versions. Comments are kept. Older files are also migrated in memory every time
+they are loaded, this only makes it permanent.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		path, err := configFile()
+		if err != nil {
+			log.Fatal(err)
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			log.Fatal(err)
+		}
+		out, from, err := config.Migrate(data)
+		if err != nil {
+			log.Fatalf("%s: %s", path, err)
+		}
+		if dryRun, _ := cmd.Flags().GetBool("dry-run"); dryRun {
+			fmt.Print(string(out))
+			return
+		}
+		if from == config.APIVersion {
+			tidalwave.Infof(cmd.Context(), ":check_mark_button:", "%s is already %s", path, config.APIVersion)
+			return
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := os.WriteFile(path, out, info.Mode()); err != nil {
+			log.Fatal(err)
+		}
+		tidalwave.Infof(cmd.Context(), ":check_mark_button:", "%s migrated from %s to %s", path, apiVersionName(from), config.APIVersion)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(configCmd)
+	configCmd.AddCommand(configValidateCmd)
+	configCmd.AddCommand(configSchemaCmd)
+	configCmd.AddCommand(configMigrateCmd)
+	configCmd.AddCommand(configRenderCmd)
+	configMigrateCmd.Flags().Bool("dry-run", false, "print the migrated config instead of writing it")
+}
+
+// apiVersionName names the apiVersion of a config file, which older files do not have
+func apiVersionName(apiVersion string) string {
+	if apiVersion == "" {
+		return "no apiVersion"
+	}
+	return apiVersion
+}
+
+// configFile returns the path of the config file in use
+func configFile() (string, error) {
+	path := viper.ConfigFileUsed()
+	if path == "" {
+		return "", fmt.Errorf("no config file found, pass --config or create $HOME/.tidalwave.yaml")
+	}
+	return path, nil
+}
+
+// envOverrides are the config fields that can be set with environment variables
+var envOverrides = map[string]func(cfg *config.Config, value string) error{
+	"TIDALWAVE_GOOGLE_COMPUTE_ENDPOINT": func(cfg *config.Config, value string) error {
+		cfg.Spec.Google.Endpoints.Compute = value
+		return nil
+	},
+	"TIDALWAVE_GOOGLE_CONTAINER_ENDPOINT": func(cfg *config.Config, value string) error {
+		cfg.Spec.Google.Endpoints.Container = value
+		return nil
+	},
+	"TIDALWAVE_GOOGLE_IAM_ENDPOINT": func(cfg *config.Config, value string) error {
+		cfg.Spec.Google.Endpoints.IAM = value
+		return nil
+	},
+	"TIDALWAVE_GOOGLE_KMS_ENDPOINT": func(cfg *config.Config, value string) error {
+		cfg.Spec.Google.Endpoints.KMS = value
+		return nil
+	},
+	"TIDALWAVE_GOOGLE_RESOURCEMANAGER_ENDPOINT": func(cfg *config.Config, value string) error {
+		cfg.Spec.Google.Endpoints.ResourceManager = value
+		return nil
+	},
+	"TIDALWAVE_GOOGLE_SERVICEUSAGE_ENDPOINT": func(cfg *config.Config, value string) error {
+		cfg.Spec.Google.Endpoints.ServiceUsage = value
+		return nil
+	},
+	"TIDALWAVE_GOOGLE_ENDPOINTS_INSECURE": func(cfg *config.Config, value string) (err error) {
+		cfg.Spec.Google.Endpoints.Insecure, err = strconv.ParseBool(value)
+		return err
+	},
+	"TIDALWAVE_AWS_ENDPOINT": func(cfg *config.Config, value string) error {
+		cfg.Spec.AWS.Endpoint = value
+		return nil
+	},
+}
+
+// loadConfig reads the config file in use and applies the flag and environment overrides
+func loadConfig() (*config.Config, error) {
+	path, err := configFile()
+	if err != nil {
+		return nil, err
+	}
+	cfg, err := config.Load(path, environment)
+	if err != nil {
+		return nil, err
+	}
+	if err := applyOverrides(path, cfg); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// applyOverrides applies the flag and environment overrides to cfg, read from path, and warns if
+// it was migrated from an older apiVersion
+func applyOverrides(path string, cfg *config.Config) error {
+	if from, ok := cfg.MigratedFrom(); ok {
+		warnf("%s is written for %s, run tidalwave config migrate to upgrade it to %s", path, apiVersionName(from), config.APIVersion)
+	}
+	for env, set := range envOverrides {
+		if value, ok := os.LookupEnv(env); ok {
+			if err := set(cfg, value); err != nil {
+				return fmt.Errorf("%s: %w", env, err)
+			}
+		}
+	}
+	if p := viper.GetInt("spec.parallelism"); p != 0 {
+		cfg.Spec.Parallelism = p
+	}
+	return nil
+}
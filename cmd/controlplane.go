@@ -6,6 +6,7 @@ package cmd
 
 import (
 	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
 )
 
 // controlplaneCmd represents the controlplane command
@@ -23,6 +24,8 @@ func init() {
 	// Cobra supports Persistent Flags which will work for this command
 	// and all subcommands, e.g.:
 	// controlplaneCmd.PersistentFlags().String("foo", "", "A help for foo")
+	controlplaneCmd.PersistentFlags().Int("parallelism", 0, "number of resources to provision at once (default is spec.parallelism or 4)")
+	cobra.CheckErr(viper.BindPFlag("spec.parallelism", controlplaneCmd.PersistentFlags().Lookup("parallelism")))
 
 	// Cobra supports local flags which will only run when this command
 	// is called directly, e.g.:
@@ -0,0 +1,143 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"tidalwave/internal/google/emulator"
+
+	computepb "cloud.google.com/go/compute/apiv1/computepb"
+	containerpb "cloud.google.com/go/container/apiv1/containerpb"
+)
+
+// run runs the cli with args against config. Flags keep their value between runs so the
+// output flags and the log set up for json are reset first.
+func run(t *testing.T, config string, args ...string) {
+	t.Helper()
+	output, noEmoji, skipBootstrap = "text", false, false
+	upgradeTo, upgradeDryRun, upgradeList, upgradePause = "", false, false, false
+	log.SetFlags(log.LstdFlags)
+	log.SetOutput(os.Stderr)
+	rootCmd.SetArgs(append(args, "--config", config))
+	if err := rootCmd.ExecuteContext(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestControlplaneEndToEnd(t *testing.T) {
+	e, err := emulator.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer e.Close()
+	e.AddProject("project", 123)
+
+	dir := t.TempDir()
+	endpoints := e.Endpoints()
+	config := filepath.Join(dir, "tidalwave.yaml")
+	err = os.WriteFile(config, []byte(fmt.Sprintf(`metadata:
+  name: e2e
+spec:
+  projectID: project
+  state:
+    path: %s
+  google:
+    endpoints:
+      compute: %s
+      container: %s
+      iam: %s
+      kms: %s
+      resourcemanager: %s
+      serviceusage: %s
+      insecure: true
+`, filepath.Join(dir, "state.json"), endpoints.Compute, endpoints.Container, endpoints.IAM, endpoints.KMS, endpoints.ResourceManager, endpoints.ServiceUsage)), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	cluster := &containerpb.GetClusterRequest{Name: "projects/project/locations/us-central1/clusters/e2e"}
+	webhooks := &computepb.GetFirewallRequest{Project: "project", Firewall: "e2e-webhooks"}
+
+	run(t, config, "controlplane", "create", "--skip-bootstrap")
+	if got := len(e.EnabledServices("projects/123")); got == 0 {
+		t.Error("no services enabled")
+	}
+	if _, err := e.Networks.Get(ctx, &computepb.GetNetworkRequest{Project: "project", Network: "e2e"}); err != nil {
+		t.Errorf("vpc not created: %s", err)
+	}
+	c, err := e.Container.GetCluster(ctx, cluster)
+	if err != nil {
+		t.Fatalf("cluster not created: %s", err)
+	}
+	if c.GetDatabaseEncryption().GetKeyName() != "projects/project/locations/us-central1/keyRings/e2e/cryptoKeys/e2e" {
+		t.Errorf("cluster encrypted with %q", c.GetDatabaseEncryption().GetKeyName())
+	}
+
+	run(t, config, "controlplane", "status", "-o", "json")
+
+	kubeconfig := filepath.Join(dir, "kubeconfig")
+	run(t, config, "controlplane", "kubeconfig", "--kubeconfig", kubeconfig, "--private")
+	data, err := os.ReadFile(kubeconfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"server: https://10.0.0.2", "current-context: e2e", "command: gke-gcloud-auth-plugin"} {
+		if !strings.Contains(string(data), want) {
+			t.Errorf("kubeconfig is missing %q:\n%s", want, data)
+		}
+	}
+
+	e.Firewalls.Modify("e2e-webhooks", func(f *computepb.Firewall) {
+		f.SourceRanges = []string{"0.0.0.0/0"}
+	})
+	run(t, config, "controlplane", "update")
+	rule, err := e.Firewalls.Get(ctx, webhooks)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := rule.GetSourceRanges(); len(got) != 1 || got[0] != "172.16.0.0/28" {
+		t.Errorf("webhooks firewall source ranges are %v after update, want [172.16.0.0/28]", got)
+	}
+
+	run(t, config, "controlplane", "upgrade", "--list")
+	run(t, config, "controlplane", "upgrade", "--dry-run", "--to", "latest")
+	if c, _ := e.Container.GetCluster(ctx, cluster); c.GetCurrentMasterVersion() != "1.24.5-gke.600" {
+		t.Errorf("dry run upgraded the master to %s", c.GetCurrentMasterVersion())
+	}
+	run(t, config, "controlplane", "upgrade", "--to", "latest", "--pause")
+	c, err = e.Container.GetCluster(ctx, cluster)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, pool := c.GetCurrentMasterVersion(), c.GetNodePools()[0].GetVersion(); got != "1.25.2-gke.1700" || pool != "1.24.5-gke.600" {
+		t.Errorf("paused upgrade left the master on %s and the nodes on %s, want only the master upgraded", got, pool)
+	}
+	run(t, config, "controlplane", "upgrade", "--to", "latest")
+	c, err = e.Container.GetCluster(ctx, cluster)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := c.GetCurrentMasterVersion(); got != "1.25.2-gke.1700" {
+		t.Errorf("master runs %s after upgrade, want 1.25.2-gke.1700", got)
+	}
+	for _, p := range c.GetNodePools() {
+		if p.GetVersion() != "1.25.2-gke.1700" {
+			t.Errorf("node pool %s runs %s after upgrade", p.GetName(), p.GetVersion())
+		}
+	}
+
+	run(t, config, "controlplane", "delete")
+	if _, err := e.Container.GetCluster(ctx, cluster); err == nil {
+		t.Error("cluster not deleted")
+	}
+	if _, err := e.Firewalls.Get(ctx, webhooks); err == nil {
+		t.Error("webhooks firewall not deleted")
+	}
+	if _, err := e.Networks.Get(ctx, &computepb.GetNetworkRequest{Project: "project", Network: "e2e"}); err == nil {
+		t.Error("vpc not deleted")
+	}
+}
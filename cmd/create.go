@@ -5,37 +5,41 @@ Copyright © 2022 NAME HERE <EMAIL ADDRESS>
 package cmd
 
 import (
-	"fmt"
 	"log"
 	"tidalwave/internal/tidalwave"
 
-	"github.com/kyokomi/emoji/v2"
 	"github.com/spf13/cobra"
-	"github.com/spf13/viper"
 )
 
+// skipBootstrap creates the cluster without installing Argo CD and the addons
+var skipBootstrap bool
+
 // createCmd represents the create command
 var createCmd = &cobra.Command{
 	Use:   "create",
 	Short: "Create a DevOps controlplane cluster",
-	Long:  "Create a DevOps controlplane cluster",
+	Long: `Create a DevOps controlplane cluster and its dependencies, then bootstrap Argo CD and
+the addons into it unless spec.bootstrap.enabled is false or --skip-bootstrap is set.`,
 	Run: func(cmd *cobra.Command, args []string) {
-		switch viper.Get("spec.provider") {
-		case "google":
-			emoji.Println(":joystick: Create Google Controlplane")
-			c, err := CreateGoogleControlplane()
-			if err != nil {
-				log.Fatal(err)
-			}
-			if err := tidalwave.CheckApis(c); err != nil {
-				log.Fatal(err)
-			}
-			err = tidalwave.CreateCluster(c)
-			if err != nil {
-				log.Fatal(err)
+		cfg, err := loadConfig()
+		if err != nil {
+			log.Fatal(err)
+		}
+		tidalwave.Infof(cmd.Context(), ":joystick:", "Create %s Controlplane", cfg.Spec.Provider)
+		err = withControlplane(cmd.Context(), cfg, false, func(c tidalwave.Controlplane) error {
+			if err := tidalwave.CheckApis(cmd.Context(), c); err != nil {
+				return err
 			}
-		case "aws":
-			fmt.Println("Configure AWS controlplane")
+			return tidalwave.CreateCluster(cmd.Context(), c)
+		})
+		if err != nil {
+			fatal(cmd.Context(), err)
+		}
+		if !cfg.Spec.Bootstrap.Enabled || skipBootstrap {
+			return
+		}
+		if err := runBootstrap(cmd.Context(), cfg); err != nil {
+			fatal(cmd.Context(), err)
 		}
 	},
 }
@@ -52,4 +56,5 @@ func init() {
 	// Cobra supports local flags which will only run when this command
 	// is called directly, e.g.:
 	// createCmd.Flags().BoolP("toggle", "t", false, "Help message for toggle")
+	createCmd.Flags().BoolVar(&skipBootstrap, "skip-bootstrap", false, "create the cluster without installing Argo CD and the addons")
 }
@@ -5,34 +5,30 @@ Copyright © 2022 NAME HERE <EMAIL ADDRESS>
 package cmd
 
 import (
-	"fmt"
 	"log"
 	"tidalwave/internal/tidalwave"
 
-	"github.com/kyokomi/emoji/v2"
 	"github.com/spf13/cobra"
-	"github.com/spf13/viper"
 )
 
+var force bool
+
 // deleteCmd represents the delete command
 var deleteCmd = &cobra.Command{
 	Use:   "delete",
 	Short: "Delete a DevOps controlplane cluster",
 	Long:  "Delete a DevOps controlplane cluster",
 	Run: func(cmd *cobra.Command, args []string) {
-		switch viper.Get("spec.provider") {
-		case "google":
-			emoji.Println(":joystick: Delete Google Controlplane")
-			c, err := CreateGoogleControlplane()
-			if err != nil {
-				log.Fatal(err)
-			}
-			err = tidalwave.DeleteCluster(c)
-			if err != nil {
-				log.Fatal(err)
-			}
-		case "aws":
-			fmt.Println("Configure AWS controlplane")
+		cfg, err := loadConfig()
+		if err != nil {
+			log.Fatal(err)
+		}
+		tidalwave.Infof(cmd.Context(), ":joystick:", "Delete %s Controlplane", cfg.Spec.Provider)
+		err = withControlplane(cmd.Context(), cfg, force, func(c tidalwave.Controlplane) error {
+			return tidalwave.DeleteCluster(cmd.Context(), c)
+		})
+		if err != nil {
+			fatal(cmd.Context(), err)
 		}
 	},
 }
@@ -49,4 +45,5 @@ func init() {
 	// Cobra supports local flags which will only run when this command
 	// is called directly, e.g.:
 	// deleteCmd.Flags().BoolP("toggle", "t", false, "Help message for toggle")
+	deleteCmd.Flags().BoolVar(&force, "force", false, "delete resources even if they are not recorded in state")
 }
@@ -0,0 +1,255 @@
+/*
+Package cmd is the entrypoint the for cli
+*/
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"tidalwave/internal/config"
+	"tidalwave/internal/tidalwave"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// fleetConcurrency is how many controlplanes of the fleet are worked on at once
+var fleetConcurrency int
+
+// fleetCmd represents the fleet command
+var fleetCmd = &cobra.Command{
+	Use:   "fleet",
+	Short: "Create/Update/Delete many DevOps controlplanes at once",
+	Long: `Run a controlplane command against every controlplane of a fleet. --config is a
+ControlplaneList, a config file or a directory of either. Every config is validated
+before anything is changed, a table of results is printed at the end and the command
+fails if any controlplane failed.`,
+}
+
+// fleetResult is the outcome of a command for one controlplane of the fleet
+type fleetResult struct {
+	cfg      *config.Config
+	summary  string
+	err      error
+	duration time.Duration
+}
+
+// loadFleet reads the fleet at the config path and applies the flag and environment overrides
+// to every controlplane
+func loadFleet() ([]*config.Config, error) {
+	path, err := configFile()
+	if err != nil {
+		return nil, err
+	}
+	cfgs, err := config.LoadFleet(path, environment)
+	if err != nil {
+		return nil, err
+	}
+	for _, cfg := range cfgs {
+		if err := applyOverrides(fmt.Sprintf("%s (%s)", path, cfg.Metadata.Name), cfg); err != nil {
+			return nil, err
+		}
+	}
+	return cfgs, nil
+}
+
+// runFleet runs fn for every controlplane, at most concurrency at once, fn returns a short
+// summary of what it did. The results are in the order of cfgs.
+func runFleet(ctx context.Context, cfgs []*config.Config, concurrency int, fn func(ctx context.Context, cfg *config.Config) (string, error)) []fleetResult {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	results := make([]fleetResult, len(cfgs))
+	sem := make(chan struct{}, concurrency)
+	wg := sync.WaitGroup{}
+	for i, cfg := range cfgs {
+		wg.Add(1)
+		go func(i int, cfg *config.Config) {
+			defer wg.Done()
+			results[i].cfg = cfg
+			select {
+			case sem <- struct{}{}:
+			case <-ctx.Done():
+				results[i].err = ctx.Err()
+				return
+			}
+			defer func() { <-sem }()
+			start := time.Now()
+			results[i].summary, results[i].err = fn(tidalwave.WithControlplane(ctx, cfg.Metadata.Name), cfg)
+			results[i].duration = time.Since(start)
+		}(i, cfg)
+	}
+	wg.Wait()
+	return results
+}
+
+// printFleet prints a row for every controlplane and exits with an error if any failed. With
+// --output json every row is a done or failed event of its controlplane instead.
+func printFleet(ctx context.Context, action string, results []fleetResult) {
+	failed := 0
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "\nCONTROLPLANE\tPROVIDER\tREGION\tDURATION\tRESULT")
+	for _, r := range results {
+		e := tidalwave.Event{Controlplane: r.cfg.Metadata.Name, Action: action, Phase: tidalwave.PhaseDone, Duration: r.duration, Message: r.summary}
+		result := r.summary
+		if r.err != nil {
+			failed++
+			result = "failed: " + strings.ReplaceAll(r.err.Error(), "\n", "; ")
+			e.Phase, e.Message, e.Error = tidalwave.PhaseFailed, "", r.err.Error()
+		}
+		if output == "json" {
+			tidalwave.Emit(ctx, e)
+			continue
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", r.cfg.Metadata.Name, r.cfg.Spec.Provider, r.cfg.Spec.Region, r.duration.Round(time.Second), result)
+	}
+	if output != "json" {
+		w.Flush()
+	}
+	if failed > 0 {
+		fatal(ctx, fmt.Errorf("%d of %d controlplanes failed", failed, len(results)))
+	}
+	tidalwave.Infof(ctx, ":check_mark_button:", "%d controlplanes done", len(results))
+}
+
+// fleetRun returns the Run of a fleet command that runs fn for every controlplane
+func fleetRun(action string, fn func(ctx context.Context, cfg *config.Config) (string, error)) func(cmd *cobra.Command, args []string) {
+	return func(cmd *cobra.Command, args []string) {
+		cfgs, err := loadFleet()
+		if err != nil {
+			log.Fatal(err)
+		}
+		tidalwave.Infof(cmd.Context(), ":joystick:", "%s %d Controlplanes, %d at once", action, len(cfgs), fleetConcurrency)
+		printFleet(cmd.Context(), strings.ToLower(strings.Fields(action)[0]), runFleet(cmd.Context(), cfgs, fleetConcurrency, fn))
+	}
+}
+
+// fleetCreateCmd represents the fleet create command
+var fleetCreateCmd = &cobra.Command{
+	Use:   "create",
+	Short: "Create every DevOps controlplane of the fleet",
+	Long:  "Create every DevOps controlplane of the fleet",
+	Run: fleetRun("Create", func(ctx context.Context, cfg *config.Config) (string, error) {
+		err := withControlplane(ctx, cfg, false, func(c tidalwave.Controlplane) error {
+			if err := tidalwave.CheckApis(ctx, c); err != nil {
+				return err
+			}
+			return tidalwave.CreateCluster(ctx, c)
+		})
+		if err != nil || !cfg.Spec.Bootstrap.Enabled {
+			return "created", err
+		}
+		return "created and bootstrapped", runBootstrap(ctx, cfg)
+	}),
+}
+
+// fleetUpdateCmd represents the fleet update command
+var fleetUpdateCmd = &cobra.Command{
+	Use:   "update",
+	Short: "Update every DevOps controlplane of the fleet",
+	Long:  "Update every DevOps controlplane of the fleet",
+	Run: fleetRun("Update", func(ctx context.Context, cfg *config.Config) (string, error) {
+		return "updated", withControlplane(ctx, cfg, false, func(c tidalwave.Controlplane) error {
+			return tidalwave.UpdateCluster(ctx, c)
+		})
+	}),
+}
+
+// fleetForce deletes resources of the fleet that are not recorded in state
+var fleetForce bool
+
+// fleetDeleteCmd represents the fleet delete command
+var fleetDeleteCmd = &cobra.Command{
+	Use:   "delete",
+	Short: "Delete every DevOps controlplane of the fleet",
+	Long:  "Delete every DevOps controlplane of the fleet",
+	Run: fleetRun("Delete", func(ctx context.Context, cfg *config.Config) (string, error) {
+		return "deleted", withControlplane(ctx, cfg, fleetForce, func(c tidalwave.Controlplane) error {
+			return tidalwave.DeleteCluster(ctx, c)
+		})
+	}),
+}
+
+// fleetPlanCmd represents the fleet plan command
+var fleetPlanCmd = &cobra.Command{
+	Use:   "plan",
+	Short: "Show the changes create/update would make to every DevOps controlplane of the fleet",
+	Long: `Plan every controlplane of the fleet, print each plan in turn and a summary per
+controlplane. Nothing is changed.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		cfgs, err := loadFleet()
+		if err != nil {
+			log.Fatal(err)
+		}
+		tidalwave.Infof(cmd.Context(), ":joystick:", "Plan %d Controlplanes, %d at once", len(cfgs), fleetConcurrency)
+		plans := make(map[string][]tidalwave.ResourcePlan, len(cfgs))
+		mu := sync.Mutex{}
+		results := runFleet(cmd.Context(), cfgs, fleetConcurrency, func(ctx context.Context, cfg *config.Config) (string, error) {
+			c, err := newControlplane(ctx, cfg)
+			if err != nil {
+				return "", err
+			}
+			defer closeControlplane(c)
+			plan, err := tidalwave.PlanCluster(ctx, c)
+			if err != nil {
+				return "", err
+			}
+			mu.Lock()
+			plans[cfg.Metadata.Name] = plan
+			mu.Unlock()
+			return tidalwave.PlanSummary(plan), nil
+		})
+		for _, r := range results {
+			if plan, ok := plans[r.cfg.Metadata.Name]; ok {
+				ctx := tidalwave.WithControlplane(cmd.Context(), r.cfg.Metadata.Name)
+				tidalwave.Infof(ctx, ":joystick:", "Plan")
+				tidalwave.PrintPlan(ctx, plan)
+			}
+		}
+		printFleet(cmd.Context(), "plan", results)
+	},
+}
+
+// fleetStatusCmd represents the fleet status command
+var fleetStatusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "Show whether every DevOps controlplane of the fleet exists and matches its config",
+	Long:  "Show whether every DevOps controlplane of the fleet exists and matches its config",
+	Run: fleetRun("Status of", func(ctx context.Context, cfg *config.Config) (string, error) {
+		c, err := newControlplane(ctx, cfg)
+		if err != nil {
+			return "", err
+		}
+		defer closeControlplane(c)
+		status, err := c.Status(ctx)
+		if err != nil {
+			return "", err
+		}
+		missing, drifted := 0, 0
+		for _, s := range status {
+			switch {
+			case !s.Exists:
+				missing++
+			case !s.InSync:
+				drifted++
+			}
+		}
+		return fmt.Sprintf("%d resources, %d missing, %d drifted", len(status), missing, drifted), nil
+	}),
+}
+
+func init() {
+	rootCmd.AddCommand(fleetCmd)
+	fleetCmd.AddCommand(fleetCreateCmd)
+	fleetCmd.AddCommand(fleetUpdateCmd)
+	fleetCmd.AddCommand(fleetDeleteCmd)
+	fleetCmd.AddCommand(fleetPlanCmd)
+	fleetCmd.AddCommand(fleetStatusCmd)
+	fleetCmd.PersistentFlags().IntVar(&fleetConcurrency, "concurrency", 4, "number of controlplanes worked on at once")
+	fleetDeleteCmd.Flags().BoolVar(&fleetForce, "force", false, "delete resources even if they are not recorded in state")
+}
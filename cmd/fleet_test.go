@@ -0,0 +1,108 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"tidalwave/internal/config"
+	"tidalwave/internal/google/emulator"
+	"tidalwave/internal/tidalwave"
+
+	containerpb "cloud.google.com/go/container/apiv1/containerpb"
+)
+
+func TestFleetEndToEnd(t *testing.T) {
+	e, err := emulator.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer e.Close()
+	e.AddProject("project", 123)
+
+	dir := t.TempDir()
+	endpoints := e.Endpoints()
+	config := filepath.Join(dir, "fleet.yaml")
+	err = os.WriteFile(config, []byte(fmt.Sprintf(`apiVersion: tidalwave.io/v1alpha1
+kind: ControlplaneList
+template:
+  spec:
+    projectID: project
+    bootstrap:
+      enabled: false
+    google:
+      endpoints:
+        compute: %s
+        container: %s
+        iam: %s
+        kms: %s
+        resourcemanager: %s
+        serviceusage: %s
+        insecure: true
+items:
+  - metadata:
+      name: east
+    spec:
+      region: us-east1
+      state:
+        path: %s
+  - metadata:
+      name: west
+    spec:
+      region: us-west1
+      state:
+        path: %s
+`, endpoints.Compute, endpoints.Container, endpoints.IAM, endpoints.KMS, endpoints.ResourceManager, endpoints.ServiceUsage,
+		filepath.Join(dir, "east.json"), filepath.Join(dir, "west.json"))), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	clusters := map[string]*containerpb.GetClusterRequest{
+		"east": {Name: "projects/project/locations/us-east1/clusters/east"},
+		"west": {Name: "projects/project/locations/us-west1/clusters/west"},
+	}
+
+	run(t, config, "fleet", "create", "--concurrency", "2")
+	for name, req := range clusters {
+		if _, err := e.Container.GetCluster(ctx, req); err != nil {
+			t.Errorf("cluster %s not created: %s", name, err)
+		}
+	}
+	run(t, config, "fleet", "status", "--output", "json")
+
+	run(t, config, "fleet", "delete")
+	for name, req := range clusters {
+		if _, err := e.Container.GetCluster(ctx, req); err == nil {
+			t.Errorf("cluster %s not deleted", name)
+		}
+	}
+}
+
+// TestProviderFactoryIsPerConfig checks spec.plugins of one config of a fleet does not replace
+// the provider of another
+func TestProviderFactoryIsPerConfig(t *testing.T) {
+	ctx := context.Background()
+	plugged := &config.Config{Spec: config.Spec{Provider: "google", Plugins: map[string]string{"google": "/does/not/exist"}}}
+	factory, err := providerFactory(plugged)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := factory(ctx, plugged); err == nil || !strings.Contains(err.Error(), "/does/not/exist") {
+		t.Errorf("got %v, want the plugin to be started", err)
+	}
+	builtin, _ := tidalwave.Lookup("google")
+	factory, err = providerFactory(&config.Config{Spec: config.Spec{Provider: "google"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reflect.ValueOf(factory).Pointer() != reflect.ValueOf(builtin).Pointer() {
+		t.Error("config without spec.plugins did not get the built in google provider")
+	}
+	if _, err := providerFactory(&config.Config{Spec: config.Spec{Provider: "nope"}}); err == nil || !strings.Contains(err.Error(), "google") {
+		t.Errorf("got %v, want the list of providers", err)
+	}
+}
@@ -4,65 +4,87 @@ Package cmd is the entrypoint the for cli
 package cmd
 
 import (
+	"context"
 	"fmt"
-	"log"
+	"strings"
+	"tidalwave/internal/config"
 	"tidalwave/internal/google"
+	"tidalwave/internal/tidalwave"
+	"time"
 
-	"github.com/kyokomi/emoji/v2"
-	"github.com/spf13/viper"
-	computepb "google.golang.org/genproto/googleapis/cloud/compute/v1"
-	containerpb "google.golang.org/genproto/googleapis/container/v1"
+	computepb "cloud.google.com/go/compute/apiv1/computepb"
+	containerpb "cloud.google.com/go/container/apiv1/containerpb"
 )
 
-func googleDefaults() {
-	viper.SetDefault("spec.region", "us-central1")
-	viper.SetDefault("spec.cidrs.nodes", "10.0.0.0/24")
-	viper.SetDefault("spec.cidrs.pods", "10.1.0.0/16")
-	viper.SetDefault("spec.cidrs.services", "10.2.0.0/20")
-	viper.SetDefault("spec.cluster.machineType", "n2-standard-4")
-	viper.SetDefault("spec.cluster.minNodeCount", 1)
-	viper.SetDefault("spec.cluster.maxNodeCount", 3)
-	viper.SetDefault("spec.cluster.masterAuthBlock", []map[string]string{
-		{
-			"displayName": "public",
-			"cidrBlock":   "0.0.0.0/0",
-		},
+func init() {
+	tidalwave.Register("google", func(ctx context.Context, cfg *config.Config) (tidalwave.Controlplane, error) {
+		return CreateGoogleControlplane(ctx, cfg)
 	})
-	viper.SetDefault("spec.cluster.masterCidrBlock", "172.16.0.0/28")
 }
 
-// CreateGoogleControlplane creates google.Controlplane from options form the config file
-func CreateGoogleControlplane() (*google.Controlplane, error) {
-	googleDefaults()
-	name := viper.GetString("metadata.name")
-	if name == "" {
-		log.Fatalln("metadata.name cannot be nil")
-	}
-	projectID := viper.GetString("spec.projectID")
-	if projectID == "" {
-		log.Fatalln("spec.projectID cannot be nil")
+// googleEndpoints returns the API endpoint overrides from the config
+func googleEndpoints(cfg *config.Config) google.Endpoints {
+	e := cfg.Spec.Google.Endpoints
+	return google.Endpoints{
+		Compute:         e.Compute,
+		Container:       e.Container,
+		IAM:             e.IAM,
+		KMS:             e.KMS,
+		ResourceManager: e.ResourceManager,
+		ServiceUsage:    e.ServiceUsage,
+		Insecure:        e.Insecure,
 	}
-	projectNumber, err := google.GetProjectNumber(projectID)
+}
+
+// CreateGoogleControlplane creates google.Controlplane from a validated config
+func CreateGoogleControlplane(ctx context.Context, cfg *config.Config) (*google.Controlplane, error) {
+	spec := cfg.Spec
+	name := cfg.Metadata.Name
+	projectID := spec.ProjectID
+	endpoints := googleEndpoints(cfg)
+	projectNumber, err := google.GetProjectNumber(ctx, projectID, endpoints)
 	if err != nil {
-		log.Fatalf("project-id %s not found: %s\n", projectID, err)
+		return nil, fmt.Errorf("project-id %s not found: %w", projectID, err)
+	}
+	tidalwave.Infof(ctx, ":bullseye:", "Project Id: %s", projectID)
+	tidalwave.Infof(ctx, ":bullseye:", "Project Number: %s", *projectNumber)
+	region := spec.Region
+	nodesCidr := spec.Cidrs.Nodes
+	podCidr := spec.Cidrs.Pods
+	serviceCidr := spec.Cidrs.Services
+	autopilot := spec.Cluster.Mode == "autopilot"
+	// Autopilot nodes are not tagged with a pool name, so the firewall rules apply to every
+	// instance of the VPC, which only holds the cluster
+	var nodePools []google.NodePool
+	var poolNames []string
+	if !autopilot {
+		nodePools = googleNodePools(spec.Cluster)
+		for _, p := range nodePools {
+			poolNames = append(poolNames, p.Name)
+		}
 	}
-	emoji.Printf(":bullseye: Project Id: %s\n", projectID)
-	emoji.Printf(":bullseye: Project Number: %s\n", *projectNumber)
-	region := viper.GetString("spec.region")
-	nodesCidr := viper.GetString("spec.cidrs.nodes")
-	podCidr := viper.GetString("spec.cidrs.pods")
-	serviceCidr := viper.GetString("spec.cidrs.services")
-	machineType := viper.GetString("spec.cluster.machineType")
-	diskSize := viper.GetInt32("spec.cluster.diskSize")
-	minNodes := viper.GetInt32("spec.cluster.minNodeCount")
-	maxNodes := viper.GetInt32("spec.cluster.maxNodeCount")
 	masterAuthCidrBlocks := []*containerpb.MasterAuthorizedNetworksConfig_CidrBlock{}
-	if err := viper.UnmarshalKey("spec.cluster.masterAuthBlock", &masterAuthCidrBlocks); err != nil {
-		return nil, err
+	for _, b := range spec.Cluster.MasterAuthBlock {
+		masterAuthCidrBlocks = append(masterAuthCidrBlocks, &containerpb.MasterAuthorizedNetworksConfig_CidrBlock{
+			DisplayName: b.DisplayName,
+			CidrBlock:   b.CidrBlock,
+		})
+	}
+	masterIpv4CidrBlock := spec.Cluster.MasterCidrBlock
+	releaseChannel := containerpb.ReleaseChannel_Channel_value[strings.ToUpper(spec.Cluster.ReleaseChannel)]
+	datapath := containerpb.DatapathProvider_LEGACY_DATAPATH
+	if spec.Cluster.Datapath == "advanced" {
+		datapath = containerpb.DatapathProvider_ADVANCED_DATAPATH
+	}
+	timeouts := map[string]time.Duration{}
+	for kind := range config.Timeouts("google") {
+		timeouts[kind] = spec.Timeout(kind)
 	}
-	masterIpv4CidrBlock := viper.GetString("spec.cluster.masterCidrBlock")
 	cp := google.Controlplane{
-		Apis: google.RequiredApis.Services,
+		Apis:        google.RequiredApis.Services,
+		Parallelism: spec.Parallelism,
+		Timeouts:    timeouts,
+		Endpoints:   endpoints,
 		Vpc: google.Vpc{
 			Name:      name,
 			ProjectID: projectID,
@@ -96,12 +118,35 @@ func CreateGoogleControlplane() (*google.Controlplane, error) {
 			Region:               region,
 			Network:              name,
 			Subnetwork:           name,
-			MachineType:          machineType,
-			DiskSizeGb:           diskSize,
-			MinNodeCount:         minNodes,
-			MaxNodeCount:         maxNodes,
 			MasterAuthCidrBlocks: masterAuthCidrBlocks,
 			MasterIpv4CidrBlock:  masterIpv4CidrBlock,
+			Version:              spec.Cluster.Version,
+			ReleaseChannel:       containerpb.ReleaseChannel_Channel(releaseChannel),
+			Datapath:             datapath,
+			NodePools:            nodePools,
+			Autopilot:            autopilot,
+			Addons: google.Addons{
+				HttpLoadBalancing:          spec.Cluster.Addons.HTTPLoadBalancing,
+				HorizontalPodAutoscaling:   spec.Cluster.Addons.HorizontalPodAutoscaling,
+				ConfigConnector:            spec.Cluster.Addons.ConfigConnector,
+				GcePersistentDiskCsiDriver: spec.Cluster.Addons.GcePersistentDiskCsiDriver,
+				GcpFilestoreCsiDriver:      spec.Cluster.Addons.GcpFilestoreCsiDriver,
+			},
+			BinaryAuthorization:   spec.Cluster.BinaryAuthorization,
+			MaintenancePolicy:     googleMaintenancePolicy(spec.Cluster.Maintenance),
+			LoggingComponents:     loggingComponents(spec.Cluster.Logging),
+			MonitoringComponents:  monitoringComponents(spec.Cluster.Monitoring),
+			ImageStreaming:        spec.Cluster.ImageStreaming,
+			WorkloadMetadata:      workloadMetadataModes[spec.Cluster.WorkloadMetadata],
+			CostAllocation:        spec.Cluster.CostAllocation,
+			GatewayAPI:            gatewayAPIChannels[spec.Cluster.GatewayAPI],
+			SecurityPosture:       securityPostureModes[spec.Cluster.SecurityPosture],
+			VulnerabilityScanning: vulnerabilityModes[spec.Cluster.VulnerabilityScanning],
+		},
+		ServiceAccount: google.ServiceAccount{
+			Name:      google.ServiceAccountID(name),
+			ProjectID: projectID,
+			Email:     spec.Cluster.ServiceAccount,
 		},
 		Firewalls: []google.Firewall{
 			{
@@ -133,9 +178,7 @@ func CreateGoogleControlplane() (*google.Controlplane, error) {
 					nodesCidr,
 					podCidr,
 				},
-				TargetTags: []string{
-					"default-pool",
-				},
+				TargetTags: poolNames,
 			},
 			{
 				Name:      fmt.Sprintf("%s-webhooks", name),
@@ -154,11 +197,138 @@ func CreateGoogleControlplane() (*google.Controlplane, error) {
 				SourceRanges: []string{
 					masterIpv4CidrBlock,
 				},
-				TargetTags: []string{
-					"default-pool",
-				},
+				TargetTags: poolNames,
 			},
 		},
 	}
 	return &cp, nil
 }
+
+// taintEffects maps the taint effects of the config to GKE
+var taintEffects = map[string]containerpb.NodeTaint_Effect{
+	"NoSchedule":       containerpb.NodeTaint_NO_SCHEDULE,
+	"PreferNoSchedule": containerpb.NodeTaint_PREFER_NO_SCHEDULE,
+	"NoExecute":        containerpb.NodeTaint_NO_EXECUTE,
+}
+
+// googleNodePools returns the default node pool built from spec.cluster followed by the pools
+// of spec.cluster.nodePools, the firewall rules target the nodes of all of them
+func googleNodePools(cluster config.Cluster) []google.NodePool {
+	pools := []google.NodePool{
+		{
+			Name:           google.DefaultPool,
+			MachineType:    cluster.MachineType,
+			DiskSizeGb:     cluster.DiskSize,
+			MinNodeCount:   cluster.MinNodeCount,
+			MaxNodeCount:   cluster.MaxNodeCount,
+			MaxSurge:       cluster.MaxSurge,
+			MaxUnavailable: cluster.MaxUnavailable,
+		},
+	}
+	for _, p := range cluster.NodePools {
+		taints := []*containerpb.NodeTaint{}
+		for _, t := range p.Taints {
+			taints = append(taints, &containerpb.NodeTaint{Key: t.Key, Value: t.Value, Effect: taintEffects[t.Effect]})
+		}
+		pools = append(pools, google.NodePool{
+			Name:           p.Name,
+			MachineType:    p.MachineType,
+			DiskType:       p.DiskType,
+			DiskSizeGb:     p.DiskSize,
+			MinNodeCount:   p.MinNodeCount,
+			MaxNodeCount:   p.MaxNodeCount,
+			Spot:           p.Spot,
+			Preemptible:    p.Preemptible,
+			Labels:         p.Labels,
+			Taints:         taints,
+			Tags:           p.Tags,
+			ServiceAccount: p.ServiceAccount,
+			MaxSurge:       p.MaxSurge,
+			MaxUnavailable: p.MaxUnavailable,
+		})
+	}
+	return pools
+}
+
+// workloadMetadataModes maps the metadata servers of the config to GKE
+var workloadMetadataModes = map[string]containerpb.WorkloadMetadataConfig_Mode{
+	"gke-metadata": containerpb.WorkloadMetadataConfig_GKE_METADATA,
+	"gce-metadata": containerpb.WorkloadMetadataConfig_GCE_METADATA,
+}
+
+// gatewayAPIChannels maps the Gateway API channels of the config to GKE
+var gatewayAPIChannels = map[string]containerpb.GatewayAPIConfig_Channel{
+	"disabled":     containerpb.GatewayAPIConfig_CHANNEL_DISABLED,
+	"standard":     containerpb.GatewayAPIConfig_CHANNEL_STANDARD,
+	"experimental": containerpb.GatewayAPIConfig_CHANNEL_EXPERIMENTAL,
+}
+
+// securityPostureModes maps the security posture modes of the config to GKE
+var securityPostureModes = map[string]containerpb.SecurityPostureConfig_Mode{
+	"disabled": containerpb.SecurityPostureConfig_DISABLED,
+	"basic":    containerpb.SecurityPostureConfig_BASIC,
+}
+
+// vulnerabilityModes maps vulnerability scanning being on or off to GKE
+var vulnerabilityModes = map[bool]containerpb.SecurityPostureConfig_VulnerabilityMode{
+	false: containerpb.SecurityPostureConfig_VULNERABILITY_DISABLED,
+	true:  containerpb.SecurityPostureConfig_VULNERABILITY_BASIC,
+}
+
+// exclusionScopes maps the maintenance exclusion scopes of the config to GKE
+var exclusionScopes = map[string]containerpb.MaintenanceExclusionOptions_Scope{
+	"no-upgrades":               containerpb.MaintenanceExclusionOptions_NO_UPGRADES,
+	"no-minor-upgrades":         containerpb.MaintenanceExclusionOptions_NO_MINOR_UPGRADES,
+	"no-minor-or-node-upgrades": containerpb.MaintenanceExclusionOptions_NO_MINOR_OR_NODE_UPGRADES,
+}
+
+// googleMaintenancePolicy returns the maintenance window and exclusions of a validated config
+func googleMaintenancePolicy(m config.Maintenance) *containerpb.MaintenancePolicy {
+	policy := google.DailyMaintenanceWindow(m.DailyStartTime)
+	if m.Recurrence != "" {
+		start, _ := time.Parse(time.RFC3339, m.Start)
+		end, _ := time.Parse(time.RFC3339, m.End)
+		policy = google.RecurringMaintenanceWindow(start, end, m.Recurrence)
+	}
+	if len(m.Exclusions) > 0 {
+		policy.Window.MaintenanceExclusions = map[string]*containerpb.TimeWindow{}
+	}
+	for _, e := range m.Exclusions {
+		start, _ := time.Parse(time.RFC3339, e.Start)
+		end, _ := time.Parse(time.RFC3339, e.End)
+		policy.Window.MaintenanceExclusions[e.Name] = google.MaintenanceExclusion(start, end, exclusionScopes[e.Scope])
+	}
+	return policy
+}
+
+// loggingComponents maps the logging components of the config to GKE
+func loggingComponents(names []string) []containerpb.LoggingComponentConfig_Component {
+	components := []containerpb.LoggingComponentConfig_Component{}
+	for _, name := range names {
+		switch name {
+		case "system":
+			components = append(components, containerpb.LoggingComponentConfig_SYSTEM_COMPONENTS)
+		case "workloads":
+			components = append(components, containerpb.LoggingComponentConfig_WORKLOADS)
+		}
+	}
+	return components
+}
+
+// monitoringComponents maps the monitoring components of the config to GKE
+func monitoringComponents(names []string) []containerpb.MonitoringComponentConfig_Component {
+	components := []containerpb.MonitoringComponentConfig_Component{}
+	for _, name := range names {
+		switch name {
+		case "system":
+			components = append(components, containerpb.MonitoringComponentConfig_SYSTEM_COMPONENTS)
+		case "apiserver":
+			components = append(components, containerpb.MonitoringComponentConfig_APISERVER)
+		case "scheduler":
+			components = append(components, containerpb.MonitoringComponentConfig_SCHEDULER)
+		case "controller-manager":
+			components = append(components, containerpb.MonitoringComponentConfig_CONTROLLER_MANAGER)
+		}
+	}
+	return components
+}
@@ -0,0 +1,116 @@
+/*
+Package cmd is the entrypoint the for cli
+*/
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"tidalwave/internal/kubeconfig"
+	"tidalwave/internal/tidalwave"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	// kubeconfigPath is the kubeconfig the context is merged into
+	kubeconfigPath string
+	// kubeconfigContext names the cluster, user and context, metadata.name if empty
+	kubeconfigContext string
+	// kubeconfigPrivate points the context at the private endpoint of the cluster
+	kubeconfigPrivate bool
+	// kubeconfigToken writes a short-lived token instead of the credential plugin
+	kubeconfigToken bool
+)
+
+// kubeconfigCmd represents the kubeconfig command
+var kubeconfigCmd = &cobra.Command{
+	Use:   "kubeconfig",
+	Short: "Add a context for the DevOps controlplane cluster to a kubeconfig",
+	Long: `Read the endpoint and CA certificate of the cluster and merge a cluster, user and
+context into the kubeconfig, replacing any with the same name, and make it the current
+context. The user runs the provider's credential plugin (gke-gcloud-auth-plugin or
+aws eks get-token) unless --token asks for a short-lived token from the ambient cloud
+credentials instead. The public endpoint is used unless --private is set.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		cfg, err := loadConfig()
+		if err != nil {
+			log.Fatal(err)
+		}
+		c, err := newControlplane(cmd.Context(), cfg)
+		if err != nil {
+			log.Fatal(err)
+		}
+		creds, err := c.Credentials(cmd.Context(), kubeconfigToken)
+		closeControlplane(c)
+		if err != nil {
+			log.Fatal(err)
+		}
+		server, err := clusterServer(creds, kubeconfigPrivate, "--private")
+		if err != nil {
+			log.Fatal(err)
+		}
+		path := kubeconfigPath
+		if path == "" {
+			if path, err = kubeconfig.Path(); err != nil {
+				log.Fatal(err)
+			}
+		}
+		name := kubeconfigContext
+		if name == "" {
+			name = cfg.Metadata.Name
+		}
+		cluster := kubeconfig.Cluster{Server: server, CertificateAuthorityData: creds.CertificateAuthority}
+		if err := kubeconfig.Write(path, name, cluster, kubeconfigUser(creds)); err != nil {
+			log.Fatal(err)
+		}
+		tidalwave.Infof(cmd.Context(), ":key:", "Context %s for %s added to %s", name, server, path)
+	},
+}
+
+// clusterServer returns the URL of the API server, the private endpoint if private is set.
+// option is how the user asks for the private endpoint.
+func clusterServer(creds *tidalwave.ClusterCredentials, private bool, option string) (string, error) {
+	endpoint := creds.Endpoint
+	switch {
+	case private && creds.PrivateEndpoint == "":
+		return "", fmt.Errorf("the cluster has no private endpoint")
+	case private:
+		endpoint = creds.PrivateEndpoint
+	case endpoint == "":
+		return "", fmt.Errorf("the cluster has no public endpoint, use %s from inside the VPC", option)
+	}
+	if !strings.Contains(endpoint, "://") {
+		endpoint = "https://" + endpoint
+	}
+	return endpoint, nil
+}
+
+// kubeconfigUser returns the user for the credentials, the token if there is one
+func kubeconfigUser(creds *tidalwave.ClusterCredentials) kubeconfig.User {
+	if creds.Token != "" || creds.Exec == nil {
+		return kubeconfig.User{Token: creds.Token}
+	}
+	exec := &kubeconfig.Exec{
+		APIVersion:         creds.Exec.APIVersion,
+		Command:            creds.Exec.Command,
+		Args:               creds.Exec.Args,
+		InstallHint:        creds.Exec.InstallHint,
+		ProvideClusterInfo: true,
+	}
+	for name, value := range creds.Exec.Env {
+		exec.Env = append(exec.Env, kubeconfig.ExecEnv{Name: name, Value: value})
+	}
+	sort.Slice(exec.Env, func(i, j int) bool { return exec.Env[i].Name < exec.Env[j].Name })
+	return kubeconfig.User{Exec: exec}
+}
+
+func init() {
+	controlplaneCmd.AddCommand(kubeconfigCmd)
+	kubeconfigCmd.Flags().StringVar(&kubeconfigPath, "kubeconfig", "", "kubeconfig to merge into (default is the first file of $KUBECONFIG or ~/.kube/config)")
+	kubeconfigCmd.Flags().StringVar(&kubeconfigContext, "context", "", "name of the context, cluster and user (default is metadata.name)")
+	kubeconfigCmd.Flags().BoolVar(&kubeconfigPrivate, "private", false, "use the private endpoint of the cluster, reachable from inside the VPC")
+	kubeconfigCmd.Flags().BoolVar(&kubeconfigToken, "token", false, "write a short-lived token from the ambient cloud credentials instead of the credential plugin")
+}
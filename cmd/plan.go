@@ -0,0 +1,41 @@
+/*
+Package cmd is the entrypoint the for cli
+*/
+package cmd
+
+import (
+	"log"
+	"tidalwave/internal/tidalwave"
+
+	"github.com/spf13/cobra"
+)
+
+// planCmd represents the plan command
+var planCmd = &cobra.Command{
+	Use:   "plan",
+	Short: "Show the changes create/update would make to a DevOps controlplane",
+	Long: `Compare the config with the live DevOps controlplane and show, for every
+resource, whether it would be created, updated in place, replaced or left
+alone. Nothing is changed.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		cfg, err := loadConfig()
+		if err != nil {
+			log.Fatal(err)
+		}
+		tidalwave.Infof(cmd.Context(), ":joystick:", "Plan %s Controlplane", cfg.Spec.Provider)
+		c, err := newControlplane(cmd.Context(), cfg)
+		if err != nil {
+			log.Fatal(err)
+		}
+		plan, err := tidalwave.PlanCluster(cmd.Context(), c)
+		closeControlplane(c)
+		if err != nil {
+			log.Fatal(err)
+		}
+		tidalwave.PrintPlan(cmd.Context(), plan)
+	},
+}
+
+func init() {
+	controlplaneCmd.AddCommand(planCmd)
+}
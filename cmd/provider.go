@@ -0,0 +1,82 @@
+/*
+Package cmd is the entrypoint the for cli
+*/
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"tidalwave/internal/config"
+	"tidalwave/internal/plugin"
+	"tidalwave/internal/state"
+	"tidalwave/internal/tidalwave"
+)
+
+// providerFactory returns the factory for spec.provider. A plugin listed in spec.plugins wins,
+// then a built in provider, then a plugin in ~/.tidalwave/plugins or on the PATH. The lookup is
+// local to cfg so the configs of a fleet never see each other's plugins.
+func providerFactory(cfg *config.Config) (tidalwave.Factory, error) {
+	provider := cfg.Spec.Provider
+	if path, ok := cfg.Spec.Plugins[provider]; ok {
+		return plugin.Factory(path), nil
+	}
+	if factory, ok := tidalwave.Lookup(provider); ok {
+		return factory, nil
+	}
+	dirs := []string{}
+	if home, err := os.UserHomeDir(); err == nil {
+		dirs = append(dirs, filepath.Join(home, ".tidalwave", "plugins"))
+	}
+	dirs = append(dirs, filepath.SplitList(os.Getenv("PATH"))...)
+	discovered := plugin.Discover(dirs...)
+	if path, ok := discovered[provider]; ok {
+		return plugin.Factory(path), nil
+	}
+	names := tidalwave.Providers()
+	for name := range discovered {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return nil, fmt.Errorf("unknown provider %q, expected one of %s", provider, strings.Join(names, ", "))
+}
+
+// newControlplane builds the controlplane for spec.provider, it must be closed with
+// closeControlplane
+func newControlplane(ctx context.Context, cfg *config.Config) (tidalwave.Controlplane, error) {
+	factory, err := providerFactory(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return factory(ctx, cfg)
+}
+
+// closeControlplane stops the plugin process behind c, if there is one
+func closeControlplane(c tidalwave.Controlplane) {
+	if closer, ok := c.(io.Closer); ok {
+		closer.Close()
+	}
+}
+
+// withControlplane runs fn with the controlplane for spec.provider, the state is locked and
+// handed to the controlplane while fn runs if the provider keeps state
+func withControlplane(ctx context.Context, cfg *config.Config, force bool, fn func(c tidalwave.Controlplane) error) error {
+	c, err := newControlplane(ctx, cfg)
+	if err != nil {
+		return err
+	}
+	defer closeControlplane(c)
+	stateful, ok := c.(tidalwave.Stateful)
+	if !ok {
+		return fn(c)
+	}
+	var store *state.Store
+	return withState(ctx, cfg, &store, func() error {
+		stateful.SetState(store, force)
+		return fn(c)
+	})
+}
@@ -5,8 +5,15 @@ Copyright © 2022 NAME HERE <EMAIL ADDRESS>
 package cmd
 
 import (
+	"context"
 	"fmt"
+	"io"
+	"log"
 	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"tidalwave/internal/tidalwave"
 
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
@@ -14,6 +21,23 @@ import (
 
 var cfgFile string
 
+// environment is the overlay of the config file merged over the base, none if empty
+var environment string
+
+// operations records the cloud operations started by the running command
+var operations *tidalwave.Operations
+
+// output is how events are written, text or json. Commands that print a document, such as
+// controlplane status, print it as a table for text and also take yaml.
+var output string
+
+// noEmoji writes text events as plain lines for log collectors
+var noEmoji bool
+
+// diagnostics renders warnings and errors on stderr, so they stay out of documents a command
+// prints on stdout
+var diagnostics = tidalwave.NewBus(&tidalwave.EmojiRenderer{W: os.Stderr})
+
 // Version is the version of the cli
 var Version string
 
@@ -28,20 +52,85 @@ var rootCmd = &cobra.Command{
 // Execute adds all child commands to the root command and sets flags appropriately.
 // This is called by main.main(). It only needs to happen once to the rootCmd.
 func Execute() {
-	err := rootCmd.Execute()
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	ctx, operations = tidalwave.WithOperations(ctx)
+	err := rootCmd.ExecuteContext(ctx)
 	if err != nil {
+		stop()
 		os.Exit(1)
 	}
 }
 
+// fatal exits with err, listing the operations that are still running if the command was
+// interrupted so they can be followed up in the cloud console
+func fatal(ctx context.Context, err error) {
+	if ctx.Err() != nil && operations != nil {
+		tidalwave.Infof(ctx, ":stop_sign:", "Interrupted, tidalwave stopped waiting on these operations")
+		for _, op := range operations.Running() {
+			tidalwave.Emit(ctx, tidalwave.Event{Phase: tidalwave.PhaseWarning, Operation: op.ID, Emoji: ":hourglass_not_done:", Message: fmt.Sprintf("%s (%s)", op.ID, op.Description)})
+		}
+	}
+	log.Fatal(err)
+}
+
+// renderer returns the renderer for the --output and --no-emoji flags writing to w
+func renderer(w io.Writer) (tidalwave.Renderer, error) {
+	switch {
+	case output == "json":
+		return &tidalwave.JSONRenderer{W: w}, nil
+	case output != "text" && output != "yaml":
+		return nil, fmt.Errorf("unknown output %q, expected text, json or yaml", output)
+	case noEmoji:
+		return &tidalwave.PlainRenderer{W: w}, nil
+	}
+	return &tidalwave.EmojiRenderer{W: w}, nil
+}
+
+// initOutput renders events the way the flags ask for, on stdout. In json mode errors passed to log are
+// written as failed events too.
+func initOutput() {
+	r, err := renderer(os.Stdout)
+	cobra.CheckErr(err)
+	tidalwave.SetRenderer(r)
+	r, err = renderer(os.Stderr)
+	cobra.CheckErr(err)
+	diagnostics.SetRenderer(r)
+	if output == "json" {
+		log.SetFlags(0)
+		log.SetOutput(logWriter{})
+	}
+}
+
+// logWriter emits every log line as a failed event on stderr
+type logWriter struct{}
+
+func (logWriter) Write(p []byte) (int, error) {
+	diagnostics.Emit(tidalwave.Event{Phase: tidalwave.PhaseFailed, Error: strings.TrimSpace(string(p))})
+	return len(p), nil
+}
+
+// infof emits a message on stderr
+func infof(alias, format string, a ...interface{}) {
+	diagnostics.Emit(tidalwave.Event{Phase: tidalwave.PhaseInfo, Emoji: alias, Message: fmt.Sprintf(format, a...)})
+}
+
+// warnf emits a warning on stderr
+func warnf(format string, a ...interface{}) {
+	diagnostics.Emit(tidalwave.Event{Phase: tidalwave.PhaseWarning, Emoji: ":warning:", Message: fmt.Sprintf(format, a...)})
+}
+
 func init() {
-	cobra.OnInitialize(initConfig)
+	cobra.OnInitialize(initOutput, initConfig)
 
 	// Here you will define your flags and configuration settings.
 	// Cobra supports persistent flags, which, if defined here,
 	// will be global for your application.
 
 	rootCmd.PersistentFlags().StringVar(&cfgFile, "config", "", "config file (default is $HOME/.tidalwave.yaml)")
+	rootCmd.PersistentFlags().StringVar(&environment, "env", "", "environment of the config file to merge over the base config")
+	rootCmd.PersistentFlags().StringVarP(&output, "output", "o", "text", "text, or json for newline delimited events, controlplane status and upgrade --list also take yaml")
+	rootCmd.PersistentFlags().BoolVar(&noEmoji, "no-emoji", false, "write text events as plain lines with timestamps, for log collectors")
 
 	// Cobra also supports local flags, which will only run
 	// when this action is called directly.
@@ -68,7 +157,6 @@ func initConfig() {
 
 	// If a config file is found, read it in.
 	if err := viper.ReadInConfig(); err == nil {
-		fmt.Fprintln(os.Stderr, "Using config file:", viper.ConfigFileUsed())
+		infof(":page_facing_up:", "Using config file %s", viper.ConfigFileUsed())
 	}
-	viper.SetDefault("spec.provider", "google")
 }
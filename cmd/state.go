@@ -0,0 +1,216 @@
+/*
+Package cmd is the entrypoint the for cli
+*/
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"tidalwave/internal/config"
+	"tidalwave/internal/state"
+	"tidalwave/internal/tidalwave"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// stateCmd represents the state command
+var stateCmd = &cobra.Command{
+	Use:   "state",
+	Short: "Inspect and edit the resources tidalwave created",
+	Long: `Inspect and edit the resources tidalwave created.
+
+State is stored in a local JSON file by default or in a GCS object when
+spec.state.backend is gcs.`,
+}
+
+// stateListCmd represents the state list command
+var stateListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List the resources in state",
+	Long:  "List the resources in state",
+	Run: func(cmd *cobra.Command, args []string) {
+		cfg, err := loadConfig()
+		if err != nil {
+			log.Fatal(err)
+		}
+		s, err := readState(cmd.Context(), cfg)
+		if err != nil {
+			log.Fatal(err)
+		}
+		sort.Slice(s.Resources, func(i, j int) bool {
+			return s.Resources[i].ID() < s.Resources[j].ID()
+		})
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "RESOURCE\tCREATED\tSELF LINK")
+		for _, r := range s.Resources {
+			fmt.Fprintf(w, "%s\t%s\t%s\n", r.ID(), r.Created.Format(time.RFC3339), r.SelfLink)
+		}
+		w.Flush()
+	},
+}
+
+// stateShowCmd represents the state show command
+var stateShowCmd = &cobra.Command{
+	Use:   "show <kind>/<name>",
+	Short: "Show a resource in state",
+	Long:  "Show a resource in state",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		cfg, err := loadConfig()
+		if err != nil {
+			log.Fatal(err)
+		}
+		s, err := readState(cmd.Context(), cfg)
+		if err != nil {
+			log.Fatal(err)
+		}
+		for _, r := range s.Resources {
+			if r.ID() == args[0] {
+				b, err := json.MarshalIndent(r, "", "  ")
+				if err != nil {
+					log.Fatal(err)
+				}
+				fmt.Println(string(b))
+				return
+			}
+		}
+		log.Fatalf("%s is not in the state\n", args[0])
+	},
+}
+
+// stateRmCmd represents the state rm command
+var stateRmCmd = &cobra.Command{
+	Use:   "rm <kind>/<name>",
+	Short: "Remove a resource from state without deleting it",
+	Long: `Remove a resource from state without deleting it. tidalwave will no longer
+delete the resource.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		kind, name, ok := strings.Cut(args[0], "/")
+		if !ok {
+			log.Fatalf("%s is not in the form <kind>/<name>\n", args[0])
+		}
+		cfg, err := loadConfig()
+		if err != nil {
+			log.Fatal(err)
+		}
+		ctx := cmd.Context()
+		s, err := openState(ctx, cfg)
+		if err != nil {
+			log.Fatal(err)
+		}
+		err = s.Remove(ctx, kind, name)
+		if cerr := s.Close(ctx); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+		tidalwave.Infof(ctx, ":wastebasket:", "Removed %s from state", args[0])
+	},
+}
+
+// stateUnlockCmd represents the state unlock command
+var stateUnlockCmd = &cobra.Command{
+	Use:   "unlock",
+	Short: "Release a state lock left behind by an interrupted run",
+	Long:  "Release a state lock left behind by an interrupted run",
+	Run: func(cmd *cobra.Command, args []string) {
+		cfg, err := loadConfig()
+		if err != nil {
+			log.Fatal(err)
+		}
+		backend, err := newStateBackend(cfg)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := backend.Unlock(cmd.Context()); err != nil {
+			log.Fatal(err)
+		}
+		tidalwave.Infof(cmd.Context(), ":unlocked:", "State unlocked")
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(stateCmd)
+	stateCmd.AddCommand(stateListCmd)
+	stateCmd.AddCommand(stateShowCmd)
+	stateCmd.AddCommand(stateRmCmd)
+	stateCmd.AddCommand(stateUnlockCmd)
+}
+
+// newStateBackend creates the state backend from options in the config file
+func newStateBackend(cfg *config.Config) (state.Backend, error) {
+	name := cfg.Metadata.Name
+	st := cfg.Spec.State
+	switch st.Backend {
+	case "", "local":
+		path := st.Path
+		if path == "" {
+			home, err := os.UserHomeDir()
+			if err != nil {
+				return nil, err
+			}
+			path = filepath.Join(home, ".tidalwave", "state", fmt.Sprintf("%s.json", name))
+		}
+		return &state.LocalBackend{Path: path}, nil
+	case "gcs":
+		object := st.Object
+		if object == "" {
+			object = fmt.Sprintf("tidalwave/%s.json", name)
+		}
+		return &state.GCSBackend{Bucket: st.Bucket, Object: object}, nil
+	default:
+		return nil, fmt.Errorf("unknown state backend %s, expected local or gcs", st.Backend)
+	}
+}
+
+// openState locks and reads the state for the controlplane in the config file
+func openState(ctx context.Context, cfg *config.Config) (*state.Store, error) {
+	backend, err := newStateBackend(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return state.Open(ctx, backend, cfg.Metadata.Name)
+}
+
+// readState reads the state without locking it
+func readState(ctx context.Context, cfg *config.Config) (*state.State, error) {
+	backend, err := newStateBackend(cfg)
+	if err != nil {
+		return nil, err
+	}
+	s, err := backend.Read(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if s == nil {
+		return &state.State{}, nil
+	}
+	return s, nil
+}
+
+// withState runs fn with the controlplane state locked and stored in store, the lock is
+// released even if fn fails or ctx is cancelled
+func withState(ctx context.Context, cfg *config.Config, store **state.Store, fn func() error) error {
+	s, err := openState(ctx, cfg)
+	if err != nil {
+		return err
+	}
+	*store = s
+	err = fn()
+	closeCtx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	if cerr := s.Close(closeCtx); err == nil {
+		err = cerr
+	}
+	return err
+}
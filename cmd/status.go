@@ -0,0 +1,71 @@
+/*
+Package cmd is the entrypoint the for cli
+*/
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"tidalwave/internal/tidalwave"
+
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+)
+
+// statusCmd represents the status command
+var statusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "Show whether every resource of a DevOps controlplane exists and matches the config",
+	Long: `Fetch every resource of the DevOps controlplane and show whether it exists, whether
+it matches the config and its key attributes, such as the cluster version and
+conditions, node pool sizes or the state of the primary crypto key version. Nothing
+is changed.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		cfg, err := loadConfig()
+		if err != nil {
+			log.Fatal(err)
+		}
+		c, err := newControlplane(cmd.Context(), cfg)
+		if err != nil {
+			log.Fatal(err)
+		}
+		status, err := c.Status(cmd.Context())
+		closeControlplane(c)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if output == "text" {
+			err = tidalwave.PrintStatus(os.Stdout, status)
+		} else {
+			err = printStructured(os.Stdout, output, status)
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+	},
+}
+
+func init() {
+	controlplaneCmd.AddCommand(statusCmd)
+}
+
+// printStructured writes v to w as json or yaml
+func printStructured(w io.Writer, format string, v interface{}) error {
+	switch format {
+	case "json":
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(v)
+	case "yaml":
+		enc := yaml.NewEncoder(w)
+		enc.SetIndent(2)
+		if err := enc.Encode(v); err != nil {
+			return err
+		}
+		return enc.Close()
+	}
+	return fmt.Errorf("unknown output format %q", format)
+}
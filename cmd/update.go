@@ -5,13 +5,10 @@ Copyright © 2022 NAME HERE <EMAIL ADDRESS>
 package cmd
 
 import (
-	"fmt"
 	"log"
 	"tidalwave/internal/tidalwave"
 
-	"github.com/kyokomi/emoji/v2"
 	"github.com/spf13/cobra"
-	"github.com/spf13/viper"
 )
 
 // updateCmd represents the update command
@@ -20,19 +17,16 @@ var updateCmd = &cobra.Command{
 	Short: "Update a DevOps controlplane cluster",
 	Long:  "Update a DevOps controlplane cluster",
 	Run: func(cmd *cobra.Command, args []string) {
-		switch viper.Get("spec.provider") {
-		case "google":
-			emoji.Println(":joystick: Update Google Controlplane")
-			c, err := CreateGoogleControlplane()
-			if err != nil {
-				log.Fatal(err)
-			}
-			err = tidalwave.UpdateCluster(c)
-			if err != nil {
-				log.Fatal(err)
-			}
-		case "aws":
-			fmt.Println("Configure AWS controlplane")
+		cfg, err := loadConfig()
+		if err != nil {
+			log.Fatal(err)
+		}
+		tidalwave.Infof(cmd.Context(), ":joystick:", "Update %s Controlplane", cfg.Spec.Provider)
+		err = withControlplane(cmd.Context(), cfg, false, func(c tidalwave.Controlplane) error {
+			return tidalwave.UpdateCluster(cmd.Context(), c)
+		})
+		if err != nil {
+			fatal(cmd.Context(), err)
 		}
 	},
 }
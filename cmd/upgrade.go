@@ -0,0 +1,123 @@
+/*
+Package cmd is the entrypoint the for cli
+*/
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"tidalwave/internal/tidalwave"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	// upgradeTo is the version to upgrade to, spec.cluster.version if empty
+	upgradeTo string
+	// upgradeDryRun lists the steps of the upgrade without running them
+	upgradeDryRun bool
+	// upgradeList prints the versions the cluster runs and can be upgraded to
+	upgradeList bool
+	// upgradePause stops the upgrade after each step
+	upgradePause bool
+)
+
+// upgradeCmd represents the upgrade command
+var upgradeCmd = &cobra.Command{
+	Use:   "upgrade",
+	Short: "Upgrade the master and then the node pools of a DevOps controlplane cluster",
+	Long: `Upgrade the cluster master to --to, or spec.cluster.version, and then each node pool
+in the order of the config with its surge settings, waiting on every operation. A version
+such as 1.24 upgrades to its newest release, latest to the newest version. The upgrade stops
+at the first step that fails, steps already at the version are skipped so running it again
+carries on from there. --pause stops after each step so the workloads can be checked before
+the next one, run upgrade again to carry on. --dry-run lists the steps, --list the versions
+the cluster runs and the versions it can be upgraded to.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		cfg, err := loadConfig()
+		if err != nil {
+			log.Fatal(err)
+		}
+		version := upgradeTo
+		if version == "" {
+			version = cfg.Spec.Cluster.Version
+		}
+		if version == "" && !upgradeList {
+			log.Fatal("upgrade: pass --to or set spec.cluster.version")
+		}
+		if upgradeList || upgradeDryRun {
+			c, err := newControlplane(cmd.Context(), cfg)
+			if err != nil {
+				log.Fatal(err)
+			}
+			err = previewUpgrade(cmd, c, version)
+			closeControlplane(c)
+			if err != nil {
+				log.Fatal(err)
+			}
+			return
+		}
+		tidalwave.Infof(cmd.Context(), ":joystick:", "Upgrade %s Controlplane to %s", cfg.Spec.Provider, version)
+		err = withControlplane(cmd.Context(), cfg, false, func(c tidalwave.Controlplane) error {
+			u, err := upgrader(c)
+			if err != nil {
+				return err
+			}
+			steps, err := u.Upgrade(cmd.Context(), version, false, upgradePause)
+			for _, s := range steps {
+				tidalwave.Infof(cmd.Context(), ":check_mark_button:", "%s %s upgraded from %s to %s", s.Kind, s.Name, s.From, s.To)
+			}
+			if err == nil && len(steps) == 0 {
+				tidalwave.Infof(cmd.Context(), ":check_mark_button:", "Nothing to upgrade")
+			}
+			return err
+		})
+		if err != nil {
+			fatal(cmd.Context(), err)
+		}
+	},
+}
+
+// upgrader returns c as a tidalwave.ClusterUpgrader, providers that cannot upgrade clusters
+// return tidalwave.ErrUnsupported
+func upgrader(c tidalwave.Controlplane) (tidalwave.ClusterUpgrader, error) {
+	u, ok := c.(tidalwave.ClusterUpgrader)
+	if !ok {
+		return nil, fmt.Errorf("upgrade: %w", tidalwave.ErrUnsupported)
+	}
+	return u, nil
+}
+
+// previewUpgrade prints the versions of the cluster for --list or the steps an upgrade to
+// version would run for --dry-run, nothing is changed
+func previewUpgrade(cmd *cobra.Command, c tidalwave.Controlplane, version string) error {
+	u, err := upgrader(c)
+	if err != nil {
+		return err
+	}
+	if upgradeList {
+		versions, err := u.Versions(cmd.Context())
+		if err != nil {
+			return err
+		}
+		if output == "text" {
+			return tidalwave.PrintVersions(os.Stdout, versions)
+		}
+		return printStructured(os.Stdout, output, versions)
+	}
+	steps, err := u.Upgrade(cmd.Context(), version, true, false)
+	if err != nil {
+		return err
+	}
+	tidalwave.PrintUpgrade(cmd.Context(), steps)
+	return nil
+}
+
+func init() {
+	controlplaneCmd.AddCommand(upgradeCmd)
+	upgradeCmd.Flags().StringVar(&upgradeTo, "to", "", "version to upgrade to, such as 1.24, 1.24.5-gke.600 or latest (default is spec.cluster.version)")
+	upgradeCmd.Flags().BoolVar(&upgradeDryRun, "dry-run", false, "list the steps of the upgrade without running them")
+	upgradeCmd.Flags().BoolVar(&upgradeList, "list", false, "print the versions the cluster runs and the versions it can be upgraded to")
+	upgradeCmd.Flags().BoolVar(&upgradePause, "pause", false, "stop after each step of the upgrade, run upgrade again to carry on")
+}
@@ -0,0 +1,135 @@
+/*
+Package addons renders the bundled add-on manifests from spec.addons. The templates are
+rendered when they are opened, so the kustomizations can be read straight from FS.
+*/
+package addons
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"text/template"
+	"tidalwave/internal/config"
+	"tidalwave/manifests"
+)
+
+// Kustomizations are the bundled kustomizations, Applications for the cluster Argo CD runs in
+// and ApplicationSets for every cluster registered with it
+var Kustomizations = []string{
+	"argocd-apps/connect",
+	"argocd-apps/security",
+	"argocd-appsets/connect",
+	"argocd-appsets/security",
+}
+
+// data is what the templates are rendered with
+type data struct {
+	Addons config.Addons
+}
+
+// funcs are the functions the templates can call
+var funcs = template.FuncMap{
+	"quote": quote,
+}
+
+// quote returns s as a double quoted YAML string
+func quote(s string) string {
+	b, _ := json.Marshal(s)
+	return string(b)
+}
+
+// FS returns the bundled manifests rendered for addons
+func FS(addons config.Addons) fs.FS {
+	return &renderFS{data: data{Addons: addons}}
+}
+
+// renderFS renders the files of the bundled manifests when they are opened
+type renderFS struct {
+	data data
+}
+
+// Open opens a directory of the bundled manifests or renders one of its files
+func (r *renderFS) Open(name string) (fs.File, error) {
+	f, err := manifests.FS.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	if info.IsDir() {
+		return f, nil
+	}
+	f.Close()
+	out, err := r.render(name)
+	if err != nil {
+		return nil, &fs.PathError{Op: "render", Path: name, Err: err}
+	}
+	return &renderedFile{Reader: bytes.NewReader(out), info: renderedInfo{FileInfo: info, size: int64(len(out))}}, nil
+}
+
+// render executes the template in the file called name
+func (r *renderFS) render(name string) ([]byte, error) {
+	src, err := fs.ReadFile(manifests.FS, name)
+	if err != nil {
+		return nil, err
+	}
+	t, err := template.New(path.Base(name)).Funcs(funcs).Option("missingkey=error").Parse(string(src))
+	if err != nil {
+		return nil, err
+	}
+	out := &bytes.Buffer{}
+	if err := t.Execute(out, r.data); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
+
+// renderedFile is a rendered file of the bundled manifests
+type renderedFile struct {
+	*bytes.Reader
+	info renderedInfo
+}
+
+func (f *renderedFile) Stat() (fs.FileInfo, error) { return f.info, nil }
+func (f *renderedFile) Close() error               { return nil }
+
+// renderedInfo is the FileInfo of a template with the size of its output
+type renderedInfo struct {
+	fs.FileInfo
+	size int64
+}
+
+func (i renderedInfo) Size() int64 { return i.size }
+
+// Write renders the kustomizations into dir, keeping their paths. Files of disabled add-ons
+// render empty and are left out.
+func Write(dir string, addons config.Addons, kustomizations []string) error {
+	fsys := FS(addons)
+	for _, k := range kustomizations {
+		err := fs.WalkDir(fsys, k, func(p string, d fs.DirEntry, err error) error {
+			if err != nil || d.IsDir() {
+				return err
+			}
+			data, err := fs.ReadFile(fsys, p)
+			if err != nil || strings.TrimSpace(string(data)) == "" {
+				return err
+			}
+			file := filepath.Join(dir, filepath.FromSlash(p))
+			if err := os.MkdirAll(filepath.Dir(file), 0o755); err != nil {
+				return err
+			}
+			return os.WriteFile(file, data, 0o644)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
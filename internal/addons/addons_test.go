@@ -0,0 +1,104 @@
+package addons
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"tidalwave/internal/bootstrap"
+	"tidalwave/internal/config"
+	"tidalwave/internal/kube"
+)
+
+// addons returns the add-ons of a config with spec.addons set to spec
+func addons(t *testing.T, spec string) config.Addons {
+	t.Helper()
+	cfg, err := config.Read([]byte("metadata:\n  name: mycluster\nspec:\n  projectID: myproject\n  addons:\n"+spec), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cfg.Spec.Addons
+}
+
+// objects returns the objects of every bundled kustomization by kind/name
+func objects(t *testing.T, a config.Addons) map[string]kube.Object {
+	t.Helper()
+	objs := map[string]kube.Object{}
+	for _, k := range Kustomizations {
+		list, err := bootstrap.Kustomize(FS(a), k)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, obj := range list {
+			if obj.Namespace() != "argocd" {
+				t.Errorf("%s is in namespace %q", obj.Ref(), obj.Namespace())
+			}
+			objs[obj.Ref()] = obj
+		}
+	}
+	return objs
+}
+
+func TestRenderDefaults(t *testing.T) {
+	objs := objects(t, addons(t, "    {}\n"))
+	if len(objs) != 20 {
+		t.Errorf("got %d objects, want 10 Applications and 10 ApplicationSets", len(objs))
+	}
+	falco := objs["application/falco"]
+	if got := falco.GetString("spec", "source", "targetRevision"); got != "2.0.17" {
+		t.Errorf("falco version = %q", got)
+	}
+	params, _ := falco.Get("spec", "source", "helm", "parameters").([]interface{})
+	if len(params) != 2 {
+		t.Errorf("falco parameters = %v", params)
+	}
+	appset := objs["applicationset/falco"]
+	if got := appset.GetString("spec", "template", "metadata", "name"); got != "falco-{{name}}" {
+		t.Errorf("ApplicationSet template name = %q", got)
+	}
+}
+
+func TestRenderConfig(t *testing.T) {
+	objs := objects(t, addons(t, `    istio:
+      version: 1.16.1
+      parameters:
+        pilot.autoscaleMin: "2"
+    falco:
+      enabled: false
+    certManager:
+      namespace: certs
+`))
+	for ref := range objs {
+		if strings.Contains(ref, "falco") {
+			t.Errorf("%s rendered for a disabled add-on", ref)
+		}
+	}
+	istiod := objs["application/istiod"]
+	if got := istiod.GetString("spec", "source", "targetRevision"); got != "1.16.1" {
+		t.Errorf("istiod version = %q", got)
+	}
+	params, _ := istiod.Get("spec", "source", "helm", "parameters").([]interface{})
+	if len(params) != 1 || params[0].(map[string]interface{})["value"] != "2" {
+		t.Errorf("istiod parameters = %v", params)
+	}
+	if got := objs["application/cert-manager"].GetString("spec", "destination", "namespace"); got != "certs" {
+		t.Errorf("cert-manager namespace = %q", got)
+	}
+}
+
+func TestWrite(t *testing.T) {
+	dir := t.TempDir()
+	if err := Write(dir, addons(t, "    falco:\n      enabled: false\n"), []string{"argocd-apps/security"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "argocd-apps", "security", "falco.yaml")); !os.IsNotExist(err) {
+		t.Errorf("falco.yaml was written for a disabled add-on: %v", err)
+	}
+	k, err := os.ReadFile(filepath.Join(dir, "argocd-apps", "security", "kustomization.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(k), "falco") || !strings.Contains(string(k), "- cert-manager.yaml") {
+		t.Errorf("kustomization.yaml:\n%s", k)
+	}
+}
@@ -0,0 +1,86 @@
+/*
+Package awstest provides in-memory fakes of the AWS clients declared in awsapi, so the aws
+package can be tested without a real account
+*/
+package awstest
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"tidalwave/internal/aws/awsapi"
+
+	"github.com/aws/smithy-go"
+)
+
+// Recorder records the methods called on a fake and returns the errors injected with Fail
+type Recorder struct {
+	mu     sync.Mutex
+	calls  []string
+	errors map[string]error
+}
+
+// Calls returns the methods called so far, in order
+func (r *Recorder) Calls() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]string{}, r.calls...)
+}
+
+// Mutations returns the methods called so far that are not reads
+func (r *Recorder) Mutations() []string {
+	mutations := []string{}
+	for _, c := range r.Calls() {
+		if !strings.HasPrefix(c, "Describe") && !strings.HasPrefix(c, "Get") && !strings.HasPrefix(c, "List") {
+			mutations = append(mutations, c)
+		}
+	}
+	return mutations
+}
+
+// Reset forgets the calls recorded so far
+func (r *Recorder) Reset() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.calls = nil
+}
+
+// Fail makes every later call to method return err, a nil err clears it
+func (r *Recorder) Fail(method string, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.errors == nil {
+		r.errors = map[string]error{}
+	}
+	if err == nil {
+		delete(r.errors, method)
+		return
+	}
+	r.errors[method] = err
+}
+
+// call records a method call, it must be called with mu held
+func (r *Recorder) call(method string) error {
+	r.calls = append(r.calls, method)
+	return r.errors[method]
+}
+
+var ids uint64
+
+// nextID returns a unique resource id with an EC2 style prefix
+func nextID(prefix string) string {
+	return fmt.Sprintf("%s-%017x", prefix, atomic.AddUint64(&ids, 1))
+}
+
+// apiError is the error the AWS clients return for a failed request
+func apiError(code, format string, a ...interface{}) error {
+	return &smithy.GenericAPIError{Code: code, Message: fmt.Sprintf(format, a...)}
+}
+
+var (
+	_ awsapi.EC2Client = (*EC2)(nil)
+	_ awsapi.EKSClient = (*EKS)(nil)
+	_ awsapi.KMSClient = (*KMS)(nil)
+	_ awsapi.IAMClient = (*IAM)(nil)
+)
@@ -0,0 +1,819 @@
+package awstest
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+// EC2 is a fake awsapi.EC2Client. Resources are available as soon as they are created and
+// deletes fail with DependencyViolation like EC2 does when something still uses the resource.
+type EC2 struct {
+	Recorder
+	zones     []string
+	vpcs      map[string]types.Vpc
+	subnets   map[string]types.Subnet
+	gateways  map[string]types.InternetGateway
+	tables    map[string]types.RouteTable
+	addresses map[string]types.Address
+	nats      map[string]types.NatGateway
+	groups    map[string]types.SecurityGroup
+}
+
+// NewEC2 returns an empty fake EC2 client for a region with zones, us-east-1a to us-east-1c
+// if none are given
+func NewEC2(zones ...string) *EC2 {
+	if len(zones) == 0 {
+		zones = []string{"us-east-1a", "us-east-1b", "us-east-1c"}
+	}
+	return &EC2{
+		zones:     zones,
+		vpcs:      map[string]types.Vpc{},
+		subnets:   map[string]types.Subnet{},
+		gateways:  map[string]types.InternetGateway{},
+		tables:    map[string]types.RouteTable{},
+		addresses: map[string]types.Address{},
+		nats:      map[string]types.NatGateway{},
+		groups:    map[string]types.SecurityGroup{},
+	}
+}
+
+// ModifySecurityGroup changes a security group found by name outside of tidalwave
+func (e *EC2) ModifySecurityGroup(name string, fn func(*types.SecurityGroup)) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for id, g := range e.groups {
+		if aws.ToString(g.GroupName) == name {
+			fn(&g)
+			e.groups[id] = g
+		}
+	}
+}
+
+// RemoveRouteTable deletes a route table found by name and its associations outside of
+// tidalwave
+func (e *EC2) RemoveRouteTable(name string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for id, t := range e.tables {
+		for _, tag := range t.Tags {
+			if aws.ToString(tag.Key) == "Name" && aws.ToString(tag.Value) == name {
+				delete(e.tables, id)
+			}
+		}
+	}
+}
+
+// Count returns how many resources of each kind exist, deleted NAT gateways are not counted
+func (e *EC2) Count() map[string]int {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	nats := 0
+	for _, n := range e.nats {
+		if n.State != types.NatGatewayStateDeleted {
+			nats++
+		}
+	}
+	return map[string]int{
+		"vpc":              len(e.vpcs),
+		"subnet":           len(e.subnets),
+		"internet-gateway": len(e.gateways),
+		"route-table":      len(e.tables),
+		"address":          len(e.addresses),
+		"natgateway":       nats,
+		"security-group":   len(e.groups),
+	}
+}
+
+// tags returns the tags of the first tag specification
+func tags(specs []types.TagSpecification) []types.Tag {
+	if len(specs) == 0 {
+		return nil
+	}
+	return append([]types.Tag{}, specs[0].Tags...)
+}
+
+// match reports whether a resource passes every filter, values may be wildcards. fields are the
+// values of the filters that are not tags.
+func match(filters []types.Filter, tags []types.Tag, fields map[string]string) bool {
+	for _, f := range filters {
+		name := aws.ToString(f.Name)
+		value, ok := fields[name], false
+		if strings.HasPrefix(name, "tag:") {
+			value = ""
+			for _, t := range tags {
+				if aws.ToString(t.Key) == strings.TrimPrefix(name, "tag:") {
+					value, ok = aws.ToString(t.Value), true
+				}
+			}
+		} else {
+			_, ok = fields[name]
+		}
+		if !ok {
+			return false
+		}
+		matched := false
+		for _, v := range f.Values {
+			if m, _ := path.Match(v, value); m {
+				matched = true
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+	return true
+}
+
+// wanted reports whether id is one of ids, every id is wanted if ids is empty
+func wanted(ids []string, id string) bool {
+	if len(ids) == 0 {
+		return true
+	}
+	for _, i := range ids {
+		if i == id {
+			return true
+		}
+	}
+	return false
+}
+
+// sorted returns the values of m ordered by key so results are deterministic
+func sorted[T any](m map[string]T) []T {
+	keys := []string{}
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	values := []T{}
+	for _, k := range keys {
+		values = append(values, m[k])
+	}
+	return values
+}
+
+// DescribeVpcs lists VPCs
+func (e *EC2) DescribeVpcs(ctx context.Context, params *ec2.DescribeVpcsInput, optFns ...func(*ec2.Options)) (*ec2.DescribeVpcsOutput, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if err := e.call("DescribeVpcs"); err != nil {
+		return nil, err
+	}
+	out := &ec2.DescribeVpcsOutput{}
+	for _, id := range params.VpcIds {
+		if _, ok := e.vpcs[id]; !ok {
+			return nil, apiError("InvalidVpcID.NotFound", "The vpc ID '%s' does not exist", id)
+		}
+	}
+	for _, v := range sorted(e.vpcs) {
+		if wanted(params.VpcIds, aws.ToString(v.VpcId)) && match(params.Filters, v.Tags, map[string]string{"state": string(v.State)}) {
+			out.Vpcs = append(out.Vpcs, v)
+		}
+	}
+	return out, nil
+}
+
+// CreateVpc creates a VPC
+func (e *EC2) CreateVpc(ctx context.Context, params *ec2.CreateVpcInput, optFns ...func(*ec2.Options)) (*ec2.CreateVpcOutput, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if err := e.call("CreateVpc"); err != nil {
+		return nil, err
+	}
+	v := types.Vpc{
+		VpcId:     aws.String(nextID("vpc")),
+		CidrBlock: params.CidrBlock,
+		State:     types.VpcStateAvailable,
+		Tags:      tags(params.TagSpecifications),
+	}
+	e.vpcs[aws.ToString(v.VpcId)] = v
+	return &ec2.CreateVpcOutput{Vpc: &v}, nil
+}
+
+// ModifyVpcAttribute accepts any attribute, the fake does not store them
+func (e *EC2) ModifyVpcAttribute(ctx context.Context, params *ec2.ModifyVpcAttributeInput, optFns ...func(*ec2.Options)) (*ec2.ModifyVpcAttributeOutput, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if err := e.call("ModifyVpcAttribute"); err != nil {
+		return nil, err
+	}
+	if _, ok := e.vpcs[aws.ToString(params.VpcId)]; !ok {
+		return nil, apiError("InvalidVpcID.NotFound", "The vpc ID '%s' does not exist", aws.ToString(params.VpcId))
+	}
+	return &ec2.ModifyVpcAttributeOutput{}, nil
+}
+
+// DeleteVpc deletes a VPC that has nothing left in it
+func (e *EC2) DeleteVpc(ctx context.Context, params *ec2.DeleteVpcInput, optFns ...func(*ec2.Options)) (*ec2.DeleteVpcOutput, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if err := e.call("DeleteVpc"); err != nil {
+		return nil, err
+	}
+	id := aws.ToString(params.VpcId)
+	if _, ok := e.vpcs[id]; !ok {
+		return nil, apiError("InvalidVpcID.NotFound", "The vpc ID '%s' does not exist", id)
+	}
+	inUse := false
+	for _, s := range e.subnets {
+		inUse = inUse || aws.ToString(s.VpcId) == id
+	}
+	for _, t := range e.tables {
+		inUse = inUse || aws.ToString(t.VpcId) == id
+	}
+	for _, g := range e.groups {
+		inUse = inUse || aws.ToString(g.VpcId) == id
+	}
+	for _, g := range e.gateways {
+		for _, a := range g.Attachments {
+			inUse = inUse || aws.ToString(a.VpcId) == id
+		}
+	}
+	if inUse {
+		return nil, apiError("DependencyViolation", "The vpc '%s' has dependencies and cannot be deleted", id)
+	}
+	delete(e.vpcs, id)
+	return &ec2.DeleteVpcOutput{}, nil
+}
+
+// DescribeAvailabilityZones lists the zones of the region
+func (e *EC2) DescribeAvailabilityZones(ctx context.Context, params *ec2.DescribeAvailabilityZonesInput, optFns ...func(*ec2.Options)) (*ec2.DescribeAvailabilityZonesOutput, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if err := e.call("DescribeAvailabilityZones"); err != nil {
+		return nil, err
+	}
+	out := &ec2.DescribeAvailabilityZonesOutput{}
+	for _, z := range e.zones {
+		out.AvailabilityZones = append(out.AvailabilityZones, types.AvailabilityZone{
+			ZoneName: aws.String(z),
+			State:    types.AvailabilityZoneStateAvailable,
+		})
+	}
+	return out, nil
+}
+
+// DescribeSubnets lists subnets
+func (e *EC2) DescribeSubnets(ctx context.Context, params *ec2.DescribeSubnetsInput, optFns ...func(*ec2.Options)) (*ec2.DescribeSubnetsOutput, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if err := e.call("DescribeSubnets"); err != nil {
+		return nil, err
+	}
+	out := &ec2.DescribeSubnetsOutput{}
+	for _, s := range sorted(e.subnets) {
+		if wanted(params.SubnetIds, aws.ToString(s.SubnetId)) && match(params.Filters, s.Tags, map[string]string{"vpc-id": aws.ToString(s.VpcId)}) {
+			out.Subnets = append(out.Subnets, s)
+		}
+	}
+	return out, nil
+}
+
+// CreateSubnet creates a subnet
+func (e *EC2) CreateSubnet(ctx context.Context, params *ec2.CreateSubnetInput, optFns ...func(*ec2.Options)) (*ec2.CreateSubnetOutput, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if err := e.call("CreateSubnet"); err != nil {
+		return nil, err
+	}
+	if _, ok := e.vpcs[aws.ToString(params.VpcId)]; !ok {
+		return nil, apiError("InvalidVpcID.NotFound", "The vpc ID '%s' does not exist", aws.ToString(params.VpcId))
+	}
+	id := nextID("subnet")
+	s := types.Subnet{
+		SubnetId:         aws.String(id),
+		SubnetArn:        aws.String(fmt.Sprintf("arn:aws:ec2:us-east-1:000000000000:subnet/%s", id)),
+		VpcId:            params.VpcId,
+		CidrBlock:        params.CidrBlock,
+		AvailabilityZone: params.AvailabilityZone,
+		State:            types.SubnetStateAvailable,
+		Tags:             tags(params.TagSpecifications),
+	}
+	e.subnets[id] = s
+	return &ec2.CreateSubnetOutput{Subnet: &s}, nil
+}
+
+// DeleteSubnet deletes a subnet without a NAT gateway in it and its route table associations
+func (e *EC2) DeleteSubnet(ctx context.Context, params *ec2.DeleteSubnetInput, optFns ...func(*ec2.Options)) (*ec2.DeleteSubnetOutput, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if err := e.call("DeleteSubnet"); err != nil {
+		return nil, err
+	}
+	id := aws.ToString(params.SubnetId)
+	if _, ok := e.subnets[id]; !ok {
+		return nil, apiError("InvalidSubnetID.NotFound", "The subnet ID '%s' does not exist", id)
+	}
+	for _, n := range e.nats {
+		if aws.ToString(n.SubnetId) == id && n.State != types.NatGatewayStateDeleted {
+			return nil, apiError("DependencyViolation", "The subnet '%s' has dependencies and cannot be deleted", id)
+		}
+	}
+	for tid, t := range e.tables {
+		associations := []types.RouteTableAssociation{}
+		for _, a := range t.Associations {
+			if aws.ToString(a.SubnetId) != id {
+				associations = append(associations, a)
+			}
+		}
+		t.Associations = associations
+		e.tables[tid] = t
+	}
+	delete(e.subnets, id)
+	return &ec2.DeleteSubnetOutput{}, nil
+}
+
+// DescribeInternetGateways lists internet gateways
+func (e *EC2) DescribeInternetGateways(ctx context.Context, params *ec2.DescribeInternetGatewaysInput, optFns ...func(*ec2.Options)) (*ec2.DescribeInternetGatewaysOutput, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if err := e.call("DescribeInternetGateways"); err != nil {
+		return nil, err
+	}
+	out := &ec2.DescribeInternetGatewaysOutput{}
+	for _, g := range sorted(e.gateways) {
+		if wanted(params.InternetGatewayIds, aws.ToString(g.InternetGatewayId)) && match(params.Filters, g.Tags, nil) {
+			out.InternetGateways = append(out.InternetGateways, g)
+		}
+	}
+	return out, nil
+}
+
+// CreateInternetGateway creates a detached internet gateway
+func (e *EC2) CreateInternetGateway(ctx context.Context, params *ec2.CreateInternetGatewayInput, optFns ...func(*ec2.Options)) (*ec2.CreateInternetGatewayOutput, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if err := e.call("CreateInternetGateway"); err != nil {
+		return nil, err
+	}
+	g := types.InternetGateway{
+		InternetGatewayId: aws.String(nextID("igw")),
+		Tags:              tags(params.TagSpecifications),
+	}
+	e.gateways[aws.ToString(g.InternetGatewayId)] = g
+	return &ec2.CreateInternetGatewayOutput{InternetGateway: &g}, nil
+}
+
+// AttachInternetGateway attaches an internet gateway to a VPC
+func (e *EC2) AttachInternetGateway(ctx context.Context, params *ec2.AttachInternetGatewayInput, optFns ...func(*ec2.Options)) (*ec2.AttachInternetGatewayOutput, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if err := e.call("AttachInternetGateway"); err != nil {
+		return nil, err
+	}
+	id := aws.ToString(params.InternetGatewayId)
+	g, ok := e.gateways[id]
+	if !ok {
+		return nil, apiError("InvalidInternetGatewayID.NotFound", "The internet gateway ID '%s' does not exist", id)
+	}
+	if len(g.Attachments) > 0 {
+		return nil, apiError("Resource.AlreadyAssociated", "resource %s is already attached", id)
+	}
+	g.Attachments = []types.InternetGatewayAttachment{{VpcId: params.VpcId, State: types.AttachmentStatusAttached}}
+	e.gateways[id] = g
+	return &ec2.AttachInternetGatewayOutput{}, nil
+}
+
+// DetachInternetGateway detaches an internet gateway from its VPC
+func (e *EC2) DetachInternetGateway(ctx context.Context, params *ec2.DetachInternetGatewayInput, optFns ...func(*ec2.Options)) (*ec2.DetachInternetGatewayOutput, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if err := e.call("DetachInternetGateway"); err != nil {
+		return nil, err
+	}
+	id := aws.ToString(params.InternetGatewayId)
+	g, ok := e.gateways[id]
+	if !ok {
+		return nil, apiError("InvalidInternetGatewayID.NotFound", "The internet gateway ID '%s' does not exist", id)
+	}
+	g.Attachments = nil
+	e.gateways[id] = g
+	return &ec2.DetachInternetGatewayOutput{}, nil
+}
+
+// DeleteInternetGateway deletes a detached internet gateway
+func (e *EC2) DeleteInternetGateway(ctx context.Context, params *ec2.DeleteInternetGatewayInput, optFns ...func(*ec2.Options)) (*ec2.DeleteInternetGatewayOutput, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if err := e.call("DeleteInternetGateway"); err != nil {
+		return nil, err
+	}
+	id := aws.ToString(params.InternetGatewayId)
+	g, ok := e.gateways[id]
+	if !ok {
+		return nil, apiError("InvalidInternetGatewayID.NotFound", "The internet gateway ID '%s' does not exist", id)
+	}
+	if len(g.Attachments) > 0 {
+		return nil, apiError("DependencyViolation", "The internet gateway '%s' has dependencies and cannot be deleted", id)
+	}
+	delete(e.gateways, id)
+	return &ec2.DeleteInternetGatewayOutput{}, nil
+}
+
+// DescribeRouteTables lists route tables
+func (e *EC2) DescribeRouteTables(ctx context.Context, params *ec2.DescribeRouteTablesInput, optFns ...func(*ec2.Options)) (*ec2.DescribeRouteTablesOutput, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if err := e.call("DescribeRouteTables"); err != nil {
+		return nil, err
+	}
+	out := &ec2.DescribeRouteTablesOutput{}
+	for _, id := range params.RouteTableIds {
+		if _, ok := e.tables[id]; !ok {
+			return nil, apiError("InvalidRouteTableID.NotFound", "The routeTable ID '%s' does not exist", id)
+		}
+	}
+	for _, t := range sorted(e.tables) {
+		if wanted(params.RouteTableIds, aws.ToString(t.RouteTableId)) && match(params.Filters, t.Tags, map[string]string{"vpc-id": aws.ToString(t.VpcId)}) {
+			out.RouteTables = append(out.RouteTables, t)
+		}
+	}
+	return out, nil
+}
+
+// CreateRouteTable creates an empty route table
+func (e *EC2) CreateRouteTable(ctx context.Context, params *ec2.CreateRouteTableInput, optFns ...func(*ec2.Options)) (*ec2.CreateRouteTableOutput, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if err := e.call("CreateRouteTable"); err != nil {
+		return nil, err
+	}
+	t := types.RouteTable{
+		RouteTableId: aws.String(nextID("rtb")),
+		VpcId:        params.VpcId,
+		Tags:         tags(params.TagSpecifications),
+	}
+	e.tables[aws.ToString(t.RouteTableId)] = t
+	return &ec2.CreateRouteTableOutput{RouteTable: &t}, nil
+}
+
+// CreateRoute adds a route to a route table
+func (e *EC2) CreateRoute(ctx context.Context, params *ec2.CreateRouteInput, optFns ...func(*ec2.Options)) (*ec2.CreateRouteOutput, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if err := e.call("CreateRoute"); err != nil {
+		return nil, err
+	}
+	id := aws.ToString(params.RouteTableId)
+	t, ok := e.tables[id]
+	if !ok {
+		return nil, apiError("InvalidRouteTableID.NotFound", "The routeTable ID '%s' does not exist", id)
+	}
+	t.Routes = append(append([]types.Route{}, t.Routes...), types.Route{
+		DestinationCidrBlock: params.DestinationCidrBlock,
+		GatewayId:            params.GatewayId,
+		NatGatewayId:         params.NatGatewayId,
+		State:                types.RouteStateActive,
+	})
+	e.tables[id] = t
+	return &ec2.CreateRouteOutput{Return: aws.Bool(true)}, nil
+}
+
+// AssociateRouteTable routes a subnet through a route table
+func (e *EC2) AssociateRouteTable(ctx context.Context, params *ec2.AssociateRouteTableInput, optFns ...func(*ec2.Options)) (*ec2.AssociateRouteTableOutput, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if err := e.call("AssociateRouteTable"); err != nil {
+		return nil, err
+	}
+	id := aws.ToString(params.RouteTableId)
+	t, ok := e.tables[id]
+	if !ok {
+		return nil, apiError("InvalidRouteTableID.NotFound", "The routeTable ID '%s' does not exist", id)
+	}
+	if _, ok := e.subnets[aws.ToString(params.SubnetId)]; !ok {
+		return nil, apiError("InvalidSubnetID.NotFound", "The subnet ID '%s' does not exist", aws.ToString(params.SubnetId))
+	}
+	association := types.RouteTableAssociation{
+		RouteTableAssociationId: aws.String(nextID("rtbassoc")),
+		RouteTableId:            params.RouteTableId,
+		SubnetId:                params.SubnetId,
+	}
+	t.Associations = append(append([]types.RouteTableAssociation{}, t.Associations...), association)
+	e.tables[id] = t
+	return &ec2.AssociateRouteTableOutput{AssociationId: association.RouteTableAssociationId}, nil
+}
+
+// DeleteRouteTable deletes a route table no subnet is associated with
+func (e *EC2) DeleteRouteTable(ctx context.Context, params *ec2.DeleteRouteTableInput, optFns ...func(*ec2.Options)) (*ec2.DeleteRouteTableOutput, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if err := e.call("DeleteRouteTable"); err != nil {
+		return nil, err
+	}
+	id := aws.ToString(params.RouteTableId)
+	t, ok := e.tables[id]
+	if !ok {
+		return nil, apiError("InvalidRouteTableID.NotFound", "The routeTable ID '%s' does not exist", id)
+	}
+	if len(t.Associations) > 0 {
+		return nil, apiError("DependencyViolation", "The routeTable '%s' has dependencies and cannot be deleted", id)
+	}
+	delete(e.tables, id)
+	return &ec2.DeleteRouteTableOutput{}, nil
+}
+
+// DescribeAddresses lists elastic IPs
+func (e *EC2) DescribeAddresses(ctx context.Context, params *ec2.DescribeAddressesInput, optFns ...func(*ec2.Options)) (*ec2.DescribeAddressesOutput, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if err := e.call("DescribeAddresses"); err != nil {
+		return nil, err
+	}
+	out := &ec2.DescribeAddressesOutput{}
+	for _, a := range sorted(e.addresses) {
+		if wanted(params.AllocationIds, aws.ToString(a.AllocationId)) && match(params.Filters, a.Tags, nil) {
+			out.Addresses = append(out.Addresses, a)
+		}
+	}
+	return out, nil
+}
+
+// AllocateAddress allocates an elastic IP
+func (e *EC2) AllocateAddress(ctx context.Context, params *ec2.AllocateAddressInput, optFns ...func(*ec2.Options)) (*ec2.AllocateAddressOutput, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if err := e.call("AllocateAddress"); err != nil {
+		return nil, err
+	}
+	a := types.Address{
+		AllocationId: aws.String(nextID("eipalloc")),
+		PublicIp:     aws.String(fmt.Sprintf("203.0.113.%d", len(e.addresses)+1)),
+		Domain:       params.Domain,
+		Tags:         tags(params.TagSpecifications),
+	}
+	e.addresses[aws.ToString(a.AllocationId)] = a
+	return &ec2.AllocateAddressOutput{AllocationId: a.AllocationId, PublicIp: a.PublicIp, Domain: a.Domain}, nil
+}
+
+// ReleaseAddress releases an elastic IP no NAT gateway uses
+func (e *EC2) ReleaseAddress(ctx context.Context, params *ec2.ReleaseAddressInput, optFns ...func(*ec2.Options)) (*ec2.ReleaseAddressOutput, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if err := e.call("ReleaseAddress"); err != nil {
+		return nil, err
+	}
+	id := aws.ToString(params.AllocationId)
+	if _, ok := e.addresses[id]; !ok {
+		return nil, apiError("InvalidAllocationID.NotFound", "The allocation ID '%s' does not exist", id)
+	}
+	for _, n := range e.nats {
+		for _, a := range n.NatGatewayAddresses {
+			if aws.ToString(a.AllocationId) == id && n.State != types.NatGatewayStateDeleted {
+				return nil, apiError("InvalidIPAddress.InUse", "Address %s is in use", id)
+			}
+		}
+	}
+	delete(e.addresses, id)
+	return &ec2.ReleaseAddressOutput{}, nil
+}
+
+// DescribeNatGateways lists NAT gateways, including deleted ones
+func (e *EC2) DescribeNatGateways(ctx context.Context, params *ec2.DescribeNatGatewaysInput, optFns ...func(*ec2.Options)) (*ec2.DescribeNatGatewaysOutput, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if err := e.call("DescribeNatGateways"); err != nil {
+		return nil, err
+	}
+	out := &ec2.DescribeNatGatewaysOutput{}
+	for _, id := range params.NatGatewayIds {
+		if _, ok := e.nats[id]; !ok {
+			return nil, apiError("NatGatewayNotFound", "NAT gateway %s was not found", id)
+		}
+	}
+	for _, n := range sorted(e.nats) {
+		fields := map[string]string{"state": string(n.State), "vpc-id": aws.ToString(n.VpcId)}
+		if wanted(params.NatGatewayIds, aws.ToString(n.NatGatewayId)) && match(params.Filter, n.Tags, fields) {
+			out.NatGateways = append(out.NatGateways, n)
+		}
+	}
+	return out, nil
+}
+
+// CreateNatGateway creates an available NAT gateway
+func (e *EC2) CreateNatGateway(ctx context.Context, params *ec2.CreateNatGatewayInput, optFns ...func(*ec2.Options)) (*ec2.CreateNatGatewayOutput, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if err := e.call("CreateNatGateway"); err != nil {
+		return nil, err
+	}
+	subnet, ok := e.subnets[aws.ToString(params.SubnetId)]
+	if !ok {
+		return nil, apiError("InvalidSubnetID.NotFound", "The subnet ID '%s' does not exist", aws.ToString(params.SubnetId))
+	}
+	if _, ok := e.addresses[aws.ToString(params.AllocationId)]; !ok {
+		return nil, apiError("InvalidAllocationID.NotFound", "The allocation ID '%s' does not exist", aws.ToString(params.AllocationId))
+	}
+	n := types.NatGateway{
+		NatGatewayId:        aws.String(nextID("nat")),
+		SubnetId:            params.SubnetId,
+		VpcId:               subnet.VpcId,
+		State:               types.NatGatewayStateAvailable,
+		CreateTime:          aws.Time(time.Now().UTC()),
+		NatGatewayAddresses: []types.NatGatewayAddress{{AllocationId: params.AllocationId}},
+		Tags:                tags(params.TagSpecifications),
+	}
+	e.nats[aws.ToString(n.NatGatewayId)] = n
+	return &ec2.CreateNatGatewayOutput{NatGateway: &n}, nil
+}
+
+// DeleteNatGateway marks a NAT gateway deleted, EC2 keeps listing it for a while
+func (e *EC2) DeleteNatGateway(ctx context.Context, params *ec2.DeleteNatGatewayInput, optFns ...func(*ec2.Options)) (*ec2.DeleteNatGatewayOutput, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if err := e.call("DeleteNatGateway"); err != nil {
+		return nil, err
+	}
+	id := aws.ToString(params.NatGatewayId)
+	n, ok := e.nats[id]
+	if !ok {
+		return nil, apiError("NatGatewayNotFound", "NAT gateway %s was not found", id)
+	}
+	for _, t := range e.tables {
+		for _, r := range t.Routes {
+			if aws.ToString(r.NatGatewayId) == id {
+				return nil, apiError("DependencyViolation", "NAT gateway %s is used by route table %s", id, aws.ToString(t.RouteTableId))
+			}
+		}
+	}
+	n.State = types.NatGatewayStateDeleted
+	e.nats[id] = n
+	return &ec2.DeleteNatGatewayOutput{NatGatewayId: params.NatGatewayId}, nil
+}
+
+// DescribeSecurityGroups lists security groups
+func (e *EC2) DescribeSecurityGroups(ctx context.Context, params *ec2.DescribeSecurityGroupsInput, optFns ...func(*ec2.Options)) (*ec2.DescribeSecurityGroupsOutput, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if err := e.call("DescribeSecurityGroups"); err != nil {
+		return nil, err
+	}
+	out := &ec2.DescribeSecurityGroupsOutput{}
+	for _, g := range sorted(e.groups) {
+		fields := map[string]string{"group-name": aws.ToString(g.GroupName), "vpc-id": aws.ToString(g.VpcId)}
+		if wanted(params.GroupIds, aws.ToString(g.GroupId)) && match(params.Filters, g.Tags, fields) {
+			out.SecurityGroups = append(out.SecurityGroups, g)
+		}
+	}
+	return out, nil
+}
+
+// CreateSecurityGroup creates a security group that allows all egress, like EC2 does
+func (e *EC2) CreateSecurityGroup(ctx context.Context, params *ec2.CreateSecurityGroupInput, optFns ...func(*ec2.Options)) (*ec2.CreateSecurityGroupOutput, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if err := e.call("CreateSecurityGroup"); err != nil {
+		return nil, err
+	}
+	for _, g := range e.groups {
+		if aws.ToString(g.GroupName) == aws.ToString(params.GroupName) && aws.ToString(g.VpcId) == aws.ToString(params.VpcId) {
+			return nil, apiError("InvalidGroup.Duplicate", "The security group '%s' already exists", aws.ToString(params.GroupName))
+		}
+	}
+	g := types.SecurityGroup{
+		GroupId:     aws.String(nextID("sg")),
+		GroupName:   params.GroupName,
+		Description: params.Description,
+		VpcId:       params.VpcId,
+		IpPermissionsEgress: []types.IpPermission{
+			{IpProtocol: aws.String("-1"), IpRanges: []types.IpRange{{CidrIp: aws.String("0.0.0.0/0")}}},
+		},
+		Tags: tags(params.TagSpecifications),
+	}
+	e.groups[aws.ToString(g.GroupId)] = g
+	return &ec2.CreateSecurityGroupOutput{GroupId: g.GroupId}, nil
+}
+
+// DeleteSecurityGroup deletes a security group
+func (e *EC2) DeleteSecurityGroup(ctx context.Context, params *ec2.DeleteSecurityGroupInput, optFns ...func(*ec2.Options)) (*ec2.DeleteSecurityGroupOutput, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if err := e.call("DeleteSecurityGroup"); err != nil {
+		return nil, err
+	}
+	id := aws.ToString(params.GroupId)
+	if _, ok := e.groups[id]; !ok {
+		return nil, apiError("InvalidGroup.NotFound", "The security group '%s' does not exist", id)
+	}
+	delete(e.groups, id)
+	return &ec2.DeleteSecurityGroupOutput{}, nil
+}
+
+// AuthorizeSecurityGroupIngress adds ingress rules
+func (e *EC2) AuthorizeSecurityGroupIngress(ctx context.Context, params *ec2.AuthorizeSecurityGroupIngressInput, optFns ...func(*ec2.Options)) (*ec2.AuthorizeSecurityGroupIngressOutput, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if err := e.call("AuthorizeSecurityGroupIngress"); err != nil {
+		return nil, err
+	}
+	if err := e.updatePermissions(aws.ToString(params.GroupId), false, params.IpPermissions, nil); err != nil {
+		return nil, err
+	}
+	return &ec2.AuthorizeSecurityGroupIngressOutput{Return: aws.Bool(true)}, nil
+}
+
+// AuthorizeSecurityGroupEgress adds egress rules
+func (e *EC2) AuthorizeSecurityGroupEgress(ctx context.Context, params *ec2.AuthorizeSecurityGroupEgressInput, optFns ...func(*ec2.Options)) (*ec2.AuthorizeSecurityGroupEgressOutput, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if err := e.call("AuthorizeSecurityGroupEgress"); err != nil {
+		return nil, err
+	}
+	if err := e.updatePermissions(aws.ToString(params.GroupId), true, params.IpPermissions, nil); err != nil {
+		return nil, err
+	}
+	return &ec2.AuthorizeSecurityGroupEgressOutput{Return: aws.Bool(true)}, nil
+}
+
+// RevokeSecurityGroupIngress removes ingress rules
+func (e *EC2) RevokeSecurityGroupIngress(ctx context.Context, params *ec2.RevokeSecurityGroupIngressInput, optFns ...func(*ec2.Options)) (*ec2.RevokeSecurityGroupIngressOutput, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if err := e.call("RevokeSecurityGroupIngress"); err != nil {
+		return nil, err
+	}
+	if err := e.updatePermissions(aws.ToString(params.GroupId), false, nil, params.IpPermissions); err != nil {
+		return nil, err
+	}
+	return &ec2.RevokeSecurityGroupIngressOutput{Return: aws.Bool(true)}, nil
+}
+
+// RevokeSecurityGroupEgress removes egress rules
+func (e *EC2) RevokeSecurityGroupEgress(ctx context.Context, params *ec2.RevokeSecurityGroupEgressInput, optFns ...func(*ec2.Options)) (*ec2.RevokeSecurityGroupEgressOutput, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if err := e.call("RevokeSecurityGroupEgress"); err != nil {
+		return nil, err
+	}
+	if err := e.updatePermissions(aws.ToString(params.GroupId), true, nil, params.IpPermissions); err != nil {
+		return nil, err
+	}
+	return &ec2.RevokeSecurityGroupEgressOutput{Return: aws.Bool(true)}, nil
+}
+
+// rule is a single permission for a single CIDR block
+type rule struct {
+	protocol string
+	from, to int32
+	cidr     string
+}
+
+func flatten(perms []types.IpPermission) []rule {
+	rules := []rule{}
+	for _, p := range perms {
+		for _, r := range p.IpRanges {
+			rules = append(rules, rule{aws.ToString(p.IpProtocol), aws.ToInt32(p.FromPort), aws.ToInt32(p.ToPort), aws.ToString(r.CidrIp)})
+		}
+	}
+	return rules
+}
+
+// updatePermissions adds and removes rules of a security group, it must be called with mu held
+func (e *EC2) updatePermissions(id string, egress bool, add, remove []types.IpPermission) error {
+	g, ok := e.groups[id]
+	if !ok {
+		return apiError("InvalidGroup.NotFound", "The security group '%s' does not exist", id)
+	}
+	existing := g.IpPermissions
+	if egress {
+		existing = g.IpPermissionsEgress
+	}
+	rules := map[rule]bool{}
+	for _, r := range flatten(existing) {
+		rules[r] = true
+	}
+	for _, r := range flatten(add) {
+		if rules[r] {
+			return apiError("InvalidPermission.Duplicate", "the specified rule already exists")
+		}
+		rules[r] = true
+	}
+	for _, r := range flatten(remove) {
+		if !rules[r] {
+			return apiError("InvalidPermission.NotFound", "the specified rule does not exist")
+		}
+		delete(rules, r)
+	}
+	perms := []types.IpPermission{}
+	for r := range rules {
+		p := types.IpPermission{IpProtocol: aws.String(r.protocol), IpRanges: []types.IpRange{{CidrIp: aws.String(r.cidr)}}}
+		if r.protocol != "-1" {
+			p.FromPort, p.ToPort = aws.Int32(r.from), aws.Int32(r.to)
+		}
+		perms = append(perms, p)
+	}
+	if egress {
+		g.IpPermissionsEgress = perms
+	} else {
+		g.IpPermissions = perms
+	}
+	e.groups[id] = g
+	return nil
+}
@@ -0,0 +1,292 @@
+package awstest
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/eks"
+	"github.com/aws/aws-sdk-go-v2/service/eks/types"
+)
+
+// EKS is a fake awsapi.EKSClient, clusters and node groups are active as soon as they are
+// created and updates succeed straight away
+type EKS struct {
+	Recorder
+	clusters   map[string]types.Cluster
+	nodegroups map[string]types.Nodegroup
+	updates    map[string]types.Update
+}
+
+// NewEKS returns an empty fake EKS client
+func NewEKS() *EKS {
+	return &EKS{
+		clusters:   map[string]types.Cluster{},
+		nodegroups: map[string]types.Nodegroup{},
+		updates:    map[string]types.Update{},
+	}
+}
+
+// ModifyCluster changes a cluster outside of tidalwave
+func (e *EKS) ModifyCluster(name string, fn func(*types.Cluster)) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if c, ok := e.clusters[name]; ok {
+		fn(&c)
+		e.clusters[name] = c
+	}
+}
+
+// ModifyNodegroup changes a node group outside of tidalwave
+func (e *EKS) ModifyNodegroup(cluster, name string, fn func(*types.Nodegroup)) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if n, ok := e.nodegroups[cluster+"/"+name]; ok {
+		fn(&n)
+		e.nodegroups[cluster+"/"+name] = n
+	}
+}
+
+// Count returns how many clusters and node groups exist
+func (e *EKS) Count() map[string]int {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return map[string]int{"cluster": len(e.clusters), "nodegroup": len(e.nodegroups)}
+}
+
+func notFound(format string, a ...interface{}) error {
+	return &types.ResourceNotFoundException{Message: aws.String(fmt.Sprintf(format, a...))}
+}
+
+// vpcConfig converts a request to the response EKS would return, the public endpoint allows
+// everyone unless it is limited
+func vpcConfig(req *types.VpcConfigRequest, current *types.VpcConfigResponse) *types.VpcConfigResponse {
+	config := &types.VpcConfigResponse{}
+	if current != nil {
+		c := *current
+		config = &c
+	}
+	if req.SubnetIds != nil {
+		config.SubnetIds = req.SubnetIds
+	}
+	if req.SecurityGroupIds != nil {
+		config.SecurityGroupIds = req.SecurityGroupIds
+	}
+	if req.EndpointPrivateAccess != nil {
+		config.EndpointPrivateAccess = *req.EndpointPrivateAccess
+	}
+	if req.EndpointPublicAccess != nil {
+		config.EndpointPublicAccess = *req.EndpointPublicAccess
+	}
+	if req.PublicAccessCidrs != nil {
+		config.PublicAccessCidrs = req.PublicAccessCidrs
+	}
+	if len(config.PublicAccessCidrs) == 0 {
+		config.PublicAccessCidrs = []string{"0.0.0.0/0"}
+	}
+	return config
+}
+
+// newUpdate records a successful update, it must be called with mu held
+func (e *EKS) newUpdate(updateType types.UpdateType) *types.Update {
+	u := types.Update{
+		Id:        aws.String(nextID("update")),
+		Type:      updateType,
+		Status:    types.UpdateStatusSuccessful,
+		CreatedAt: aws.Time(time.Now().UTC()),
+	}
+	e.updates[aws.ToString(u.Id)] = u
+	return &u
+}
+
+// DescribeCluster gets a cluster
+func (e *EKS) DescribeCluster(ctx context.Context, params *eks.DescribeClusterInput, optFns ...func(*eks.Options)) (*eks.DescribeClusterOutput, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if err := e.call("DescribeCluster"); err != nil {
+		return nil, err
+	}
+	c, ok := e.clusters[aws.ToString(params.Name)]
+	if !ok {
+		return nil, notFound("No cluster found for name: %s.", aws.ToString(params.Name))
+	}
+	return &eks.DescribeClusterOutput{Cluster: &c}, nil
+}
+
+// CreateCluster creates an active cluster
+func (e *EKS) CreateCluster(ctx context.Context, params *eks.CreateClusterInput, optFns ...func(*eks.Options)) (*eks.CreateClusterOutput, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if err := e.call("CreateCluster"); err != nil {
+		return nil, err
+	}
+	name := aws.ToString(params.Name)
+	if _, ok := e.clusters[name]; ok {
+		return nil, &types.ResourceInUseException{Message: aws.String(fmt.Sprintf("Cluster already exists with name: %s", name))}
+	}
+	version := params.Version
+	if version == nil {
+		version = aws.String("1.24")
+	}
+	c := types.Cluster{
+		Name:      params.Name,
+		Arn:       aws.String(fmt.Sprintf("arn:aws:eks:us-east-1:000000000000:cluster/%s", name)),
+		Version:   version,
+		RoleArn:   params.RoleArn,
+		Status:    types.ClusterStatusActive,
+		CreatedAt: aws.Time(time.Now().UTC()),
+		Endpoint:  aws.String(fmt.Sprintf("https://%s.gr7.us-east-1.eks.amazonaws.com", name)),
+		CertificateAuthority: &types.Certificate{
+			Data: aws.String("LS0tLS1CRUdJTiBDRVJUSUZJQ0FURS0tLS0t"),
+		},
+		ResourcesVpcConfig: vpcConfig(params.ResourcesVpcConfig, nil),
+		EncryptionConfig:   params.EncryptionConfig,
+		Tags:               params.Tags,
+	}
+	e.clusters[name] = c
+	return &eks.CreateClusterOutput{Cluster: &c}, nil
+}
+
+// UpdateClusterConfig changes the endpoint access of a cluster
+func (e *EKS) UpdateClusterConfig(ctx context.Context, params *eks.UpdateClusterConfigInput, optFns ...func(*eks.Options)) (*eks.UpdateClusterConfigOutput, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if err := e.call("UpdateClusterConfig"); err != nil {
+		return nil, err
+	}
+	name := aws.ToString(params.Name)
+	c, ok := e.clusters[name]
+	if !ok {
+		return nil, notFound("No cluster found for name: %s.", name)
+	}
+	if params.ResourcesVpcConfig != nil {
+		c.ResourcesVpcConfig = vpcConfig(params.ResourcesVpcConfig, c.ResourcesVpcConfig)
+	}
+	e.clusters[name] = c
+	return &eks.UpdateClusterConfigOutput{Update: e.newUpdate(types.UpdateTypeEndpointAccessUpdate)}, nil
+}
+
+// DeleteCluster deletes a cluster without node groups
+func (e *EKS) DeleteCluster(ctx context.Context, params *eks.DeleteClusterInput, optFns ...func(*eks.Options)) (*eks.DeleteClusterOutput, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if err := e.call("DeleteCluster"); err != nil {
+		return nil, err
+	}
+	name := aws.ToString(params.Name)
+	c, ok := e.clusters[name]
+	if !ok {
+		return nil, notFound("No cluster found for name: %s.", name)
+	}
+	for _, n := range e.nodegroups {
+		if aws.ToString(n.ClusterName) == name {
+			return nil, &types.ResourceInUseException{Message: aws.String("Cluster has nodegroups attached")}
+		}
+	}
+	delete(e.clusters, name)
+	c.Status = types.ClusterStatusDeleting
+	return &eks.DeleteClusterOutput{Cluster: &c}, nil
+}
+
+// DescribeUpdate gets an update
+func (e *EKS) DescribeUpdate(ctx context.Context, params *eks.DescribeUpdateInput, optFns ...func(*eks.Options)) (*eks.DescribeUpdateOutput, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if err := e.call("DescribeUpdate"); err != nil {
+		return nil, err
+	}
+	u, ok := e.updates[aws.ToString(params.UpdateId)]
+	if !ok {
+		return nil, notFound("No update found for ID: %s", aws.ToString(params.UpdateId))
+	}
+	return &eks.DescribeUpdateOutput{Update: &u}, nil
+}
+
+// DescribeNodegroup gets a node group
+func (e *EKS) DescribeNodegroup(ctx context.Context, params *eks.DescribeNodegroupInput, optFns ...func(*eks.Options)) (*eks.DescribeNodegroupOutput, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if err := e.call("DescribeNodegroup"); err != nil {
+		return nil, err
+	}
+	n, ok := e.nodegroups[aws.ToString(params.ClusterName)+"/"+aws.ToString(params.NodegroupName)]
+	if !ok {
+		return nil, notFound("No node group found for name: %s.", aws.ToString(params.NodegroupName))
+	}
+	return &eks.DescribeNodegroupOutput{Nodegroup: &n}, nil
+}
+
+// CreateNodegroup creates an active node group
+func (e *EKS) CreateNodegroup(ctx context.Context, params *eks.CreateNodegroupInput, optFns ...func(*eks.Options)) (*eks.CreateNodegroupOutput, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if err := e.call("CreateNodegroup"); err != nil {
+		return nil, err
+	}
+	cluster, name := aws.ToString(params.ClusterName), aws.ToString(params.NodegroupName)
+	c, ok := e.clusters[cluster]
+	if !ok {
+		return nil, notFound("No cluster found for name: %s.", cluster)
+	}
+	if _, ok := e.nodegroups[cluster+"/"+name]; ok {
+		return nil, &types.ResourceInUseException{Message: aws.String(fmt.Sprintf("NodeGroup already exists with name %s", name))}
+	}
+	diskSize := params.DiskSize
+	if diskSize == nil {
+		diskSize = aws.Int32(20)
+	}
+	n := types.Nodegroup{
+		ClusterName:   params.ClusterName,
+		NodegroupName: params.NodegroupName,
+		NodegroupArn:  aws.String(fmt.Sprintf("arn:aws:eks:us-east-1:000000000000:nodegroup/%s/%s/%s", cluster, name, nextID("ng"))),
+		NodeRole:      params.NodeRole,
+		Subnets:       params.Subnets,
+		InstanceTypes: params.InstanceTypes,
+		DiskSize:      diskSize,
+		ScalingConfig: params.ScalingConfig,
+		Status:        types.NodegroupStatusActive,
+		Version:       c.Version,
+		CreatedAt:     aws.Time(time.Now().UTC()),
+		Tags:          params.Tags,
+	}
+	e.nodegroups[cluster+"/"+name] = n
+	return &eks.CreateNodegroupOutput{Nodegroup: &n}, nil
+}
+
+// UpdateNodegroupConfig changes the scaling of a node group
+func (e *EKS) UpdateNodegroupConfig(ctx context.Context, params *eks.UpdateNodegroupConfigInput, optFns ...func(*eks.Options)) (*eks.UpdateNodegroupConfigOutput, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if err := e.call("UpdateNodegroupConfig"); err != nil {
+		return nil, err
+	}
+	key := aws.ToString(params.ClusterName) + "/" + aws.ToString(params.NodegroupName)
+	n, ok := e.nodegroups[key]
+	if !ok {
+		return nil, notFound("No node group found for name: %s.", aws.ToString(params.NodegroupName))
+	}
+	if params.ScalingConfig != nil {
+		scaling := *params.ScalingConfig
+		n.ScalingConfig = &scaling
+	}
+	e.nodegroups[key] = n
+	return &eks.UpdateNodegroupConfigOutput{Update: e.newUpdate(types.UpdateTypeConfigUpdate)}, nil
+}
+
+// DeleteNodegroup deletes a node group
+func (e *EKS) DeleteNodegroup(ctx context.Context, params *eks.DeleteNodegroupInput, optFns ...func(*eks.Options)) (*eks.DeleteNodegroupOutput, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if err := e.call("DeleteNodegroup"); err != nil {
+		return nil, err
+	}
+	key := aws.ToString(params.ClusterName) + "/" + aws.ToString(params.NodegroupName)
+	n, ok := e.nodegroups[key]
+	if !ok {
+		return nil, notFound("No node group found for name: %s.", aws.ToString(params.NodegroupName))
+	}
+	delete(e.nodegroups, key)
+	n.Status = types.NodegroupStatusDeleting
+	return &eks.DeleteNodegroupOutput{Nodegroup: &n}, nil
+}
@@ -0,0 +1,156 @@
+package awstest
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/iam"
+	"github.com/aws/aws-sdk-go-v2/service/iam/types"
+)
+
+// IAM is a fake awsapi.IAMClient
+type IAM struct {
+	Recorder
+	roles    map[string]types.Role
+	policies map[string]map[string]bool
+}
+
+// NewIAM returns an empty fake IAM client
+func NewIAM() *IAM {
+	return &IAM{
+		roles:    map[string]types.Role{},
+		policies: map[string]map[string]bool{},
+	}
+}
+
+// DetachPolicy detaches a policy from a role outside of tidalwave
+func (i *IAM) DetachPolicy(role, policy string) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	delete(i.policies[role], policy)
+}
+
+// Count returns how many roles exist
+func (i *IAM) Count() map[string]int {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	return map[string]int{"role": len(i.roles)}
+}
+
+func noSuchEntity(role string) error {
+	return &types.NoSuchEntityException{Message: aws.String(fmt.Sprintf("The role with name %s cannot be found.", role))}
+}
+
+// GetRole gets a role
+func (i *IAM) GetRole(ctx context.Context, params *iam.GetRoleInput, optFns ...func(*iam.Options)) (*iam.GetRoleOutput, error) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	if err := i.call("GetRole"); err != nil {
+		return nil, err
+	}
+	role, ok := i.roles[aws.ToString(params.RoleName)]
+	if !ok {
+		return nil, noSuchEntity(aws.ToString(params.RoleName))
+	}
+	return &iam.GetRoleOutput{Role: &role}, nil
+}
+
+// CreateRole creates a role
+func (i *IAM) CreateRole(ctx context.Context, params *iam.CreateRoleInput, optFns ...func(*iam.Options)) (*iam.CreateRoleOutput, error) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	if err := i.call("CreateRole"); err != nil {
+		return nil, err
+	}
+	name := aws.ToString(params.RoleName)
+	if _, ok := i.roles[name]; ok {
+		return nil, &types.EntityAlreadyExistsException{Message: aws.String(fmt.Sprintf("Role with name %s already exists.", name))}
+	}
+	role := types.Role{
+		RoleName:                 params.RoleName,
+		RoleId:                   aws.String(nextID("AROA")),
+		Arn:                      aws.String(fmt.Sprintf("arn:aws:iam::000000000000:role/%s", name)),
+		Path:                     aws.String("/"),
+		AssumeRolePolicyDocument: params.AssumeRolePolicyDocument,
+		CreateDate:               aws.Time(time.Now().UTC()),
+		Tags:                     params.Tags,
+	}
+	i.roles[name] = role
+	i.policies[name] = map[string]bool{}
+	return &iam.CreateRoleOutput{Role: &role}, nil
+}
+
+// DeleteRole deletes a role without attached policies
+func (i *IAM) DeleteRole(ctx context.Context, params *iam.DeleteRoleInput, optFns ...func(*iam.Options)) (*iam.DeleteRoleOutput, error) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	if err := i.call("DeleteRole"); err != nil {
+		return nil, err
+	}
+	name := aws.ToString(params.RoleName)
+	if _, ok := i.roles[name]; !ok {
+		return nil, noSuchEntity(name)
+	}
+	if len(i.policies[name]) > 0 {
+		return nil, &types.DeleteConflictException{Message: aws.String("Cannot delete entity, must detach all policies first.")}
+	}
+	delete(i.roles, name)
+	delete(i.policies, name)
+	return &iam.DeleteRoleOutput{}, nil
+}
+
+// AttachRolePolicy attaches a managed policy to a role
+func (i *IAM) AttachRolePolicy(ctx context.Context, params *iam.AttachRolePolicyInput, optFns ...func(*iam.Options)) (*iam.AttachRolePolicyOutput, error) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	if err := i.call("AttachRolePolicy"); err != nil {
+		return nil, err
+	}
+	name := aws.ToString(params.RoleName)
+	if _, ok := i.roles[name]; !ok {
+		return nil, noSuchEntity(name)
+	}
+	i.policies[name][aws.ToString(params.PolicyArn)] = true
+	return &iam.AttachRolePolicyOutput{}, nil
+}
+
+// DetachRolePolicy detaches a managed policy from a role
+func (i *IAM) DetachRolePolicy(ctx context.Context, params *iam.DetachRolePolicyInput, optFns ...func(*iam.Options)) (*iam.DetachRolePolicyOutput, error) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	if err := i.call("DetachRolePolicy"); err != nil {
+		return nil, err
+	}
+	name, policy := aws.ToString(params.RoleName), aws.ToString(params.PolicyArn)
+	if !i.policies[name][policy] {
+		return nil, &types.NoSuchEntityException{Message: aws.String(fmt.Sprintf("Policy %s was not found.", policy))}
+	}
+	delete(i.policies[name], policy)
+	return &iam.DetachRolePolicyOutput{}, nil
+}
+
+// ListAttachedRolePolicies lists the managed policies attached to a role in one page
+func (i *IAM) ListAttachedRolePolicies(ctx context.Context, params *iam.ListAttachedRolePoliciesInput, optFns ...func(*iam.Options)) (*iam.ListAttachedRolePoliciesOutput, error) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	if err := i.call("ListAttachedRolePolicies"); err != nil {
+		return nil, err
+	}
+	name := aws.ToString(params.RoleName)
+	if _, ok := i.roles[name]; !ok {
+		return nil, noSuchEntity(name)
+	}
+	arns := []string{}
+	for arn := range i.policies[name] {
+		arns = append(arns, arn)
+	}
+	sort.Strings(arns)
+	out := &iam.ListAttachedRolePoliciesOutput{}
+	for _, arn := range arns {
+		out.AttachedPolicies = append(out.AttachedPolicies, types.AttachedPolicy{PolicyArn: aws.String(arn)})
+	}
+	return out, nil
+}
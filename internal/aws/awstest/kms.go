@@ -0,0 +1,209 @@
+package awstest
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/kms"
+	"github.com/aws/aws-sdk-go-v2/service/kms/types"
+)
+
+// KMS is a fake awsapi.KMSClient
+type KMS struct {
+	Recorder
+	keys     map[string]types.KeyMetadata
+	aliases  map[string]string
+	rotation map[string]bool
+}
+
+// NewKMS returns an empty fake KMS client
+func NewKMS() *KMS {
+	return &KMS{
+		keys:     map[string]types.KeyMetadata{},
+		aliases:  map[string]string{},
+		rotation: map[string]bool{},
+	}
+}
+
+// SetRotation turns rotation of the key behind alias on or off outside of tidalwave
+func (k *KMS) SetRotation(alias string, enabled bool) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if id, ok := k.aliases[alias]; ok {
+		k.rotation[id] = enabled
+	}
+}
+
+// Count returns how many keys are enabled
+func (k *KMS) Count() map[string]int {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	enabled := 0
+	for _, key := range k.keys {
+		if key.KeyState == types.KeyStateEnabled {
+			enabled++
+		}
+	}
+	return map[string]int{"key": enabled}
+}
+
+// key finds a key by id, ARN or alias, it must be called with mu held
+func (k *KMS) key(id string) (types.KeyMetadata, error) {
+	if strings.HasPrefix(id, "alias/") {
+		id = k.aliases[id]
+	}
+	for _, key := range k.keys {
+		if aws.ToString(key.KeyId) == id || aws.ToString(key.Arn) == id {
+			return key, nil
+		}
+	}
+	return types.KeyMetadata{}, &types.NotFoundException{Message: aws.String(fmt.Sprintf("Key '%s' does not exist", id))}
+}
+
+// DescribeKey gets a key
+func (k *KMS) DescribeKey(ctx context.Context, params *kms.DescribeKeyInput, optFns ...func(*kms.Options)) (*kms.DescribeKeyOutput, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if err := k.call("DescribeKey"); err != nil {
+		return nil, err
+	}
+	key, err := k.key(aws.ToString(params.KeyId))
+	if err != nil {
+		return nil, err
+	}
+	return &kms.DescribeKeyOutput{KeyMetadata: &key}, nil
+}
+
+// CreateKey creates an enabled symmetric key
+func (k *KMS) CreateKey(ctx context.Context, params *kms.CreateKeyInput, optFns ...func(*kms.Options)) (*kms.CreateKeyOutput, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if err := k.call("CreateKey"); err != nil {
+		return nil, err
+	}
+	id := nextID("key")
+	key := types.KeyMetadata{
+		KeyId:        aws.String(id),
+		Arn:          aws.String(fmt.Sprintf("arn:aws:kms:us-east-1:000000000000:key/%s", id)),
+		Description:  params.Description,
+		CreationDate: aws.Time(time.Now().UTC()),
+		Enabled:      true,
+		KeyState:     types.KeyStateEnabled,
+		KeySpec:      types.KeySpecSymmetricDefault,
+		KeyUsage:     types.KeyUsageTypeEncryptDecrypt,
+	}
+	k.keys[id] = key
+	return &kms.CreateKeyOutput{KeyMetadata: &key}, nil
+}
+
+// CreateAlias points a new alias at a key
+func (k *KMS) CreateAlias(ctx context.Context, params *kms.CreateAliasInput, optFns ...func(*kms.Options)) (*kms.CreateAliasOutput, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if err := k.call("CreateAlias"); err != nil {
+		return nil, err
+	}
+	alias := aws.ToString(params.AliasName)
+	if _, ok := k.aliases[alias]; ok {
+		return nil, &types.AlreadyExistsException{Message: aws.String(fmt.Sprintf("An alias with the name %s already exists", alias))}
+	}
+	key, err := k.key(aws.ToString(params.TargetKeyId))
+	if err != nil {
+		return nil, err
+	}
+	k.aliases[alias] = aws.ToString(key.KeyId)
+	return &kms.CreateAliasOutput{}, nil
+}
+
+// UpdateAlias points an existing alias at another key
+func (k *KMS) UpdateAlias(ctx context.Context, params *kms.UpdateAliasInput, optFns ...func(*kms.Options)) (*kms.UpdateAliasOutput, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if err := k.call("UpdateAlias"); err != nil {
+		return nil, err
+	}
+	alias := aws.ToString(params.AliasName)
+	if _, ok := k.aliases[alias]; !ok {
+		return nil, &types.NotFoundException{Message: aws.String(fmt.Sprintf("Alias %s is not found", alias))}
+	}
+	key, err := k.key(aws.ToString(params.TargetKeyId))
+	if err != nil {
+		return nil, err
+	}
+	k.aliases[alias] = aws.ToString(key.KeyId)
+	return &kms.UpdateAliasOutput{}, nil
+}
+
+// DeleteAlias removes an alias, the key is left alone
+func (k *KMS) DeleteAlias(ctx context.Context, params *kms.DeleteAliasInput, optFns ...func(*kms.Options)) (*kms.DeleteAliasOutput, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if err := k.call("DeleteAlias"); err != nil {
+		return nil, err
+	}
+	alias := aws.ToString(params.AliasName)
+	if _, ok := k.aliases[alias]; !ok {
+		return nil, &types.NotFoundException{Message: aws.String(fmt.Sprintf("Alias %s is not found", alias))}
+	}
+	delete(k.aliases, alias)
+	return &kms.DeleteAliasOutput{}, nil
+}
+
+// ScheduleKeyDeletion marks a key pending deletion
+func (k *KMS) ScheduleKeyDeletion(ctx context.Context, params *kms.ScheduleKeyDeletionInput, optFns ...func(*kms.Options)) (*kms.ScheduleKeyDeletionOutput, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if err := k.call("ScheduleKeyDeletion"); err != nil {
+		return nil, err
+	}
+	key, err := k.key(aws.ToString(params.KeyId))
+	if err != nil {
+		return nil, err
+	}
+	days := aws.ToInt32(params.PendingWindowInDays)
+	if days == 0 {
+		days = 30
+	}
+	key.Enabled = false
+	key.KeyState = types.KeyStatePendingDeletion
+	key.DeletionDate = aws.Time(time.Now().UTC().AddDate(0, 0, int(days)))
+	k.keys[aws.ToString(key.KeyId)] = key
+	return &kms.ScheduleKeyDeletionOutput{
+		KeyId:               key.KeyId,
+		KeyState:            key.KeyState,
+		DeletionDate:        key.DeletionDate,
+		PendingWindowInDays: aws.Int32(days),
+	}, nil
+}
+
+// GetKeyRotationStatus reports whether a key is rotated
+func (k *KMS) GetKeyRotationStatus(ctx context.Context, params *kms.GetKeyRotationStatusInput, optFns ...func(*kms.Options)) (*kms.GetKeyRotationStatusOutput, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if err := k.call("GetKeyRotationStatus"); err != nil {
+		return nil, err
+	}
+	key, err := k.key(aws.ToString(params.KeyId))
+	if err != nil {
+		return nil, err
+	}
+	return &kms.GetKeyRotationStatusOutput{KeyRotationEnabled: k.rotation[aws.ToString(key.KeyId)]}, nil
+}
+
+// EnableKeyRotation turns on yearly rotation of a key
+func (k *KMS) EnableKeyRotation(ctx context.Context, params *kms.EnableKeyRotationInput, optFns ...func(*kms.Options)) (*kms.EnableKeyRotationOutput, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if err := k.call("EnableKeyRotation"); err != nil {
+		return nil, err
+	}
+	key, err := k.key(aws.ToString(params.KeyId))
+	if err != nil {
+		return nil, err
+	}
+	k.rotation[aws.ToString(key.KeyId)] = true
+	return &kms.EnableKeyRotationOutput{}, nil
+}
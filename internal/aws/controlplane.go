@@ -0,0 +1,582 @@
+/*
+Package aws provisions an EKS controlplane and the network, encryption key and IAM roles it
+depends on
+*/
+package aws
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"tidalwave/internal/aws/awsapi"
+	"tidalwave/internal/state"
+	"tidalwave/internal/tidalwave"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/eks"
+	"github.com/aws/aws-sdk-go-v2/service/iam"
+	"github.com/aws/aws-sdk-go-v2/service/kms"
+)
+
+// Controlplane contains values for an EKS cluster and its dependencies
+type Controlplane struct {
+	// State records the resources tidalwave created, nil disables tracking
+	State *state.Store
+	// Force deletes resources even if they are not recorded in State
+	Force bool
+	// Parallelism is the number of resources provisioned at once
+	Parallelism int
+	// Timeouts limit how long each kind of resource may take, keyed by vpc, gateway, nat,
+	// subnets, key, roles, securitygroup, cluster and nodegroup
+	Timeouts map[string]time.Duration
+	Region   string
+	// Endpoint overrides the endpoint of every AWS API, for running against a local stand-in
+	Endpoint string
+	// Zones the private subnets are spread across, the first available zones of the region are
+	// used if empty
+	Zones []string
+	Vpc
+	Gateway
+	Nat
+	Subnets
+	Key
+	Roles
+	Cluster
+	NodeGroup
+	SecurityGroups []SecurityGroup
+}
+
+// clients are the AWS API clients used by the controlplane, they are safe for concurrent use
+type clients struct {
+	ec2 awsapi.EC2Client
+	eks awsapi.EKSClient
+	kms awsapi.KMSClient
+	iam awsapi.IAMClient
+}
+
+func newClients(ctx context.Context, region, endpoint string) (*clients, error) {
+	opts := []func(*config.LoadOptions) error{config.WithRegion(region)}
+	if endpoint != "" {
+		resolver := aws.EndpointResolverWithOptionsFunc(func(service, region string, options ...interface{}) (aws.Endpoint, error) {
+			return aws.Endpoint{URL: endpoint, HostnameImmutable: true, SigningRegion: region}, nil
+		})
+		opts = append(opts, config.WithEndpointResolverWithOptions(resolver))
+	}
+	cfg, err := config.LoadDefaultConfig(ctx, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return &clients{
+		ec2: ec2.NewFromConfig(cfg),
+		eks: eks.NewFromConfig(cfg),
+		kms: kms.NewFromConfig(cfg),
+		iam: iam.NewFromConfig(cfg),
+	}, nil
+}
+
+// graph returns the controlplane resources and their dependencies. The key and roles do not
+// depend on the network so they are provisioned alongside the VPC.
+func (c *Controlplane) graph(cl *clients, update bool) (*tidalwave.Graph, error) {
+	verb := "created"
+	if update {
+		verb = "updated"
+	}
+	g := tidalwave.NewGraph(c.Parallelism)
+	securityGroups := []string{}
+	for _, s := range c.SecurityGroups {
+		securityGroups = append(securityGroups, "securitygroup/"+s.Name)
+	}
+	nodes := []tidalwave.Node{
+		{
+			Name: "vpc",
+			Create: func(ctx context.Context, in tidalwave.Outputs) (tidalwave.Outputs, error) {
+				zones := c.Zones
+				if len(zones) == 0 {
+					var err error
+					if zones, err = availableZones(ctx, cl.ec2, len(c.Subnets.Cidrs)); err != nil {
+						return nil, err
+					}
+				}
+				existed := c.Vpc.exists(ctx, cl.ec2)
+				apply := c.Vpc.create
+				if update {
+					apply = c.Vpc.update
+				}
+				vpc, err := apply(ctx, cl.ec2)
+				if err != nil {
+					return nil, err
+				}
+				if err := c.track(ctx, existed, vpcResource(vpc, &c.Vpc)); err != nil {
+					return nil, err
+				}
+				tidalwave.Infof(ctx, ":check_mark_button:", "Controlplane VPC %s", verb)
+				return tidalwave.Outputs{"id": aws.ToString(vpc.VpcId), "zones": strings.Join(zones, ",")}, nil
+			},
+			Delete: func(ctx context.Context) error {
+				return c.destroy(ctx, "vpc", c.Vpc.Name, func() (string, error) {
+					v, err := c.Vpc.get(ctx, cl.ec2)
+					if err != nil {
+						return "", err
+					}
+					return aws.ToString(v.VpcId), nil
+				}, func() error {
+					return c.Vpc.delete(ctx, cl.ec2)
+				})
+			},
+		},
+		{
+			Name: "gateway",
+			Deps: []string{"vpc"},
+			Create: func(ctx context.Context, in tidalwave.Outputs) (tidalwave.Outputs, error) {
+				c.Gateway.VpcID = in["vpc.id"]
+				c.Gateway.Zone, _, _ = strings.Cut(in["vpc.zones"], ",")
+				existed := c.Gateway.exists(ctx, cl.ec2)
+				apply := c.Gateway.create
+				if update {
+					apply = c.Gateway.update
+				}
+				subnet, err := apply(ctx, cl.ec2)
+				if err != nil {
+					return nil, err
+				}
+				if err := c.track(ctx, existed, gatewayResource(subnet, &c.Gateway)); err != nil {
+					return nil, err
+				}
+				tidalwave.Infof(ctx, ":check_mark_button:", "Controlplane internet gateway %s", verb)
+				return tidalwave.Outputs{"subnetId": aws.ToString(subnet.SubnetId)}, nil
+			},
+			Delete: func(ctx context.Context) error {
+				return c.destroy(ctx, "gateway", c.Gateway.Name, func() (string, error) {
+					s, err := c.Gateway.get(ctx, cl.ec2)
+					if err != nil {
+						return "", err
+					}
+					return aws.ToString(s.SubnetId), nil
+				}, func() error {
+					return c.Gateway.delete(ctx, cl.ec2)
+				})
+			},
+		},
+		{
+			Name: "nat",
+			Deps: []string{"vpc", "gateway"},
+			Create: func(ctx context.Context, in tidalwave.Outputs) (tidalwave.Outputs, error) {
+				c.Nat.VpcID = in["vpc.id"]
+				c.Nat.PublicSubnetID = in["gateway.subnetId"]
+				existed := c.Nat.exists(ctx, cl.ec2)
+				apply := c.Nat.create
+				if update {
+					apply = c.Nat.update
+				}
+				table, err := apply(ctx, cl.ec2)
+				if err != nil {
+					return nil, err
+				}
+				nat, err := c.Nat.get(ctx, cl.ec2)
+				if err != nil {
+					return nil, err
+				}
+				if err := c.track(ctx, existed, natResource(nat, &c.Nat)); err != nil {
+					return nil, err
+				}
+				tidalwave.Infof(ctx, ":check_mark_button:", "Controlplane NAT gateway %s", verb)
+				return tidalwave.Outputs{"routeTableId": aws.ToString(table.RouteTableId)}, nil
+			},
+			Delete: func(ctx context.Context) error {
+				return c.destroy(ctx, "nat", c.Nat.Name, func() (string, error) {
+					n, err := c.Nat.get(ctx, cl.ec2)
+					if err != nil {
+						return "", err
+					}
+					return aws.ToString(n.NatGatewayId), nil
+				}, func() error {
+					return c.Nat.delete(ctx, cl.ec2)
+				})
+			},
+		},
+		{
+			Name: "subnets",
+			Deps: []string{"vpc", "nat"},
+			Create: func(ctx context.Context, in tidalwave.Outputs) (tidalwave.Outputs, error) {
+				c.Subnets.VpcID = in["vpc.id"]
+				c.Subnets.RouteTableID = in["nat.routeTableId"]
+				c.Subnets.Zones = strings.Split(in["vpc.zones"], ",")
+				existed := c.Subnets.exists(ctx, cl.ec2)
+				apply := c.Subnets.create
+				if update {
+					apply = c.Subnets.update
+				}
+				subnets, err := apply(ctx, cl.ec2)
+				if err != nil {
+					return nil, err
+				}
+				if err := c.track(ctx, existed, subnetsResource(subnets, &c.Subnets)); err != nil {
+					return nil, err
+				}
+				tidalwave.Infof(ctx, ":check_mark_button:", "Controlplane private subnets %s", verb)
+				return tidalwave.Outputs{"ids": strings.Join(subnetIDs(subnets), ",")}, nil
+			},
+			Delete: func(ctx context.Context) error {
+				return c.destroy(ctx, "subnets", c.Subnets.Name, func() (string, error) {
+					s, err := c.Subnets.get(ctx, cl.ec2)
+					return strings.Join(subnetIDs(s), ","), err
+				}, func() error {
+					return c.Subnets.delete(ctx, cl.ec2)
+				})
+			},
+		},
+		{
+			Name: "key",
+			Create: func(ctx context.Context, in tidalwave.Outputs) (tidalwave.Outputs, error) {
+				existed := c.Key.exists(ctx, cl.kms)
+				apply := c.Key.create
+				if update {
+					apply = c.Key.update
+				}
+				key, err := apply(ctx, cl.kms)
+				if err != nil {
+					return nil, err
+				}
+				if err := c.track(ctx, existed, keyResource(key, &c.Key)); err != nil {
+					return nil, err
+				}
+				tidalwave.Infof(ctx, ":check_mark_button:", "Controlplane KMS key %s", verb)
+				return tidalwave.Outputs{"arn": aws.ToString(key.Arn)}, nil
+			},
+			Delete: func(ctx context.Context) error {
+				return c.destroy(ctx, "key", c.Key.Name, func() (string, error) {
+					k, err := c.Key.get(ctx, cl.kms)
+					if err != nil {
+						return "", err
+					}
+					return aws.ToString(k.KeyId), nil
+				}, func() error {
+					return c.Key.delete(ctx, cl.kms)
+				})
+			},
+		},
+		{
+			Name: "roles",
+			Create: func(ctx context.Context, in tidalwave.Outputs) (tidalwave.Outputs, error) {
+				existed := c.Roles.exists(ctx, cl.iam)
+				apply := c.Roles.create
+				if update {
+					apply = c.Roles.update
+				}
+				roles, err := apply(ctx, cl.iam)
+				if err != nil {
+					return nil, err
+				}
+				if err := c.track(ctx, existed, rolesResource(roles, &c.Roles)); err != nil {
+					return nil, err
+				}
+				tidalwave.Infof(ctx, ":check_mark_button:", "Controlplane IAM roles %s", verb)
+				return tidalwave.Outputs{"cluster": aws.ToString(roles[0].Arn), "node": aws.ToString(roles[1].Arn)}, nil
+			},
+			Delete: func(ctx context.Context) error {
+				return c.destroy(ctx, "roles", c.Roles.Name, func() (string, error) {
+					ids := []string{}
+					for _, role := range []role{c.Roles.cluster(), c.Roles.node()} {
+						r, err := role.get(ctx, cl.iam)
+						if err != nil {
+							return "", err
+						}
+						ids = append(ids, aws.ToString(r.RoleId))
+					}
+					return strings.Join(ids, ","), nil
+				}, func() error {
+					return c.Roles.delete(ctx, cl.iam)
+				})
+			},
+		},
+		{
+			Name: "cluster",
+			Deps: append([]string{"subnets", "key", "roles"}, securityGroups...),
+			Create: func(ctx context.Context, in tidalwave.Outputs) (tidalwave.Outputs, error) {
+				c.Cluster.SubnetIDs = strings.Split(in["subnets.ids"], ",")
+				c.Cluster.KeyArn = in["key.arn"]
+				c.Cluster.RoleArn = in["roles.cluster"]
+				c.Cluster.SecurityGroupIDs = []string{}
+				for _, s := range securityGroups {
+					c.Cluster.SecurityGroupIDs = append(c.Cluster.SecurityGroupIDs, in[s+".id"])
+				}
+				existed := c.Cluster.exists(ctx, cl.eks)
+				apply := c.Cluster.create
+				if update {
+					apply = c.Cluster.update
+				}
+				cluster, err := apply(ctx, cl.eks)
+				if err != nil {
+					return nil, err
+				}
+				if err := c.track(ctx, existed, clusterResource(cluster, &c.Cluster)); err != nil {
+					return nil, err
+				}
+				tidalwave.Infof(ctx, ":check_mark_button:", "Controlplane cluster %s", verb)
+				return tidalwave.Outputs{"arn": aws.ToString(cluster.Arn)}, nil
+			},
+			Delete: func(ctx context.Context) error {
+				return c.destroy(ctx, "cluster", c.Cluster.Name, func() (string, error) {
+					cluster, err := c.Cluster.get(ctx, cl.eks)
+					if err != nil {
+						return "", err
+					}
+					return clusterFingerprint(cluster), nil
+				}, func() error {
+					return c.Cluster.delete(ctx, cl.eks)
+				})
+			},
+		},
+		{
+			Name: "nodegroup",
+			Deps: []string{"cluster", "subnets", "roles"},
+			Create: func(ctx context.Context, in tidalwave.Outputs) (tidalwave.Outputs, error) {
+				c.NodeGroup.ClusterName = c.Cluster.Name
+				c.NodeGroup.SubnetIDs = strings.Split(in["subnets.ids"], ",")
+				c.NodeGroup.NodeRoleArn = in["roles.node"]
+				existed := c.NodeGroup.exists(ctx, cl.eks)
+				apply := c.NodeGroup.create
+				if update {
+					apply = c.NodeGroup.update
+				}
+				group, err := apply(ctx, cl.eks)
+				if err != nil {
+					return nil, err
+				}
+				if err := c.track(ctx, existed, nodeGroupResource(group, &c.NodeGroup)); err != nil {
+					return nil, err
+				}
+				tidalwave.Infof(ctx, ":check_mark_button:", "Controlplane node group %s", verb)
+				return tidalwave.Outputs{"arn": aws.ToString(group.NodegroupArn)}, nil
+			},
+			Delete: func(ctx context.Context) error {
+				c.NodeGroup.ClusterName = c.Cluster.Name
+				return c.destroy(ctx, "nodegroup", c.NodeGroup.Name, func() (string, error) {
+					group, err := c.NodeGroup.get(ctx, cl.eks)
+					if err != nil {
+						return "", err
+					}
+					return aws.ToString(group.NodegroupArn), nil
+				}, func() error {
+					return c.NodeGroup.delete(ctx, cl.eks)
+				})
+			},
+		},
+	}
+	for i := range c.SecurityGroups {
+		s := &c.SecurityGroups[i]
+		nodes = append(nodes, tidalwave.Node{
+			Name: fmt.Sprintf("securitygroup/%s", s.Name),
+			Deps: []string{"vpc"},
+			Create: func(ctx context.Context, in tidalwave.Outputs) (tidalwave.Outputs, error) {
+				s.VpcID = in["vpc.id"]
+				existed := s.exists(ctx, cl.ec2)
+				apply := s.create
+				if update {
+					apply = s.update
+				}
+				group, err := apply(ctx, cl.ec2)
+				if err != nil {
+					return nil, err
+				}
+				if err := c.track(ctx, existed, securityGroupResource(group, s)); err != nil {
+					return nil, err
+				}
+				tidalwave.Infof(ctx, ":check_mark_button:", "Controlplane security group %s %s", s.Name, verb)
+				return tidalwave.Outputs{"id": aws.ToString(group.GroupId)}, nil
+			},
+			Delete: func(ctx context.Context) error {
+				return c.destroy(ctx, "securitygroup", s.Name, func() (string, error) {
+					group, err := s.get(ctx, cl.ec2)
+					if err != nil {
+						return "", err
+					}
+					return aws.ToString(group.GroupId), nil
+				}, func() error {
+					return s.delete(ctx, cl.ec2)
+				})
+			},
+		})
+	}
+	for _, n := range nodes {
+		kind, _, _ := strings.Cut(n.Name, "/")
+		n.Timeout = c.Timeouts[kind]
+		if err := g.Add(n); err != nil {
+			return nil, err
+		}
+	}
+	return g, nil
+}
+
+// SetState records the resources the controlplane creates in store
+func (c *Controlplane) SetState(store *state.Store, force bool) {
+	c.State = store
+	c.Force = force
+}
+
+// EnableApis does nothing, AWS has no APIs to enable
+func (c *Controlplane) EnableApis(ctx context.Context) error {
+	return nil
+}
+
+// Create controlplane
+func (c *Controlplane) Create(ctx context.Context) error {
+	cl, err := newClients(ctx, c.Region, c.Endpoint)
+	if err != nil {
+		return err
+	}
+	g, err := c.graph(cl, false)
+	if err != nil {
+		return err
+	}
+	return g.Apply(ctx)
+}
+
+// Delete controlplane, only resources recorded in state are deleted
+func (c *Controlplane) Delete(ctx context.Context) error {
+	cl, err := newClients(ctx, c.Region, c.Endpoint)
+	if err != nil {
+		return err
+	}
+	g, err := c.graph(cl, false)
+	if err != nil {
+		return err
+	}
+	return g.Destroy(ctx)
+}
+
+// Update controlplane
+func (c *Controlplane) Update(ctx context.Context) error {
+	cl, err := newClients(ctx, c.Region, c.Endpoint)
+	if err != nil {
+		return err
+	}
+	g, err := c.graph(cl, true)
+	if err != nil {
+		return err
+	}
+	return g.Apply(ctx)
+}
+
+// Plan compares every resource with the config, nothing is changed
+func (c *Controlplane) Plan(ctx context.Context) ([]tidalwave.ResourcePlan, error) {
+	cl, err := newClients(ctx, c.Region, c.Endpoint)
+	if err != nil {
+		return nil, err
+	}
+	return c.plan(ctx, cl)
+}
+
+// plan diffs every resource using cl. The VPC, cluster and node group are compared field by
+// field, the rest only by whether they exist. The IDs the cluster is compared with are read
+// from the live subnets, security groups and key.
+func (c *Controlplane) plan(ctx context.Context, cl *clients) ([]tidalwave.ResourcePlan, error) {
+	plan := []tidalwave.ResourcePlan{}
+	add := func(p *tidalwave.ResourcePlan, err error) error {
+		if err != nil {
+			return err
+		}
+		plan = append(plan, *p)
+		return nil
+	}
+
+	if err := add(c.Vpc.diff(ctx, cl.ec2)); err != nil {
+		return nil, err
+	}
+	_, err := c.Gateway.get(ctx, cl.ec2)
+	if err := add(existence("gateway", c.Gateway.Name, err)); err != nil {
+		return nil, err
+	}
+	_, err = c.Nat.get(ctx, cl.ec2)
+	if err := add(existence("nat", c.Nat.Name, err)); err != nil {
+		return nil, err
+	}
+	subnets, err := c.Subnets.get(ctx, cl.ec2)
+	if err := add(existence("subnets", c.Subnets.Name, err)); err != nil {
+		return nil, err
+	}
+	c.Cluster.SubnetIDs = subnetIDs(subnets)
+	key, err := c.Key.get(ctx, cl.kms)
+	if err := add(existence("key", c.Key.Name, err)); err != nil {
+		return nil, err
+	}
+	if key != nil {
+		c.Cluster.KeyArn = aws.ToString(key.Arn)
+	}
+	err = nil
+	for _, role := range []role{c.Roles.cluster(), c.Roles.node()} {
+		if _, err = role.get(ctx, cl.iam); err != nil {
+			break
+		}
+	}
+	if err := add(existence("roles", c.Roles.Name, err)); err != nil {
+		return nil, err
+	}
+	c.Cluster.SecurityGroupIDs = []string{}
+	for i := range c.SecurityGroups {
+		s := &c.SecurityGroups[i]
+		group, err := s.get(ctx, cl.ec2)
+		if err := add(existence("securitygroup", s.Name, err)); err != nil {
+			return nil, err
+		}
+		if group != nil {
+			c.Cluster.SecurityGroupIDs = append(c.Cluster.SecurityGroupIDs, aws.ToString(group.GroupId))
+		}
+	}
+
+	if err := add(c.Cluster.diff(ctx, cl.eks)); err != nil {
+		return nil, err
+	}
+	c.NodeGroup.ClusterName = c.Cluster.Name
+	if err := add(c.NodeGroup.diff(ctx, cl.eks)); err != nil {
+		return nil, err
+	}
+	return plan, nil
+}
+
+// Credentials returns the endpoint and CA certificate of the EKS cluster with aws eks get-token as
+// the credential plugin. Short-lived tokens are not supported, the credential plugin is always
+// used.
+func (c *Controlplane) Credentials(ctx context.Context, token bool) (*tidalwave.ClusterCredentials, error) {
+	if token {
+		return nil, fmt.Errorf("token: %w", tidalwave.ErrUnsupported)
+	}
+	cl, err := newClients(ctx, c.Region, c.Endpoint)
+	if err != nil {
+		return nil, err
+	}
+	return c.credentials(ctx, cl)
+}
+
+// credentials returns the credentials of the cluster using cl
+func (c *Controlplane) credentials(ctx context.Context, cl *clients) (*tidalwave.ClusterCredentials, error) {
+	cluster, err := c.Cluster.get(ctx, cl.eks)
+	if err != nil {
+		return nil, fmt.Errorf("cluster %s: %w", c.Cluster.Name, err)
+	}
+	creds := &tidalwave.ClusterCredentials{
+		Exec: &tidalwave.ExecCredential{
+			APIVersion:  "client.authentication.k8s.io/v1beta1",
+			Command:     "aws",
+			Args:        []string{"eks", "get-token", "--cluster-name", c.Cluster.Name, "--region", c.Region},
+			InstallHint: "Install the AWS CLI v2, see https://docs.aws.amazon.com/cli/latest/userguide/getting-started-install.html",
+		},
+	}
+	if cluster.CertificateAuthority != nil {
+		creds.CertificateAuthority = aws.ToString(cluster.CertificateAuthority.Data)
+	}
+	// EKS serves the public and private endpoint on the same hostname
+	endpoint := aws.ToString(cluster.Endpoint)
+	if vpc := cluster.ResourcesVpcConfig; vpc != nil {
+		if vpc.EndpointPublicAccess {
+			creds.Endpoint = endpoint
+		}
+		if vpc.EndpointPrivateAccess {
+			creds.PrivateEndpoint = endpoint
+		}
+	}
+	return creds, nil
+}
@@ -0,0 +1,644 @@
+package aws
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"tidalwave/internal/aws/awstest"
+	"tidalwave/internal/tidalwave"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	ec2types "github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	ekstypes "github.com/aws/aws-sdk-go-v2/service/eks/types"
+	"github.com/aws/aws-sdk-go-v2/service/kms"
+	kmstypes "github.com/aws/aws-sdk-go-v2/service/kms/types"
+)
+
+// fakes are the in-memory clients behind a test controlplane
+type fakes struct {
+	ec2 *awstest.EC2
+	eks *awstest.EKS
+	kms *awstest.KMS
+	iam *awstest.IAM
+}
+
+func newFakes() *fakes {
+	return &fakes{
+		ec2: awstest.NewEC2(),
+		eks: awstest.NewEKS(),
+		kms: awstest.NewKMS(),
+		iam: awstest.NewIAM(),
+	}
+}
+
+func (f *fakes) clients() *clients {
+	return &clients{ec2: f.ec2, eks: f.eks, kms: f.kms, iam: f.iam}
+}
+
+func (f *fakes) recorders() map[string]*awstest.Recorder {
+	return map[string]*awstest.Recorder{
+		"ec2": &f.ec2.Recorder,
+		"eks": &f.eks.Recorder,
+		"kms": &f.kms.Recorder,
+		"iam": &f.iam.Recorder,
+	}
+}
+
+func (f *fakes) reset() {
+	for _, r := range f.recorders() {
+		r.Reset()
+	}
+}
+
+// mutations returns every non-read call keyed by client
+func (f *fakes) mutations() map[string][]string {
+	m := map[string][]string{}
+	for name, r := range f.recorders() {
+		if calls := r.Mutations(); len(calls) > 0 {
+			m[name] = calls
+		}
+	}
+	return m
+}
+
+// count returns how many resources of each kind exist across every fake
+func (f *fakes) count() map[string]int {
+	total := map[string]int{}
+	for _, counts := range []map[string]int{f.ec2.Count(), f.eks.Count(), f.kms.Count(), f.iam.Count()} {
+		for kind, n := range counts {
+			total[kind] += n
+		}
+	}
+	return total
+}
+
+func testControlplane() *Controlplane {
+	return &Controlplane{
+		Parallelism: 1,
+		Region:      "us-east-1",
+		Vpc:         Vpc{Name: "test", Cidr: "10.0.0.0/16"},
+		Gateway:     Gateway{Name: "test", Cidr: "10.0.96.0/24"},
+		Nat:         Nat{Name: "test"},
+		Subnets:     Subnets{Name: "test", Cidrs: []string{"10.0.0.0/19", "10.0.32.0/19", "10.0.64.0/19"}},
+		Key:         Key{Name: "test"},
+		Roles:       Roles{Name: "test"},
+		Cluster:     Cluster{Name: "test", PublicAccessCidrs: []string{"0.0.0.0/0"}},
+		NodeGroup: NodeGroup{
+			Name:          "default-pool",
+			InstanceTypes: []string{"m5.large"},
+			MinSize:       1,
+			MaxSize:       3,
+		},
+		SecurityGroups: []SecurityGroup{
+			{
+				Name:         "test-webhooks",
+				Description:  "webhooks",
+				Controlplane: "test",
+				Ingress:      []Rule{{Protocol: "tcp", Port: 8443, Cidrs: []string{"10.0.0.0/16"}}},
+			},
+		},
+	}
+}
+
+func apply(t *testing.T, c *Controlplane, f *fakes, update bool) error {
+	t.Helper()
+	g, err := c.graph(f.clients(), update)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return g.Apply(context.Background())
+}
+
+func destroy(t *testing.T, c *Controlplane, f *fakes) error {
+	t.Helper()
+	g, err := c.graph(f.clients(), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return g.Destroy(context.Background())
+}
+
+func TestCreateIsIdempotent(t *testing.T) {
+	for _, update := range []bool{false, true} {
+		f := newFakes()
+		c := testControlplane()
+		if err := apply(t, c, f, false); err != nil {
+			t.Fatal(err)
+		}
+		want := map[string]int{
+			"vpc": 1, "subnet": 4, "internet-gateway": 1, "route-table": 2, "address": 1,
+			"natgateway": 1, "security-group": 1, "cluster": 1, "nodegroup": 1, "key": 1, "role": 2,
+		}
+		for kind, n := range f.count() {
+			if n != want[kind] {
+				t.Errorf("%d %s created, want %d", n, kind, want[kind])
+			}
+		}
+
+		f.reset()
+		if err := apply(t, c, f, update); err != nil {
+			t.Fatal(err)
+		}
+		if got := f.mutations(); len(got) != 0 {
+			t.Errorf("update=%t: second apply made calls %v", update, got)
+		}
+	}
+}
+
+func TestCreateCluster(t *testing.T) {
+	f := newFakes()
+	c := testControlplane()
+	if err := apply(t, c, f, false); err != nil {
+		t.Fatal(err)
+	}
+	cluster, err := c.Cluster.get(context.Background(), f.eks)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !cluster.ResourcesVpcConfig.EndpointPrivateAccess {
+		t.Error("cluster endpoint is not private")
+	}
+	if len(cluster.ResourcesVpcConfig.SubnetIds) != 3 {
+		t.Errorf("cluster subnets %v, want one per zone", cluster.ResourcesVpcConfig.SubnetIds)
+	}
+	if len(cluster.EncryptionConfig) != 1 || aws.ToString(cluster.EncryptionConfig[0].Provider.KeyArn) == "" {
+		t.Errorf("cluster secrets are not encrypted: %+v", cluster.EncryptionConfig)
+	}
+	if got := cluster.ResourcesVpcConfig.SecurityGroupIds; len(got) != 1 || !strings.HasPrefix(got[0], "sg-") {
+		t.Errorf("cluster security groups %v, want the webhooks group", got)
+	}
+}
+
+func TestSecurityGroupEgress(t *testing.T) {
+	ctx := context.Background()
+	f := newFakes()
+	allowAll := ec2types.IpPermission{IpProtocol: aws.String("-1"), IpRanges: []ec2types.IpRange{{CidrIp: aws.String("0.0.0.0/0")}}}
+	for _, tc := range []struct {
+		name   string
+		egress []Rule
+		want   []permission
+	}{
+		{
+			name: "no egress",
+		},
+		{
+			name:   "https to the vpc",
+			egress: []Rule{{Protocol: "tcp", Port: 443, Cidrs: []string{"10.0.0.0/16"}}},
+			want:   []permission{{protocol: "tcp", from: 443, to: 443, cidr: "10.0.0.0/16"}},
+		},
+		{
+			name:   "every port to the vpc",
+			egress: []Rule{{Protocol: "tcp", Cidrs: []string{"10.0.0.0/16"}}},
+			want:   []permission{{protocol: "-1", cidr: "10.0.0.0/16"}},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			s := &SecurityGroup{Name: "test-" + strings.ReplaceAll(tc.name, " ", "-"), Description: tc.name, VpcID: "vpc-test", Controlplane: "test", Egress: tc.egress}
+			group, err := s.create(ctx, f.ec2)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := existingPermissions(group.IpPermissionsEgress)
+			if len(got) != len(tc.want) {
+				t.Errorf("egress %+v, want %+v", group.IpPermissionsEgress, tc.want)
+			}
+			for _, p := range tc.want {
+				if !got[p] {
+					t.Errorf("egress %+v, want %+v", group.IpPermissionsEgress, tc.want)
+				}
+			}
+
+			// the allow all rule is revoked again if it is added back
+			f.ec2.ModifySecurityGroup(s.Name, func(g *ec2types.SecurityGroup) {
+				g.IpPermissionsEgress = append(g.IpPermissionsEgress, allowAll)
+			})
+			f.reset()
+			if _, err := s.update(ctx, f.ec2); err != nil {
+				t.Fatal(err)
+			}
+			if got := f.ec2.Mutations(); strings.Join(got, ",") != "RevokeSecurityGroupEgress" {
+				t.Errorf("update made calls %v, want RevokeSecurityGroupEgress", got)
+			}
+		})
+	}
+}
+
+func TestKeyPendingDeletion(t *testing.T) {
+	ctx := context.Background()
+	for _, tc := range []struct {
+		name   string
+		delete func(t *testing.T, k *Key, f *fakes)
+		want   []string
+	}{
+		{
+			name: "deleted by tidalwave",
+			delete: func(t *testing.T, k *Key, f *fakes) {
+				if err := k.delete(ctx, f.kms); err != nil {
+					t.Fatal(err)
+				}
+			},
+			want: []string{"CreateKey", "CreateAlias", "EnableKeyRotation"},
+		},
+		{
+			name: "deletion scheduled outside of tidalwave",
+			delete: func(t *testing.T, k *Key, f *fakes) {
+				if _, err := f.kms.ScheduleKeyDeletion(ctx, &kms.ScheduleKeyDeletionInput{KeyId: aws.String(k.alias())}); err != nil {
+					t.Fatal(err)
+				}
+			},
+			want: []string{"CreateKey", "UpdateAlias", "EnableKeyRotation"},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			f := newFakes()
+			k := &Key{Name: "test"}
+			old, err := k.create(ctx, f.kms)
+			if err != nil {
+				t.Fatal(err)
+			}
+			tc.delete(t, k, f)
+			if k.exists(ctx, f.kms) {
+				t.Fatal("a key pending deletion should not exist")
+			}
+
+			f.reset()
+			key, err := k.update(ctx, f.kms)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := f.kms.Mutations(); strings.Join(got, ",") != strings.Join(tc.want, ",") {
+				t.Errorf("update made calls %v, want %v", got, tc.want)
+			}
+			if aws.ToString(key.KeyId) == aws.ToString(old.KeyId) || key.KeyState != kmstypes.KeyStateEnabled {
+				t.Errorf("alias points at %s %s, want a new enabled key", aws.ToString(key.KeyId), key.KeyState)
+			}
+		})
+	}
+}
+
+func TestNodeGroupDesiredSize(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		desired  int32
+		min, max int32
+		want     int32
+	}{
+		{name: "within the new bounds", desired: 2, min: 1, max: 5, want: 2},
+		{name: "above the new maximum", desired: 3, min: 1, max: 2, want: 2},
+		{name: "below the new minimum", desired: 1, min: 2, max: 3, want: 2},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			f := newFakes()
+			c := testControlplane()
+			if err := apply(t, c, f, false); err != nil {
+				t.Fatal(err)
+			}
+			f.eks.ModifyNodegroup("test", "default-pool", func(n *ekstypes.Nodegroup) { n.ScalingConfig.DesiredSize = aws.Int32(tc.desired) })
+			c = testControlplane()
+			c.NodeGroup.MinSize, c.NodeGroup.MaxSize = tc.min, tc.max
+			if err := apply(t, c, f, true); err != nil {
+				t.Fatal(err)
+			}
+			group, err := c.NodeGroup.get(context.Background(), f.eks)
+			if err != nil {
+				t.Fatal(err)
+			}
+			scaling := group.ScalingConfig
+			if aws.ToInt32(scaling.MinSize) != tc.min || aws.ToInt32(scaling.MaxSize) != tc.max || aws.ToInt32(scaling.DesiredSize) != tc.want {
+				t.Errorf("scaling %d-%d desired %d, want %d-%d desired %d", aws.ToInt32(scaling.MinSize), aws.ToInt32(scaling.MaxSize), aws.ToInt32(scaling.DesiredSize), tc.min, tc.max, tc.want)
+			}
+		})
+	}
+}
+
+func TestMissingRouteTable(t *testing.T) {
+	for _, tc := range []struct {
+		table string
+		want  []string
+	}{
+		{table: "test-public", want: []string{"CreateRouteTable", "CreateRoute", "AssociateRouteTable"}},
+		{table: "test-private", want: []string{"CreateRouteTable", "CreateRoute", "AssociateRouteTable", "AssociateRouteTable", "AssociateRouteTable"}},
+	} {
+		t.Run(tc.table, func(t *testing.T) {
+			ctx := context.Background()
+			f := newFakes()
+			c := testControlplane()
+			if err := apply(t, c, f, false); err != nil {
+				t.Fatal(err)
+			}
+			f.ec2.RemoveRouteTable(tc.table)
+			f.reset()
+			if err := apply(t, c, f, true); err != nil {
+				t.Fatal(err)
+			}
+			if got := f.mutations(); strings.Join(got["ec2"], ",") != strings.Join(tc.want, ",") || len(got) != 1 {
+				t.Errorf("update made calls %v, want ec2 calls %v", got, tc.want)
+			}
+			table, err := routeTable(ctx, f.ec2, tc.table)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(table.Routes) != 1 || aws.ToString(table.Routes[0].DestinationCidrBlock) != "0.0.0.0/0" {
+				t.Errorf("routes %+v, want a default route", table.Routes)
+			}
+
+			f.reset()
+			if err := apply(t, c, f, true); err != nil {
+				t.Fatal(err)
+			}
+			if got := f.mutations(); len(got) != 0 {
+				t.Errorf("route table was not fixed, the next update made calls %v", got)
+			}
+		})
+	}
+}
+
+func TestUpdateDrift(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		drift  func(f *fakes)
+		client string
+		want   []string
+	}{
+		{
+			name: "security group rule removed",
+			drift: func(f *fakes) {
+				f.ec2.ModifySecurityGroup("test-webhooks", func(g *ec2types.SecurityGroup) { g.IpPermissions = nil })
+			},
+			client: "ec2",
+			want:   []string{"AuthorizeSecurityGroupIngress"},
+		},
+		{
+			name: "security group rule added",
+			drift: func(f *fakes) {
+				f.ec2.ModifySecurityGroup("test-webhooks", func(g *ec2types.SecurityGroup) {
+					g.IpPermissions = append(g.IpPermissions, ec2types.IpPermission{
+						IpProtocol: aws.String("tcp"),
+						FromPort:   aws.Int32(22),
+						ToPort:     aws.Int32(22),
+						IpRanges:   []ec2types.IpRange{{CidrIp: aws.String("0.0.0.0/0")}},
+					})
+				})
+			},
+			client: "ec2",
+			want:   []string{"RevokeSecurityGroupIngress"},
+		},
+		{
+			name: "key rotation disabled",
+			drift: func(f *fakes) {
+				f.kms.SetRotation("alias/test", false)
+			},
+			client: "kms",
+			want:   []string{"EnableKeyRotation"},
+		},
+		{
+			name: "role policy detached",
+			drift: func(f *fakes) {
+				f.iam.DetachPolicy("test-node", "arn:aws:iam::aws:policy/AmazonEKS_CNI_Policy")
+			},
+			client: "iam",
+			want:   []string{"AttachRolePolicy"},
+		},
+		{
+			name: "cluster endpoint access",
+			drift: func(f *fakes) {
+				f.eks.ModifyCluster("test", func(c *ekstypes.Cluster) { c.ResourcesVpcConfig.PublicAccessCidrs = []string{"192.0.2.0/24"} })
+			},
+			client: "eks",
+			want:   []string{"UpdateClusterConfig"},
+		},
+		{
+			name: "node group scaling",
+			drift: func(f *fakes) {
+				f.eks.ModifyNodegroup("test", "default-pool", func(n *ekstypes.Nodegroup) {
+					n.ScalingConfig = &ekstypes.NodegroupScalingConfig{MinSize: aws.Int32(1), MaxSize: aws.Int32(10), DesiredSize: aws.Int32(5)}
+				})
+			},
+			client: "eks",
+			want:   []string{"UpdateNodegroupConfig"},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			f := newFakes()
+			c := testControlplane()
+			if err := apply(t, c, f, false); err != nil {
+				t.Fatal(err)
+			}
+			tc.drift(f)
+			f.reset()
+			if err := apply(t, c, f, true); err != nil {
+				t.Fatal(err)
+			}
+			got := f.mutations()
+			if strings.Join(got[tc.client], ",") != strings.Join(tc.want, ",") {
+				t.Errorf("%s calls %v, want %v", tc.client, got[tc.client], tc.want)
+			}
+			for client, calls := range got {
+				if client != tc.client {
+					t.Errorf("unexpected %s calls %v", client, calls)
+				}
+			}
+			f.reset()
+			if err := apply(t, c, f, true); err != nil {
+				t.Fatal(err)
+			}
+			if got := f.mutations(); len(got) != 0 {
+				t.Errorf("drift was not fixed, the next update made calls %v", got)
+			}
+		})
+	}
+}
+
+func TestUpdateForceNew(t *testing.T) {
+	f := newFakes()
+	c := testControlplane()
+	if err := apply(t, c, f, false); err != nil {
+		t.Fatal(err)
+	}
+	c.NodeGroup.InstanceTypes = []string{"m5.xlarge"}
+	f.reset()
+	err := apply(t, c, f, true)
+	if err == nil || !strings.Contains(err.Error(), "deleted and recreated") {
+		t.Fatalf("got %v, want a replacement error", err)
+	}
+	if calls := f.mutations()["eks"]; len(calls) != 0 {
+		t.Errorf("eks calls %v after a force new change", calls)
+	}
+}
+
+func TestPlan(t *testing.T) {
+	ctx := context.Background()
+	f := newFakes()
+	c := testControlplane()
+	plan, err := c.plan(ctx, f.clients())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range plan {
+		if p.Action != tidalwave.ActionCreate {
+			t.Errorf("%s %s: %s before create", p.Kind, p.Name, p.Action)
+		}
+	}
+	if len(plan) != 9 {
+		t.Errorf("plan has %d resources, want 9", len(plan))
+	}
+
+	if err := apply(t, c, f, false); err != nil {
+		t.Fatal(err)
+	}
+	plan, err = testControlplane().plan(ctx, f.clients())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range plan {
+		if p.Action != tidalwave.ActionNoop {
+			t.Errorf("%s %s: %s %+v after create", p.Kind, p.Name, p.Action, p.Changes)
+		}
+	}
+
+	f.eks.ModifyCluster("test", func(c *ekstypes.Cluster) { c.ResourcesVpcConfig.PublicAccessCidrs = []string{"192.0.2.0/24"} })
+	f.eks.ModifyNodegroup("test", "default-pool", func(n *ekstypes.Nodegroup) { n.InstanceTypes = []string{"m5.xlarge"} })
+	f.reset()
+	plan, err = testControlplane().plan(ctx, f.clients())
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := map[string]tidalwave.Action{}
+	for _, p := range plan {
+		got[p.Kind] = p.Action
+	}
+	if got["cluster"] != tidalwave.ActionUpdate || got["nodegroup"] != tidalwave.ActionReplace || got["vpc"] != tidalwave.ActionNoop {
+		t.Errorf("unexpected actions %v", got)
+	}
+	if m := f.mutations(); len(m) != 0 {
+		t.Errorf("plan made calls %v", m)
+	}
+}
+
+func TestStatus(t *testing.T) {
+	ctx := context.Background()
+	f := newFakes()
+	c := testControlplane()
+	if err := apply(t, c, f, false); err != nil {
+		t.Fatal(err)
+	}
+	status, err := testControlplane().status(ctx, f.clients())
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := map[string]tidalwave.ResourceStatus{}
+	for _, s := range status {
+		if !s.Exists || !s.InSync {
+			t.Errorf("%s %s: exists %t, in sync %t", s.Kind, s.Name, s.Exists, s.InSync)
+		}
+		got[s.Kind] = s
+	}
+	if s := got["cluster"]; s.Attributes["status"] != "ACTIVE" {
+		t.Errorf("cluster attributes %v", s.Attributes)
+	}
+	if s := got["nodegroup"]; s.Attributes["scaling"] != "1-3" || s.Attributes["instanceTypes"] != "m5.large" {
+		t.Errorf("node group attributes %v", s.Attributes)
+	}
+	if s := got["vpc"]; s.Attributes["cidr"] != "10.0.0.0/16" {
+		t.Errorf("vpc attributes %v", s.Attributes)
+	}
+}
+
+func TestDelete(t *testing.T) {
+	f := newFakes()
+	c := testControlplane()
+	if err := apply(t, c, f, false); err != nil {
+		t.Fatal(err)
+	}
+	if err := destroy(t, c, f); err != nil {
+		t.Fatal(err)
+	}
+	for kind, n := range f.count() {
+		if n != 0 {
+			t.Errorf("%d %s left after delete", n, kind)
+		}
+	}
+	// the second delete finds nothing left
+	f.reset()
+	if err := destroy(t, c, f); err != nil {
+		t.Fatal(err)
+	}
+	if got := f.mutations(); len(got) != 0 {
+		t.Errorf("second delete made calls %v", got)
+	}
+}
+
+func TestApplyErrors(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		fail   func(f *fakes, err error)
+		node   string
+		update bool
+	}{
+		{
+			name: "vpc create",
+			fail: func(f *fakes, err error) { f.ec2.Fail("CreateVpc", err) },
+			node: "vpc",
+		},
+		{
+			name: "nat gateway create",
+			fail: func(f *fakes, err error) { f.ec2.Fail("CreateNatGateway", err) },
+			node: "nat",
+		},
+		{
+			name: "cluster create",
+			fail: func(f *fakes, err error) { f.eks.Fail("CreateCluster", err) },
+			node: "cluster",
+		},
+		{
+			name:   "role get during update",
+			fail:   func(f *fakes, err error) { f.iam.Fail("GetRole", err) },
+			node:   "roles",
+			update: true,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			f := newFakes()
+			c := testControlplane()
+			tc.fail(f, fmt.Errorf("limit exceeded"))
+			err := apply(t, c, f, tc.update)
+			if err == nil || !strings.Contains(err.Error(), tc.node) || !strings.Contains(err.Error(), "limit exceeded") {
+				t.Errorf("got %v, want the %s error", err, tc.node)
+			}
+		})
+	}
+}
+
+func TestEndpointOverride(t *testing.T) {
+	t.Setenv("AWS_ACCESS_KEY_ID", "test")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "test")
+	var action string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		action = string(b)
+		w.Header().Set("Content-Type", "text/xml")
+		fmt.Fprint(w, `<DescribeAvailabilityZonesResponse xmlns="http://ec2.amazonaws.com/doc/2016-11-15/">
+<availabilityZoneInfo><item><zoneName>us-east-1a</zoneName><zoneState>available</zoneState></item></availabilityZoneInfo>
+</DescribeAvailabilityZonesResponse>`)
+	}))
+	defer srv.Close()
+
+	cl, err := newClients(context.Background(), "us-east-1", srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zones, err := availableZones(context.Background(), cl.ec2, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(action, "Action=DescribeAvailabilityZones") {
+		t.Errorf("local endpoint got %q, want DescribeAvailabilityZones", action)
+	}
+	if len(zones) != 1 || zones[0] != "us-east-1a" {
+		t.Errorf("zones %v, want us-east-1a", zones)
+	}
+}
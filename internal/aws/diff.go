@@ -0,0 +1,69 @@
+package aws
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"tidalwave/internal/tidalwave"
+)
+
+// differ collects the changes between a live resource and the config
+type differ struct {
+	changes []tidalwave.Change
+}
+
+// field records a change if the observed and desired values differ
+func (d *differ) field(name, observed, desired string) {
+	if observed != desired {
+		d.changes = append(d.changes, tidalwave.Change{
+			Field:    name,
+			Observed: observed,
+			Desired:  desired,
+		})
+	}
+}
+
+// forceNew records a change to a field that cannot be updated in place
+func (d *differ) forceNew(name, observed, desired string) {
+	if observed != desired {
+		d.changes = append(d.changes, tidalwave.Change{
+			Field:    name,
+			Observed: observed,
+			Desired:  desired,
+			ForceNew: true,
+		})
+	}
+}
+
+// forceNewError returns an error naming the fields that cannot be changed without replacing the resource
+func forceNewError(kind, name string, changes []tidalwave.Change) error {
+	fields := []string{}
+	for _, c := range changes {
+		if c.ForceNew {
+			fields = append(fields, fmt.Sprintf("%s from %s to %s", c.Field, c.Observed, c.Desired))
+		}
+	}
+	if len(fields) == 0 {
+		return nil
+	}
+	return fmt.Errorf("%s %s must be deleted and recreated to change %s", kind, name, strings.Join(fields, ", "))
+}
+
+// existence plans a resource that is only compared by whether it exists, err is the error of
+// looking it up
+func existence(kind, name string, err error) (*tidalwave.ResourcePlan, error) {
+	if isNotFound(err) {
+		return tidalwave.NewResourcePlan(kind, name, false, nil), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return tidalwave.NewResourcePlan(kind, name, true, nil), nil
+}
+
+// list joins values in sorted order so sets compare equal whatever their order
+func list(values []string) string {
+	sorted := append([]string{}, values...)
+	sort.Strings(sorted)
+	return strings.Join(sorted, ",")
+}
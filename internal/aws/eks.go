@@ -0,0 +1,335 @@
+package aws
+
+import (
+	"context"
+	"fmt"
+	"tidalwave/internal/aws/awsapi"
+	"tidalwave/internal/tidalwave"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/eks"
+	"github.com/aws/aws-sdk-go-v2/service/eks/types"
+)
+
+// Cluster represents an EKS cluster with a private endpoint and its secrets encrypted with a
+// KMS key
+type Cluster struct {
+	Name    string
+	Version string
+	RoleArn string
+	KeyArn  string
+	// SubnetIDs are the private subnets the controlplane attaches network interfaces to
+	SubnetIDs []string
+	// SecurityGroupIDs are attached to the controlplane network interfaces besides the
+	// security group EKS creates
+	SecurityGroupIDs []string
+	// PublicAccessCidrs may reach the public endpoint, it is disabled if empty
+	PublicAccessCidrs []string
+}
+
+func (c *Cluster) vpcConfig() *types.VpcConfigRequest {
+	config := &types.VpcConfigRequest{
+		EndpointPrivateAccess: aws.Bool(true),
+		EndpointPublicAccess:  aws.Bool(len(c.PublicAccessCidrs) > 0),
+	}
+	if len(c.PublicAccessCidrs) > 0 {
+		config.PublicAccessCidrs = c.PublicAccessCidrs
+	}
+	return config
+}
+
+// Create cluster
+func (c *Cluster) create(ctx context.Context, client awsapi.EKSClient) (*types.Cluster, error) {
+	if c.exists(ctx, client) {
+		return c.get(ctx, client)
+	}
+	vpcConfig := c.vpcConfig()
+	vpcConfig.SubnetIds = c.SubnetIDs
+	vpcConfig.SecurityGroupIds = c.SecurityGroupIDs
+	input := &eks.CreateClusterInput{
+		Name:               aws.String(c.Name),
+		RoleArn:            aws.String(c.RoleArn),
+		ResourcesVpcConfig: vpcConfig,
+		EncryptionConfig: []types.EncryptionConfig{
+			{
+				Provider:  &types.Provider{KeyArn: aws.String(c.KeyArn)},
+				Resources: []string{"secrets"},
+			},
+		},
+		Tags: map[string]string{ControlplaneTag: c.Name},
+	}
+	if c.Version != "" {
+		input.Version = aws.String(c.Version)
+	}
+	if _, err := client.CreateCluster(ctx, input); err != nil {
+		return nil, err
+	}
+	err := waitFor(ctx, c.Name, fmt.Sprintf("create cluster %s", c.Name), func(max time.Duration) error {
+		return eks.NewClusterActiveWaiter(client).Wait(ctx, &eks.DescribeClusterInput{Name: aws.String(c.Name)}, max)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return c.get(ctx, client)
+}
+
+// Get cluster
+func (c *Cluster) get(ctx context.Context, client awsapi.EKSClient) (*types.Cluster, error) {
+	resp, err := client.DescribeCluster(ctx, &eks.DescribeClusterInput{Name: aws.String(c.Name)})
+	if err != nil {
+		return nil, err
+	}
+	return resp.Cluster, nil
+}
+
+// Check if cluster exists
+func (c *Cluster) exists(ctx context.Context, client awsapi.EKSClient) bool {
+	_, err := c.get(ctx, client)
+	return err == nil
+}
+
+// Diff cluster against the config, the subnets, security groups and key must be resolved
+func (c *Cluster) diff(ctx context.Context, client awsapi.EKSClient) (*tidalwave.ResourcePlan, error) {
+	cluster, err := c.get(ctx, client)
+	if isNotFound(err) {
+		return tidalwave.NewResourcePlan("cluster", c.Name, false, nil), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return tidalwave.NewResourcePlan("cluster", c.Name, true, c.compare(cluster)), nil
+}
+
+// Compare a live cluster with the config, only the endpoint access can be changed in place
+func (c *Cluster) compare(cluster *types.Cluster) []tidalwave.Change {
+	vpc := cluster.ResourcesVpcConfig
+	if vpc == nil {
+		vpc = &types.VpcConfigResponse{}
+	}
+	d := differ{}
+	d.forceNew("subnets", list(vpc.SubnetIds), list(c.SubnetIDs))
+	d.forceNew("securityGroups", list(vpc.SecurityGroupIds), list(c.SecurityGroupIDs))
+	for _, e := range cluster.EncryptionConfig {
+		if e.Provider != nil {
+			d.forceNew("encryptionKey", aws.ToString(e.Provider.KeyArn), c.KeyArn)
+		}
+	}
+	publicAccess := len(c.PublicAccessCidrs) > 0
+	d.field("endpointPrivateAccess", fmt.Sprint(vpc.EndpointPrivateAccess), "true")
+	d.field("endpointPublicAccess", fmt.Sprint(vpc.EndpointPublicAccess), fmt.Sprint(publicAccess))
+	if publicAccess {
+		d.field("publicAccessCidrs", list(vpc.PublicAccessCidrs), list(c.PublicAccessCidrs))
+	}
+	return d.changes
+}
+
+// Update cluster endpoint access. The subnets, security groups and encryption key cannot be
+// changed once the cluster is created.
+func (c *Cluster) update(ctx context.Context, client awsapi.EKSClient) (*types.Cluster, error) {
+	cluster, err := c.get(ctx, client)
+	if isNotFound(err) {
+		return c.create(ctx, client)
+	}
+	if err != nil {
+		return nil, err
+	}
+	changes := c.compare(cluster)
+	if err := forceNewError("cluster", c.Name, changes); err != nil {
+		return nil, err
+	}
+	if len(changes) == 0 {
+		return cluster, nil
+	}
+	resp, err := client.UpdateClusterConfig(ctx, &eks.UpdateClusterConfigInput{
+		Name:               aws.String(c.Name),
+		ResourcesVpcConfig: c.vpcConfig(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	err = waitUpdate(ctx, client, &eks.DescribeUpdateInput{
+		Name:     aws.String(c.Name),
+		UpdateId: resp.Update.Id,
+	}, fmt.Sprintf("update cluster %s endpoint access", c.Name))
+	if err != nil {
+		return nil, err
+	}
+	return c.get(ctx, client)
+}
+
+// Delete cluster, its node groups must already be deleted
+func (c *Cluster) delete(ctx context.Context, client awsapi.EKSClient) error {
+	if _, err := client.DeleteCluster(ctx, &eks.DeleteClusterInput{Name: aws.String(c.Name)}); err != nil {
+		if isNotFound(err) {
+			return nil
+		}
+		return err
+	}
+	return waitFor(ctx, c.Name, fmt.Sprintf("delete cluster %s", c.Name), func(max time.Duration) error {
+		return eks.NewClusterDeletedWaiter(client).Wait(ctx, &eks.DescribeClusterInput{Name: aws.String(c.Name)}, max)
+	})
+}
+
+// NodeGroup represents the EKS managed node group workloads run on
+type NodeGroup struct {
+	Name          string
+	ClusterName   string
+	NodeRoleArn   string
+	SubnetIDs     []string
+	InstanceTypes []string
+	DiskSize      int32
+	MinSize       int32
+	MaxSize       int32
+}
+
+func (n *NodeGroup) describeInput() *eks.DescribeNodegroupInput {
+	return &eks.DescribeNodegroupInput{
+		ClusterName:   aws.String(n.ClusterName),
+		NodegroupName: aws.String(n.Name),
+	}
+}
+
+// Create node group
+func (n *NodeGroup) create(ctx context.Context, client awsapi.EKSClient) (*types.Nodegroup, error) {
+	if n.exists(ctx, client) {
+		return n.get(ctx, client)
+	}
+	input := &eks.CreateNodegroupInput{
+		ClusterName:   aws.String(n.ClusterName),
+		NodegroupName: aws.String(n.Name),
+		NodeRole:      aws.String(n.NodeRoleArn),
+		Subnets:       n.SubnetIDs,
+		InstanceTypes: n.InstanceTypes,
+		ScalingConfig: &types.NodegroupScalingConfig{
+			MinSize:     aws.Int32(n.MinSize),
+			MaxSize:     aws.Int32(n.MaxSize),
+			DesiredSize: aws.Int32(n.MinSize),
+		},
+		Tags: map[string]string{ControlplaneTag: n.ClusterName},
+	}
+	if n.DiskSize > 0 {
+		input.DiskSize = aws.Int32(n.DiskSize)
+	}
+	if _, err := client.CreateNodegroup(ctx, input); err != nil {
+		return nil, err
+	}
+	err := waitFor(ctx, n.ClusterName+"/"+n.Name, fmt.Sprintf("create node group %s", n.Name), func(max time.Duration) error {
+		return eks.NewNodegroupActiveWaiter(client).Wait(ctx, n.describeInput(), max)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return n.get(ctx, client)
+}
+
+// Get node group
+func (n *NodeGroup) get(ctx context.Context, client awsapi.EKSClient) (*types.Nodegroup, error) {
+	resp, err := client.DescribeNodegroup(ctx, n.describeInput())
+	if err != nil {
+		return nil, err
+	}
+	return resp.Nodegroup, nil
+}
+
+// Check if node group exists
+func (n *NodeGroup) exists(ctx context.Context, client awsapi.EKSClient) bool {
+	_, err := n.get(ctx, client)
+	return err == nil
+}
+
+// Diff node group against the config
+func (n *NodeGroup) diff(ctx context.Context, client awsapi.EKSClient) (*tidalwave.ResourcePlan, error) {
+	group, err := n.get(ctx, client)
+	if isNotFound(err) {
+		return tidalwave.NewResourcePlan("nodegroup", n.Name, false, nil), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return tidalwave.NewResourcePlan("nodegroup", n.Name, true, n.compare(group)), nil
+}
+
+// Compare a live node group with the config, only the scaling can be changed in place
+func (n *NodeGroup) compare(group *types.Nodegroup) []tidalwave.Change {
+	scaling := group.ScalingConfig
+	if scaling == nil {
+		scaling = &types.NodegroupScalingConfig{}
+	}
+	d := differ{}
+	d.forceNew("instanceTypes", list(group.InstanceTypes), list(n.InstanceTypes))
+	if n.DiskSize > 0 {
+		d.forceNew("diskSize", fmt.Sprint(aws.ToInt32(group.DiskSize)), fmt.Sprint(n.DiskSize))
+	}
+	d.field("minSize", fmt.Sprint(aws.ToInt32(scaling.MinSize)), fmt.Sprint(n.MinSize))
+	d.field("maxSize", fmt.Sprint(aws.ToInt32(scaling.MaxSize)), fmt.Sprint(n.MaxSize))
+	return d.changes
+}
+
+// Update node group scaling, the instance types and disk size cannot be changed in place
+func (n *NodeGroup) update(ctx context.Context, client awsapi.EKSClient) (*types.Nodegroup, error) {
+	group, err := n.get(ctx, client)
+	if isNotFound(err) {
+		return n.create(ctx, client)
+	}
+	if err != nil {
+		return nil, err
+	}
+	changes := n.compare(group)
+	if err := forceNewError("node group", n.Name, changes); err != nil {
+		return nil, err
+	}
+	if len(changes) == 0 {
+		return group, nil
+	}
+	scaling := group.ScalingConfig
+	if scaling == nil {
+		scaling = &types.NodegroupScalingConfig{}
+	}
+	// Keep the current size unless it is outside the new bounds
+	desired := aws.ToInt32(scaling.DesiredSize)
+	if desired < n.MinSize {
+		desired = n.MinSize
+	}
+	if desired > n.MaxSize {
+		desired = n.MaxSize
+	}
+	resp, err := client.UpdateNodegroupConfig(ctx, &eks.UpdateNodegroupConfigInput{
+		ClusterName:   aws.String(n.ClusterName),
+		NodegroupName: aws.String(n.Name),
+		ScalingConfig: &types.NodegroupScalingConfig{
+			MinSize:     aws.Int32(n.MinSize),
+			MaxSize:     aws.Int32(n.MaxSize),
+			DesiredSize: aws.Int32(desired),
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	err = waitUpdate(ctx, client, &eks.DescribeUpdateInput{
+		Name:          aws.String(n.ClusterName),
+		NodegroupName: aws.String(n.Name),
+		UpdateId:      resp.Update.Id,
+	}, fmt.Sprintf("update node group %s scaling", n.Name))
+	if err != nil {
+		return nil, err
+	}
+	return n.get(ctx, client)
+}
+
+// Delete node group
+func (n *NodeGroup) delete(ctx context.Context, client awsapi.EKSClient) error {
+	_, err := client.DeleteNodegroup(ctx, &eks.DeleteNodegroupInput{
+		ClusterName:   aws.String(n.ClusterName),
+		NodegroupName: aws.String(n.Name),
+	})
+	if isNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return waitFor(ctx, n.ClusterName+"/"+n.Name, fmt.Sprintf("delete node group %s", n.Name), func(max time.Duration) error {
+		return eks.NewNodegroupDeletedWaiter(client).Wait(ctx, n.describeInput(), max)
+	})
+}
@@ -0,0 +1,37 @@
+package aws
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/aws/smithy-go"
+)
+
+// notFoundError is returned when no resource carries the name tidalwave looks it up by
+type notFoundError struct {
+	kind string
+	name string
+}
+
+func (e *notFoundError) Error() string {
+	return fmt.Sprintf("%s %s not found", e.kind, e.name)
+}
+
+// isNotFound reports whether err means the resource does not exist
+func isNotFound(err error) bool {
+	var nf *notFoundError
+	if errors.As(err, &nf) {
+		return true
+	}
+	var apiErr smithy.APIError
+	if !errors.As(err, &apiErr) {
+		return false
+	}
+	switch code := apiErr.ErrorCode(); code {
+	case "NoSuchEntity", "NotFoundException", "ResourceNotFoundException", "NatGatewayNotFound":
+		return true
+	default:
+		return strings.HasSuffix(code, ".NotFound")
+	}
+}
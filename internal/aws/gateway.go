@@ -0,0 +1,212 @@
+package aws
+
+import (
+	"context"
+	"fmt"
+	"tidalwave/internal/aws/awsapi"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+// Gateway represents the internet gateway and the public subnet the NAT gateway lives in
+type Gateway struct {
+	Name  string
+	VpcID string
+	// Cidr is the CIDR block of the public subnet
+	Cidr string
+	Zone string
+}
+
+func (g *Gateway) publicName() string {
+	return g.Name + "-public"
+}
+
+// Create internet gateway, public subnet and the route table that sends it to the internet.
+// It returns the public subnet.
+func (g *Gateway) create(ctx context.Context, client awsapi.EC2Client) (*types.Subnet, error) {
+	igw, err := g.internetGateway(ctx, client)
+	if isNotFound(err) {
+		resp, err := client.CreateInternetGateway(ctx, &ec2.CreateInternetGatewayInput{
+			TagSpecifications: tagSpec(types.ResourceTypeInternetGateway, g.Name, g.Name, nil),
+		})
+		if err != nil {
+			return nil, err
+		}
+		igw = resp.InternetGateway
+	} else if err != nil {
+		return nil, err
+	}
+	if len(igw.Attachments) == 0 {
+		_, err := client.AttachInternetGateway(ctx, &ec2.AttachInternetGatewayInput{
+			InternetGatewayId: igw.InternetGatewayId,
+			VpcId:             aws.String(g.VpcID),
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	subnet, err := g.get(ctx, client)
+	if isNotFound(err) {
+		resp, err := client.CreateSubnet(ctx, &ec2.CreateSubnetInput{
+			VpcId:             aws.String(g.VpcID),
+			CidrBlock:         aws.String(g.Cidr),
+			AvailabilityZone:  aws.String(g.Zone),
+			TagSpecifications: tagSpec(types.ResourceTypeSubnet, g.publicName(), g.Name, map[string]string{"kubernetes.io/role/elb": "1"}),
+		})
+		if err != nil {
+			return nil, err
+		}
+		subnet = resp.Subnet
+	} else if err != nil {
+		return nil, err
+	}
+
+	table, err := routeTable(ctx, client, g.publicName())
+	if isNotFound(err) {
+		table, err = createRouteTable(ctx, client, g.VpcID, g.publicName(), g.Name, &ec2.CreateRouteInput{
+			DestinationCidrBlock: aws.String("0.0.0.0/0"),
+			GatewayId:            igw.InternetGatewayId,
+		})
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := associate(ctx, client, table, aws.ToString(subnet.SubnetId)); err != nil {
+		return nil, err
+	}
+	return subnet, nil
+}
+
+// Get the public subnet
+func (g *Gateway) get(ctx context.Context, client awsapi.EC2Client) (*types.Subnet, error) {
+	resp, err := client.DescribeSubnets(ctx, &ec2.DescribeSubnetsInput{Filters: nameFilter(g.publicName())})
+	if err != nil {
+		return nil, err
+	}
+	if len(resp.Subnets) == 0 {
+		return nil, &notFoundError{kind: "subnet", name: g.publicName()}
+	}
+	return &resp.Subnets[0], nil
+}
+
+// internetGateway returns the internet gateway
+func (g *Gateway) internetGateway(ctx context.Context, client awsapi.EC2Client) (*types.InternetGateway, error) {
+	resp, err := client.DescribeInternetGateways(ctx, &ec2.DescribeInternetGatewaysInput{Filters: nameFilter(g.Name)})
+	if err != nil {
+		return nil, err
+	}
+	if len(resp.InternetGateways) == 0 {
+		return nil, &notFoundError{kind: "internet gateway", name: g.Name}
+	}
+	return &resp.InternetGateways[0], nil
+}
+
+// Check if the gateway exists, it is only complete once the public subnet exists
+func (g *Gateway) exists(ctx context.Context, client awsapi.EC2Client) bool {
+	_, err := g.get(ctx, client)
+	return err == nil
+}
+
+// Update gateway, anything missing is created
+func (g *Gateway) update(ctx context.Context, client awsapi.EC2Client) (*types.Subnet, error) {
+	subnet, err := g.get(ctx, client)
+	if err != nil && !isNotFound(err) {
+		return nil, err
+	}
+	if err == nil && aws.ToString(subnet.CidrBlock) != g.Cidr {
+		return nil, fmt.Errorf("subnet %s must be deleted and recreated to change cidr from %s to %s", g.publicName(), aws.ToString(subnet.CidrBlock), g.Cidr)
+	}
+	return g.create(ctx, client)
+}
+
+// Delete the public subnet, its route table and the internet gateway
+func (g *Gateway) delete(ctx context.Context, client awsapi.EC2Client) error {
+	subnet, err := g.get(ctx, client)
+	if err == nil {
+		if _, err := client.DeleteSubnet(ctx, &ec2.DeleteSubnetInput{SubnetId: subnet.SubnetId}); err != nil {
+			return err
+		}
+	} else if !isNotFound(err) {
+		return err
+	}
+	if err := deleteRouteTable(ctx, client, g.publicName()); err != nil {
+		return err
+	}
+	igw, err := g.internetGateway(ctx, client)
+	if isNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	for _, a := range igw.Attachments {
+		_, err := client.DetachInternetGateway(ctx, &ec2.DetachInternetGatewayInput{
+			InternetGatewayId: igw.InternetGatewayId,
+			VpcId:             a.VpcId,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	_, err = client.DeleteInternetGateway(ctx, &ec2.DeleteInternetGatewayInput{InternetGatewayId: igw.InternetGatewayId})
+	return err
+}
+
+// routeTable returns a route table by its Name tag
+func routeTable(ctx context.Context, client awsapi.EC2Client, name string) (*types.RouteTable, error) {
+	resp, err := client.DescribeRouteTables(ctx, &ec2.DescribeRouteTablesInput{Filters: nameFilter(name)})
+	if err != nil {
+		return nil, err
+	}
+	if len(resp.RouteTables) == 0 {
+		return nil, &notFoundError{kind: "route table", name: name}
+	}
+	return &resp.RouteTables[0], nil
+}
+
+// createRouteTable creates a route table with a single default route
+func createRouteTable(ctx context.Context, client awsapi.EC2Client, vpcID, name, controlplane string, route *ec2.CreateRouteInput) (*types.RouteTable, error) {
+	resp, err := client.CreateRouteTable(ctx, &ec2.CreateRouteTableInput{
+		VpcId:             aws.String(vpcID),
+		TagSpecifications: tagSpec(types.ResourceTypeRouteTable, name, controlplane, nil),
+	})
+	if err != nil {
+		return nil, err
+	}
+	route.RouteTableId = resp.RouteTable.RouteTableId
+	if _, err := client.CreateRoute(ctx, route); err != nil {
+		return nil, err
+	}
+	return routeTable(ctx, client, name)
+}
+
+// associate routes a subnet through a route table unless it already is
+func associate(ctx context.Context, client awsapi.EC2Client, table *types.RouteTable, subnetID string) error {
+	for _, a := range table.Associations {
+		if aws.ToString(a.SubnetId) == subnetID {
+			return nil
+		}
+	}
+	_, err := client.AssociateRouteTable(ctx, &ec2.AssociateRouteTableInput{
+		RouteTableId: table.RouteTableId,
+		SubnetId:     aws.String(subnetID),
+	})
+	return err
+}
+
+// deleteRouteTable deletes a route table by its Name tag, the subnets associated with it must
+// already be deleted
+func deleteRouteTable(ctx context.Context, client awsapi.EC2Client, name string) error {
+	table, err := routeTable(ctx, client, name)
+	if isNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	_, err = client.DeleteRouteTable(ctx, &ec2.DeleteRouteTableInput{RouteTableId: table.RouteTableId})
+	return err
+}
@@ -0,0 +1,127 @@
+package aws
+
+import (
+	"context"
+	"fmt"
+	"tidalwave/internal/aws/awsapi"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/kms"
+	"github.com/aws/aws-sdk-go-v2/service/kms/types"
+)
+
+// keyDeletionWindow is how many days a deleted key can still be recovered for
+const keyDeletionWindow = 7
+
+// Key represents the KMS key EKS uses for envelope encryption of Kubernetes secrets, it is
+// found by its alias
+type Key struct {
+	Name string
+}
+
+func (k *Key) alias() string {
+	return "alias/" + k.Name
+}
+
+// Create key with rotation enabled
+func (k *Key) create(ctx context.Context, client awsapi.KMSClient) (*types.KeyMetadata, error) {
+	key, err := k.get(ctx, client)
+	if err == nil {
+		return key, nil
+	}
+	if !isNotFound(err) {
+		return nil, err
+	}
+	// A key scheduled for deletion outside of tidalwave keeps its alias, which is then moved to
+	// the new key
+	_, err = client.DescribeKey(ctx, &kms.DescribeKeyInput{KeyId: aws.String(k.alias())})
+	aliased := err == nil
+	if err != nil && !isNotFound(err) {
+		return nil, err
+	}
+	resp, err := client.CreateKey(ctx, &kms.CreateKeyInput{
+		Description: aws.String(fmt.Sprintf("EKS secrets encryption for %s", k.Name)),
+		Tags: []types.Tag{
+			{TagKey: aws.String(ControlplaneTag), TagValue: aws.String(k.Name)},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if aliased {
+		_, err = client.UpdateAlias(ctx, &kms.UpdateAliasInput{
+			AliasName:   aws.String(k.alias()),
+			TargetKeyId: resp.KeyMetadata.KeyId,
+		})
+	} else {
+		_, err = client.CreateAlias(ctx, &kms.CreateAliasInput{
+			AliasName:   aws.String(k.alias()),
+			TargetKeyId: resp.KeyMetadata.KeyId,
+		})
+	}
+	if err != nil {
+		return nil, err
+	}
+	if _, err := client.EnableKeyRotation(ctx, &kms.EnableKeyRotationInput{KeyId: resp.KeyMetadata.KeyId}); err != nil {
+		return nil, err
+	}
+	return k.get(ctx, client)
+}
+
+// Get key by its alias, keys pending deletion are treated as missing
+func (k *Key) get(ctx context.Context, client awsapi.KMSClient) (*types.KeyMetadata, error) {
+	resp, err := client.DescribeKey(ctx, &kms.DescribeKeyInput{KeyId: aws.String(k.alias())})
+	if err != nil {
+		return nil, err
+	}
+	if resp.KeyMetadata.KeyState == types.KeyStatePendingDeletion {
+		return nil, &notFoundError{kind: "key", name: k.alias()}
+	}
+	return resp.KeyMetadata, nil
+}
+
+// Check if key exists
+func (k *Key) exists(ctx context.Context, client awsapi.KMSClient) bool {
+	_, err := k.get(ctx, client)
+	return err == nil
+}
+
+// Update key, rotation is turned back on if it was disabled
+func (k *Key) update(ctx context.Context, client awsapi.KMSClient) (*types.KeyMetadata, error) {
+	key, err := k.get(ctx, client)
+	if isNotFound(err) {
+		return k.create(ctx, client)
+	}
+	if err != nil {
+		return nil, err
+	}
+	rotation, err := client.GetKeyRotationStatus(ctx, &kms.GetKeyRotationStatusInput{KeyId: key.KeyId})
+	if err != nil {
+		return nil, err
+	}
+	if !rotation.KeyRotationEnabled {
+		if _, err := client.EnableKeyRotation(ctx, &kms.EnableKeyRotationInput{KeyId: key.KeyId}); err != nil {
+			return nil, err
+		}
+	}
+	return key, nil
+}
+
+// Delete key, KMS only schedules the deletion so the key can be recovered for a week
+func (k *Key) delete(ctx context.Context, client awsapi.KMSClient) error {
+	key, err := k.get(ctx, client)
+	if isNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if _, err := client.DeleteAlias(ctx, &kms.DeleteAliasInput{AliasName: aws.String(k.alias())}); err != nil {
+		return err
+	}
+	_, err = client.ScheduleKeyDeletion(ctx, &kms.ScheduleKeyDeletionInput{
+		KeyId:               key.KeyId,
+		PendingWindowInDays: aws.Int32(keyDeletionWindow),
+	})
+	return err
+}
@@ -0,0 +1,149 @@
+package aws
+
+import (
+	"context"
+	"fmt"
+	"tidalwave/internal/aws/awsapi"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+// Nat represents the NAT gateway private subnets reach the internet through, with its elastic
+// IP and the private route table
+type Nat struct {
+	Name           string
+	VpcID          string
+	PublicSubnetID string
+}
+
+func (n *Nat) addressName() string {
+	return n.Name + "-nat"
+}
+
+func (n *Nat) privateName() string {
+	return n.Name + "-private"
+}
+
+// Create NAT gateway, it returns the private route table
+func (n *Nat) create(ctx context.Context, client awsapi.EC2Client) (*types.RouteTable, error) {
+	gw, err := n.get(ctx, client)
+	if isNotFound(err) {
+		gw, err = n.createGateway(ctx, client)
+	}
+	if err != nil {
+		return nil, err
+	}
+	table, err := routeTable(ctx, client, n.privateName())
+	if isNotFound(err) {
+		return createRouteTable(ctx, client, n.VpcID, n.privateName(), n.Name, &ec2.CreateRouteInput{
+			DestinationCidrBlock: aws.String("0.0.0.0/0"),
+			NatGatewayId:         gw.NatGatewayId,
+		})
+	}
+	return table, err
+}
+
+// createGateway allocates the elastic IP and creates the NAT gateway in the public subnet
+func (n *Nat) createGateway(ctx context.Context, client awsapi.EC2Client) (*types.NatGateway, error) {
+	address, err := n.address(ctx, client)
+	if isNotFound(err) {
+		resp, err := client.AllocateAddress(ctx, &ec2.AllocateAddressInput{
+			Domain:            types.DomainTypeVpc,
+			TagSpecifications: tagSpec(types.ResourceTypeElasticIp, n.addressName(), n.Name, nil),
+		})
+		if err != nil {
+			return nil, err
+		}
+		address = &types.Address{AllocationId: resp.AllocationId}
+	} else if err != nil {
+		return nil, err
+	}
+	resp, err := client.CreateNatGateway(ctx, &ec2.CreateNatGatewayInput{
+		AllocationId:      address.AllocationId,
+		SubnetId:          aws.String(n.PublicSubnetID),
+		TagSpecifications: tagSpec(types.ResourceTypeNatgateway, n.Name, n.Name, nil),
+	})
+	if err != nil {
+		return nil, err
+	}
+	id := aws.ToString(resp.NatGateway.NatGatewayId)
+	err = waitFor(ctx, id, fmt.Sprintf("create nat gateway %s", n.Name), func(max time.Duration) error {
+		return ec2.NewNatGatewayAvailableWaiter(client).Wait(ctx, &ec2.DescribeNatGatewaysInput{NatGatewayIds: []string{id}}, max)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return n.get(ctx, client)
+}
+
+// Get the NAT gateway, deleted gateways are ignored
+func (n *Nat) get(ctx context.Context, client awsapi.EC2Client) (*types.NatGateway, error) {
+	resp, err := client.DescribeNatGateways(ctx, &ec2.DescribeNatGatewaysInput{
+		Filter: append(nameFilter(n.Name), filter("state", "pending", "available")),
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(resp.NatGateways) == 0 {
+		return nil, &notFoundError{kind: "nat gateway", name: n.Name}
+	}
+	return &resp.NatGateways[0], nil
+}
+
+// address returns the elastic IP of the NAT gateway
+func (n *Nat) address(ctx context.Context, client awsapi.EC2Client) (*types.Address, error) {
+	resp, err := client.DescribeAddresses(ctx, &ec2.DescribeAddressesInput{Filters: nameFilter(n.addressName())})
+	if err != nil {
+		return nil, err
+	}
+	if len(resp.Addresses) == 0 {
+		return nil, &notFoundError{kind: "elastic ip", name: n.addressName()}
+	}
+	return &resp.Addresses[0], nil
+}
+
+// Check if the NAT gateway exists
+func (n *Nat) exists(ctx context.Context, client awsapi.EC2Client) bool {
+	_, err := n.get(ctx, client)
+	return err == nil
+}
+
+// Update NAT gateway, anything missing is created
+func (n *Nat) update(ctx context.Context, client awsapi.EC2Client) (*types.RouteTable, error) {
+	return n.create(ctx, client)
+}
+
+// Delete the private route table, NAT gateway and its elastic IP. The private subnets must
+// already be deleted.
+func (n *Nat) delete(ctx context.Context, client awsapi.EC2Client) error {
+	if err := deleteRouteTable(ctx, client, n.privateName()); err != nil {
+		return err
+	}
+	gw, err := n.get(ctx, client)
+	if err == nil {
+		id := aws.ToString(gw.NatGatewayId)
+		if _, err := client.DeleteNatGateway(ctx, &ec2.DeleteNatGatewayInput{NatGatewayId: gw.NatGatewayId}); err != nil {
+			return err
+		}
+		err = waitFor(ctx, id, fmt.Sprintf("delete nat gateway %s", n.Name), func(max time.Duration) error {
+			return ec2.NewNatGatewayDeletedWaiter(client).Wait(ctx, &ec2.DescribeNatGatewaysInput{NatGatewayIds: []string{id}}, max)
+		})
+		if err != nil {
+			return err
+		}
+	} else if !isNotFound(err) {
+		return err
+	}
+	address, err := n.address(ctx, client)
+	if isNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	_, err = client.ReleaseAddress(ctx, &ec2.ReleaseAddressInput{AllocationId: address.AllocationId})
+	return err
+}
@@ -0,0 +1,169 @@
+package aws
+
+import (
+	"context"
+	"fmt"
+	"tidalwave/internal/aws/awsapi"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/iam"
+	"github.com/aws/aws-sdk-go-v2/service/iam/types"
+)
+
+const assumeRolePolicy = `{"Version":"2012-10-17","Statement":[{"Effect":"Allow","Principal":{"Service":"%s"},"Action":"sts:AssumeRole"}]}`
+
+// Roles represents the IAM roles the EKS controlplane and its nodes run as
+type Roles struct {
+	Name string
+}
+
+// role is an IAM role assumed by an AWS service with managed policies attached
+type role struct {
+	name     string
+	service  string
+	policies []string
+}
+
+func (r *Roles) cluster() role {
+	return role{
+		name:     r.Name + "-cluster",
+		service:  "eks.amazonaws.com",
+		policies: []string{"arn:aws:iam::aws:policy/AmazonEKSClusterPolicy"},
+	}
+}
+
+func (r *Roles) node() role {
+	return role{
+		name:    r.Name + "-node",
+		service: "ec2.amazonaws.com",
+		policies: []string{
+			"arn:aws:iam::aws:policy/AmazonEKSWorkerNodePolicy",
+			"arn:aws:iam::aws:policy/AmazonEKS_CNI_Policy",
+			"arn:aws:iam::aws:policy/AmazonEC2ContainerRegistryReadOnly",
+		},
+	}
+}
+
+// Create the cluster and node roles, it returns them in that order
+func (r *Roles) create(ctx context.Context, client awsapi.IAMClient) ([]*types.Role, error) {
+	roles := []*types.Role{}
+	for _, role := range []role{r.cluster(), r.node()} {
+		created, err := role.create(ctx, client, r.Name)
+		if err != nil {
+			return nil, err
+		}
+		roles = append(roles, created)
+	}
+	return roles, nil
+}
+
+// Check if both roles exist
+func (r *Roles) exists(ctx context.Context, client awsapi.IAMClient) bool {
+	for _, role := range []role{r.cluster(), r.node()} {
+		if _, err := role.get(ctx, client); err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// Update roles, missing roles are created and detached policies attached again
+func (r *Roles) update(ctx context.Context, client awsapi.IAMClient) ([]*types.Role, error) {
+	return r.create(ctx, client)
+}
+
+// Delete both roles
+func (r *Roles) delete(ctx context.Context, client awsapi.IAMClient) error {
+	for _, role := range []role{r.node(), r.cluster()} {
+		if err := role.delete(ctx, client); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *role) create(ctx context.Context, client awsapi.IAMClient, controlplane string) (*types.Role, error) {
+	_, err := r.get(ctx, client)
+	if isNotFound(err) {
+		_, err = client.CreateRole(ctx, &iam.CreateRoleInput{
+			RoleName:                 aws.String(r.name),
+			AssumeRolePolicyDocument: aws.String(fmt.Sprintf(assumeRolePolicy, r.service)),
+			Tags: []types.Tag{
+				{Key: aws.String(ControlplaneTag), Value: aws.String(controlplane)},
+			},
+		})
+	}
+	if err != nil {
+		return nil, err
+	}
+	attached, err := r.attached(ctx, client)
+	if err != nil {
+		return nil, err
+	}
+	for _, policy := range r.policies {
+		if attached[policy] {
+			continue
+		}
+		_, err := client.AttachRolePolicy(ctx, &iam.AttachRolePolicyInput{
+			RoleName:  aws.String(r.name),
+			PolicyArn: aws.String(policy),
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return r.get(ctx, client)
+}
+
+func (r *role) get(ctx context.Context, client awsapi.IAMClient) (*types.Role, error) {
+	resp, err := client.GetRole(ctx, &iam.GetRoleInput{RoleName: aws.String(r.name)})
+	if err != nil {
+		return nil, err
+	}
+	return resp.Role, nil
+}
+
+// attached returns the ARNs of the managed policies attached to the role
+func (r *role) attached(ctx context.Context, client awsapi.IAMClient) (map[string]bool, error) {
+	attached := map[string]bool{}
+	input := &iam.ListAttachedRolePoliciesInput{RoleName: aws.String(r.name)}
+	for {
+		resp, err := client.ListAttachedRolePolicies(ctx, input)
+		if err != nil {
+			return nil, err
+		}
+		for _, p := range resp.AttachedPolicies {
+			attached[aws.ToString(p.PolicyArn)] = true
+		}
+		if !resp.IsTruncated {
+			return attached, nil
+		}
+		input.Marker = resp.Marker
+	}
+}
+
+// delete detaches every policy from the role before deleting it, IAM refuses to delete a role
+// with policies attached
+func (r *role) delete(ctx context.Context, client awsapi.IAMClient) error {
+	attached, err := r.attached(ctx, client)
+	if isNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	for policy := range attached {
+		_, err := client.DetachRolePolicy(ctx, &iam.DetachRolePolicyInput{
+			RoleName:  aws.String(r.name),
+			PolicyArn: aws.String(policy),
+		})
+		if err != nil {
+			return err
+		}
+	}
+	_, err = client.DeleteRole(ctx, &iam.DeleteRoleInput{RoleName: aws.String(r.name)})
+	if isNotFound(err) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,188 @@
+package aws
+
+import (
+	"context"
+	"fmt"
+	"tidalwave/internal/aws/awsapi"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+// SecurityGroup represents a VPC security group attached to the EKS cluster, rules not listed
+// here are revoked, including the allow all egress rule AWS adds to new groups
+type SecurityGroup struct {
+	Name        string
+	Description string
+	VpcID       string
+	Ingress     []Rule
+	Egress      []Rule
+	// Controlplane is the name of the controlplane the group belongs to
+	Controlplane string
+}
+
+// Rule allows traffic to or from Cidrs on a port, a Port of 0 allows every port
+type Rule struct {
+	Protocol string
+	Port     int32
+	Cidrs    []string
+}
+
+// permission is a single rule for a single CIDR block, which is how rules are compared
+type permission struct {
+	protocol string
+	from     int32
+	to       int32
+	cidr     string
+}
+
+func (p permission) ipPermission() types.IpPermission {
+	perm := types.IpPermission{
+		IpProtocol: aws.String(p.protocol),
+		IpRanges:   []types.IpRange{{CidrIp: aws.String(p.cidr)}},
+	}
+	if p.protocol != "-1" {
+		perm.FromPort = aws.Int32(p.from)
+		perm.ToPort = aws.Int32(p.to)
+	}
+	return perm
+}
+
+// permissions flattens rules to one permission per CIDR block
+func permissions(rules []Rule) map[permission]bool {
+	perms := map[permission]bool{}
+	for _, r := range rules {
+		p := permission{protocol: r.Protocol, from: r.Port, to: r.Port}
+		if r.Port == 0 {
+			p = permission{protocol: "-1"}
+		}
+		for _, cidr := range r.Cidrs {
+			p.cidr = cidr
+			perms[p] = true
+		}
+	}
+	return perms
+}
+
+// existingPermissions flattens the permissions of a security group the same way as permissions
+func existingPermissions(ipPermissions []types.IpPermission) map[permission]bool {
+	perms := map[permission]bool{}
+	for _, ip := range ipPermissions {
+		p := permission{protocol: aws.ToString(ip.IpProtocol)}
+		if p.protocol != "-1" {
+			p.from = aws.ToInt32(ip.FromPort)
+			p.to = aws.ToInt32(ip.ToPort)
+		}
+		for _, r := range ip.IpRanges {
+			p.cidr = aws.ToString(r.CidrIp)
+			perms[p] = true
+		}
+	}
+	return perms
+}
+
+// diff returns the permissions in a that are not in b
+func diff(a, b map[permission]bool) []types.IpPermission {
+	missing := []types.IpPermission{}
+	for p := range a {
+		if !b[p] {
+			missing = append(missing, p.ipPermission())
+		}
+	}
+	return missing
+}
+
+// Create security group
+func (s *SecurityGroup) create(ctx context.Context, client awsapi.EC2Client) (*types.SecurityGroup, error) {
+	group, err := s.get(ctx, client)
+	if isNotFound(err) {
+		_, err = client.CreateSecurityGroup(ctx, &ec2.CreateSecurityGroupInput{
+			GroupName:         aws.String(s.Name),
+			Description:       aws.String(s.Description),
+			VpcId:             aws.String(s.VpcID),
+			TagSpecifications: tagSpec(types.ResourceTypeSecurityGroup, s.Name, s.Controlplane, nil),
+		})
+		if err != nil {
+			return nil, err
+		}
+		group, err = s.get(ctx, client)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := s.reconcile(ctx, client, group); err != nil {
+		return nil, err
+	}
+	return s.get(ctx, client)
+}
+
+// reconcile authorizes missing rules and revokes the ones that should not be there
+func (s *SecurityGroup) reconcile(ctx context.Context, client awsapi.EC2Client, group *types.SecurityGroup) error {
+	ingress, existingIngress := permissions(s.Ingress), existingPermissions(group.IpPermissions)
+	egress, existingEgress := permissions(s.Egress), existingPermissions(group.IpPermissionsEgress)
+
+	if perms := diff(ingress, existingIngress); len(perms) > 0 {
+		_, err := client.AuthorizeSecurityGroupIngress(ctx, &ec2.AuthorizeSecurityGroupIngressInput{GroupId: group.GroupId, IpPermissions: perms})
+		if err != nil {
+			return fmt.Errorf("authorize ingress on %s: %w", s.Name, err)
+		}
+	}
+	if perms := diff(existingIngress, ingress); len(perms) > 0 {
+		_, err := client.RevokeSecurityGroupIngress(ctx, &ec2.RevokeSecurityGroupIngressInput{GroupId: group.GroupId, IpPermissions: perms})
+		if err != nil {
+			return fmt.Errorf("revoke ingress on %s: %w", s.Name, err)
+		}
+	}
+	if perms := diff(egress, existingEgress); len(perms) > 0 {
+		_, err := client.AuthorizeSecurityGroupEgress(ctx, &ec2.AuthorizeSecurityGroupEgressInput{GroupId: group.GroupId, IpPermissions: perms})
+		if err != nil {
+			return fmt.Errorf("authorize egress on %s: %w", s.Name, err)
+		}
+	}
+	if perms := diff(existingEgress, egress); len(perms) > 0 {
+		_, err := client.RevokeSecurityGroupEgress(ctx, &ec2.RevokeSecurityGroupEgressInput{GroupId: group.GroupId, IpPermissions: perms})
+		if err != nil {
+			return fmt.Errorf("revoke egress on %s: %w", s.Name, err)
+		}
+	}
+	return nil
+}
+
+// Get security group by name
+func (s *SecurityGroup) get(ctx context.Context, client awsapi.EC2Client) (*types.SecurityGroup, error) {
+	resp, err := client.DescribeSecurityGroups(ctx, &ec2.DescribeSecurityGroupsInput{
+		Filters: []types.Filter{filter("group-name", s.Name), filter("tag:"+ControlplaneTag, s.Controlplane)},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(resp.SecurityGroups) == 0 {
+		return nil, &notFoundError{kind: "security group", name: s.Name}
+	}
+	return &resp.SecurityGroups[0], nil
+}
+
+// Check if security group exists
+func (s *SecurityGroup) exists(ctx context.Context, client awsapi.EC2Client) bool {
+	_, err := s.get(ctx, client)
+	return err == nil
+}
+
+// Update security group, rules that drifted are put back
+func (s *SecurityGroup) update(ctx context.Context, client awsapi.EC2Client) (*types.SecurityGroup, error) {
+	return s.create(ctx, client)
+}
+
+// Delete security group
+func (s *SecurityGroup) delete(ctx context.Context, client awsapi.EC2Client) error {
+	group, err := s.get(ctx, client)
+	if isNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	_, err = client.DeleteSecurityGroup(ctx, &ec2.DeleteSecurityGroupInput{GroupId: group.GroupId})
+	return err
+}
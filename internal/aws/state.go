@@ -0,0 +1,142 @@
+package aws
+
+import (
+	"context"
+	"strings"
+	"tidalwave/internal/state"
+	"tidalwave/internal/tidalwave"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	ec2types "github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	ekstypes "github.com/aws/aws-sdk-go-v2/service/eks/types"
+	iamtypes "github.com/aws/aws-sdk-go-v2/service/iam/types"
+	kmstypes "github.com/aws/aws-sdk-go-v2/service/kms/types"
+)
+
+// newResource builds a state entry, EC2 does not report when most resources were created so
+// a nil created is the time they were recorded
+func newResource(kind, name, selfLink string, created *time.Time, fingerprint string, config interface{}) state.Resource {
+	t := time.Now().UTC()
+	if created != nil {
+		t = created.UTC()
+	}
+	return state.Resource{
+		Kind:        kind,
+		Name:        name,
+		SelfLink:    selfLink,
+		Created:     t,
+		Fingerprint: fingerprint,
+		ConfigHash:  state.Hash(config),
+	}
+}
+
+func vpcResource(v *ec2types.Vpc, config *Vpc) state.Resource {
+	return newResource("vpc", config.Name, aws.ToString(v.VpcId), nil, aws.ToString(v.VpcId), config)
+}
+
+func gatewayResource(s *ec2types.Subnet, config *Gateway) state.Resource {
+	return newResource("gateway", config.Name, aws.ToString(s.SubnetArn), nil, aws.ToString(s.SubnetId), config)
+}
+
+func natResource(n *ec2types.NatGateway, config *Nat) state.Resource {
+	return newResource("nat", config.Name, aws.ToString(n.NatGatewayId), n.CreateTime, aws.ToString(n.NatGatewayId), config)
+}
+
+func subnetsResource(s []ec2types.Subnet, config *Subnets) state.Resource {
+	ids := strings.Join(subnetIDs(s), ",")
+	return newResource("subnets", config.Name, ids, nil, ids, config)
+}
+
+func securityGroupResource(s *ec2types.SecurityGroup, config *SecurityGroup) state.Resource {
+	return newResource("securitygroup", config.Name, aws.ToString(s.GroupId), nil, aws.ToString(s.GroupId), config)
+}
+
+func keyResource(k *kmstypes.KeyMetadata, config *Key) state.Resource {
+	return newResource("key", config.Name, aws.ToString(k.Arn), k.CreationDate, aws.ToString(k.KeyId), config)
+}
+
+func rolesResource(r []*iamtypes.Role, config *Roles) state.Resource {
+	arns, ids := []string{}, []string{}
+	for _, role := range r {
+		arns = append(arns, aws.ToString(role.Arn))
+		ids = append(ids, aws.ToString(role.RoleId))
+	}
+	return newResource("roles", config.Name, strings.Join(arns, ","), r[0].CreateDate, strings.Join(ids, ","), config)
+}
+
+func clusterResource(c *ekstypes.Cluster, config *Cluster) state.Resource {
+	return newResource("cluster", config.Name, aws.ToString(c.Arn), c.CreatedAt, clusterFingerprint(c), config)
+}
+
+// clusterFingerprint tells clusters with the same name apart by when they were created, EKS
+// reuses the ARN
+func clusterFingerprint(c *ekstypes.Cluster) string {
+	return aws.ToTime(c.CreatedAt).UTC().Format(time.RFC3339Nano)
+}
+
+func nodeGroupResource(n *ekstypes.Nodegroup, config *NodeGroup) state.Resource {
+	return newResource("nodegroup", config.Name, aws.ToString(n.NodegroupArn), n.CreatedAt, aws.ToString(n.NodegroupArn), config)
+}
+
+// track records a resource in state if tidalwave created it or already manages it.
+// Resources that existed before tidalwave touched them are left out so they are never deleted.
+func (c *Controlplane) track(ctx context.Context, existed bool, r state.Resource) error {
+	if c.State == nil {
+		return nil
+	}
+	if _, ok := c.State.Get(r.Kind, r.Name); existed && !ok {
+		tidalwave.Warnf(ctx, "%s %s already exists and was not created by tidalwave, it is not tracked", r.Kind, r.Name)
+		return nil
+	}
+	return c.State.Put(ctx, r)
+}
+
+// owned reports whether a live resource may be deleted, it must be in state and be the
+// same instance tidalwave created
+func (c *Controlplane) owned(ctx context.Context, kind, name, fingerprint string) bool {
+	if c.State == nil || c.Force {
+		return true
+	}
+	r, ok := c.State.Get(kind, name)
+	if !ok {
+		tidalwave.Infof(ctx, ":stop_sign:", "%s %s was not created by tidalwave, skipping delete", kind, name)
+		return false
+	}
+	if r.Fingerprint != fingerprint {
+		tidalwave.Infof(ctx, ":stop_sign:", "%s %s was recreated outside of tidalwave, skipping delete", kind, name)
+		return false
+	}
+	return true
+}
+
+// destroy deletes a resource if tidalwave owns it and removes it from state. get returns the
+// fingerprint of the live resource.
+func (c *Controlplane) destroy(ctx context.Context, kind, name string, get func() (string, error), del func() error) error {
+	fingerprint, err := get()
+	if isNotFound(err) {
+		return c.forget(ctx, kind, name)
+	}
+	if err != nil {
+		return err
+	}
+	if !c.owned(ctx, kind, name, fingerprint) {
+		return nil
+	}
+	if err := del(); err != nil {
+		return err
+	}
+	tidalwave.Infof(ctx, ":cross_mark_button:", "Controlplane %s %s destroyed", kind, name)
+	return c.forget(ctx, kind, name)
+}
+
+// forget removes a deleted resource from state
+func (c *Controlplane) forget(ctx context.Context, kind, name string) error {
+	if c.State == nil {
+		return nil
+	}
+	if _, ok := c.State.Get(kind, name); !ok {
+		return nil
+	}
+	return c.State.Remove(ctx, kind, name)
+}
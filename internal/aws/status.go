@@ -0,0 +1,106 @@
+package aws
+
+import (
+	"context"
+	"fmt"
+	"tidalwave/internal/tidalwave"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	ec2types "github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	ekstypes "github.com/aws/aws-sdk-go-v2/service/eks/types"
+)
+
+// Status reports whether every resource exists, whether it matches the config and its key
+// attributes, nothing is changed
+func (c *Controlplane) Status(ctx context.Context) ([]tidalwave.ResourceStatus, error) {
+	cl, err := newClients(ctx, c.Region, c.Endpoint)
+	if err != nil {
+		return nil, err
+	}
+	return c.status(ctx, cl)
+}
+
+// status reports the status of every resource using cl
+func (c *Controlplane) status(ctx context.Context, cl *clients) ([]tidalwave.ResourceStatus, error) {
+	plan, err := c.plan(ctx, cl)
+	if err != nil {
+		return nil, err
+	}
+	status := tidalwave.StatusFromPlan(plan)
+	for i, s := range status {
+		if !s.Exists {
+			continue
+		}
+		attributes, err := c.attributes(ctx, cl, s.Kind)
+		if err != nil {
+			return nil, fmt.Errorf("%s %s: %w", s.Kind, s.Name, err)
+		}
+		for k, v := range attributes {
+			if v == "" {
+				delete(attributes, k)
+			}
+		}
+		status[i].Attributes = attributes
+	}
+	return status, nil
+}
+
+// attributes fetches a live resource of the plan and returns its key attributes, only the
+// VPC, cluster and node group have any
+func (c *Controlplane) attributes(ctx context.Context, cl *clients, kind string) (map[string]string, error) {
+	switch kind {
+	case "vpc":
+		v, err := c.Vpc.get(ctx, cl.ec2)
+		if err != nil {
+			return nil, err
+		}
+		return vpcAttributes(v), nil
+	case "cluster":
+		cluster, err := c.Cluster.get(ctx, cl.eks)
+		if err != nil {
+			return nil, err
+		}
+		return clusterAttributes(cluster), nil
+	case "nodegroup":
+		group, err := c.NodeGroup.get(ctx, cl.eks)
+		if err != nil {
+			return nil, err
+		}
+		return nodeGroupAttributes(group), nil
+	}
+	return nil, nil
+}
+
+// vpcAttributes are the key attributes of a live VPC
+func vpcAttributes(v *ec2types.Vpc) map[string]string {
+	return map[string]string{
+		"id":    aws.ToString(v.VpcId),
+		"cidr":  aws.ToString(v.CidrBlock),
+		"state": string(v.State),
+	}
+}
+
+// clusterAttributes are the key attributes of a live EKS cluster
+func clusterAttributes(cluster *ekstypes.Cluster) map[string]string {
+	return map[string]string{
+		"status":          string(cluster.Status),
+		"version":         aws.ToString(cluster.Version),
+		"platformVersion": aws.ToString(cluster.PlatformVersion),
+		"endpoint":        aws.ToString(cluster.Endpoint),
+	}
+}
+
+// nodeGroupAttributes are the key attributes of a live node group
+func nodeGroupAttributes(group *ekstypes.Nodegroup) map[string]string {
+	scaling := group.ScalingConfig
+	if scaling == nil {
+		scaling = &ekstypes.NodegroupScalingConfig{}
+	}
+	return map[string]string{
+		"status":        string(group.Status),
+		"version":       aws.ToString(group.Version),
+		"instanceTypes": list(group.InstanceTypes),
+		"scaling":       fmt.Sprintf("%d-%d", aws.ToInt32(scaling.MinSize), aws.ToInt32(scaling.MaxSize)),
+		"desiredSize":   fmt.Sprint(aws.ToInt32(scaling.DesiredSize)),
+	}
+}
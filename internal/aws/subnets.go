@@ -0,0 +1,145 @@
+package aws
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"tidalwave/internal/aws/awsapi"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+// Subnets represents the private subnets the cluster and its nodes run in, one per zone
+type Subnets struct {
+	Name         string
+	VpcID        string
+	RouteTableID string
+	// Cidrs are the CIDR blocks of the subnets, matched to Zones by index
+	Cidrs []string
+	Zones []string
+}
+
+func (s *Subnets) subnetName(zone string) string {
+	return fmt.Sprintf("%s-private-%s", s.Name, zone)
+}
+
+// Create the private subnets and route them through the NAT gateway
+func (s *Subnets) create(ctx context.Context, client awsapi.EC2Client) ([]types.Subnet, error) {
+	if len(s.Zones) < len(s.Cidrs) {
+		return nil, fmt.Errorf("%d private subnets need %d zones, only %d given", len(s.Cidrs), len(s.Cidrs), len(s.Zones))
+	}
+	existing, err := s.get(ctx, client)
+	if err != nil && !isNotFound(err) {
+		return nil, err
+	}
+	byName := map[string]types.Subnet{}
+	for _, subnet := range existing {
+		byName[tag(subnet.Tags, "Name")] = subnet
+	}
+	for i, cidr := range s.Cidrs {
+		name := s.subnetName(s.Zones[i])
+		subnet, ok := byName[name]
+		if ok && aws.ToString(subnet.CidrBlock) != cidr {
+			return nil, fmt.Errorf("subnet %s must be deleted and recreated to change cidr from %s to %s", name, aws.ToString(subnet.CidrBlock), cidr)
+		}
+		if ok {
+			continue
+		}
+		_, err := client.CreateSubnet(ctx, &ec2.CreateSubnetInput{
+			VpcId:            aws.String(s.VpcID),
+			CidrBlock:        aws.String(cidr),
+			AvailabilityZone: aws.String(s.Zones[i]),
+			TagSpecifications: tagSpec(types.ResourceTypeSubnet, name, s.Name, map[string]string{
+				"kubernetes.io/role/internal-elb":               "1",
+				fmt.Sprintf("kubernetes.io/cluster/%s", s.Name): "shared",
+			}),
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	subnets, err := s.get(ctx, client)
+	if err != nil {
+		return nil, err
+	}
+	tables, err := client.DescribeRouteTables(ctx, &ec2.DescribeRouteTablesInput{
+		RouteTableIds: []string{s.RouteTableID},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(tables.RouteTables) == 0 {
+		return nil, &notFoundError{kind: "route table", name: s.RouteTableID}
+	}
+	for _, subnet := range subnets {
+		if err := associate(ctx, client, &tables.RouteTables[0], aws.ToString(subnet.SubnetId)); err != nil {
+			return nil, err
+		}
+	}
+	return subnets, nil
+}
+
+// Get the private subnets, sorted by zone
+func (s *Subnets) get(ctx context.Context, client awsapi.EC2Client) ([]types.Subnet, error) {
+	resp, err := client.DescribeSubnets(ctx, &ec2.DescribeSubnetsInput{Filters: nameFilter(s.subnetName("*"))})
+	if err != nil {
+		return nil, err
+	}
+	if len(resp.Subnets) == 0 {
+		return nil, &notFoundError{kind: "subnets", name: s.subnetName("*")}
+	}
+	subnets := resp.Subnets
+	sort.Slice(subnets, func(i, j int) bool {
+		return aws.ToString(subnets[i].AvailabilityZone) < aws.ToString(subnets[j].AvailabilityZone)
+	})
+	return subnets, nil
+}
+
+// Check if any private subnet exists
+func (s *Subnets) exists(ctx context.Context, client awsapi.EC2Client) bool {
+	_, err := s.get(ctx, client)
+	return err == nil
+}
+
+// Update private subnets, missing subnets are created
+func (s *Subnets) update(ctx context.Context, client awsapi.EC2Client) ([]types.Subnet, error) {
+	return s.create(ctx, client)
+}
+
+// Delete the private subnets
+func (s *Subnets) delete(ctx context.Context, client awsapi.EC2Client) error {
+	subnets, err := s.get(ctx, client)
+	if isNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	for _, subnet := range subnets {
+		if _, err := client.DeleteSubnet(ctx, &ec2.DeleteSubnetInput{SubnetId: subnet.SubnetId}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// subnetIDs returns the ids of subnets
+func subnetIDs(subnets []types.Subnet) []string {
+	ids := []string{}
+	for _, s := range subnets {
+		ids = append(ids, aws.ToString(s.SubnetId))
+	}
+	return ids
+}
+
+// tag returns the value of an EC2 tag
+func tag(tags []types.Tag, key string) string {
+	for _, t := range tags {
+		if aws.ToString(t.Key) == key {
+			return aws.ToString(t.Value)
+		}
+	}
+	return ""
+}
@@ -0,0 +1,40 @@
+package aws
+
+import (
+	"sort"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+// ControlplaneTag is set on every EC2 resource tidalwave creates, its value is the controlplane name
+const ControlplaneTag = "tidalwave:controlplane"
+
+// tagSpec tags a new EC2 resource with its name, the controlplane and any extra tags
+func tagSpec(resource types.ResourceType, name, controlplane string, extra map[string]string) []types.TagSpecification {
+	tags := []types.Tag{
+		{Key: aws.String("Name"), Value: aws.String(name)},
+		{Key: aws.String(ControlplaneTag), Value: aws.String(controlplane)},
+	}
+	keys := []string{}
+	for k := range extra {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		tags = append(tags, types.Tag{Key: aws.String(k), Value: aws.String(extra[k])})
+	}
+	return []types.TagSpecification{{ResourceType: resource, Tags: tags}}
+}
+
+// nameFilter finds EC2 resources by their Name tag
+func nameFilter(name string) []types.Filter {
+	return []types.Filter{
+		{Name: aws.String("tag:Name"), Values: []string{name}},
+	}
+}
+
+// filter returns a single EC2 filter
+func filter(name string, values ...string) types.Filter {
+	return types.Filter{Name: aws.String(name), Values: values}
+}
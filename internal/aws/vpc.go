@@ -0,0 +1,136 @@
+package aws
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"tidalwave/internal/aws/awsapi"
+	"tidalwave/internal/tidalwave"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+// Vpc represents a VPC with the DNS support EKS needs
+type Vpc struct {
+	Name string
+	Cidr string
+}
+
+// Create VPC
+func (v *Vpc) create(ctx context.Context, client awsapi.EC2Client) (*types.Vpc, error) {
+	if v.exists(ctx, client) {
+		return v.get(ctx, client)
+	}
+	resp, err := client.CreateVpc(ctx, &ec2.CreateVpcInput{
+		CidrBlock:         aws.String(v.Cidr),
+		TagSpecifications: tagSpec(types.ResourceTypeVpc, v.Name, v.Name, nil),
+	})
+	if err != nil {
+		return nil, err
+	}
+	id := aws.ToString(resp.Vpc.VpcId)
+	err = waitFor(ctx, id, fmt.Sprintf("create vpc %s", v.Name), func(max time.Duration) error {
+		return ec2.NewVpcAvailableWaiter(client).Wait(ctx, &ec2.DescribeVpcsInput{VpcIds: []string{id}}, max)
+	})
+	if err != nil {
+		return nil, err
+	}
+	// EKS nodes need DNS hostnames to register with the cluster, the attributes can only be
+	// modified one at a time
+	for _, attr := range []*ec2.ModifyVpcAttributeInput{
+		{VpcId: aws.String(id), EnableDnsSupport: &types.AttributeBooleanValue{Value: aws.Bool(true)}},
+		{VpcId: aws.String(id), EnableDnsHostnames: &types.AttributeBooleanValue{Value: aws.Bool(true)}},
+	} {
+		if _, err := client.ModifyVpcAttribute(ctx, attr); err != nil {
+			return nil, err
+		}
+	}
+	return v.get(ctx, client)
+}
+
+// Get VPC by its Name tag
+func (v *Vpc) get(ctx context.Context, client awsapi.EC2Client) (*types.Vpc, error) {
+	resp, err := client.DescribeVpcs(ctx, &ec2.DescribeVpcsInput{Filters: nameFilter(v.Name)})
+	if err != nil {
+		return nil, err
+	}
+	if len(resp.Vpcs) == 0 {
+		return nil, &notFoundError{kind: "vpc", name: v.Name}
+	}
+	return &resp.Vpcs[0], nil
+}
+
+// Check if VPC exists
+func (v *Vpc) exists(ctx context.Context, client awsapi.EC2Client) bool {
+	_, err := v.get(ctx, client)
+	return err == nil
+}
+
+// Diff VPC against the config
+func (v *Vpc) diff(ctx context.Context, client awsapi.EC2Client) (*tidalwave.ResourcePlan, error) {
+	vpc, err := v.get(ctx, client)
+	if isNotFound(err) {
+		return tidalwave.NewResourcePlan("vpc", v.Name, false, nil), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return tidalwave.NewResourcePlan("vpc", v.Name, true, v.compare(vpc)), nil
+}
+
+// Compare a live VPC with the config
+func (v *Vpc) compare(vpc *types.Vpc) []tidalwave.Change {
+	d := differ{}
+	d.forceNew("cidr", aws.ToString(vpc.CidrBlock), v.Cidr)
+	return d.changes
+}
+
+// Update VPC, the CIDR block cannot be changed in place
+func (v *Vpc) update(ctx context.Context, client awsapi.EC2Client) (*types.Vpc, error) {
+	vpc, err := v.get(ctx, client)
+	if isNotFound(err) {
+		return v.create(ctx, client)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := forceNewError("vpc", v.Name, v.compare(vpc)); err != nil {
+		return nil, err
+	}
+	return vpc, nil
+}
+
+// Delete VPC
+func (v *Vpc) delete(ctx context.Context, client awsapi.EC2Client) error {
+	vpc, err := v.get(ctx, client)
+	if isNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	_, err = client.DeleteVpc(ctx, &ec2.DeleteVpcInput{VpcId: vpc.VpcId})
+	return err
+}
+
+// availableZones returns the available zones of the region, limited to n
+func availableZones(ctx context.Context, client awsapi.EC2Client, n int) ([]string, error) {
+	resp, err := client.DescribeAvailabilityZones(ctx, &ec2.DescribeAvailabilityZonesInput{
+		Filters: []types.Filter{filter("state", "available")},
+	})
+	if err != nil {
+		return nil, err
+	}
+	names := []string{}
+	for _, z := range resp.AvailabilityZones {
+		names = append(names, aws.ToString(z.ZoneName))
+	}
+	sort.Strings(names)
+	if len(names) < n {
+		return nil, fmt.Errorf("%d private subnets need %d availability zones, the region only has %d", n, n, len(names))
+	}
+	return names[:n], nil
+}
@@ -0,0 +1,57 @@
+package aws
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"tidalwave/internal/aws/awsapi"
+	"tidalwave/internal/tidalwave"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/eks"
+	ekstypes "github.com/aws/aws-sdk-go-v2/service/eks/types"
+)
+
+// defaultWait bounds the SDK waiters when the context has no deadline
+const defaultWait = time.Hour
+
+// updatePollInterval is how often an EKS update is checked
+var updatePollInterval = 15 * time.Second
+
+// waitFor runs an SDK waiter, recording id as running while it waits. wait is given how long
+// it may take, which is the time left before the context deadline.
+func waitFor(ctx context.Context, id, description string, wait func(max time.Duration) error) error {
+	defer tidalwave.StartOperation(ctx, id, description)()
+	max := defaultWait
+	if deadline, ok := ctx.Deadline(); ok {
+		max = time.Until(deadline)
+	}
+	return wait(max)
+}
+
+// waitUpdate waits for an EKS cluster or node group update, the SDK has no waiter for updates
+func waitUpdate(ctx context.Context, client awsapi.EKSClient, req *eks.DescribeUpdateInput, description string) error {
+	defer tidalwave.StartOperation(ctx, aws.ToString(req.UpdateId), description)()
+	for {
+		resp, err := client.DescribeUpdate(ctx, req)
+		if err != nil {
+			return err
+		}
+		switch resp.Update.Status {
+		case ekstypes.UpdateStatusSuccessful:
+			return nil
+		case ekstypes.UpdateStatusFailed, ekstypes.UpdateStatusCancelled:
+			details := []string{}
+			for _, e := range resp.Update.Errors {
+				details = append(details, fmt.Sprintf("%s: %s", e.ErrorCode, aws.ToString(e.ErrorMessage)))
+			}
+			return fmt.Errorf("%s %s: %s", description, strings.ToLower(string(resp.Update.Status)), strings.Join(details, ", "))
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(updatePollInterval):
+		}
+	}
+}
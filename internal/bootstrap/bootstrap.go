@@ -0,0 +1,302 @@
+/*
+Package bootstrap turns a new cluster into a DevOps controlplane. It installs Argo CD,
+creates the AppProject the bundled Applications belong to and applies kustomizations of
+Applications, waiting for Argo CD to sync them.
+*/
+package bootstrap
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"strings"
+	"tidalwave/internal/kube"
+	"tidalwave/internal/tidalwave"
+	"time"
+)
+
+const (
+	// Namespace is where Argo CD and its Applications are
+	Namespace = "argocd"
+	// Project is the AppProject the bundled Applications belong to
+	Project = "cluster-addons"
+	// defaultInterval is how often Applications are checked
+	defaultInterval = 10 * time.Second
+)
+
+// Options are what Run installs
+type Options struct {
+	// Manifest is the URL or file of the Argo CD install manifest
+	Manifest string
+	// Manifests holds the kustomizations
+	Manifests fs.FS
+	// Kustomizations are the directories of Manifests that are applied
+	Kustomizations []string
+	// Timeout limits how long each step may take, zero means no limit
+	Timeout time.Duration
+	// Parallelism is how many kustomizations are applied at once
+	Parallelism int
+	// Interval is how often Applications are checked, 10s if zero
+	Interval time.Duration
+}
+
+// bootstrapper runs the steps of a bootstrap against one cluster
+type bootstrapper struct {
+	client   *kube.Client
+	interval time.Duration
+}
+
+// Run installs Argo CD into the cluster of c, creates the cluster-addons AppProject and applies
+// the kustomizations, waiting for their Applications to be Synced and Healthy. The manifests
+// are read before anything is applied so mistakes in them leave the cluster untouched.
+func Run(ctx context.Context, c *kube.Client, opts Options) error {
+	kustomizations := map[string][]kube.Object{}
+	for _, k := range opts.Kustomizations {
+		objs, err := Kustomize(opts.Manifests, k)
+		if err != nil {
+			return err
+		}
+		kustomizations[k] = objs
+	}
+	data, err := readManifest(ctx, opts.Manifest)
+	if err != nil {
+		return err
+	}
+	install, err := kube.Decode(data)
+	if err != nil {
+		return fmt.Errorf("%s: %w", opts.Manifest, err)
+	}
+
+	b := &bootstrapper{client: c, interval: opts.Interval}
+	if b.interval == 0 {
+		b.interval = defaultInterval
+	}
+	g := tidalwave.NewGraph(opts.Parallelism)
+	nodes := []tidalwave.Node{
+		{
+			Name:    "namespace/" + Namespace,
+			Timeout: opts.Timeout,
+			Create: func(ctx context.Context, in tidalwave.Outputs) (tidalwave.Outputs, error) {
+				return nil, b.apply(ctx, namespace(Namespace))
+			},
+		},
+		{
+			Name:    "argocd",
+			Deps:    []string{"namespace/" + Namespace},
+			Timeout: opts.Timeout,
+			Create: func(ctx context.Context, in tidalwave.Outputs) (tidalwave.Outputs, error) {
+				return nil, b.install(ctx, install)
+			},
+		},
+		{
+			Name:    "appproject/" + Project,
+			Deps:    []string{"argocd"},
+			Timeout: opts.Timeout,
+			Create: func(ctx context.Context, in tidalwave.Outputs) (tidalwave.Outputs, error) {
+				return nil, b.apply(ctx, project())
+			},
+		},
+	}
+	for _, k := range opts.Kustomizations {
+		objs := kustomizations[k]
+		nodes = append(nodes, tidalwave.Node{
+			Name:    "kustomization/" + k,
+			Deps:    []string{"appproject/" + Project},
+			Timeout: opts.Timeout,
+			Create: func(ctx context.Context, in tidalwave.Outputs) (tidalwave.Outputs, error) {
+				return nil, b.kustomization(ctx, objs)
+			},
+		})
+	}
+	for _, n := range nodes {
+		if err := g.Add(n); err != nil {
+			return err
+		}
+	}
+	return g.Apply(ctx)
+}
+
+// readManifest reads the Argo CD install manifest from a URL or a file
+func readManifest(ctx context.Context, source string) ([]byte, error) {
+	if !strings.HasPrefix(source, "https://") && !strings.HasPrefix(source, "http://") {
+		return os.ReadFile(source)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, source, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s: %s", source, resp.Status)
+	}
+	return io.ReadAll(resp.Body)
+}
+
+// apply applies obj, retrying while its kind is not served yet because the definition of a
+// custom resource is still being established
+func (b *bootstrapper) apply(ctx context.Context, obj kube.Object) error {
+	for {
+		_, err := b.client.Apply(ctx, obj)
+		if !errors.Is(err, kube.ErrUnknownKind) {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(b.interval):
+		}
+	}
+}
+
+// install applies the Argo CD install manifest, objects without a namespace are put in the
+// argocd namespace
+func (b *bootstrapper) install(ctx context.Context, objs []kube.Object) error {
+	for _, obj := range objs {
+		if obj.Namespace() == "" {
+			obj.SetNamespace(Namespace)
+		}
+		if err := b.apply(ctx, obj); err != nil {
+			return err
+		}
+	}
+	tidalwave.Infof(ctx, ":octopus:", "Argo CD installed in namespace %s", Namespace)
+	return nil
+}
+
+// kustomization applies the objects of a kustomization, starts a sync of the Applications that
+// do not sync automatically and waits for all of them to be Synced and Healthy
+func (b *bootstrapper) kustomization(ctx context.Context, objs []kube.Object) error {
+	apps := []kube.Object{}
+	for _, obj := range objs {
+		if err := b.apply(ctx, obj); err != nil {
+			return err
+		}
+		if isApplication(obj) {
+			apps = append(apps, obj)
+		}
+	}
+	for _, app := range apps {
+		if app.Get("spec", "syncPolicy", "automated") != nil {
+			continue
+		}
+		live, err := b.client.Get(ctx, app.APIVersion(), app.Kind(), app.Namespace(), app.Name())
+		if err != nil {
+			return err
+		}
+		if ready(live) || live.Get("operation") != nil {
+			continue
+		}
+		if _, err := b.client.Patch(ctx, app.APIVersion(), app.Kind(), app.Namespace(), app.Name(), syncOperation()); err != nil {
+			return err
+		}
+	}
+	return b.wait(ctx, apps)
+}
+
+// wait polls apps until every one is Synced and Healthy or one fails to sync
+func (b *bootstrapper) wait(ctx context.Context, apps []kube.Object) error {
+	if len(apps) == 0 {
+		return nil
+	}
+	defer tidalwave.StartOperation(ctx, "applications", fmt.Sprintf("Waiting for %d Applications to be Synced and Healthy", len(apps)))()
+	last := -1
+	for {
+		pending := []string{}
+		for _, app := range apps {
+			live, err := b.client.Get(ctx, app.APIVersion(), app.Kind(), app.Namespace(), app.Name())
+			if err != nil {
+				return err
+			}
+			if phase := live.GetString("status", "operationState", "phase"); phase == "Failed" || phase == "Error" {
+				return fmt.Errorf("application %s: sync %s: %s", app.Name(), strings.ToLower(phase), live.GetString("status", "operationState", "message"))
+			}
+			if !ready(live) {
+				pending = append(pending, fmt.Sprintf("%s (%s, %s)", app.Name(),
+					orUnknown(live.GetString("status", "sync", "status")), orUnknown(live.GetString("status", "health", "status"))))
+			}
+		}
+		done := len(apps) - len(pending)
+		if done != last {
+			last = done
+			tidalwave.Emit(ctx, tidalwave.Event{
+				Phase:    tidalwave.PhaseProgress,
+				Progress: done * 100 / len(apps),
+				Emoji:    ":hourglass_not_done:",
+				Message:  fmt.Sprintf("%d/%d Applications Synced and Healthy", done, len(apps)),
+			})
+		}
+		if len(pending) == 0 {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("waiting for %s: %w", strings.Join(pending, ", "), ctx.Err())
+		case <-time.After(b.interval):
+		}
+	}
+}
+
+// isApplication reports whether obj is an Argo CD Application
+func isApplication(obj kube.Object) bool {
+	return obj.Kind() == "Application" && strings.HasPrefix(obj.APIVersion(), "argoproj.io/")
+}
+
+// ready reports whether an Application is Synced and Healthy
+func ready(app kube.Object) bool {
+	return app.GetString("status", "sync", "status") == "Synced" && app.GetString("status", "health", "status") == "Healthy"
+}
+
+func orUnknown(s string) string {
+	if s == "" {
+		return "Unknown"
+	}
+	return s
+}
+
+// syncOperation is the patch that asks Argo CD to sync an Application once, the way argocd app
+// sync does
+func syncOperation() map[string]interface{} {
+	return map[string]interface{}{
+		"operation": map[string]interface{}{
+			"initiatedBy": map[string]interface{}{"username": "tidalwave"},
+			"sync":        map[string]interface{}{},
+		},
+	}
+}
+
+// namespace returns a Namespace object
+func namespace(name string) kube.Object {
+	return kube.Object{
+		"apiVersion": "v1",
+		"kind":       "Namespace",
+		"metadata":   map[string]interface{}{"name": name},
+	}
+}
+
+// project returns the AppProject the bundled Applications belong to, it may deploy anything
+// from any repository into the cluster Argo CD runs in
+func project() kube.Object {
+	return kube.Object{
+		"apiVersion": "argoproj.io/v1alpha1",
+		"kind":       "AppProject",
+		"metadata":   map[string]interface{}{"name": Project, "namespace": Namespace},
+		"spec": map[string]interface{}{
+			"description": "Cluster addons installed by tidalwave",
+			"sourceRepos": []interface{}{"*"},
+			"destinations": []interface{}{
+				map[string]interface{}{"server": "https://kubernetes.default.svc", "namespace": "*"},
+			},
+			"clusterResourceWhitelist": []interface{}{
+				map[string]interface{}{"group": "*", "kind": "*"},
+			},
+		},
+	}
+}
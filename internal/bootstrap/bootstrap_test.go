@@ -0,0 +1,144 @@
+package bootstrap
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"tidalwave/internal/addons"
+	"tidalwave/internal/config"
+	"tidalwave/internal/kube"
+	"tidalwave/internal/kube/kubetest"
+	"tidalwave/internal/tidalwave"
+	"time"
+)
+
+// install is a small stand-in for the Argo CD install manifest
+const install = `apiVersion: apiextensions.k8s.io/v1
+kind: CustomResourceDefinition
+metadata:
+  name: applications.argoproj.io
+spec:
+  group: argoproj.io
+  scope: Namespaced
+  names:
+    kind: Application
+    plural: applications
+  versions:
+    - name: v1alpha1
+---
+apiVersion: apiextensions.k8s.io/v1
+kind: CustomResourceDefinition
+metadata:
+  name: appprojects.argoproj.io
+spec:
+  group: argoproj.io
+  scope: Namespaced
+  names:
+    kind: AppProject
+    plural: appprojects
+  versions:
+    - name: v1alpha1
+---
+apiVersion: v1
+kind: ServiceAccount
+metadata:
+  name: argocd-application-controller
+---
+apiVersion: rbac.authorization.k8s.io/v1
+kind: ClusterRole
+metadata:
+  name: argocd-application-controller
+`
+
+// bootstrap runs Run against a fake API server with the bundled manifests
+func bootstrap(t *testing.T, reconcile func(kube.Object)) (*kubetest.Server, error) {
+	t.Helper()
+	server := kubetest.NewServer("secret")
+	t.Cleanup(server.Close)
+	server.Reconcile = reconcile
+	manifest := filepath.Join(t.TempDir(), "install.yaml")
+	if err := os.WriteFile(manifest, []byte(install), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	c, err := kube.New(server.URL, &tidalwave.ClusterCredentials{CertificateAuthority: server.CertificateAuthority(), Token: "secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defaults := &config.Config{}
+	defaults.ApplyDefaults()
+	ctx := tidalwave.WithBus(context.Background(), tidalwave.NewBus(&tidalwave.PlainRenderer{W: &strings.Builder{}}))
+	return server, Run(ctx, c, Options{
+		Manifest:       manifest,
+		Manifests:      addons.FS(defaults.Spec.Addons),
+		Kustomizations: []string{"argocd-apps/connect", "argocd-apps/security"},
+		Timeout:        5 * time.Second,
+		Interval:       time.Millisecond,
+	})
+}
+
+// argocd syncs Applications the way the application controller would
+func argocd(app kube.Object) {
+	if app.Kind() != "Application" || app["operation"] == nil {
+		return
+	}
+	delete(app, "operation")
+	app["status"] = map[string]interface{}{
+		"sync":   map[string]interface{}{"status": "Synced"},
+		"health": map[string]interface{}{"status": "Healthy"},
+	}
+}
+
+func TestRun(t *testing.T) {
+	server, err := bootstrap(t, argocd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"namespaces//argocd",
+		"serviceaccounts/argocd/argocd-application-controller",
+		"clusterroles//argocd-application-controller",
+		"appprojects/argocd/cluster-addons",
+		"applications/argocd/istiod",
+		"applications/argocd/falco",
+	} {
+		if !contains(server.Objects(), want) {
+			t.Errorf("%s was not applied, got %v", want, server.Objects())
+		}
+	}
+	app := server.Object("Application", "argocd", "falco")
+	if app.GetString("spec", "project") != Project || app.GetString("status", "sync", "status") != "Synced" {
+		t.Errorf("falco = %v", app)
+	}
+	project := server.Object("AppProject", "argocd", Project)
+	if got := project.Get("spec", "sourceRepos"); !reflect.DeepEqual(got, []interface{}{"*"}) {
+		t.Errorf("sourceRepos = %v", got)
+	}
+}
+
+func TestRunSyncFailed(t *testing.T) {
+	_, err := bootstrap(t, func(app kube.Object) {
+		if app.Name() != "falco" || app["operation"] == nil {
+			argocd(app)
+			return
+		}
+		delete(app, "operation")
+		app["status"] = map[string]interface{}{
+			"operationState": map[string]interface{}{"phase": "Failed", "message": "the driver did not load"},
+		}
+	})
+	if err == nil || !strings.Contains(err.Error(), "application falco: sync failed: the driver did not load") {
+		t.Errorf("err = %v", err)
+	}
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,77 @@
+package bootstrap
+
+import (
+	"bytes"
+	"fmt"
+	"io/fs"
+	"path"
+	"strings"
+	"tidalwave/internal/kube"
+
+	"gopkg.in/yaml.v3"
+)
+
+// kustomization is the part of a kustomization.yaml bootstrap understands
+type kustomization struct {
+	APIVersion string   `yaml:"apiVersion"`
+	Kind       string   `yaml:"kind"`
+	Namespace  string   `yaml:"namespace"`
+	Resources  []string `yaml:"resources"`
+}
+
+// Kustomize returns the objects of the kustomization in dir of fsys with its namespace set on
+// them. Only namespace and resources are supported, resources may be files or directories of
+// other kustomizations in fsys.
+func Kustomize(fsys fs.FS, dir string) ([]kube.Object, error) {
+	file := path.Join(dir, "kustomization.yaml")
+	data, err := fs.ReadFile(fsys, file)
+	if err != nil {
+		return nil, err
+	}
+	k := &kustomization{}
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(k); err != nil {
+		return nil, fmt.Errorf("%s: only namespace and resources are supported: %w", file, err)
+	}
+	objects := []kube.Object{}
+	for _, r := range k.Resources {
+		if strings.Contains(r, "://") {
+			return nil, fmt.Errorf("%s: remote resource %s is not supported", file, r)
+		}
+		p := path.Join(dir, r)
+		info, err := fs.Stat(fsys, p)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", file, err)
+		}
+		var objs []kube.Object
+		if info.IsDir() {
+			objs, err = Kustomize(fsys, p)
+		} else {
+			objs, err = readObjects(fsys, p)
+		}
+		if err != nil {
+			return nil, err
+		}
+		objects = append(objects, objs...)
+	}
+	if k.Namespace != "" {
+		for _, obj := range objects {
+			obj.SetNamespace(k.Namespace)
+		}
+	}
+	return objects, nil
+}
+
+// readObjects decodes every object in a file of fsys
+func readObjects(fsys fs.FS, file string) ([]kube.Object, error) {
+	data, err := fs.ReadFile(fsys, file)
+	if err != nil {
+		return nil, err
+	}
+	objs, err := kube.Decode(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", file, err)
+	}
+	return objs, nil
+}
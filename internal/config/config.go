@@ -0,0 +1,469 @@
+/*
+Package config is the schema of the tidalwave config file, it decodes the YAML strictly and
+validates it before any cloud API is called
+*/
+package config
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	// APIVersion is the version of the config schema
+	APIVersion = "tidalwave.io/v1alpha1"
+	// Kind is the kind of document a config file holds
+	Kind = "Controlplane"
+	// ListKind is the kind of document that holds a fleet of controlplanes
+	ListKind = "ControlplaneList"
+)
+
+// Config is a tidalwave config file, the doc, enum, pattern, minimum and required tags describe
+// fields in the JSON Schema, pattern:"cidr" is an IPv4 CIDR block
+type Config struct {
+	APIVersion   string                 `yaml:"apiVersion" json:"apiVersion" doc:"Version of the config schema" enum:"tidalwave.io/v1alpha1"`
+	Kind         string                 `yaml:"kind" json:"kind" doc:"Kind of document" enum:"Controlplane"`
+	Metadata     Metadata               `yaml:"metadata" json:"metadata" doc:"Identifies the controlplane" required:"true"`
+	Spec         Spec                   `yaml:"spec" json:"spec" doc:"Describes the controlplane"`
+	Environments map[string]Environment `yaml:"environments,omitempty" json:"environments,omitempty" doc:"Overlays merged over metadata and spec by environment name, picked with --env"`
+
+	// lines maps field paths such as spec.cidrs.pods to the line they are set on
+	lines map[string]int
+	// from is the apiVersion of the file before it was migrated
+	from string
+}
+
+// Environment overrides the fields of the config it sets for one environment, mappings are
+// merged and every other value, lists included, replaces the base value
+type Environment struct {
+	Metadata Metadata `yaml:"metadata,omitempty" json:"metadata,omitempty" doc:"Overrides of metadata"`
+	Spec     Spec     `yaml:"spec,omitempty" json:"spec,omitempty" doc:"Overrides of spec"`
+}
+
+// Metadata identifies the controlplane
+type Metadata struct {
+	Name string `yaml:"name" json:"name" doc:"Name of the controlplane, every resource is named after it" pattern:"^[a-z]([-a-z0-9]{0,38}[a-z0-9])?$" required:"true"`
+}
+
+// Spec describes the controlplane
+type Spec struct {
+	Provider    string                 `yaml:"provider" json:"provider" doc:"Cloud provider, google, aws or the name of a provider plugin" enum:"google,aws" open:"true"`
+	ProjectID   string                 `yaml:"projectID,omitempty" json:"projectID,omitempty" doc:"GCP project the controlplane is created in, required by the google provider"`
+	Region      string                 `yaml:"region" json:"region" doc:"Region the controlplane is created in, us-east-1 by default on aws"`
+	Parallelism int                    `yaml:"parallelism" json:"parallelism" doc:"Number of resources provisioned at once, 4 if not set" minimum:"0"`
+	Cidrs       Cidrs                  `yaml:"cidrs" json:"cidrs" doc:"Address ranges of the network"`
+	Cluster     Cluster                `yaml:"cluster" json:"cluster" doc:"Kubernetes cluster and its default node pool"`
+	Timeouts    map[string]string      `yaml:"timeouts,omitempty" json:"timeouts,omitempty" doc:"How long each kind of resource may take, as a duration such as 10m" pattern:"^([0-9]+(\\.[0-9]+)?(ns|us|µs|ms|s|m|h))+$"`
+	State       State                  `yaml:"state,omitempty" json:"state,omitempty" doc:"Where tidalwave records the resources it created"`
+	Google      Google                 `yaml:"google,omitempty" json:"google,omitempty" doc:"Settings only used by the google provider"`
+	AWS         AWS                    `yaml:"aws,omitempty" json:"aws,omitempty" doc:"Settings only used by the aws provider"`
+	Plugins     map[string]string      `yaml:"plugins,omitempty" json:"plugins,omitempty" doc:"Paths of provider plugin executables by provider name"`
+	Plugin      map[string]interface{} `yaml:"plugin,omitempty" json:"plugin,omitempty" doc:"Settings passed to a provider plugin as is"`
+	Bootstrap   Bootstrap              `yaml:"bootstrap" json:"bootstrap" doc:"What is installed into the cluster once it is created"`
+	Addons      Addons                 `yaml:"addons" json:"addons" doc:"Add-ons of the bundled manifests Argo CD installs"`
+}
+
+// Cidrs are the address ranges of the network, nodes, pods and services are used by google
+// and vpc, public and private by aws
+type Cidrs struct {
+	Nodes    string   `yaml:"nodes,omitempty" json:"nodes,omitempty" doc:"Primary range of the google subnetwork" pattern:"cidr"`
+	Pods     string   `yaml:"pods,omitempty" json:"pods,omitempty" doc:"Secondary range of the google subnetwork for pods" pattern:"cidr"`
+	Services string   `yaml:"services,omitempty" json:"services,omitempty" doc:"Secondary range of the google subnetwork for services" pattern:"cidr"`
+	Vpc      string   `yaml:"vpc,omitempty" json:"vpc,omitempty" doc:"Range of the aws VPC, 10.0.0.0/16 by default" pattern:"cidr"`
+	Public   string   `yaml:"public,omitempty" json:"public,omitempty" doc:"Range of the aws public subnet the NAT gateway is in, 10.0.96.0/24 by default" pattern:"cidr"`
+	Private  []string `yaml:"private,omitempty" json:"private,omitempty" doc:"Ranges of the aws private subnets, one per availability zone, three /19s by default" pattern:"cidr"`
+}
+
+// Cluster describes the Kubernetes cluster and its default node pool
+type Cluster struct {
+	Mode            string      `yaml:"mode,omitempty" json:"mode,omitempty" doc:"GKE cluster mode, autopilot clusters have their nodes managed by GKE so the node pool fields are not used. It cannot be changed once the cluster exists" enum:"standard,autopilot"`
+	Version         string      `yaml:"version,omitempty" json:"version,omitempty" doc:"Kubernetes version, the provider default if not set. On google a GKE version such as 1.24 or 1.24.5-gke.600 the cluster is created with and pinned to, with node auto-upgrade off and no release channel, controlplane upgrade moves it to a newer one"`
+	MachineType     string      `yaml:"machineType" json:"machineType" doc:"Machine type of the default node pool, m5.large by default on aws"`
+	DiskSize        int32       `yaml:"diskSize,omitempty" json:"diskSize,omitempty" doc:"Boot disk size of the nodes in GB, the provider default if not set" minimum:"0"`
+	MinNodeCount    int32       `yaml:"minNodeCount" json:"minNodeCount" doc:"Fewest nodes the default node pool scales down to" minimum:"0"`
+	MaxNodeCount    int32       `yaml:"maxNodeCount" json:"maxNodeCount" doc:"Most nodes the default node pool scales up to" minimum:"1"`
+	MasterAuthBlock []CidrBlock `yaml:"masterAuthBlock" json:"masterAuthBlock" doc:"Ranges allowed to reach the controlplane endpoint, everyone if not set on google. On aws the public endpoint is off unless ranges are listed"`
+	MasterCidrBlock string      `yaml:"masterCidrBlock,omitempty" json:"masterCidrBlock,omitempty" doc:"/28 range of the GKE controlplane" pattern:"cidr"`
+	ReleaseChannel  string      `yaml:"releaseChannel,omitempty" json:"releaseChannel,omitempty" doc:"GKE release channel, rapid by default. A cluster pinned to a version must be unspecified, which is the default then" enum:"rapid,regular,stable,unspecified"`
+	Datapath        string      `yaml:"datapath,omitempty" json:"datapath,omitempty" doc:"GKE networking datapath, advanced is Dataplane V2, it cannot be changed once the cluster exists" enum:"legacy,advanced"`
+	NodePools       []NodePool  `yaml:"nodePools,omitempty" json:"nodePools,omitempty" doc:"GKE node pools besides the default node pool, pools removed from the list are drained and deleted"`
+	ServiceAccount  string      `yaml:"serviceAccount,omitempty" json:"serviceAccount,omitempty" doc:"Email of an existing service account the GKE nodes run as, default for the Compute Engine default service account. A <name>-nodes account with only the logging, monitoring and Artifact Registry reader roles is created if not set"`
+	// the GKE features below are only used by the google provider
+	BinaryAuthorization   bool        `yaml:"binaryAuthorization" json:"binaryAuthorization" doc:"Enforces Binary Authorization on the GKE cluster, true by default"`
+	Addons                GKEAddons   `yaml:"addons" json:"addons" doc:"GKE add-ons, the add-ons bootstrap installs are under spec.addons"`
+	Maintenance           Maintenance `yaml:"maintenance" json:"maintenance" doc:"When GKE may upgrade and repair the cluster"`
+	Logging               []string    `yaml:"logging,omitempty" json:"logging,omitempty" doc:"Components whose logs are sent to Cloud Logging, system and workloads by default, an empty list turns logging off" enum:"system,workloads"`
+	Monitoring            []string    `yaml:"monitoring,omitempty" json:"monitoring,omitempty" doc:"Components whose metrics are sent to Cloud Monitoring, system by default, an empty list turns monitoring off" enum:"system,apiserver,scheduler,controller-manager"`
+	ImageStreaming        bool        `yaml:"imageStreaming,omitempty" json:"imageStreaming,omitempty" doc:"Streams container images to the nodes so pods start before the whole image is pulled"`
+	WorkloadMetadata      string      `yaml:"workloadMetadata,omitempty" json:"workloadMetadata,omitempty" doc:"Metadata server the pods see, gke-metadata is needed for Workload Identity, gce-metadata exposes the node's" enum:"gke-metadata,gce-metadata"`
+	CostAllocation        bool        `yaml:"costAllocation,omitempty" json:"costAllocation,omitempty" doc:"Breaks the cluster's costs down by namespace and label in the billing export"`
+	GatewayAPI            string      `yaml:"gatewayAPI,omitempty" json:"gatewayAPI,omitempty" doc:"Channel of the Gateway API GKE installs, disabled by default" enum:"disabled,standard,experimental"`
+	SecurityPosture       string      `yaml:"securityPosture,omitempty" json:"securityPosture,omitempty" doc:"Security posture dashboard of the cluster, basic by default" enum:"disabled,basic"`
+	VulnerabilityScanning bool        `yaml:"vulnerabilityScanning,omitempty" json:"vulnerabilityScanning,omitempty" doc:"Scans the images of running workloads for known vulnerabilities, needs securityPosture basic"`
+	MaxSurge              int32       `yaml:"maxSurge" json:"maxSurge" doc:"Nodes added at a time while the default node pool is upgraded, 1 by default" minimum:"0"`
+	MaxUnavailable        int32       `yaml:"maxUnavailable" json:"maxUnavailable" doc:"Nodes taken away at a time while the default node pool is upgraded, 1 by default" minimum:"0"`
+}
+
+// GKEAddons are the add-ons GKE manages in the cluster
+type GKEAddons struct {
+	HTTPLoadBalancing          bool `yaml:"httpLoadBalancing" json:"httpLoadBalancing" doc:"Ingress controller for Google Cloud load balancers, true by default"`
+	HorizontalPodAutoscaling   bool `yaml:"horizontalPodAutoscaling" json:"horizontalPodAutoscaling" doc:"Scales deployments on metrics, true by default"`
+	ConfigConnector            bool `yaml:"configConnector" json:"configConnector" doc:"Manages Google Cloud resources from Kubernetes, true by default, not available in autopilot mode"`
+	GcePersistentDiskCsiDriver bool `yaml:"gcePersistentDiskCsiDriver" json:"gcePersistentDiskCsiDriver" doc:"Persistent disk volumes, true by default, always on in autopilot mode"`
+	GcpFilestoreCsiDriver      bool `yaml:"gcpFilestoreCsiDriver" json:"gcpFilestoreCsiDriver" doc:"Filestore volumes, true by default, always on in autopilot mode"`
+}
+
+// Maintenance is when GKE may carry out automatic upgrades, either a daily window or a
+// recurring window
+type Maintenance struct {
+	DailyStartTime string                 `yaml:"dailyStartTime,omitempty" json:"dailyStartTime,omitempty" doc:"Start of the daily four hour window in UTC, 06:00 by default when there is no recurring window" pattern:"^([01][0-9]|2[0-3]):[0-5][0-9]$"`
+	Start          string                 `yaml:"start,omitempty" json:"start,omitempty" doc:"Start of the first occurrence of a recurring window, an RFC 3339 time"`
+	End            string                 `yaml:"end,omitempty" json:"end,omitempty" doc:"End of the first occurrence of a recurring window, an RFC 3339 time"`
+	Recurrence     string                 `yaml:"recurrence,omitempty" json:"recurrence,omitempty" doc:"RFC 5545 RRULE the recurring window repeats on, such as FREQ=WEEKLY;BYDAY=SA,SU" pattern:"^FREQ="`
+	Exclusions     []MaintenanceExclusion `yaml:"exclusions,omitempty" json:"exclusions,omitempty" doc:"Periods when upgrades are held back"`
+}
+
+// MaintenanceExclusion is a period when GKE does not upgrade the cluster
+type MaintenanceExclusion struct {
+	Name  string `yaml:"name" json:"name" doc:"Name of the exclusion" required:"true"`
+	Start string `yaml:"start" json:"start" doc:"Start of the exclusion, an RFC 3339 time" required:"true"`
+	End   string `yaml:"end" json:"end" doc:"End of the exclusion, an RFC 3339 time" required:"true"`
+	Scope string `yaml:"scope,omitempty" json:"scope,omitempty" doc:"Upgrades held back, no-upgrades by default" enum:"no-upgrades,no-minor-upgrades,no-minor-or-node-upgrades"`
+}
+
+// NodePool is a GKE node pool besides the default node pool
+type NodePool struct {
+	Name           string            `yaml:"name" json:"name" doc:"Name of the node pool" required:"true" pattern:"^[a-z]([-a-z0-9]{0,38}[a-z0-9])?$"`
+	MachineType    string            `yaml:"machineType,omitempty" json:"machineType,omitempty" doc:"Machine type of the nodes, spec.cluster.machineType by default"`
+	DiskType       string            `yaml:"diskType,omitempty" json:"diskType,omitempty" doc:"Boot disk type of the nodes" enum:"pd-standard,pd-balanced,pd-ssd"`
+	DiskSize       int32             `yaml:"diskSize,omitempty" json:"diskSize,omitempty" doc:"Boot disk size of the nodes in GB, spec.cluster.diskSize by default" minimum:"0"`
+	MinNodeCount   int32             `yaml:"minNodeCount" json:"minNodeCount" doc:"Fewest nodes the pool scales down to" minimum:"0"`
+	MaxNodeCount   int32             `yaml:"maxNodeCount" json:"maxNodeCount" doc:"Most nodes the pool scales up to" minimum:"1"`
+	Spot           bool              `yaml:"spot,omitempty" json:"spot,omitempty" doc:"Runs the nodes on Spot VMs"`
+	Preemptible    bool              `yaml:"preemptible,omitempty" json:"preemptible,omitempty" doc:"Runs the nodes on preemptible VMs"`
+	Labels         map[string]string `yaml:"labels,omitempty" json:"labels,omitempty" doc:"Kubernetes labels of the nodes"`
+	Taints         []Taint           `yaml:"taints,omitempty" json:"taints,omitempty" doc:"Kubernetes taints of the nodes"`
+	Tags           []string          `yaml:"tags,omitempty" json:"tags,omitempty" doc:"Network tags of the nodes besides the name of the pool, which the controlplane firewall rules target"`
+	ServiceAccount string            `yaml:"serviceAccount,omitempty" json:"serviceAccount,omitempty" doc:"Email of the service account the nodes run as, spec.cluster.serviceAccount by default"`
+	MaxSurge       int32             `yaml:"maxSurge" json:"maxSurge" doc:"Nodes added at a time while the pool is upgraded, spec.cluster.maxSurge by default" minimum:"0"`
+	MaxUnavailable int32             `yaml:"maxUnavailable" json:"maxUnavailable" doc:"Nodes taken away at a time while the pool is upgraded, spec.cluster.maxUnavailable by default" minimum:"0"`
+}
+
+// Taint is a Kubernetes taint of the nodes of a node pool
+type Taint struct {
+	Key    string `yaml:"key" json:"key" doc:"Key of the taint" required:"true"`
+	Value  string `yaml:"value,omitempty" json:"value,omitempty" doc:"Value of the taint"`
+	Effect string `yaml:"effect" json:"effect" doc:"Effect of the taint" enum:"NoSchedule,PreferNoSchedule,NoExecute" required:"true"`
+}
+
+// CidrBlock is a named address range
+type CidrBlock struct {
+	DisplayName string `yaml:"displayName" json:"displayName" doc:"Name shown for the range"`
+	CidrBlock   string `yaml:"cidrBlock" json:"cidrBlock" doc:"Address range" pattern:"cidr" required:"true"`
+}
+
+// State is where tidalwave records the resources it created
+type State struct {
+	Backend string `yaml:"backend,omitempty" json:"backend,omitempty" doc:"Where state is stored" enum:"local,gcs"`
+	Path    string `yaml:"path,omitempty" json:"path,omitempty" doc:"File of the local backend, $HOME/.tidalwave/state/<metadata.name>.json by default"`
+	Bucket  string `yaml:"bucket,omitempty" json:"bucket,omitempty" doc:"Bucket of the gcs backend"`
+	Object  string `yaml:"object,omitempty" json:"object,omitempty" doc:"Object of the gcs backend, tidalwave/<metadata.name>.json by default"`
+}
+
+// Bootstrap is what is installed into the cluster once it is created, Argo CD and the
+// kustomizations of Applications it syncs
+type Bootstrap struct {
+	Enabled         bool     `yaml:"enabled" json:"enabled" doc:"Installs Argo CD and the kustomizations when the controlplane is created, true by default"`
+	ArgoCD          ArgoCD   `yaml:"argocd" json:"argocd" doc:"Argo CD installed into the cluster"`
+	Manifests       string   `yaml:"manifests,omitempty" json:"manifests,omitempty" doc:"Directory the kustomizations are in, the bundled manifests rendered from spec.addons if not set"`
+	Kustomizations  []string `yaml:"kustomizations,omitempty" json:"kustomizations,omitempty" doc:"Kustomizations under manifests that are applied, argocd-apps/connect and argocd-apps/security by default"`
+	PrivateEndpoint bool     `yaml:"privateEndpoint,omitempty" json:"privateEndpoint,omitempty" doc:"Reaches the API server at its private endpoint, for running inside the VPC"`
+	Timeout         string   `yaml:"timeout,omitempty" json:"timeout,omitempty" doc:"How long the Applications may take to be Synced and Healthy" pattern:"^([0-9]+(\\.[0-9]+)?(ns|us|µs|ms|s|m|h))+$"`
+}
+
+// ArgoCD is the Argo CD installed into the cluster
+type ArgoCD struct {
+	Version  string `yaml:"version,omitempty" json:"version,omitempty" doc:"Version of Argo CD" pattern:"^v[0-9]+\\.[0-9]+\\.[0-9]+$"`
+	Manifest string `yaml:"manifest,omitempty" json:"manifest,omitempty" doc:"URL or file of the Argo CD install manifest, the upstream install.yaml of version by default"`
+}
+
+// Addons are the add-ons of the bundled manifests
+type Addons struct {
+	CertManager     Addon `yaml:"certManager" json:"certManager" doc:"cert-manager issues TLS certificates"`
+	ExternalDNS     Addon `yaml:"externalDNS" json:"externalDNS" doc:"external-dns publishes DNS records for services and ingresses"`
+	ExternalSecrets Addon `yaml:"externalSecrets" json:"externalSecrets" doc:"external-secrets syncs secrets from cloud secret managers"`
+	Falco           Addon `yaml:"falco" json:"falco" doc:"Falco detects threats at runtime"`
+	IngressNginx    Addon `yaml:"ingressNginx" json:"ingressNginx" doc:"ingress-nginx ingress controller"`
+	Istio           Addon `yaml:"istio" json:"istio" doc:"Istio service mesh, the parameters are those of istiod, the gateways are in istio-ingress"`
+	OPAGatekeeper   Addon `yaml:"opaGatekeeper" json:"opaGatekeeper" doc:"OPA Gatekeeper enforces policies on resources"`
+}
+
+// Addon is one add-on of the bundled manifests
+type Addon struct {
+	Enabled    bool              `yaml:"enabled" json:"enabled" doc:"Installs the add-on, true by default"`
+	Version    string            `yaml:"version,omitempty" json:"version,omitempty" doc:"Version of the Helm chart"`
+	Namespace  string            `yaml:"namespace,omitempty" json:"namespace,omitempty" doc:"Namespace the add-on is installed in" pattern:"^[a-z0-9]([-a-z0-9]{0,61}[a-z0-9])?$"`
+	Parameters map[string]string `yaml:"parameters,omitempty" json:"parameters,omitempty" doc:"Helm parameters of the chart, merged over the defaults"`
+}
+
+// All returns every add-on keyed by its field name, such as certManager
+func (a *Addons) All() map[string]*Addon {
+	return map[string]*Addon{
+		"certManager":     &a.CertManager,
+		"externalDNS":     &a.ExternalDNS,
+		"externalSecrets": &a.ExternalSecrets,
+		"falco":           &a.Falco,
+		"ingressNginx":    &a.IngressNginx,
+		"istio":           &a.Istio,
+		"opaGatekeeper":   &a.OPAGatekeeper,
+	}
+}
+
+// Google holds settings only used by the google provider
+type Google struct {
+	Endpoints Endpoints `yaml:"endpoints,omitempty" json:"endpoints,omitempty" doc:"Overrides of the GCP API endpoints, for running against an emulator"`
+}
+
+// Endpoints override the GCP API endpoints
+type Endpoints struct {
+	Compute         string `yaml:"compute,omitempty" json:"compute,omitempty" doc:"Compute Engine REST endpoint"`
+	Container       string `yaml:"container,omitempty" json:"container,omitempty" doc:"Kubernetes Engine gRPC endpoint"`
+	IAM             string `yaml:"iam,omitempty" json:"iam,omitempty" doc:"IAM gRPC endpoint"`
+	KMS             string `yaml:"kms,omitempty" json:"kms,omitempty" doc:"Cloud KMS gRPC endpoint"`
+	ResourceManager string `yaml:"resourcemanager,omitempty" json:"resourcemanager,omitempty" doc:"Resource Manager gRPC endpoint"`
+	ServiceUsage    string `yaml:"serviceusage,omitempty" json:"serviceusage,omitempty" doc:"Service Usage gRPC endpoint"`
+	Insecure        bool   `yaml:"insecure,omitempty" json:"insecure,omitempty" doc:"Turns off TLS and authentication"`
+}
+
+// AWS holds settings only used by the aws provider
+type AWS struct {
+	Zones    []string `yaml:"zones,omitempty" json:"zones,omitempty" doc:"Availability zones of the private subnets, the first available zones of the region if not set"`
+	Endpoint string   `yaml:"endpoint,omitempty" json:"endpoint,omitempty" doc:"Endpoint every AWS API call is sent to, such as LocalStack"`
+}
+
+// Timeout returns how long resources of kind may take
+func (s *Spec) Timeout(kind string) time.Duration {
+	d, _ := time.ParseDuration(s.Timeouts[kind])
+	return d
+}
+
+// Error is a problem with a single field of the config
+type Error struct {
+	// Line is where the field is set, 0 if it is not in the file
+	Line    int
+	Field   string
+	Message string
+}
+
+func (e Error) Error() string {
+	if e.Line == 0 {
+		return fmt.Sprintf("%s: %s", e.Field, e.Message)
+	}
+	return fmt.Sprintf("line %d: %s: %s", e.Line, e.Field, e.Message)
+}
+
+// Errors is every problem found in a config
+type Errors []Error
+
+func (e Errors) Error() string {
+	msgs := make([]string, 0, len(e))
+	for _, err := range e {
+		msgs = append(msgs, err.Error())
+	}
+	return strings.Join(msgs, "\n")
+}
+
+// Line returns the line field is set on, or the line of its closest parent that is set
+func (c *Config) Line(field string) int {
+	for field != "" {
+		if line, ok := c.lines[field]; ok {
+			return line
+		}
+		i := strings.LastIndexAny(field, ".[")
+		if i < 0 {
+			break
+		}
+		field = field[:i]
+	}
+	return 0
+}
+
+// MigratedFrom returns the apiVersion the file was written for and whether it was migrated to
+// APIVersion when it was loaded
+func (c *Config) MigratedFrom() (string, bool) {
+	return c.from, c.from != c.APIVersion
+}
+
+// fieldAt returns the most specific field set on line
+func (c *Config) fieldAt(line int) string {
+	field := "config"
+	for f, l := range c.lines {
+		if l == line && (field == "config" || len(f) > len(field)) {
+			field = f
+		}
+	}
+	return field
+}
+
+// errorf returns an Error for field
+func (c *Config) errorf(field, format string, a ...interface{}) Error {
+	return Error{Line: c.Line(field), Field: field, Message: fmt.Sprintf(format, a...)}
+}
+
+// Parse decodes a config file, merging the overlay of env if it is not empty. Unknown fields
+// and values of the wrong type are returned together as Errors.
+func Parse(data []byte, env string) (*Config, error) {
+	root, err := decode(data)
+	if err != nil {
+		return nil, err
+	}
+	return parse(root, env)
+}
+
+// decode returns the top level node of a YAML document, nil if it is empty
+func decode(data []byte) (*yaml.Node, error) {
+	doc := yaml.Node{}
+	if err := yaml.NewDecoder(bytes.NewReader(data)).Decode(&doc); err != nil && err != io.EOF {
+		return nil, err
+	}
+	if len(doc.Content) == 0 {
+		return nil, nil
+	}
+	return doc.Content[0], nil
+}
+
+// parse decodes the config in root, see Parse
+func parse(root *yaml.Node, env string) (*Config, error) {
+	c := &Config{lines: map[string]int{}}
+	if root == nil {
+		return c, nil
+	}
+	if root.Kind == yaml.MappingNode {
+		from, err := migrate(root)
+		if err != nil {
+			return nil, err
+		}
+		c.from = from
+	}
+	if env != "" {
+		if err := overlay(root, env); err != nil {
+			return nil, err
+		}
+	}
+	errs := c.walk(root, reflect.TypeOf(*c), "")
+	if err := root.Decode(c); err != nil {
+		typeErr, ok := err.(*yaml.TypeError)
+		if !ok {
+			return nil, err
+		}
+		for _, msg := range typeErr.Errors {
+			e := Error{Field: "config", Message: msg}
+			if _, err := fmt.Sscanf(msg, "line %d:", &e.Line); err == nil {
+				_, e.Message, _ = strings.Cut(msg, ": ")
+				e.Field = c.fieldAt(e.Line)
+			}
+			errs = append(errs, e)
+		}
+	}
+	if len(errs) > 0 {
+		return c, errs
+	}
+	return c, nil
+}
+
+// Read decodes a config file for env, applies defaults and validates it, problems with the
+// YAML and with the values are returned together as Errors ordered by line
+func Read(data []byte, env string) (*Config, error) {
+	root, err := decode(data)
+	if err != nil {
+		return nil, err
+	}
+	return read(root, env)
+}
+
+// read decodes the config in root, see Read
+func read(root *yaml.Node, env string) (*Config, error) {
+	c, err := parse(root, env)
+	errs := Errors{}
+	if err != nil && !errors.As(err, &errs) {
+		return nil, err
+	}
+	c.ApplyDefaults()
+	if err := c.Validate(); err != nil {
+		errs = append(errs, err.(Errors)...)
+	}
+	if len(errs) > 0 {
+		sort.SliceStable(errs, func(i, j int) bool { return errs[i].Line < errs[j].Line })
+		return c, errs
+	}
+	return c, nil
+}
+
+// Load reads the config file at path for env, see Read
+func Load(path, env string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return Read(data, env)
+}
+
+// walk records the line of every field under n and reports the fields t does not have
+func (c *Config) walk(n *yaml.Node, t reflect.Type, path string) Errors {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	errs := Errors{}
+	join := func(key string) string {
+		if path == "" {
+			return key
+		}
+		return path + "." + key
+	}
+	switch {
+	case t.Kind() == reflect.Struct && n.Kind == yaml.MappingNode:
+		fields := map[string]reflect.Type{}
+		names := []string{}
+		for i := 0; i < t.NumField(); i++ {
+			name, _, _ := strings.Cut(t.Field(i).Tag.Get("yaml"), ",")
+			if name != "" && name != "-" {
+				fields[name] = t.Field(i).Type
+				names = append(names, name)
+			}
+		}
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			key, value := n.Content[i], n.Content[i+1]
+			ft, ok := fields[key.Value]
+			if !ok {
+				errs = append(errs, Error{Line: key.Line, Field: join(key.Value), Message: fmt.Sprintf("unknown field, expected one of %s", strings.Join(names, ", "))})
+				continue
+			}
+			c.lines[join(key.Value)] = key.Line
+			errs = append(errs, c.walk(value, ft, join(key.Value))...)
+		}
+	case t.Kind() == reflect.Map && n.Kind == yaml.MappingNode:
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			key, value := n.Content[i], n.Content[i+1]
+			c.lines[join(key.Value)] = key.Line
+			if t.Elem().Kind() == reflect.Interface {
+				continue
+			}
+			errs = append(errs, c.walk(value, t.Elem(), join(key.Value))...)
+		}
+	case t.Kind() == reflect.Slice && n.Kind == yaml.SequenceNode:
+		for i, item := range n.Content {
+			c.lines[fmt.Sprintf("%s[%d]", path, i)] = item.Line
+			errs = append(errs, c.walk(item, t.Elem(), fmt.Sprintf("%s[%d]", path, i))...)
+		}
+	}
+	return errs
+}
@@ -0,0 +1,428 @@
+package config
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// load reads a config, failing if there is a problem other than Errors
+func load(t *testing.T, data string) (*Config, Errors) {
+	t.Helper()
+	c, err := Read([]byte(data), "")
+	if err == nil {
+		return c, nil
+	}
+	var errs Errors
+	if !errors.As(err, &errs) {
+		t.Fatal(err)
+	}
+	return c, errs
+}
+
+// lines returns the field and line of every error
+func lines(errs Errors) map[string]int {
+	got := map[string]int{}
+	for _, e := range errs {
+		got[e.Field] = e.Line
+	}
+	return got
+}
+
+func TestValidConfig(t *testing.T) {
+	c, errs := load(t, `apiVersion: tidalwave.io/v1alpha1
+kind: Controlplane
+metadata:
+  name: mycluster
+spec:
+  projectID: myproject
+  cluster:
+    minNodeCount: 0
+  timeouts:
+    cluster: 1h
+`)
+	if errs != nil {
+		t.Fatal(errs)
+	}
+	if c.Spec.Provider != "google" || c.Spec.Cidrs.Pods != "10.1.0.0/16" || c.Spec.Timeouts["vpc"] != "10m" {
+		t.Errorf("defaults not applied: %+v", c.Spec)
+	}
+	if c.Spec.Cluster.MinNodeCount != 0 || c.Spec.Cluster.MaxNodeCount != 3 {
+		t.Errorf("minNodeCount 0 was replaced by a default: %+v", c.Spec.Cluster)
+	}
+}
+
+func TestUnknownFields(t *testing.T) {
+	_, errs := load(t, `apiVersion: tidalwave.io/v1alpha1
+metadata:
+  name: mycluster
+spec:
+  projectID: myproject
+  cidrs:
+    nodes: 10.0.0.0/24
+    cluster:
+      machineType: n2-standard-8
+  paralelism: 8
+`)
+	want := map[string]int{
+		"spec.cidrs.cluster": 8,
+		"spec.paralelism":    10,
+	}
+	if got := lines(errs); !reflect.DeepEqual(got, want) {
+		t.Errorf("got errors %v, want %v", errs, want)
+	}
+}
+
+func TestWrongTypes(t *testing.T) {
+	_, errs := load(t, `metadata:
+  name: mycluster
+spec:
+  projectID: myproject
+  cluster:
+    maxNodeCount: lots
+`)
+	if got := lines(errs); got["spec.cluster.maxNodeCount"] != 6 {
+		t.Errorf("got errors %v", errs)
+	}
+}
+
+func TestSemanticErrors(t *testing.T) {
+	_, errs := load(t, `apiVersion: tidalwave.io/v2
+metadata:
+  name: My_Cluster
+spec:
+  region: central
+  cidrs:
+    nodes: 10.0.0.0/24
+    pods: 10.0.0.0/16
+    services: 10.2.0.1/20
+  cluster:
+    minNodeCount: 5
+    maxNodeCount: 2
+    masterCidrBlock: 172.16.0.0/24
+  timeouts:
+    cluster: soon
+    nodegroup: 10m
+`)
+	want := map[string]int{
+		"apiVersion":                   1,
+		"metadata.name":                3,
+		"spec.projectID":               4,
+		"spec.region":                  5,
+		"spec.cidrs.pods":              8,
+		"spec.cidrs.services":          9,
+		"spec.cluster.minNodeCount":    11,
+		"spec.cluster.masterCidrBlock": 13,
+		"spec.timeouts.cluster":        15,
+		"spec.timeouts.nodegroup":      16,
+	}
+	if got := lines(errs); !reflect.DeepEqual(got, want) {
+		t.Errorf("got errors\n%v\nwant fields and lines %v", errs, want)
+	}
+}
+
+func TestAWSNetwork(t *testing.T) {
+	_, errs := load(t, `metadata:
+  name: mycluster
+spec:
+  provider: aws
+  cidrs:
+    vpc: 10.0.0.0/16
+    public: 10.0.96.0/24
+    private:
+      - 10.0.0.0/19
+      - 10.0.16.0/20
+      - 10.1.0.0/19
+  cluster:
+    masterAuthBlock: []
+`)
+	want := map[string]int{
+		"spec.cidrs.private[1]": 10,
+		"spec.cidrs.private[2]": 11,
+	}
+	if got := lines(errs); !reflect.DeepEqual(got, want) {
+		t.Errorf("got errors %v, want %v", errs, want)
+	}
+
+	c, errs := load(t, `metadata:
+  name: mycluster
+spec:
+  provider: aws
+`)
+	if errs != nil {
+		t.Fatal(errs)
+	}
+	if len(c.Spec.Cluster.MasterAuthBlock) != 0 {
+		t.Errorf("the public endpoint should be off by default, got %v", c.Spec.Cluster.MasterAuthBlock)
+	}
+}
+
+func TestBootstrap(t *testing.T) {
+	c, errs := load(t, `metadata:
+  name: mycluster
+spec:
+  projectID: myproject
+`)
+	if errs != nil {
+		t.Fatal(errs)
+	}
+	if b := c.Spec.Bootstrap; !b.Enabled || b.ArgoCD.Manifest != "https://raw.githubusercontent.com/argoproj/argo-cd/v2.4.11/manifests/install.yaml" || len(b.Kustomizations) != 2 {
+		t.Errorf("defaults not applied: %+v", b)
+	}
+
+	c, errs = load(t, `metadata:
+  name: mycluster
+spec:
+  projectID: myproject
+  bootstrap:
+    enabled: false
+    argocd:
+      version: "2.4"
+    kustomizations:
+      - argocd-apps/connect
+      - ../secrets
+    timeout: never
+`)
+	if c.Spec.Bootstrap.Enabled {
+		t.Error("enabled: false was replaced by the default")
+	}
+	want := map[string]int{
+		"spec.bootstrap.argocd.version":    8,
+		"spec.bootstrap.kustomizations[1]": 11,
+		"spec.bootstrap.timeout":           12,
+	}
+	if got := lines(errs); !reflect.DeepEqual(got, want) {
+		t.Errorf("got errors\n%v\nwant fields and lines %v", errs, want)
+	}
+}
+
+func TestNodePools(t *testing.T) {
+	c, errs := load(t, `metadata:
+  name: mycluster
+spec:
+  projectID: myproject
+  cluster:
+    diskSize: 200
+    nodePools:
+      - name: ci
+        minNodeCount: 0
+        spot: true
+        taints:
+          - key: dedicated
+            value: ci
+            effect: NoSchedule
+`)
+	if errs != nil {
+		t.Fatal(errs)
+	}
+	pool := c.Spec.Cluster.NodePools[0]
+	if pool.MachineType != "n2-standard-4" || pool.DiskType != "pd-ssd" || pool.DiskSize != 200 || pool.MinNodeCount != 0 || pool.MaxNodeCount != 3 {
+		t.Errorf("defaults not applied: %+v", pool)
+	}
+
+	_, errs = load(t, `metadata:
+  name: mycluster
+spec:
+  projectID: myproject
+  cluster:
+    nodePools:
+      - name: default-pool
+      - name: ci
+        spot: true
+        preemptible: true
+        taints:
+          - key: dedicated
+            effect: Never
+      - name: ci
+`)
+	want := map[string]int{
+		"spec.cluster.nodePools[0].name":             7,
+		"spec.cluster.nodePools[1].preemptible":      10,
+		"spec.cluster.nodePools[1].taints[0].effect": 13,
+		"spec.cluster.nodePools[2].name":             14,
+	}
+	if got := lines(errs); !reflect.DeepEqual(got, want) {
+		t.Errorf("got errors\n%v\nwant fields and lines %v", errs, want)
+	}
+}
+
+func TestServiceAccount(t *testing.T) {
+	_, errs := load(t, `metadata:
+  name: mycluster
+spec:
+  projectID: myproject
+  cluster:
+    serviceAccount: nodes
+    nodePools:
+      - name: ci
+        serviceAccount: ci@myproject.iam.gserviceaccount.com
+      - name: batch
+        serviceAccount: default
+`)
+	want := map[string]int{
+		"spec.cluster.serviceAccount": 6,
+	}
+	if got := lines(errs); !reflect.DeepEqual(got, want) {
+		t.Errorf("got errors\n%v\nwant fields and lines %v", errs, want)
+	}
+}
+
+func TestAutopilot(t *testing.T) {
+	c, errs := load(t, `metadata:
+  name: mycluster
+spec:
+  projectID: myproject
+  cluster:
+    mode: autopilot
+`)
+	if errs != nil {
+		t.Fatal(errs)
+	}
+	if c.Spec.Cluster.Datapath != "advanced" {
+		t.Errorf("datapath = %q, want advanced", c.Spec.Cluster.Datapath)
+	}
+
+	_, errs = load(t, `metadata:
+  name: mycluster
+spec:
+  projectID: myproject
+  cluster:
+    mode: autopilot
+    machineType: e2-standard-4
+    datapath: legacy
+    nodePools:
+      - name: ci
+    version: "1.24"
+`)
+	want := map[string]int{
+		"spec.cluster.machineType": 7,
+		"spec.cluster.datapath":    8,
+		"spec.cluster.nodePools":   9,
+		"spec.cluster.version":     11,
+	}
+	if got := lines(errs); !reflect.DeepEqual(got, want) {
+		t.Errorf("got errors\n%v\nwant fields and lines %v", errs, want)
+	}
+}
+
+func TestGKEFeatures(t *testing.T) {
+	c, errs := load(t, `metadata:
+  name: mycluster
+spec:
+  projectID: myproject
+  cluster:
+    addons:
+      configConnector: false
+`)
+	if errs != nil {
+		t.Fatal(errs)
+	}
+	cl := c.Spec.Cluster
+	if !cl.BinaryAuthorization || !cl.Addons.HTTPLoadBalancing || cl.Addons.ConfigConnector || !cl.Addons.GcpFilestoreCsiDriver {
+		t.Errorf("defaults not applied: %+v %+v", cl.BinaryAuthorization, cl.Addons)
+	}
+	if cl.Maintenance.DailyStartTime != "06:00" || strings.Join(cl.Logging, ",") != "system,workloads" || strings.Join(cl.Monitoring, ",") != "system" || cl.WorkloadMetadata != "gke-metadata" ||
+		cl.GatewayAPI != "disabled" || cl.SecurityPosture != "basic" || cl.CostAllocation || cl.VulnerabilityScanning {
+		t.Errorf("defaults not applied: %+v", cl)
+	}
+
+	_, errs = load(t, `metadata:
+  name: mycluster
+spec:
+  projectID: myproject
+  cluster:
+    logging: [workloads, audit]
+    monitoring: [apiserver]
+    maintenance:
+      dailyStartTime: "03:00"
+      start: 2023-01-07T06:00:00Z
+      end: 2023-01-07T02:00:00Z
+      recurrence: FREQ=WEEKLY;BYDAY=SA,SU
+      exclusions:
+        - name: freeze
+          start: December
+          end: 2024-01-02T00:00:00Z
+          scope: no-minor-upgrades
+        - name: freeze
+          start: 2023-11-20T00:00:00Z
+          end: 2023-11-27T00:00:00Z
+    gatewayAPI: standard
+    securityPosture: disabled
+    vulnerabilityScanning: true
+    addons:
+      httpLoadBalancing: false
+`)
+	want := map[string]int{
+		"spec.cluster.logging":                         6,
+		"spec.cluster.logging[1]":                      6,
+		"spec.cluster.monitoring":                      7,
+		"spec.cluster.maintenance.dailyStartTime":      9,
+		"spec.cluster.maintenance.end":                 11,
+		"spec.cluster.maintenance.exclusions[0].start": 15,
+		"spec.cluster.maintenance.exclusions[1].name":  18,
+		"spec.cluster.gatewayAPI":                      21,
+		"spec.cluster.vulnerabilityScanning":           23,
+	}
+	if got := lines(errs); !reflect.DeepEqual(got, want) {
+		t.Errorf("got errors\n%v\nwant fields and lines %v", errs, want)
+	}
+}
+
+func TestUpgradeSettings(t *testing.T) {
+	c, errs := load(t, `metadata:
+  name: mycluster
+spec:
+  projectID: myproject
+  cluster:
+    version: "1.24"
+    maxSurge: 3
+    nodePools:
+      - name: ci
+        maxUnavailable: 0
+`)
+	if errs != nil {
+		t.Fatal(errs)
+	}
+	cl := c.Spec.Cluster
+	if cl.MaxSurge != 3 || cl.MaxUnavailable != 1 || cl.NodePools[0].MaxSurge != 3 || cl.NodePools[0].MaxUnavailable != 0 {
+		t.Errorf("surge defaults not applied: %+v", cl)
+	}
+	if cl.ReleaseChannel != "unspecified" {
+		t.Errorf("release channel of a pinned cluster is %s, want unspecified", cl.ReleaseChannel)
+	}
+
+	_, errs = load(t, `metadata:
+  name: mycluster
+spec:
+  projectID: myproject
+  cluster:
+    version: v1.24.5
+    maxUnavailable: -1
+    nodePools:
+      - name: ci
+        maxSurge: 0
+        maxUnavailable: 0
+    releaseChannel: stable
+`)
+	want := map[string]int{
+		"spec.cluster.version":               6,
+		"spec.cluster.maxUnavailable":        7,
+		"spec.cluster.nodePools[0].maxSurge": 10,
+		"spec.cluster.releaseChannel":        12,
+	}
+	if got := lines(errs); !reflect.DeepEqual(got, want) {
+		t.Errorf("got errors\n%v\nwant fields and lines %v", errs, want)
+	}
+
+	_, errs = load(t, `metadata:
+  name: mycluster
+spec:
+  projectID: myproject
+  cluster:
+    releaseChannel: unspecified
+`)
+	if got := lines(errs); !reflect.DeepEqual(got, map[string]int{"spec.cluster.releaseChannel": 6}) {
+		t.Errorf("got errors\n%v\nwant an unspecified channel without a version to be rejected", errs)
+	}
+}
@@ -0,0 +1,233 @@
+package config
+
+import "fmt"
+
+// publicAccess allows everyone to reach the controlplane endpoint
+var publicAccess = []CidrBlock{
+	{
+		DisplayName: "public",
+		CidrBlock:   "0.0.0.0/0",
+	},
+}
+
+// googleTimeouts are how long each kind of google resource may take by default
+var googleTimeouts = map[string]string{
+	"apis":           "10m",
+	"vpc":            "10m",
+	"subnetwork":     "10m",
+	"router":         "10m",
+	"firewall":       "10m",
+	"keyring":        "10m",
+	"cryptokey":      "10m",
+	"serviceaccount": "10m",
+	"cluster":        "45m",
+}
+
+// argoCDVersion is the version of Argo CD bootstrapped by default
+const argoCDVersion = "v2.4.11"
+
+// addonDefaults are the chart versions, namespaces and Helm parameters of the bundled add-ons
+var addonDefaults = map[string]Addon{
+	"certManager":     {Version: "v1.9.1", Namespace: "cert-manager", Parameters: map[string]string{"installCRDs": "true"}},
+	"externalDNS":     {Version: "1.11.0", Namespace: "external-dns"},
+	"externalSecrets": {Version: "0.5.9", Namespace: "external-secrets"},
+	"falco":           {Version: "2.0.17", Namespace: "falco", Parameters: map[string]string{"driver.enabled": "true", "driver.kind": "ebpf"}},
+	"ingressNginx":    {Version: "4.2.5", Namespace: "ingress-nginx"},
+	"istio":           {Version: "1.15.0", Namespace: "istio-system"},
+	"opaGatekeeper":   {Version: "3.9.0", Namespace: "gatekeeper-system"},
+}
+
+// awsTimeouts are how long each kind of aws resource may take by default
+var awsTimeouts = map[string]string{
+	"vpc":           "10m",
+	"gateway":       "10m",
+	"nat":           "15m",
+	"subnets":       "10m",
+	"securitygroup": "10m",
+	"key":           "10m",
+	"roles":         "10m",
+	"cluster":       "45m",
+	"nodegroup":     "30m",
+}
+
+// Timeouts returns the kinds of resource provider has timeouts for with their defaults, nil
+// if the provider is not built in
+func Timeouts(provider string) map[string]string {
+	switch provider {
+	case "google":
+		return googleTimeouts
+	case "aws":
+		return awsTimeouts
+	}
+	return nil
+}
+
+// setDefault sets s to value if it is empty
+func setDefault(s *string, value string) {
+	if *s == "" {
+		*s = value
+	}
+}
+
+// isSet reports whether field is in the config file
+func (c *Config) isSet(field string) bool {
+	_, ok := c.lines[field]
+	return ok
+}
+
+// applyGKEDefaults turns on binary authorization and the GKE add-ons unless they are turned off
+// in the config, and fills in the maintenance window, logging, monitoring, metadata server, Gateway
+// API channel and security posture
+func (c *Config) applyGKEDefaults() {
+	cl := &c.Spec.Cluster
+	for field, enabled := range map[string]*bool{
+		"binaryAuthorization":               &cl.BinaryAuthorization,
+		"addons.httpLoadBalancing":          &cl.Addons.HTTPLoadBalancing,
+		"addons.horizontalPodAutoscaling":   &cl.Addons.HorizontalPodAutoscaling,
+		"addons.configConnector":            &cl.Addons.ConfigConnector,
+		"addons.gcePersistentDiskCsiDriver": &cl.Addons.GcePersistentDiskCsiDriver,
+		"addons.gcpFilestoreCsiDriver":      &cl.Addons.GcpFilestoreCsiDriver,
+	} {
+		if !c.isSet("spec.cluster." + field) {
+			*enabled = true
+		}
+	}
+	if cl.Mode == "autopilot" {
+		// Autopilot has no Config Connector add-on and always runs the CSI drivers
+		cl.Addons.ConfigConnector = false
+		cl.Addons.GcePersistentDiskCsiDriver = true
+		cl.Addons.GcpFilestoreCsiDriver = true
+	}
+	m := &cl.Maintenance
+	if m.Start == "" && m.End == "" && m.Recurrence == "" {
+		setDefault(&m.DailyStartTime, "06:00")
+	}
+	for i := range m.Exclusions {
+		setDefault(&m.Exclusions[i].Scope, "no-upgrades")
+	}
+	if cl.Logging == nil {
+		cl.Logging = []string{"system", "workloads"}
+	}
+	if cl.Monitoring == nil {
+		cl.Monitoring = []string{"system"}
+	}
+	setDefault(&cl.WorkloadMetadata, "gke-metadata")
+	setDefault(&cl.GatewayAPI, "disabled")
+	setDefault(&cl.SecurityPosture, "basic")
+	if !c.isSet("spec.cluster.maxSurge") {
+		cl.MaxSurge = 1
+	}
+	if !c.isSet("spec.cluster.maxUnavailable") {
+		cl.MaxUnavailable = 1
+	}
+	for i := range cl.NodePools {
+		pool := &cl.NodePools[i]
+		field := fmt.Sprintf("spec.cluster.nodePools[%d]", i)
+		if !c.isSet(field + ".maxSurge") {
+			pool.MaxSurge = cl.MaxSurge
+		}
+		if !c.isSet(field + ".maxUnavailable") {
+			pool.MaxUnavailable = cl.MaxUnavailable
+		}
+	}
+}
+
+// ApplyDefaults fills in every field that is not set with the default of the provider
+func (c *Config) ApplyDefaults() {
+	if c.APIVersion == "" {
+		c.APIVersion = APIVersion
+	}
+	if c.Kind == "" {
+		c.Kind = Kind
+	}
+	s := &c.Spec
+	setDefault(&s.Provider, "google")
+	if !c.isSet("spec.cluster.minNodeCount") {
+		s.Cluster.MinNodeCount = 1
+	}
+	if !c.isSet("spec.cluster.maxNodeCount") {
+		s.Cluster.MaxNodeCount = 3
+	}
+	switch s.Provider {
+	case "google":
+		setDefault(&s.Region, "us-central1")
+		setDefault(&s.Cidrs.Nodes, "10.0.0.0/24")
+		setDefault(&s.Cidrs.Pods, "10.1.0.0/16")
+		setDefault(&s.Cidrs.Services, "10.2.0.0/20")
+		setDefault(&s.Cluster.MachineType, "n2-standard-4")
+		setDefault(&s.Cluster.MasterCidrBlock, "172.16.0.0/28")
+		if s.Cluster.MasterAuthBlock == nil {
+			s.Cluster.MasterAuthBlock = append([]CidrBlock{}, publicAccess...)
+		}
+		if s.Cluster.Version != "" && s.Cluster.Mode != "autopilot" {
+			// GKE upgrades clusters on a release channel past a pinned version
+			setDefault(&s.Cluster.ReleaseChannel, "unspecified")
+		}
+		setDefault(&s.Cluster.ReleaseChannel, "rapid")
+		setDefault(&s.Cluster.Mode, "standard")
+		if s.Cluster.Mode == "autopilot" {
+			// Autopilot clusters always use Dataplane V2
+			setDefault(&s.Cluster.Datapath, "advanced")
+		}
+		setDefault(&s.Cluster.Datapath, "legacy")
+		c.applyGKEDefaults()
+	case "aws":
+		setDefault(&s.Region, "us-east-1")
+		setDefault(&s.Cidrs.Vpc, "10.0.0.0/16")
+		setDefault(&s.Cidrs.Public, "10.0.96.0/24")
+		if s.Cidrs.Private == nil {
+			s.Cidrs.Private = []string{"10.0.0.0/19", "10.0.32.0/19", "10.0.64.0/19"}
+		}
+		setDefault(&s.Cluster.MachineType, "m5.large")
+	}
+	for i := range s.Cluster.NodePools {
+		pool := &s.Cluster.NodePools[i]
+		field := fmt.Sprintf("spec.cluster.nodePools[%d]", i)
+		setDefault(&pool.MachineType, s.Cluster.MachineType)
+		setDefault(&pool.DiskType, "pd-ssd")
+		if pool.DiskSize == 0 {
+			pool.DiskSize = s.Cluster.DiskSize
+		}
+		if !c.isSet(field + ".minNodeCount") {
+			pool.MinNodeCount = 1
+		}
+		if !c.isSet(field + ".maxNodeCount") {
+			pool.MaxNodeCount = 3
+		}
+	}
+	if !c.isSet("spec.bootstrap.enabled") {
+		s.Bootstrap.Enabled = true
+	}
+	setDefault(&s.Bootstrap.ArgoCD.Version, argoCDVersion)
+	setDefault(&s.Bootstrap.ArgoCD.Manifest, fmt.Sprintf("https://raw.githubusercontent.com/argoproj/argo-cd/%s/manifests/install.yaml", s.Bootstrap.ArgoCD.Version))
+	if s.Bootstrap.Kustomizations == nil {
+		s.Bootstrap.Kustomizations = []string{"argocd-apps/connect", "argocd-apps/security"}
+	}
+	setDefault(&s.Bootstrap.Timeout, "30m")
+	for name, addon := range s.Addons.All() {
+		d := addonDefaults[name]
+		if !c.isSet("spec.addons." + name + ".enabled") {
+			addon.Enabled = true
+		}
+		setDefault(&addon.Version, d.Version)
+		setDefault(&addon.Namespace, d.Namespace)
+		for param, value := range d.Parameters {
+			if _, ok := addon.Parameters[param]; !ok {
+				if addon.Parameters == nil {
+					addon.Parameters = map[string]string{}
+				}
+				addon.Parameters[param] = value
+			}
+		}
+	}
+	if timeouts := Timeouts(s.Provider); timeouts != nil {
+		if s.Timeouts == nil {
+			s.Timeouts = map[string]string{}
+		}
+		for kind, d := range timeouts {
+			if s.Timeouts[kind] == "" {
+				s.Timeouts[kind] = d
+			}
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package config
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// overlay merges the metadata and spec of the environment env over root
+func overlay(root *yaml.Node, env string) error {
+	envs := value(root, "environments")
+	o := value(envs, env)
+	if o == nil {
+		names := []string{}
+		if envs != nil && envs.Kind == yaml.MappingNode {
+			for i := 0; i+1 < len(envs.Content); i += 2 {
+				names = append(names, envs.Content[i].Value)
+			}
+		}
+		if len(names) == 0 {
+			return fmt.Errorf("unknown environment %q, the config has no environments", env)
+		}
+		sort.Strings(names)
+		return fmt.Errorf("unknown environment %q, expected one of %s", env, strings.Join(names, ", "))
+	}
+	if o.Kind != yaml.MappingNode {
+		return nil
+	}
+	for _, key := range []string{"metadata", "spec"} {
+		i := index(o, key)
+		if i < 0 {
+			continue
+		}
+		merge(root, &yaml.Node{Kind: yaml.MappingNode, Content: o.Content[i : i+2]})
+	}
+	return nil
+}
+
+// merge sets every field of the mapping src on the mapping dst, fields that are mappings on
+// both sides are merged and any other value replaces the one in dst. The nodes of src are
+// kept so errors point at the overlay.
+func merge(dst, src *yaml.Node) {
+	for i := 0; i+1 < len(src.Content); i += 2 {
+		key, v := src.Content[i], src.Content[i+1]
+		j := index(dst, key.Value)
+		switch {
+		case j < 0:
+			dst.Content = append(dst.Content, key, v)
+		case dst.Content[j+1].Kind == yaml.MappingNode && v.Kind == yaml.MappingNode:
+			merge(dst.Content[j+1], v)
+		default:
+			dst.Content[j], dst.Content[j+1] = key, v
+		}
+	}
+}
+
+// Render returns the effective config as YAML, with defaults applied and without the
+// environments
+func (c *Config) Render() ([]byte, error) {
+	effective := *c
+	effective.Environments = nil
+	out := &bytes.Buffer{}
+	enc := yaml.NewEncoder(out)
+	enc.SetIndent(2)
+	if err := enc.Encode(&effective); err != nil {
+		return nil, err
+	}
+	if err := enc.Close(); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
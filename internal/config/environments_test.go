@@ -0,0 +1,83 @@
+package config
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+const environments = `apiVersion: tidalwave.io/v1alpha1
+metadata:
+  name: dev
+spec:
+  projectID: myproject-dev
+  cluster:
+    machineType: n2-standard-2
+    maxNodeCount: 3
+    masterAuthBlock:
+      - displayName: office
+        cidrBlock: 203.0.113.0/24
+environments:
+  prod:
+    metadata:
+      name: prod
+    spec:
+      projectID: myproject-prod
+      cluster:
+        maxNodeCount: 12
+        masterAuthBlock: []
+  staging:
+    spec:
+      cidrs:
+        pods: 10.0.0.0/16
+`
+
+func TestEnvironments(t *testing.T) {
+	c, err := Read([]byte(environments), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Metadata.Name != "dev" || c.Spec.Cluster.MaxNodeCount != 3 {
+		t.Errorf("the base config should be used without an environment, got %+v", c)
+	}
+
+	c, err = Read([]byte(environments), "prod")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cl := c.Spec.Cluster
+	if c.Metadata.Name != "prod" || c.Spec.ProjectID != "myproject-prod" || cl.MaxNodeCount != 12 {
+		t.Errorf("prod overlay not merged: %+v", c)
+	}
+	if cl.MachineType != "n2-standard-2" || len(cl.MasterAuthBlock) != 0 {
+		t.Errorf("got cluster %+v, want the base machine type and the overlay's empty list", cl)
+	}
+
+	_, err = Read([]byte(environments), "staging")
+	var errs Errors
+	if !errors.As(err, &errs) || len(errs) != 1 || errs[0].Field != "spec.cidrs.pods" || errs[0].Line != 24 {
+		t.Errorf("got %v, want spec.cidrs.pods on the line of the overlay", err)
+	}
+
+	if _, err := Read([]byte(environments), "qa"); err == nil || !strings.Contains(err.Error(), "expected one of prod, staging") {
+		t.Errorf("got %v, want an unknown environment error", err)
+	}
+}
+
+func TestRender(t *testing.T) {
+	c, err := Read([]byte(environments), "prod")
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := c.Render()
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err = Read(out, "")
+	if err != nil {
+		t.Fatalf("rendered config is not valid: %v\n%s", err, out)
+	}
+	if strings.Contains(string(out), "environments") || c.Metadata.Name != "prod" || c.Spec.Timeouts["cluster"] != "45m" {
+		t.Errorf("got\n%s", out)
+	}
+}
@@ -0,0 +1,154 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// listFields are the fields of a ControlplaneList
+var listFields = []string{"apiVersion", "kind", "template", "items"}
+
+// ReadList decodes a ControlplaneList for env. template is merged under every item the way an
+// environment is merged over the base config, then each item is read like a config file of its
+// own. Problems are returned together as Errors, fields of an item start with items[i].
+func ReadList(data []byte, env string) ([]*Config, error) {
+	root, err := decode(data)
+	if err != nil {
+		return nil, err
+	}
+	if root == nil || root.Kind != yaml.MappingNode {
+		return nil, fmt.Errorf("config is not a YAML mapping")
+	}
+	errs := Errors{}
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		if key := root.Content[i]; !contains(listFields, key.Value) {
+			errs = append(errs, Error{Line: key.Line, Field: key.Value, Message: fmt.Sprintf("unknown field, expected one of %s", strings.Join(listFields, ", "))})
+		}
+	}
+	if kind := value(root, "kind"); kind == nil || kind.Value != ListKind {
+		line := 0
+		if kind != nil {
+			line = kind.Line
+		}
+		errs = append(errs, Error{Line: line, Field: "kind", Message: fmt.Sprintf("expected %s", ListKind)})
+	}
+	items := value(root, "items")
+	if items == nil || items.Kind != yaml.SequenceNode || len(items.Content) == 0 {
+		line := 0
+		if items != nil {
+			line = items.Line
+		}
+		return nil, append(errs, Error{Line: line, Field: "items", Message: "needs at least one controlplane"})
+	}
+	template := value(root, "template")
+	cfgs := []*Config{}
+	for i, item := range items.Content {
+		doc := &yaml.Node{Kind: yaml.MappingNode, Line: item.Line}
+		if template != nil && template.Kind == yaml.MappingNode {
+			merge(doc, clone(template))
+		}
+		if item.Kind == yaml.MappingNode {
+			merge(doc, item)
+		}
+		if v := value(root, "apiVersion"); v != nil && index(doc, "apiVersion") < 0 {
+			insert(doc, 0, "apiVersion", clone(v))
+		}
+		c, err := read(doc, env)
+		if err != nil {
+			var itemErrs Errors
+			if !errors.As(err, &itemErrs) {
+				return nil, fmt.Errorf("items[%d]: %w", i, err)
+			}
+			for _, e := range itemErrs {
+				e.Field = fmt.Sprintf("items[%d].%s", i, e.Field)
+				errs = append(errs, e)
+			}
+		}
+		cfgs = append(cfgs, c)
+	}
+	if len(errs) > 0 {
+		sort.SliceStable(errs, func(i, j int) bool { return errs[i].Line < errs[j].Line })
+		return cfgs, errs
+	}
+	return cfgs, nil
+}
+
+// LoadFleet reads every controlplane at path for env, path is a ControlplaneList, a config file
+// or a directory of either ending in .yaml or .yml. Two controlplanes cannot have the same name
+// as they would share resources and state.
+func LoadFleet(path, env string) ([]*Config, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	files := []string{path}
+	if info.IsDir() {
+		entries, err := os.ReadDir(path)
+		if err != nil {
+			return nil, err
+		}
+		files = nil
+		for _, e := range entries {
+			if ext := filepath.Ext(e.Name()); !e.IsDir() && (ext == ".yaml" || ext == ".yml") {
+				files = append(files, filepath.Join(path, e.Name()))
+			}
+		}
+		if len(files) == 0 {
+			return nil, fmt.Errorf("%s has no config files", path)
+		}
+	}
+	cfgs := []*Config{}
+	sources := map[string]string{}
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		list, err := readFleetFile(data, env)
+		if err != nil {
+			return nil, fmt.Errorf("%s:\n%w", file, err)
+		}
+		for _, c := range list {
+			if other, ok := sources[c.Metadata.Name]; ok && other == file {
+				return nil, fmt.Errorf("%s has two controlplanes named %q", file, c.Metadata.Name)
+			} else if ok {
+				return nil, fmt.Errorf("%s and %s both have a controlplane named %q", other, file, c.Metadata.Name)
+			}
+			sources[c.Metadata.Name] = file
+		}
+		cfgs = append(cfgs, list...)
+	}
+	return cfgs, nil
+}
+
+// readFleetFile reads the controlplanes of a ControlplaneList or a config file
+func readFleetFile(data []byte, env string) ([]*Config, error) {
+	root, err := decode(data)
+	if err != nil {
+		return nil, err
+	}
+	if kind := value(root, "kind"); kind != nil && kind.Value == ListKind {
+		return ReadList(data, env)
+	}
+	c, err := read(root, env)
+	if err != nil {
+		return nil, err
+	}
+	return []*Config{c}, nil
+}
+
+// clone returns a deep copy of n, merging into the copy leaves n as it is
+func clone(n *yaml.Node) *yaml.Node {
+	c := *n
+	c.Content = make([]*yaml.Node, len(n.Content))
+	for i, child := range n.Content {
+		c.Content[i] = clone(child)
+	}
+	return &c
+}
@@ -0,0 +1,93 @@
+package config
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const fleet = `apiVersion: tidalwave.io/v1alpha1
+kind: ControlplaneList
+template:
+  spec:
+    projectID: myproject
+    cluster:
+      machineType: n2-standard-8
+items:
+  - metadata:
+      name: us-east
+    spec:
+      region: us-east1
+  - metadata:
+      name: europe-west
+    spec:
+      region: europe-west1
+      cluster:
+        maxNodeCount: 6
+`
+
+func TestReadList(t *testing.T) {
+	cfgs, err := ReadList([]byte(fleet), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cfgs) != 2 {
+		t.Fatalf("got %d controlplanes, want 2", len(cfgs))
+	}
+	east, west := cfgs[0], cfgs[1]
+	if east.Metadata.Name != "us-east" || east.Spec.Region != "us-east1" || east.Spec.Cluster.MaxNodeCount != 3 {
+		t.Errorf("got %+v", east)
+	}
+	if west.Spec.ProjectID != "myproject" || west.Spec.Cluster.MachineType != "n2-standard-8" || west.Spec.Cluster.MaxNodeCount != 6 {
+		t.Errorf("template not merged under the item: %+v", west.Spec)
+	}
+	if _, migrated := west.MigratedFrom(); migrated {
+		t.Error("items should have the apiVersion of the list")
+	}
+
+	_, err = ReadList([]byte(strings.Replace(fleet, "region: europe-west1", "region: europe", 1)), "")
+	var errs Errors
+	if !errors.As(err, &errs) {
+		t.Fatal(err)
+	}
+	want := map[string]int{"items[1].spec.region": 16}
+	if got := lines(errs); !reflect.DeepEqual(got, want) {
+		t.Errorf("got errors %v, want %v", errs, want)
+	}
+}
+
+func TestLoadFleet(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"fleet.yaml": fleet,
+		"dev.yml":    "apiVersion: tidalwave.io/v1alpha1\nmetadata:\n  name: dev\nspec:\n  projectID: myproject\n",
+		"README.md":  "not a config",
+	}
+	for name, data := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(data), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cfgs, err := LoadFleet(dir, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := []string{}
+	for _, c := range cfgs {
+		names = append(names, c.Metadata.Name)
+	}
+	if want := []string{"dev", "us-east", "europe-west"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("got %v, want %v", names, want)
+	}
+
+	dup := strings.Replace(files["dev.yml"], "name: dev", "name: us-east", 1)
+	if err := os.WriteFile(filepath.Join(dir, "dev.yml"), []byte(dup), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadFleet(dir, ""); err == nil || !strings.Contains(err.Error(), `named "us-east"`) {
+		t.Errorf("got %v, want an error about the duplicate name", err)
+	}
+}
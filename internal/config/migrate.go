@@ -0,0 +1,161 @@
+package config
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+
+	"gopkg.in/yaml.v3"
+)
+
+// migration upgrades a config from one apiVersion to the next, it edits the YAML nodes so
+// comments and the order of fields survive. It is applied to the template and every item of a
+// ControlplaneList.
+type migration struct {
+	from, to string
+	apply    func(root *yaml.Node) error
+}
+
+// migrations are applied in order starting from the apiVersion of the document, the first
+// upgrades files written before the config had an apiVersion
+var migrations = []migration{
+	{from: "", to: "tidalwave.io/v1alpha1", apply: unversioned},
+}
+
+// unversioned moves spec.cidrs.cluster, where the first example config put it and where it
+// was never read, to spec.cluster
+func unversioned(root *yaml.Node) error {
+	spec := value(root, "spec")
+	cidrs := value(spec, "cidrs")
+	if cidrs == nil || value(spec, "cluster") != nil {
+		return nil
+	}
+	if key, cluster := remove(cidrs, "cluster"); cluster != nil {
+		i := index(spec, "cidrs")
+		spec.Content = append(spec.Content[:i+2], append([]*yaml.Node{key, cluster}, spec.Content[i+2:]...)...)
+	}
+	return nil
+}
+
+// migrate upgrades root to APIVersion in place and returns the apiVersion it was at
+func migrate(root *yaml.Node) (string, error) {
+	from := ""
+	if v := value(root, "apiVersion"); v != nil {
+		from = v.Value
+	}
+	docs := []*yaml.Node{root}
+	if kind := value(root, "kind"); kind != nil && kind.Value == ListKind {
+		docs = []*yaml.Node{}
+		if template := value(root, "template"); template != nil {
+			docs = append(docs, template)
+		}
+		if items := value(root, "items"); items != nil {
+			docs = append(docs, items.Content...)
+		}
+	}
+	version := from
+	for _, m := range migrations {
+		if m.from != version {
+			continue
+		}
+		for _, doc := range docs {
+			if doc.Kind != yaml.MappingNode {
+				continue
+			}
+			if err := m.apply(doc); err != nil {
+				return from, fmt.Errorf("migrate %s to %s: %w", m.from, m.to, err)
+			}
+		}
+		if v := value(root, "apiVersion"); v != nil {
+			v.Value, v.Tag, v.Style = m.to, "!!str", 0
+		} else {
+			insert(root, 0, "apiVersion", scalar(m.to))
+		}
+		version = m.to
+	}
+	if from == "" && value(root, "kind") == nil {
+		insert(root, 1, "kind", scalar(Kind))
+	}
+	return from, nil
+}
+
+// Migrate rewrites a config file written for an older apiVersion to APIVersion, keeping its
+// comments. It returns the apiVersion the file was at, data is returned as is if it is
+// already at APIVersion.
+func Migrate(data []byte) ([]byte, string, error) {
+	doc := yaml.Node{}
+	if err := yaml.NewDecoder(bytes.NewReader(data)).Decode(&doc); err != nil && err != io.EOF {
+		return nil, "", err
+	}
+	if len(doc.Content) == 0 || doc.Content[0].Kind != yaml.MappingNode {
+		return nil, "", fmt.Errorf("config is not a YAML mapping")
+	}
+	root := doc.Content[0]
+	from, err := migrate(root)
+	if err != nil {
+		return nil, from, err
+	}
+	if v := value(root, "apiVersion"); v.Value != APIVersion {
+		return nil, from, fmt.Errorf("unsupported version %q, expected %s", v.Value, APIVersion)
+	}
+	if from == APIVersion {
+		return data, from, nil
+	}
+	out := &bytes.Buffer{}
+	enc := yaml.NewEncoder(out)
+	enc.SetIndent(2)
+	if err := enc.Encode(&doc); err != nil {
+		return nil, from, err
+	}
+	if err := enc.Close(); err != nil {
+		return nil, from, err
+	}
+	return out.Bytes(), from, nil
+}
+
+// scalar returns a string node
+func scalar(s string) *yaml.Node {
+	return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: s}
+}
+
+// index returns the position of key in the mapping n, -1 if it is not there
+func index(n *yaml.Node, key string) int {
+	if n == nil || n.Kind != yaml.MappingNode {
+		return -1
+	}
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		if n.Content[i].Value == key {
+			return i
+		}
+	}
+	return -1
+}
+
+// value returns the value of key in the mapping n, nil if it is not there
+func value(n *yaml.Node, key string) *yaml.Node {
+	if i := index(n, key); i >= 0 {
+		return n.Content[i+1]
+	}
+	return nil
+}
+
+// insert adds key to the mapping n as its i-th field, a comment at the top of the mapping
+// stays at the top
+func insert(n *yaml.Node, i int, key string, v *yaml.Node) {
+	k := scalar(key)
+	if i == 0 && len(n.Content) > 0 {
+		k.HeadComment, n.Content[0].HeadComment = n.Content[0].HeadComment, ""
+	}
+	n.Content = append(n.Content[:2*i], append([]*yaml.Node{k, v}, n.Content[2*i:]...)...)
+}
+
+// remove deletes key from the mapping n and returns its key and value nodes
+func remove(n *yaml.Node, key string) (*yaml.Node, *yaml.Node) {
+	i := index(n, key)
+	if i < 0 {
+		return nil, nil
+	}
+	k, v := n.Content[i], n.Content[i+1]
+	n.Content = append(n.Content[:i], n.Content[i+2:]...)
+	return k, v
+}
@@ -0,0 +1,75 @@
+package config
+
+import (
+	"strings"
+	"testing"
+)
+
+// legacy is the example config of the first README, without an apiVersion and with the
+// cluster under cidrs
+const legacy = `# yaml-language-server: $schema=tidalwave.schema.json
+metadata:
+  name: mycluster
+spec:
+  projectID: myproject # billing account
+  cidrs:
+    nodes: 10.0.0.0/24
+    cluster:
+      machineType: n2-standard-8
+      # keep it small
+      maxNodeCount: 2
+  parallelism: 2
+`
+
+func TestMigrate(t *testing.T) {
+	out, from, err := Migrate([]byte(legacy))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if from != "" {
+		t.Errorf("got from %q, want no apiVersion", from)
+	}
+	want := `# yaml-language-server: $schema=tidalwave.schema.json
+apiVersion: tidalwave.io/v1alpha1
+kind: Controlplane
+metadata:
+  name: mycluster
+spec:
+  projectID: myproject # billing account
+  cidrs:
+    nodes: 10.0.0.0/24
+  cluster:
+    machineType: n2-standard-8
+    # keep it small
+    maxNodeCount: 2
+  parallelism: 2
+`
+	if string(out) != want {
+		t.Errorf("got\n%s\nwant\n%s", out, want)
+	}
+
+	again, from, err := Migrate(out)
+	if err != nil || from != APIVersion || string(again) != string(out) {
+		t.Errorf("migrating a current config should change nothing, got %q %v\n%s", from, err, again)
+	}
+
+	if _, _, err := Migrate([]byte("apiVersion: tidalwave.io/v9\n")); err == nil || !strings.Contains(err.Error(), "unsupported") {
+		t.Errorf("got %v, want an unsupported version error", err)
+	}
+}
+
+func TestLoadMigrates(t *testing.T) {
+	c, errs := load(t, legacy)
+	if errs != nil {
+		t.Fatal(errs)
+	}
+	if from, ok := c.MigratedFrom(); !ok || from != "" {
+		t.Errorf("got MigratedFrom %q %v", from, ok)
+	}
+	if c.Spec.Cluster.MachineType != "n2-standard-8" || c.Spec.Cluster.MaxNodeCount != 2 {
+		t.Errorf("cluster was not moved out of cidrs: %+v", c.Spec.Cluster)
+	}
+	if line := c.Line("spec.cluster.maxNodeCount"); line != 11 {
+		t.Errorf("got line %d, want the line in the file", line)
+	}
+}
@@ -0,0 +1,131 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// SchemaID is where the published JSON Schema of the config file lives
+const SchemaID = "https://raw.githubusercontent.com/theboarderline/tidalwave/main/schema/tidalwave.schema.json"
+
+// cidrPattern matches an IPv4 CIDR block
+const cidrPattern = `^([0-9]{1,3}\.){3}[0-9]{1,3}/([0-9]|[12][0-9]|3[0-2])$`
+
+// Schema returns the JSON Schema (draft 2020-12) of the config file, defaults are those of the
+// google provider
+func Schema() ([]byte, error) {
+	defaults := &Config{}
+	defaults.ApplyDefaults()
+	s := schemaOf(reflect.TypeOf(*defaults), reflect.ValueOf(*defaults))
+	s["$schema"] = "https://json-schema.org/draft/2020-12/schema"
+	s["$id"] = SchemaID
+	s["title"] = "tidalwave config"
+	s["description"] = "A DevOps controlplane managed by tidalwave"
+	b := &bytes.Buffer{}
+	enc := json.NewEncoder(b)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(s); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+// schemaOf describes values of type t, def holds the defaults of its fields
+func schemaOf(t reflect.Type, def reflect.Value) map[string]interface{} {
+	switch t.Kind() {
+	case reflect.Struct:
+		properties := map[string]interface{}{}
+		required := []string{}
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			name, _, _ := strings.Cut(f.Tag.Get("yaml"), ",")
+			if name == "" || name == "-" {
+				continue
+			}
+			p := schemaOf(f.Type, def.Field(i))
+			tags(p, f.Tag)
+			if v := def.Field(i); f.Type.Kind() != reflect.Struct && !v.IsZero() {
+				p["default"] = v.Interface()
+			}
+			if f.Tag.Get("required") == "true" {
+				required = append(required, name)
+			}
+			properties[name] = p
+		}
+		s := map[string]interface{}{
+			"type":                 "object",
+			"properties":           properties,
+			"additionalProperties": false,
+		}
+		if len(required) > 0 {
+			s["required"] = required
+		}
+		return s
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int32, reflect.Int64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Slice:
+		return map[string]interface{}{
+			"type":  "array",
+			"items": schemaOf(t.Elem(), reflect.Zero(t.Elem())),
+		}
+	case reflect.Map:
+		if t.Elem().Kind() == reflect.Interface {
+			return map[string]interface{}{"type": "object"}
+		}
+		return map[string]interface{}{
+			"type":                 "object",
+			"additionalProperties": schemaOf(t.Elem(), reflect.Zero(t.Elem())),
+		}
+	}
+	return map[string]interface{}{}
+}
+
+// tags applies the schema tags of a field to its schema, patterns and enums apply to the
+// items of arrays and values of maps
+func tags(s map[string]interface{}, tag reflect.StructTag) {
+	if doc := tag.Get("doc"); doc != "" {
+		s["description"] = doc
+	}
+	if min := tag.Get("minimum"); min != "" {
+		n, _ := strconv.Atoi(min)
+		s["minimum"] = n
+	}
+	leaf := s
+	for {
+		if items, ok := leaf["items"].(map[string]interface{}); ok {
+			leaf = items
+		} else if values, ok := leaf["additionalProperties"].(map[string]interface{}); ok {
+			leaf = values
+		} else {
+			break
+		}
+	}
+	switch pattern := tag.Get("pattern"); pattern {
+	case "":
+	case "cidr":
+		leaf["pattern"] = cidrPattern
+	default:
+		leaf["pattern"] = pattern
+	}
+	if enum := tag.Get("enum"); enum != "" {
+		values := strings.Split(enum, ",")
+		if tag.Get("open") == "true" {
+			// suggest the values but allow anything, such as the name of a plugin
+			delete(leaf, "type")
+			leaf["anyOf"] = []interface{}{
+				map[string]interface{}{"enum": values},
+				map[string]interface{}{"type": "string"},
+			}
+		} else {
+			leaf["enum"] = values
+		}
+	}
+}
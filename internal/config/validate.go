@@ -0,0 +1,465 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"path"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+var (
+	// nameRe is a GCP resource name, GKE limits cluster names to 40 characters
+	nameRe = regexp.MustCompile(`^[a-z]([-a-z0-9]{0,38}[a-z0-9])?$`)
+	// googleRegionRe is a GCP region such as us-central1 or northamerica-northeast1
+	googleRegionRe = regexp.MustCompile(`^[a-z]+-[a-z]+[0-9]+$`)
+	// awsRegionRe is an AWS region such as us-east-1 or us-gov-west-1
+	awsRegionRe = regexp.MustCompile(`^[a-z]{2}(-gov|-iso[a-z]?)?-[a-z]+-[0-9]+$`)
+	// dailyTimeRe is a time of day in maintenance windows
+	dailyTimeRe = regexp.MustCompile(`^([01][0-9]|2[0-3]):[0-5][0-9]$`)
+	// namespaceRe is a Kubernetes namespace
+	namespaceRe = regexp.MustCompile(`^[a-z0-9]([-a-z0-9]{0,61}[a-z0-9])?$`)
+	// versionRe is a release of Argo CD such as v2.4.11
+	versionRe = regexp.MustCompile(`^v[0-9]+\.[0-9]+\.[0-9]+$`)
+	// gkeVersionRe is a GKE version such as 1.24, 1.24.5 or 1.24.5-gke.600
+	gkeVersionRe = regexp.MustCompile(`^1\.[0-9]+(\.[0-9]+(-gke\.[0-9]+)?)?$`)
+)
+
+// cidr is an address range in the config
+type cidr struct {
+	field string
+	net   *net.IPNet
+}
+
+// overlaps reports whether two address ranges share any address
+func overlaps(a, b *net.IPNet) bool {
+	return a.Contains(b.IP) || b.Contains(a.IP)
+}
+
+// parseCidr parses the address range in field, recording an error if it is not valid
+func (c *Config) parseCidr(errs *Errors, field, value string) *cidr {
+	_, n, err := net.ParseCIDR(value)
+	if err != nil {
+		*errs = append(*errs, c.errorf(field, "%q is not a CIDR block", value))
+		return nil
+	}
+	if n.String() != value {
+		*errs = append(*errs, c.errorf(field, "%q has host bits set, did you mean %s", value, n))
+		return nil
+	}
+	return &cidr{field: field, net: n}
+}
+
+// disjoint records an error for every pair of ranges that overlap
+func (c *Config) disjoint(errs *Errors, cidrs ...*cidr) {
+	for i, a := range cidrs {
+		for _, b := range cidrs[i+1:] {
+			if a != nil && b != nil && overlaps(a.net, b.net) {
+				*errs = append(*errs, c.errorf(b.field, "%s overlaps %s %s", b.net, a.field, a.net))
+			}
+		}
+	}
+}
+
+// enums reports the fields under v set to a value their enum tag does not allow, open enums
+// only list suggestions
+func (c *Config) enums(v reflect.Value, path string) Errors {
+	errs := Errors{}
+	switch v.Kind() {
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			f := v.Type().Field(i)
+			name, _, _ := strings.Cut(f.Tag.Get("yaml"), ",")
+			if name == "" || name == "-" {
+				continue
+			}
+			field := path + "." + name
+			enum := f.Tag.Get("enum")
+			value := v.Field(i)
+			if enum == "" || f.Tag.Get("open") == "true" {
+				errs = append(errs, c.enums(value, field)...)
+				continue
+			}
+			allowed := strings.Split(enum, ",")
+			switch {
+			case value.Kind() == reflect.String:
+				if value.String() != "" && !contains(allowed, value.String()) {
+					errs = append(errs, c.errorf(field, "%q must be one of %s", value.String(), strings.Join(allowed, ", ")))
+				}
+			case value.Kind() == reflect.Slice && value.Type().Elem().Kind() == reflect.String:
+				for j := 0; j < value.Len(); j++ {
+					if item := value.Index(j).String(); !contains(allowed, item) {
+						errs = append(errs, c.errorf(fmt.Sprintf("%s[%d]", field, j), "%q must be one of %s", item, strings.Join(allowed, ", ")))
+					}
+				}
+			default:
+				errs = append(errs, c.enums(value, field)...)
+			}
+		}
+	case reflect.Slice:
+		for i := 0; i < v.Len(); i++ {
+			errs = append(errs, c.enums(v.Index(i), fmt.Sprintf("%s[%d]", path, i))...)
+		}
+	}
+	return errs
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// Validate checks the values in the config make sense, every problem is returned together as
+// Errors. Defaults should be applied first.
+func (c *Config) Validate() error {
+	errs := Errors{}
+	if c.APIVersion != APIVersion {
+		errs = append(errs, c.errorf("apiVersion", "unsupported version %q, expected %s", c.APIVersion, APIVersion))
+	}
+	if c.Kind != Kind {
+		errs = append(errs, c.errorf("kind", "unsupported kind %q, expected %s", c.Kind, Kind))
+	}
+	switch name := c.Metadata.Name; {
+	case name == "":
+		errs = append(errs, c.errorf("metadata.name", "is required"))
+	case !nameRe.MatchString(name):
+		errs = append(errs, c.errorf("metadata.name", "%q must be at most 40 lowercase letters, digits or hyphens, start with a letter and not end with a hyphen", name))
+	}
+
+	s := &c.Spec
+	if s.Parallelism < 0 {
+		errs = append(errs, c.errorf("spec.parallelism", "must be positive"))
+	}
+	cl := s.Cluster
+	if cl.MinNodeCount < 0 {
+		errs = append(errs, c.errorf("spec.cluster.minNodeCount", "must not be negative"))
+	}
+	if cl.MaxNodeCount < 1 {
+		errs = append(errs, c.errorf("spec.cluster.maxNodeCount", "must be at least 1"))
+	}
+	if cl.MinNodeCount > cl.MaxNodeCount {
+		errs = append(errs, c.errorf("spec.cluster.minNodeCount", "%d is more than maxNodeCount %d", cl.MinNodeCount, cl.MaxNodeCount))
+	}
+	if cl.DiskSize < 0 {
+		errs = append(errs, c.errorf("spec.cluster.diskSize", "must not be negative"))
+	}
+	for i, b := range cl.MasterAuthBlock {
+		c.parseCidr(&errs, fmt.Sprintf("spec.cluster.masterAuthBlock[%d].cidrBlock", i), b.CidrBlock)
+	}
+
+	kinds := Timeouts(s.Provider)
+	timeouts := make([]string, 0, len(s.Timeouts))
+	for kind := range s.Timeouts {
+		timeouts = append(timeouts, kind)
+	}
+	sort.Strings(timeouts)
+	for _, kind := range timeouts {
+		field := "spec.timeouts." + kind
+		if _, ok := kinds[kind]; kinds != nil && !ok {
+			errs = append(errs, c.errorf(field, "%s has no %s resource", s.Provider, kind))
+			continue
+		}
+		if d, err := time.ParseDuration(s.Timeouts[kind]); err != nil || d <= 0 {
+			errs = append(errs, c.errorf(field, "%q is not a positive duration such as 10m", s.Timeouts[kind]))
+		}
+	}
+
+	switch s.Provider {
+	case "google":
+		errs = append(errs, c.validateGoogle()...)
+	case "aws":
+		errs = append(errs, c.validateAWS()...)
+	}
+
+	errs = append(errs, c.validateBootstrap()...)
+
+	if s.State.Backend == "gcs" && s.State.Bucket == "" {
+		errs = append(errs, c.errorf("spec.state.bucket", "is required for the gcs state backend"))
+	}
+	errs = append(errs, c.enums(reflect.ValueOf(*s), "spec")...)
+
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// validateGoogle checks the fields used by the google provider
+func (c *Config) validateGoogle() Errors {
+	errs := Errors{}
+	s := &c.Spec
+	if s.ProjectID == "" {
+		errs = append(errs, c.errorf("spec.projectID", "is required"))
+	}
+	if !googleRegionRe.MatchString(s.Region) {
+		errs = append(errs, c.errorf("spec.region", "%q is not a GCP region such as us-central1", s.Region))
+	}
+	nodes := c.parseCidr(&errs, "spec.cidrs.nodes", s.Cidrs.Nodes)
+	pods := c.parseCidr(&errs, "spec.cidrs.pods", s.Cidrs.Pods)
+	services := c.parseCidr(&errs, "spec.cidrs.services", s.Cidrs.Services)
+	master := c.parseCidr(&errs, "spec.cluster.masterCidrBlock", s.Cluster.MasterCidrBlock)
+	if master != nil {
+		if ones, _ := master.net.Mask.Size(); ones != 28 {
+			errs = append(errs, c.errorf(master.field, "%s must be a /28", master.net))
+		}
+	}
+	c.disjoint(&errs, nodes, pods, services, master)
+	for _, field := range []string{"vpc", "public", "private"} {
+		if c.isSet("spec.cidrs." + field) {
+			errs = append(errs, c.errorf("spec.cidrs."+field, "is only used by the aws provider"))
+		}
+	}
+	if a := s.Cluster.ServiceAccount; a != "" && a != "default" && !strings.Contains(a, "@") {
+		errs = append(errs, c.errorf("spec.cluster.serviceAccount", "%q must be the email of a service account or default", a))
+	}
+	if v := s.Cluster.Version; v != "" && !gkeVersionRe.MatchString(v) {
+		errs = append(errs, c.errorf("spec.cluster.version", "%q is not a GKE version such as 1.24 or 1.24.5-gke.600", v))
+	}
+	switch {
+	case s.Cluster.Mode == "autopilot":
+		// validateAutopilot rejects pinned versions
+	case s.Cluster.Version != "" && s.Cluster.ReleaseChannel != "unspecified":
+		errs = append(errs, c.errorf("spec.cluster.releaseChannel", "must be unspecified when spec.cluster.version pins the cluster, GKE upgrades clusters on the %s channel past it", s.Cluster.ReleaseChannel))
+	case s.Cluster.Version == "" && s.Cluster.ReleaseChannel == "unspecified":
+		errs = append(errs, c.errorf("spec.cluster.releaseChannel", "can only be unspecified when spec.cluster.version pins the cluster"))
+	}
+	c.surge(&errs, "spec.cluster", s.Cluster.MaxSurge, s.Cluster.MaxUnavailable)
+	if s.Cluster.Mode == "autopilot" {
+		errs = append(errs, c.validateAutopilot()...)
+	}
+	errs = append(errs, c.validateNodePools()...)
+	if len(s.Cluster.Logging) > 0 && !contains(s.Cluster.Logging, "system") {
+		errs = append(errs, c.errorf("spec.cluster.logging", "must include system when other components are enabled"))
+	}
+	if len(s.Cluster.Monitoring) > 0 && !contains(s.Cluster.Monitoring, "system") {
+		errs = append(errs, c.errorf("spec.cluster.monitoring", "must include system when other components are enabled"))
+	}
+	if s.Cluster.GatewayAPI != "disabled" && !s.Cluster.Addons.HTTPLoadBalancing {
+		errs = append(errs, c.errorf("spec.cluster.gatewayAPI", "needs the httpLoadBalancing add-on"))
+	}
+	if s.Cluster.VulnerabilityScanning && s.Cluster.SecurityPosture != "basic" {
+		errs = append(errs, c.errorf("spec.cluster.vulnerabilityScanning", "needs securityPosture basic"))
+	}
+	errs = append(errs, c.validateMaintenance()...)
+	return errs
+}
+
+// validateMaintenance checks the maintenance window and exclusions of spec.cluster.maintenance
+func (c *Config) validateMaintenance() Errors {
+	errs := Errors{}
+	m := &c.Spec.Cluster.Maintenance
+	recurring := m.Start != "" || m.End != "" || m.Recurrence != ""
+	if recurring {
+		if c.isSet("spec.cluster.maintenance.dailyStartTime") {
+			errs = append(errs, c.errorf("spec.cluster.maintenance.dailyStartTime", "cannot be set together with a recurring window"))
+		}
+		for _, f := range [][2]string{{"start", m.Start}, {"end", m.End}, {"recurrence", m.Recurrence}} {
+			if f[1] == "" {
+				errs = append(errs, c.errorf("spec.cluster.maintenance."+f[0], "is required for a recurring window"))
+			}
+		}
+		if m.Recurrence != "" && !strings.HasPrefix(m.Recurrence, "FREQ=") {
+			errs = append(errs, c.errorf("spec.cluster.maintenance.recurrence", "%q must be an RRULE such as FREQ=WEEKLY;BYDAY=SA,SU", m.Recurrence))
+		}
+		c.timeWindow(&errs, "spec.cluster.maintenance", m.Start, m.End)
+	} else if m.DailyStartTime != "" && !dailyTimeRe.MatchString(m.DailyStartTime) {
+		errs = append(errs, c.errorf("spec.cluster.maintenance.dailyStartTime", "%q must be a time of day such as 06:00", m.DailyStartTime))
+	}
+	names := map[string]bool{}
+	for i, e := range m.Exclusions {
+		field := fmt.Sprintf("spec.cluster.maintenance.exclusions[%d]", i)
+		switch {
+		case e.Name == "":
+			errs = append(errs, c.errorf(field+".name", "is required"))
+		case names[e.Name]:
+			errs = append(errs, c.errorf(field+".name", "%q is already the name of an exclusion", e.Name))
+		}
+		names[e.Name] = true
+		if e.Start == "" {
+			errs = append(errs, c.errorf(field+".start", "is required"))
+		}
+		if e.End == "" {
+			errs = append(errs, c.errorf(field+".end", "is required"))
+		}
+		c.timeWindow(&errs, field, e.Start, e.End)
+	}
+	return errs
+}
+
+// timeWindow checks that the start and end of a window under field are RFC 3339 times and that
+// the window ends after it starts, empty times are left to the caller
+func (c *Config) timeWindow(errs *Errors, field, start, end string) {
+	parse := func(name, value string) (time.Time, bool) {
+		if value == "" {
+			return time.Time{}, false
+		}
+		t, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			*errs = append(*errs, c.errorf(field+"."+name, "%q must be an RFC 3339 time such as 2023-01-07T06:00:00Z", value))
+			return time.Time{}, false
+		}
+		return t, true
+	}
+	s, startOK := parse("start", start)
+	e, endOK := parse("end", end)
+	if startOK && endOK && !e.After(s) {
+		*errs = append(*errs, c.errorf(field+".end", "%s is not after start %s", end, start))
+	}
+}
+
+// surge checks the upgrade surge settings under field, an upgrade needs to add or take away at
+// least one node at a time
+func (c *Config) surge(errs *Errors, field string, maxSurge, maxUnavailable int32) {
+	if maxSurge < 0 {
+		*errs = append(*errs, c.errorf(field+".maxSurge", "must not be negative"))
+	}
+	if maxUnavailable < 0 {
+		*errs = append(*errs, c.errorf(field+".maxUnavailable", "must not be negative"))
+	}
+	if maxSurge == 0 && maxUnavailable == 0 {
+		*errs = append(*errs, c.errorf(field+".maxSurge", "cannot be 0 when maxUnavailable is 0"))
+	}
+}
+
+// validateAutopilot rejects the node pool fields GKE manages itself in autopilot mode
+func (c *Config) validateAutopilot() Errors {
+	errs := Errors{}
+	for _, field := range []string{"machineType", "diskSize", "minNodeCount", "maxNodeCount", "nodePools", "imageStreaming", "workloadMetadata",
+		"maxSurge", "maxUnavailable", "addons.configConnector", "addons.gcePersistentDiskCsiDriver", "addons.gcpFilestoreCsiDriver"} {
+		if c.isSet("spec.cluster." + field) {
+			errs = append(errs, c.errorf("spec.cluster."+field, "is not used in autopilot mode"))
+		}
+	}
+	if c.Spec.Cluster.Datapath != "advanced" {
+		errs = append(errs, c.errorf("spec.cluster.datapath", "must be advanced in autopilot mode"))
+	}
+	if c.Spec.Cluster.Version != "" {
+		errs = append(errs, c.errorf("spec.cluster.version", "cannot be pinned in autopilot mode, Autopilot clusters are always on a release channel"))
+	}
+	if c.Spec.Cluster.ReleaseChannel == "unspecified" {
+		errs = append(errs, c.errorf("spec.cluster.releaseChannel", "cannot be unspecified in autopilot mode"))
+	}
+	return errs
+}
+
+// validateNodePools checks the node pools of spec.cluster.nodePools
+func (c *Config) validateNodePools() Errors {
+	errs := Errors{}
+	names := map[string]bool{"default-pool": true}
+	for i, pool := range c.Spec.Cluster.NodePools {
+		field := fmt.Sprintf("spec.cluster.nodePools[%d]", i)
+		switch {
+		case pool.Name == "":
+			errs = append(errs, c.errorf(field+".name", "is required"))
+		case !nameRe.MatchString(pool.Name):
+			errs = append(errs, c.errorf(field+".name", "%q must be at most 40 lowercase letters, digits or hyphens, start with a letter and not end with a hyphen", pool.Name))
+		case names[pool.Name]:
+			errs = append(errs, c.errorf(field+".name", "%q is already the name of a node pool", pool.Name))
+		}
+		names[pool.Name] = true
+		if pool.MinNodeCount < 0 {
+			errs = append(errs, c.errorf(field+".minNodeCount", "must not be negative"))
+		}
+		if pool.MaxNodeCount < 1 {
+			errs = append(errs, c.errorf(field+".maxNodeCount", "must be at least 1"))
+		}
+		if pool.MinNodeCount > pool.MaxNodeCount {
+			errs = append(errs, c.errorf(field+".minNodeCount", "%d is more than maxNodeCount %d", pool.MinNodeCount, pool.MaxNodeCount))
+		}
+		if pool.DiskSize < 0 {
+			errs = append(errs, c.errorf(field+".diskSize", "must not be negative"))
+		}
+		if a := pool.ServiceAccount; a != "" && a != "default" && !strings.Contains(a, "@") {
+			errs = append(errs, c.errorf(field+".serviceAccount", "%q must be the email of a service account or default", a))
+		}
+		c.surge(&errs, field, pool.MaxSurge, pool.MaxUnavailable)
+		if pool.Spot && pool.Preemptible {
+			errs = append(errs, c.errorf(field+".preemptible", "cannot be set together with spot"))
+		}
+		for j, t := range pool.Taints {
+			if t.Key == "" {
+				errs = append(errs, c.errorf(fmt.Sprintf("%s.taints[%d].key", field, j), "is required"))
+			}
+			if t.Effect == "" {
+				errs = append(errs, c.errorf(fmt.Sprintf("%s.taints[%d].effect", field, j), "is required"))
+			}
+		}
+	}
+	return errs
+}
+
+// validateAWS checks the fields used by the aws provider
+func (c *Config) validateAWS() Errors {
+	errs := Errors{}
+	s := &c.Spec
+	if !awsRegionRe.MatchString(s.Region) {
+		errs = append(errs, c.errorf("spec.region", "%q is not an AWS region such as us-east-1", s.Region))
+	}
+	vpc := c.parseCidr(&errs, "spec.cidrs.vpc", s.Cidrs.Vpc)
+	subnets := []*cidr{c.parseCidr(&errs, "spec.cidrs.public", s.Cidrs.Public)}
+	if len(s.Cidrs.Private) == 0 {
+		errs = append(errs, c.errorf("spec.cidrs.private", "needs at least one subnet"))
+	}
+	for i, p := range s.Cidrs.Private {
+		subnets = append(subnets, c.parseCidr(&errs, fmt.Sprintf("spec.cidrs.private[%d]", i), p))
+	}
+	if vpc != nil {
+		for _, subnet := range subnets {
+			if subnet != nil && !vpc.net.Contains(subnet.net.IP) {
+				errs = append(errs, c.errorf(subnet.field, "%s is outside the vpc %s", subnet.net, vpc.net))
+			}
+		}
+	}
+	c.disjoint(&errs, subnets...)
+	if len(s.AWS.Zones) > 0 && len(s.AWS.Zones) < len(s.Cidrs.Private) {
+		errs = append(errs, c.errorf("spec.aws.zones", "%d zones for %d private subnets", len(s.AWS.Zones), len(s.Cidrs.Private)))
+	}
+	for _, field := range []string{"nodes", "pods", "services"} {
+		if c.isSet("spec.cidrs." + field) {
+			errs = append(errs, c.errorf("spec.cidrs."+field, "is only used by the google provider"))
+		}
+	}
+	for _, field := range []string{"mode", "nodePools", "serviceAccount", "binaryAuthorization", "addons", "maintenance", "logging", "monitoring",
+		"imageStreaming", "workloadMetadata", "costAllocation", "gatewayAPI", "securityPosture", "vulnerabilityScanning", "maxSurge", "maxUnavailable"} {
+		if c.isSet("spec.cluster." + field) {
+			errs = append(errs, c.errorf("spec.cluster."+field, "is only used by the google provider"))
+		}
+	}
+	return errs
+}
+
+// validateBootstrap checks the fields of spec.bootstrap
+func (c *Config) validateBootstrap() Errors {
+	errs := Errors{}
+	b := &c.Spec.Bootstrap
+	if !versionRe.MatchString(b.ArgoCD.Version) {
+		errs = append(errs, c.errorf("spec.bootstrap.argocd.version", "%q is not a version such as %s", b.ArgoCD.Version, argoCDVersion))
+	}
+	for i, k := range b.Kustomizations {
+		if k == "" || path.IsAbs(k) || path.Clean(k) != k || strings.HasPrefix(k, "..") {
+			errs = append(errs, c.errorf(fmt.Sprintf("spec.bootstrap.kustomizations[%d]", i), "%q must be a directory inside manifests", k))
+		}
+	}
+	if d, err := time.ParseDuration(b.Timeout); err != nil || d <= 0 {
+		errs = append(errs, c.errorf("spec.bootstrap.timeout", "%q is not a positive duration such as 30m", b.Timeout))
+	}
+	addons := c.Spec.Addons.All()
+	names := make([]string, 0, len(addons))
+	for name := range addons {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if ns := addons[name].Namespace; !namespaceRe.MatchString(ns) {
+			errs = append(errs, c.errorf("spec.addons."+name+".namespace", "%q is not a Kubernetes namespace", ns))
+		}
+	}
+	return errs
+}
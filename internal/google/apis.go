@@ -2,10 +2,10 @@ package google
 
 import (
 	serviceusage "cloud.google.com/go/serviceusage/apiv1"
+	serviceusagepb "cloud.google.com/go/serviceusage/apiv1/serviceusagepb"
 	"context"
 	"fmt"
-	"github.com/kyokomi/emoji/v2"
-	serviceusagepb "google.golang.org/genproto/googleapis/api/serviceusage/v1"
+	"tidalwave/internal/tidalwave"
 )
 
 type Apis struct {
@@ -25,9 +25,13 @@ var RequiredApis = Apis{
 }
 
 // EnableApis will enable any apis in the list that arent already enabled
-func (r *Controlplane) EnableApis() error {
-	ctx := context.Background()
-	c, err := serviceusage.NewClient(ctx)
+func (r *Controlplane) EnableApis(ctx context.Context) error {
+	if timeout := r.Timeouts["apis"]; timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	c, err := serviceusage.NewClient(ctx, r.Endpoints.grpcOptions(r.Endpoints.ServiceUsage)...)
 	if err != nil {
 		return err
 	}
@@ -42,12 +46,13 @@ func (r *Controlplane) EnableApis() error {
 	if err != nil {
 		return err
 	}
+	defer tidalwave.StartOperation(ctx, operation.Name(), "enable apis")()
 	resp, err := operation.Wait(ctx)
 	if err != nil {
 		return err
 	}
 	for _, service := range resp.Services {
-		emoji.Printf(":check_mark_button: Enabled %s\n", service.Name)
+		tidalwave.Infof(ctx, ":check_mark_button:", "Enabled %s", service.Name)
 	}
 
 	return nil
@@ -2,16 +2,34 @@ package google
 
 import (
 	"context"
+	"fmt"
+	"strings"
+	"tidalwave/internal/google/gcp"
+	"tidalwave/internal/state"
+	"tidalwave/internal/tidalwave"
+	"time"
 
 	compute "cloud.google.com/go/compute/apiv1"
 	container "cloud.google.com/go/container/apiv1"
+	admin "cloud.google.com/go/iam/admin/apiv1"
 	kms "cloud.google.com/go/kms/apiv1"
-	"github.com/kyokomi/emoji/v2"
+	resourcemanager "cloud.google.com/go/resourcemanager/apiv3"
 )
 
 // Controlplane contains values for a GKE clutser and its dependencies
 type Controlplane struct {
 	Apis []string
+	// State records the resources tidalwave created, nil disables tracking
+	State *state.Store
+	// Force deletes resources even if they are not recorded in State
+	Force bool
+	// Parallelism is the number of resources provisioned at once
+	Parallelism int
+	// Timeouts limit how long each kind of resource may take, keyed by vpc, subnetwork,
+	// router, keyring, cryptokey, serviceaccount, cluster, firewall and apis
+	Timeouts map[string]time.Duration
+	// Endpoints override the GCP API endpoints
+	Endpoints Endpoints
 	Vpc
 	Subnetwork
 	Router
@@ -19,6 +37,16 @@ type Controlplane struct {
 	Firewalls []Firewall
 	Keyring
 	CryptoKey
+	ServiceAccount
+}
+
+// waitCompute waits for a compute operation, recording it as running while it is waited on
+func waitCompute(ctx context.Context, op gcp.Operation, description string) error {
+	defer tidalwave.StartOperation(ctx, op.Name(), description)()
+	if err := op.Wait(ctx); err != nil {
+		return err
+	}
+	return computeOperationError(op.Proto())
 }
 
 // BoolPtr convertes a bool to *bool
@@ -31,253 +59,460 @@ func StrPtr(s string) *string {
 	return &s
 }
 
-// Create controlplane
-func (c *Controlplane) Create() error {
-	ctx := context.Background()
+// clients are the GCP API clients used by the controlplane, they are safe for concurrent use
+type clients struct {
+	networks    gcp.NetworksClient
+	subnetworks gcp.SubnetworksClient
+	routers     gcp.RoutersClient
+	firewalls   gcp.FirewallsClient
+	kms         gcp.KMSClient
+	container   gcp.ContainerClient
+	iam         gcp.IAMClient
+	projects    gcp.ProjectsClient
 
-	vpcClient, err := compute.NewNetworksRESTClient(ctx)
-	if err != nil {
-		return err
-	}
-	defer vpcClient.Close()
-	network, err := c.Vpc.create(ctx, vpcClient)
-	if err != nil {
-		return err
-	}
-	emoji.Println(":check_mark_button: Controlplane VPC created")
+	closers []func() error
+}
 
-	c.Subnetwork.Network = network.GetSelfLink()
-	subnetClient, err := compute.NewSubnetworksRESTClient(ctx)
+func newClients(ctx context.Context, endpoints Endpoints) (*clients, error) {
+	cl := &clients{}
+	computeOpts := endpoints.restOptions(endpoints.Compute)
+	networks, err := compute.NewNetworksRESTClient(ctx, computeOpts...)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	defer subnetClient.Close()
-	_, err = c.Subnetwork.create(ctx, subnetClient)
+	cl.networks = gcp.Networks(networks)
+	cl.closers = append(cl.closers, networks.Close)
+	subnetworks, err := compute.NewSubnetworksRESTClient(ctx, computeOpts...)
 	if err != nil {
-		return err
+		cl.Close()
+		return nil, err
 	}
-	emoji.Println(":check_mark_button: Controlplane subnetwork created")
-
-	c.Router.Network = network.GetSelfLink()
-	routerClient, err := compute.NewRoutersRESTClient(ctx)
+	cl.subnetworks = gcp.Subnetworks(subnetworks)
+	cl.closers = append(cl.closers, subnetworks.Close)
+	routers, err := compute.NewRoutersRESTClient(ctx, computeOpts...)
 	if err != nil {
-		return err
+		cl.Close()
+		return nil, err
 	}
-	defer routerClient.Close()
-	_, err = c.Router.create(ctx, routerClient)
+	cl.routers = gcp.Routers(routers)
+	cl.closers = append(cl.closers, routers.Close)
+	firewalls, err := compute.NewFirewallsRESTClient(ctx, computeOpts...)
 	if err != nil {
-		return err
+		cl.Close()
+		return nil, err
 	}
-	emoji.Println(":check_mark_button: Controlplane router created")
-
-	kmsClient, err := kms.NewKeyManagementClient(ctx)
+	cl.firewalls = gcp.Firewalls(firewalls)
+	cl.closers = append(cl.closers, firewalls.Close)
+	keys, err := kms.NewKeyManagementClient(ctx, endpoints.grpcOptions(endpoints.KMS)...)
 	if err != nil {
-		return err
+		cl.Close()
+		return nil, err
 	}
-	defer kmsClient.Close()
-
-	keyring, err := c.Keyring.create(ctx, kmsClient)
+	cl.kms = keys
+	cl.closers = append(cl.closers, keys.Close)
+	clusters, err := container.NewClusterManagerClient(ctx, endpoints.grpcOptions(endpoints.Container)...)
 	if err != nil {
-		return err
+		cl.Close()
+		return nil, err
 	}
-	emoji.Println(":check_mark_button: Controlplane KMS Keyring created")
-
-	c.CryptoKey.Keyring = keyring.GetName()
-	cryptoKey, err := c.CryptoKey.create(ctx, kmsClient)
+	cl.container = clusters
+	cl.closers = append(cl.closers, clusters.Close)
+	accounts, err := admin.NewIamClient(ctx, endpoints.grpcOptions(endpoints.IAM)...)
 	if err != nil {
-		return err
+		cl.Close()
+		return nil, err
 	}
-	emoji.Println(":check_mark_button: Controlplane KMS Crypto Key created")
-
-	c.Cluster.CryptoKeyName = cryptoKey.GetName()
-	clusterClient, err := container.NewClusterManagerClient(ctx)
-	if err != nil {
-		return err
-	}
-	defer clusterClient.Close()
-	_, err = c.Cluster.create(ctx, clusterClient)
+	cl.iam = accounts
+	cl.closers = append(cl.closers, accounts.Close)
+	projects, err := resourcemanager.NewProjectsClient(ctx, endpoints.grpcOptions(endpoints.ResourceManager)...)
 	if err != nil {
-		return err
+		cl.Close()
+		return nil, err
 	}
-	emoji.Println(":check_mark_button: Controlplane cluster created")
+	cl.projects = projects
+	cl.closers = append(cl.closers, projects.Close)
+	return cl, nil
+}
 
-	firewallClient, err := compute.NewFirewallsRESTClient(ctx)
-	if err != nil {
-		return err
-	}
-	defer firewallClient.Close()
-	for i := range c.Firewalls {
-		c.Firewalls[i].Network = network.GetSelfLink()
-		_, err = c.Firewalls[i].create(ctx, firewallClient)
-		if err != nil {
-			return err
-		}
+// Close every client that was created
+func (cl *clients) Close() {
+	for _, c := range cl.closers {
+		c()
 	}
-	emoji.Println(":check_mark_button: Controlplane firewall rules created")
-
-	return nil
 }
 
-// Delete controlplane
-func (c *Controlplane) Delete() error {
-	ctx := context.Background()
-
-	firewallClient, err := compute.NewFirewallsRESTClient(ctx)
-	if err != nil {
-		return err
+// graph returns the controlplane resources and their dependencies. The keyring and crypto key
+// do not depend on the network so they are provisioned alongside the VPC, subnetwork and router.
+func (c *Controlplane) graph(cl *clients, update bool) (*tidalwave.Graph, error) {
+	verb := "created"
+	if update {
+		verb = "updated"
+	}
+	g := tidalwave.NewGraph(c.Parallelism)
+	nodes := []tidalwave.Node{
+		{
+			Name: "vpc",
+			Create: func(ctx context.Context, in tidalwave.Outputs) (tidalwave.Outputs, error) {
+				existed := c.Vpc.exists(ctx, cl.networks)
+				apply := c.Vpc.create
+				if update {
+					apply = c.Vpc.update
+				}
+				network, err := apply(ctx, cl.networks)
+				if err != nil {
+					return nil, err
+				}
+				if err := c.track(ctx, existed, networkResource(network, &c.Vpc)); err != nil {
+					return nil, err
+				}
+				tidalwave.Infof(ctx, ":check_mark_button:", "Controlplane VPC %s", verb)
+				return tidalwave.Outputs{"selfLink": network.GetSelfLink()}, nil
+			},
+			Delete: func(ctx context.Context) error {
+				return c.destroy(ctx, "vpc", c.Vpc.Name, func() (string, error) {
+					n, err := c.Vpc.get(ctx, cl.networks)
+					return fmt.Sprint(n.GetId()), err
+				}, func() error {
+					return c.Vpc.delete(ctx, cl.networks)
+				})
+			},
+		},
+		{
+			Name: "subnetwork",
+			Deps: []string{"vpc"},
+			Create: func(ctx context.Context, in tidalwave.Outputs) (tidalwave.Outputs, error) {
+				c.Subnetwork.Network = in["vpc.selfLink"]
+				existed := c.Subnetwork.exists(ctx, cl.subnetworks)
+				apply := c.Subnetwork.create
+				if update {
+					apply = c.Subnetwork.update
+				}
+				subnet, err := apply(ctx, cl.subnetworks)
+				if err != nil {
+					return nil, err
+				}
+				if err := c.track(ctx, existed, subnetworkResource(subnet, &c.Subnetwork)); err != nil {
+					return nil, err
+				}
+				tidalwave.Infof(ctx, ":check_mark_button:", "Controlplane subnetwork %s", verb)
+				return tidalwave.Outputs{"selfLink": subnet.GetSelfLink()}, nil
+			},
+			Delete: func(ctx context.Context) error {
+				return c.destroy(ctx, "subnetwork", c.Subnetwork.Name, func() (string, error) {
+					s, err := c.Subnetwork.get(ctx, cl.subnetworks)
+					return fmt.Sprint(s.GetId()), err
+				}, func() error {
+					return c.Subnetwork.delete(ctx, cl.subnetworks)
+				})
+			},
+		},
+		{
+			Name: "router",
+			Deps: []string{"vpc"},
+			Create: func(ctx context.Context, in tidalwave.Outputs) (tidalwave.Outputs, error) {
+				c.Router.Network = in["vpc.selfLink"]
+				existed := c.Router.exists(ctx, cl.routers)
+				apply := c.Router.create
+				if update {
+					apply = c.Router.update
+				}
+				router, err := apply(ctx, cl.routers)
+				if err != nil {
+					return nil, err
+				}
+				if err := c.track(ctx, existed, routerResource(router, &c.Router)); err != nil {
+					return nil, err
+				}
+				tidalwave.Infof(ctx, ":check_mark_button:", "Controlplane router %s", verb)
+				return tidalwave.Outputs{"selfLink": router.GetSelfLink()}, nil
+			},
+			Delete: func(ctx context.Context) error {
+				return c.destroy(ctx, "router", c.Router.Name, func() (string, error) {
+					r, err := c.Router.get(ctx, cl.routers)
+					return fmt.Sprint(r.GetId()), err
+				}, func() error {
+					return c.Router.delete(ctx, cl.routers)
+				})
+			},
+		},
+		{
+			// KMS Keyrings cannot be deleted so there is no Delete
+			Name: "keyring",
+			Create: func(ctx context.Context, in tidalwave.Outputs) (tidalwave.Outputs, error) {
+				existed := c.Keyring.exists(ctx, cl.kms)
+				keyring, err := c.Keyring.create(ctx, cl.kms)
+				if err != nil {
+					return nil, err
+				}
+				if err := c.track(ctx, existed, keyringResource(keyring, &c.Keyring)); err != nil {
+					return nil, err
+				}
+				tidalwave.Infof(ctx, ":check_mark_button:", "Controlplane KMS Keyring %s", verb)
+				return tidalwave.Outputs{"name": keyring.GetName()}, nil
+			},
+		},
+		{
+			Name: "cryptokey",
+			Deps: []string{"keyring"},
+			Create: func(ctx context.Context, in tidalwave.Outputs) (tidalwave.Outputs, error) {
+				c.CryptoKey.Keyring = in["keyring.name"]
+				_, existed := c.CryptoKey.exists(ctx, cl.kms)
+				apply := c.CryptoKey.create
+				if update {
+					apply = c.CryptoKey.update
+				}
+				cryptoKey, err := apply(ctx, cl.kms)
+				if err != nil {
+					return nil, err
+				}
+				if err := c.track(ctx, existed, cryptoKeyResource(cryptoKey, &c.CryptoKey)); err != nil {
+					return nil, err
+				}
+				tidalwave.Infof(ctx, ":check_mark_button:", "Controlplane KMS Crypto Key %s", verb)
+				return tidalwave.Outputs{"name": cryptoKey.GetName()}, nil
+			},
+			// Crypto Keys cannot be deleted, only the GKE service agent's access to it is removed
+			Delete: func(ctx context.Context) error {
+				c.CryptoKey.Keyring = c.Keyring.name()
+				return c.destroy(ctx, "cryptokey", c.CryptoKey.Name, func() (string, error) {
+					k, err := c.CryptoKey.get(ctx, cl.kms)
+					return cryptoKeyResource(k, &c.CryptoKey).Fingerprint, err
+				}, func() error {
+					return c.CryptoKey.delete(ctx, cl.kms)
+				})
+			},
+		},
+		{
+			// an existing service account given by email is used as is and never deleted
+			Name: "serviceaccount",
+			Create: func(ctx context.Context, in tidalwave.Outputs) (tidalwave.Outputs, error) {
+				if !c.ServiceAccount.managed() {
+					return tidalwave.Outputs{"email": c.ServiceAccount.email()}, nil
+				}
+				existed := c.ServiceAccount.exists(ctx, cl.iam)
+				account, err := c.ServiceAccount.create(ctx, cl.iam, cl.projects)
+				if err != nil {
+					return nil, err
+				}
+				if err := c.track(ctx, existed, serviceAccountResource(account, &c.ServiceAccount)); err != nil {
+					return nil, err
+				}
+				tidalwave.Infof(ctx, ":check_mark_button:", "Controlplane node service account %s", verb)
+				return tidalwave.Outputs{"email": account.GetEmail()}, nil
+			},
+			Delete: func(ctx context.Context) error {
+				if !c.ServiceAccount.managed() {
+					return nil
+				}
+				return c.destroy(ctx, "serviceaccount", c.ServiceAccount.Name, func() (string, error) {
+					account, err := c.ServiceAccount.get(ctx, cl.iam)
+					return account.GetUniqueId(), err
+				}, func() error {
+					return c.ServiceAccount.delete(ctx, cl.iam, cl.projects)
+				})
+			},
+		},
+		{
+			Name: "cluster",
+			Deps: []string{"subnetwork", "router", "cryptokey", "serviceaccount"},
+			Create: func(ctx context.Context, in tidalwave.Outputs) (tidalwave.Outputs, error) {
+				c.Cluster.CryptoKeyName = in["cryptokey.name"]
+				c.Cluster.ServiceAccount = in["serviceaccount.email"]
+				existed := c.Cluster.exists(ctx, cl.container)
+				apply := c.Cluster.create
+				if update {
+					apply = c.Cluster.update
+				}
+				cluster, err := apply(ctx, cl.container)
+				if err != nil {
+					return nil, err
+				}
+				if err := c.track(ctx, existed, clusterResource(cluster, &c.Cluster)); err != nil {
+					return nil, err
+				}
+				tidalwave.Infof(ctx, ":check_mark_button:", "Controlplane cluster %s", verb)
+				return tidalwave.Outputs{"selfLink": cluster.GetSelfLink()}, nil
+			},
+			Delete: func(ctx context.Context) error {
+				return c.destroy(ctx, "cluster", c.Cluster.Name, func() (string, error) {
+					cluster, err := c.Cluster.get(ctx, cl.container)
+					return cluster.GetId(), err
+				}, func() error {
+					return c.Cluster.delete(ctx, cl.container)
+				})
+			},
+		},
 	}
-	defer firewallClient.Close()
 	for i := range c.Firewalls {
-		err = c.Firewalls[i].delete(ctx, firewallClient)
-		if err != nil {
-			return err
+		f := &c.Firewalls[i]
+		nodes = append(nodes, tidalwave.Node{
+			Name: fmt.Sprintf("firewall/%s", f.Name),
+			Deps: []string{"vpc"},
+			Create: func(ctx context.Context, in tidalwave.Outputs) (tidalwave.Outputs, error) {
+				f.Network = in["vpc.selfLink"]
+				existed := f.exists(ctx, cl.firewalls)
+				apply := f.create
+				if update {
+					apply = f.update
+				}
+				rule, err := apply(ctx, cl.firewalls)
+				if err != nil {
+					return nil, err
+				}
+				if err := c.track(ctx, existed, firewallResource(rule, f)); err != nil {
+					return nil, err
+				}
+				tidalwave.Infof(ctx, ":check_mark_button:", "Controlplane firewall rule %s %s", f.Name, verb)
+				return tidalwave.Outputs{"selfLink": rule.GetSelfLink()}, nil
+			},
+			Delete: func(ctx context.Context) error {
+				return c.destroy(ctx, "firewall", f.Name, func() (string, error) {
+					rule, err := f.get(ctx, cl.firewalls)
+					return fmt.Sprint(rule.GetId()), err
+				}, func() error {
+					return f.delete(ctx, cl.firewalls)
+				})
+			},
+		})
+	}
+	for _, n := range nodes {
+		kind, _, _ := strings.Cut(n.Name, "/")
+		n.Timeout = c.Timeouts[kind]
+		if err := g.Add(n); err != nil {
+			return nil, err
 		}
 	}
-	emoji.Println(":cross_mark_button: Controlplane firewall rules deleted")
+	return g, nil
+}
 
-	clusterClient, err := container.NewClusterManagerClient(ctx)
-	if err != nil {
-		return err
-	}
-	defer clusterClient.Close()
-	err = c.Cluster.delete(ctx, clusterClient)
-	if err != nil {
-		return err
-	}
-	emoji.Println(":cross_mark_button: Controlplane cluster destroyed")
+// SetState records the resources the controlplane creates in store
+func (c *Controlplane) SetState(store *state.Store, force bool) {
+	c.State = store
+	c.Force = force
+}
 
-	kmsClient, err := kms.NewKeyManagementClient(ctx)
-	if err != nil {
-		return err
-	}
-	defer kmsClient.Close()
-	keyring, err := c.Keyring.get(ctx, kmsClient)
-	if err != nil {
-		return err
-	}
-	c.CryptoKey.Keyring = keyring.GetName()
-	err = c.CryptoKey.delete(ctx, kmsClient)
-	if err != nil {
-		return err
-	}
-	emoji.Println(":cross_mark_button: Controlplane KMS Crypto Key IAM permissions deleted")
+// Create controlplane
+func (c *Controlplane) Create(ctx context.Context) error {
+	c.warnOrphans(ctx)
 
-	routerClient, err := compute.NewRoutersRESTClient(ctx)
+	cl, err := newClients(ctx, c.Endpoints)
 	if err != nil {
 		return err
 	}
-	defer routerClient.Close()
-	err = c.Router.delete(ctx, routerClient)
+	defer cl.Close()
+	g, err := c.graph(cl, false)
 	if err != nil {
 		return err
 	}
-	emoji.Println(":cross_mark_button: Controlplane router destroyed")
+	return g.Apply(ctx)
+}
 
-	subnetClient, err := compute.NewSubnetworksRESTClient(ctx)
-	if err != nil {
-		return err
-	}
-	defer subnetClient.Close()
-	err = c.Subnetwork.delete(ctx, subnetClient)
-	if err != nil {
-		return err
-	}
-	emoji.Println(":cross_mark_button: Controlplane subnetwork destroyed")
+// Delete controlplane, only resources recorded in state are deleted
+func (c *Controlplane) Delete(ctx context.Context) error {
 
-	vpcClient, err := compute.NewNetworksRESTClient(ctx)
+	cl, err := newClients(ctx, c.Endpoints)
 	if err != nil {
 		return err
 	}
-	defer vpcClient.Close()
-	err = c.Vpc.delete(ctx, vpcClient)
+	defer cl.Close()
+	g, err := c.graph(cl, false)
 	if err != nil {
 		return err
 	}
-	emoji.Println(":cross_mark_button: Controlplane VPC destroyed")
-
-	return nil
+	return g.Destroy(ctx)
 }
 
 // Update controlplane
-func (c *Controlplane) Update() error {
-	ctx := context.Background()
+func (c *Controlplane) Update(ctx context.Context) error {
+	c.warnOrphans(ctx)
 
-	vpcClient, err := compute.NewNetworksRESTClient(ctx)
+	cl, err := newClients(ctx, c.Endpoints)
 	if err != nil {
 		return err
 	}
-	defer vpcClient.Close()
-	network, err := c.Vpc.update(ctx, vpcClient)
+	defer cl.Close()
+	g, err := c.graph(cl, true)
 	if err != nil {
 		return err
 	}
-	emoji.Println(":check_mark_button: Controlplane VPC updated")
+	return g.Apply(ctx)
+}
 
-	c.Subnetwork.Network = network.GetSelfLink()
-	subnetClient, err := compute.NewSubnetworksRESTClient(ctx)
+// Plan compares the config with the live controlplane without changing anything
+func (c *Controlplane) Plan(ctx context.Context) ([]tidalwave.ResourcePlan, error) {
+	cl, err := newClients(ctx, c.Endpoints)
 	if err != nil {
-		return err
-	}
-	defer subnetClient.Close()
-	_, err = c.Subnetwork.update(ctx, subnetClient)
-	if err != nil {
-		return err
+		return nil, err
 	}
-	emoji.Println(":check_mark_button: Controlplane subnetwork updated")
+	defer cl.Close()
+	return c.plan(ctx, cl)
+}
+
+// plan diffs every resource using cl
+func (c *Controlplane) plan(ctx context.Context, cl *clients) ([]tidalwave.ResourcePlan, error) {
+	plan := []tidalwave.ResourcePlan{}
 
-	c.Router.Network = network.GetSelfLink()
-	routerClient, err := compute.NewRoutersRESTClient(ctx)
+	p, err := c.Vpc.diff(ctx, cl.networks)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	defer routerClient.Close()
-	_, err = c.Router.update(ctx, routerClient)
-	if err != nil {
-		return err
+	plan = append(plan, *p)
+	network := c.Vpc.Name
+	if n, err := c.Vpc.get(ctx, cl.networks); err == nil {
+		network = n.GetSelfLink()
 	}
-	emoji.Println(":check_mark_button: Controlplane router updated")
 
-	kmsClient, err := kms.NewKeyManagementClient(ctx)
+	c.Subnetwork.Network = network
+	p, err = c.Subnetwork.diff(ctx, cl.subnetworks)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	defer kmsClient.Close()
-	keyring, err := c.Keyring.get(ctx, kmsClient)
+	plan = append(plan, *p)
+
+	c.Router.Network = network
+	p, err = c.Router.diff(ctx, cl.routers)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	c.CryptoKey.Keyring = keyring.GetName()
-	cryptoKey, err := c.CryptoKey.update(ctx, kmsClient)
+	plan = append(plan, *p)
+
+	p, err = c.Keyring.diff(ctx, cl.kms)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	emoji.Println(":check_mark_button: Controlplane KMS Crypto Key updated")
+	plan = append(plan, *p)
 
-	c.Cluster.CryptoKeyName = cryptoKey.GetName()
-	clusterClient, err := container.NewClusterManagerClient(ctx)
+	c.CryptoKey.Keyring = c.Keyring.name()
+	p, err = c.CryptoKey.diff(ctx, cl.kms)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	defer clusterClient.Close()
-	_, err = c.Cluster.update(ctx, clusterClient)
-	if err != nil {
-		return err
+	plan = append(plan, *p)
+
+	if c.ServiceAccount.managed() {
+		p, err = c.ServiceAccount.diff(ctx, cl.iam, cl.projects)
+		if err != nil {
+			return nil, err
+		}
+		plan = append(plan, *p)
 	}
-	emoji.Println(":check_mark_button: Controlplane cluster updated")
 
-	firewallClient, err := compute.NewFirewallsRESTClient(ctx)
+	c.Cluster.CryptoKeyName = fmt.Sprintf("%s/cryptoKeys/%s", c.CryptoKey.Keyring, c.CryptoKey.Name)
+	c.Cluster.ServiceAccount = c.ServiceAccount.email()
+	clusterPlan, err := c.Cluster.diff(ctx, cl.container)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	defer firewallClient.Close()
+	plan = append(plan, clusterPlan...)
+
 	for i := range c.Firewalls {
-		c.Firewalls[i].Network = network.GetSelfLink()
-		_, err = c.Firewalls[i].update(ctx, firewallClient)
+		c.Firewalls[i].Network = network
+		p, err = c.Firewalls[i].diff(ctx, cl.firewalls)
 		if err != nil {
-			return err
+			return nil, err
 		}
+		plan = append(plan, *p)
 	}
-	emoji.Println(":check_mark_button: Controlplane firewall rules updated")
 
-	return nil
+	return plan, nil
 }
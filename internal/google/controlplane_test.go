@@ -0,0 +1,1035 @@
+package google
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+	"tidalwave/internal/google/googletest"
+	"tidalwave/internal/state"
+	"tidalwave/internal/tidalwave"
+	"time"
+
+	computepb "cloud.google.com/go/compute/apiv1/computepb"
+	containerpb "cloud.google.com/go/container/apiv1/containerpb"
+	iampb "cloud.google.com/go/iam/apiv1/iampb"
+	kmspb "cloud.google.com/go/kms/apiv1/kmspb"
+	"golang.org/x/oauth2"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/genproto/googleapis/type/expr"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+// fakes are the in-memory clients behind a test controlplane
+type fakes struct {
+	networks    *googletest.Networks
+	subnetworks *googletest.Subnetworks
+	routers     *googletest.Routers
+	firewalls   *googletest.Firewalls
+	kms         *googletest.KMS
+	container   *googletest.Container
+	iam         *googletest.IAM
+}
+
+func newFakes() *fakes {
+	return &fakes{
+		networks:    googletest.NewNetworks(),
+		subnetworks: googletest.NewSubnetworks(),
+		routers:     googletest.NewRouters(),
+		firewalls:   googletest.NewFirewalls(),
+		kms:         googletest.NewKMS(),
+		container:   googletest.NewContainer(),
+		iam:         googletest.NewIAM(),
+	}
+}
+
+func (f *fakes) clients() *clients {
+	return &clients{
+		networks:    f.networks,
+		subnetworks: f.subnetworks,
+		routers:     f.routers,
+		firewalls:   f.firewalls,
+		kms:         f.kms,
+		container:   f.container,
+		iam:         f.iam,
+		projects:    f.iam,
+	}
+}
+
+func (f *fakes) reset() {
+	for _, r := range f.recorders() {
+		r.Reset()
+	}
+}
+
+func (f *fakes) recorders() map[string]*googletest.Recorder {
+	return map[string]*googletest.Recorder{
+		"networks":    &f.networks.Recorder,
+		"subnetworks": &f.subnetworks.Recorder,
+		"routers":     &f.routers.Recorder,
+		"firewalls":   &f.firewalls.Recorder,
+		"kms":         &f.kms.Recorder,
+		"container":   &f.container.Recorder,
+		"iam":         &f.iam.Recorder,
+	}
+}
+
+// mutations returns every non-read call keyed by client
+func (f *fakes) mutations() map[string][]string {
+	m := map[string][]string{}
+	for name, r := range f.recorders() {
+		if calls := r.Mutations(); len(calls) > 0 {
+			m[name] = calls
+		}
+	}
+	return m
+}
+
+func testControlplane() *Controlplane {
+	return &Controlplane{
+		Parallelism: 1,
+		Vpc:         Vpc{Name: "test", ProjectID: "project"},
+		Subnetwork: Subnetwork{
+			Name:         "test",
+			ProjectID:    "project",
+			Region:       "us-central1",
+			NodesCidr:    "10.0.0.0/24",
+			PodsCidr:     "10.1.0.0/16",
+			ServicesCidr: "10.2.0.0/20",
+		},
+		Router:         Router{Name: "test", ProjectID: "project", Region: "us-central1"},
+		Keyring:        Keyring{Name: "test", ProjectID: "project", Region: "us-central1"},
+		CryptoKey:      CryptoKey{Name: "test", ProjectID: "project", ProjectNumber: "123"},
+		ServiceAccount: ServiceAccount{Name: "test-nodes", ProjectID: "project"},
+		Cluster: Cluster{
+			Name:                "test",
+			ProjectID:           "project",
+			Region:              "us-central1",
+			Network:             "test",
+			Subnetwork:          "test",
+			MasterIpv4CidrBlock: "172.16.0.0/28",
+			NodePools: []NodePool{
+				{Name: DefaultPool, MachineType: "n2-standard-4", MinNodeCount: 1, MaxNodeCount: 3},
+			},
+			MasterAuthCidrBlocks: []*containerpb.MasterAuthorizedNetworksConfig_CidrBlock{
+				{DisplayName: "public", CidrBlock: "0.0.0.0/0"},
+			},
+			Addons:              Addons{HttpLoadBalancing: true, HorizontalPodAutoscaling: true, ConfigConnector: true, GcePersistentDiskCsiDriver: true, GcpFilestoreCsiDriver: true},
+			BinaryAuthorization: true,
+		},
+		Firewalls: []Firewall{
+			{
+				Name:         "test-webhooks",
+				ProjectID:    "project",
+				Allowed:      []*computepb.Allowed{{IPProtocol: StrPtr("tcp"), Ports: []string{"8443"}}},
+				Direction:    "INGRESS",
+				SourceRanges: []string{"172.16.0.0/28"},
+				TargetTags:   []string{"default-pool"},
+			},
+		},
+	}
+}
+
+func apply(t *testing.T, c *Controlplane, f *fakes, update bool) error {
+	t.Helper()
+	g, err := c.graph(f.clients(), update)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return g.Apply(context.Background())
+}
+
+func destroy(t *testing.T, c *Controlplane, f *fakes) error {
+	t.Helper()
+	g, err := c.graph(f.clients(), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return g.Destroy(context.Background())
+}
+
+// assertNoChanges checks a plan of the controlplane shows every resource up to date
+func assertNoChanges(t *testing.T, c *Controlplane, f *fakes) {
+	t.Helper()
+	plan, err := c.plan(context.Background(), f.clients())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range plan {
+		if p.Action != tidalwave.ActionNoop {
+			t.Errorf("%s %s: %s %+v", p.Kind, p.Name, p.Action, p.Changes)
+		}
+	}
+}
+
+func TestCreateIsIdempotent(t *testing.T) {
+	for _, update := range []bool{false, true} {
+		f := newFakes()
+		c := testControlplane()
+		if err := apply(t, c, f, false); err != nil {
+			t.Fatal(err)
+		}
+		assertNoChanges(t, c, f)
+
+		f.reset()
+		if err := apply(t, c, f, update); err != nil {
+			t.Fatal(err)
+		}
+		// the crypto key IAM policy is always reapplied, nothing else should change
+		want := map[string][]string{"kms": {"SetIamPolicy"}}
+		got := f.mutations()
+		if len(got) != len(want) || strings.Join(got["kms"], ",") != "SetIamPolicy" {
+			t.Errorf("update=%t: second apply made calls %v, want %v", update, got, want)
+		}
+	}
+}
+
+func TestUpdateDrift(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		drift  func(f *fakes)
+		client string
+		want   []string
+	}{
+		{
+			name: "subnetwork private access",
+			drift: func(f *fakes) {
+				f.subnetworks.Modify("test", func(s *computepb.Subnetwork) { s.PrivateIpGoogleAccess = BoolPtr(false) })
+			},
+			client: "subnetworks",
+			want:   []string{"SetPrivateIpGoogleAccess"},
+		},
+		{
+			name: "subnetwork secondary ranges",
+			drift: func(f *fakes) {
+				f.subnetworks.Modify("test", func(s *computepb.Subnetwork) { s.SecondaryIpRanges = s.SecondaryIpRanges[:1] })
+			},
+			client: "subnetworks",
+			want:   []string{"Patch"},
+		},
+		{
+			name: "subnetwork expanded",
+			drift: func(f *fakes) {
+				f.subnetworks.Modify("test", func(s *computepb.Subnetwork) { s.IpCidrRange = StrPtr("10.0.0.0/25") })
+			},
+			client: "subnetworks",
+			want:   []string{"ExpandIpCidrRange"},
+		},
+		{
+			name: "router nat removed",
+			drift: func(f *fakes) {
+				f.routers.Modify("test", func(r *computepb.Router) { r.Nats = nil })
+			},
+			client: "routers",
+			want:   []string{"Patch"},
+		},
+		{
+			name: "firewall source ranges",
+			drift: func(f *fakes) {
+				f.firewalls.Modify("test-webhooks", func(r *computepb.Firewall) { r.SourceRanges = []string{"0.0.0.0/0"} })
+			},
+			client: "firewalls",
+			want:   []string{"Patch"},
+		},
+		{
+			name: "firewall source tags added",
+			drift: func(f *fakes) {
+				f.firewalls.Modify("test-webhooks", func(r *computepb.Firewall) { r.SourceTags = []string{"other"} })
+			},
+			client: "firewalls",
+			want:   []string{"Update"},
+		},
+		{
+			name: "cluster binary authorization",
+			drift: func(f *fakes) {
+				f.container.Modify(clusterName(), func(c *containerpb.Cluster) { c.BinaryAuthorization.Enabled = false })
+			},
+			client: "container",
+			want:   []string{"UpdateCluster"},
+		},
+		{
+			name: "cluster shielded nodes and release channel",
+			drift: func(f *fakes) {
+				f.container.Modify(clusterName(), func(c *containerpb.Cluster) {
+					c.ShieldedNodes = nil
+					c.ReleaseChannel.Channel = containerpb.ReleaseChannel_STABLE
+				})
+			},
+			client: "container",
+			want:   []string{"UpdateCluster", "UpdateCluster"},
+		},
+		{
+			name: "cluster logging, monitoring and image streaming",
+			drift: func(f *fakes) {
+				f.container.Modify(clusterName(), func(c *containerpb.Cluster) {
+					c.LoggingConfig = nil
+					c.MonitoringConfig.ComponentConfig.EnableComponents = nil
+					c.NodePoolDefaults.NodeConfigDefaults.GcfsConfig.Enabled = true
+				})
+			},
+			client: "container",
+			want:   []string{"UpdateCluster", "UpdateCluster", "UpdateCluster"},
+		},
+		{
+			name: "cluster cost allocation, gateway api and security posture",
+			drift: func(f *fakes) {
+				f.container.Modify(clusterName(), func(c *containerpb.Cluster) {
+					c.CostManagementConfig = nil
+					c.NetworkConfig.GatewayApiConfig = nil
+					c.SecurityPostureConfig.VulnerabilityMode = containerpb.SecurityPostureConfig_VULNERABILITY_DISABLED.Enum()
+				})
+			},
+			client: "container",
+			want:   []string{"UpdateCluster", "UpdateCluster", "UpdateCluster"},
+		},
+		{
+			name: "cluster maintenance window",
+			drift: func(f *fakes) {
+				f.container.Modify(clusterName(), func(c *containerpb.Cluster) {
+					c.MaintenancePolicy.Window.GetDailyMaintenanceWindow().StartTime = "12:00"
+				})
+			},
+			client: "container",
+			want:   []string{"SetMaintenancePolicy"},
+		},
+		{
+			name: "node pool autoscaling",
+			drift: func(f *fakes) {
+				f.container.Modify(clusterName(), func(c *containerpb.Cluster) { c.NodePools[0].Autoscaling.MaxNodeCount = 10 })
+			},
+			client: "container",
+			want:   []string{"SetNodePoolAutoscaling"},
+		},
+		{
+			name: "node pool tags",
+			drift: func(f *fakes) {
+				f.container.Modify(clusterName(), func(c *containerpb.Cluster) { c.NodePools[0].Config.Tags = nil })
+			},
+			client: "container",
+			want:   []string{"UpdateNodePool"},
+		},
+		{
+			name: "node pool deleted",
+			drift: func(f *fakes) {
+				f.container.Modify(clusterName(), func(c *containerpb.Cluster) { c.NodePools = nil })
+			},
+			client: "container",
+			want:   []string{"CreateNodePool"},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			f := newFakes()
+			c := testControlplane()
+			c.Cluster.LoggingComponents = []containerpb.LoggingComponentConfig_Component{containerpb.LoggingComponentConfig_SYSTEM_COMPONENTS}
+			c.Cluster.MonitoringComponents = []containerpb.MonitoringComponentConfig_Component{containerpb.MonitoringComponentConfig_SYSTEM_COMPONENTS}
+			c.Cluster.CostAllocation = true
+			c.Cluster.GatewayAPI = containerpb.GatewayAPIConfig_CHANNEL_STANDARD
+			c.Cluster.SecurityPosture = containerpb.SecurityPostureConfig_BASIC
+			c.Cluster.VulnerabilityScanning = containerpb.SecurityPostureConfig_VULNERABILITY_BASIC
+			if err := apply(t, c, f, false); err != nil {
+				t.Fatal(err)
+			}
+			tc.drift(f)
+			f.reset()
+			if err := apply(t, c, f, true); err != nil {
+				t.Fatal(err)
+			}
+			got := f.mutations()
+			if strings.Join(got[tc.client], ",") != strings.Join(tc.want, ",") {
+				t.Errorf("%s calls %v, want %v", tc.client, got[tc.client], tc.want)
+			}
+			for client, calls := range got {
+				if client != tc.client && client != "kms" {
+					t.Errorf("unexpected %s calls %v", client, calls)
+				}
+			}
+			assertNoChanges(t, c, f)
+		})
+	}
+}
+
+func TestNodePools(t *testing.T) {
+	f := newFakes()
+	c := testControlplane()
+	if err := apply(t, c, f, false); err != nil {
+		t.Fatal(err)
+	}
+	c.Cluster.NodePools = append(c.Cluster.NodePools, NodePool{
+		Name:         "ci",
+		MachineType:  "n2-standard-8",
+		MaxNodeCount: 5,
+		Spot:         true,
+		Labels:       map[string]string{"workload": "ci"},
+		Taints:       []*containerpb.NodeTaint{{Key: "dedicated", Value: "ci", Effect: containerpb.NodeTaint_NO_SCHEDULE}},
+	})
+	f.reset()
+	if err := apply(t, c, f, true); err != nil {
+		t.Fatal(err)
+	}
+	if got := f.mutations()["container"]; strings.Join(got, ",") != "CreateNodePool" {
+		t.Errorf("adding a pool made container calls %v", got)
+	}
+	assertNoChanges(t, c, f)
+
+	f.container.Modify(clusterName(), func(cluster *containerpb.Cluster) { cluster.NodePools[1].Config.Labels = nil })
+	f.reset()
+	if err := apply(t, c, f, true); err != nil {
+		t.Fatal(err)
+	}
+	if got := f.mutations()["container"]; strings.Join(got, ",") != "UpdateNodePool" {
+		t.Errorf("relabelling a pool made container calls %v", got)
+	}
+
+	c.Cluster.NodePools = c.Cluster.NodePools[:1]
+	plan, err := c.plan(context.Background(), f.clients())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p := plan[len(plan)-len(c.Firewalls)-1]; p.Name != "test/ci" || p.Action != tidalwave.ActionDelete {
+		t.Errorf("plan for the removed pool = %+v", p)
+	}
+	f.reset()
+	if err := apply(t, c, f, true); err != nil {
+		t.Fatal(err)
+	}
+	if got := f.mutations()["container"]; strings.Join(got, ",") != "DeleteNodePool" {
+		t.Errorf("removing a pool made container calls %v", got)
+	}
+	assertNoChanges(t, c, f)
+}
+
+func TestServiceAccount(t *testing.T) {
+	f := newFakes()
+	c := testControlplane()
+	if err := apply(t, c, f, false); err != nil {
+		t.Fatal(err)
+	}
+	email := "test-nodes@project.iam.gserviceaccount.com"
+	if got := f.mutations()["iam"]; strings.Join(got, ",") != "CreateServiceAccount,SetIamPolicy" {
+		t.Errorf("create made iam calls %v", got)
+	}
+	p, _ := f.iam.GetIamPolicy(context.Background(), &iampb.GetIamPolicyRequest{Resource: "projects/project"})
+	if got := c.ServiceAccount.roles(p); got != list(NodeRoles) {
+		t.Errorf("node service account has roles %s, want %s", got, list(NodeRoles))
+	}
+	cluster, _ := f.container.GetCluster(context.Background(), &containerpb.GetClusterRequest{Name: clusterName()})
+	if got := cluster.GetNodePools()[0].GetConfig().GetServiceAccount(); got != email {
+		t.Errorf("default pool runs as %q, want %q", got, email)
+	}
+
+	if err := destroy(t, c, f); err != nil {
+		t.Fatal(err)
+	}
+	if c.ServiceAccount.exists(context.Background(), f.iam) {
+		t.Error("node service account not deleted")
+	}
+	p, _ = f.iam.GetIamPolicy(context.Background(), &iampb.GetIamPolicyRequest{Resource: "projects/project"})
+	if got := c.ServiceAccount.roles(p); got != "" {
+		t.Errorf("deleted node service account still has roles %s", got)
+	}
+
+	// an existing service account is used as is
+	f = newFakes()
+	c = testControlplane()
+	c.ServiceAccount.Email = "nodes@other.iam.gserviceaccount.com"
+	if err := apply(t, c, f, false); err != nil {
+		t.Fatal(err)
+	}
+	if got := f.mutations()["iam"]; len(got) != 0 {
+		t.Errorf("create with an existing service account made iam calls %v", got)
+	}
+	cluster, _ = f.container.GetCluster(context.Background(), &containerpb.GetClusterRequest{Name: clusterName()})
+	if got := cluster.GetNodePools()[0].GetConfig().GetServiceAccount(); got != c.ServiceAccount.Email {
+		t.Errorf("default pool runs as %q, want %q", got, c.ServiceAccount.Email)
+	}
+	assertNoChanges(t, c, f)
+}
+
+// TestServiceAccountExistingPools checks pools created before the node service account keep
+// running as the Compute Engine default instead of failing update, explicit accounts still
+// cannot change in place
+func TestServiceAccountExistingPools(t *testing.T) {
+	f := newFakes()
+	c := testControlplane()
+	if err := apply(t, c, f, false); err != nil {
+		t.Fatal(err)
+	}
+	f.container.Modify(clusterName(), func(cl *containerpb.Cluster) {
+		cl.GetNodePools()[0].GetConfig().ServiceAccount = "default"
+	})
+
+	// a pool still on the Compute Engine default service account is drift that needs a new pool
+	plan, err := c.plan(context.Background(), f.clients())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range plan {
+		if p.Kind == "nodepool" && p.Action != tidalwave.ActionReplace {
+			t.Errorf("node pool %s on the Compute Engine default is planned as %s, want %s", p.Name, p.Action, tidalwave.ActionReplace)
+		}
+	}
+	f.reset()
+	if err := apply(t, c, f, true); err == nil || !strings.Contains(err.Error(), "config.serviceAccount") {
+		t.Errorf("got %v, want the pool on the Compute Engine default to need recreating", err)
+	}
+	if got := f.mutations()["container"]; len(got) != 0 {
+		t.Errorf("update made container calls %v", got)
+	}
+}
+
+func TestServiceAccountIamConflict(t *testing.T) {
+	defer func(d time.Duration) { iamRetryDelay = d }(iamRetryDelay)
+	iamRetryDelay = time.Millisecond
+	f := newFakes()
+	c := testControlplane()
+	f.iam.FailTimes("SetIamPolicy", status.Error(codes.Aborted, "etag does not match"), 2)
+	if err := apply(t, c, f, false); err != nil {
+		t.Fatal(err)
+	}
+	if got := f.mutations()["iam"]; strings.Join(got, ",") != "CreateServiceAccount,SetIamPolicy,SetIamPolicy,SetIamPolicy" {
+		t.Errorf("create made iam calls %v, want the policy write retried twice", got)
+	}
+	p, _ := f.iam.GetIamPolicy(context.Background(), &iampb.GetIamPolicyRequest{Resource: "projects/project"})
+	if got := c.ServiceAccount.roles(p); got != list(NodeRoles) {
+		t.Errorf("node service account has roles %s, want %s", got, list(NodeRoles))
+	}
+
+	f.iam.Fail("SetIamPolicy", status.Error(codes.PermissionDenied, "denied"))
+	f.reset()
+	if err := destroy(t, c, f); err == nil || !strings.Contains(err.Error(), "PermissionDenied") {
+		t.Errorf("got %v, want PermissionDenied", err)
+	}
+	if got := f.mutations()["iam"]; len(got) != 1 {
+		t.Errorf("delete made iam calls %v, other errors are not retried", got)
+	}
+}
+
+func TestServiceAccountIamConditions(t *testing.T) {
+	defer func(d time.Duration) { iamRetryDelay = d }(iamRetryDelay)
+	iamRetryDelay = time.Millisecond
+	ctx := context.Background()
+	f := newFakes()
+	c := testControlplane()
+	conditional := []*iampb.Binding{
+		{
+			Role:      "roles/logging.logWriter",
+			Members:   []string{c.ServiceAccount.member()},
+			Condition: &expr.Expr{Title: "weekdays", Expression: "request.time.getDayOfWeek() < 5"},
+		},
+		{
+			Role:      "roles/owner",
+			Members:   []string{"user:oncall@example.com"},
+			Condition: &expr.Expr{Title: "expires", Expression: `request.time < timestamp("2030-01-01T00:00:00Z")`},
+		},
+	}
+	if _, err := f.iam.SetIamPolicy(ctx, &iampb.SetIamPolicyRequest{
+		Resource: "projects/project",
+		Policy:   &iampb.Policy{Version: 3, Bindings: conditional},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	// a service account that was just created is not a valid member straight away
+	f.iam.FailTimes("SetIamPolicy", status.Error(codes.InvalidArgument, "Service account test-nodes@project.iam.gserviceaccount.com does not exist."), 1)
+	f.reset()
+	if err := apply(t, c, f, false); err != nil {
+		t.Fatal(err)
+	}
+	if got := f.mutations()["iam"]; strings.Join(got, ",") != "CreateServiceAccount,SetIamPolicy,SetIamPolicy" {
+		t.Errorf("create made iam calls %v, want the policy write retried once", got)
+	}
+
+	kept := func(when string) {
+		t.Helper()
+		p, _ := f.iam.GetIamPolicy(ctx, &iampb.GetIamPolicyRequest{
+			Resource: "projects/project",
+			Options:  &iampb.GetPolicyOptions{RequestedPolicyVersion: 3},
+		})
+		for _, want := range conditional {
+			found := false
+			for _, b := range p.GetBindings() {
+				found = found || proto.Equal(b, want)
+			}
+			if !found {
+				t.Errorf("conditional binding of %s changed %s: %v", want.GetRole(), when, p.GetBindings())
+			}
+		}
+	}
+	kept("on create")
+	assertNoChanges(t, c, f)
+
+	if err := destroy(t, c, f); err != nil {
+		t.Fatal(err)
+	}
+	kept("on delete")
+}
+
+func TestAutopilot(t *testing.T) {
+	f := newFakes()
+	c := testControlplane()
+	c.Cluster.Autopilot = true
+	c.Firewalls[0].TargetTags = nil
+	if err := apply(t, c, f, false); err != nil {
+		t.Fatal(err)
+	}
+	cluster, _ := f.container.GetCluster(context.Background(), &containerpb.GetClusterRequest{Name: clusterName()})
+	if !cluster.GetAutopilot().GetEnabled() || len(cluster.GetNodePools()) != 0 {
+		t.Errorf("got autopilot %t with %d node pools", cluster.GetAutopilot().GetEnabled(), len(cluster.GetNodePools()))
+	}
+	if got := cluster.GetAutoscaling().GetAutoprovisioningNodePoolDefaults().GetServiceAccount(); got != c.ServiceAccount.email() {
+		t.Errorf("autopilot nodes run as %q, want %q", got, c.ServiceAccount.email())
+	}
+	if got := cluster.GetDatabaseEncryption().GetKeyName(); got == "" {
+		t.Error("autopilot cluster is not encrypted with the crypto key")
+	}
+	assertNoChanges(t, c, f)
+
+	// node pools GKE adds to an autopilot cluster are left alone
+	f.container.Modify(clusterName(), func(cluster *containerpb.Cluster) {
+		cluster.NodePools = append(cluster.NodePools, &containerpb.NodePool{Name: "nap-e2-standard-2"})
+	})
+	f.reset()
+	if err := apply(t, c, f, true); err != nil {
+		t.Fatal(err)
+	}
+	if got := f.mutations()["container"]; len(got) != 0 {
+		t.Errorf("update made container calls %v", got)
+	}
+	status, err := c.status(context.Background(), f.clients())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range status {
+		if s.Kind == "nodepool" {
+			t.Errorf("status reports node pool %s of an autopilot cluster", s.Name)
+		}
+		if s.Kind == "cluster" && s.Attributes["mode"] != "autopilot" {
+			t.Errorf("got %+v", s)
+		}
+	}
+
+	c.Cluster.Autopilot = false
+	err = apply(t, c, f, true)
+	if err == nil || !strings.Contains(err.Error(), "deleted and recreated") {
+		t.Fatalf("got %v, want a replacement error for switching to standard", err)
+	}
+
+	c.Cluster.Autopilot = true
+	f.reset()
+	if err := destroy(t, c, f); err != nil {
+		t.Fatal(err)
+	}
+	if got := f.mutations()["container"]; strings.Join(got, ",") != "DeleteCluster" {
+		t.Errorf("delete made container calls %v", got)
+	}
+}
+
+func TestMaintenancePolicy(t *testing.T) {
+	f := newFakes()
+	c := testControlplane()
+	start := time.Date(2023, 1, 7, 6, 0, 0, 0, time.UTC)
+	c.Cluster.MaintenancePolicy = RecurringMaintenanceWindow(start, start.Add(8*time.Hour), "FREQ=WEEKLY;BYDAY=SA,SU")
+	c.Cluster.MaintenancePolicy.Window.MaintenanceExclusions = map[string]*containerpb.TimeWindow{
+		"freeze": MaintenanceExclusion(time.Date(2023, 12, 20, 0, 0, 0, 0, time.UTC), time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC), containerpb.MaintenanceExclusionOptions_NO_MINOR_UPGRADES),
+	}
+	if err := apply(t, c, f, false); err != nil {
+		t.Fatal(err)
+	}
+	assertNoChanges(t, c, f)
+
+	delete(c.Cluster.MaintenancePolicy.Window.MaintenanceExclusions, "freeze")
+	f.reset()
+	if err := apply(t, c, f, true); err != nil {
+		t.Fatal(err)
+	}
+	if got := f.mutations()["container"]; strings.Join(got, ",") != "SetMaintenancePolicy" {
+		t.Errorf("removing an exclusion made container calls %v", got)
+	}
+	assertNoChanges(t, c, f)
+}
+
+func TestUpgrade(t *testing.T) {
+	f := newFakes()
+	c := testControlplane()
+	c.Cluster.Version = "1.24"
+	c.Cluster.NodePools = append(c.Cluster.NodePools, NodePool{Name: "ci", MachineType: "n2-standard-8", MaxNodeCount: 5, MaxSurge: 2})
+	if err := apply(t, c, f, false); err != nil {
+		t.Fatal(err)
+	}
+	assertNoChanges(t, c, f)
+	ctx := context.Background()
+	container := f.clients().container
+
+	// update leaves the version to upgrade
+	c.Cluster.Version = "1.25"
+	f.reset()
+	if err := apply(t, c, f, true); err != nil {
+		t.Fatal(err)
+	}
+	if got := f.mutations()["container"]; len(got) != 0 {
+		t.Errorf("update of a pinned version made container calls %v", got)
+	}
+
+	// a pinned cluster leaves its release channel and its nodes do not auto-upgrade
+	cluster, _ := f.container.GetCluster(ctx, &containerpb.GetClusterRequest{Name: clusterName()})
+	if ch := cluster.GetReleaseChannel().GetChannel(); ch != containerpb.ReleaseChannel_UNSPECIFIED {
+		t.Errorf("pinned cluster is on the %s channel", ch)
+	}
+	for _, p := range cluster.GetNodePools() {
+		if p.GetManagement().GetAutoUpgrade() {
+			t.Errorf("node pool %s of a pinned cluster auto-upgrades", p.GetName())
+		}
+	}
+
+	if _, err := c.Cluster.upgrade(ctx, container, "1.22", true, false, 0); err == nil || !strings.Contains(err.Error(), "not available") {
+		t.Errorf("got %v, want 1.22 not to be available for the master", err)
+	}
+	// the nodes follow the master so versions only the master can run are skipped
+	v := &tidalwave.Versions{ValidMasterVersions: []string{"1.26.1-gke.100", "1.25.2-gke.1700"}, ValidNodeVersions: []string{"1.25.2-gke.1700"}}
+	for version, want := range map[string]string{"latest": "1.25.2-gke.1700", "1.25": "1.25.2-gke.1700"} {
+		if got, err := resolveVersion(v, version); err != nil || got != want {
+			t.Errorf("%s resolved to %q, %v, want %s", version, got, err, want)
+		}
+	}
+	if _, err := resolveVersion(v, "1.26"); err == nil || !strings.Contains(err.Error(), "not a valid node version") {
+		t.Errorf("got %v, want 1.26 to be rejected as a node version", err)
+	}
+	steps, err := c.Cluster.upgrade(ctx, container, "1.25", true, false, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []tidalwave.UpgradeStep{
+		{Kind: "master", Name: "test", From: "1.24.5-gke.600", To: "1.25.2-gke.1700"},
+		{Kind: "nodepool", Name: DefaultPool, From: "1.24.5-gke.600", To: "1.25.2-gke.1700"},
+		{Kind: "nodepool", Name: "ci", From: "1.24.5-gke.600", To: "1.25.2-gke.1700"},
+	}
+	if len(steps) != len(want) {
+		t.Fatalf("dry run steps %+v, want %+v", steps, want)
+	}
+	for i := range want {
+		if steps[i] != want[i] {
+			t.Errorf("step %d is %+v, want %+v", i, steps[i], want[i])
+		}
+	}
+	if got := f.mutations()["container"]; len(got) != 0 {
+		t.Errorf("dry run made container calls %v", got)
+	}
+
+	// a failed node pool stops the upgrade after the master, running it again carries on
+	f.container.Fail("UpdateNodePool", errors.New("quota exceeded"))
+	steps, err = c.Cluster.upgrade(ctx, container, "1.25", false, false, 0)
+	if err == nil || !strings.Contains(err.Error(), "1 of 3 steps done") || len(steps) != 1 {
+		t.Fatalf("got %v after %+v, want the upgrade to stop after the master", err, steps)
+	}
+	f.container.Fail("UpdateNodePool", nil)
+
+	// a paused upgrade stops after the next step
+	f.reset()
+	steps, err = c.Cluster.upgrade(ctx, container, "1.25", false, true, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(steps) != 1 || steps[0].Name != DefaultPool {
+		t.Errorf("paused run upgraded %+v, want only %s", steps, DefaultPool)
+	}
+	f.reset()
+	steps, err = c.Cluster.upgrade(ctx, container, "1.25", false, false, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(steps) != 1 || steps[0].Name != "ci" {
+		t.Errorf("resumed run upgraded %+v, want the remaining node pool", steps)
+	}
+	if got := f.mutations()["container"]; strings.Join(got, ",") != "UpdateNodePool" {
+		t.Errorf("resumed run made container calls %v", got)
+	}
+	cluster, _ = f.container.GetCluster(ctx, &containerpb.GetClusterRequest{Name: clusterName()})
+	for _, p := range cluster.GetNodePools() {
+		if p.GetVersion() != "1.25.2-gke.1700" {
+			t.Errorf("node pool %s runs %s", p.GetName(), p.GetVersion())
+		}
+	}
+	if got := findNodePool(cluster, "ci").GetUpgradeSettings(); got.GetMaxSurge() != 2 || got.GetMaxUnavailable() != 0 {
+		t.Errorf("ci upgrade settings %v, want maxSurge 2", got)
+	}
+	assertNoChanges(t, c, f)
+
+	if steps, err := c.Cluster.upgrade(ctx, container, "1.25", false, false, 0); err != nil || len(steps) != 0 {
+		t.Errorf("upgrading again ran %+v, %v", steps, err)
+	}
+	f.container.Modify(clusterName(), func(cluster *containerpb.Cluster) {
+		cluster.ReleaseChannel.Channel = containerpb.ReleaseChannel_REGULAR
+	})
+	if _, err := c.Cluster.upgrade(ctx, container, "1.24", true, false, 0); err == nil || !strings.Contains(err.Error(), "cannot downgrade") {
+		t.Errorf("got %v, want a downgrade error", err)
+	}
+
+	// a step that is done by the time its deadline passes did not time out
+	step := tidalwave.UpgradeStep{Kind: "nodepool", Name: "ci", From: "1.25.2-gke.1700", To: "1.25.2-gke.1700"}
+	if err := c.Cluster.upgradeStep(ctx, container, step, time.Nanosecond); err != nil {
+		t.Errorf("got %v, want the step to be done", err)
+	}
+}
+
+func TestPinVersion(t *testing.T) {
+	f := newFakes()
+	c := testControlplane()
+	if err := apply(t, c, f, false); err != nil {
+		t.Fatal(err)
+	}
+
+	// pinning a cluster takes it off its release channel before node auto-upgrade is turned off
+	c.Cluster.Version = "1.24"
+	f.reset()
+	if err := apply(t, c, f, true); err != nil {
+		t.Fatal(err)
+	}
+	if got := f.mutations()["container"]; strings.Join(got, ",") != "UpdateCluster,SetNodePoolManagement" {
+		t.Errorf("pinning made container calls %v", got)
+	}
+	assertNoChanges(t, c, f)
+}
+
+func clusterName() string {
+	return "projects/project/locations/us-central1/clusters/test"
+}
+
+func TestUpdateForceNew(t *testing.T) {
+	f := newFakes()
+	c := testControlplane()
+	if err := apply(t, c, f, false); err != nil {
+		t.Fatal(err)
+	}
+	c.Cluster.MasterIpv4CidrBlock = "172.16.1.0/28"
+	f.reset()
+	err := apply(t, c, f, true)
+	if err == nil || !strings.Contains(err.Error(), "deleted and recreated") {
+		t.Fatalf("got %v, want a replacement error", err)
+	}
+	if calls := f.mutations()["container"]; len(calls) != 0 {
+		t.Errorf("container calls %v after a force new change", calls)
+	}
+}
+
+func TestDelete(t *testing.T) {
+	f := newFakes()
+	c := testControlplane()
+	if err := apply(t, c, f, false); err != nil {
+		t.Fatal(err)
+	}
+	for i, want := range []map[string][]string{
+		{
+			"networks":    {"Delete"},
+			"subnetworks": {"Delete"},
+			"routers":     {"Delete"},
+			"firewalls":   {"Delete"},
+			"kms":         {"SetIamPolicy"},
+			"container":   {"DeleteCluster"},
+			"iam":         {"SetIamPolicy", "DeleteServiceAccount"},
+		},
+		// the second delete finds nothing left, the crypto key cannot be deleted
+		{
+			"kms": {"SetIamPolicy"},
+		},
+	} {
+		f.reset()
+		if err := destroy(t, c, f); err != nil {
+			t.Fatal(err)
+		}
+		got := f.mutations()
+		if len(got) != len(want) {
+			t.Errorf("delete %d made calls %v, want %v", i, got, want)
+		}
+		for client, calls := range want {
+			if strings.Join(got[client], ",") != strings.Join(calls, ",") {
+				t.Errorf("delete %d: %s calls %v, want %v", i, client, got[client], calls)
+			}
+		}
+	}
+	member := "serviceAccount:service-123@container-engine-robot.iam.gserviceaccount.com"
+	for _, b := range f.kms.Policy("projects/project/locations/us-central1/keyRings/test/cryptoKeys/test").GetBindings() {
+		for _, m := range b.GetMembers() {
+			if m == member {
+				t.Errorf("%s still has %s", member, b.GetRole())
+			}
+		}
+	}
+}
+
+// TestDeleteUntracked checks resources tidalwave did not create are left alone, the crypto
+// key keeps the GKE service agent's access and a crypto key it created stays in state
+func TestDeleteUntracked(t *testing.T) {
+	ctx := context.Background()
+	f := newFakes()
+	c := testControlplane()
+	if err := apply(t, c, f, false); err != nil {
+		t.Fatal(err)
+	}
+	s, err := state.Open(ctx, &state.LocalBackend{Path: filepath.Join(t.TempDir(), "state.json")}, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close(ctx)
+	c.State = s
+	f.reset()
+	if err := destroy(t, c, f); err != nil {
+		t.Fatal(err)
+	}
+	if got := f.mutations(); len(got) != 0 {
+		t.Errorf("delete of untracked resources made calls %v", got)
+	}
+
+	if err := apply(t, testControlplane(), f, false); err != nil {
+		t.Fatal(err)
+	}
+	key, err := f.kms.GetCryptoKey(ctx, &kmspb.GetCryptoKeyRequest{Name: "projects/project/locations/us-central1/keyRings/test/cryptoKeys/test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Put(ctx, cryptoKeyResource(key, &c.CryptoKey)); err != nil {
+		t.Fatal(err)
+	}
+	f.reset()
+	if err := destroy(t, c, f); err != nil {
+		t.Fatal(err)
+	}
+	if got := f.mutations()["kms"]; strings.Join(got, ",") != "SetIamPolicy" {
+		t.Errorf("kms calls %v, want SetIamPolicy", got)
+	}
+	if _, ok := s.Get("cryptokey", "test"); !ok {
+		t.Error("crypto key removed from state, it cannot be deleted")
+	}
+}
+
+func TestApplyErrors(t *testing.T) {
+	quota := &googleapi.Error{Code: 403, Message: "quota exceeded"}
+	for _, tc := range []struct {
+		name   string
+		fail   func(f *fakes)
+		node   string
+		update bool
+	}{
+		{
+			name: "vpc insert",
+			fail: func(f *fakes) { f.networks.Fail("Insert", quota) },
+			node: "vpc",
+		},
+		{
+			name: "subnetwork insert",
+			fail: func(f *fakes) { f.subnetworks.Fail("Insert", quota) },
+			node: "subnetwork",
+		},
+		{
+			name: "crypto key iam",
+			fail: func(f *fakes) { f.kms.Fail("SetIamPolicy", quota) },
+			node: "cryptokey",
+		},
+		{
+			name: "cluster create",
+			fail: func(f *fakes) { f.container.Fail("CreateCluster", quota) },
+			node: "cluster",
+		},
+		{
+			name:   "subnetwork get during update",
+			fail:   func(f *fakes) { f.subnetworks.Fail("Get", quota) },
+			node:   "subnetwork",
+			update: true,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			f := newFakes()
+			c := testControlplane()
+			tc.fail(f)
+			err := apply(t, c, f, tc.update)
+			if !errors.Is(err, quota) {
+				t.Fatalf("got %v, want %v", err, quota)
+			}
+			if !strings.HasPrefix(err.Error(), tc.node+":") {
+				t.Errorf("error %q does not name node %s", err, tc.node)
+			}
+			if tc.node != "cluster" {
+				if calls := f.container.Mutations(); len(calls) != 0 {
+					t.Errorf("cluster was created after %s failed: %v", tc.node, calls)
+				}
+			}
+		})
+	}
+}
+
+func TestStatus(t *testing.T) {
+	f := newFakes()
+	c := testControlplane()
+	if err := apply(t, c, f, false); err != nil {
+		t.Fatal(err)
+	}
+	f.firewalls.Modify("test-webhooks", func(fw *computepb.Firewall) { fw.SourceRanges = []string{"0.0.0.0/0"} })
+	f.reset()
+	status, err := c.status(context.Background(), f.clients())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// once for the plan and once for the attributes of the cluster and all of its node pools
+	gets := 0
+	for _, call := range f.container.Calls() {
+		if call == "GetCluster" {
+			gets++
+		}
+	}
+	if gets != 2 {
+		t.Errorf("status got the cluster %d times, want 2", gets)
+	}
+	got := map[string]tidalwave.ResourceStatus{}
+	for _, s := range status {
+		got[s.Kind+"/"+s.Name] = s
+		if !s.Exists {
+			t.Errorf("%s %s does not exist", s.Kind, s.Name)
+		}
+	}
+	if s := got["firewall/test-webhooks"]; s.InSync || s.Attributes["sourceRanges"] != "0.0.0.0/0" {
+		t.Errorf("got %+v, want the drifted firewall out of sync", s)
+	}
+	if s := got["subnetwork/test"]; !s.InSync || !strings.Contains(s.Attributes["secondaryIpRanges"], "pods=") {
+		t.Errorf("got %+v", s)
+	}
+	if s := got["cryptokey/test"]; s.Attributes["primaryState"] != "ENABLED" {
+		t.Errorf("got %+v", s)
+	}
+	if s := got["nodepool/test/default-pool"]; s.Attributes["autoscaling"] != "1-3" || s.Attributes["initialNodeCount"] != "" {
+		t.Errorf("got %+v", s)
+	}
+	if s := got["serviceaccount/test-nodes"]; !s.InSync || s.Attributes["roles"] != list(NodeRoles) {
+		t.Errorf("got %+v", s)
+	}
+}
+
+func TestCredentials(t *testing.T) {
+	f := newFakes()
+	c := testControlplane()
+	if err := apply(t, c, f, false); err != nil {
+		t.Fatal(err)
+	}
+	tokenSource = func(ctx context.Context) (oauth2.TokenSource, error) {
+		return oauth2.StaticTokenSource(&oauth2.Token{AccessToken: "short-lived"}), nil
+	}
+	defer func() { tokenSource = defaultTokenSource }()
+
+	creds, err := c.credentials(context.Background(), f.clients(), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if creds.Endpoint == "" || creds.PrivateEndpoint == "" || creds.CertificateAuthority == "" {
+		t.Errorf("got %+v", creds)
+	}
+	if creds.Exec.Command != "gke-gcloud-auth-plugin" || creds.Token != "" {
+		t.Errorf("got %+v, want the credential plugin and no token", creds)
+	}
+	creds, err = c.credentials(context.Background(), f.clients(), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if creds.Token != "short-lived" {
+		t.Errorf("token = %q", creds.Token)
+	}
+}
@@ -0,0 +1,67 @@
+package google
+
+import (
+	"context"
+	"fmt"
+	"tidalwave/internal/tidalwave"
+
+	"golang.org/x/oauth2"
+	googleoauth "golang.org/x/oauth2/google"
+)
+
+// cloudPlatformScope is the OAuth scope GKE accepts tokens for
+const cloudPlatformScope = "https://www.googleapis.com/auth/cloud-platform"
+
+// defaultTokenSource returns the ambient Google credentials
+func defaultTokenSource(ctx context.Context) (oauth2.TokenSource, error) {
+	return googleoauth.DefaultTokenSource(ctx, cloudPlatformScope)
+}
+
+// tokenSource is where tokens come from, tests replace it
+var tokenSource = defaultTokenSource
+
+// Credentials returns the endpoints and CA certificate of the GKE cluster with
+// gke-gcloud-auth-plugin as the credential plugin
+func (c *Controlplane) Credentials(ctx context.Context, token bool) (*tidalwave.ClusterCredentials, error) {
+	cl, err := newClients(ctx, c.Endpoints)
+	if err != nil {
+		return nil, err
+	}
+	defer cl.Close()
+	return c.credentials(ctx, cl, token)
+}
+
+// credentials returns the credentials of the cluster using cl
+func (c *Controlplane) credentials(ctx context.Context, cl *clients, token bool) (*tidalwave.ClusterCredentials, error) {
+	cluster, err := c.Cluster.get(ctx, cl.container)
+	if err != nil {
+		return nil, fmt.Errorf("cluster %s: %w", c.Cluster.Name, err)
+	}
+	private := cluster.GetPrivateClusterConfig()
+	creds := &tidalwave.ClusterCredentials{
+		PrivateEndpoint:      private.GetPrivateEndpoint(),
+		CertificateAuthority: cluster.GetMasterAuth().GetClusterCaCertificate(),
+		Exec: &tidalwave.ExecCredential{
+			APIVersion:  "client.authentication.k8s.io/v1beta1",
+			Command:     "gke-gcloud-auth-plugin",
+			InstallHint: "Install gke-gcloud-auth-plugin with: gcloud components install gke-gcloud-auth-plugin",
+		},
+	}
+	// with the private endpoint enabled the endpoint of the cluster is the private one
+	if !private.GetEnablePrivateEndpoint() {
+		creds.Endpoint = cluster.GetEndpoint()
+	}
+	if !token {
+		return creds, nil
+	}
+	ts, err := tokenSource(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("google credentials: %w", err)
+	}
+	t, err := ts.Token()
+	if err != nil {
+		return nil, fmt.Errorf("google credentials: %w", err)
+	}
+	creds.Token = t.AccessToken
+	return creds, nil
+}
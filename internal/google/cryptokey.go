@@ -4,9 +4,12 @@ import (
 	"context"
 	"fmt"
 	"log"
+	"tidalwave/internal/google/gcp"
+	"tidalwave/internal/tidalwave"
 
-	kms "cloud.google.com/go/kms/apiv1"
-	kmspb "google.golang.org/genproto/googleapis/cloud/kms/v1"
+	"cloud.google.com/go/iam"
+	iampb "cloud.google.com/go/iam/apiv1/iampb"
+	kmspb "cloud.google.com/go/kms/apiv1/kmspb"
 	"google.golang.org/protobuf/types/known/fieldmaskpb"
 )
 
@@ -18,32 +21,41 @@ type CryptoKey struct {
 	ProjectNumber string
 }
 
-func setIam(ctx context.Context, client *kms.KeyManagementClient, id string, key *kmspb.CryptoKey) error {
+func setIam(ctx context.Context, client gcp.KMSClient, id string, key *kmspb.CryptoKey) error {
 	member := fmt.Sprintf("serviceAccount:service-%s@container-engine-robot.iam.gserviceaccount.com", id)
-	handle := client.ResourceIAM(key.GetName())
-	policy, err := handle.Policy(ctx)
-	if err != nil {
-		return err
-	}
-	policy.Add(member, "roles/cloudkms.cryptoKeyDecrypter")
-	policy.Add(member, "roles/cloudkms.cryptoKeyEncrypter")
-	return handle.SetPolicy(ctx, policy)
+	return editIam(ctx, client, key, func(policy *iam.Policy) {
+		policy.Add(member, "roles/cloudkms.cryptoKeyDecrypter")
+		policy.Add(member, "roles/cloudkms.cryptoKeyEncrypter")
+	})
 }
 
-func removeIam(ctx context.Context, client *kms.KeyManagementClient, id string, key *kmspb.CryptoKey) error {
+func removeIam(ctx context.Context, client gcp.KMSClient, id string, key *kmspb.CryptoKey) error {
 	member := fmt.Sprintf("serviceAccount:service-%s@container-engine-robot.iam.gserviceaccount.com", id)
-	handle := client.ResourceIAM(key.GetName())
-	policy, err := handle.Policy(ctx)
+	return editIam(ctx, client, key, func(policy *iam.Policy) {
+		policy.Remove(member, "roles/cloudkms.cryptoKeyDecrypter")
+		policy.Remove(member, "roles/cloudkms.cryptoKeyEncrypter")
+	})
+}
+
+// editIam reads the IAM policy of a crypto key, applies edit and writes it back
+func editIam(ctx context.Context, client gcp.KMSClient, key *kmspb.CryptoKey, edit func(*iam.Policy)) error {
+	p, err := client.GetIamPolicy(ctx, &iampb.GetIamPolicyRequest{
+		Resource: key.GetName(),
+	})
 	if err != nil {
 		return err
 	}
-	policy.Remove(member, "roles/cloudkms.cryptoKeyDecrypter")
-	policy.Remove(member, "roles/cloudkms.cryptoKeyEncrypter")
-	return handle.SetPolicy(ctx, policy)
+	policy := &iam.Policy{InternalProto: p}
+	edit(policy)
+	_, err = client.SetIamPolicy(ctx, &iampb.SetIamPolicyRequest{
+		Resource: key.GetName(),
+		Policy:   policy.InternalProto,
+	})
+	return err
 }
 
 // Create KMS Crypto Key
-func (c *CryptoKey) create(ctx context.Context, client *kms.KeyManagementClient) (*kmspb.CryptoKey, error) {
+func (c *CryptoKey) create(ctx context.Context, client gcp.KMSClient) (*kmspb.CryptoKey, error) {
 	k, ok := c.exists(ctx, client)
 	if ok {
 		if err := setIam(ctx, client, c.ProjectNumber, k); err != nil {
@@ -75,7 +87,7 @@ func (c *CryptoKey) create(ctx context.Context, client *kms.KeyManagementClient)
 }
 
 // Get KMS Crypto Key
-func (c *CryptoKey) get(ctx context.Context, client *kms.KeyManagementClient) (*kmspb.CryptoKey, error) {
+func (c *CryptoKey) get(ctx context.Context, client gcp.KMSClient) (*kmspb.CryptoKey, error) {
 	req := &kmspb.GetCryptoKeyRequest{
 		Name: fmt.Sprintf("%s/cryptoKeys/%s", c.Keyring, c.Name),
 	}
@@ -87,7 +99,7 @@ func (c *CryptoKey) get(ctx context.Context, client *kms.KeyManagementClient) (*
 }
 
 // Check if KMS Crypto Key exists
-func (c *CryptoKey) exists(ctx context.Context, client *kms.KeyManagementClient) (*kmspb.CryptoKey, bool) {
+func (c *CryptoKey) exists(ctx context.Context, client gcp.KMSClient) (*kmspb.CryptoKey, bool) {
 	k, err := c.get(ctx, client)
 	if err != nil {
 		return nil, false
@@ -95,9 +107,29 @@ func (c *CryptoKey) exists(ctx context.Context, client *kms.KeyManagementClient)
 	return k, err == nil
 }
 
-func (c *CryptoKey) checkVersion(ctx context.Context, client *kms.KeyManagementClient, k *kmspb.CryptoKey) (*kmspb.CryptoKeyVersion, error) {
+// Diff KMS Crypto Key against the config
+func (c *CryptoKey) diff(ctx context.Context, client gcp.KMSClient) (*tidalwave.ResourcePlan, error) {
+	key, err := c.get(ctx, client)
+	if isNotFound(err) {
+		return tidalwave.NewResourcePlan("cryptokey", c.Name, false, nil), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return tidalwave.NewResourcePlan("cryptokey", c.Name, true, c.compare(key)), nil
+}
+
+// Compare a live KMS Crypto Key with the config
+func (c *CryptoKey) compare(key *kmspb.CryptoKey) []tidalwave.Change {
+	d := differ{}
+	d.forceNew("purpose", key.GetPurpose().String(), kmspb.CryptoKey_ENCRYPT_DECRYPT.String())
+	d.field("primary.state", key.GetPrimary().GetState().String(), kmspb.CryptoKeyVersion_ENABLED.String())
+	return d.changes
+}
+
+func (c *CryptoKey) checkVersion(ctx context.Context, client gcp.KMSClient, k *kmspb.CryptoKey) (*kmspb.CryptoKeyVersion, error) {
 	kv := k.GetPrimary()
-	switch kv.State {
+	switch kv.GetState() {
 	case kmspb.CryptoKeyVersion_DISABLED:
 		log.Printf("cryptokey version %s is disabled\n", k.GetName())
 		ks, err := enableKeyVersion(ctx, client, kv)
@@ -111,6 +143,7 @@ func (c *CryptoKey) checkVersion(ctx context.Context, client *kms.KeyManagementC
 		if err != nil {
 			return ks, err
 		}
+		return ks, nil
 	case kmspb.CryptoKeyVersion_DESTROY_SCHEDULED:
 		log.Printf("cryptokey %s is scheduled to be destroyed\n", k.GetName())
 		ks, err := restoreKeyVersion(ctx, client, kv)
@@ -126,19 +159,32 @@ func (c *CryptoKey) checkVersion(ctx context.Context, client *kms.KeyManagementC
 	return kv, nil
 }
 
-// Update KMS Crypto Key
-func (c *CryptoKey) update(ctx context.Context, client *kms.KeyManagementClient) (*kmspb.CryptoKey, error) {
+// Update KMS Crypto Key, restoring the primary version if it drifted from enabled
+func (c *CryptoKey) update(ctx context.Context, client gcp.KMSClient) (*kmspb.CryptoKey, error) {
 	key, err := c.get(ctx, client)
+	if isNotFound(err) {
+		return c.create(ctx, client)
+	}
 	if err != nil {
 		return nil, err
 	}
+	changes := c.compare(key)
+	if err := forceNewError("cryptokey", c.Name, changes); err != nil {
+		return nil, err
+	}
 	if err := setIam(ctx, client, c.ProjectNumber, key); err != nil {
 		return nil, err
 	}
+	if changed(changes, "primary.state") {
+		if _, err := c.checkVersion(ctx, client, key); err != nil {
+			return nil, err
+		}
+		return c.get(ctx, client)
+	}
 	return key, nil
 }
 
-func (c *CryptoKey) delete(ctx context.Context, client *kms.KeyManagementClient) error {
+func (c *CryptoKey) delete(ctx context.Context, client gcp.KMSClient) error {
 	key, err := c.get(ctx, client)
 	if err != nil {
 		return err
@@ -146,7 +192,7 @@ func (c *CryptoKey) delete(ctx context.Context, client *kms.KeyManagementClient)
 	return removeIam(ctx, client, c.ProjectNumber, key)
 }
 
-func enableKeyVersion(ctx context.Context, client *kms.KeyManagementClient, k *kmspb.CryptoKeyVersion) (*kmspb.CryptoKeyVersion, error) {
+func enableKeyVersion(ctx context.Context, client gcp.KMSClient, k *kmspb.CryptoKeyVersion) (*kmspb.CryptoKeyVersion, error) {
 	req := &kmspb.UpdateCryptoKeyVersionRequest{
 		CryptoKeyVersion: &kmspb.CryptoKeyVersion{
 			Name:  k.GetName(),
@@ -160,23 +206,14 @@ func enableKeyVersion(ctx context.Context, client *kms.KeyManagementClient, k *k
 	if err != nil {
 		return nil, err
 	}
-	var ks *kmspb.CryptoKeyVersion
-status:
-	for {
-		ks, err := client.GetCryptoKeyVersion(ctx, &kmspb.GetCryptoKeyVersionRequest{
-			Name: kv.GetName(),
-		})
-		if err != nil {
-			return nil, err
-		}
-		if ks.GetState() == kmspb.CryptoKeyVersion_ENABLED {
-			break status
-		}
+	err = waitKeyVersion(ctx, client, kv, kmspb.CryptoKeyVersion_ENABLED, "enable key version")
+	if err != nil {
+		return nil, err
 	}
-	return ks, nil
+	return kv, nil
 }
 
-func createKeyVersion(ctx context.Context, client *kms.KeyManagementClient, k *kmspb.CryptoKey) (*kmspb.CryptoKeyVersion, error) {
+func createKeyVersion(ctx context.Context, client gcp.KMSClient, k *kmspb.CryptoKey) (*kmspb.CryptoKeyVersion, error) {
 	req := &kmspb.CreateCryptoKeyVersionRequest{
 		Parent: k.GetName(),
 	}
@@ -186,7 +223,7 @@ func createKeyVersion(ctx context.Context, client *kms.KeyManagementClient, k *k
 	}
 	updateReq := &kmspb.UpdateCryptoKeyPrimaryVersionRequest{
 		Name:               k.GetName(),
-		CryptoKeyVersionId: kv.GetName(),
+		CryptoKeyVersionId: resourceName(kv.GetName()),
 	}
 	_, err = client.UpdateCryptoKeyPrimaryVersion(ctx, updateReq)
 	if err != nil {
@@ -195,7 +232,7 @@ func createKeyVersion(ctx context.Context, client *kms.KeyManagementClient, k *k
 	return kv, nil
 }
 
-func restoreKeyVersion(ctx context.Context, client *kms.KeyManagementClient, k *kmspb.CryptoKeyVersion) (*kmspb.CryptoKeyVersion, error) {
+func restoreKeyVersion(ctx context.Context, client gcp.KMSClient, k *kmspb.CryptoKeyVersion) (*kmspb.CryptoKeyVersion, error) {
 	req := &kmspb.RestoreCryptoKeyVersionRequest{
 		Name: k.GetName(),
 	}
@@ -203,17 +240,18 @@ func restoreKeyVersion(ctx context.Context, client *kms.KeyManagementClient, k *
 	if err != nil {
 		return nil, err
 	}
-status:
-	for {
-		ks, err := client.GetCryptoKeyVersion(ctx, &kmspb.GetCryptoKeyVersionRequest{
-			Name: kv.GetName(),
-		})
-		if err != nil {
-			return nil, err
-		}
-		if ks.GetState() == kmspb.CryptoKeyVersion_DISABLED {
-			break status
-		}
+	err = waitKeyVersion(ctx, client, kv, kmspb.CryptoKeyVersion_DISABLED, "restore key version")
+	if err != nil {
+		return nil, err
 	}
 	return kv, nil
 }
+
+// waitKeyVersion polls a key version until it reaches the want state
+func waitKeyVersion(ctx context.Context, client gcp.KMSClient, kv *kmspb.CryptoKeyVersion, want kmspb.CryptoKeyVersion_CryptoKeyVersionState, description string) error {
+	return newWaiter().wait(ctx, kv.GetName(), description, pollKeyVersion(want, func(ctx context.Context) (*kmspb.CryptoKeyVersion, error) {
+		return client.GetCryptoKeyVersion(ctx, &kmspb.GetCryptoKeyVersionRequest{
+			Name: kv.GetName(),
+		})
+	}))
+}
@@ -0,0 +1,74 @@
+package google
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"tidalwave/internal/google/googletest"
+
+	kmspb "cloud.google.com/go/kms/apiv1/kmspb"
+)
+
+func TestCheckVersion(t *testing.T) {
+	for _, tc := range []struct {
+		state       kmspb.CryptoKeyVersion_CryptoKeyVersionState
+		wantPrimary string
+		wantCalls   []string
+	}{
+		{
+			state:       kmspb.CryptoKeyVersion_ENABLED,
+			wantPrimary: "cryptoKeyVersions/1",
+		},
+		{
+			state:       kmspb.CryptoKeyVersion_DISABLED,
+			wantPrimary: "cryptoKeyVersions/1",
+			wantCalls:   []string{"UpdateCryptoKeyVersion"},
+		},
+		{
+			state:       kmspb.CryptoKeyVersion_DESTROY_SCHEDULED,
+			wantPrimary: "cryptoKeyVersions/1",
+			wantCalls:   []string{"RestoreCryptoKeyVersion", "UpdateCryptoKeyVersion"},
+		},
+		{
+			state:       kmspb.CryptoKeyVersion_DESTROYED,
+			wantPrimary: "cryptoKeyVersions/2",
+			wantCalls:   []string{"CreateCryptoKeyVersion", "UpdateCryptoKeyPrimaryVersion"},
+		},
+	} {
+		t.Run(tc.state.String(), func(t *testing.T) {
+			ctx := context.Background()
+			client := googletest.NewKMS()
+			keyring := Keyring{Name: "test", ProjectID: "project", Region: "us-central1"}
+			if _, err := keyring.create(ctx, client); err != nil {
+				t.Fatal(err)
+			}
+			key := CryptoKey{Name: "test", Keyring: keyring.name(), ProjectNumber: "123"}
+			k, err := key.create(ctx, client)
+			if err != nil {
+				t.Fatal(err)
+			}
+			client.SetVersionState(k.GetPrimary().GetName(), tc.state)
+			client.Reset()
+
+			k, err = key.update(ctx, client)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !strings.HasSuffix(k.GetPrimary().GetName(), tc.wantPrimary) {
+				t.Errorf("primary is %s, want %s", k.GetPrimary().GetName(), tc.wantPrimary)
+			}
+			if k.GetPrimary().GetState() != kmspb.CryptoKeyVersion_ENABLED {
+				t.Errorf("primary is %s, want ENABLED", k.GetPrimary().GetState())
+			}
+			got := []string{}
+			for _, c := range client.Mutations() {
+				if c != "SetIamPolicy" {
+					got = append(got, c)
+				}
+			}
+			if strings.Join(got, ",") != strings.Join(tc.wantCalls, ",") {
+				t.Errorf("calls %v, want %v", got, tc.wantCalls)
+			}
+		})
+	}
+}
@@ -0,0 +1,158 @@
+package google
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"tidalwave/internal/tidalwave"
+
+	computepb "cloud.google.com/go/compute/apiv1/computepb"
+	containerpb "cloud.google.com/go/container/apiv1/containerpb"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// differ collects field differences between the config and a live resource
+type differ struct {
+	changes []tidalwave.Change
+}
+
+// field records a change if the observed and desired values differ
+func (d *differ) field(name, observed, desired string) {
+	if observed != desired {
+		d.changes = append(d.changes, tidalwave.Change{
+			Field:    name,
+			Observed: observed,
+			Desired:  desired,
+		})
+	}
+}
+
+// forceNew records a change to a field that cannot be updated in place
+func (d *differ) forceNew(name, observed, desired string) {
+	if observed != desired {
+		d.changes = append(d.changes, tidalwave.Change{
+			Field:    name,
+			Observed: observed,
+			Desired:  desired,
+			ForceNew: true,
+		})
+	}
+}
+
+// forceNewError returns an error naming the fields that cannot be changed without replacing the resource
+func forceNewError(kind, name string, changes []tidalwave.Change) error {
+	fields := []string{}
+	for _, c := range changes {
+		if c.ForceNew {
+			fields = append(fields, c.Field)
+		}
+	}
+	if len(fields) == 0 {
+		return nil
+	}
+	return fmt.Errorf("%s %s must be deleted and recreated to change %s", kind, name, strings.Join(fields, ", "))
+}
+
+// changed reports whether field is in changes
+func changed(changes []tidalwave.Change, field string) bool {
+	for _, c := range changes {
+		if c.Field == field {
+			return true
+		}
+	}
+	return false
+}
+
+// isNotFound reports whether err is a not found error from either the REST or gRPC clients
+func isNotFound(err error) bool {
+	var apiErr *googleapi.Error
+	if errors.As(err, &apiErr) {
+		return apiErr.Code == 404
+	}
+	return status.Code(err) == codes.NotFound
+}
+
+// isConflict reports whether err is a concurrent modification error, such as a stale IAM
+// policy etag, from either the REST or gRPC clients
+func isConflict(err error) bool {
+	var apiErr *googleapi.Error
+	if errors.As(err, &apiErr) {
+		return apiErr.Code == 409
+	}
+	return status.Code(err) == codes.Aborted
+}
+
+// isMissingMember reports whether err rejects an IAM policy for a member that does not exist,
+// which a service account that was just created is until IAM has caught up with it
+func isMissingMember(err error) bool {
+	var apiErr *googleapi.Error
+	if errors.As(err, &apiErr) {
+		return apiErr.Code == 400 && strings.Contains(apiErr.Message, "does not exist")
+	}
+	return status.Code(err) == codes.InvalidArgument && strings.Contains(status.Convert(err).Message(), "does not exist")
+}
+
+// resourceName returns the last segment of a self link or resource name
+func resourceName(link string) string {
+	return link[strings.LastIndex(link, "/")+1:]
+}
+
+// list formats a string slice independent of order
+func list(s []string) string {
+	l := append([]string{}, s...)
+	sort.Strings(l)
+	return strings.Join(l, ",")
+}
+
+// allowedList formats firewall allow rules independent of order
+func allowedList(allowed []*computepb.Allowed) string {
+	l := []string{}
+	for _, a := range allowed {
+		rule := a.GetIPProtocol()
+		if len(a.GetPorts()) > 0 {
+			rule = fmt.Sprintf("%s:%s", rule, list(a.GetPorts()))
+		}
+		l = append(l, rule)
+	}
+	return list(l)
+}
+
+// secondaryRangeList formats subnetwork secondary ranges independent of order
+func secondaryRangeList(ranges []*computepb.SubnetworkSecondaryRange) string {
+	l := []string{}
+	for _, r := range ranges {
+		l = append(l, fmt.Sprintf("%s=%s", r.GetRangeName(), r.GetIpCidrRange()))
+	}
+	return list(l)
+}
+
+// cidrBlockList formats master authorized networks independent of order
+func cidrBlockList(blocks []*containerpb.MasterAuthorizedNetworksConfig_CidrBlock) string {
+	l := []string{}
+	for _, b := range blocks {
+		l = append(l, fmt.Sprintf("%s=%s", b.GetDisplayName(), b.GetCidrBlock()))
+	}
+	return list(l)
+}
+
+// componentList formats the logging or monitoring components of a cluster
+func componentList[T fmt.Stringer](components []T) string {
+	l := make([]string, 0, len(components))
+	for _, c := range components {
+		l = append(l, c.String())
+	}
+	return list(l)
+}
+
+// contains reports whether s is in list
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
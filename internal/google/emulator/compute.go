@@ -0,0 +1,294 @@
+package emulator
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	computepb "cloud.google.com/go/compute/apiv1/computepb"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+// computeREST serves the Compute REST API for networks, subnetworks, routers, firewalls and their
+// operations, paths look like /compute/v1/projects/{project}/global/networks/{network}
+type computeREST struct {
+	e *Emulator
+}
+
+// computePath is a parsed Compute REST path
+type computePath struct {
+	project string
+	// region is empty for global resources
+	region     string
+	collection string
+	name       string
+	verb       string
+}
+
+func parseComputePath(path string) (*computePath, bool) {
+	parts := strings.Split(strings.TrimPrefix(path, "/compute/v1/projects/"), "/")
+	if len(parts) < 3 {
+		return nil, false
+	}
+	p := &computePath{project: parts[0]}
+	switch parts[1] {
+	case "global":
+		parts = parts[2:]
+	case "regions":
+		p.region = parts[2]
+		parts = parts[3:]
+	default:
+		return nil, false
+	}
+	switch len(parts) {
+	case 3:
+		p.verb = parts[2]
+		fallthrough
+	case 2:
+		p.name = parts[1]
+		fallthrough
+	case 1:
+		p.collection = parts[0]
+	default:
+		return nil, false
+	}
+	return p, true
+}
+
+func (c *computeREST) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	p, ok := parseComputePath(r.URL.Path)
+	if !ok {
+		writeError(w, &googleapi.Error{Code: http.StatusNotFound, Message: fmt.Sprintf("%s not found", r.URL.Path)})
+		return
+	}
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	resp, err := c.serve(r.Context(), r.Method, p, body)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	b, err := protojson.Marshal(resp)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(b)
+}
+
+// serve routes a request to the fakes
+func (c *computeREST) serve(ctx context.Context, method string, p *computePath, body []byte) (proto.Message, error) {
+	e := c.e
+	route := fmt.Sprintf("%s %s", method, p.collection)
+	if p.name != "" {
+		route += "/"
+	}
+	if p.verb != "" {
+		route += p.verb
+	}
+	switch route {
+	case "GET operations/":
+		return c.operation(p.name)
+
+	case "GET networks/":
+		return e.Networks.Get(ctx, &computepb.GetNetworkRequest{Project: p.project, Network: p.name})
+	case "POST networks":
+		network := &computepb.Network{}
+		if err := unmarshal(body, network); err != nil {
+			return nil, err
+		}
+		return c.mutate(p, "Networks.Insert", network.GetName(), func() error {
+			_, err := e.Networks.Insert(ctx, &computepb.InsertNetworkRequest{Project: p.project, NetworkResource: network})
+			return err
+		})
+	case "DELETE networks/":
+		return c.mutate(p, "Networks.Delete", p.name, func() error {
+			_, err := e.Networks.Delete(ctx, &computepb.DeleteNetworkRequest{Project: p.project, Network: p.name})
+			return err
+		})
+
+	case "GET subnetworks/":
+		return e.Subnetworks.Get(ctx, &computepb.GetSubnetworkRequest{Project: p.project, Region: p.region, Subnetwork: p.name})
+	case "POST subnetworks":
+		subnet := &computepb.Subnetwork{}
+		if err := unmarshal(body, subnet); err != nil {
+			return nil, err
+		}
+		return c.mutate(p, "Subnetworks.Insert", subnet.GetName(), func() error {
+			_, err := e.Subnetworks.Insert(ctx, &computepb.InsertSubnetworkRequest{Project: p.project, Region: p.region, SubnetworkResource: subnet})
+			return err
+		})
+	case "DELETE subnetworks/":
+		return c.mutate(p, "Subnetworks.Delete", p.name, func() error {
+			_, err := e.Subnetworks.Delete(ctx, &computepb.DeleteSubnetworkRequest{Project: p.project, Region: p.region, Subnetwork: p.name})
+			return err
+		})
+	case "PATCH subnetworks/":
+		subnet := &computepb.Subnetwork{}
+		if err := unmarshal(body, subnet); err != nil {
+			return nil, err
+		}
+		return c.mutate(p, "Subnetworks.Patch", p.name, func() error {
+			_, err := e.Subnetworks.Patch(ctx, &computepb.PatchSubnetworkRequest{Project: p.project, Region: p.region, Subnetwork: p.name, SubnetworkResource: subnet})
+			return err
+		})
+	case "POST subnetworks/expandIpCidrRange":
+		expand := &computepb.SubnetworksExpandIpCidrRangeRequest{}
+		if err := unmarshal(body, expand); err != nil {
+			return nil, err
+		}
+		return c.mutate(p, "Subnetworks.ExpandIpCidrRange", p.name, func() error {
+			_, err := e.Subnetworks.ExpandIpCidrRange(ctx, &computepb.ExpandIpCidrRangeSubnetworkRequest{Project: p.project, Region: p.region, Subnetwork: p.name, SubnetworksExpandIpCidrRangeRequestResource: expand})
+			return err
+		})
+	case "POST subnetworks/setPrivateIpGoogleAccess":
+		access := &computepb.SubnetworksSetPrivateIpGoogleAccessRequest{}
+		if err := unmarshal(body, access); err != nil {
+			return nil, err
+		}
+		return c.mutate(p, "Subnetworks.SetPrivateIpGoogleAccess", p.name, func() error {
+			_, err := e.Subnetworks.SetPrivateIpGoogleAccess(ctx, &computepb.SetPrivateIpGoogleAccessSubnetworkRequest{Project: p.project, Region: p.region, Subnetwork: p.name, SubnetworksSetPrivateIpGoogleAccessRequestResource: access})
+			return err
+		})
+
+	case "GET routers/":
+		return e.Routers.Get(ctx, &computepb.GetRouterRequest{Project: p.project, Region: p.region, Router: p.name})
+	case "POST routers":
+		router := &computepb.Router{}
+		if err := unmarshal(body, router); err != nil {
+			return nil, err
+		}
+		return c.mutate(p, "Routers.Insert", router.GetName(), func() error {
+			_, err := e.Routers.Insert(ctx, &computepb.InsertRouterRequest{Project: p.project, Region: p.region, RouterResource: router})
+			return err
+		})
+	case "DELETE routers/":
+		return c.mutate(p, "Routers.Delete", p.name, func() error {
+			_, err := e.Routers.Delete(ctx, &computepb.DeleteRouterRequest{Project: p.project, Region: p.region, Router: p.name})
+			return err
+		})
+	case "PATCH routers/":
+		router := &computepb.Router{}
+		if err := unmarshal(body, router); err != nil {
+			return nil, err
+		}
+		return c.mutate(p, "Routers.Patch", p.name, func() error {
+			_, err := e.Routers.Patch(ctx, &computepb.PatchRouterRequest{Project: p.project, Region: p.region, Router: p.name, RouterResource: router})
+			return err
+		})
+
+	case "GET firewalls/":
+		return e.Firewalls.Get(ctx, &computepb.GetFirewallRequest{Project: p.project, Firewall: p.name})
+	case "POST firewalls":
+		rule := &computepb.Firewall{}
+		if err := unmarshal(body, rule); err != nil {
+			return nil, err
+		}
+		return c.mutate(p, "Firewalls.Insert", rule.GetName(), func() error {
+			_, err := e.Firewalls.Insert(ctx, &computepb.InsertFirewallRequest{Project: p.project, FirewallResource: rule})
+			return err
+		})
+	case "DELETE firewalls/":
+		return c.mutate(p, "Firewalls.Delete", p.name, func() error {
+			_, err := e.Firewalls.Delete(ctx, &computepb.DeleteFirewallRequest{Project: p.project, Firewall: p.name})
+			return err
+		})
+	case "PATCH firewalls/":
+		rule := &computepb.Firewall{}
+		if err := unmarshal(body, rule); err != nil {
+			return nil, err
+		}
+		return c.mutate(p, "Firewalls.Patch", p.name, func() error {
+			_, err := e.Firewalls.Patch(ctx, &computepb.PatchFirewallRequest{Project: p.project, Firewall: p.name, FirewallResource: rule})
+			return err
+		})
+	case "PUT firewalls/":
+		rule := &computepb.Firewall{}
+		if err := unmarshal(body, rule); err != nil {
+			return nil, err
+		}
+		return c.mutate(p, "Firewalls.Update", p.name, func() error {
+			_, err := e.Firewalls.Update(ctx, &computepb.UpdateFirewallRequest{Project: p.project, Firewall: p.name, FirewallResource: rule})
+			return err
+		})
+	}
+	return nil, &googleapi.Error{Code: http.StatusNotFound, Message: fmt.Sprintf("%s %s is not emulated", method, p.collection)}
+}
+
+// mutate starts an operation on the named resource
+func (c *computeREST) mutate(p *computePath, method, name string, fn func() error) (*computepb.Operation, error) {
+	op, err := c.e.mutate(method, fn)
+	if err != nil {
+		return nil, err
+	}
+	scope := "global"
+	if p.region != "" {
+		scope = "regions/" + p.region
+	}
+	resp := computeOperation(op)
+	resp.TargetLink = proto.String(fmt.Sprintf("%s/compute/v1/projects/%s/%s/%s/%s", c.e.rest.URL, p.project, scope, p.collection, name))
+	return resp, nil
+}
+
+// operation returns the current state of an operation
+func (c *computeREST) operation(name string) (*computepb.Operation, error) {
+	op, ok := c.e.operation(name)
+	if !ok {
+		return nil, &googleapi.Error{Code: http.StatusNotFound, Message: fmt.Sprintf("The resource 'operations/%s' was not found", name)}
+	}
+	return computeOperation(op), nil
+}
+
+func computeOperation(op *operation) *computepb.Operation {
+	resp := &computepb.Operation{
+		Name:     proto.String(op.name),
+		Status:   computepb.Operation_RUNNING.Enum(),
+		Progress: proto.Int32(op.progress()),
+	}
+	if !op.done() {
+		return resp
+	}
+	resp.Status = computepb.Operation_DONE.Enum()
+	if op.err != "" {
+		resp.Error = &computepb.Error{
+			Errors: []*computepb.Errors{
+				{
+					Code:    proto.String("OPERATION_FAILED"),
+					Message: proto.String(op.err),
+				},
+			},
+		}
+	}
+	return resp
+}
+
+func unmarshal(body []byte, m proto.Message) error {
+	if err := (protojson.UnmarshalOptions{DiscardUnknown: true}).Unmarshal(body, m); err != nil {
+		return &googleapi.Error{Code: http.StatusBadRequest, Message: err.Error()}
+	}
+	return nil
+}
+
+// writeError writes err in the JSON form googleapi.CheckResponse parses
+func writeError(w http.ResponseWriter, err error) {
+	apiErr := &googleapi.Error{Code: http.StatusInternalServerError, Message: err.Error()}
+	errors.As(err, &apiErr)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(apiErr.Code)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"error": map[string]interface{}{
+			"code":    apiErr.Code,
+			"message": apiErr.Message,
+		},
+	})
+}
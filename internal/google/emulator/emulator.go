@@ -0,0 +1,207 @@
+/*
+Package emulator serves the googletest fakes over local gRPC and REST endpoints, so the CLI can
+be run end to end against them with google.Endpoints
+*/
+package emulator
+
+import (
+	"fmt"
+	"net"
+	"net/http/httptest"
+	"sort"
+	"sync"
+	"tidalwave/internal/google"
+	"tidalwave/internal/google/googletest"
+	"time"
+
+	containerpb "cloud.google.com/go/container/apiv1/containerpb"
+	adminpb "cloud.google.com/go/iam/admin/apiv1/adminpb"
+	iampb "cloud.google.com/go/iam/apiv1/iampb"
+	kmspb "cloud.google.com/go/kms/apiv1/kmspb"
+	longrunning "cloud.google.com/go/longrunning/autogen/longrunningpb"
+	resourcemanagerpb "cloud.google.com/go/resourcemanager/apiv3/resourcemanagerpb"
+	serviceusagepb "cloud.google.com/go/serviceusage/apiv1/serviceusagepb"
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/types/known/anypb"
+)
+
+// Emulator stands in for the Compute, Container, IAM, KMS, Resource Manager and Service Usage
+// APIs.
+// Changes are applied to the fakes straight away, the operations that report them complete
+// after the configured delay.
+type Emulator struct {
+	Networks    *googletest.Networks
+	Subnetworks *googletest.Subnetworks
+	Routers     *googletest.Routers
+	Firewalls   *googletest.Firewalls
+	KMS         *googletest.KMS
+	Container   *googletest.Container
+	IAM         *googletest.IAM
+
+	mu       sync.Mutex
+	delay    time.Duration
+	failures map[string]string
+	ops      map[string]*operation
+	projects map[string]int64
+	services map[string][]string
+
+	grpc *grpc.Server
+	lis  net.Listener
+	rest *httptest.Server
+}
+
+// operation is a long-running operation started by the emulator
+type operation struct {
+	name   string
+	method string
+	start  time.Time
+	delay  time.Duration
+	// err is the message the operation fails with, empty if it succeeds
+	err string
+	// response is returned by longrunning operations that succeed
+	response *anypb.Any
+}
+
+// done reports whether the operation has completed
+func (o *operation) done() bool {
+	return time.Since(o.start) >= o.delay
+}
+
+// progress returns how far through its delay the operation is, as a percentage
+func (o *operation) progress() int32 {
+	if o.done() {
+		return 100
+	}
+	return int32(100 * time.Since(o.start) / o.delay)
+}
+
+// Start serves the emulated APIs on random local ports until Close is called
+func Start() (*Emulator, error) {
+	e := &Emulator{
+		Networks:    googletest.NewNetworks(),
+		Subnetworks: googletest.NewSubnetworks(),
+		Routers:     googletest.NewRouters(),
+		Firewalls:   googletest.NewFirewalls(),
+		KMS:         googletest.NewKMS(),
+		Container:   googletest.NewContainer(),
+		IAM:         googletest.NewIAM(),
+		failures:    map[string]string{},
+		ops:         map[string]*operation{},
+		projects:    map[string]int64{},
+		services:    map[string][]string{},
+	}
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	e.lis = lis
+	e.grpc = grpc.NewServer()
+	containerpb.RegisterClusterManagerServer(e.grpc, &clusterManager{e: e})
+	kmspb.RegisterKeyManagementServiceServer(e.grpc, &keyManagement{e: e})
+	iampb.RegisterIAMPolicyServer(e.grpc, &iamPolicy{e: e})
+	adminpb.RegisterIAMServer(e.grpc, &iamAdmin{e: e})
+	resourcemanagerpb.RegisterProjectsServer(e.grpc, &projects{e: e})
+	serviceusagepb.RegisterServiceUsageServer(e.grpc, &serviceUsage{e: e})
+	longrunning.RegisterOperationsServer(e.grpc, &operations{e: e})
+	go e.grpc.Serve(lis)
+	e.rest = httptest.NewServer(&computeREST{e: e})
+	return e, nil
+}
+
+// Close stops serving
+func (e *Emulator) Close() {
+	e.grpc.Stop()
+	e.rest.Close()
+}
+
+// Endpoints returns the endpoints to reach the emulator
+func (e *Emulator) Endpoints() google.Endpoints {
+	addr := e.lis.Addr().String()
+	return google.Endpoints{
+		Compute:         e.rest.URL,
+		Container:       addr,
+		IAM:             addr,
+		KMS:             addr,
+		ResourceManager: addr,
+		ServiceUsage:    addr,
+		Insecure:        true,
+	}
+}
+
+// SetDelay sets how long the operations started from now on take to complete
+func (e *Emulator) SetDelay(d time.Duration) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.delay = d
+}
+
+// FailOperation makes the operations later started by method fail with message instead of
+// making their change, an empty message clears it. Methods are named after the fakes, e.g.
+// Networks.Insert, Container.CreateCluster or ServiceUsage.BatchEnableServices.
+func (e *Emulator) FailOperation(method, message string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if message == "" {
+		delete(e.failures, method)
+		return
+	}
+	e.failures[method] = message
+}
+
+// AddProject makes a project known to Resource Manager
+func (e *Emulator) AddProject(id string, number int64) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.projects[id] = number
+}
+
+// EnabledServices returns the services enabled in a project, given as projects/<number>
+func (e *Emulator) EnabledServices(project string) []string {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	services := append([]string{}, e.services[project]...)
+	sort.Strings(services)
+	return services
+}
+
+// mutate starts an operation for method, fn makes its change unless the operation is set to fail
+func (e *Emulator) mutate(method string, fn func() error) (*operation, error) {
+	e.mu.Lock()
+	message := e.failures[method]
+	e.mu.Unlock()
+	if message == "" {
+		if err := fn(); err != nil {
+			return nil, err
+		}
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	op := &operation{
+		name:   fmt.Sprintf("operation-%d", len(e.ops)+1),
+		method: method,
+		start:  time.Now(),
+		delay:  e.delay,
+		err:    message,
+	}
+	e.ops[op.name] = op
+	return op, nil
+}
+
+// respond sets the response of a longrunning operation
+func (e *Emulator) respond(op *operation, response *anypb.Any) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	op.response = response
+}
+
+// operation returns a copy of an operation started by the emulator
+func (e *Emulator) operation(name string) (*operation, bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	op, ok := e.ops[name]
+	if !ok {
+		return nil, false
+	}
+	c := *op
+	return &c, true
+}
@@ -0,0 +1,120 @@
+package emulator
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"tidalwave/internal/google"
+	"time"
+
+	compute "cloud.google.com/go/compute/apiv1"
+	computepb "cloud.google.com/go/compute/apiv1/computepb"
+	"google.golang.org/api/option"
+	"google.golang.org/protobuf/proto"
+)
+
+func start(t *testing.T) *Emulator {
+	t.Helper()
+	e, err := Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(e.Close)
+	return e
+}
+
+func controlplane(endpoints google.Endpoints) *google.Controlplane {
+	return &google.Controlplane{
+		Endpoints: endpoints,
+		Vpc:       google.Vpc{Name: "test", ProjectID: "project"},
+		Subnetwork: google.Subnetwork{
+			Name:         "test",
+			ProjectID:    "project",
+			Region:       "us-central1",
+			NodesCidr:    "10.0.0.0/24",
+			PodsCidr:     "10.1.0.0/16",
+			ServicesCidr: "10.2.0.0/20",
+		},
+		Router:    google.Router{Name: "test", ProjectID: "project", Region: "us-central1"},
+		Keyring:   google.Keyring{Name: "test", ProjectID: "project", Region: "us-central1"},
+		CryptoKey: google.CryptoKey{Name: "test", ProjectID: "project", ProjectNumber: "123"},
+		Cluster: google.Cluster{
+			Name:                "test",
+			ProjectID:           "project",
+			Region:              "us-central1",
+			Network:             "test",
+			Subnetwork:          "test",
+			MasterIpv4CidrBlock: "172.16.0.0/28",
+			NodePools: []google.NodePool{
+				{Name: google.DefaultPool, MachineType: "n2-standard-4", MinNodeCount: 1, MaxNodeCount: 3},
+			},
+		},
+	}
+}
+
+func TestFailOperation(t *testing.T) {
+	for _, tc := range []struct {
+		method string
+		node   string
+	}{
+		{method: "Networks.Insert", node: "vpc"},
+		{method: "Routers.Insert", node: "router"},
+		{method: "Container.CreateCluster", node: "cluster"},
+	} {
+		t.Run(tc.method, func(t *testing.T) {
+			e := start(t)
+			e.FailOperation(tc.method, "quota exceeded")
+			err := controlplane(e.Endpoints()).Create(context.Background())
+			if err == nil {
+				t.Fatal("create succeeded")
+			}
+			if !strings.HasPrefix(err.Error(), tc.node+":") || !strings.Contains(err.Error(), "quota exceeded") {
+				t.Errorf("error is %q, want the %s operation to fail", err, tc.node)
+			}
+		})
+	}
+}
+
+func TestEnableApis(t *testing.T) {
+	e := start(t)
+	c := controlplane(e.Endpoints())
+	if err := c.EnableApis(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := len(e.EnabledServices("projects/123")), len(google.RequiredApis.Services); got != want {
+		t.Errorf("%d services enabled, want %d", got, want)
+	}
+
+	e.FailOperation("ServiceUsage.BatchEnableServices", "permission denied")
+	if err := c.EnableApis(context.Background()); err == nil || !strings.Contains(err.Error(), "permission denied") {
+		t.Errorf("error is %v, want permission denied", err)
+	}
+}
+
+func TestDelay(t *testing.T) {
+	e := start(t)
+	e.SetDelay(500 * time.Millisecond)
+	ctx := context.Background()
+	client, err := compute.NewNetworksRESTClient(ctx, option.WithEndpoint(e.Endpoints().Compute), option.WithoutAuthentication())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	op, err := client.Insert(ctx, &computepb.InsertNetworkRequest{
+		Project:         "project",
+		NetworkResource: &computepb.Network{Name: proto.String("test")},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if op.Done() {
+		t.Error("operation done before its delay")
+	}
+	if err := op.Wait(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if !op.Done() {
+		t.Error("operation not done after Wait")
+	}
+}
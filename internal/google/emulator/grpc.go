@@ -0,0 +1,312 @@
+package emulator
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	containerpb "cloud.google.com/go/container/apiv1/containerpb"
+	adminpb "cloud.google.com/go/iam/admin/apiv1/adminpb"
+	iampb "cloud.google.com/go/iam/apiv1/iampb"
+	kmspb "cloud.google.com/go/kms/apiv1/kmspb"
+	longrunning "cloud.google.com/go/longrunning/autogen/longrunningpb"
+	resourcemanagerpb "cloud.google.com/go/resourcemanager/apiv3/resourcemanagerpb"
+	serviceusagepb "cloud.google.com/go/serviceusage/apiv1/serviceusagepb"
+	rpcstatus "google.golang.org/genproto/googleapis/rpc/status"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/anypb"
+	"google.golang.org/protobuf/types/known/emptypb"
+)
+
+// lastSegment returns the id at the end of a resource name
+func lastSegment(name string) string {
+	return name[strings.LastIndex(name, "/")+1:]
+}
+
+// clusterManager serves the Container API from the fake, its operations are tracked by the
+// emulator
+type clusterManager struct {
+	containerpb.UnimplementedClusterManagerServer
+	e *Emulator
+}
+
+// mutate starts an operation for a Container method
+func (s *clusterManager) mutate(method, parent string, fn func() (*containerpb.Operation, error)) (*containerpb.Operation, error) {
+	var fake *containerpb.Operation
+	op, err := s.e.mutate("Container."+method, func() error {
+		var err error
+		fake, err = fn()
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	resp := containerOperation(op)
+	resp.OperationType = fake.GetOperationType()
+	resp.TargetLink = fake.GetTargetLink()
+	resp.SelfLink = fmt.Sprintf("%s/operations/%s", parent, op.name)
+	return resp, nil
+}
+
+func containerOperation(op *operation) *containerpb.Operation {
+	resp := &containerpb.Operation{
+		Name:   op.name,
+		Status: containerpb.Operation_RUNNING,
+	}
+	if !op.done() {
+		return resp
+	}
+	resp.Status = containerpb.Operation_DONE
+	if op.err != "" {
+		resp.StatusMessage = op.err
+		resp.Error = &rpcstatus.Status{Code: int32(codes.Internal), Message: op.err}
+	}
+	return resp
+}
+
+func (s *clusterManager) GetCluster(ctx context.Context, req *containerpb.GetClusterRequest) (*containerpb.Cluster, error) {
+	return s.e.Container.GetCluster(ctx, req)
+}
+
+func (s *clusterManager) CreateCluster(ctx context.Context, req *containerpb.CreateClusterRequest) (*containerpb.Operation, error) {
+	return s.mutate("CreateCluster", req.GetParent(), func() (*containerpb.Operation, error) {
+		return s.e.Container.CreateCluster(ctx, req)
+	})
+}
+
+func (s *clusterManager) UpdateCluster(ctx context.Context, req *containerpb.UpdateClusterRequest) (*containerpb.Operation, error) {
+	return s.mutate("UpdateCluster", req.GetName(), func() (*containerpb.Operation, error) {
+		return s.e.Container.UpdateCluster(ctx, req)
+	})
+}
+
+func (s *clusterManager) SetMaintenancePolicy(ctx context.Context, req *containerpb.SetMaintenancePolicyRequest) (*containerpb.Operation, error) {
+	return s.mutate("SetMaintenancePolicy", req.GetName(), func() (*containerpb.Operation, error) {
+		return s.e.Container.SetMaintenancePolicy(ctx, req)
+	})
+}
+
+func (s *clusterManager) DeleteCluster(ctx context.Context, req *containerpb.DeleteClusterRequest) (*containerpb.Operation, error) {
+	return s.mutate("DeleteCluster", req.GetName(), func() (*containerpb.Operation, error) {
+		return s.e.Container.DeleteCluster(ctx, req)
+	})
+}
+
+func (s *clusterManager) CreateNodePool(ctx context.Context, req *containerpb.CreateNodePoolRequest) (*containerpb.Operation, error) {
+	return s.mutate("CreateNodePool", req.GetParent(), func() (*containerpb.Operation, error) {
+		return s.e.Container.CreateNodePool(ctx, req)
+	})
+}
+
+func (s *clusterManager) UpdateNodePool(ctx context.Context, req *containerpb.UpdateNodePoolRequest) (*containerpb.Operation, error) {
+	return s.mutate("UpdateNodePool", req.GetName(), func() (*containerpb.Operation, error) {
+		return s.e.Container.UpdateNodePool(ctx, req)
+	})
+}
+
+func (s *clusterManager) DeleteNodePool(ctx context.Context, req *containerpb.DeleteNodePoolRequest) (*containerpb.Operation, error) {
+	return s.mutate("DeleteNodePool", req.GetName(), func() (*containerpb.Operation, error) {
+		return s.e.Container.DeleteNodePool(ctx, req)
+	})
+}
+
+func (s *clusterManager) SetNodePoolAutoscaling(ctx context.Context, req *containerpb.SetNodePoolAutoscalingRequest) (*containerpb.Operation, error) {
+	return s.mutate("SetNodePoolAutoscaling", req.GetName(), func() (*containerpb.Operation, error) {
+		return s.e.Container.SetNodePoolAutoscaling(ctx, req)
+	})
+}
+
+func (s *clusterManager) SetNodePoolManagement(ctx context.Context, req *containerpb.SetNodePoolManagementRequest) (*containerpb.Operation, error) {
+	return s.mutate("SetNodePoolManagement", req.GetName(), func() (*containerpb.Operation, error) {
+		return s.e.Container.SetNodePoolManagement(ctx, req)
+	})
+}
+
+func (s *clusterManager) GetOperation(ctx context.Context, req *containerpb.GetOperationRequest) (*containerpb.Operation, error) {
+	op, ok := s.e.operation(lastSegment(req.GetName()))
+	if !ok {
+		return nil, status.Errorf(codes.NotFound, "%s not found", req.GetName())
+	}
+	return containerOperation(op), nil
+}
+
+func (s *clusterManager) GetServerConfig(ctx context.Context, req *containerpb.GetServerConfigRequest) (*containerpb.ServerConfig, error) {
+	return s.e.Container.GetServerConfig(ctx, req)
+}
+
+// keyManagement serves the KMS API from the fake
+type keyManagement struct {
+	kmspb.UnimplementedKeyManagementServiceServer
+	e *Emulator
+}
+
+func (s *keyManagement) GetKeyRing(ctx context.Context, req *kmspb.GetKeyRingRequest) (*kmspb.KeyRing, error) {
+	return s.e.KMS.GetKeyRing(ctx, req)
+}
+
+func (s *keyManagement) CreateKeyRing(ctx context.Context, req *kmspb.CreateKeyRingRequest) (*kmspb.KeyRing, error) {
+	return s.e.KMS.CreateKeyRing(ctx, req)
+}
+
+func (s *keyManagement) GetCryptoKey(ctx context.Context, req *kmspb.GetCryptoKeyRequest) (*kmspb.CryptoKey, error) {
+	return s.e.KMS.GetCryptoKey(ctx, req)
+}
+
+func (s *keyManagement) CreateCryptoKey(ctx context.Context, req *kmspb.CreateCryptoKeyRequest) (*kmspb.CryptoKey, error) {
+	return s.e.KMS.CreateCryptoKey(ctx, req)
+}
+
+func (s *keyManagement) GetCryptoKeyVersion(ctx context.Context, req *kmspb.GetCryptoKeyVersionRequest) (*kmspb.CryptoKeyVersion, error) {
+	return s.e.KMS.GetCryptoKeyVersion(ctx, req)
+}
+
+func (s *keyManagement) CreateCryptoKeyVersion(ctx context.Context, req *kmspb.CreateCryptoKeyVersionRequest) (*kmspb.CryptoKeyVersion, error) {
+	return s.e.KMS.CreateCryptoKeyVersion(ctx, req)
+}
+
+func (s *keyManagement) UpdateCryptoKeyVersion(ctx context.Context, req *kmspb.UpdateCryptoKeyVersionRequest) (*kmspb.CryptoKeyVersion, error) {
+	return s.e.KMS.UpdateCryptoKeyVersion(ctx, req)
+}
+
+func (s *keyManagement) UpdateCryptoKeyPrimaryVersion(ctx context.Context, req *kmspb.UpdateCryptoKeyPrimaryVersionRequest) (*kmspb.CryptoKey, error) {
+	return s.e.KMS.UpdateCryptoKeyPrimaryVersion(ctx, req)
+}
+
+func (s *keyManagement) RestoreCryptoKeyVersion(ctx context.Context, req *kmspb.RestoreCryptoKeyVersionRequest) (*kmspb.CryptoKeyVersion, error) {
+	return s.e.KMS.RestoreCryptoKeyVersion(ctx, req)
+}
+
+// iamPolicy serves the IAM policies of KMS resources from the fake
+type iamPolicy struct {
+	iampb.UnimplementedIAMPolicyServer
+	e *Emulator
+}
+
+func (s *iamPolicy) GetIamPolicy(ctx context.Context, req *iampb.GetIamPolicyRequest) (*iampb.Policy, error) {
+	return s.e.KMS.GetIamPolicy(ctx, req)
+}
+
+func (s *iamPolicy) SetIamPolicy(ctx context.Context, req *iampb.SetIamPolicyRequest) (*iampb.Policy, error) {
+	return s.e.KMS.SetIamPolicy(ctx, req)
+}
+
+// iamAdmin serves service accounts from the fake
+type iamAdmin struct {
+	adminpb.UnimplementedIAMServer
+	e *Emulator
+}
+
+func (s *iamAdmin) GetServiceAccount(ctx context.Context, req *adminpb.GetServiceAccountRequest) (*adminpb.ServiceAccount, error) {
+	return s.e.IAM.GetServiceAccount(ctx, req)
+}
+
+func (s *iamAdmin) CreateServiceAccount(ctx context.Context, req *adminpb.CreateServiceAccountRequest) (*adminpb.ServiceAccount, error) {
+	return s.e.IAM.CreateServiceAccount(ctx, req)
+}
+
+func (s *iamAdmin) DeleteServiceAccount(ctx context.Context, req *adminpb.DeleteServiceAccountRequest) (*emptypb.Empty, error) {
+	if err := s.e.IAM.DeleteServiceAccount(ctx, req); err != nil {
+		return nil, err
+	}
+	return &emptypb.Empty{}, nil
+}
+
+// projects serves the projects added with AddProject and their IAM policies from the fake
+type projects struct {
+	resourcemanagerpb.UnimplementedProjectsServer
+	e *Emulator
+}
+
+func (s *projects) GetIamPolicy(ctx context.Context, req *iampb.GetIamPolicyRequest) (*iampb.Policy, error) {
+	return s.e.IAM.GetIamPolicy(ctx, req)
+}
+
+func (s *projects) SetIamPolicy(ctx context.Context, req *iampb.SetIamPolicyRequest) (*iampb.Policy, error) {
+	return s.e.IAM.SetIamPolicy(ctx, req)
+}
+
+func (s *projects) GetProject(ctx context.Context, req *resourcemanagerpb.GetProjectRequest) (*resourcemanagerpb.Project, error) {
+	id := lastSegment(req.GetName())
+	s.e.mu.Lock()
+	defer s.e.mu.Unlock()
+	number, ok := s.e.projects[id]
+	if !ok {
+		return nil, status.Errorf(codes.PermissionDenied, "project %s not found or permission denied", id)
+	}
+	return &resourcemanagerpb.Project{
+		Name:      fmt.Sprintf("projects/%d", number),
+		ProjectId: id,
+		State:     resourcemanagerpb.Project_ACTIVE,
+	}, nil
+}
+
+// serviceUsage records the services enabled in each project
+type serviceUsage struct {
+	serviceusagepb.UnimplementedServiceUsageServer
+	e *Emulator
+}
+
+func (s *serviceUsage) BatchEnableServices(ctx context.Context, req *serviceusagepb.BatchEnableServicesRequest) (*longrunning.Operation, error) {
+	resp := &serviceusagepb.BatchEnableServicesResponse{}
+	for _, id := range req.GetServiceIds() {
+		resp.Services = append(resp.Services, &serviceusagepb.Service{
+			Name:   fmt.Sprintf("%s/services/%s", req.GetParent(), id),
+			Parent: req.GetParent(),
+			State:  serviceusagepb.State_ENABLED,
+		})
+	}
+	response, err := anypb.New(resp)
+	if err != nil {
+		return nil, err
+	}
+	op, err := s.e.mutate("ServiceUsage.BatchEnableServices", func() error {
+		s.e.mu.Lock()
+		defer s.e.mu.Unlock()
+		enabled := map[string]bool{}
+		for _, id := range s.e.services[req.GetParent()] {
+			enabled[id] = true
+		}
+		for _, id := range req.GetServiceIds() {
+			if !enabled[id] {
+				s.e.services[req.GetParent()] = append(s.e.services[req.GetParent()], id)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	s.e.respond(op, response)
+	return longrunningOperation(op), nil
+}
+
+// operations serves the longrunning operations started by Service Usage
+type operations struct {
+	longrunning.UnimplementedOperationsServer
+	e *Emulator
+}
+
+func (s *operations) GetOperation(ctx context.Context, req *longrunning.GetOperationRequest) (*longrunning.Operation, error) {
+	op, ok := s.e.operation(lastSegment(req.GetName()))
+	if !ok {
+		return nil, status.Errorf(codes.NotFound, "%s not found", req.GetName())
+	}
+	return longrunningOperation(op), nil
+}
+
+func longrunningOperation(op *operation) *longrunning.Operation {
+	resp := &longrunning.Operation{Name: "operations/" + op.name}
+	if !op.done() {
+		return resp
+	}
+	resp.Done = true
+	if op.err != "" {
+		resp.Result = &longrunning.Operation_Error{
+			Error: &rpcstatus.Status{Code: int32(codes.Internal), Message: op.err},
+		}
+		return resp
+	}
+	resp.Result = &longrunning.Operation_Response{Response: op.response}
+	return resp
+}
@@ -0,0 +1,49 @@
+package google
+
+import (
+	"google.golang.org/api/option"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+// Endpoints override the GCP API endpoints, an empty endpoint uses the default
+type Endpoints struct {
+	// Compute is the base URL of the Compute REST API, e.g. http://localhost:8080
+	Compute string
+	// Container, IAM, KMS, ResourceManager and ServiceUsage are gRPC addresses, e.g.
+	// localhost:9090
+	Container       string
+	IAM             string
+	KMS             string
+	ResourceManager string
+	ServiceUsage    string
+	// Insecure disables TLS and authentication, for local emulators
+	Insecure bool
+}
+
+// restOptions returns the client options for a REST endpoint
+func (e Endpoints) restOptions(endpoint string) []option.ClientOption {
+	if endpoint == "" {
+		return nil
+	}
+	opts := []option.ClientOption{option.WithEndpoint(endpoint)}
+	if e.Insecure {
+		opts = append(opts, option.WithoutAuthentication())
+	}
+	return opts
+}
+
+// grpcOptions returns the client options for a gRPC endpoint
+func (e Endpoints) grpcOptions(endpoint string) []option.ClientOption {
+	if endpoint == "" {
+		return nil
+	}
+	opts := []option.ClientOption{option.WithEndpoint(endpoint)}
+	if e.Insecure {
+		opts = append(opts,
+			option.WithoutAuthentication(),
+			option.WithGRPCDialOption(grpc.WithTransportCredentials(insecure.NewCredentials())),
+		)
+	}
+	return opts
+}
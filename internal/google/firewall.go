@@ -2,9 +2,11 @@ package google
 
 import (
 	"context"
+	"fmt"
+	"tidalwave/internal/google/gcp"
+	"tidalwave/internal/tidalwave"
 
-	compute "cloud.google.com/go/compute/apiv1"
-	computepb "google.golang.org/genproto/googleapis/cloud/compute/v1"
+	computepb "cloud.google.com/go/compute/apiv1/computepb"
 )
 
 // Firewall represents a VPC firewall rule
@@ -21,23 +23,14 @@ type Firewall struct {
 }
 
 // Create firewall rule
-func (f *Firewall) create(ctx context.Context, client *compute.FirewallsClient) (*computepb.Firewall, error) {
+func (f *Firewall) create(ctx context.Context, client gcp.FirewallsClient) (*computepb.Firewall, error) {
 	if f.exists(ctx, client) {
 		return f.get(ctx, client)
 	}
 
 	req := &computepb.InsertFirewallRequest{
-		FirewallResource: &computepb.Firewall{
-			Allowed:           f.Allowed,
-			DestinationRanges: f.DestinationRanges,
-			Direction:         StrPtr(f.Direction),
-			Name:              StrPtr(f.Name),
-			Network:           StrPtr(f.Network),
-			SourceRanges:      f.SourceRanges,
-			SourceTags:        f.SourceTags,
-			TargetTags:        f.TargetTags,
-		},
-		Project: f.ProjectID,
+		FirewallResource: f.resource(),
+		Project:          f.ProjectID,
 	}
 
 	op, err := client.Insert(ctx, req)
@@ -46,7 +39,7 @@ func (f *Firewall) create(ctx context.Context, client *compute.FirewallsClient)
 		return nil, err
 	}
 
-	err = op.Wait(ctx)
+	err = waitCompute(ctx, op, fmt.Sprintf("create firewall %s", f.Name))
 
 	if err != nil {
 		return nil, err
@@ -56,7 +49,7 @@ func (f *Firewall) create(ctx context.Context, client *compute.FirewallsClient)
 }
 
 // Get firewall rule
-func (f *Firewall) get(ctx context.Context, client *compute.FirewallsClient) (*computepb.Firewall, error) {
+func (f *Firewall) get(ctx context.Context, client gcp.FirewallsClient) (*computepb.Firewall, error) {
 	req := &computepb.GetFirewallRequest{
 		Firewall: f.Name,
 		Project:  f.ProjectID,
@@ -69,13 +62,38 @@ func (f *Firewall) get(ctx context.Context, client *compute.FirewallsClient) (*c
 }
 
 // Check if firewall rule exists
-func (f *Firewall) exists(ctx context.Context, client *compute.FirewallsClient) bool {
+func (f *Firewall) exists(ctx context.Context, client gcp.FirewallsClient) bool {
 	_, err := f.get(ctx, client)
 	return err == nil
 }
 
+// Diff firewall rule against the config
+func (f *Firewall) diff(ctx context.Context, client gcp.FirewallsClient) (*tidalwave.ResourcePlan, error) {
+	rule, err := f.get(ctx, client)
+	if isNotFound(err) {
+		return tidalwave.NewResourcePlan("firewall", f.Name, false, nil), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return tidalwave.NewResourcePlan("firewall", f.Name, true, f.compare(rule)), nil
+}
+
+// Compare a live firewall rule with the config
+func (f *Firewall) compare(rule *computepb.Firewall) []tidalwave.Change {
+	d := differ{}
+	d.forceNew("network", resourceName(rule.GetNetwork()), resourceName(f.Network))
+	d.forceNew("direction", rule.GetDirection(), f.Direction)
+	d.field("allowed", allowedList(rule.GetAllowed()), allowedList(f.Allowed))
+	d.field("sourceRanges", list(rule.GetSourceRanges()), list(f.SourceRanges))
+	d.field("destinationRanges", list(rule.GetDestinationRanges()), list(f.DestinationRanges))
+	d.field("sourceTags", list(rule.GetSourceTags()), list(f.SourceTags))
+	d.field("targetTags", list(rule.GetTargetTags()), list(f.TargetTags))
+	return d.changes
+}
+
 // Delete firewall rule
-func (f *Firewall) delete(ctx context.Context, client *compute.FirewallsClient) error {
+func (f *Firewall) delete(ctx context.Context, client gcp.FirewallsClient) error {
 	if f.exists(ctx, client) {
 		req := &computepb.DeleteFirewallRequest{
 			Firewall: f.Name,
@@ -85,7 +103,7 @@ func (f *Firewall) delete(ctx context.Context, client *compute.FirewallsClient)
 		if err != nil {
 			return err
 		}
-		err = op.Wait(ctx)
+		err = waitCompute(ctx, op, fmt.Sprintf("delete firewall %s", f.Name))
 		if err != nil {
 			return err
 		}
@@ -93,34 +111,63 @@ func (f *Firewall) delete(ctx context.Context, client *compute.FirewallsClient)
 	return nil
 }
 
-// Update firewall rule
-func (f *Firewall) update(ctx context.Context, client *compute.FirewallsClient) (*computepb.Firewall, error) {
-	if f.exists(ctx, client) {
-		return f.get(ctx, client)
+// Update firewall rule, patching only the fields that drifted
+func (f *Firewall) update(ctx context.Context, client gcp.FirewallsClient) (*computepb.Firewall, error) {
+	rule, err := f.get(ctx, client)
+	if isNotFound(err) {
+		return f.create(ctx, client)
 	}
-
-	req := &computepb.PatchFirewallRequest{
-		FirewallResource: &computepb.Firewall{
-			Allowed:           f.Allowed,
-			DestinationRanges: f.DestinationRanges,
-			Direction:         StrPtr(f.Direction),
-			Name:              StrPtr(f.Name),
-			Network:           StrPtr(f.Network),
-			SourceRanges:      f.SourceRanges,
-			SourceTags:        f.SourceTags,
-			TargetTags:        f.TargetTags,
-		},
-		Project:  f.ProjectID,
-		Firewall: f.Name,
+	if err != nil {
+		return nil, err
+	}
+	changes := f.compare(rule)
+	if err := forceNewError("firewall", f.Name, changes); err != nil {
+		return nil, err
+	}
+	if len(changes) == 0 {
+		return rule, nil
 	}
 
-	op, err := client.Patch(ctx, req)
+	// patch cannot clear a list, so fall back to replacing the whole rule when one is emptied
+	patch := &computepb.Firewall{}
+	replace := false
+	for _, c := range changes {
+		switch c.Field {
+		case "allowed":
+			patch.Allowed = f.Allowed
+		case "sourceRanges":
+			patch.SourceRanges = f.SourceRanges
+		case "destinationRanges":
+			patch.DestinationRanges = f.DestinationRanges
+		case "sourceTags":
+			patch.SourceTags = f.SourceTags
+		case "targetTags":
+			patch.TargetTags = f.TargetTags
+		}
+		if c.Desired == "" {
+			replace = true
+		}
+	}
 
+	var op gcp.Operation
+	if replace {
+		op, err = client.Update(ctx, &computepb.UpdateFirewallRequest{
+			FirewallResource: f.resource(),
+			Project:          f.ProjectID,
+			Firewall:         f.Name,
+		})
+	} else {
+		op, err = client.Patch(ctx, &computepb.PatchFirewallRequest{
+			FirewallResource: patch,
+			Project:          f.ProjectID,
+			Firewall:         f.Name,
+		})
+	}
 	if err != nil {
 		return nil, err
 	}
 
-	err = op.Wait(ctx)
+	err = waitCompute(ctx, op, fmt.Sprintf("update firewall %s", f.Name))
 
 	if err != nil {
 		return nil, err
@@ -128,3 +175,17 @@ func (f *Firewall) update(ctx context.Context, client *compute.FirewallsClient)
 
 	return f.get(ctx, client)
 }
+
+// resource returns the firewall rule as described by the config
+func (f *Firewall) resource() *computepb.Firewall {
+	return &computepb.Firewall{
+		Allowed:           f.Allowed,
+		DestinationRanges: f.DestinationRanges,
+		Direction:         StrPtr(f.Direction),
+		Name:              StrPtr(f.Name),
+		Network:           StrPtr(f.Network),
+		SourceRanges:      f.SourceRanges,
+		SourceTags:        f.SourceTags,
+		TargetTags:        f.TargetTags,
+	}
+}
@@ -0,0 +1,128 @@
+package gcp
+
+import (
+	"context"
+
+	compute "cloud.google.com/go/compute/apiv1"
+	computepb "cloud.google.com/go/compute/apiv1/computepb"
+	"github.com/googleapis/gax-go/v2"
+)
+
+// The compute clients return a concrete *compute.Operation, these adapters return it as an
+// Operation so the clients satisfy the interfaces above
+
+// operation converts a compute operation, keeping a nil operation nil
+func operation(op *compute.Operation, err error) (Operation, error) {
+	if err != nil {
+		return nil, err
+	}
+	return op, nil
+}
+
+// Networks adapts a compute networks client
+func Networks(c *compute.NetworksClient) NetworksClient {
+	return networks{c}
+}
+
+type networks struct {
+	c *compute.NetworksClient
+}
+
+func (n networks) Get(ctx context.Context, req *computepb.GetNetworkRequest, opts ...gax.CallOption) (*computepb.Network, error) {
+	return n.c.Get(ctx, req, opts...)
+}
+
+func (n networks) Insert(ctx context.Context, req *computepb.InsertNetworkRequest, opts ...gax.CallOption) (Operation, error) {
+	return operation(n.c.Insert(ctx, req, opts...))
+}
+
+func (n networks) Delete(ctx context.Context, req *computepb.DeleteNetworkRequest, opts ...gax.CallOption) (Operation, error) {
+	return operation(n.c.Delete(ctx, req, opts...))
+}
+
+// Subnetworks adapts a compute subnetworks client
+func Subnetworks(c *compute.SubnetworksClient) SubnetworksClient {
+	return subnetworks{c}
+}
+
+type subnetworks struct {
+	c *compute.SubnetworksClient
+}
+
+func (s subnetworks) Get(ctx context.Context, req *computepb.GetSubnetworkRequest, opts ...gax.CallOption) (*computepb.Subnetwork, error) {
+	return s.c.Get(ctx, req, opts...)
+}
+
+func (s subnetworks) Insert(ctx context.Context, req *computepb.InsertSubnetworkRequest, opts ...gax.CallOption) (Operation, error) {
+	return operation(s.c.Insert(ctx, req, opts...))
+}
+
+func (s subnetworks) Delete(ctx context.Context, req *computepb.DeleteSubnetworkRequest, opts ...gax.CallOption) (Operation, error) {
+	return operation(s.c.Delete(ctx, req, opts...))
+}
+
+func (s subnetworks) Patch(ctx context.Context, req *computepb.PatchSubnetworkRequest, opts ...gax.CallOption) (Operation, error) {
+	return operation(s.c.Patch(ctx, req, opts...))
+}
+
+func (s subnetworks) ExpandIpCidrRange(ctx context.Context, req *computepb.ExpandIpCidrRangeSubnetworkRequest, opts ...gax.CallOption) (Operation, error) {
+	return operation(s.c.ExpandIpCidrRange(ctx, req, opts...))
+}
+
+func (s subnetworks) SetPrivateIpGoogleAccess(ctx context.Context, req *computepb.SetPrivateIpGoogleAccessSubnetworkRequest, opts ...gax.CallOption) (Operation, error) {
+	return operation(s.c.SetPrivateIpGoogleAccess(ctx, req, opts...))
+}
+
+// Routers adapts a compute routers client
+func Routers(c *compute.RoutersClient) RoutersClient {
+	return routers{c}
+}
+
+type routers struct {
+	c *compute.RoutersClient
+}
+
+func (r routers) Get(ctx context.Context, req *computepb.GetRouterRequest, opts ...gax.CallOption) (*computepb.Router, error) {
+	return r.c.Get(ctx, req, opts...)
+}
+
+func (r routers) Insert(ctx context.Context, req *computepb.InsertRouterRequest, opts ...gax.CallOption) (Operation, error) {
+	return operation(r.c.Insert(ctx, req, opts...))
+}
+
+func (r routers) Delete(ctx context.Context, req *computepb.DeleteRouterRequest, opts ...gax.CallOption) (Operation, error) {
+	return operation(r.c.Delete(ctx, req, opts...))
+}
+
+func (r routers) Patch(ctx context.Context, req *computepb.PatchRouterRequest, opts ...gax.CallOption) (Operation, error) {
+	return operation(r.c.Patch(ctx, req, opts...))
+}
+
+// Firewalls adapts a compute firewalls client
+func Firewalls(c *compute.FirewallsClient) FirewallsClient {
+	return firewalls{c}
+}
+
+type firewalls struct {
+	c *compute.FirewallsClient
+}
+
+func (f firewalls) Get(ctx context.Context, req *computepb.GetFirewallRequest, opts ...gax.CallOption) (*computepb.Firewall, error) {
+	return f.c.Get(ctx, req, opts...)
+}
+
+func (f firewalls) Insert(ctx context.Context, req *computepb.InsertFirewallRequest, opts ...gax.CallOption) (Operation, error) {
+	return operation(f.c.Insert(ctx, req, opts...))
+}
+
+func (f firewalls) Delete(ctx context.Context, req *computepb.DeleteFirewallRequest, opts ...gax.CallOption) (Operation, error) {
+	return operation(f.c.Delete(ctx, req, opts...))
+}
+
+func (f firewalls) Patch(ctx context.Context, req *computepb.PatchFirewallRequest, opts ...gax.CallOption) (Operation, error) {
+	return operation(f.c.Patch(ctx, req, opts...))
+}
+
+func (f firewalls) Update(ctx context.Context, req *computepb.UpdateFirewallRequest, opts ...gax.CallOption) (Operation, error) {
+	return operation(f.c.Update(ctx, req, opts...))
+}
@@ -0,0 +1,113 @@
+/*
+Package gcp declares the parts of the GCP API clients tidalwave uses, so the google package
+can run against the real clients or the fakes in googletest
+*/
+package gcp
+
+import (
+	"context"
+
+	computepb "cloud.google.com/go/compute/apiv1/computepb"
+	container "cloud.google.com/go/container/apiv1"
+	containerpb "cloud.google.com/go/container/apiv1/containerpb"
+	admin "cloud.google.com/go/iam/admin/apiv1"
+	adminpb "cloud.google.com/go/iam/admin/apiv1/adminpb"
+	iampb "cloud.google.com/go/iam/apiv1/iampb"
+	kms "cloud.google.com/go/kms/apiv1"
+	kmspb "cloud.google.com/go/kms/apiv1/kmspb"
+	resourcemanager "cloud.google.com/go/resourcemanager/apiv3"
+	"github.com/googleapis/gax-go/v2"
+)
+
+// Operation is a compute operation that can be waited on. Wait only fails if the operation
+// could not be polled, an operation that failed is reported in Proto().Error
+type Operation interface {
+	Name() string
+	Wait(ctx context.Context, opts ...gax.CallOption) error
+	Proto() *computepb.Operation
+}
+
+// NetworksClient manages VPC networks
+type NetworksClient interface {
+	Get(ctx context.Context, req *computepb.GetNetworkRequest, opts ...gax.CallOption) (*computepb.Network, error)
+	Insert(ctx context.Context, req *computepb.InsertNetworkRequest, opts ...gax.CallOption) (Operation, error)
+	Delete(ctx context.Context, req *computepb.DeleteNetworkRequest, opts ...gax.CallOption) (Operation, error)
+}
+
+// SubnetworksClient manages VPC subnetworks
+type SubnetworksClient interface {
+	Get(ctx context.Context, req *computepb.GetSubnetworkRequest, opts ...gax.CallOption) (*computepb.Subnetwork, error)
+	Insert(ctx context.Context, req *computepb.InsertSubnetworkRequest, opts ...gax.CallOption) (Operation, error)
+	Delete(ctx context.Context, req *computepb.DeleteSubnetworkRequest, opts ...gax.CallOption) (Operation, error)
+	Patch(ctx context.Context, req *computepb.PatchSubnetworkRequest, opts ...gax.CallOption) (Operation, error)
+	ExpandIpCidrRange(ctx context.Context, req *computepb.ExpandIpCidrRangeSubnetworkRequest, opts ...gax.CallOption) (Operation, error)
+	SetPrivateIpGoogleAccess(ctx context.Context, req *computepb.SetPrivateIpGoogleAccessSubnetworkRequest, opts ...gax.CallOption) (Operation, error)
+}
+
+// RoutersClient manages Cloud Routers and their NATs
+type RoutersClient interface {
+	Get(ctx context.Context, req *computepb.GetRouterRequest, opts ...gax.CallOption) (*computepb.Router, error)
+	Insert(ctx context.Context, req *computepb.InsertRouterRequest, opts ...gax.CallOption) (Operation, error)
+	Delete(ctx context.Context, req *computepb.DeleteRouterRequest, opts ...gax.CallOption) (Operation, error)
+	Patch(ctx context.Context, req *computepb.PatchRouterRequest, opts ...gax.CallOption) (Operation, error)
+}
+
+// FirewallsClient manages VPC firewall rules
+type FirewallsClient interface {
+	Get(ctx context.Context, req *computepb.GetFirewallRequest, opts ...gax.CallOption) (*computepb.Firewall, error)
+	Insert(ctx context.Context, req *computepb.InsertFirewallRequest, opts ...gax.CallOption) (Operation, error)
+	Delete(ctx context.Context, req *computepb.DeleteFirewallRequest, opts ...gax.CallOption) (Operation, error)
+	Patch(ctx context.Context, req *computepb.PatchFirewallRequest, opts ...gax.CallOption) (Operation, error)
+	Update(ctx context.Context, req *computepb.UpdateFirewallRequest, opts ...gax.CallOption) (Operation, error)
+}
+
+// KMSClient manages KMS keyrings, crypto keys and their IAM policies
+type KMSClient interface {
+	GetKeyRing(ctx context.Context, req *kmspb.GetKeyRingRequest, opts ...gax.CallOption) (*kmspb.KeyRing, error)
+	CreateKeyRing(ctx context.Context, req *kmspb.CreateKeyRingRequest, opts ...gax.CallOption) (*kmspb.KeyRing, error)
+	GetCryptoKey(ctx context.Context, req *kmspb.GetCryptoKeyRequest, opts ...gax.CallOption) (*kmspb.CryptoKey, error)
+	CreateCryptoKey(ctx context.Context, req *kmspb.CreateCryptoKeyRequest, opts ...gax.CallOption) (*kmspb.CryptoKey, error)
+	GetCryptoKeyVersion(ctx context.Context, req *kmspb.GetCryptoKeyVersionRequest, opts ...gax.CallOption) (*kmspb.CryptoKeyVersion, error)
+	CreateCryptoKeyVersion(ctx context.Context, req *kmspb.CreateCryptoKeyVersionRequest, opts ...gax.CallOption) (*kmspb.CryptoKeyVersion, error)
+	UpdateCryptoKeyVersion(ctx context.Context, req *kmspb.UpdateCryptoKeyVersionRequest, opts ...gax.CallOption) (*kmspb.CryptoKeyVersion, error)
+	UpdateCryptoKeyPrimaryVersion(ctx context.Context, req *kmspb.UpdateCryptoKeyPrimaryVersionRequest, opts ...gax.CallOption) (*kmspb.CryptoKey, error)
+	RestoreCryptoKeyVersion(ctx context.Context, req *kmspb.RestoreCryptoKeyVersionRequest, opts ...gax.CallOption) (*kmspb.CryptoKeyVersion, error)
+	GetIamPolicy(ctx context.Context, req *iampb.GetIamPolicyRequest, opts ...gax.CallOption) (*iampb.Policy, error)
+	SetIamPolicy(ctx context.Context, req *iampb.SetIamPolicyRequest, opts ...gax.CallOption) (*iampb.Policy, error)
+}
+
+// ContainerClient manages GKE clusters, node pools and their operations
+type ContainerClient interface {
+	GetCluster(ctx context.Context, req *containerpb.GetClusterRequest, opts ...gax.CallOption) (*containerpb.Cluster, error)
+	CreateCluster(ctx context.Context, req *containerpb.CreateClusterRequest, opts ...gax.CallOption) (*containerpb.Operation, error)
+	UpdateCluster(ctx context.Context, req *containerpb.UpdateClusterRequest, opts ...gax.CallOption) (*containerpb.Operation, error)
+	DeleteCluster(ctx context.Context, req *containerpb.DeleteClusterRequest, opts ...gax.CallOption) (*containerpb.Operation, error)
+	SetMaintenancePolicy(ctx context.Context, req *containerpb.SetMaintenancePolicyRequest, opts ...gax.CallOption) (*containerpb.Operation, error)
+	CreateNodePool(ctx context.Context, req *containerpb.CreateNodePoolRequest, opts ...gax.CallOption) (*containerpb.Operation, error)
+	UpdateNodePool(ctx context.Context, req *containerpb.UpdateNodePoolRequest, opts ...gax.CallOption) (*containerpb.Operation, error)
+	SetNodePoolAutoscaling(ctx context.Context, req *containerpb.SetNodePoolAutoscalingRequest, opts ...gax.CallOption) (*containerpb.Operation, error)
+	SetNodePoolManagement(ctx context.Context, req *containerpb.SetNodePoolManagementRequest, opts ...gax.CallOption) (*containerpb.Operation, error)
+	DeleteNodePool(ctx context.Context, req *containerpb.DeleteNodePoolRequest, opts ...gax.CallOption) (*containerpb.Operation, error)
+	GetOperation(ctx context.Context, req *containerpb.GetOperationRequest, opts ...gax.CallOption) (*containerpb.Operation, error)
+	GetServerConfig(ctx context.Context, req *containerpb.GetServerConfigRequest, opts ...gax.CallOption) (*containerpb.ServerConfig, error)
+}
+
+// IAMClient manages IAM service accounts
+type IAMClient interface {
+	GetServiceAccount(ctx context.Context, req *adminpb.GetServiceAccountRequest, opts ...gax.CallOption) (*adminpb.ServiceAccount, error)
+	CreateServiceAccount(ctx context.Context, req *adminpb.CreateServiceAccountRequest, opts ...gax.CallOption) (*adminpb.ServiceAccount, error)
+	DeleteServiceAccount(ctx context.Context, req *adminpb.DeleteServiceAccountRequest, opts ...gax.CallOption) error
+}
+
+// ProjectsClient manages the IAM policy of projects
+type ProjectsClient interface {
+	GetIamPolicy(ctx context.Context, req *iampb.GetIamPolicyRequest, opts ...gax.CallOption) (*iampb.Policy, error)
+	SetIamPolicy(ctx context.Context, req *iampb.SetIamPolicyRequest, opts ...gax.CallOption) (*iampb.Policy, error)
+}
+
+var (
+	_ KMSClient       = (*kms.KeyManagementClient)(nil)
+	_ ContainerClient = (*container.ClusterManagerClient)(nil)
+	_ IAMClient       = (*admin.IamClient)(nil)
+	_ ProjectsClient  = (*resourcemanager.ProjectsClient)(nil)
+)
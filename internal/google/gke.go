@@ -2,16 +2,12 @@ package google
 
 import (
 	"context"
-	"errors"
 	"fmt"
-	"github.com/kyokomi/emoji/v2"
-	"time"
+	"tidalwave/internal/google/gcp"
+	"tidalwave/internal/tidalwave"
 
-	container "cloud.google.com/go/container/apiv1"
-	containerpb "google.golang.org/genproto/googleapis/container/v1"
+	containerpb "cloud.google.com/go/container/apiv1/containerpb"
 )
-//counter is used to check status
-var counter int
 
 // Cluster represents a GKE cluster
 type Cluster struct {
@@ -21,88 +17,138 @@ type Cluster struct {
 	CryptoKeyName        string
 	Network              string
 	Subnetwork           string
-	MachineType          string
-	DiskSizeGb           int32
-	MinNodeCount         int32
-	MaxNodeCount         int32
 	MasterAuthCidrBlocks []*containerpb.MasterAuthorizedNetworksConfig_CidrBlock
 	MasterIpv4CidrBlock  string
+	// Version is the GKE version the master is created with and pinned to, such as 1.24 or
+	// 1.24.5-gke.600, the default of the release channel if empty. A pinned cluster is not
+	// enrolled in a release channel and its node pools do not auto-upgrade. Update does not
+	// move the cluster to a new version, Upgrade does.
+	Version string
+	// ReleaseChannel is the GKE release channel, RAPID if unspecified and the cluster is not
+	// pinned to a version
+	ReleaseChannel containerpb.ReleaseChannel_Channel
+	// Datapath is the networking datapath, it cannot be changed once the cluster exists
+	Datapath containerpb.DatapathProvider
+	// NodePools are the node pools of the cluster, pools of the live cluster that are not
+	// listed are deleted when it is updated
+	NodePools []NodePool
+	// ServiceAccount is the email the nodes of pools without their own service account run as
+	ServiceAccount string
+	// Autopilot creates an Autopilot cluster, GKE manages its nodes so NodePools are ignored.
+	// It cannot be changed once the cluster exists.
+	Autopilot bool
+	// Addons are the GKE add-ons enabled on the cluster
+	Addons Addons
+	// BinaryAuthorization only lets the cluster run images Binary Authorization allows
+	BinaryAuthorization bool
+	// MaintenancePolicy is when GKE may upgrade the cluster, a daily window at 06:00 UTC if nil
+	MaintenancePolicy *containerpb.MaintenancePolicy
+	// LoggingComponents and MonitoringComponents are sent to Cloud Logging and Cloud
+	// Monitoring, the GKE defaults are left alone if they are nil
+	LoggingComponents    []containerpb.LoggingComponentConfig_Component
+	MonitoringComponents []containerpb.MonitoringComponentConfig_Component
+	// ImageStreaming streams container images to the nodes of Standard clusters
+	ImageStreaming bool
+	// WorkloadMetadata is the metadata server the pods of every node pool see, GKE_METADATA
+	// for Workload Identity if unspecified
+	WorkloadMetadata containerpb.WorkloadMetadataConfig_Mode
+	// CostAllocation breaks the cluster's costs down by namespace and label in billing exports
+	CostAllocation bool
+	// GatewayAPI is the channel of the Gateway API CRDs GKE installs, CHANNEL_DISABLED if
+	// unspecified
+	GatewayAPI containerpb.GatewayAPIConfig_Channel
+	// SecurityPosture and VulnerabilityScanning are the modes of the security posture
+	// dashboard, the GKE defaults are left alone if SecurityPosture is unspecified
+	SecurityPosture       containerpb.SecurityPostureConfig_Mode
+	VulnerabilityScanning containerpb.SecurityPostureConfig_VulnerabilityMode
+}
+
+// Addons are the GKE add-ons of a cluster, Autopilot always runs the CSI drivers and has no
+// Config Connector so those are ignored for Autopilot clusters
+type Addons struct {
+	HttpLoadBalancing          bool
+	HorizontalPodAutoscaling   bool
+	ConfigConnector            bool
+	GcePersistentDiskCsiDriver bool
+	GcpFilestoreCsiDriver      bool
+}
+
+// mode is how the cluster is shown in plans, autopilot or standard
+func mode(autopilot bool) string {
+	if autopilot {
+		return "autopilot"
+	}
+	return "standard"
+}
+
+// pools returns the node pools with the cluster service account filled in, Autopilot clusters
+// have none that tidalwave manages
+func (c *Cluster) pools() []NodePool {
+	if c.Autopilot {
+		return nil
+	}
+	pools := append([]NodePool{}, c.NodePools...)
+	for i := range pools {
+		if pools[i].ServiceAccount == "" {
+			pools[i].ServiceAccount = c.ServiceAccount
+		}
+		pools[i].pinned = c.Version != ""
+		pools[i].WorkloadMetadata = c.WorkloadMetadata
+	}
+	return pools
+}
+
+// releaseChannel returns the configured release channel, a cluster pinned to a version is not
+// enrolled in one as GKE would upgrade it past the version
+func (c *Cluster) releaseChannel() containerpb.ReleaseChannel_Channel {
+	if c.Version != "" {
+		return containerpb.ReleaseChannel_UNSPECIFIED
+	}
+	if c.ReleaseChannel == containerpb.ReleaseChannel_UNSPECIFIED {
+		return containerpb.ReleaseChannel_RAPID
+	}
+	return c.ReleaseChannel
+}
+
+// gatewayChannel returns the Gateway API channel, GKE reports clusters without the Gateway API
+// as unspecified
+func gatewayChannel(ch containerpb.GatewayAPIConfig_Channel) containerpb.GatewayAPIConfig_Channel {
+	if ch == containerpb.GatewayAPIConfig_CHANNEL_UNSPECIFIED {
+		return containerpb.GatewayAPIConfig_CHANNEL_DISABLED
+	}
+	return ch
+}
+
+// datapath returns the datapath, GKE reports clusters created without one as legacy
+func datapath(d containerpb.DatapathProvider) containerpb.DatapathProvider {
+	if d == containerpb.DatapathProvider_DATAPATH_PROVIDER_UNSPECIFIED {
+		return containerpb.DatapathProvider_LEGACY_DATAPATH
+	}
+	return d
 }
 
 // Create GKE cluster
-func (c *Cluster) create(ctx context.Context, client *container.ClusterManagerClient) (*containerpb.Cluster, error) {
+func (c *Cluster) create(ctx context.Context, client gcp.ContainerClient) (*containerpb.Cluster, error) {
 	if c.exists(ctx, client) {
 		return c.get(ctx, client)
 	}
 
 	req := &containerpb.CreateClusterRequest{
 		Cluster: &containerpb.Cluster{
-			Name:    c.Name,
-			Network: c.Network,
-			AddonsConfig: &containerpb.AddonsConfig{
-				HttpLoadBalancing: &containerpb.HttpLoadBalancing{
-					Disabled: false,
-				},
-				HorizontalPodAutoscaling: &containerpb.HorizontalPodAutoscaling{
-					Disabled: false,
-				},
-				ConfigConnectorConfig: &containerpb.ConfigConnectorConfig{
-					Enabled: true,
-				},
-				GcePersistentDiskCsiDriverConfig: &containerpb.GcePersistentDiskCsiDriverConfig{
-					Enabled: true,
-				},
-				GcpFilestoreCsiDriverConfig: &containerpb.GcpFilestoreCsiDriverConfig{
-					Enabled: true,
-				},
-			},
+			Name:                  c.Name,
+			InitialClusterVersion: c.Version,
+			Network:               c.Network,
+			AddonsConfig:          c.addonsConfig(),
 			DatabaseEncryption: &containerpb.DatabaseEncryption{
 				State:   containerpb.DatabaseEncryption_ENCRYPTED,
 				KeyName: c.CryptoKeyName,
 			},
 			Subnetwork: c.Subnetwork,
-			NodePools: []*containerpb.NodePool{
-				{
-					Name: "default-pool",
-					Config: &containerpb.NodeConfig{
-						MachineType: c.MachineType,
-						DiskSizeGb:  c.DiskSizeGb,
-						OauthScopes: []string{
-							"https://www.googleapis.com/auth/devstorage.read_only",
-							"https://www.googleapis.com/auth/logging.write",
-							"https://www.googleapis.com/auth/monitoring",
-							"https://www.googleapis.com/auth/servicecontrol",
-							"https://www.googleapis.com/auth/service.management.readonly",
-							"https://www.googleapis.com/auth/trace.append",
-							"https://www.googleapis.com/auth/cloud-platform",
-						},
-						Tags: []string{
-							"default-pool",
-						},
-						DiskType: "pd-ssd",
-						WorkloadMetadataConfig: &containerpb.WorkloadMetadataConfig{
-							Mode: 2,
-						},
-						ShieldedInstanceConfig: &containerpb.ShieldedInstanceConfig{
-							EnableSecureBoot: true,
-						},
-					},
-					InitialNodeCount: 1,
-					Autoscaling: &containerpb.NodePoolAutoscaling{
-						Enabled:      true,
-						MinNodeCount: c.MinNodeCount,
-						MaxNodeCount: c.MaxNodeCount,
-					},
-					Management: &containerpb.NodeManagement{
-						AutoUpgrade: true,
-						AutoRepair:  true,
-					},
-					UpgradeSettings: &containerpb.NodePool_UpgradeSettings{
-						MaxSurge:       1,
-						MaxUnavailable: 1,
-					},
-				},
+			NodePools:  c.nodePools(),
+			Autopilot: &containerpb.Autopilot{
+				Enabled: c.Autopilot,
 			},
+			Autoscaling: c.autoscaling(),
 			IpAllocationPolicy: &containerpb.IPAllocationPolicy{
 				UseIpAliases:               true,
 				ClusterSecondaryRangeName:  "pods",
@@ -112,31 +158,29 @@ func (c *Cluster) create(ctx context.Context, client *container.ClusterManagerCl
 				Enabled:    true,
 				CidrBlocks: c.MasterAuthCidrBlocks,
 			},
-			MaintenancePolicy: &containerpb.MaintenancePolicy{
-				Window: &containerpb.MaintenanceWindow{
-					Policy: &containerpb.MaintenanceWindow_DailyMaintenanceWindow{
-						DailyMaintenanceWindow: &containerpb.DailyMaintenanceWindow{
-							StartTime: "06:00",
-						},
-					},
-				},
-			},
+			MaintenancePolicy: c.maintenancePolicy(),
 			BinaryAuthorization: &containerpb.BinaryAuthorization{
-				Enabled: true,
+				Enabled: c.BinaryAuthorization,
 			},
+			LoggingConfig:    c.loggingConfig(),
+			MonitoringConfig: c.monitoringConfig(),
+			NodePoolDefaults: c.nodePoolDefaults(),
 			NetworkConfig: &containerpb.NetworkConfig{
 				EnableIntraNodeVisibility: true,
-				DatapathProvider:          0,
+				DatapathProvider:          c.Datapath,
+				GatewayApiConfig:          c.gatewayAPIConfig(),
+			},
+			CostManagementConfig: &containerpb.CostManagementConfig{
+				Enabled: c.CostAllocation,
 			},
+			SecurityPostureConfig: c.securityPostureConfig(),
 			PrivateClusterConfig: &containerpb.PrivateClusterConfig{
 				EnablePrivateNodes:  true,
 				MasterIpv4CidrBlock: c.MasterIpv4CidrBlock,
 			},
-			ShieldedNodes: &containerpb.ShieldedNodes{
-				Enabled: true,
-			},
+			ShieldedNodes: c.shieldedNodes(),
 			ReleaseChannel: &containerpb.ReleaseChannel{
-				Channel: 1,
+				Channel: c.releaseChannel(),
 			},
 			WorkloadIdentityConfig: &containerpb.WorkloadIdentityConfig{
 				WorkloadPool: fmt.Sprintf("%s.svc.id.goog", c.ProjectID),
@@ -151,33 +195,155 @@ func (c *Cluster) create(ctx context.Context, client *container.ClusterManagerCl
 		return nil, err
 	}
 
-status:
-	for {
-		if counter%10==0 {
-			emoji.Println(":beer: Cluster is being created")
-			time.Sleep(time.Second*30)
-		}
-		s, err := client.GetOperation(ctx, &containerpb.GetOperationRequest{
-			Name: fmt.Sprintf("projects/%s/locations/%s/operations/%s", c.ProjectID, c.Region, op.GetName()),
-		})
-		if err != nil {
-			return nil, err
-		}
-		switch s.GetStatus().Number() {
-		case 3:
-			break status
-		case 4:
-			return nil, errors.New(s.GetError().Message)
-		}
-		counter++
+	if err := c.wait(ctx, client, op, ":beer:", "Cluster is being created"); err != nil {
+		return nil, err
 	}
 	return c.get(ctx, client)
 }
 
+// autoscaling returns the defaults of the node pools Autopilot provisions, nil for Standard
+// clusters whose pools are configured one by one
+func (c *Cluster) autoscaling() *containerpb.ClusterAutoscaling {
+	if !c.Autopilot {
+		return nil
+	}
+	return &containerpb.ClusterAutoscaling{
+		AutoprovisioningNodePoolDefaults: &containerpb.AutoprovisioningNodePoolDefaults{
+			ServiceAccount: c.ServiceAccount,
+		},
+	}
+}
+
+// shieldedNodes returns the shielded nodes config, Autopilot always uses shielded nodes and
+// rejects the setting
+func (c *Cluster) shieldedNodes() *containerpb.ShieldedNodes {
+	if c.Autopilot {
+		return nil
+	}
+	return &containerpb.ShieldedNodes{
+		Enabled: true,
+	}
+}
+
+// addonsConfig returns the GKE addons enabled on the cluster, Autopilot manages the CSI drivers
+// itself and does not support Config Connector
+func (c *Cluster) addonsConfig() *containerpb.AddonsConfig {
+	config := &containerpb.AddonsConfig{
+		HttpLoadBalancing: &containerpb.HttpLoadBalancing{
+			Disabled: !c.Addons.HttpLoadBalancing,
+		},
+		HorizontalPodAutoscaling: &containerpb.HorizontalPodAutoscaling{
+			Disabled: !c.Addons.HorizontalPodAutoscaling,
+		},
+	}
+	if c.Autopilot {
+		return config
+	}
+	config.ConfigConnectorConfig = &containerpb.ConfigConnectorConfig{
+		Enabled: c.Addons.ConfigConnector,
+	}
+	config.GcePersistentDiskCsiDriverConfig = &containerpb.GcePersistentDiskCsiDriverConfig{
+		Enabled: c.Addons.GcePersistentDiskCsiDriver,
+	}
+	config.GcpFilestoreCsiDriverConfig = &containerpb.GcpFilestoreCsiDriverConfig{
+		Enabled: c.Addons.GcpFilestoreCsiDriver,
+	}
+	return config
+}
+
+// loggingConfig returns the components sent to Cloud Logging, nil leaves the GKE default
+func (c *Cluster) loggingConfig() *containerpb.LoggingConfig {
+	if c.LoggingComponents == nil {
+		return nil
+	}
+	return &containerpb.LoggingConfig{
+		ComponentConfig: &containerpb.LoggingComponentConfig{
+			EnableComponents: c.LoggingComponents,
+		},
+	}
+}
+
+// monitoringConfig returns the components sent to Cloud Monitoring, nil leaves the GKE default
+func (c *Cluster) monitoringConfig() *containerpb.MonitoringConfig {
+	if c.MonitoringComponents == nil {
+		return nil
+	}
+	return &containerpb.MonitoringConfig{
+		ComponentConfig: &containerpb.MonitoringComponentConfig{
+			EnableComponents: c.MonitoringComponents,
+		},
+	}
+}
+
+// gatewayAPIConfig returns the Gateway API channel of the cluster
+func (c *Cluster) gatewayAPIConfig() *containerpb.GatewayAPIConfig {
+	return &containerpb.GatewayAPIConfig{
+		Channel: gatewayChannel(c.GatewayAPI),
+	}
+}
+
+// securityPostureConfig returns the security posture modes, nil leaves the GKE default
+func (c *Cluster) securityPostureConfig() *containerpb.SecurityPostureConfig {
+	if c.SecurityPosture == containerpb.SecurityPostureConfig_MODE_UNSPECIFIED {
+		return nil
+	}
+	vulnerability := c.VulnerabilityScanning
+	if vulnerability == containerpb.SecurityPostureConfig_VULNERABILITY_MODE_UNSPECIFIED {
+		vulnerability = containerpb.SecurityPostureConfig_VULNERABILITY_DISABLED
+	}
+	return &containerpb.SecurityPostureConfig{
+		Mode:              c.SecurityPosture.Enum(),
+		VulnerabilityMode: vulnerability.Enum(),
+	}
+}
+
+// securityPostureList formats the security posture modes of a cluster
+func securityPostureList(s *containerpb.SecurityPostureConfig) string {
+	return fmt.Sprintf("mode=%s,vulnerabilityMode=%s", s.GetMode(), s.GetVulnerabilityMode())
+}
+
+// nodePoolDefaults returns the settings new node pools of Standard clusters inherit
+func (c *Cluster) nodePoolDefaults() *containerpb.NodePoolDefaults {
+	if c.Autopilot {
+		return nil
+	}
+	return &containerpb.NodePoolDefaults{
+		NodeConfigDefaults: &containerpb.NodeConfigDefaults{
+			GcfsConfig: &containerpb.GcfsConfig{
+				Enabled: c.ImageStreaming,
+			},
+		},
+	}
+}
+
+// nodePools returns the node pools the cluster is created with, none for Autopilot
+func (c *Cluster) nodePools() []*containerpb.NodePool {
+	pools := []*containerpb.NodePool{}
+	for _, p := range c.pools() {
+		pools = append(pools, p.nodePool())
+	}
+	return pools
+}
+
+// Full resource name of the GKE cluster
+func (c *Cluster) name() string {
+	return fmt.Sprintf("projects/%s/locations/%s/clusters/%s", c.ProjectID, c.Region, c.Name)
+}
+
+// wait polls a GKE operation until it is done, the emoji and description are those of the
+// started event
+func (c *Cluster) wait(ctx context.Context, client gcp.ContainerClient, op *containerpb.Operation, emoji, description string) error {
+	name := fmt.Sprintf("projects/%s/locations/%s/operations/%s", c.ProjectID, c.Region, op.GetName())
+	tidalwave.Emit(ctx, tidalwave.Event{Phase: tidalwave.PhaseStarted, Operation: name, Emoji: emoji, Message: description})
+	return newWaiter().wait(ctx, name, description, pollGke(name, func(ctx context.Context) (*containerpb.Operation, error) {
+		return client.GetOperation(ctx, &containerpb.GetOperationRequest{Name: name})
+	}))
+}
+
 // Get GKE cluster
-func (c *Cluster) get(ctx context.Context, client *container.ClusterManagerClient) (*containerpb.Cluster, error) {
+func (c *Cluster) get(ctx context.Context, client gcp.ContainerClient) (*containerpb.Cluster, error) {
 	req := &containerpb.GetClusterRequest{
-		Name: fmt.Sprintf("projects/%s/locations/%s/clusters/%s", c.ProjectID, c.Region, c.Name),
+		Name: c.name(),
 	}
 
 	resp, err := client.GetCluster(ctx, req)
@@ -188,164 +354,381 @@ func (c *Cluster) get(ctx context.Context, client *container.ClusterManagerClien
 }
 
 // Check if GKE cluster exists
-func (c *Cluster) exists(ctx context.Context, client *container.ClusterManagerClient) bool {
+func (c *Cluster) exists(ctx context.Context, client gcp.ContainerClient) bool {
 	_, err := c.get(ctx, client)
 	return err == nil
 }
 
+// Diff GKE cluster and its node pools against the config, node pools that are no longer in the
+// config are planned for deletion
+func (c *Cluster) diff(ctx context.Context, client gcp.ContainerClient) ([]tidalwave.ResourcePlan, error) {
+	cluster, err := c.get(ctx, client)
+	if isNotFound(err) {
+		plans := []tidalwave.ResourcePlan{*tidalwave.NewResourcePlan("cluster", c.Name, false, nil)}
+		for _, p := range c.pools() {
+			plans = append(plans, *tidalwave.NewResourcePlan("nodepool", c.poolName(p.Name), false, nil))
+		}
+		return plans, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	plans := []tidalwave.ResourcePlan{*tidalwave.NewResourcePlan("cluster", c.Name, true, c.compare(cluster))}
+	pools := c.pools()
+	for i := range pools {
+		p := &pools[i]
+		pool := findNodePool(cluster, p.Name)
+		if pool == nil {
+			plans = append(plans, *tidalwave.NewResourcePlan("nodepool", c.poolName(p.Name), false, nil))
+			continue
+		}
+		plans = append(plans, *tidalwave.NewResourcePlan("nodepool", c.poolName(p.Name), true, p.compare(pool)))
+	}
+	for _, pool := range c.removedPools(cluster) {
+		plans = append(plans, tidalwave.ResourcePlan{Kind: "nodepool", Name: c.poolName(pool.GetName()), Action: tidalwave.ActionDelete})
+	}
+	return plans, nil
+}
+
+// poolName is the name of a node pool in plans, the cluster name and the pool name
+func (c *Cluster) poolName(pool string) string {
+	return fmt.Sprintf("%s/%s", c.Name, pool)
+}
+
+// removedPools returns the node pools of a live cluster that are not in the config, the pools
+// of Autopilot clusters belong to GKE
+func (c *Cluster) removedPools(cluster *containerpb.Cluster) []*containerpb.NodePool {
+	removed := []*containerpb.NodePool{}
+	if c.Autopilot || cluster.GetAutopilot().GetEnabled() {
+		return removed
+	}
+	for _, pool := range cluster.GetNodePools() {
+		if c.findPool(pool.GetName()) == nil {
+			removed = append(removed, pool)
+		}
+	}
+	return removed
+}
+
+// findPool returns the node pool in the config with name or nil
+func (c *Cluster) findPool(name string) *NodePool {
+	for i := range c.NodePools {
+		if c.NodePools[i].Name == name {
+			return &c.NodePools[i]
+		}
+	}
+	return nil
+}
+
+// Compare a live GKE cluster with the config
+func (c *Cluster) compare(cluster *containerpb.Cluster) []tidalwave.Change {
+	d := differ{}
+	d.forceNew("mode", mode(cluster.GetAutopilot().GetEnabled()), mode(c.Autopilot))
+	d.forceNew("network", resourceName(cluster.GetNetwork()), resourceName(c.Network))
+	d.forceNew("subnetwork", resourceName(cluster.GetSubnetwork()), resourceName(c.Subnetwork))
+	d.forceNew("privateClusterConfig.enablePrivateNodes", fmt.Sprint(cluster.GetPrivateClusterConfig().GetEnablePrivateNodes()), "true")
+	d.forceNew("privateClusterConfig.masterIpv4CidrBlock", cluster.GetPrivateClusterConfig().GetMasterIpv4CidrBlock(), c.MasterIpv4CidrBlock)
+	d.field("addonsConfig", c.addonsList(cluster.GetAddonsConfig()), c.addonsList(c.addonsConfig()))
+	d.field("databaseEncryption.keyName", cluster.GetDatabaseEncryption().GetKeyName(), c.CryptoKeyName)
+	d.field("masterAuthorizedNetworksConfig.cidrBlocks", cidrBlockList(cluster.GetMasterAuthorizedNetworksConfig().GetCidrBlocks()), cidrBlockList(c.MasterAuthCidrBlocks))
+	d.field("binaryAuthorization.enabled", fmt.Sprint(cluster.GetBinaryAuthorization().GetEnabled()), fmt.Sprint(c.BinaryAuthorization))
+	d.field("maintenancePolicy", maintenanceList(cluster.GetMaintenancePolicy()), maintenanceList(c.maintenancePolicy()))
+	if c.LoggingComponents != nil {
+		d.field("loggingConfig.components", componentList(cluster.GetLoggingConfig().GetComponentConfig().GetEnableComponents()), componentList(c.LoggingComponents))
+	}
+	if c.MonitoringComponents != nil {
+		d.field("monitoringConfig.components", componentList(cluster.GetMonitoringConfig().GetComponentConfig().GetEnableComponents()), componentList(c.MonitoringComponents))
+	}
+	d.field("networkConfig.enableIntraNodeVisibility", fmt.Sprint(cluster.GetNetworkConfig().GetEnableIntraNodeVisibility()), "true")
+	d.field("networkConfig.gatewayApiConfig.channel", gatewayChannel(cluster.GetNetworkConfig().GetGatewayApiConfig().GetChannel()).String(), gatewayChannel(c.GatewayAPI).String())
+	d.field("costManagementConfig.enabled", fmt.Sprint(cluster.GetCostManagementConfig().GetEnabled()), fmt.Sprint(c.CostAllocation))
+	if posture := c.securityPostureConfig(); posture != nil {
+		d.field("securityPostureConfig", securityPostureList(cluster.GetSecurityPostureConfig()), securityPostureList(posture))
+	}
+	if c.Autopilot {
+		d.forceNew("autoscaling.autoprovisioningNodePoolDefaults.serviceAccount",
+			cluster.GetAutoscaling().GetAutoprovisioningNodePoolDefaults().GetServiceAccount(), c.ServiceAccount)
+	} else {
+		d.field("shieldedNodes.enabled", fmt.Sprint(cluster.GetShieldedNodes().GetEnabled()), "true")
+		d.field("imageStreaming", fmt.Sprint(cluster.GetNodePoolDefaults().GetNodeConfigDefaults().GetGcfsConfig().GetEnabled()), fmt.Sprint(c.ImageStreaming))
+	}
+	if c.Version != "" && !versionMatches(cluster.GetCurrentMasterVersion(), c.Version) {
+		d.field("version", cluster.GetCurrentMasterVersion(), c.Version)
+	}
+	d.forceNew("networkConfig.datapathProvider", datapath(cluster.GetNetworkConfig().GetDatapathProvider()).String(), datapath(c.Datapath).String())
+	d.field("releaseChannel.channel", cluster.GetReleaseChannel().GetChannel().String(), c.releaseChannel().String())
+	return d.changes
+}
+
+// findNodePool returns the named node pool of a live cluster or nil
+func findNodePool(cluster *containerpb.Cluster, name string) *containerpb.NodePool {
+	for _, p := range cluster.GetNodePools() {
+		if p.GetName() == name {
+			return p
+		}
+	}
+	return nil
+}
+
+// addonsList formats the addons tidalwave manages, the CSI drivers Autopilot enables itself are
+// left out for Autopilot clusters
+func (c *Cluster) addonsList(a *containerpb.AddonsConfig) string {
+	if c.Autopilot {
+		return fmt.Sprintf("httpLoadBalancing=%t,horizontalPodAutoscaling=%t",
+			!a.GetHttpLoadBalancing().GetDisabled(),
+			!a.GetHorizontalPodAutoscaling().GetDisabled(),
+		)
+	}
+	return fmt.Sprintf("httpLoadBalancing=%t,horizontalPodAutoscaling=%t,configConnector=%t,gcePersistentDiskCsiDriver=%t,gcpFilestoreCsiDriver=%t",
+		!a.GetHttpLoadBalancing().GetDisabled(),
+		!a.GetHorizontalPodAutoscaling().GetDisabled(),
+		a.GetConfigConnectorConfig().GetEnabled(),
+		a.GetGcePersistentDiskCsiDriverConfig().GetEnabled(),
+		a.GetGcpFilestoreCsiDriverConfig().GetEnabled(),
+	)
+}
+
 // Delete GKE cluster
-func (c *Cluster) delete(ctx context.Context, client *container.ClusterManagerClient) error {
+func (c *Cluster) delete(ctx context.Context, client gcp.ContainerClient) error {
 	if c.exists(ctx, client) {
 		req := &containerpb.DeleteClusterRequest{
-			Name: fmt.Sprintf("projects/%s/locations/%s/clusters/%s", c.ProjectID, c.Region, c.Name),
+			Name: c.name(),
 		}
 		op, err := client.DeleteCluster(ctx, req)
 		if err != nil {
 			return err
 		}
-	status:
-		for {
-			if counter%10==0 {
-				emoji.Println(":beer: Cluster is being deleted")
-				time.Sleep(time.Second*30)
-			}
-			s, err := client.GetOperation(ctx, &containerpb.GetOperationRequest{
-				Name: fmt.Sprintf("projects/%s/locations/%s/operations/%s", c.ProjectID, c.Region, op.GetName()),
-			})
-			if err != nil {
-				return err
-			}
-			switch s.GetStatus().Number() {
-			case 3:
-				break status
-			case 4:
-				return errors.New(s.GetError().Message)
-			}
-			counter++
-		}
+		return c.wait(ctx, client, op, ":beer:", "Cluster is being deleted")
 	}
 	return nil
 }
 
-// Update GKE cluster
-func (c *Cluster) update(ctx context.Context, client *container.ClusterManagerClient) (*containerpb.Cluster, error) {
-	if c.exists(ctx, client) {
-		return c.get(ctx, client)
+// Update GKE cluster, GKE only accepts one changed field per UpdateClusterRequest so
+// every drifted field is sent and waited on separately
+func (c *Cluster) update(ctx context.Context, client gcp.ContainerClient) (*containerpb.Cluster, error) {
+	cluster, err := c.get(ctx, client)
+	if isNotFound(err) {
+		return c.create(ctx, client)
+	}
+	if err != nil {
+		return nil, err
+	}
+	changes := c.compare(cluster)
+	if err := forceNewError("cluster", c.Name, changes); err != nil {
+		return nil, err
+	}
+
+	for _, u := range c.clusterUpdates(changes) {
+		op, err := client.UpdateCluster(ctx, &containerpb.UpdateClusterRequest{
+			Name:   c.name(),
+			Update: u,
+		})
+		if err != nil {
+			return nil, err
+		}
+		if err := c.wait(ctx, client, op, ":beer:", "Cluster is being updated"); err != nil {
+			return nil, err
+		}
+	}
+	if changed(changes, "version") {
+		tidalwave.Warnf(ctx, "cluster %s runs %s rather than version %s, run controlplane upgrade to move it", c.Name, cluster.GetCurrentMasterVersion(), c.Version)
+	}
+	// the maintenance policy has its own method rather than a ClusterUpdate field
+	if changed(changes, "maintenancePolicy") {
+		if err := c.setMaintenancePolicy(ctx, client, cluster); err != nil {
+			return nil, err
+		}
 	}
 
-	creq := &containerpb.UpdateClusterRequest{
-		Name: fmt.Sprintf("projects/%s/locations/%s/clusters/%s", c.ProjectID, c.Region, c.Name),
-		Update: &containerpb.ClusterUpdate{
-			DesiredAddonsConfig: &containerpb.AddonsConfig{
-				HttpLoadBalancing: &containerpb.HttpLoadBalancing{
-					Disabled: false,
+	// new pools are created before removed ones are deleted so their workloads have
+	// somewhere to go when the nodes are drained
+	pools := c.pools()
+	for i := range pools {
+		p := &pools[i]
+		pool := findNodePool(cluster, p.Name)
+		if pool == nil {
+			if err := c.createPool(ctx, client, p); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if err := c.updatePool(ctx, client, p, pool); err != nil {
+			return nil, err
+		}
+	}
+	for _, pool := range c.removedPools(cluster) {
+		if err := c.deletePool(ctx, client, pool.GetName()); err != nil {
+			return nil, err
+		}
+	}
+
+	return c.get(ctx, client)
+}
+
+// clusterUpdates returns one ClusterUpdate for every drifted cluster field
+func (c *Cluster) clusterUpdates(changes []tidalwave.Change) []*containerpb.ClusterUpdate {
+	updates := []*containerpb.ClusterUpdate{}
+	for _, change := range changes {
+		switch change.Field {
+		case "addonsConfig":
+			updates = append(updates, &containerpb.ClusterUpdate{
+				DesiredAddonsConfig: c.addonsConfig(),
+			})
+		case "databaseEncryption.keyName":
+			updates = append(updates, &containerpb.ClusterUpdate{
+				DesiredDatabaseEncryption: &containerpb.DatabaseEncryption{
+					State:   containerpb.DatabaseEncryption_ENCRYPTED,
+					KeyName: c.CryptoKeyName,
+				},
+			})
+		case "masterAuthorizedNetworksConfig.cidrBlocks":
+			updates = append(updates, &containerpb.ClusterUpdate{
+				DesiredMasterAuthorizedNetworksConfig: &containerpb.MasterAuthorizedNetworksConfig{
+					Enabled:    true,
+					CidrBlocks: c.MasterAuthCidrBlocks,
 				},
-				HorizontalPodAutoscaling: &containerpb.HorizontalPodAutoscaling{
-					Disabled: false,
+			})
+		case "binaryAuthorization.enabled":
+			updates = append(updates, &containerpb.ClusterUpdate{
+				DesiredBinaryAuthorization: &containerpb.BinaryAuthorization{
+					Enabled: c.BinaryAuthorization,
 				},
-				ConfigConnectorConfig: &containerpb.ConfigConnectorConfig{
-					Enabled: true,
+			})
+		case "loggingConfig.components":
+			updates = append(updates, &containerpb.ClusterUpdate{
+				DesiredLoggingConfig: c.loggingConfig(),
+			})
+		case "monitoringConfig.components":
+			updates = append(updates, &containerpb.ClusterUpdate{
+				DesiredMonitoringConfig: c.monitoringConfig(),
+			})
+		case "imageStreaming":
+			updates = append(updates, &containerpb.ClusterUpdate{
+				DesiredGcfsConfig: &containerpb.GcfsConfig{
+					Enabled: c.ImageStreaming,
 				},
-				GcePersistentDiskCsiDriverConfig: &containerpb.GcePersistentDiskCsiDriverConfig{
+			})
+		case "networkConfig.enableIntraNodeVisibility":
+			updates = append(updates, &containerpb.ClusterUpdate{
+				DesiredIntraNodeVisibilityConfig: &containerpb.IntraNodeVisibilityConfig{
 					Enabled: true,
 				},
-				GcpFilestoreCsiDriverConfig: &containerpb.GcpFilestoreCsiDriverConfig{
+			})
+		case "networkConfig.gatewayApiConfig.channel":
+			updates = append(updates, &containerpb.ClusterUpdate{
+				DesiredGatewayApiConfig: c.gatewayAPIConfig(),
+			})
+		case "costManagementConfig.enabled":
+			updates = append(updates, &containerpb.ClusterUpdate{
+				DesiredCostManagementConfig: &containerpb.CostManagementConfig{
+					Enabled: c.CostAllocation,
+				},
+			})
+		case "securityPostureConfig":
+			updates = append(updates, &containerpb.ClusterUpdate{
+				DesiredSecurityPostureConfig: c.securityPostureConfig(),
+			})
+		case "shieldedNodes.enabled":
+			updates = append(updates, &containerpb.ClusterUpdate{
+				DesiredShieldedNodes: &containerpb.ShieldedNodes{
 					Enabled: true,
 				},
-			},
-			DesiredNodePoolId: "default-pool",
-			DesiredNodePoolAutoscaling: &containerpb.NodePoolAutoscaling{
-				Enabled:      true,
-				MinNodeCount: c.MinNodeCount,
-				MaxNodeCount: c.MaxNodeCount,
-			},
-			DesiredMasterAuthorizedNetworksConfig: &containerpb.MasterAuthorizedNetworksConfig{
-				Enabled:    true,
-				CidrBlocks: c.MasterAuthCidrBlocks,
-			},
-			DesiredBinaryAuthorization: &containerpb.BinaryAuthorization{
-				Enabled: true,
-			},
-			DesiredIntraNodeVisibilityConfig: &containerpb.IntraNodeVisibilityConfig{
-				Enabled: true,
-			},
-			DesiredReleaseChannel: &containerpb.ReleaseChannel{
-				Channel: 1,
-			},
-			DesiredPrivateClusterConfig: &containerpb.PrivateClusterConfig{
-				EnablePrivateNodes:  true,
-				MasterIpv4CidrBlock: c.MasterIpv4CidrBlock,
-			},
-			DesiredDatapathProvider: 0,
-			DesiredShieldedNodes: &containerpb.ShieldedNodes{
-				Enabled: true,
-			},
-		},
+			})
+		case "releaseChannel.channel":
+			updates = append(updates, &containerpb.ClusterUpdate{
+				DesiredReleaseChannel: &containerpb.ReleaseChannel{
+					Channel: c.releaseChannel(),
+				},
+			})
+		}
 	}
+	return updates
+}
 
-	op, err := client.UpdateCluster(ctx, creq)
+// createPool adds a node pool to the cluster
+func (c *Cluster) createPool(ctx context.Context, client gcp.ContainerClient, p *NodePool) error {
+	op, err := client.CreateNodePool(ctx, &containerpb.CreateNodePoolRequest{
+		Parent:   c.name(),
+		NodePool: p.nodePool(),
+	})
+	if err != nil {
+		return err
+	}
+	return c.wait(ctx, client, op, ":beer:", fmt.Sprintf("Node pool %s is being created", p.Name))
+}
 
+// deletePool deletes a node pool of the cluster, GKE drains its nodes first
+func (c *Cluster) deletePool(ctx context.Context, client gcp.ContainerClient, name string) error {
+	op, err := client.DeleteNodePool(ctx, &containerpb.DeleteNodePoolRequest{
+		Name: fmt.Sprintf("%s/nodePools/%s", c.name(), name),
+	})
 	if err != nil {
-		return nil, err
+		return err
+	}
+	return c.wait(ctx, client, op, ":beer:", fmt.Sprintf("Node pool %s is being drained and deleted", name))
+}
+
+// updatePool resizes the node pool autoscaler and updates node settings that drifted
+func (c *Cluster) updatePool(ctx context.Context, client gcp.ContainerClient, p *NodePool, pool *containerpb.NodePool) error {
+	changes := p.compare(pool)
+	if err := forceNewError("nodepool", c.poolName(p.Name), changes); err != nil {
+		return err
 	}
+	name := fmt.Sprintf("%s/nodePools/%s", c.name(), pool.GetName())
+	want := p.nodePool()
 
-cstatus:
-	for {
-		s, err := client.GetOperation(ctx, &containerpb.GetOperationRequest{
-			Name: fmt.Sprintf("projects/%s/locations/%s/operations/%s", c.ProjectID, c.Region, op.GetName()),
+	if changed(changes, "autoscaling.minNodeCount") || changed(changes, "autoscaling.maxNodeCount") {
+		op, err := client.SetNodePoolAutoscaling(ctx, &containerpb.SetNodePoolAutoscalingRequest{
+			Name:        name,
+			Autoscaling: want.GetAutoscaling(),
 		})
 		if err != nil {
-			return nil, err
+			return err
 		}
-		switch s.GetStatus().Number() {
-		case 3:
-			break cstatus
-		case 4:
-			return nil, errors.New(s.GetError().Message)
+		if err := c.wait(ctx, client, op, ":beer:", fmt.Sprintf("Node pool %s is being resized", p.Name)); err != nil {
+			return err
 		}
 	}
 
-	cluster, _ := c.get(ctx, client)
-
-	nreq := &containerpb.UpdateNodePoolRequest{
-		Name:        fmt.Sprintf("projects/%s/locations/%s/clusters/%s/nodePools/default-pool", c.ProjectID, c.Region, c.Name),
-		NodeVersion: "-",
-		ImageType:   cluster.NodePools[0].Config.ImageType,
-		WorkloadMetadataConfig: &containerpb.WorkloadMetadataConfig{
-			Mode: 2,
-		},
-		UpgradeSettings: &containerpb.NodePool_UpgradeSettings{
-			MaxSurge:       1,
-			MaxUnavailable: 1,
-		},
-		Tags: &containerpb.NetworkTags{
-			Tags: []string{
-				"default-pool",
-			},
-		},
-	}
-
-	op, err = client.UpdateNodePool(ctx, nreq)
-
-	if err != nil {
-		return nil, err
+	// the cluster has left its release channel by now, GKE only lets pools outside of one
+	// turn auto-upgrade off
+	if changed(changes, "management.autoUpgrade") {
+		op, err := client.SetNodePoolManagement(ctx, &containerpb.SetNodePoolManagementRequest{
+			Name:       name,
+			Management: want.GetManagement(),
+		})
+		if err != nil {
+			return err
+		}
+		if err := c.wait(ctx, client, op, ":beer:", fmt.Sprintf("Node pool %s auto-upgrade is being changed", p.Name)); err != nil {
+			return err
+		}
 	}
 
-nstatus:
-	for {
-		s, err := client.GetOperation(ctx, &containerpb.GetOperationRequest{
-			Name: fmt.Sprintf("projects/%s/locations/%s/operations/%s", c.ProjectID, c.Region, op.GetName()),
+	if changed(changes, "config.tags") || changed(changes, "config.workloadMetadataConfig.mode") || changed(changes, "upgradeSettings") ||
+		changed(changes, "config.labels") || changed(changes, "config.taints") {
+		op, err := client.UpdateNodePool(ctx, &containerpb.UpdateNodePoolRequest{
+			Name:                   name,
+			NodeVersion:            pool.GetVersion(),
+			ImageType:              pool.GetConfig().GetImageType(),
+			WorkloadMetadataConfig: want.GetConfig().GetWorkloadMetadataConfig(),
+			UpgradeSettings:        want.GetUpgradeSettings(),
+			Tags: &containerpb.NetworkTags{
+				Tags: want.GetConfig().GetTags(),
+			},
+			Labels: &containerpb.NodeLabels{
+				Labels: want.GetConfig().GetLabels(),
+			},
+			Taints: &containerpb.NodeTaints{
+				Taints: want.GetConfig().GetTaints(),
+			},
 		})
 		if err != nil {
-			return nil, err
+			return err
 		}
-		switch s.GetStatus().Number() {
-		case 3:
-			break nstatus
-		case 4:
-			return nil, errors.New(s.GetError().Message)
+		if err := c.wait(ctx, client, op, ":beer:", fmt.Sprintf("Node pool %s is being updated", p.Name)); err != nil {
+			return err
 		}
 	}
-
-	return c.get(ctx, client)
+	return nil
 }
@@ -0,0 +1,424 @@
+package googletest
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"tidalwave/internal/google/gcp"
+	"time"
+
+	computepb "cloud.google.com/go/compute/apiv1/computepb"
+	"github.com/googleapis/gax-go/v2"
+	"google.golang.org/api/googleapi"
+)
+
+const computeURL = "https://www.googleapis.com/compute/v1"
+
+var ids uint64
+
+// nextID returns a unique numeric resource id
+func nextID() *uint64 {
+	id := atomic.AddUint64(&ids, 1)
+	return &id
+}
+
+func now() *string {
+	t := time.Now().UTC().Format(time.RFC3339)
+	return &t
+}
+
+func strPtr(s string) *string {
+	return &s
+}
+
+// Networks is a fake gcp.NetworksClient
+type Networks struct {
+	Recorder
+	networks map[string]*computepb.Network
+}
+
+// NewNetworks returns an empty fake networks client
+func NewNetworks() *Networks {
+	return &Networks{networks: map[string]*computepb.Network{}}
+}
+
+// Get a network
+func (n *Networks) Get(ctx context.Context, req *computepb.GetNetworkRequest, opts ...gax.CallOption) (*computepb.Network, error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if err := n.call("Get"); err != nil {
+		return nil, err
+	}
+	network, ok := n.networks[req.GetNetwork()]
+	if !ok {
+		return nil, restNotFound("networks", req.GetNetwork())
+	}
+	return clone(network), nil
+}
+
+// Insert a network
+func (n *Networks) Insert(ctx context.Context, req *computepb.InsertNetworkRequest, opts ...gax.CallOption) (gcp.Operation, error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if err := n.call("Insert"); err != nil {
+		return nil, err
+	}
+	network := clone(req.GetNetworkResource())
+	name := network.GetName()
+	if _, ok := n.networks[name]; ok {
+		return nil, restConflict("networks", name)
+	}
+	network.Id = nextID()
+	network.CreationTimestamp = now()
+	network.SelfLink = strPtr(fmt.Sprintf("%s/projects/%s/global/networks/%s", computeURL, req.GetProject(), name))
+	n.networks[name] = network
+	return &Operation{ID: n.nextOp()}, nil
+}
+
+// Delete a network
+func (n *Networks) Delete(ctx context.Context, req *computepb.DeleteNetworkRequest, opts ...gax.CallOption) (gcp.Operation, error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if err := n.call("Delete"); err != nil {
+		return nil, err
+	}
+	if _, ok := n.networks[req.GetNetwork()]; !ok {
+		return nil, restNotFound("networks", req.GetNetwork())
+	}
+	delete(n.networks, req.GetNetwork())
+	return &Operation{ID: n.nextOp()}, nil
+}
+
+// Subnetworks is a fake gcp.SubnetworksClient
+type Subnetworks struct {
+	Recorder
+	subnetworks map[string]*computepb.Subnetwork
+}
+
+// NewSubnetworks returns an empty fake subnetworks client
+func NewSubnetworks() *Subnetworks {
+	return &Subnetworks{subnetworks: map[string]*computepb.Subnetwork{}}
+}
+
+// Modify changes a stored subnetwork in place, to simulate drift
+func (s *Subnetworks) Modify(name string, fn func(*computepb.Subnetwork)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if subnet, ok := s.subnetworks[name]; ok {
+		fn(subnet)
+		s.fingerprint(subnet)
+	}
+}
+
+// fingerprint changes the fingerprint of a subnetwork, as the API does after every change
+func (s *Subnetworks) fingerprint(subnet *computepb.Subnetwork) {
+	n, _ := strconv.Atoi(subnet.GetFingerprint())
+	subnet.Fingerprint = strPtr(strconv.Itoa(n + 1))
+}
+
+// Get a subnetwork
+func (s *Subnetworks) Get(ctx context.Context, req *computepb.GetSubnetworkRequest, opts ...gax.CallOption) (*computepb.Subnetwork, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.call("Get"); err != nil {
+		return nil, err
+	}
+	subnet, ok := s.subnetworks[req.GetSubnetwork()]
+	if !ok {
+		return nil, restNotFound("subnetworks", req.GetSubnetwork())
+	}
+	return clone(subnet), nil
+}
+
+// Insert a subnetwork
+func (s *Subnetworks) Insert(ctx context.Context, req *computepb.InsertSubnetworkRequest, opts ...gax.CallOption) (gcp.Operation, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.call("Insert"); err != nil {
+		return nil, err
+	}
+	subnet := clone(req.GetSubnetworkResource())
+	name := subnet.GetName()
+	if _, ok := s.subnetworks[name]; ok {
+		return nil, restConflict("subnetworks", name)
+	}
+	subnet.Id = nextID()
+	subnet.CreationTimestamp = now()
+	subnet.Region = strPtr(req.GetRegion())
+	subnet.SelfLink = strPtr(fmt.Sprintf("%s/projects/%s/regions/%s/subnetworks/%s", computeURL, req.GetProject(), req.GetRegion(), name))
+	s.fingerprint(subnet)
+	s.subnetworks[name] = subnet
+	return &Operation{ID: s.nextOp()}, nil
+}
+
+// Delete a subnetwork
+func (s *Subnetworks) Delete(ctx context.Context, req *computepb.DeleteSubnetworkRequest, opts ...gax.CallOption) (gcp.Operation, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.call("Delete"); err != nil {
+		return nil, err
+	}
+	if _, ok := s.subnetworks[req.GetSubnetwork()]; !ok {
+		return nil, restNotFound("subnetworks", req.GetSubnetwork())
+	}
+	delete(s.subnetworks, req.GetSubnetwork())
+	return &Operation{ID: s.nextOp()}, nil
+}
+
+// Patch the secondary ranges of a subnetwork, failing if the fingerprint is stale
+func (s *Subnetworks) Patch(ctx context.Context, req *computepb.PatchSubnetworkRequest, opts ...gax.CallOption) (gcp.Operation, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.call("Patch"); err != nil {
+		return nil, err
+	}
+	subnet, ok := s.subnetworks[req.GetSubnetwork()]
+	if !ok {
+		return nil, restNotFound("subnetworks", req.GetSubnetwork())
+	}
+	patch := req.GetSubnetworkResource()
+	if patch.GetFingerprint() != subnet.GetFingerprint() {
+		return nil, &googleapi.Error{Code: http.StatusPreconditionFailed, Message: "fingerprint does not match"}
+	}
+	if patch.SecondaryIpRanges != nil {
+		subnet.SecondaryIpRanges = clone(patch).SecondaryIpRanges
+	}
+	s.fingerprint(subnet)
+	return &Operation{ID: s.nextOp()}, nil
+}
+
+// ExpandIpCidrRange of a subnetwork
+func (s *Subnetworks) ExpandIpCidrRange(ctx context.Context, req *computepb.ExpandIpCidrRangeSubnetworkRequest, opts ...gax.CallOption) (gcp.Operation, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.call("ExpandIpCidrRange"); err != nil {
+		return nil, err
+	}
+	subnet, ok := s.subnetworks[req.GetSubnetwork()]
+	if !ok {
+		return nil, restNotFound("subnetworks", req.GetSubnetwork())
+	}
+	subnet.IpCidrRange = strPtr(req.GetSubnetworksExpandIpCidrRangeRequestResource().GetIpCidrRange())
+	s.fingerprint(subnet)
+	return &Operation{ID: s.nextOp()}, nil
+}
+
+// SetPrivateIpGoogleAccess of a subnetwork
+func (s *Subnetworks) SetPrivateIpGoogleAccess(ctx context.Context, req *computepb.SetPrivateIpGoogleAccessSubnetworkRequest, opts ...gax.CallOption) (gcp.Operation, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.call("SetPrivateIpGoogleAccess"); err != nil {
+		return nil, err
+	}
+	subnet, ok := s.subnetworks[req.GetSubnetwork()]
+	if !ok {
+		return nil, restNotFound("subnetworks", req.GetSubnetwork())
+	}
+	access := req.GetSubnetworksSetPrivateIpGoogleAccessRequestResource().GetPrivateIpGoogleAccess()
+	subnet.PrivateIpGoogleAccess = &access
+	s.fingerprint(subnet)
+	return &Operation{ID: s.nextOp()}, nil
+}
+
+// Routers is a fake gcp.RoutersClient
+type Routers struct {
+	Recorder
+	routers map[string]*computepb.Router
+}
+
+// NewRouters returns an empty fake routers client
+func NewRouters() *Routers {
+	return &Routers{routers: map[string]*computepb.Router{}}
+}
+
+// Modify changes a stored router in place, to simulate drift
+func (r *Routers) Modify(name string, fn func(*computepb.Router)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if router, ok := r.routers[name]; ok {
+		fn(router)
+	}
+}
+
+// Get a router
+func (r *Routers) Get(ctx context.Context, req *computepb.GetRouterRequest, opts ...gax.CallOption) (*computepb.Router, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err := r.call("Get"); err != nil {
+		return nil, err
+	}
+	router, ok := r.routers[req.GetRouter()]
+	if !ok {
+		return nil, restNotFound("routers", req.GetRouter())
+	}
+	return clone(router), nil
+}
+
+// Insert a router
+func (r *Routers) Insert(ctx context.Context, req *computepb.InsertRouterRequest, opts ...gax.CallOption) (gcp.Operation, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err := r.call("Insert"); err != nil {
+		return nil, err
+	}
+	router := clone(req.GetRouterResource())
+	name := router.GetName()
+	if _, ok := r.routers[name]; ok {
+		return nil, restConflict("routers", name)
+	}
+	router.Id = nextID()
+	router.CreationTimestamp = now()
+	router.Region = strPtr(req.GetRegion())
+	router.SelfLink = strPtr(fmt.Sprintf("%s/projects/%s/regions/%s/routers/%s", computeURL, req.GetProject(), req.GetRegion(), name))
+	r.routers[name] = router
+	return &Operation{ID: r.nextOp()}, nil
+}
+
+// Delete a router
+func (r *Routers) Delete(ctx context.Context, req *computepb.DeleteRouterRequest, opts ...gax.CallOption) (gcp.Operation, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err := r.call("Delete"); err != nil {
+		return nil, err
+	}
+	if _, ok := r.routers[req.GetRouter()]; !ok {
+		return nil, restNotFound("routers", req.GetRouter())
+	}
+	delete(r.routers, req.GetRouter())
+	return &Operation{ID: r.nextOp()}, nil
+}
+
+// Patch the NATs of a router
+func (r *Routers) Patch(ctx context.Context, req *computepb.PatchRouterRequest, opts ...gax.CallOption) (gcp.Operation, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err := r.call("Patch"); err != nil {
+		return nil, err
+	}
+	router, ok := r.routers[req.GetRouter()]
+	if !ok {
+		return nil, restNotFound("routers", req.GetRouter())
+	}
+	if nats := req.GetRouterResource().GetNats(); nats != nil {
+		router.Nats = clone(req.GetRouterResource()).Nats
+	}
+	return &Operation{ID: r.nextOp()}, nil
+}
+
+// Firewalls is a fake gcp.FirewallsClient
+type Firewalls struct {
+	Recorder
+	firewalls map[string]*computepb.Firewall
+}
+
+// NewFirewalls returns an empty fake firewalls client
+func NewFirewalls() *Firewalls {
+	return &Firewalls{firewalls: map[string]*computepb.Firewall{}}
+}
+
+// Modify changes a stored firewall rule in place, to simulate drift
+func (f *Firewalls) Modify(name string, fn func(*computepb.Firewall)) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if rule, ok := f.firewalls[name]; ok {
+		fn(rule)
+	}
+}
+
+// Get a firewall rule
+func (f *Firewalls) Get(ctx context.Context, req *computepb.GetFirewallRequest, opts ...gax.CallOption) (*computepb.Firewall, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.call("Get"); err != nil {
+		return nil, err
+	}
+	rule, ok := f.firewalls[req.GetFirewall()]
+	if !ok {
+		return nil, restNotFound("firewalls", req.GetFirewall())
+	}
+	return clone(rule), nil
+}
+
+// Insert a firewall rule
+func (f *Firewalls) Insert(ctx context.Context, req *computepb.InsertFirewallRequest, opts ...gax.CallOption) (gcp.Operation, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.call("Insert"); err != nil {
+		return nil, err
+	}
+	rule := clone(req.GetFirewallResource())
+	name := rule.GetName()
+	if _, ok := f.firewalls[name]; ok {
+		return nil, restConflict("firewalls", name)
+	}
+	rule.Id = nextID()
+	rule.CreationTimestamp = now()
+	rule.SelfLink = strPtr(fmt.Sprintf("%s/projects/%s/global/firewalls/%s", computeURL, req.GetProject(), name))
+	f.firewalls[name] = rule
+	return &Operation{ID: f.nextOp()}, nil
+}
+
+// Delete a firewall rule
+func (f *Firewalls) Delete(ctx context.Context, req *computepb.DeleteFirewallRequest, opts ...gax.CallOption) (gcp.Operation, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.call("Delete"); err != nil {
+		return nil, err
+	}
+	if _, ok := f.firewalls[req.GetFirewall()]; !ok {
+		return nil, restNotFound("firewalls", req.GetFirewall())
+	}
+	delete(f.firewalls, req.GetFirewall())
+	return &Operation{ID: f.nextOp()}, nil
+}
+
+// Patch a firewall rule, like the API lists that are empty in the patch are left unchanged
+func (f *Firewalls) Patch(ctx context.Context, req *computepb.PatchFirewallRequest, opts ...gax.CallOption) (gcp.Operation, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.call("Patch"); err != nil {
+		return nil, err
+	}
+	rule, ok := f.firewalls[req.GetFirewall()]
+	if !ok {
+		return nil, restNotFound("firewalls", req.GetFirewall())
+	}
+	patch := clone(req.GetFirewallResource())
+	if len(patch.Allowed) > 0 {
+		rule.Allowed = patch.Allowed
+	}
+	if len(patch.SourceRanges) > 0 {
+		rule.SourceRanges = patch.SourceRanges
+	}
+	if len(patch.DestinationRanges) > 0 {
+		rule.DestinationRanges = patch.DestinationRanges
+	}
+	if len(patch.SourceTags) > 0 {
+		rule.SourceTags = patch.SourceTags
+	}
+	if len(patch.TargetTags) > 0 {
+		rule.TargetTags = patch.TargetTags
+	}
+	return &Operation{ID: f.nextOp()}, nil
+}
+
+// Update replaces a firewall rule, keeping its id
+func (f *Firewalls) Update(ctx context.Context, req *computepb.UpdateFirewallRequest, opts ...gax.CallOption) (gcp.Operation, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.call("Update"); err != nil {
+		return nil, err
+	}
+	old, ok := f.firewalls[req.GetFirewall()]
+	if !ok {
+		return nil, restNotFound("firewalls", req.GetFirewall())
+	}
+	rule := clone(req.GetFirewallResource())
+	rule.Id = old.Id
+	rule.CreationTimestamp = old.CreationTimestamp
+	rule.SelfLink = old.SelfLink
+	f.firewalls[req.GetFirewall()] = rule
+	return &Operation{ID: f.nextOp()}, nil
+}
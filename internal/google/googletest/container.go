@@ -0,0 +1,385 @@
+package googletest
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	containerpb "cloud.google.com/go/container/apiv1/containerpb"
+	"github.com/googleapis/gax-go/v2"
+)
+
+// ServerConfig is what the fake GKE client serves as the versions of every location, newest
+// first
+var ServerConfig = &containerpb.ServerConfig{
+	DefaultClusterVersion: "1.24.5-gke.600",
+	ValidMasterVersions:   []string{"1.25.2-gke.1700", "1.24.5-gke.600", "1.23.8-gke.1900"},
+	ValidNodeVersions:     []string{"1.25.2-gke.1700", "1.24.5-gke.600", "1.23.8-gke.1900", "1.22.12-gke.2300"},
+	Channels: []*containerpb.ServerConfig_ReleaseChannelConfig{
+		{
+			Channel:        containerpb.ReleaseChannel_RAPID,
+			DefaultVersion: "1.25.2-gke.1700",
+			ValidVersions:  []string{"1.25.2-gke.1700", "1.24.5-gke.600"},
+		},
+		{
+			Channel:        containerpb.ReleaseChannel_REGULAR,
+			DefaultVersion: "1.24.5-gke.600",
+			ValidVersions:  []string{"1.24.5-gke.600", "1.23.8-gke.1900"},
+		},
+		{
+			Channel:        containerpb.ReleaseChannel_STABLE,
+			DefaultVersion: "1.23.8-gke.1900",
+			ValidVersions:  []string{"1.23.8-gke.1900"},
+		},
+	},
+}
+
+// Container is a fake gcp.ContainerClient, every operation it starts is done straight away
+type Container struct {
+	Recorder
+	clusters   map[string]*containerpb.Cluster
+	operations map[string]*containerpb.Operation
+}
+
+// NewContainer returns an empty fake GKE client
+func NewContainer() *Container {
+	return &Container{
+		clusters:   map[string]*containerpb.Cluster{},
+		operations: map[string]*containerpb.Operation{},
+	}
+}
+
+// Modify changes a stored cluster in place, to simulate drift
+func (c *Container) Modify(name string, fn func(*containerpb.Cluster)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if cluster, ok := c.clusters[name]; ok {
+		fn(cluster)
+	}
+}
+
+// operation records a finished operation, it must be called with mu held
+func (c *Container) operation(opType containerpb.Operation_Type, target string) *containerpb.Operation {
+	op := &containerpb.Operation{
+		Name:          c.nextOp(),
+		OperationType: opType,
+		Status:        containerpb.Operation_DONE,
+		TargetLink:    target,
+	}
+	c.operations[op.Name] = op
+	return clone(op)
+}
+
+// cluster returns the stored cluster, it must be called with mu held
+func (c *Container) cluster(name string) (*containerpb.Cluster, error) {
+	cluster, ok := c.clusters[name]
+	if !ok {
+		return nil, grpcNotFound(name)
+	}
+	return cluster, nil
+}
+
+// nodePool returns the stored node pool named projects/*/locations/*/clusters/*/nodePools/*,
+// it must be called with mu held
+func (c *Container) nodePool(name string) (*containerpb.NodePool, error) {
+	clusterName, poolName, _ := strings.Cut(name, "/nodePools/")
+	cluster, err := c.cluster(clusterName)
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range cluster.NodePools {
+		if p.GetName() == poolName {
+			return p, nil
+		}
+	}
+	return nil, grpcNotFound(name)
+}
+
+// GetCluster returns a cluster
+func (c *Container) GetCluster(ctx context.Context, req *containerpb.GetClusterRequest, opts ...gax.CallOption) (*containerpb.Cluster, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.call("GetCluster"); err != nil {
+		return nil, err
+	}
+	cluster, err := c.cluster(req.GetName())
+	if err != nil {
+		return nil, err
+	}
+	return clone(cluster), nil
+}
+
+// CreateCluster creates a running cluster
+func (c *Container) CreateCluster(ctx context.Context, req *containerpb.CreateClusterRequest, opts ...gax.CallOption) (*containerpb.Operation, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.call("CreateCluster"); err != nil {
+		return nil, err
+	}
+	cluster := clone(req.GetCluster())
+	name := fmt.Sprintf("%s/clusters/%s", req.GetParent(), cluster.GetName())
+	if _, ok := c.clusters[name]; ok {
+		return nil, grpcAlreadyExists(name)
+	}
+	cluster.Id = fmt.Sprint(*nextID())
+	cluster.SelfLink = "https://container.googleapis.com/v1/" + name
+	cluster.CreateTime = time.Now().UTC().Format(time.RFC3339)
+	cluster.Status = containerpb.Cluster_RUNNING
+	cluster.Endpoint = "203.0.113.10"
+	cluster.MasterAuth = &containerpb.MasterAuth{ClusterCaCertificate: "LS0tLS1CRUdJTiBDRVJUSUZJQ0FURS0tLS0t"}
+	if policy := cluster.GetMaintenancePolicy(); policy != nil {
+		policy.ResourceVersion = fmt.Sprint(*nextID())
+	}
+	cluster.CurrentMasterVersion = serverVersion(cluster.GetInitialClusterVersion())
+	cluster.CurrentNodeVersion = cluster.CurrentMasterVersion
+	for _, p := range cluster.NodePools {
+		p.Version = cluster.CurrentMasterVersion
+	}
+	if private := cluster.GetPrivateClusterConfig(); private != nil {
+		private.PrivateEndpoint = "10.0.0.2"
+	}
+	c.clusters[name] = cluster
+	return c.operation(containerpb.Operation_CREATE_CLUSTER, cluster.SelfLink), nil
+}
+
+// UpdateCluster applies the single desired change in the update
+func (c *Container) UpdateCluster(ctx context.Context, req *containerpb.UpdateClusterRequest, opts ...gax.CallOption) (*containerpb.Operation, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.call("UpdateCluster"); err != nil {
+		return nil, err
+	}
+	cluster, err := c.cluster(req.GetName())
+	if err != nil {
+		return nil, err
+	}
+	u := clone(req.GetUpdate())
+	switch {
+	case u.DesiredAddonsConfig != nil:
+		cluster.AddonsConfig = u.DesiredAddonsConfig
+	case u.DesiredDatabaseEncryption != nil:
+		cluster.DatabaseEncryption = u.DesiredDatabaseEncryption
+	case u.DesiredMasterAuthorizedNetworksConfig != nil:
+		cluster.MasterAuthorizedNetworksConfig = u.DesiredMasterAuthorizedNetworksConfig
+	case u.DesiredBinaryAuthorization != nil:
+		cluster.BinaryAuthorization = u.DesiredBinaryAuthorization
+	case u.DesiredIntraNodeVisibilityConfig != nil:
+		if cluster.NetworkConfig == nil {
+			cluster.NetworkConfig = &containerpb.NetworkConfig{}
+		}
+		cluster.NetworkConfig.EnableIntraNodeVisibility = u.DesiredIntraNodeVisibilityConfig.GetEnabled()
+	case u.DesiredShieldedNodes != nil:
+		cluster.ShieldedNodes = u.DesiredShieldedNodes
+	case u.DesiredReleaseChannel != nil:
+		cluster.ReleaseChannel = u.DesiredReleaseChannel
+	case u.DesiredLoggingConfig != nil:
+		cluster.LoggingConfig = u.DesiredLoggingConfig
+	case u.DesiredMonitoringConfig != nil:
+		cluster.MonitoringConfig = u.DesiredMonitoringConfig
+	case u.DesiredGatewayApiConfig != nil:
+		if cluster.NetworkConfig == nil {
+			cluster.NetworkConfig = &containerpb.NetworkConfig{}
+		}
+		cluster.NetworkConfig.GatewayApiConfig = u.DesiredGatewayApiConfig
+	case u.DesiredCostManagementConfig != nil:
+		cluster.CostManagementConfig = u.DesiredCostManagementConfig
+	case u.DesiredSecurityPostureConfig != nil:
+		cluster.SecurityPostureConfig = u.DesiredSecurityPostureConfig
+	case u.DesiredGcfsConfig != nil:
+		cluster.NodePoolDefaults = &containerpb.NodePoolDefaults{
+			NodeConfigDefaults: &containerpb.NodeConfigDefaults{GcfsConfig: u.DesiredGcfsConfig},
+		}
+	case u.DesiredMasterVersion != "":
+		cluster.CurrentMasterVersion = serverVersion(u.DesiredMasterVersion)
+	}
+	return c.operation(containerpb.Operation_UPDATE_CLUSTER, cluster.SelfLink), nil
+}
+
+// SetMaintenancePolicy replaces the maintenance policy of a cluster, the resource version must
+// match the stored policy
+func (c *Container) SetMaintenancePolicy(ctx context.Context, req *containerpb.SetMaintenancePolicyRequest, opts ...gax.CallOption) (*containerpb.Operation, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.call("SetMaintenancePolicy"); err != nil {
+		return nil, err
+	}
+	cluster, err := c.cluster(req.GetName())
+	if err != nil {
+		return nil, err
+	}
+	policy := clone(req.GetMaintenancePolicy())
+	if policy.GetResourceVersion() != cluster.GetMaintenancePolicy().GetResourceVersion() {
+		return nil, grpcFailedPrecondition(req.GetName() + " maintenance policy")
+	}
+	policy.ResourceVersion = fmt.Sprint(*nextID())
+	cluster.MaintenancePolicy = policy
+	return c.operation(containerpb.Operation_SET_MAINTENANCE_POLICY, cluster.SelfLink), nil
+}
+
+// DeleteCluster deletes a cluster
+func (c *Container) DeleteCluster(ctx context.Context, req *containerpb.DeleteClusterRequest, opts ...gax.CallOption) (*containerpb.Operation, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.call("DeleteCluster"); err != nil {
+		return nil, err
+	}
+	cluster, err := c.cluster(req.GetName())
+	if err != nil {
+		return nil, err
+	}
+	delete(c.clusters, req.GetName())
+	return c.operation(containerpb.Operation_DELETE_CLUSTER, cluster.SelfLink), nil
+}
+
+// CreateNodePool adds a node pool to a cluster
+func (c *Container) CreateNodePool(ctx context.Context, req *containerpb.CreateNodePoolRequest, opts ...gax.CallOption) (*containerpb.Operation, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.call("CreateNodePool"); err != nil {
+		return nil, err
+	}
+	cluster, err := c.cluster(req.GetParent())
+	if err != nil {
+		return nil, err
+	}
+	if _, err := c.nodePool(fmt.Sprintf("%s/nodePools/%s", req.GetParent(), req.GetNodePool().GetName())); err == nil {
+		return nil, grpcAlreadyExists(req.GetNodePool().GetName())
+	}
+	pool := clone(req.GetNodePool())
+	if pool.Version == "" {
+		pool.Version = cluster.GetCurrentMasterVersion()
+	}
+	cluster.NodePools = append(cluster.NodePools, pool)
+	return c.operation(containerpb.Operation_CREATE_NODE_POOL, cluster.SelfLink), nil
+}
+
+// UpdateNodePool updates the node settings of a node pool
+func (c *Container) UpdateNodePool(ctx context.Context, req *containerpb.UpdateNodePoolRequest, opts ...gax.CallOption) (*containerpb.Operation, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.call("UpdateNodePool"); err != nil {
+		return nil, err
+	}
+	pool, err := c.nodePool(req.GetName())
+	if err != nil {
+		return nil, err
+	}
+	u := clone(req)
+	if v := u.GetNodeVersion(); v != "" && v != "-" {
+		pool.Version = serverVersion(v)
+	}
+	if pool.Config == nil {
+		pool.Config = &containerpb.NodeConfig{}
+	}
+	if u.WorkloadMetadataConfig != nil {
+		pool.Config.WorkloadMetadataConfig = u.WorkloadMetadataConfig
+	}
+	if u.Tags != nil {
+		pool.Config.Tags = u.Tags.GetTags()
+	}
+	if u.UpgradeSettings != nil {
+		pool.UpgradeSettings = u.UpgradeSettings
+	}
+	if u.Labels != nil {
+		pool.Config.Labels = u.Labels.GetLabels()
+	}
+	if u.Taints != nil {
+		pool.Config.Taints = u.Taints.GetTaints()
+	}
+	return c.operation(containerpb.Operation_UPGRADE_NODES, req.GetName()), nil
+}
+
+// DeleteNodePool removes a node pool from a cluster
+func (c *Container) DeleteNodePool(ctx context.Context, req *containerpb.DeleteNodePoolRequest, opts ...gax.CallOption) (*containerpb.Operation, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.call("DeleteNodePool"); err != nil {
+		return nil, err
+	}
+	if _, err := c.nodePool(req.GetName()); err != nil {
+		return nil, err
+	}
+	clusterName, poolName, _ := strings.Cut(req.GetName(), "/nodePools/")
+	cluster := c.clusters[clusterName]
+	pools := []*containerpb.NodePool{}
+	for _, p := range cluster.NodePools {
+		if p.GetName() != poolName {
+			pools = append(pools, p)
+		}
+	}
+	cluster.NodePools = pools
+	return c.operation(containerpb.Operation_DELETE_NODE_POOL, cluster.SelfLink), nil
+}
+
+// SetNodePoolAutoscaling changes the autoscaling limits of a node pool
+func (c *Container) SetNodePoolAutoscaling(ctx context.Context, req *containerpb.SetNodePoolAutoscalingRequest, opts ...gax.CallOption) (*containerpb.Operation, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.call("SetNodePoolAutoscaling"); err != nil {
+		return nil, err
+	}
+	pool, err := c.nodePool(req.GetName())
+	if err != nil {
+		return nil, err
+	}
+	pool.Autoscaling = clone(req.GetAutoscaling())
+	return c.operation(containerpb.Operation_SET_NODE_POOL_MANAGEMENT, req.GetName()), nil
+}
+
+// SetNodePoolManagement changes the auto-upgrade and auto-repair settings of a node pool
+func (c *Container) SetNodePoolManagement(ctx context.Context, req *containerpb.SetNodePoolManagementRequest, opts ...gax.CallOption) (*containerpb.Operation, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.call("SetNodePoolManagement"); err != nil {
+		return nil, err
+	}
+	pool, err := c.nodePool(req.GetName())
+	if err != nil {
+		return nil, err
+	}
+	pool.Management = clone(req.GetManagement())
+	return c.operation(containerpb.Operation_SET_NODE_POOL_MANAGEMENT, req.GetName()), nil
+}
+
+// GetServerConfig returns ServerConfig for every location
+func (c *Container) GetServerConfig(ctx context.Context, req *containerpb.GetServerConfigRequest, opts ...gax.CallOption) (*containerpb.ServerConfig, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.call("GetServerConfig"); err != nil {
+		return nil, err
+	}
+	return clone(ServerConfig), nil
+}
+
+// serverVersion returns the newest valid version that is version or starts with it,
+// such as 1.24 for 1.24.5-gke.600, and the default version for an empty version, "-" or latest
+func serverVersion(version string) string {
+	switch version {
+	case "", "-":
+		return ServerConfig.GetDefaultClusterVersion()
+	case "latest":
+		return ServerConfig.GetValidMasterVersions()[0]
+	}
+	for _, v := range ServerConfig.GetValidNodeVersions() {
+		if v == version || strings.HasPrefix(v, version+".") || strings.HasPrefix(v, version+"-") {
+			return v
+		}
+	}
+	return version
+}
+
+// GetOperation returns an operation, named projects/*/locations/*/operations/*
+func (c *Container) GetOperation(ctx context.Context, req *containerpb.GetOperationRequest, opts ...gax.CallOption) (*containerpb.Operation, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.call("GetOperation"); err != nil {
+		return nil, err
+	}
+	name := req.GetName()
+	op, ok := c.operations[name[strings.LastIndex(name, "/")+1:]]
+	if !ok {
+		return nil, grpcNotFound(name)
+	}
+	return clone(op), nil
+}
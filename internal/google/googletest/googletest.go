@@ -0,0 +1,178 @@
+/*
+Package googletest provides in-memory fakes of the GCP clients declared in gcp, so the google
+package can be tested without a real project
+*/
+package googletest
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"tidalwave/internal/google/gcp"
+
+	computepb "cloud.google.com/go/compute/apiv1/computepb"
+	"github.com/googleapis/gax-go/v2"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+// Recorder records the methods called on a fake and returns the errors injected with Fail
+type Recorder struct {
+	mu     sync.Mutex
+	calls  []string
+	errors map[string]error
+	// times counts down the calls left to fail for errors injected with FailTimes
+	times map[string]int
+	ops   int
+}
+
+// Calls returns the methods called so far, in order
+func (r *Recorder) Calls() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]string{}, r.calls...)
+}
+
+// Mutations returns the methods called so far that are not reads
+func (r *Recorder) Mutations() []string {
+	mutations := []string{}
+	for _, c := range r.Calls() {
+		if !strings.HasPrefix(c, "Get") {
+			mutations = append(mutations, c)
+		}
+	}
+	return mutations
+}
+
+// Reset forgets the calls recorded so far
+func (r *Recorder) Reset() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.calls = nil
+}
+
+// Fail makes every later call to method return err, a nil err clears it
+func (r *Recorder) Fail(method string, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.errors == nil {
+		r.errors = map[string]error{}
+	}
+	if err == nil {
+		delete(r.errors, method)
+		return
+	}
+	r.errors[method] = err
+}
+
+// FailTimes makes the next n calls to method return err
+func (r *Recorder) FailTimes(method string, err error, n int) {
+	r.Fail(method, err)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.times == nil {
+		r.times = map[string]int{}
+	}
+	r.times[method] = n
+}
+
+// call records a method call, it must be called with mu held
+func (r *Recorder) call(method string) error {
+	r.calls = append(r.calls, method)
+	err := r.errors[method]
+	if n, ok := r.times[method]; ok && err != nil {
+		if n <= 1 {
+			delete(r.times, method)
+			delete(r.errors, method)
+		} else {
+			r.times[method] = n - 1
+		}
+	}
+	return err
+}
+
+// nextOp returns a new operation name, it must be called with mu held
+func (r *Recorder) nextOp() string {
+	r.ops++
+	return fmt.Sprintf("operation-%d", r.ops)
+}
+
+// Operation is a compute operation that is already done
+type Operation struct {
+	ID string
+}
+
+// Name of the operation
+func (o *Operation) Name() string {
+	return o.ID
+}
+
+// Wait returns straight away
+func (o *Operation) Wait(ctx context.Context, opts ...gax.CallOption) error {
+	return ctx.Err()
+}
+
+// Proto returns the operation as done
+func (o *Operation) Proto() *computepb.Operation {
+	return &computepb.Operation{
+		Name:   proto.String(o.ID),
+		Status: computepb.Operation_DONE.Enum(),
+	}
+}
+
+// restNotFound is the error the compute REST clients return for a missing resource
+func restNotFound(kind, name string) error {
+	return &googleapi.Error{
+		Code:    http.StatusNotFound,
+		Message: fmt.Sprintf("The resource '%s/%s' was not found", kind, name),
+	}
+}
+
+// restConflict is the error the compute REST clients return for a resource that already exists
+func restConflict(kind, name string) error {
+	return &googleapi.Error{
+		Code:    http.StatusConflict,
+		Message: fmt.Sprintf("The resource '%s/%s' already exists", kind, name),
+	}
+}
+
+// grpcNotFound is the error the gRPC clients return for a missing resource
+func grpcNotFound(name string) error {
+	return status.Errorf(codes.NotFound, "%s not found", name)
+}
+
+// grpcInvalidArgument is the error the gRPC clients return for a request they reject
+func grpcInvalidArgument(message string) error {
+	return status.Error(codes.InvalidArgument, message)
+}
+
+// grpcFailedPrecondition is the error the gRPC clients return when a resource changed since it
+// was read
+func grpcFailedPrecondition(name string) error {
+	return status.Errorf(codes.FailedPrecondition, "%s has changed", name)
+}
+
+// grpcAlreadyExists is the error the gRPC clients return for a resource that already exists
+func grpcAlreadyExists(name string) error {
+	return status.Errorf(codes.AlreadyExists, "%s already exists", name)
+}
+
+// clone copies a proto so callers cannot change what the fake stores
+func clone[T proto.Message](m T) T {
+	return proto.Clone(m).(T)
+}
+
+var (
+	_ gcp.NetworksClient    = (*Networks)(nil)
+	_ gcp.SubnetworksClient = (*Subnetworks)(nil)
+	_ gcp.RoutersClient     = (*Routers)(nil)
+	_ gcp.FirewallsClient   = (*Firewalls)(nil)
+	_ gcp.KMSClient         = (*KMS)(nil)
+	_ gcp.ContainerClient   = (*Container)(nil)
+	_ gcp.IAMClient         = (*IAM)(nil)
+	_ gcp.ProjectsClient    = (*IAM)(nil)
+)
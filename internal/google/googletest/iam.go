@@ -0,0 +1,131 @@
+package googletest
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	adminpb "cloud.google.com/go/iam/admin/apiv1/adminpb"
+	iampb "cloud.google.com/go/iam/apiv1/iampb"
+	"github.com/googleapis/gax-go/v2"
+)
+
+// IAM is a fake gcp.IAMClient and gcp.ProjectsClient, it stores service accounts and the IAM
+// policy of projects
+type IAM struct {
+	Recorder
+	accounts map[string]*adminpb.ServiceAccount
+	policies map[string]*iampb.Policy
+}
+
+// NewIAM returns an empty fake IAM client
+func NewIAM() *IAM {
+	return &IAM{
+		accounts: map[string]*adminpb.ServiceAccount{},
+		policies: map[string]*iampb.Policy{},
+	}
+}
+
+// accountEmail returns the email of a service account named projects/*/serviceAccounts/*
+func accountEmail(name string) string {
+	return name[strings.LastIndex(name, "/")+1:]
+}
+
+// GetServiceAccount returns a service account
+func (i *IAM) GetServiceAccount(ctx context.Context, req *adminpb.GetServiceAccountRequest, opts ...gax.CallOption) (*adminpb.ServiceAccount, error) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	if err := i.call("GetServiceAccount"); err != nil {
+		return nil, err
+	}
+	account, ok := i.accounts[accountEmail(req.GetName())]
+	if !ok {
+		return nil, grpcNotFound(req.GetName())
+	}
+	return clone(account), nil
+}
+
+// CreateServiceAccount creates a service account in a project
+func (i *IAM) CreateServiceAccount(ctx context.Context, req *adminpb.CreateServiceAccountRequest, opts ...gax.CallOption) (*adminpb.ServiceAccount, error) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	if err := i.call("CreateServiceAccount"); err != nil {
+		return nil, err
+	}
+	project := strings.TrimPrefix(req.GetName(), "projects/")
+	email := fmt.Sprintf("%s@%s.iam.gserviceaccount.com", req.GetAccountId(), project)
+	if _, ok := i.accounts[email]; ok {
+		return nil, grpcAlreadyExists(email)
+	}
+	account := clone(req.GetServiceAccount())
+	if account == nil {
+		account = &adminpb.ServiceAccount{}
+	}
+	account.Name = fmt.Sprintf("projects/%s/serviceAccounts/%s", project, email)
+	account.ProjectId = project
+	account.Email = email
+	account.UniqueId = fmt.Sprint(*nextID())
+	i.accounts[email] = account
+	return clone(account), nil
+}
+
+// DeleteServiceAccount deletes a service account
+func (i *IAM) DeleteServiceAccount(ctx context.Context, req *adminpb.DeleteServiceAccountRequest, opts ...gax.CallOption) error {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	if err := i.call("DeleteServiceAccount"); err != nil {
+		return err
+	}
+	email := accountEmail(req.GetName())
+	if _, ok := i.accounts[email]; !ok {
+		return grpcNotFound(req.GetName())
+	}
+	delete(i.accounts, email)
+	return nil
+}
+
+// GetIamPolicy returns the IAM policy of a project. Like IAM it returns a version 1 policy with
+// the conditions of conditional bindings dropped and their roles renamed unless version 3 is
+// requested.
+func (i *IAM) GetIamPolicy(ctx context.Context, req *iampb.GetIamPolicyRequest, opts ...gax.CallOption) (*iampb.Policy, error) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	if err := i.call("GetIamPolicy"); err != nil {
+		return nil, err
+	}
+	p, ok := i.policies[req.GetResource()]
+	if !ok {
+		return &iampb.Policy{}, nil
+	}
+	p = clone(p)
+	if req.GetOptions().GetRequestedPolicyVersion() < 3 {
+		p.Version = 1
+		for n, b := range p.GetBindings() {
+			if b.GetCondition() != nil {
+				b.Role = fmt.Sprintf("%s_withcond_%d", b.GetRole(), n)
+				b.Condition = nil
+			}
+		}
+	}
+	return p, nil
+}
+
+// SetIamPolicy replaces the IAM policy of a project, conditional bindings need a version 3
+// policy
+func (i *IAM) SetIamPolicy(ctx context.Context, req *iampb.SetIamPolicyRequest, opts ...gax.CallOption) (*iampb.Policy, error) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	if err := i.call("SetIamPolicy"); err != nil {
+		return nil, err
+	}
+	for _, b := range req.GetPolicy().GetBindings() {
+		if strings.Contains(b.GetRole(), "_withcond_") {
+			return nil, grpcInvalidArgument(fmt.Sprintf("role %s is not supported", b.GetRole()))
+		}
+		if b.GetCondition() != nil && req.GetPolicy().GetVersion() < 3 {
+			return nil, grpcInvalidArgument("conditional bindings need policy version 3")
+		}
+	}
+	i.policies[req.GetResource()] = clone(req.GetPolicy())
+	return clone(req.GetPolicy()), nil
+}
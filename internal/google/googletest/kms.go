@@ -0,0 +1,262 @@
+package googletest
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	iampb "cloud.google.com/go/iam/apiv1/iampb"
+	kmspb "cloud.google.com/go/kms/apiv1/kmspb"
+	"github.com/googleapis/gax-go/v2"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// KMS is a fake gcp.KMSClient
+type KMS struct {
+	Recorder
+	keyRings map[string]*kmspb.KeyRing
+	keys     map[string]*kmspb.CryptoKey
+	versions map[string]*kmspb.CryptoKeyVersion
+	policies map[string]*iampb.Policy
+}
+
+// NewKMS returns an empty fake KMS client
+func NewKMS() *KMS {
+	return &KMS{
+		keyRings: map[string]*kmspb.KeyRing{},
+		keys:     map[string]*kmspb.CryptoKey{},
+		versions: map[string]*kmspb.CryptoKeyVersion{},
+		policies: map[string]*iampb.Policy{},
+	}
+}
+
+// SetVersionState changes the state of a key version, to simulate it being disabled or destroyed
+func (k *KMS) SetVersionState(name string, state kmspb.CryptoKeyVersion_CryptoKeyVersionState) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if v, ok := k.versions[name]; ok {
+		v.State = state
+	}
+}
+
+// Policy returns the IAM policy of a resource
+func (k *KMS) Policy(resource string) *iampb.Policy {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if p, ok := k.policies[resource]; ok {
+		return clone(p)
+	}
+	return &iampb.Policy{}
+}
+
+// GetKeyRing returns a keyring
+func (k *KMS) GetKeyRing(ctx context.Context, req *kmspb.GetKeyRingRequest, opts ...gax.CallOption) (*kmspb.KeyRing, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if err := k.call("GetKeyRing"); err != nil {
+		return nil, err
+	}
+	ring, ok := k.keyRings[req.GetName()]
+	if !ok {
+		return nil, grpcNotFound(req.GetName())
+	}
+	return clone(ring), nil
+}
+
+// CreateKeyRing creates a keyring
+func (k *KMS) CreateKeyRing(ctx context.Context, req *kmspb.CreateKeyRingRequest, opts ...gax.CallOption) (*kmspb.KeyRing, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if err := k.call("CreateKeyRing"); err != nil {
+		return nil, err
+	}
+	name := fmt.Sprintf("%s/keyRings/%s", req.GetParent(), req.GetKeyRingId())
+	if _, ok := k.keyRings[name]; ok {
+		return nil, grpcAlreadyExists(name)
+	}
+	ring := &kmspb.KeyRing{
+		Name:       name,
+		CreateTime: timestamppb.Now(),
+	}
+	k.keyRings[name] = ring
+	return clone(ring), nil
+}
+
+// GetCryptoKey returns a crypto key with its current primary version
+func (k *KMS) GetCryptoKey(ctx context.Context, req *kmspb.GetCryptoKeyRequest, opts ...gax.CallOption) (*kmspb.CryptoKey, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if err := k.call("GetCryptoKey"); err != nil {
+		return nil, err
+	}
+	key, ok := k.keys[req.GetName()]
+	if !ok {
+		return nil, grpcNotFound(req.GetName())
+	}
+	return k.withPrimary(key), nil
+}
+
+// withPrimary returns a copy of key with the latest copy of its primary version
+func (k *KMS) withPrimary(key *kmspb.CryptoKey) *kmspb.CryptoKey {
+	c := clone(key)
+	if v, ok := k.versions[key.GetPrimary().GetName()]; ok {
+		c.Primary = clone(v)
+	}
+	return c
+}
+
+// CreateCryptoKey creates a crypto key and its first version
+func (k *KMS) CreateCryptoKey(ctx context.Context, req *kmspb.CreateCryptoKeyRequest, opts ...gax.CallOption) (*kmspb.CryptoKey, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if err := k.call("CreateCryptoKey"); err != nil {
+		return nil, err
+	}
+	if _, ok := k.keyRings[req.GetParent()]; !ok {
+		return nil, grpcNotFound(req.GetParent())
+	}
+	name := fmt.Sprintf("%s/cryptoKeys/%s", req.GetParent(), req.GetCryptoKeyId())
+	if _, ok := k.keys[name]; ok {
+		return nil, grpcAlreadyExists(name)
+	}
+	key := clone(req.GetCryptoKey())
+	key.Name = name
+	key.CreateTime = timestamppb.Now()
+	if !req.GetSkipInitialVersionCreation() {
+		key.Primary = k.newVersion(name)
+	}
+	k.keys[name] = key
+	return k.withPrimary(key), nil
+}
+
+// newVersion adds an enabled version to a crypto key, it must be called with mu held
+func (k *KMS) newVersion(key string) *kmspb.CryptoKeyVersion {
+	n := 1
+	for name := range k.versions {
+		if strings.HasPrefix(name, key+"/cryptoKeyVersions/") {
+			n++
+		}
+	}
+	v := &kmspb.CryptoKeyVersion{
+		Name:       fmt.Sprintf("%s/cryptoKeyVersions/%d", key, n),
+		State:      kmspb.CryptoKeyVersion_ENABLED,
+		CreateTime: timestamppb.Now(),
+	}
+	k.versions[v.Name] = v
+	return clone(v)
+}
+
+// GetCryptoKeyVersion returns a key version
+func (k *KMS) GetCryptoKeyVersion(ctx context.Context, req *kmspb.GetCryptoKeyVersionRequest, opts ...gax.CallOption) (*kmspb.CryptoKeyVersion, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if err := k.call("GetCryptoKeyVersion"); err != nil {
+		return nil, err
+	}
+	v, ok := k.versions[req.GetName()]
+	if !ok {
+		return nil, grpcNotFound(req.GetName())
+	}
+	return clone(v), nil
+}
+
+// CreateCryptoKeyVersion adds an enabled version to a crypto key
+func (k *KMS) CreateCryptoKeyVersion(ctx context.Context, req *kmspb.CreateCryptoKeyVersionRequest, opts ...gax.CallOption) (*kmspb.CryptoKeyVersion, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if err := k.call("CreateCryptoKeyVersion"); err != nil {
+		return nil, err
+	}
+	if _, ok := k.keys[req.GetParent()]; !ok {
+		return nil, grpcNotFound(req.GetParent())
+	}
+	return k.newVersion(req.GetParent()), nil
+}
+
+// UpdateCryptoKeyVersion changes the state of a key version, only ENABLED and DISABLED are allowed
+func (k *KMS) UpdateCryptoKeyVersion(ctx context.Context, req *kmspb.UpdateCryptoKeyVersionRequest, opts ...gax.CallOption) (*kmspb.CryptoKeyVersion, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if err := k.call("UpdateCryptoKeyVersion"); err != nil {
+		return nil, err
+	}
+	want := req.GetCryptoKeyVersion()
+	v, ok := k.versions[want.GetName()]
+	if !ok {
+		return nil, grpcNotFound(want.GetName())
+	}
+	switch v.GetState() {
+	case kmspb.CryptoKeyVersion_ENABLED, kmspb.CryptoKeyVersion_DISABLED:
+	default:
+		return nil, status.Errorf(codes.FailedPrecondition, "%s is %s", v.GetName(), v.GetState())
+	}
+	v.State = want.GetState()
+	return clone(v), nil
+}
+
+// UpdateCryptoKeyPrimaryVersion makes a version the primary, the version is given by its id
+func (k *KMS) UpdateCryptoKeyPrimaryVersion(ctx context.Context, req *kmspb.UpdateCryptoKeyPrimaryVersionRequest, opts ...gax.CallOption) (*kmspb.CryptoKey, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if err := k.call("UpdateCryptoKeyPrimaryVersion"); err != nil {
+		return nil, err
+	}
+	key, ok := k.keys[req.GetName()]
+	if !ok {
+		return nil, grpcNotFound(req.GetName())
+	}
+	name := fmt.Sprintf("%s/cryptoKeyVersions/%s", req.GetName(), req.GetCryptoKeyVersionId())
+	v, ok := k.versions[name]
+	if !ok {
+		return nil, status.Errorf(codes.InvalidArgument, "%s is not a version of %s", req.GetCryptoKeyVersionId(), req.GetName())
+	}
+	if v.GetState() != kmspb.CryptoKeyVersion_ENABLED {
+		return nil, status.Errorf(codes.FailedPrecondition, "%s is %s", name, v.GetState())
+	}
+	key.Primary = clone(v)
+	return k.withPrimary(key), nil
+}
+
+// RestoreCryptoKeyVersion cancels the scheduled destruction of a version, leaving it disabled
+func (k *KMS) RestoreCryptoKeyVersion(ctx context.Context, req *kmspb.RestoreCryptoKeyVersionRequest, opts ...gax.CallOption) (*kmspb.CryptoKeyVersion, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if err := k.call("RestoreCryptoKeyVersion"); err != nil {
+		return nil, err
+	}
+	v, ok := k.versions[req.GetName()]
+	if !ok {
+		return nil, grpcNotFound(req.GetName())
+	}
+	if v.GetState() != kmspb.CryptoKeyVersion_DESTROY_SCHEDULED {
+		return nil, status.Errorf(codes.FailedPrecondition, "%s is %s", v.GetName(), v.GetState())
+	}
+	v.State = kmspb.CryptoKeyVersion_DISABLED
+	return clone(v), nil
+}
+
+// GetIamPolicy returns the IAM policy of a resource
+func (k *KMS) GetIamPolicy(ctx context.Context, req *iampb.GetIamPolicyRequest, opts ...gax.CallOption) (*iampb.Policy, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if err := k.call("GetIamPolicy"); err != nil {
+		return nil, err
+	}
+	if p, ok := k.policies[req.GetResource()]; ok {
+		return clone(p), nil
+	}
+	return &iampb.Policy{}, nil
+}
+
+// SetIamPolicy replaces the IAM policy of a resource
+func (k *KMS) SetIamPolicy(ctx context.Context, req *iampb.SetIamPolicyRequest, opts ...gax.CallOption) (*iampb.Policy, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if err := k.call("SetIamPolicy"); err != nil {
+		return nil, err
+	}
+	k.policies[req.GetResource()] = clone(req.GetPolicy())
+	return clone(req.GetPolicy()), nil
+}
@@ -3,9 +3,10 @@ package google
 import (
 	"context"
 	"fmt"
+	"tidalwave/internal/google/gcp"
+	"tidalwave/internal/tidalwave"
 
-	kms "cloud.google.com/go/kms/apiv1"
-	kmspb "google.golang.org/genproto/googleapis/cloud/kms/v1"
+	kmspb "cloud.google.com/go/kms/apiv1/kmspb"
 )
 
 // Keyring represents a KMS Keyring
@@ -16,7 +17,7 @@ type Keyring struct {
 }
 
 // Create KMS Keyring
-func (k *Keyring) create(ctx context.Context, client *kms.KeyManagementClient) (*kmspb.KeyRing, error) {
+func (k *Keyring) create(ctx context.Context, client gcp.KMSClient) (*kmspb.KeyRing, error) {
 	if k.exists(ctx, client) {
 		return k.get(ctx, client)
 	}
@@ -33,10 +34,15 @@ func (k *Keyring) create(ctx context.Context, client *kms.KeyManagementClient) (
 	return resp, nil
 }
 
+// Full resource name of the KMS Keyring
+func (k *Keyring) name() string {
+	return fmt.Sprintf("projects/%s/locations/%s/keyRings/%s", k.ProjectID, k.Region, k.Name)
+}
+
 // Get KMS Keyring
-func (k *Keyring) get(ctx context.Context, client *kms.KeyManagementClient) (*kmspb.KeyRing, error) {
+func (k *Keyring) get(ctx context.Context, client gcp.KMSClient) (*kmspb.KeyRing, error) {
 	req := &kmspb.GetKeyRingRequest{
-		Name: fmt.Sprintf("projects/%s/locations/%s/keyRings/%s", k.ProjectID, k.Region, k.Name),
+		Name: k.name(),
 	}
 	resp, err := client.GetKeyRing(ctx, req)
 	if err != nil {
@@ -46,7 +52,19 @@ func (k *Keyring) get(ctx context.Context, client *kms.KeyManagementClient) (*km
 }
 
 // Check if KMS Keyring exists
-func (k *Keyring) exists(ctx context.Context, client *kms.KeyManagementClient) bool {
+func (k *Keyring) exists(ctx context.Context, client gcp.KMSClient) bool {
 	_, err := k.get(ctx, client)
 	return err == nil
 }
+
+// Diff KMS Keyring against the config, keyrings have no mutable fields
+func (k *Keyring) diff(ctx context.Context, client gcp.KMSClient) (*tidalwave.ResourcePlan, error) {
+	_, err := k.get(ctx, client)
+	if isNotFound(err) {
+		return tidalwave.NewResourcePlan("keyring", k.Name, false, nil), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return tidalwave.NewResourcePlan("keyring", k.Name, true, nil), nil
+}
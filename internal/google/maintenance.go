@@ -0,0 +1,105 @@
+package google
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"tidalwave/internal/google/gcp"
+	"time"
+
+	containerpb "cloud.google.com/go/container/apiv1/containerpb"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// DailyMaintenanceWindow returns a maintenance policy with a daily window starting at startTime,
+// a time of day in UTC such as 06:00
+func DailyMaintenanceWindow(startTime string) *containerpb.MaintenancePolicy {
+	return &containerpb.MaintenancePolicy{
+		Window: &containerpb.MaintenanceWindow{
+			Policy: &containerpb.MaintenanceWindow_DailyMaintenanceWindow{
+				DailyMaintenanceWindow: &containerpb.DailyMaintenanceWindow{
+					StartTime: startTime,
+				},
+			},
+		},
+	}
+}
+
+// RecurringMaintenanceWindow returns a maintenance policy with a window from start to end that
+// repeats on an RFC 5545 RRULE such as FREQ=WEEKLY;BYDAY=SA,SU
+func RecurringMaintenanceWindow(start, end time.Time, recurrence string) *containerpb.MaintenancePolicy {
+	return &containerpb.MaintenancePolicy{
+		Window: &containerpb.MaintenanceWindow{
+			Policy: &containerpb.MaintenanceWindow_RecurringWindow{
+				RecurringWindow: &containerpb.RecurringTimeWindow{
+					Window: &containerpb.TimeWindow{
+						StartTime: timestamppb.New(start),
+						EndTime:   timestamppb.New(end),
+					},
+					Recurrence: recurrence,
+				},
+			},
+		},
+	}
+}
+
+// MaintenanceExclusion returns a period when GKE holds back the upgrades of scope
+func MaintenanceExclusion(start, end time.Time, scope containerpb.MaintenanceExclusionOptions_Scope) *containerpb.TimeWindow {
+	return &containerpb.TimeWindow{
+		StartTime: timestamppb.New(start),
+		EndTime:   timestamppb.New(end),
+		Options: &containerpb.TimeWindow_MaintenanceExclusionOptions{
+			MaintenanceExclusionOptions: &containerpb.MaintenanceExclusionOptions{
+				Scope: scope,
+			},
+		},
+	}
+}
+
+// maintenancePolicy returns the configured maintenance policy, a daily window at 06:00 if unset
+func (c *Cluster) maintenancePolicy() *containerpb.MaintenancePolicy {
+	if c.MaintenancePolicy == nil {
+		return DailyMaintenanceWindow("06:00")
+	}
+	return c.MaintenancePolicy
+}
+
+// setMaintenancePolicy replaces the maintenance policy of a live cluster, the resource version
+// of the live policy guards against concurrent changes
+func (c *Cluster) setMaintenancePolicy(ctx context.Context, client gcp.ContainerClient, cluster *containerpb.Cluster) error {
+	policy := proto.Clone(c.maintenancePolicy()).(*containerpb.MaintenancePolicy)
+	policy.ResourceVersion = cluster.GetMaintenancePolicy().GetResourceVersion()
+	op, err := client.SetMaintenancePolicy(ctx, &containerpb.SetMaintenancePolicyRequest{
+		Name:              c.name(),
+		MaintenancePolicy: policy,
+	})
+	if err != nil {
+		return err
+	}
+	return c.wait(ctx, client, op, ":beer:", "Cluster maintenance policy is being updated")
+}
+
+// maintenanceList formats the window and exclusions of a maintenance policy
+func maintenanceList(p *containerpb.MaintenancePolicy) string {
+	items := []string{}
+	w := p.GetWindow()
+	if daily := w.GetDailyMaintenanceWindow(); daily != nil {
+		items = append(items, "daily "+daily.GetStartTime())
+	}
+	if r := w.GetRecurringWindow(); r != nil {
+		items = append(items, fmt.Sprintf("%s %s", timeWindowString(r.GetWindow()), r.GetRecurrence()))
+	}
+	exclusions := []string{}
+	for name, e := range w.GetMaintenanceExclusions() {
+		exclusions = append(exclusions, fmt.Sprintf("%s=%s %s", name, timeWindowString(e), e.GetMaintenanceExclusionOptions().GetScope()))
+	}
+	sort.Strings(exclusions)
+	return strings.Join(append(items, exclusions...), ",")
+}
+
+// timeWindowString formats a time window as start/end in RFC 3339
+func timeWindowString(w *containerpb.TimeWindow) string {
+	return fmt.Sprintf("%s/%s", w.GetStartTime().AsTime().Format(time.RFC3339), w.GetEndTime().AsTime().Format(time.RFC3339))
+}
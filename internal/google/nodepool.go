@@ -0,0 +1,165 @@
+package google
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"tidalwave/internal/tidalwave"
+
+	containerpb "cloud.google.com/go/container/apiv1/containerpb"
+)
+
+// DefaultPool is the name of the node pool built from the spec.cluster settings
+const DefaultPool = "default-pool"
+
+// NodePool represents a node pool of a GKE cluster
+type NodePool struct {
+	Name        string
+	MachineType string
+	// DiskType is the boot disk type of the nodes, pd-ssd if empty
+	DiskType     string
+	DiskSizeGb   int32
+	MinNodeCount int32
+	MaxNodeCount int32
+	Spot         bool
+	Preemptible  bool
+	Labels       map[string]string
+	Taints       []*containerpb.NodeTaint
+	// Tags are network tags of the nodes besides the pool name, which every pool is tagged with
+	Tags []string
+	// ServiceAccount is the email the nodes run as, the service account of the cluster if empty
+	ServiceAccount string
+	// WorkloadMetadata is the metadata server the pods see, GKE_METADATA if unspecified
+	WorkloadMetadata containerpb.WorkloadMetadataConfig_Mode
+	// MaxSurge and MaxUnavailable are how many nodes are added and taken away at a time while
+	// the pool is upgraded, one of each if both are zero
+	MaxSurge       int32
+	MaxUnavailable int32
+	// pinned turns node auto-upgrade off so the pool stays on the version of a cluster pinned
+	// to one
+	pinned bool
+}
+
+// upgradeSettings returns the surge settings of the pool
+func (p *NodePool) upgradeSettings() *containerpb.NodePool_UpgradeSettings {
+	if p.MaxSurge == 0 && p.MaxUnavailable == 0 {
+		return &containerpb.NodePool_UpgradeSettings{MaxSurge: 1, MaxUnavailable: 1}
+	}
+	return &containerpb.NodePool_UpgradeSettings{MaxSurge: p.MaxSurge, MaxUnavailable: p.MaxUnavailable}
+}
+
+// workloadMetadata returns the metadata server the pods see
+func (p *NodePool) workloadMetadata() containerpb.WorkloadMetadataConfig_Mode {
+	if p.WorkloadMetadata == containerpb.WorkloadMetadataConfig_MODE_UNSPECIFIED {
+		return containerpb.WorkloadMetadataConfig_GKE_METADATA
+	}
+	return p.WorkloadMetadata
+}
+
+// diskType returns the configured boot disk type
+func (p *NodePool) diskType() string {
+	if p.DiskType == "" {
+		return "pd-ssd"
+	}
+	return p.DiskType
+}
+
+// tags returns the network tags of the nodes, the pool name first
+func (p *NodePool) tags() []string {
+	tags := []string{p.Name}
+	for _, t := range p.Tags {
+		if t != p.Name {
+			tags = append(tags, t)
+		}
+	}
+	return tags
+}
+
+// nodePool returns the GKE node pool
+func (p *NodePool) nodePool() *containerpb.NodePool {
+	return &containerpb.NodePool{
+		Name: p.Name,
+		Config: &containerpb.NodeConfig{
+			MachineType: p.MachineType,
+			DiskSizeGb:  p.DiskSizeGb,
+			OauthScopes: []string{
+				"https://www.googleapis.com/auth/devstorage.read_only",
+				"https://www.googleapis.com/auth/logging.write",
+				"https://www.googleapis.com/auth/monitoring",
+				"https://www.googleapis.com/auth/servicecontrol",
+				"https://www.googleapis.com/auth/service.management.readonly",
+				"https://www.googleapis.com/auth/trace.append",
+			},
+			Tags:           p.tags(),
+			DiskType:       p.diskType(),
+			Spot:           p.Spot,
+			Preemptible:    p.Preemptible,
+			Labels:         p.Labels,
+			Taints:         p.Taints,
+			ServiceAccount: p.ServiceAccount,
+			WorkloadMetadataConfig: &containerpb.WorkloadMetadataConfig{
+				Mode: p.workloadMetadata(),
+			},
+			ShieldedInstanceConfig: &containerpb.ShieldedInstanceConfig{
+				EnableSecureBoot: true,
+			},
+		},
+		InitialNodeCount: 1,
+		Autoscaling: &containerpb.NodePoolAutoscaling{
+			Enabled:      true,
+			MinNodeCount: p.MinNodeCount,
+			MaxNodeCount: p.MaxNodeCount,
+		},
+		Management: &containerpb.NodeManagement{
+			AutoUpgrade: !p.pinned,
+			AutoRepair:  true,
+		},
+		UpgradeSettings: p.upgradeSettings(),
+	}
+}
+
+// Compare a live node pool with the config
+func (p *NodePool) compare(pool *containerpb.NodePool) []tidalwave.Change {
+	want := p.nodePool()
+	d := differ{}
+	d.forceNew("config.machineType", pool.GetConfig().GetMachineType(), want.GetConfig().GetMachineType())
+	if p.DiskSizeGb != 0 {
+		d.forceNew("config.diskSizeGb", fmt.Sprint(pool.GetConfig().GetDiskSizeGb()), fmt.Sprint(want.GetConfig().GetDiskSizeGb()))
+	}
+	d.forceNew("config.diskType", pool.GetConfig().GetDiskType(), want.GetConfig().GetDiskType())
+	d.forceNew("config.spot", fmt.Sprint(pool.GetConfig().GetSpot()), fmt.Sprint(p.Spot))
+	d.forceNew("config.preemptible", fmt.Sprint(pool.GetConfig().GetPreemptible()), fmt.Sprint(p.Preemptible))
+	if p.ServiceAccount != "" {
+		d.forceNew("config.serviceAccount", pool.GetConfig().GetServiceAccount(), p.ServiceAccount)
+	}
+	d.field("config.tags", list(pool.GetConfig().GetTags()), list(want.GetConfig().GetTags()))
+	d.field("config.labels", labelList(pool.GetConfig().GetLabels()), labelList(p.Labels))
+	d.field("config.taints", taintList(pool.GetConfig().GetTaints()), taintList(p.Taints))
+	d.field("config.workloadMetadataConfig.mode", pool.GetConfig().GetWorkloadMetadataConfig().GetMode().String(), want.GetConfig().GetWorkloadMetadataConfig().GetMode().String())
+	d.field("management.autoUpgrade", fmt.Sprint(pool.GetManagement().GetAutoUpgrade()), fmt.Sprint(want.GetManagement().GetAutoUpgrade()))
+	d.field("autoscaling.minNodeCount", fmt.Sprint(pool.GetAutoscaling().GetMinNodeCount()), fmt.Sprint(want.GetAutoscaling().GetMinNodeCount()))
+	d.field("autoscaling.maxNodeCount", fmt.Sprint(pool.GetAutoscaling().GetMaxNodeCount()), fmt.Sprint(want.GetAutoscaling().GetMaxNodeCount()))
+	d.field("upgradeSettings", fmt.Sprintf("maxSurge=%d,maxUnavailable=%d", pool.GetUpgradeSettings().GetMaxSurge(), pool.GetUpgradeSettings().GetMaxUnavailable()),
+		fmt.Sprintf("maxSurge=%d,maxUnavailable=%d", want.GetUpgradeSettings().GetMaxSurge(), want.GetUpgradeSettings().GetMaxUnavailable()))
+	return d.changes
+}
+
+// labelList formats node labels sorted by key
+func labelList(labels map[string]string) string {
+	items := make([]string, 0, len(labels))
+	for k, v := range labels {
+		items = append(items, k+"="+v)
+	}
+	sort.Strings(items)
+	return strings.Join(items, ",")
+}
+
+// taintList formats node taints as key=value:effect
+func taintList(taints []*containerpb.NodeTaint) string {
+	items := make([]string, 0, len(taints))
+	for _, t := range taints {
+		items = append(items, fmt.Sprintf("%s=%s:%s", t.GetKey(), t.GetValue(), t.GetEffect()))
+	}
+	sort.Strings(items)
+	return strings.Join(items, ",")
+}
@@ -0,0 +1,229 @@
+package google
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"strings"
+	"tidalwave/internal/tidalwave"
+	"time"
+
+	computepb "cloud.google.com/go/compute/apiv1/computepb"
+	containerpb "cloud.google.com/go/container/apiv1/containerpb"
+	kmspb "cloud.google.com/go/kms/apiv1/kmspb"
+)
+
+// OperationError is returned when a long-running operation finishes unsuccessfully
+type OperationError struct {
+	// Name is the full resource name of the operation
+	Name string
+	// Status is the final status reported by the API, e.g. ABORTING
+	Status string
+	// Detail is the error message reported by the API
+	Detail string
+}
+
+func (e *OperationError) Error() string {
+	if e.Detail == "" {
+		return fmt.Sprintf("operation %s finished with status %s", e.Name, e.Status)
+	}
+	return fmt.Sprintf("operation %s finished with status %s: %s", e.Name, e.Status, e.Detail)
+}
+
+// clock is the time source used by waiter, replaced by a fake in tests
+type clock interface {
+	Now() time.Time
+	After(d time.Duration) <-chan time.Time
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time                         { return time.Now() }
+func (realClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
+
+// pollFunc checks an operation once. It returns whether the operation is done and its progress
+// as a percentage, or -1 if the API does not report progress.
+type pollFunc func(ctx context.Context) (done bool, percent int, err error)
+
+// waiter polls long-running operations with exponential backoff and jitter
+type waiter struct {
+	clock clock
+	// initial is the delay before the second poll
+	initial time.Duration
+	// max caps the delay between polls
+	max time.Duration
+	// multiplier grows the delay after every poll
+	multiplier float64
+	// jitter is the fraction of the delay that is randomised, 0.2 waits between 80% and 120%
+	jitter float64
+	// random returns a number in [0, 1)
+	random func() float64
+	// progress is called when the reported percentage changes
+	progress func(ctx context.Context, name, description string, percent int)
+}
+
+// newWaiter returns a waiter suitable for GKE and KMS operations
+func newWaiter() *waiter {
+	return &waiter{
+		clock:      realClock{},
+		initial:    2 * time.Second,
+		max:        30 * time.Second,
+		multiplier: 1.5,
+		jitter:     0.2,
+		random:     rand.Float64,
+		progress: func(ctx context.Context, name, description string, percent int) {
+			tidalwave.Emit(ctx, tidalwave.Event{
+				Phase:     tidalwave.PhaseProgress,
+				Operation: name,
+				Progress:  percent,
+				Emoji:     ":hourglass_not_done:",
+				Message:   fmt.Sprintf("%s (%d%%)", description, percent),
+			})
+		},
+	}
+}
+
+// delay returns d with jitter applied
+func (w *waiter) delay(d time.Duration) time.Duration {
+	if w.jitter <= 0 {
+		return d
+	}
+	return time.Duration(float64(d) * (1 - w.jitter + 2*w.jitter*w.random()))
+}
+
+// wait calls poll until the operation is done, ctx is cancelled or the ctx deadline would pass
+// before the next poll. The operation is recorded as running while it is waited on.
+func (w *waiter) wait(ctx context.Context, name, description string, poll pollFunc) error {
+	defer tidalwave.StartOperation(ctx, name, description)()
+	next := w.initial
+	last := -1
+	for {
+		done, percent, err := poll(ctx)
+		if err != nil {
+			return err
+		}
+		if done {
+			return nil
+		}
+		if percent >= 0 && percent != last {
+			last = percent
+			if w.progress != nil {
+				w.progress(ctx, name, description, percent)
+			}
+		}
+		if err := ctx.Err(); err != nil {
+			return fmt.Errorf("operation %s: %w", name, err)
+		}
+		d := w.delay(next)
+		if deadline, ok := ctx.Deadline(); ok && w.clock.Now().Add(d).After(deadline) {
+			return fmt.Errorf("operation %s did not finish before the deadline: %w", name, context.DeadlineExceeded)
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("operation %s: %w", name, ctx.Err())
+		case <-w.clock.After(d):
+		}
+		next = time.Duration(float64(next) * w.multiplier)
+		if next > w.max {
+			next = w.max
+		}
+	}
+}
+
+// gkeProgress works out a percentage from the progress of a GKE operation, the nodes done out of
+// NODES_TOTAL or, for operations without node metrics, the stages that are done. It returns -1
+// if there is neither.
+func gkeProgress(p *containerpb.OperationProgress) int {
+	values := map[string]int64{}
+	for _, m := range p.GetMetrics() {
+		values[m.GetName()] = m.GetIntValue()
+	}
+	if total := values["NODES_TOTAL"]; total > 0 {
+		done, ok := values["NODES_DONE"]
+		if !ok {
+			done = values["NODES_COMPLETE"]
+		}
+		return int(done * 100 / total)
+	}
+	stages := p.GetStages()
+	if len(stages) == 0 {
+		return -1
+	}
+	done := 0
+	for _, s := range stages {
+		if s.GetStatus() == containerpb.Operation_DONE {
+			done++
+		}
+	}
+	return done * 100 / len(stages)
+}
+
+// gkeOperationError returns the error for a finished GKE operation, nil if it succeeded
+func gkeOperationError(name string, op *containerpb.Operation) error {
+	detail := op.GetError().GetMessage()
+	if op.GetStatus() == containerpb.Operation_DONE && detail == "" {
+		return nil
+	}
+	if detail == "" {
+		detail = op.GetStatusMessage()
+	}
+	return &OperationError{
+		Name:   name,
+		Status: op.GetStatus().String(),
+		Detail: detail,
+	}
+}
+
+// computeOperationError returns an OperationError if a finished compute operation failed
+func computeOperationError(op *computepb.Operation) error {
+	errs := op.GetError().GetErrors()
+	if len(errs) == 0 {
+		return nil
+	}
+	details := []string{}
+	for _, e := range errs {
+		details = append(details, fmt.Sprintf("%s: %s", e.GetCode(), e.GetMessage()))
+	}
+	return &OperationError{
+		Name:   op.GetName(),
+		Status: op.GetStatus().String(),
+		Detail: strings.Join(details, ", "),
+	}
+}
+
+// pollGke returns a pollFunc for a GKE operation
+func pollGke(name string, get func(ctx context.Context) (*containerpb.Operation, error)) pollFunc {
+	return func(ctx context.Context) (bool, int, error) {
+		op, err := get(ctx)
+		if err != nil {
+			return false, -1, err
+		}
+		switch op.GetStatus() {
+		case containerpb.Operation_DONE, containerpb.Operation_ABORTING:
+			return true, 100, gkeOperationError(name, op)
+		}
+		return false, gkeProgress(op.GetProgress()), nil
+	}
+}
+
+// pollKeyVersion returns a pollFunc that waits for a key version to reach want, failing if
+// it ends up in one of the failed states instead
+func pollKeyVersion(want kmspb.CryptoKeyVersion_CryptoKeyVersionState, get func(ctx context.Context) (*kmspb.CryptoKeyVersion, error)) pollFunc {
+	return func(ctx context.Context) (bool, int, error) {
+		kv, err := get(ctx)
+		if err != nil {
+			return false, -1, err
+		}
+		switch kv.GetState() {
+		case want:
+			return true, 100, nil
+		case kmspb.CryptoKeyVersion_DESTROYED, kmspb.CryptoKeyVersion_IMPORT_FAILED:
+			return true, -1, &OperationError{
+				Name:   kv.GetName(),
+				Status: kv.GetState().String(),
+				Detail: fmt.Sprintf("expected key version to become %s", want),
+			}
+		}
+		return false, -1, nil
+	}
+}
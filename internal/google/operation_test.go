@@ -0,0 +1,233 @@
+package google
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	containerpb "cloud.google.com/go/container/apiv1/containerpb"
+	kmspb "cloud.google.com/go/kms/apiv1/kmspb"
+	status "google.golang.org/genproto/googleapis/rpc/status"
+)
+
+// fakeClock advances instantly and records every wait
+type fakeClock struct {
+	now   time.Time
+	waits []time.Duration
+}
+
+func (c *fakeClock) Now() time.Time {
+	return c.now
+}
+
+func (c *fakeClock) After(d time.Duration) <-chan time.Time {
+	c.waits = append(c.waits, d)
+	c.now = c.now.Add(d)
+	ch := make(chan time.Time, 1)
+	ch <- c.now
+	return ch
+}
+
+func testWaiter(c *fakeClock) *waiter {
+	return &waiter{
+		clock:      c,
+		initial:    time.Second,
+		max:        8 * time.Second,
+		multiplier: 2,
+		jitter:     0,
+	}
+}
+
+// gkeOps returns a get func that reports each operation in turn
+func gkeOps(ops ...*containerpb.Operation) func(context.Context) (*containerpb.Operation, error) {
+	i := 0
+	return func(context.Context) (*containerpb.Operation, error) {
+		op := ops[i]
+		if i < len(ops)-1 {
+			i++
+		}
+		return op, nil
+	}
+}
+
+func running(done, total int64) *containerpb.Operation {
+	return &containerpb.Operation{
+		Status: containerpb.Operation_RUNNING,
+		Progress: &containerpb.OperationProgress{
+			Metrics: []*containerpb.OperationProgress_Metric{
+				{Name: "NODES_TOTAL", Value: &containerpb.OperationProgress_Metric_IntValue{IntValue: total}},
+				{Name: "NODES_FAILED", Value: &containerpb.OperationProgress_Metric_IntValue{IntValue: 0}},
+				{Name: "NODES_COMPLETE", Value: &containerpb.OperationProgress_Metric_IntValue{IntValue: done}},
+				{Name: "NODES_DONE", Value: &containerpb.OperationProgress_Metric_IntValue{IntValue: done}},
+			},
+		},
+	}
+}
+
+func TestWaitBacksOff(t *testing.T) {
+	c := &fakeClock{now: time.Unix(0, 0)}
+	w := testWaiter(c)
+	ops := []*containerpb.Operation{}
+	for i := 0; i < 6; i++ {
+		ops = append(ops, &containerpb.Operation{Status: containerpb.Operation_RUNNING})
+	}
+	ops = append(ops, &containerpb.Operation{Status: containerpb.Operation_DONE})
+
+	if err := w.wait(context.Background(), "op", "test", pollGke("op", gkeOps(ops...))); err != nil {
+		t.Fatal(err)
+	}
+	want := []time.Duration{1, 2, 4, 8, 8, 8}
+	if len(c.waits) != len(want) {
+		t.Fatalf("waited %v, want %d waits", c.waits, len(want))
+	}
+	for i, d := range want {
+		if c.waits[i] != d*time.Second {
+			t.Errorf("wait %d was %s, want %s", i, c.waits[i], d*time.Second)
+		}
+	}
+}
+
+func TestWaitJitter(t *testing.T) {
+	w := testWaiter(&fakeClock{})
+	w.jitter = 0.2
+	for _, tc := range []struct {
+		random float64
+		want   time.Duration
+	}{
+		{0, 8 * time.Second},
+		{0.5, 10 * time.Second},
+		{0.99, 11960 * time.Millisecond},
+	} {
+		w.random = func() float64 { return tc.random }
+		if got := w.delay(10 * time.Second); got != tc.want {
+			t.Errorf("delay with random %v = %s, want %s", tc.random, got, tc.want)
+		}
+	}
+}
+
+func TestWaitProgress(t *testing.T) {
+	c := &fakeClock{}
+	w := testWaiter(c)
+	reported := []int{}
+	w.progress = func(ctx context.Context, name, description string, percent int) {
+		reported = append(reported, percent)
+	}
+	get := gkeOps(running(0, 4), running(1, 4), running(1, 4), running(3, 4), &containerpb.Operation{Status: containerpb.Operation_DONE})
+
+	if err := w.wait(context.Background(), "op", "test", pollGke("op", get)); err != nil {
+		t.Fatal(err)
+	}
+	want := []int{0, 25, 75}
+	if len(reported) != len(want) {
+		t.Fatalf("reported %v, want %v", reported, want)
+	}
+	for i := range want {
+		if reported[i] != want[i] {
+			t.Fatalf("reported %v, want %v", reported, want)
+		}
+	}
+}
+
+func TestGkeProgress(t *testing.T) {
+	metric := func(name string, value int64) *containerpb.OperationProgress_Metric {
+		return &containerpb.OperationProgress_Metric{Name: name, Value: &containerpb.OperationProgress_Metric_IntValue{IntValue: value}}
+	}
+	stage := func(status containerpb.Operation_Status) *containerpb.OperationProgress {
+		return &containerpb.OperationProgress{Status: status}
+	}
+	for _, tc := range []struct {
+		name     string
+		progress *containerpb.OperationProgress
+		want     int
+	}{
+		{"none", nil, -1},
+		{"nodes done", &containerpb.OperationProgress{Metrics: []*containerpb.OperationProgress_Metric{metric("NODES_TOTAL", 8), metric("NODES_DONE", 2)}}, 25},
+		{"nodes complete", &containerpb.OperationProgress{Metrics: []*containerpb.OperationProgress_Metric{metric("NODES_TOTAL", 4), metric("NODES_COMPLETE", 3)}}, 75},
+		{"no nodes", &containerpb.OperationProgress{Metrics: []*containerpb.OperationProgress_Metric{metric("NODES_TOTAL", 0)}}, -1},
+		{"stages", &containerpb.OperationProgress{Stages: []*containerpb.OperationProgress{
+			stage(containerpb.Operation_DONE), stage(containerpb.Operation_RUNNING), stage(containerpb.Operation_PENDING), stage(containerpb.Operation_PENDING),
+		}}, 25},
+	} {
+		if got := gkeProgress(tc.progress); got != tc.want {
+			t.Errorf("%s: got %d, want %d", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestWaitOperationError(t *testing.T) {
+	w := testWaiter(&fakeClock{})
+	for _, op := range []*containerpb.Operation{
+		{Status: containerpb.Operation_ABORTING, StatusMessage: "quota exceeded"},
+		{Status: containerpb.Operation_DONE, Error: &status.Status{Message: "quota exceeded"}},
+	} {
+		err := w.wait(context.Background(), "projects/p/locations/l/operations/op", "test", pollGke("projects/p/locations/l/operations/op", gkeOps(op)))
+		var opErr *OperationError
+		if !errors.As(err, &opErr) {
+			t.Fatalf("got %v, want an OperationError", err)
+		}
+		if opErr.Name != "projects/p/locations/l/operations/op" || opErr.Status != op.GetStatus().String() || opErr.Detail != "quota exceeded" {
+			t.Errorf("got %+v", opErr)
+		}
+	}
+}
+
+func TestWaitDeadline(t *testing.T) {
+	c := &fakeClock{now: time.Now()}
+	w := testWaiter(c)
+	ctx, cancel := context.WithDeadline(context.Background(), c.now.Add(10*time.Second))
+	defer cancel()
+	get := gkeOps(&containerpb.Operation{Status: containerpb.Operation_RUNNING})
+
+	err := w.wait(ctx, "op", "test", pollGke("op", get))
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("got %v, want deadline exceeded", err)
+	}
+	// 1s + 2s + 4s fit in the deadline, the next 8s does not
+	if len(c.waits) != 3 {
+		t.Errorf("waited %v before giving up", c.waits)
+	}
+}
+
+func TestWaitCancelled(t *testing.T) {
+	w := testWaiter(&fakeClock{})
+	ctx, cancel := context.WithCancel(context.Background())
+	polls := 0
+	err := w.wait(ctx, "op", "test", func(ctx context.Context) (bool, int, error) {
+		polls++
+		cancel()
+		return false, -1, nil
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("got %v, want cancelled", err)
+	}
+	if polls != 1 {
+		t.Errorf("polled %d times after cancel", polls)
+	}
+}
+
+func TestPollKeyVersion(t *testing.T) {
+	w := testWaiter(&fakeClock{})
+	states := []kmspb.CryptoKeyVersion_CryptoKeyVersionState{
+		kmspb.CryptoKeyVersion_PENDING_GENERATION,
+		kmspb.CryptoKeyVersion_ENABLED,
+	}
+	i := 0
+	get := func(context.Context) (*kmspb.CryptoKeyVersion, error) {
+		kv := &kmspb.CryptoKeyVersion{Name: "key/1", State: states[i]}
+		i++
+		return kv, nil
+	}
+	if err := w.wait(context.Background(), "key/1", "test", pollKeyVersion(kmspb.CryptoKeyVersion_ENABLED, get)); err != nil {
+		t.Fatal(err)
+	}
+
+	destroyed := func(context.Context) (*kmspb.CryptoKeyVersion, error) {
+		return &kmspb.CryptoKeyVersion{Name: "key/1", State: kmspb.CryptoKeyVersion_DESTROYED}, nil
+	}
+	err := w.wait(context.Background(), "key/1", "test", pollKeyVersion(kmspb.CryptoKeyVersion_ENABLED, destroyed))
+	var opErr *OperationError
+	if !errors.As(err, &opErr) || opErr.Status != "DESTROYED" {
+		t.Fatalf("got %v, want a DESTROYED OperationError", err)
+	}
+}
@@ -6,13 +6,12 @@ import (
 	"strings"
 
 	resource "cloud.google.com/go/resourcemanager/apiv3"
-	resourcemanagerpb "google.golang.org/genproto/googleapis/cloud/resourcemanager/v3"
+	resourcemanagerpb "cloud.google.com/go/resourcemanager/apiv3/resourcemanagerpb"
 )
 
 // GetProjectNumber returns a GCP project number from a GCP project id
-func GetProjectNumber(id string) (*string, error) {
-	ctx := context.Background()
-	client, err := resource.NewProjectsClient(ctx)
+func GetProjectNumber(ctx context.Context, id string, endpoints Endpoints) (*string, error) {
+	client, err := resource.NewProjectsClient(ctx, endpoints.grpcOptions(endpoints.ResourceManager)...)
 	if err != nil {
 		return nil, err
 	}
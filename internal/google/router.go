@@ -2,9 +2,11 @@ package google
 
 import (
 	"context"
+	"fmt"
+	"tidalwave/internal/google/gcp"
+	"tidalwave/internal/tidalwave"
 
-	compute "cloud.google.com/go/compute/apiv1"
-	computepb "google.golang.org/genproto/googleapis/cloud/compute/v1"
+	computepb "cloud.google.com/go/compute/apiv1/computepb"
 )
 
 // Router represents a VPC Cloud Router and Cloud Nat
@@ -16,20 +18,14 @@ type Router struct {
 }
 
 // Create Cloud Router
-func (r *Router) create(ctx context.Context, client *compute.RoutersClient) (*computepb.Router, error) {
+func (r *Router) create(ctx context.Context, client gcp.RoutersClient) (*computepb.Router, error) {
 	if r.exists(ctx, client) {
 		return r.get(ctx, client)
 	}
 	req := &computepb.InsertRouterRequest{
 		RouterResource: &computepb.Router{
-			Name: &r.Name,
-			Nats: []*computepb.RouterNat{
-				{
-					Name:                          &r.Name,
-					NatIpAllocateOption:           StrPtr("AUTO_ONLY"),
-					SourceSubnetworkIpRangesToNat: StrPtr("ALL_SUBNETWORKS_ALL_IP_RANGES"),
-				},
-			},
+			Name:    &r.Name,
+			Nats:    []*computepb.RouterNat{r.nat()},
 			Network: &r.Network,
 		},
 		Project: r.ProjectID,
@@ -39,7 +35,7 @@ func (r *Router) create(ctx context.Context, client *compute.RoutersClient) (*co
 	if err != nil {
 		return nil, err
 	}
-	err = op.Wait(ctx)
+	err = waitCompute(ctx, op, fmt.Sprintf("create router %s", r.Name))
 	if err != nil {
 		return nil, err
 	}
@@ -47,7 +43,7 @@ func (r *Router) create(ctx context.Context, client *compute.RoutersClient) (*co
 }
 
 // Get Cloud Router
-func (r *Router) get(ctx context.Context, client *compute.RoutersClient) (*computepb.Router, error) {
+func (r *Router) get(ctx context.Context, client gcp.RoutersClient) (*computepb.Router, error) {
 	req := &computepb.GetRouterRequest{
 		Project: r.ProjectID,
 		Region:  r.Region,
@@ -61,13 +57,51 @@ func (r *Router) get(ctx context.Context, client *compute.RoutersClient) (*compu
 }
 
 // Check if Cloud Router exists
-func (r *Router) exists(ctx context.Context, client *compute.RoutersClient) bool {
+func (r *Router) exists(ctx context.Context, client gcp.RoutersClient) bool {
 	_, err := r.get(ctx, client)
 	return err == nil
 }
 
+// Diff Cloud Router and Cloud Nat against the config
+func (r *Router) diff(ctx context.Context, client gcp.RoutersClient) (*tidalwave.ResourcePlan, error) {
+	router, err := r.get(ctx, client)
+	if isNotFound(err) {
+		return tidalwave.NewResourcePlan("router", r.Name, false, nil), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return tidalwave.NewResourcePlan("router", r.Name, true, r.compare(router)), nil
+}
+
+// Compare a live Cloud Router and Cloud Nat with the config
+func (r *Router) compare(router *computepb.Router) []tidalwave.Change {
+	d := differ{}
+	d.forceNew("network", resourceName(router.GetNetwork()), resourceName(r.Network))
+	nat := &computepb.RouterNat{}
+	for _, n := range router.GetNats() {
+		if n.GetName() == r.Name {
+			nat = n
+		}
+	}
+	want := r.nat()
+	d.field("nats.name", nat.GetName(), want.GetName())
+	d.field("nats.natIpAllocateOption", nat.GetNatIpAllocateOption(), want.GetNatIpAllocateOption())
+	d.field("nats.sourceSubnetworkIpRangesToNat", nat.GetSourceSubnetworkIpRangesToNat(), want.GetSourceSubnetworkIpRangesToNat())
+	return d.changes
+}
+
+// nat returns the Cloud Nat config for the router
+func (r *Router) nat() *computepb.RouterNat {
+	return &computepb.RouterNat{
+		Name:                          StrPtr(r.Name),
+		NatIpAllocateOption:           StrPtr("AUTO_ONLY"),
+		SourceSubnetworkIpRangesToNat: StrPtr("ALL_SUBNETWORKS_ALL_IP_RANGES"),
+	}
+}
+
 // Delete Cloud Router
-func (r *Router) delete(ctx context.Context, client *compute.RoutersClient) error {
+func (r *Router) delete(ctx context.Context, client gcp.RoutersClient) error {
 	if r.exists(ctx, client) {
 		req := &computepb.DeleteRouterRequest{
 			Project: r.ProjectID,
@@ -78,7 +112,7 @@ func (r *Router) delete(ctx context.Context, client *compute.RoutersClient) erro
 		if err != nil {
 			return err
 		}
-		err = op.Wait(ctx)
+		err = waitCompute(ctx, op, fmt.Sprintf("delete router %s", r.Name))
 		if err != nil {
 			return err
 		}
@@ -86,22 +120,33 @@ func (r *Router) delete(ctx context.Context, client *compute.RoutersClient) erro
 	return nil
 }
 
-// Update Cloud Router
-func (r *Router) update(ctx context.Context, client *compute.RoutersClient) (*computepb.Router, error) {
-	if r.exists(ctx, client) {
-		return r.get(ctx, client)
+// Update Cloud Router, patching the Cloud Nat if it drifted
+func (r *Router) update(ctx context.Context, client gcp.RoutersClient) (*computepb.Router, error) {
+	router, err := r.get(ctx, client)
+	if isNotFound(err) {
+		return r.create(ctx, client)
+	}
+	if err != nil {
+		return nil, err
+	}
+	changes := r.compare(router)
+	if err := forceNewError("router", r.Name, changes); err != nil {
+		return nil, err
+	}
+	if len(changes) == 0 {
+		return router, nil
+	}
+
+	// nats are replaced as a list so keep any that tidalwave does not manage
+	nats := []*computepb.RouterNat{r.nat()}
+	for _, n := range router.GetNats() {
+		if n.GetName() != r.Name {
+			nats = append(nats, n)
+		}
 	}
 	req := &computepb.PatchRouterRequest{
 		RouterResource: &computepb.Router{
-			Name: &r.Name,
-			Nats: []*computepb.RouterNat{
-				{
-					Name:                          &r.Name,
-					NatIpAllocateOption:           StrPtr("AUTO_ONLY"),
-					SourceSubnetworkIpRangesToNat: StrPtr("ALL_SUBNETWORKS_ALL_IP_RANGES"),
-				},
-			},
-			Network: &r.Network,
+			Nats: nats,
 		},
 		Project: r.ProjectID,
 		Region:  r.Region,
@@ -111,7 +156,7 @@ func (r *Router) update(ctx context.Context, client *compute.RoutersClient) (*co
 	if err != nil {
 		return nil, err
 	}
-	err = op.Wait(ctx)
+	err = waitCompute(ctx, op, fmt.Sprintf("update router %s", r.Name))
 	if err != nil {
 		return nil, err
 	}
@@ -0,0 +1,257 @@
+package google
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"strings"
+	"tidalwave/internal/google/gcp"
+	"tidalwave/internal/tidalwave"
+	"time"
+
+	adminpb "cloud.google.com/go/iam/admin/apiv1/adminpb"
+	iampb "cloud.google.com/go/iam/apiv1/iampb"
+)
+
+// NodeRoles are the roles the node service account is granted on the project, enough to write
+// logs and metrics and to pull images from Artifact Registry
+var NodeRoles = []string{
+	"roles/artifactregistry.reader",
+	"roles/logging.logWriter",
+	"roles/monitoring.metricWriter",
+	"roles/monitoring.viewer",
+}
+
+// ServiceAccount represents the IAM service account the nodes run as
+type ServiceAccount struct {
+	// Name is the account ID, the part of the email before the @
+	Name      string
+	ProjectID string
+	// Email of an existing service account the nodes run as instead, nothing is created or
+	// granted when it is set
+	Email string
+}
+
+// ServiceAccountID returns the ID of the node service account of a controlplane. IDs are at
+// most 30 characters, longer controlplane names are shortened and a hash keeps them unique.
+func ServiceAccountID(controlplane string) string {
+	id := controlplane + "-nodes"
+	if len(id) <= 30 {
+		return id
+	}
+	h := fnv.New32a()
+	h.Write([]byte(controlplane))
+	return fmt.Sprintf("%s-%04x-nodes", strings.TrimRight(controlplane[:18], "-"), h.Sum32()&0xffff)
+}
+
+// managed reports whether tidalwave creates the service account
+func (s *ServiceAccount) managed() bool {
+	return s.Email == ""
+}
+
+// email returns the email the nodes run as
+func (s *ServiceAccount) email() string {
+	if !s.managed() {
+		return s.Email
+	}
+	return fmt.Sprintf("%s@%s.iam.gserviceaccount.com", s.Name, s.ProjectID)
+}
+
+// Full resource name of the service account
+func (s *ServiceAccount) name() string {
+	return fmt.Sprintf("projects/%s/serviceAccounts/%s", s.ProjectID, s.email())
+}
+
+// member is the service account in IAM bindings
+func (s *ServiceAccount) member() string {
+	return "serviceAccount:" + s.email()
+}
+
+// Get service account
+func (s *ServiceAccount) get(ctx context.Context, client gcp.IAMClient) (*adminpb.ServiceAccount, error) {
+	return client.GetServiceAccount(ctx, &adminpb.GetServiceAccountRequest{Name: s.name()})
+}
+
+// Check if service account exists
+func (s *ServiceAccount) exists(ctx context.Context, client gcp.IAMClient) bool {
+	_, err := s.get(ctx, client)
+	return err == nil
+}
+
+// Create the service account and grant it NodeRoles on the project, roles it already has are
+// left alone so the project policy is only written when something is missing
+func (s *ServiceAccount) create(ctx context.Context, client gcp.IAMClient, projects gcp.ProjectsClient) (*adminpb.ServiceAccount, error) {
+	account, err := s.get(ctx, client)
+	if isNotFound(err) {
+		account, err = client.CreateServiceAccount(ctx, &adminpb.CreateServiceAccountRequest{
+			Name:      fmt.Sprintf("projects/%s", s.ProjectID),
+			AccountId: s.Name,
+			ServiceAccount: &adminpb.ServiceAccount{
+				DisplayName: "tidalwave nodes",
+				Description: "Runs the nodes of a tidalwave controlplane",
+			},
+		})
+	}
+	if err != nil {
+		return nil, err
+	}
+	err = s.editProjectIam(ctx, projects, func(policy *iampb.Policy) {
+		for _, role := range NodeRoles {
+			addMember(policy, role, s.member())
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	return account, nil
+}
+
+// Delete the service account after removing its roles from the project
+func (s *ServiceAccount) delete(ctx context.Context, client gcp.IAMClient, projects gcp.ProjectsClient) error {
+	err := s.editProjectIam(ctx, projects, func(policy *iampb.Policy) {
+		for _, role := range NodeRoles {
+			removeMember(policy, role, s.member())
+		}
+	})
+	if err != nil {
+		return err
+	}
+	return client.DeleteServiceAccount(ctx, &adminpb.DeleteServiceAccountRequest{Name: s.name()})
+}
+
+// iamRetryDelay is the delay before the first retry of a project IAM policy write, it doubles
+// on every retry
+var iamRetryDelay = time.Second
+
+// iamRetries is how many times a project IAM policy write is retried
+const iamRetries = 5
+
+// iamPolicyVersion is the IAM policy version that keeps conditional role bindings intact,
+// older versions return them mangled
+const iamPolicyVersion = 3
+
+// editProjectIam reads the IAM policy of the project, applies edit and writes it back if edit
+// changed it. Another write since the read makes the etag stale, and a service account that
+// was just created is not a valid member until IAM has caught up with it, the whole read, edit
+// and write is retried with backoff then.
+func (s *ServiceAccount) editProjectIam(ctx context.Context, client gcp.ProjectsClient, edit func(*iampb.Policy)) error {
+	delay := iamRetryDelay
+	for attempt := 0; ; attempt++ {
+		err := s.setProjectIam(ctx, client, edit)
+		switch {
+		case err == nil || attempt == iamRetries:
+			return err
+		case isConflict(err):
+			tidalwave.Warnf(ctx, "IAM policy of project %s changed while it was edited, retrying in %s", s.ProjectID, delay)
+		case isMissingMember(err):
+			tidalwave.Warnf(ctx, "service account %s is not known to IAM yet, retrying in %s", s.email(), delay)
+		default:
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(delay):
+		}
+		delay *= 2
+	}
+}
+
+// setProjectIam does a single read, edit and write of the project IAM policy
+func (s *ServiceAccount) setProjectIam(ctx context.Context, client gcp.ProjectsClient, edit func(*iampb.Policy)) error {
+	resource := fmt.Sprintf("projects/%s", s.ProjectID)
+	policy, err := getProjectIam(ctx, client, s.ProjectID)
+	if err != nil {
+		return err
+	}
+	before := s.roles(policy)
+	edit(policy)
+	if s.roles(policy) == before {
+		return nil
+	}
+	policy.Version = iamPolicyVersion
+	_, err = client.SetIamPolicy(ctx, &iampb.SetIamPolicyRequest{
+		Resource: resource,
+		Policy:   policy,
+	})
+	return err
+}
+
+// getProjectIam reads the IAM policy of a project with its conditional role bindings
+func getProjectIam(ctx context.Context, client gcp.ProjectsClient, projectID string) (*iampb.Policy, error) {
+	return client.GetIamPolicy(ctx, &iampb.GetIamPolicyRequest{
+		Resource: fmt.Sprintf("projects/%s", projectID),
+		Options:  &iampb.GetPolicyOptions{RequestedPolicyVersion: iamPolicyVersion},
+	})
+}
+
+// addMember grants role to member with a binding without a condition, conditional bindings of
+// the role are left alone
+func addMember(p *iampb.Policy, role, member string) {
+	for _, b := range p.GetBindings() {
+		if b.GetRole() == role && b.GetCondition() == nil {
+			if !contains(b.GetMembers(), member) {
+				b.Members = append(b.Members, member)
+			}
+			return
+		}
+	}
+	p.Bindings = append(p.Bindings, &iampb.Binding{Role: role, Members: []string{member}})
+}
+
+// removeMember takes role away from member in the bindings without a condition, bindings left
+// without members are dropped
+func removeMember(p *iampb.Policy, role, member string) {
+	bindings := p.Bindings[:0]
+	for _, b := range p.GetBindings() {
+		if b.GetRole() == role && b.GetCondition() == nil {
+			members := b.Members[:0]
+			for _, m := range b.GetMembers() {
+				if m != member {
+					members = append(members, m)
+				}
+			}
+			b.Members = members
+			if len(members) == 0 {
+				continue
+			}
+		}
+		bindings = append(bindings, b)
+	}
+	p.Bindings = bindings
+}
+
+// roles lists which of NodeRoles the service account has in a project policy without a
+// condition, other roles granted to it are left alone
+func (s *ServiceAccount) roles(p *iampb.Policy) string {
+	roles := []string{}
+	for _, b := range p.GetBindings() {
+		if !contains(NodeRoles, b.GetRole()) || b.GetCondition() != nil {
+			continue
+		}
+		for _, m := range b.GetMembers() {
+			if m == s.member() {
+				roles = append(roles, b.GetRole())
+			}
+		}
+	}
+	return list(roles)
+}
+
+// Diff the service account and its roles against the config
+func (s *ServiceAccount) diff(ctx context.Context, client gcp.IAMClient, projects gcp.ProjectsClient) (*tidalwave.ResourcePlan, error) {
+	_, err := s.get(ctx, client)
+	if isNotFound(err) {
+		return tidalwave.NewResourcePlan("serviceaccount", s.Name, false, nil), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	p, err := getProjectIam(ctx, projects, s.ProjectID)
+	if err != nil {
+		return nil, err
+	}
+	d := differ{}
+	d.field("roles", s.roles(p), list(NodeRoles))
+	return tidalwave.NewResourcePlan("serviceaccount", s.Name, true, d.changes), nil
+}
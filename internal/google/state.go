@@ -0,0 +1,175 @@
+package google
+
+import (
+	"context"
+	"fmt"
+	"tidalwave/internal/state"
+	"tidalwave/internal/tidalwave"
+	"time"
+
+	computepb "cloud.google.com/go/compute/apiv1/computepb"
+	containerpb "cloud.google.com/go/container/apiv1/containerpb"
+	adminpb "cloud.google.com/go/iam/admin/apiv1/adminpb"
+	kmspb "cloud.google.com/go/kms/apiv1/kmspb"
+)
+
+// newResource builds a state entry, created is an RFC 3339 timestamp from the API
+func newResource(kind, name, selfLink, created, fingerprint string, config interface{}) state.Resource {
+	t, err := time.Parse(time.RFC3339, created)
+	if err != nil {
+		t = time.Now().UTC()
+	}
+	return state.Resource{
+		Kind:        kind,
+		Name:        name,
+		SelfLink:    selfLink,
+		Created:     t,
+		Fingerprint: fingerprint,
+		ConfigHash:  state.Hash(config),
+	}
+}
+
+func networkResource(n *computepb.Network, config *Vpc) state.Resource {
+	return newResource("vpc", config.Name, n.GetSelfLink(), n.GetCreationTimestamp(), fmt.Sprint(n.GetId()), config)
+}
+
+func subnetworkResource(s *computepb.Subnetwork, config *Subnetwork) state.Resource {
+	return newResource("subnetwork", config.Name, s.GetSelfLink(), s.GetCreationTimestamp(), fmt.Sprint(s.GetId()), config)
+}
+
+func routerResource(r *computepb.Router, config *Router) state.Resource {
+	return newResource("router", config.Name, r.GetSelfLink(), r.GetCreationTimestamp(), fmt.Sprint(r.GetId()), config)
+}
+
+func firewallResource(f *computepb.Firewall, config *Firewall) state.Resource {
+	return newResource("firewall", config.Name, f.GetSelfLink(), f.GetCreationTimestamp(), fmt.Sprint(f.GetId()), config)
+}
+
+func keyringResource(k *kmspb.KeyRing, config *Keyring) state.Resource {
+	created := k.GetCreateTime().AsTime().Format(time.RFC3339)
+	return newResource("keyring", config.Name, k.GetName(), created, created, config)
+}
+
+func cryptoKeyResource(k *kmspb.CryptoKey, config *CryptoKey) state.Resource {
+	created := k.GetCreateTime().AsTime().Format(time.RFC3339)
+	return newResource("cryptokey", config.Name, k.GetName(), created, created, config)
+}
+
+func serviceAccountResource(a *adminpb.ServiceAccount, config *ServiceAccount) state.Resource {
+	return newResource("serviceaccount", config.Name, a.GetName(), "", a.GetUniqueId(), config)
+}
+
+func clusterResource(c *containerpb.Cluster, config *Cluster) state.Resource {
+	return newResource("cluster", config.Name, c.GetSelfLink(), c.GetCreateTime(), c.GetId(), config)
+}
+
+// track records a resource in state if tidalwave created it or already manages it.
+// Resources that existed before tidalwave touched them are left out so they are never deleted.
+func (c *Controlplane) track(ctx context.Context, existed bool, r state.Resource) error {
+	if c.State == nil {
+		return nil
+	}
+	if _, ok := c.State.Get(r.Kind, r.Name); existed && !ok {
+		tidalwave.Warnf(ctx, "%s %s already exists and was not created by tidalwave, it is not tracked", r.Kind, r.Name)
+		return nil
+	}
+	return c.State.Put(ctx, r)
+}
+
+// owned reports whether a live resource may be deleted, it must be in state and be the
+// same instance tidalwave created
+func (c *Controlplane) owned(ctx context.Context, kind, name, fingerprint string) bool {
+	if c.State == nil || c.Force {
+		return true
+	}
+	r, ok := c.State.Get(kind, name)
+	if !ok {
+		tidalwave.Infof(ctx, ":stop_sign:", "%s %s was not created by tidalwave, skipping delete", kind, name)
+		return false
+	}
+	if r.Fingerprint != fingerprint {
+		tidalwave.Infof(ctx, ":stop_sign:", "%s %s was recreated outside of tidalwave, skipping delete", kind, name)
+		return false
+	}
+	return true
+}
+
+// retained are the kinds that cannot be deleted, destroy only revokes access to them and
+// keeps them in state so tidalwave still owns them when the controlplane is created again
+var retained = map[string]bool{"cryptokey": true}
+
+// destroy deletes a resource if tidalwave owns it and removes it from state. get returns the
+// fingerprint of the live resource.
+func (c *Controlplane) destroy(ctx context.Context, kind, name string, get func() (string, error), del func() error) error {
+	fingerprint, err := get()
+	if isNotFound(err) {
+		return c.forget(ctx, kind, name)
+	}
+	if err != nil {
+		return err
+	}
+	if !c.owned(ctx, kind, name, fingerprint) {
+		return nil
+	}
+	if err := del(); err != nil {
+		return err
+	}
+	if retained[kind] {
+		tidalwave.Infof(ctx, ":cross_mark_button:", "Controlplane %s %s access revoked", kind, name)
+		return nil
+	}
+	tidalwave.Infof(ctx, ":cross_mark_button:", "Controlplane %s %s destroyed", kind, name)
+	return c.forget(ctx, kind, name)
+}
+
+// forget removes a deleted resource from state
+func (c *Controlplane) forget(ctx context.Context, kind, name string) error {
+	if c.State == nil {
+		return nil
+	}
+	if _, ok := c.State.Get(kind, name); !ok {
+		return nil
+	}
+	return c.State.Remove(ctx, kind, name)
+}
+
+// configured returns the kind/name address of every resource in the config
+func (c *Controlplane) configured() map[string]bool {
+	ids := map[string]bool{
+		"vpc/" + c.Vpc.Name:               true,
+		"subnetwork/" + c.Subnetwork.Name: true,
+		"router/" + c.Router.Name:         true,
+		"keyring/" + c.Keyring.Name:       true,
+		"cryptokey/" + c.CryptoKey.Name:   true,
+		"cluster/" + c.Cluster.Name:       true,
+	}
+	if c.ServiceAccount.managed() {
+		ids["serviceaccount/"+c.ServiceAccount.Name] = true
+	}
+	for _, f := range c.Firewalls {
+		ids["firewall/"+f.Name] = true
+	}
+	return ids
+}
+
+// Orphans returns resources tidalwave created that are no longer in the config
+func (c *Controlplane) Orphans() []state.Resource {
+	if c.State == nil {
+		return nil
+	}
+	configured := c.configured()
+	orphans := []state.Resource{}
+	for _, r := range c.State.Resources() {
+		if !configured[r.ID()] {
+			orphans = append(orphans, r)
+		}
+	}
+	return orphans
+}
+
+// warnOrphans prints every orphaned resource
+func (c *Controlplane) warnOrphans(ctx context.Context) {
+	for _, r := range c.Orphans() {
+		tidalwave.Warnf(ctx, "%s was created by tidalwave but is no longer in the config", r.ID())
+	}
+}
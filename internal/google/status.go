@@ -0,0 +1,204 @@
+package google
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"tidalwave/internal/tidalwave"
+
+	computepb "cloud.google.com/go/compute/apiv1/computepb"
+	containerpb "cloud.google.com/go/container/apiv1/containerpb"
+	kmspb "cloud.google.com/go/kms/apiv1/kmspb"
+)
+
+// Status reports whether every resource exists and matches the config, with the key
+// attributes of the live resources
+func (c *Controlplane) Status(ctx context.Context) ([]tidalwave.ResourceStatus, error) {
+	cl, err := newClients(ctx, c.Endpoints)
+	if err != nil {
+		return nil, err
+	}
+	defer cl.Close()
+	return c.status(ctx, cl)
+}
+
+// status reports the status of every resource using cl
+func (c *Controlplane) status(ctx context.Context, cl *clients) ([]tidalwave.ResourceStatus, error) {
+	plan, err := c.plan(ctx, cl)
+	if err != nil {
+		return nil, err
+	}
+	status := tidalwave.StatusFromPlan(plan)
+	// the cluster is fetched once for its own row and the rows of its node pools
+	var cluster *containerpb.Cluster
+	for i, s := range status {
+		if !s.Exists {
+			continue
+		}
+		if (s.Kind == "cluster" || s.Kind == "nodepool") && cluster == nil {
+			if cluster, err = c.Cluster.get(ctx, cl.container); err != nil {
+				return nil, fmt.Errorf("%s %s: %w", s.Kind, s.Name, err)
+			}
+		}
+		attributes, err := c.attributes(ctx, cl, cluster, s.Kind, s.Name)
+		if err != nil {
+			return nil, fmt.Errorf("%s %s: %w", s.Kind, s.Name, err)
+		}
+		for k, v := range attributes {
+			if v == "" {
+				delete(attributes, k)
+			}
+		}
+		status[i].Attributes = attributes
+	}
+	return status, nil
+}
+
+// attributes fetches a live resource of the plan and returns its key attributes, the rows of
+// the GKE cluster and its node pools use the live cluster
+func (c *Controlplane) attributes(ctx context.Context, cl *clients, cluster *containerpb.Cluster, kind, name string) (map[string]string, error) {
+	switch kind {
+	case "vpc":
+		n, err := c.Vpc.get(ctx, cl.networks)
+		if err != nil {
+			return nil, err
+		}
+		return vpcAttributes(n), nil
+	case "subnetwork":
+		s, err := c.Subnetwork.get(ctx, cl.subnetworks)
+		if err != nil {
+			return nil, err
+		}
+		return subnetworkAttributes(s), nil
+	case "router":
+		r, err := c.Router.get(ctx, cl.routers)
+		if err != nil {
+			return nil, err
+		}
+		return routerAttributes(r), nil
+	case "keyring":
+		k, err := c.Keyring.get(ctx, cl.kms)
+		if err != nil {
+			return nil, err
+		}
+		return map[string]string{"name": k.GetName()}, nil
+	case "cryptokey":
+		k, err := c.CryptoKey.get(ctx, cl.kms)
+		if err != nil {
+			return nil, err
+		}
+		return cryptoKeyAttributes(k), nil
+	case "serviceaccount":
+		a, err := c.ServiceAccount.get(ctx, cl.iam)
+		if err != nil {
+			return nil, err
+		}
+		p, err := getProjectIam(ctx, cl.projects, c.ServiceAccount.ProjectID)
+		if err != nil {
+			return nil, err
+		}
+		return map[string]string{
+			"email":    a.GetEmail(),
+			"disabled": fmt.Sprint(a.GetDisabled()),
+			"roles":    c.ServiceAccount.roles(p),
+		}, nil
+	case "cluster":
+		return clusterAttributes(cluster), nil
+	case "nodepool":
+		return nodePoolAttributes(findNodePool(cluster, resourceName(name))), nil
+	case "firewall":
+		for i := range c.Firewalls {
+			if c.Firewalls[i].Name != name {
+				continue
+			}
+			f, err := c.Firewalls[i].get(ctx, cl.firewalls)
+			if err != nil {
+				return nil, err
+			}
+			return firewallAttributes(f), nil
+		}
+	}
+	return nil, nil
+}
+
+// vpcAttributes are the key attributes of a live VPC
+func vpcAttributes(n *computepb.Network) map[string]string {
+	return map[string]string{
+		"autoCreateSubnetworks": fmt.Sprint(n.GetAutoCreateSubnetworks()),
+		"routingMode":           n.GetRoutingConfig().GetRoutingMode(),
+		"subnetworks":           fmt.Sprint(len(n.GetSubnetworks())),
+	}
+}
+
+// subnetworkAttributes are the key attributes of a live subnetwork
+func subnetworkAttributes(s *computepb.Subnetwork) map[string]string {
+	return map[string]string{
+		"ipCidrRange":           s.GetIpCidrRange(),
+		"secondaryIpRanges":     secondaryRangeList(s.GetSecondaryIpRanges()),
+		"privateIpGoogleAccess": fmt.Sprint(s.GetPrivateIpGoogleAccess()),
+		"network":               resourceName(s.GetNetwork()),
+	}
+}
+
+// routerAttributes are the key attributes of a live Cloud Router and its Cloud NATs
+func routerAttributes(r *computepb.Router) map[string]string {
+	nats := []string{}
+	for _, n := range r.GetNats() {
+		nats = append(nats, fmt.Sprintf("%s(%s,%s)", n.GetName(), n.GetNatIpAllocateOption(), n.GetSourceSubnetworkIpRangesToNat()))
+	}
+	return map[string]string{
+		"network": resourceName(r.GetNetwork()),
+		"nats":    list(nats),
+	}
+}
+
+// cryptoKeyAttributes are the key attributes of a live KMS Crypto Key
+func cryptoKeyAttributes(k *kmspb.CryptoKey) map[string]string {
+	return map[string]string{
+		"purpose":        k.GetPurpose().String(),
+		"primaryVersion": resourceName(k.GetPrimary().GetName()),
+		"primaryState":   k.GetPrimary().GetState().String(),
+	}
+}
+
+// clusterAttributes are the key attributes of a live GKE cluster
+func clusterAttributes(cluster *containerpb.Cluster) map[string]string {
+	conditions := []string{}
+	for _, c := range cluster.GetConditions() {
+		conditions = append(conditions, fmt.Sprintf("%s: %s", c.GetCanonicalCode(), c.GetMessage()))
+	}
+	return map[string]string{
+		"status":         cluster.GetStatus().String(),
+		"mode":           mode(cluster.GetAutopilot().GetEnabled()),
+		"masterVersion":  cluster.GetCurrentMasterVersion(),
+		"nodeVersion":    cluster.GetCurrentNodeVersion(),
+		"releaseChannel": cluster.GetReleaseChannel().GetChannel().String(),
+		"endpoint":       cluster.GetEndpoint(),
+		"conditions":     strings.Join(conditions, "; "),
+	}
+}
+
+// nodePoolAttributes are the key attributes of a live node pool. The node pool only knows the
+// size it was created with, the autoscaler moves it within the autoscaling bounds.
+func nodePoolAttributes(pool *containerpb.NodePool) map[string]string {
+	if pool == nil {
+		return nil
+	}
+	return map[string]string{
+		"status":      pool.GetStatus().String(),
+		"version":     pool.GetVersion(),
+		"machineType": pool.GetConfig().GetMachineType(),
+		"autoscaling": fmt.Sprintf("%d-%d", pool.GetAutoscaling().GetMinNodeCount(), pool.GetAutoscaling().GetMaxNodeCount()),
+	}
+}
+
+// firewallAttributes are the key attributes of a live firewall rule
+func firewallAttributes(f *computepb.Firewall) map[string]string {
+	return map[string]string{
+		"direction":    f.GetDirection(),
+		"allowed":      allowedList(f.GetAllowed()),
+		"sourceRanges": list(f.GetSourceRanges()),
+		"targetTags":   list(f.GetTargetTags()),
+		"disabled":     fmt.Sprint(f.GetDisabled()),
+	}
+}
@@ -2,9 +2,12 @@ package google
 
 import (
 	"context"
+	"fmt"
+	"net"
+	"tidalwave/internal/google/gcp"
+	"tidalwave/internal/tidalwave"
 
-	compute "cloud.google.com/go/compute/apiv1"
-	computepb "google.golang.org/genproto/googleapis/cloud/compute/v1"
+	computepb "cloud.google.com/go/compute/apiv1/computepb"
 )
 
 // Subnetwork represents a VPC subnetwork
@@ -19,7 +22,7 @@ type Subnetwork struct {
 }
 
 // Create subnetwork
-func (s *Subnetwork) create(ctx context.Context, client *compute.SubnetworksClient) (*computepb.Subnetwork, error) {
+func (s *Subnetwork) create(ctx context.Context, client gcp.SubnetworksClient) (*computepb.Subnetwork, error) {
 	if s.exists(ctx, client) {
 		return s.get(ctx, client)
 	}
@@ -30,16 +33,7 @@ func (s *Subnetwork) create(ctx context.Context, client *compute.SubnetworksClie
 			Region:                &s.Region,
 			Network:               &s.Network,
 			PrivateIpGoogleAccess: BoolPtr(true),
-			SecondaryIpRanges: []*computepb.SubnetworkSecondaryRange{
-				{
-					IpCidrRange: &s.PodsCidr,
-					RangeName:   StrPtr("pods"),
-				},
-				{
-					IpCidrRange: &s.ServicesCidr,
-					RangeName:   StrPtr("services"),
-				},
-			},
+			SecondaryIpRanges:     s.secondaryRanges(),
 		},
 		Project: s.ProjectID,
 		Region:  s.Region,
@@ -48,7 +42,7 @@ func (s *Subnetwork) create(ctx context.Context, client *compute.SubnetworksClie
 	if err != nil {
 		return nil, err
 	}
-	err = op.Wait(ctx)
+	err = waitCompute(ctx, op, fmt.Sprintf("create subnetwork %s", s.Name))
 	if err != nil {
 		return nil, err
 	}
@@ -56,7 +50,7 @@ func (s *Subnetwork) create(ctx context.Context, client *compute.SubnetworksClie
 }
 
 // Get subnetwork
-func (s *Subnetwork) get(ctx context.Context, client *compute.SubnetworksClient) (*computepb.Subnetwork, error) {
+func (s *Subnetwork) get(ctx context.Context, client gcp.SubnetworksClient) (*computepb.Subnetwork, error) {
 	req := &computepb.GetSubnetworkRequest{
 		Project:    s.ProjectID,
 		Subnetwork: s.Name,
@@ -70,13 +64,69 @@ func (s *Subnetwork) get(ctx context.Context, client *compute.SubnetworksClient)
 }
 
 // Check if subnetwork exists
-func (s *Subnetwork) exists(ctx context.Context, client *compute.SubnetworksClient) bool {
+func (s *Subnetwork) exists(ctx context.Context, client gcp.SubnetworksClient) bool {
 	_, err := s.get(ctx, client)
 	return err == nil
 }
 
+// Diff subnetwork against the config
+func (s *Subnetwork) diff(ctx context.Context, client gcp.SubnetworksClient) (*tidalwave.ResourcePlan, error) {
+	subnet, err := s.get(ctx, client)
+	if isNotFound(err) {
+		return tidalwave.NewResourcePlan("subnetwork", s.Name, false, nil), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return tidalwave.NewResourcePlan("subnetwork", s.Name, true, s.compare(subnet)), nil
+}
+
+// Compare a live subnetwork with the config
+func (s *Subnetwork) compare(subnet *computepb.Subnetwork) []tidalwave.Change {
+	d := differ{}
+	d.forceNew("network", resourceName(subnet.GetNetwork()), resourceName(s.Network))
+	if expandsCidr(subnet.GetIpCidrRange(), s.NodesCidr) {
+		d.field("ipCidrRange", subnet.GetIpCidrRange(), s.NodesCidr)
+	} else {
+		d.forceNew("ipCidrRange", subnet.GetIpCidrRange(), s.NodesCidr)
+	}
+	d.field("privateIpGoogleAccess", fmt.Sprint(subnet.GetPrivateIpGoogleAccess()), "true")
+	d.field("secondaryIpRanges", secondaryRangeList(subnet.GetSecondaryIpRanges()), secondaryRangeList(s.secondaryRanges()))
+	return d.changes
+}
+
+// secondaryRanges returns the pods and services secondary ranges
+func (s *Subnetwork) secondaryRanges() []*computepb.SubnetworkSecondaryRange {
+	return []*computepb.SubnetworkSecondaryRange{
+		{
+			IpCidrRange: &s.PodsCidr,
+			RangeName:   StrPtr("pods"),
+		},
+		{
+			IpCidrRange: &s.ServicesCidr,
+			RangeName:   StrPtr("services"),
+		},
+	}
+}
+
+// expandsCidr reports whether desired is a strictly larger range containing observed,
+// which is the only primary range change GCP allows in place
+func expandsCidr(observed, desired string) bool {
+	_, o, err := net.ParseCIDR(observed)
+	if err != nil {
+		return false
+	}
+	_, d, err := net.ParseCIDR(desired)
+	if err != nil {
+		return false
+	}
+	oSize, _ := o.Mask.Size()
+	dSize, _ := d.Mask.Size()
+	return dSize < oSize && d.Contains(o.IP)
+}
+
 // Delete subnetwork
-func (s *Subnetwork) delete(ctx context.Context, client *compute.SubnetworksClient) error {
+func (s *Subnetwork) delete(ctx context.Context, client gcp.SubnetworksClient) error {
 	if s.exists(ctx, client) {
 		req := &computepb.DeleteSubnetworkRequest{
 			Project:    s.ProjectID,
@@ -87,7 +137,7 @@ func (s *Subnetwork) delete(ctx context.Context, client *compute.SubnetworksClie
 		if err != nil {
 			return err
 		}
-		err = op.Wait(ctx)
+		err = waitCompute(ctx, op, fmt.Sprintf("delete subnetwork %s", s.Name))
 		if err != nil {
 			return err
 		}
@@ -95,40 +145,79 @@ func (s *Subnetwork) delete(ctx context.Context, client *compute.SubnetworksClie
 	return nil
 }
 
-// Update subnetwork
-func (s *Subnetwork) update(ctx context.Context, client *compute.SubnetworksClient) (*computepb.Subnetwork, error) {
-	if s.exists(ctx, client) {
-		return s.get(ctx, client)
+// Update subnetwork, only sending the calls needed for the fields that drifted
+func (s *Subnetwork) update(ctx context.Context, client gcp.SubnetworksClient) (*computepb.Subnetwork, error) {
+	subnet, err := s.get(ctx, client)
+	if isNotFound(err) {
+		return s.create(ctx, client)
 	}
-	req := &computepb.PatchSubnetworkRequest{
-		SubnetworkResource: &computepb.Subnetwork{
-			IpCidrRange:           &s.NodesCidr,
-			Name:                  &s.Name,
-			Region:                &s.Region,
-			Network:               &s.Network,
-			PrivateIpGoogleAccess: BoolPtr(true),
-			SecondaryIpRanges: []*computepb.SubnetworkSecondaryRange{
-				{
-					IpCidrRange: &s.PodsCidr,
-					RangeName:   StrPtr("pods"),
-				},
-				{
-					IpCidrRange: &s.ServicesCidr,
-					RangeName:   StrPtr("services"),
-				},
-			},
-		},
-		Project:    s.ProjectID,
-		Region:     s.Region,
-		Subnetwork: s.Name,
-	}
-	op, err := client.Patch(ctx, req)
 	if err != nil {
 		return nil, err
 	}
-	err = op.Wait(ctx)
-	if err != nil {
+	changes := s.compare(subnet)
+	if err := forceNewError("subnetwork", s.Name, changes); err != nil {
 		return nil, err
 	}
+	if len(changes) == 0 {
+		return subnet, nil
+	}
+
+	if changed(changes, "ipCidrRange") {
+		op, err := client.ExpandIpCidrRange(ctx, &computepb.ExpandIpCidrRangeSubnetworkRequest{
+			Project:    s.ProjectID,
+			Region:     s.Region,
+			Subnetwork: s.Name,
+			SubnetworksExpandIpCidrRangeRequestResource: &computepb.SubnetworksExpandIpCidrRangeRequest{
+				IpCidrRange: &s.NodesCidr,
+			},
+		})
+		if err != nil {
+			return nil, err
+		}
+		if err := waitCompute(ctx, op, fmt.Sprintf("expand subnetwork %s", s.Name)); err != nil {
+			return nil, err
+		}
+	}
+
+	if changed(changes, "privateIpGoogleAccess") {
+		op, err := client.SetPrivateIpGoogleAccess(ctx, &computepb.SetPrivateIpGoogleAccessSubnetworkRequest{
+			Project:    s.ProjectID,
+			Region:     s.Region,
+			Subnetwork: s.Name,
+			SubnetworksSetPrivateIpGoogleAccessRequestResource: &computepb.SubnetworksSetPrivateIpGoogleAccessRequest{
+				PrivateIpGoogleAccess: BoolPtr(true),
+			},
+		})
+		if err != nil {
+			return nil, err
+		}
+		if err := waitCompute(ctx, op, fmt.Sprintf("update subnetwork %s", s.Name)); err != nil {
+			return nil, err
+		}
+	}
+
+	if changed(changes, "secondaryIpRanges") {
+		// the fingerprint changes after every call above so patch against a fresh copy
+		subnet, err = s.get(ctx, client)
+		if err != nil {
+			return nil, err
+		}
+		op, err := client.Patch(ctx, &computepb.PatchSubnetworkRequest{
+			SubnetworkResource: &computepb.Subnetwork{
+				Fingerprint:       subnet.Fingerprint,
+				SecondaryIpRanges: s.secondaryRanges(),
+			},
+			Project:    s.ProjectID,
+			Region:     s.Region,
+			Subnetwork: s.Name,
+		})
+		if err != nil {
+			return nil, err
+		}
+		if err := waitCompute(ctx, op, fmt.Sprintf("update subnetwork %s", s.Name)); err != nil {
+			return nil, err
+		}
+	}
+
 	return s.get(ctx, client)
 }
@@ -0,0 +1,249 @@
+package google
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"tidalwave/internal/google/gcp"
+	"tidalwave/internal/tidalwave"
+	"time"
+
+	containerpb "cloud.google.com/go/container/apiv1/containerpb"
+)
+
+// versionNumberRe finds the numbers of a GKE version such as 1.24.5-gke.600
+var versionNumberRe = regexp.MustCompile(`[0-9]+`)
+
+// compareVersions returns -1, 0 or 1 when GKE version a is older than, the same as or newer
+// than b
+func compareVersions(a, b string) int {
+	x := versionNumberRe.FindAllString(a, -1)
+	y := versionNumberRe.FindAllString(b, -1)
+	for i := 0; i < len(x) && i < len(y); i++ {
+		m, _ := strconv.Atoi(x[i])
+		n, _ := strconv.Atoi(y[i])
+		switch {
+		case m < n:
+			return -1
+		case m > n:
+			return 1
+		}
+	}
+	switch {
+	case len(x) < len(y):
+		return -1
+	case len(x) > len(y):
+		return 1
+	}
+	return 0
+}
+
+// versionMatches reports whether version is pin or a release of it, such as 1.24.5-gke.600
+// for 1.24 or 1.24.5
+func versionMatches(version, pin string) bool {
+	return version == pin || strings.HasPrefix(version, pin+".") || strings.HasPrefix(version, pin+"-")
+}
+
+// Versions returns the versions of the cluster and its node pools and the versions GKE offers
+// for its release channel
+func (c *Controlplane) Versions(ctx context.Context) (*tidalwave.Versions, error) {
+	cl, err := newClients(ctx, c.Endpoints)
+	if err != nil {
+		return nil, err
+	}
+	defer cl.Close()
+	return c.Cluster.versions(ctx, cl.container)
+}
+
+// Upgrade moves the cluster master to version and then each node pool in the order of the
+// config, waiting on every operation. Each step may take as long as the cluster timeout.
+func (c *Controlplane) Upgrade(ctx context.Context, version string, dryRun, pause bool) ([]tidalwave.UpgradeStep, error) {
+	cl, err := newClients(ctx, c.Endpoints)
+	if err != nil {
+		return nil, err
+	}
+	defer cl.Close()
+	return c.Cluster.upgrade(ctx, cl.container, version, dryRun, pause, c.Timeouts["cluster"])
+}
+
+// location is the parent of the cluster
+func (c *Cluster) location() string {
+	return fmt.Sprintf("projects/%s/locations/%s", c.ProjectID, c.Region)
+}
+
+// serverConfig returns the versions GKE offers in the region of the cluster
+func (c *Cluster) serverConfig(ctx context.Context, client gcp.ContainerClient) (*containerpb.ServerConfig, error) {
+	return client.GetServerConfig(ctx, &containerpb.GetServerConfigRequest{Name: c.location()})
+}
+
+// channelConfig returns the versions of the release channel a live cluster is enrolled in, or
+// nil if it is not enrolled in one
+func channelConfig(config *containerpb.ServerConfig, cluster *containerpb.Cluster) *containerpb.ServerConfig_ReleaseChannelConfig {
+	channel := cluster.GetReleaseChannel().GetChannel()
+	if channel == containerpb.ReleaseChannel_UNSPECIFIED {
+		return nil
+	}
+	for _, ch := range config.GetChannels() {
+		if ch.GetChannel() == channel {
+			return ch
+		}
+	}
+	return nil
+}
+
+// versions lists the versions of the live cluster and the versions it can be upgraded to,
+// a cluster enrolled in a release channel can only run the versions of the channel
+func (c *Cluster) versions(ctx context.Context, client gcp.ContainerClient) (*tidalwave.Versions, error) {
+	cluster, err := c.get(ctx, client)
+	if err != nil {
+		return nil, fmt.Errorf("cluster %s: %w", c.Name, err)
+	}
+	config, err := c.serverConfig(ctx, client)
+	if err != nil {
+		return nil, err
+	}
+	v := &tidalwave.Versions{
+		Master:              cluster.GetCurrentMasterVersion(),
+		Default:             config.GetDefaultClusterVersion(),
+		ValidMasterVersions: config.GetValidMasterVersions(),
+		ValidNodeVersions:   config.GetValidNodeVersions(),
+	}
+	if ch := channelConfig(config, cluster); ch != nil {
+		v.Channel = ch.GetChannel().String()
+		v.Default = ch.GetDefaultVersion()
+		v.ValidMasterVersions = ch.GetValidVersions()
+		v.ValidNodeVersions = ch.GetValidVersions()
+	}
+	for _, p := range cluster.GetNodePools() {
+		v.NodePools = append(v.NodePools, tidalwave.NodePoolVersion{Name: p.GetName(), Version: p.GetVersion()})
+	}
+	return v, nil
+}
+
+// resolveVersion returns the newest valid master version that is version or a release of it,
+// latest is the newest valid version. The node pools move to the same version so master
+// versions that are not valid node versions are skipped. A cluster already running a release
+// of version stays on it even if GKE no longer offers it.
+func resolveVersion(v *tidalwave.Versions, version string) (string, error) {
+	masterOnly := ""
+	for _, valid := range v.ValidMasterVersions {
+		if version != "latest" && !versionMatches(valid, version) {
+			continue
+		}
+		if !contains(v.ValidNodeVersions, valid) {
+			if masterOnly == "" {
+				masterOnly = valid
+			}
+			continue
+		}
+		return valid, nil
+	}
+	if versionMatches(v.Master, version) {
+		return v.Master, nil
+	}
+	if masterOnly != "" {
+		return "", fmt.Errorf("version %s is not a valid node version", masterOnly)
+	}
+	return "", fmt.Errorf("version %s is not available, valid versions are %s", version, strings.Join(v.ValidMasterVersions, ", "))
+}
+
+// upgradeSteps returns the steps that move the master and then the configured node pools to
+// version, those already running it are left out. Autopilot clusters only have the master
+// step, GKE upgrades their nodes itself.
+func (c *Cluster) upgradeSteps(v *tidalwave.Versions, version string) ([]tidalwave.UpgradeStep, error) {
+	if compareVersions(version, v.Master) < 0 {
+		return nil, fmt.Errorf("cannot downgrade master of cluster %s from %s to %s", c.Name, v.Master, version)
+	}
+	steps := []tidalwave.UpgradeStep{}
+	if v.Master != version {
+		steps = append(steps, tidalwave.UpgradeStep{Kind: "master", Name: c.Name, From: v.Master, To: version})
+	}
+	live := map[string]string{}
+	for _, p := range v.NodePools {
+		live[p.Name] = p.Version
+	}
+	for _, p := range c.pools() {
+		current, ok := live[p.Name]
+		if !ok || current == version {
+			continue
+		}
+		if compareVersions(version, current) < 0 {
+			return nil, fmt.Errorf("cannot downgrade node pool %s from %s to %s", c.poolName(p.Name), current, version)
+		}
+		steps = append(steps, tidalwave.UpgradeStep{Kind: "nodepool", Name: p.Name, From: current, To: version})
+	}
+	return steps, nil
+}
+
+// upgrade runs the steps that move the cluster to version one at a time and returns the steps
+// that were done. It stops at the first step that fails, or after the first step with pause so
+// the workloads can be checked, running it again carries on from there.
+func (c *Cluster) upgrade(ctx context.Context, client gcp.ContainerClient, version string, dryRun, pause bool, timeout time.Duration) ([]tidalwave.UpgradeStep, error) {
+	v, err := c.versions(ctx, client)
+	if err != nil {
+		return nil, err
+	}
+	target, err := resolveVersion(v, version)
+	if err != nil {
+		return nil, err
+	}
+	steps, err := c.upgradeSteps(v, target)
+	if err != nil || dryRun {
+		return steps, err
+	}
+	for i, s := range steps {
+		if err := c.upgradeStep(ctx, client, s, timeout); err != nil {
+			return steps[:i], fmt.Errorf("%s %s was not upgraded to %s, %d of %d steps done, run upgrade again to carry on: %w",
+				s.Kind, s.Name, s.To, i, len(steps), err)
+		}
+		if pause && i < len(steps)-1 {
+			tidalwave.Infof(ctx, ":pause_button:", "Upgrade paused after %s %s, %d of %d steps done, run upgrade again to carry on", s.Kind, s.Name, i+1, len(steps))
+			return steps[:i+1], nil
+		}
+	}
+	return steps, nil
+}
+
+// upgradeStep upgrades the master or a node pool and waits for GKE to finish, node pools are
+// upgraded with their surge settings
+func (c *Cluster) upgradeStep(ctx context.Context, client gcp.ContainerClient, s tidalwave.UpgradeStep, timeout time.Duration) error {
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	var op *containerpb.Operation
+	var err error
+	description := fmt.Sprintf("Cluster master is being upgraded to %s", s.To)
+	if s.Kind == "master" {
+		op, err = client.UpdateCluster(ctx, &containerpb.UpdateClusterRequest{
+			Name: c.name(),
+			Update: &containerpb.ClusterUpdate{
+				DesiredMasterVersion: s.To,
+			},
+		})
+	} else {
+		var cluster *containerpb.Cluster
+		cluster, err = c.get(ctx, client)
+		if err != nil {
+			return err
+		}
+		description = fmt.Sprintf("Node pool %s is being upgraded to %s", s.Name, s.To)
+		op, err = client.UpdateNodePool(ctx, &containerpb.UpdateNodePoolRequest{
+			Name:            fmt.Sprintf("%s/nodePools/%s", c.name(), s.Name),
+			NodeVersion:     s.To,
+			ImageType:       findNodePool(cluster, s.Name).GetConfig().GetImageType(),
+			UpgradeSettings: c.findPool(s.Name).upgradeSettings(),
+		})
+	}
+	if err == nil {
+		err = c.wait(ctx, client, op, ":beer:", description)
+	}
+	if err != nil && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return fmt.Errorf("timed out after %s: %w", timeout, err)
+	}
+	return err
+}
@@ -2,9 +2,11 @@ package google
 
 import (
 	"context"
+	"fmt"
+	"tidalwave/internal/google/gcp"
+	"tidalwave/internal/tidalwave"
 
-	compute "cloud.google.com/go/compute/apiv1"
-	computepb "google.golang.org/genproto/googleapis/cloud/compute/v1"
+	computepb "cloud.google.com/go/compute/apiv1/computepb"
 )
 
 // Vpc represents a VPC
@@ -14,7 +16,7 @@ type Vpc struct {
 }
 
 // Create VPC
-func (n *Vpc) create(ctx context.Context, client *compute.NetworksClient) (*computepb.Network, error) {
+func (n *Vpc) create(ctx context.Context, client gcp.NetworksClient) (*computepb.Network, error) {
 	if n.exists(ctx, client) {
 		return n.get(ctx, client)
 	}
@@ -29,7 +31,7 @@ func (n *Vpc) create(ctx context.Context, client *compute.NetworksClient) (*comp
 	if err != nil {
 		return nil, err
 	}
-	err = op.Wait(ctx)
+	err = waitCompute(ctx, op, fmt.Sprintf("create vpc %s", n.Name))
 	if err != nil {
 		return nil, err
 	}
@@ -37,7 +39,7 @@ func (n *Vpc) create(ctx context.Context, client *compute.NetworksClient) (*comp
 }
 
 // Get VPC
-func (n *Vpc) get(ctx context.Context, client *compute.NetworksClient) (*computepb.Network, error) {
+func (n *Vpc) get(ctx context.Context, client gcp.NetworksClient) (*computepb.Network, error) {
 	req := &computepb.GetNetworkRequest{
 		Project: n.ProjectID,
 		Network: n.Name,
@@ -50,13 +52,32 @@ func (n *Vpc) get(ctx context.Context, client *compute.NetworksClient) (*compute
 }
 
 // Check if VPC exists
-func (n *Vpc) exists(ctx context.Context, client *compute.NetworksClient) bool {
+func (n *Vpc) exists(ctx context.Context, client gcp.NetworksClient) bool {
 	_, err := n.get(ctx, client)
 	return err == nil
 }
 
+// Diff VPC against the config
+func (n *Vpc) diff(ctx context.Context, client gcp.NetworksClient) (*tidalwave.ResourcePlan, error) {
+	network, err := n.get(ctx, client)
+	if isNotFound(err) {
+		return tidalwave.NewResourcePlan("vpc", n.Name, false, nil), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return tidalwave.NewResourcePlan("vpc", n.Name, true, n.compare(network)), nil
+}
+
+// Compare a live VPC with the config
+func (n *Vpc) compare(network *computepb.Network) []tidalwave.Change {
+	d := differ{}
+	d.forceNew("autoCreateSubnetworks", fmt.Sprint(network.GetAutoCreateSubnetworks()), "false")
+	return d.changes
+}
+
 // Delete VPC
-func (n *Vpc) delete(ctx context.Context, client *compute.NetworksClient) error {
+func (n *Vpc) delete(ctx context.Context, client gcp.NetworksClient) error {
 	if n.exists(ctx, client) {
 		req := &computepb.DeleteNetworkRequest{
 			Project: n.ProjectID,
@@ -66,7 +87,7 @@ func (n *Vpc) delete(ctx context.Context, client *compute.NetworksClient) error
 		if err != nil {
 			return err
 		}
-		err = op.Wait(ctx)
+		err = waitCompute(ctx, op, fmt.Sprintf("delete vpc %s", n.Name))
 		if err != nil {
 			return err
 		}
@@ -74,28 +95,17 @@ func (n *Vpc) delete(ctx context.Context, client *compute.NetworksClient) error
 	return nil
 }
 
-// Update VPC
-func (n *Vpc) update(ctx context.Context, client *compute.NetworksClient) (*computepb.Network, error) {
-	if n.exists(ctx, client) {
-		return n.get(ctx, client)
+// Update VPC, a VPC has no fields tidalwave can change in place
+func (n *Vpc) update(ctx context.Context, client gcp.NetworksClient) (*computepb.Network, error) {
+	network, err := n.get(ctx, client)
+	if isNotFound(err) {
+		return n.create(ctx, client)
 	}
-
-	req := &computepb.PatchNetworkRequest{
-		Network: n.Name,
-		NetworkResource: &computepb.Network{
-			AutoCreateSubnetworks: BoolPtr(false),
-			Name:                  &n.Name,
-		},
-		Project: n.ProjectID,
-	}
-	op, err := client.Patch(ctx, req)
 	if err != nil {
 		return nil, err
 	}
-	err = op.Wait(ctx)
-	if err != nil {
+	if err := forceNewError("vpc", n.Name, n.compare(network)); err != nil {
 		return nil, err
 	}
-
-	return n.get(ctx, client)
+	return network, nil
 }
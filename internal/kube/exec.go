@@ -0,0 +1,74 @@
+package kube
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"tidalwave/internal/tidalwave"
+	"time"
+)
+
+// execToken gets bearer tokens from a client-go credential plugin, reusing a token until it
+// is about to expire
+type execToken struct {
+	exec *tidalwave.ExecCredential
+
+	mu      sync.Mutex
+	token   string
+	expires time.Time
+}
+
+// execCredential is what credential plugins print
+type execCredential struct {
+	Status struct {
+		Token               string    `json:"token"`
+		ExpirationTimestamp time.Time `json:"expirationTimestamp"`
+	} `json:"status"`
+}
+
+// Token returns a bearer token, running the plugin when there is none or it expires within a
+// minute
+func (t *execToken) Token(ctx context.Context) (string, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.token != "" && (t.expires.IsZero() || time.Until(t.expires) > time.Minute) {
+		return t.token, nil
+	}
+	info, err := json.Marshal(map[string]interface{}{
+		"apiVersion": t.exec.APIVersion,
+		"kind":       "ExecCredential",
+		"spec":       map[string]interface{}{"interactive": false},
+	})
+	if err != nil {
+		return "", err
+	}
+	cmd := exec.CommandContext(ctx, t.exec.Command, t.exec.Args...)
+	cmd.Env = append(os.Environ(), "KUBERNETES_EXEC_INFO="+string(info))
+	for name, value := range t.exec.Env {
+		cmd.Env = append(cmd.Env, name+"="+value)
+	}
+	stderr := &bytes.Buffer{}
+	cmd.Stderr = stderr
+	out, err := cmd.Output()
+	if errors.Is(err, exec.ErrNotFound) && t.exec.InstallHint != "" {
+		return "", fmt.Errorf("%s: %w\n%s", t.exec.Command, err, t.exec.InstallHint)
+	}
+	if err != nil {
+		return "", fmt.Errorf("%s: %w: %s", t.exec.Command, err, strings.TrimSpace(stderr.String()))
+	}
+	cred := &execCredential{}
+	if err := json.Unmarshal(out, cred); err != nil {
+		return "", fmt.Errorf("%s: %w", t.exec.Command, err)
+	}
+	if cred.Status.Token == "" {
+		return "", fmt.Errorf("%s returned no token", t.exec.Command)
+	}
+	t.token, t.expires = cred.Status.Token, cred.Status.ExpirationTimestamp
+	return t.token, nil
+}
@@ -0,0 +1,256 @@
+/*
+Package kube is a small client of the Kubernetes API. It applies objects with server-side
+apply and reads them back, which is all bootstrapping a cluster needs.
+*/
+package kube
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"tidalwave/internal/tidalwave"
+)
+
+// FieldManager owns the fields tidalwave applies
+const FieldManager = "tidalwave"
+
+// ErrUnknownKind is returned for objects whose kind the API server does not serve, such as
+// custom resources applied before their definition is established
+var ErrUnknownKind = errors.New("kind is not served by the API server")
+
+// StatusError is an error returned by the API server
+type StatusError struct {
+	Code    int    `json:"code"`
+	Reason  string `json:"reason"`
+	Message string `json:"message"`
+}
+
+func (e *StatusError) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("%d %s", e.Code, http.StatusText(e.Code))
+	}
+	return e.Message
+}
+
+// IsNotFound reports whether err is a 404 from the API server
+func IsNotFound(err error) bool {
+	var s *StatusError
+	return errors.As(err, &s) && s.Code == http.StatusNotFound
+}
+
+// apiResource is a resource the API server serves
+type apiResource struct {
+	Name       string `json:"name"`
+	Kind       string `json:"kind"`
+	Namespaced bool   `json:"namespaced"`
+}
+
+// Client talks to the API server of one cluster
+type Client struct {
+	server string
+	http   *http.Client
+	token  func(ctx context.Context) (string, error)
+
+	mu sync.Mutex
+	// resources are the resources served by each group version, such as apps/v1
+	resources map[string][]apiResource
+}
+
+// New returns a client of the API server at server that trusts the CA certificate of creds
+// and authenticates with its token, or with its credential plugin if it has none
+func New(server string, creds *tidalwave.ClusterCredentials) (*Client, error) {
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+	if creds.CertificateAuthority != "" {
+		pem, err := base64.StdEncoding.DecodeString(creds.CertificateAuthority)
+		if err != nil {
+			return nil, fmt.Errorf("certificate authority: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("certificate authority: no PEM certificate")
+		}
+		tlsConfig.RootCAs = pool
+	}
+	c := &Client{
+		server:    strings.TrimSuffix(server, "/"),
+		http:      &http.Client{Transport: &http.Transport{Proxy: http.ProxyFromEnvironment, TLSClientConfig: tlsConfig}},
+		resources: map[string][]apiResource{},
+	}
+	switch {
+	case creds.Token != "":
+		c.token = func(context.Context) (string, error) { return creds.Token, nil }
+	case creds.Exec != nil:
+		c.token = (&execToken{exec: creds.Exec}).Token
+	default:
+		return nil, fmt.Errorf("the cluster credentials have neither a token nor a credential plugin")
+	}
+	return c, nil
+}
+
+// Apply creates or updates obj with server-side apply, taking over fields other managers set,
+// and returns the object the API server stored
+func (c *Client) Apply(ctx context.Context, obj Object) (Object, error) {
+	p, err := c.path(ctx, obj.APIVersion(), obj.Kind(), obj.Namespace(), obj.Name())
+	if err != nil {
+		return nil, err
+	}
+	body, err := json.Marshal(obj)
+	if err != nil {
+		return nil, err
+	}
+	query := url.Values{"fieldManager": {FieldManager}, "force": {"true"}}
+	out := Object{}
+	if err := c.do(ctx, http.MethodPatch, p, query, "application/apply-patch+yaml", body, &out); err != nil {
+		return nil, fmt.Errorf("%s: %w", obj.Ref(), err)
+	}
+	return out, nil
+}
+
+// Patch merges patch into an object with a JSON merge patch
+func (c *Client) Patch(ctx context.Context, apiVersion, kind, namespace, name string, patch interface{}) (Object, error) {
+	p, err := c.path(ctx, apiVersion, kind, namespace, name)
+	if err != nil {
+		return nil, err
+	}
+	body, err := json.Marshal(patch)
+	if err != nil {
+		return nil, err
+	}
+	out := Object{}
+	if err := c.do(ctx, http.MethodPatch, p, url.Values{"fieldManager": {FieldManager}}, "application/merge-patch+json", body, &out); err != nil {
+		return nil, fmt.Errorf("%s/%s: %w", strings.ToLower(kind), name, err)
+	}
+	return out, nil
+}
+
+// Get returns an object
+func (c *Client) Get(ctx context.Context, apiVersion, kind, namespace, name string) (Object, error) {
+	p, err := c.path(ctx, apiVersion, kind, namespace, name)
+	if err != nil {
+		return nil, err
+	}
+	out := Object{}
+	if err := c.do(ctx, http.MethodGet, p, nil, "", nil, &out); err != nil {
+		return nil, fmt.Errorf("%s/%s: %w", strings.ToLower(kind), name, err)
+	}
+	return out, nil
+}
+
+// path returns the URL path of an object, the namespace is ignored for cluster scoped kinds
+func (c *Client) path(ctx context.Context, apiVersion, kind, namespace, name string) (string, error) {
+	r, err := c.resource(ctx, apiVersion, kind)
+	if err != nil {
+		return "", err
+	}
+	p := groupVersionPath(apiVersion)
+	if r.Namespaced {
+		if namespace == "" {
+			namespace = "default"
+		}
+		p += "/namespaces/" + url.PathEscape(namespace)
+	}
+	return p + "/" + r.Name + "/" + url.PathEscape(name), nil
+}
+
+// resource looks up the resource of kind, asking the API server again when it is not known
+// in case it was defined since
+func (c *Client) resource(ctx context.Context, apiVersion, kind string) (apiResource, error) {
+	c.mu.Lock()
+	cached := c.resources[apiVersion]
+	c.mu.Unlock()
+	if r, ok := findKind(cached, kind); ok {
+		return r, nil
+	}
+	list := struct {
+		Resources []apiResource `json:"resources"`
+	}{}
+	if err := c.do(ctx, http.MethodGet, groupVersionPath(apiVersion), nil, "", nil, &list); err != nil {
+		if IsNotFound(err) {
+			return apiResource{}, fmt.Errorf("%s %s: %w", apiVersion, kind, ErrUnknownKind)
+		}
+		return apiResource{}, err
+	}
+	c.mu.Lock()
+	c.resources[apiVersion] = list.Resources
+	c.mu.Unlock()
+	if r, ok := findKind(list.Resources, kind); ok {
+		return r, nil
+	}
+	return apiResource{}, fmt.Errorf("%s %s: %w", apiVersion, kind, ErrUnknownKind)
+}
+
+// findKind returns the resource of kind, leaving out subresources such as deployments/scale
+func findKind(resources []apiResource, kind string) (apiResource, bool) {
+	for _, r := range resources {
+		if r.Kind == kind && !strings.Contains(r.Name, "/") {
+			return r, true
+		}
+	}
+	return apiResource{}, false
+}
+
+// groupVersionPath returns the path the API server serves a group version under, the core
+// group is served under /api
+func groupVersionPath(apiVersion string) string {
+	if !strings.Contains(apiVersion, "/") {
+		return "/api/" + apiVersion
+	}
+	return "/apis/" + apiVersion
+}
+
+// do sends a request to the API server and decodes the response into out, error responses are
+// returned as a StatusError
+func (c *Client) do(ctx context.Context, method, path string, query url.Values, contentType string, body []byte, out interface{}) error {
+	u := c.server + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	var r io.Reader
+	if body != nil {
+		r = bytes.NewReader(body)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u, r)
+	if err != nil {
+		return err
+	}
+	token, err := c.token(ctx)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Accept", "application/json")
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode >= 300 {
+		status := &StatusError{}
+		if json.Unmarshal(data, status) != nil || status.Message == "" {
+			status.Message = strings.TrimSpace(string(data))
+		}
+		status.Code = resp.StatusCode
+		return status
+	}
+	if out == nil {
+		return nil
+	}
+	return json.Unmarshal(data, out)
+}
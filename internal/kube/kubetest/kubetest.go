@@ -0,0 +1,272 @@
+/*
+Package kubetest is a fake Kubernetes API server for tests. It serves discovery for the
+built-in kinds and for every CustomResourceDefinition applied to it, and stores objects in
+memory. Apply and merge patches are both merged into the stored object.
+*/
+package kubetest
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"tidalwave/internal/kube"
+)
+
+// Resource is a kind the fake serves
+type Resource struct {
+	GroupVersion string
+	Name         string
+	Kind         string
+	Namespaced   bool
+}
+
+// builtin are the built-in kinds the fake serves
+var builtin = []Resource{
+	{"v1", "namespaces", "Namespace", false},
+	{"v1", "configmaps", "ConfigMap", true},
+	{"v1", "secrets", "Secret", true},
+	{"v1", "services", "Service", true},
+	{"v1", "serviceaccounts", "ServiceAccount", true},
+	{"apps/v1", "deployments", "Deployment", true},
+	{"apps/v1", "statefulsets", "StatefulSet", true},
+	{"rbac.authorization.k8s.io/v1", "roles", "Role", true},
+	{"rbac.authorization.k8s.io/v1", "rolebindings", "RoleBinding", true},
+	{"rbac.authorization.k8s.io/v1", "clusterroles", "ClusterRole", false},
+	{"rbac.authorization.k8s.io/v1", "clusterrolebindings", "ClusterRoleBinding", false},
+	{"networking.k8s.io/v1", "networkpolicies", "NetworkPolicy", true},
+	{"apiextensions.k8s.io/v1", "customresourcedefinitions", "CustomResourceDefinition", false},
+}
+
+// Server is a fake API server
+type Server struct {
+	*httptest.Server
+	// Token is the bearer token requests must have
+	Token string
+	// Reconcile is called with every object that is written, standing in for controllers
+	Reconcile func(obj kube.Object)
+
+	mu        sync.Mutex
+	resources []Resource
+	objects   map[string]kube.Object
+}
+
+// NewServer starts a fake API server over TLS that accepts token
+func NewServer(token string) *Server {
+	s := &Server{
+		Token:     token,
+		resources: append([]Resource{}, builtin...),
+		objects:   map[string]kube.Object{},
+	}
+	s.Server = httptest.NewTLSServer(http.HandlerFunc(s.serve))
+	return s
+}
+
+// CertificateAuthority returns the certificate of the server as base64 encoded PEM, the way
+// providers return the CA certificate of a cluster
+func (s *Server) CertificateAuthority() string {
+	cert := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: s.Certificate().Raw})
+	return base64.StdEncoding.EncodeToString(cert)
+}
+
+// Object returns a copy of the object of kind, nil if it does not exist. The namespace of
+// cluster scoped objects is empty.
+func (s *Server) Object(kind, namespace, name string) kube.Object {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, r := range s.resources {
+		if r.Kind == kind {
+			return copyObject(s.objects[key(r, namespace, name)])
+		}
+	}
+	return nil
+}
+
+// Objects returns the key of every object, <resource>/<namespace>/<name> sorted
+func (s *Server) Objects() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	keys := make([]string, 0, len(s.objects))
+	for k := range s.objects {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func key(r Resource, namespace, name string) string {
+	if !r.Namespaced {
+		namespace = ""
+	}
+	return fmt.Sprintf("%s/%s/%s", r.Name, namespace, name)
+}
+
+func (s *Server) serve(w http.ResponseWriter, req *http.Request) {
+	if req.Header.Get("Authorization") != "Bearer "+s.Token {
+		status(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	gv, rest, ok := splitGroupVersion(req.URL.Path)
+	if !ok {
+		status(w, http.StatusNotFound, "NotFound")
+		return
+	}
+	if rest == "" {
+		s.discovery(w, gv)
+		return
+	}
+	parts := strings.Split(rest, "/")
+	namespace := ""
+	if len(parts) == 4 && parts[0] == "namespaces" {
+		namespace, parts = parts[1], parts[2:]
+	}
+	if len(parts) != 2 {
+		status(w, http.StatusNotFound, "NotFound")
+		return
+	}
+	r, ok := s.find(gv, parts[0])
+	if !ok {
+		status(w, http.StatusNotFound, "NotFound")
+		return
+	}
+	k := key(r, namespace, parts[1])
+	switch req.Method {
+	case http.MethodGet:
+		obj, ok := s.objects[k]
+		if !ok {
+			status(w, http.StatusNotFound, fmt.Sprintf("%s %q not found", r.Name, parts[1]))
+			return
+		}
+		respond(w, obj)
+	case http.MethodPatch:
+		patch := map[string]interface{}{}
+		data, _ := io.ReadAll(req.Body)
+		if err := json.Unmarshal(data, &patch); err != nil {
+			status(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		obj, ok := s.objects[k]
+		if !ok {
+			if req.Header.Get("Content-Type") != "application/apply-patch+yaml" {
+				status(w, http.StatusNotFound, fmt.Sprintf("%s %q not found", r.Name, parts[1]))
+				return
+			}
+			obj = kube.Object{}
+		}
+		obj = kube.Object(merge(obj, patch))
+		if r.Namespaced {
+			obj.SetNamespace(namespace)
+		}
+		if r.Kind == "CustomResourceDefinition" {
+			s.define(obj)
+		}
+		if s.Reconcile != nil {
+			s.Reconcile(obj)
+		}
+		s.objects[k] = obj
+		respond(w, obj)
+	default:
+		status(w, http.StatusMethodNotAllowed, "MethodNotAllowed")
+	}
+}
+
+// discovery lists the resources of a group version
+func (s *Server) discovery(w http.ResponseWriter, gv string) {
+	resources := []map[string]interface{}{}
+	for _, r := range s.resources {
+		if r.GroupVersion == gv {
+			resources = append(resources, map[string]interface{}{"name": r.Name, "kind": r.Kind, "namespaced": r.Namespaced})
+		}
+	}
+	if len(resources) == 0 {
+		status(w, http.StatusNotFound, "NotFound")
+		return
+	}
+	respond(w, map[string]interface{}{"kind": "APIResourceList", "groupVersion": gv, "resources": resources})
+}
+
+// define serves the kinds of a CustomResourceDefinition
+func (s *Server) define(crd kube.Object) {
+	group := crd.GetString("spec", "group")
+	kind := crd.GetString("spec", "names", "kind")
+	plural := crd.GetString("spec", "names", "plural")
+	versions, _ := crd.Get("spec", "versions").([]interface{})
+	for _, v := range versions {
+		version, _ := v.(map[string]interface{})
+		name, _ := version["name"].(string)
+		gv := group + "/" + name
+		if _, ok := s.find(gv, plural); !ok {
+			s.resources = append(s.resources, Resource{gv, plural, kind, crd.GetString("spec", "scope") == "Namespaced"})
+		}
+	}
+}
+
+func (s *Server) find(gv, name string) (Resource, bool) {
+	for _, r := range s.resources {
+		if r.GroupVersion == gv && r.Name == name {
+			return r, true
+		}
+	}
+	return Resource{}, false
+}
+
+// splitGroupVersion splits a path into its group version and the rest
+func splitGroupVersion(path string) (string, string, bool) {
+	parts := strings.SplitN(strings.Trim(path, "/"), "/", 4)
+	switch {
+	case len(parts) >= 2 && parts[0] == "api":
+		return parts[1], strings.Join(parts[2:], "/"), true
+	case len(parts) >= 3 && parts[0] == "apis":
+		return parts[1] + "/" + parts[2], strings.Join(parts[3:], "/"), true
+	}
+	return "", "", false
+}
+
+// merge applies a JSON merge patch to obj
+func merge(obj, patch map[string]interface{}) map[string]interface{} {
+	for k, v := range patch {
+		switch v := v.(type) {
+		case nil:
+			delete(obj, k)
+		case map[string]interface{}:
+			existing, _ := obj[k].(map[string]interface{})
+			if existing == nil {
+				existing = map[string]interface{}{}
+			}
+			obj[k] = merge(existing, v)
+		default:
+			obj[k] = v
+		}
+	}
+	return obj
+}
+
+func copyObject(obj kube.Object) kube.Object {
+	if obj == nil {
+		return nil
+	}
+	data, _ := json.Marshal(obj)
+	out := kube.Object{}
+	_ = json.Unmarshal(data, &out)
+	return out
+}
+
+func respond(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func status(w http.ResponseWriter, code int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{"kind": "Status", "status": "Failure", "code": code, "message": message})
+}
@@ -0,0 +1,94 @@
+package kube
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Object is a Kubernetes object as it is decoded from YAML or JSON
+type Object map[string]interface{}
+
+// APIVersion returns the apiVersion of the object
+func (o Object) APIVersion() string {
+	return o.GetString("apiVersion")
+}
+
+// Kind returns the kind of the object
+func (o Object) Kind() string {
+	return o.GetString("kind")
+}
+
+// Name returns metadata.name
+func (o Object) Name() string {
+	return o.GetString("metadata", "name")
+}
+
+// Namespace returns metadata.namespace
+func (o Object) Namespace() string {
+	return o.GetString("metadata", "namespace")
+}
+
+// SetNamespace sets metadata.namespace
+func (o Object) SetNamespace(namespace string) {
+	metadata, ok := o["metadata"].(map[string]interface{})
+	if !ok {
+		metadata = map[string]interface{}{}
+		o["metadata"] = metadata
+	}
+	metadata["namespace"] = namespace
+}
+
+// Get returns the value at path, such as status.sync.status, nil if it is not set
+func (o Object) Get(path ...string) interface{} {
+	var v interface{} = map[string]interface{}(o)
+	for _, key := range path {
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		v = m[key]
+	}
+	return v
+}
+
+// GetString returns the string at path, empty if it is not set or not a string
+func (o Object) GetString(path ...string) string {
+	s, _ := o.Get(path...).(string)
+	return s
+}
+
+// Ref names the object as kind/name, such as application/istiod
+func (o Object) Ref() string {
+	return strings.ToLower(o.Kind()) + "/" + o.Name()
+}
+
+// Decode splits a YAML stream of one or more documents into objects, empty documents are
+// left out
+func Decode(data []byte) ([]Object, error) {
+	objects := []Object{}
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	for {
+		// nested mappings take the type of the top level one, which must be a plain map
+		m := map[string]interface{}{}
+		err := dec.Decode(&m)
+		if errors.Is(err, io.EOF) {
+			return objects, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(m) == 0 {
+			continue
+		}
+		obj := Object(m)
+		if obj.APIVersion() == "" || obj.Kind() == "" || obj.Name() == "" {
+			return nil, fmt.Errorf("document %d has no apiVersion, kind or metadata.name", len(objects)+1)
+		}
+		objects = append(objects, obj)
+	}
+}
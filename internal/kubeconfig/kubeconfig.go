@@ -0,0 +1,181 @@
+/*
+Package kubeconfig merges clusters, users and contexts into kubeconfig files the way kubectl
+reads them. Fields it does not know are kept as they are.
+*/
+package kubeconfig
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Config is a kubeconfig file
+type Config struct {
+	APIVersion     string                 `yaml:"apiVersion"`
+	Kind           string                 `yaml:"kind"`
+	Preferences    map[string]interface{} `yaml:"preferences"`
+	Clusters       []NamedCluster         `yaml:"clusters"`
+	Contexts       []NamedContext         `yaml:"contexts"`
+	Users          []NamedUser            `yaml:"users"`
+	CurrentContext string                 `yaml:"current-context"`
+	Extra          map[string]interface{} `yaml:",inline"`
+}
+
+// NamedCluster is an entry of clusters
+type NamedCluster struct {
+	Name    string  `yaml:"name"`
+	Cluster Cluster `yaml:"cluster"`
+}
+
+// Cluster is the API server of a cluster
+type Cluster struct {
+	Server                   string                 `yaml:"server"`
+	CertificateAuthorityData string                 `yaml:"certificate-authority-data,omitempty"`
+	Extra                    map[string]interface{} `yaml:",inline"`
+}
+
+// NamedContext is an entry of contexts
+type NamedContext struct {
+	Name    string  `yaml:"name"`
+	Context Context `yaml:"context"`
+}
+
+// Context pairs a cluster with a user
+type Context struct {
+	Cluster   string                 `yaml:"cluster"`
+	User      string                 `yaml:"user"`
+	Namespace string                 `yaml:"namespace,omitempty"`
+	Extra     map[string]interface{} `yaml:",inline"`
+}
+
+// NamedUser is an entry of users
+type NamedUser struct {
+	Name string `yaml:"name"`
+	User User   `yaml:"user"`
+}
+
+// User is how kubectl authenticates, with a bearer token or a credential plugin
+type User struct {
+	Token string                 `yaml:"token,omitempty"`
+	Exec  *Exec                  `yaml:"exec,omitempty"`
+	Extra map[string]interface{} `yaml:",inline"`
+}
+
+// Exec is a client-go credential plugin
+type Exec struct {
+	APIVersion         string    `yaml:"apiVersion"`
+	Command            string    `yaml:"command"`
+	Args               []string  `yaml:"args,omitempty"`
+	Env                []ExecEnv `yaml:"env,omitempty"`
+	InstallHint        string    `yaml:"installHint,omitempty"`
+	ProvideClusterInfo bool      `yaml:"provideClusterInfo"`
+}
+
+// ExecEnv is an environment variable of a credential plugin
+type ExecEnv struct {
+	Name  string `yaml:"name"`
+	Value string `yaml:"value"`
+}
+
+// Path returns the kubeconfig kubectl writes to, the first file of $KUBECONFIG or
+// ~/.kube/config
+func Path() (string, error) {
+	for _, p := range filepath.SplitList(os.Getenv("KUBECONFIG")) {
+		if p != "" {
+			return p, nil
+		}
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, ".kube", "config"), nil
+}
+
+// Merge sets the cluster, user and context called name in the kubeconfig data, replacing any
+// with the same name, and makes the context current. Empty data starts a new kubeconfig.
+func Merge(data []byte, name string, cluster Cluster, user User) ([]byte, error) {
+	c := &Config{}
+	if len(bytes.TrimSpace(data)) > 0 {
+		if err := yaml.Unmarshal(data, c); err != nil {
+			return nil, fmt.Errorf("kubeconfig: %w", err)
+		}
+	}
+	if c.APIVersion == "" {
+		c.APIVersion = "v1"
+	}
+	if c.Kind == "" {
+		c.Kind = "Config"
+	}
+	if c.Preferences == nil {
+		c.Preferences = map[string]interface{}{}
+	}
+	c.setCluster(NamedCluster{Name: name, Cluster: cluster})
+	c.setUser(NamedUser{Name: name, User: user})
+	c.setContext(NamedContext{Name: name, Context: Context{Cluster: name, User: name}})
+	c.CurrentContext = name
+
+	out := &bytes.Buffer{}
+	enc := yaml.NewEncoder(out)
+	enc.SetIndent(2)
+	if err := enc.Encode(c); err != nil {
+		return nil, err
+	}
+	if err := enc.Close(); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
+
+// Write merges the cluster, user and context called name into the kubeconfig at path, the file
+// and its directory are created if they do not exist
+func Write(path, name string, cluster Cluster, user User) error {
+	data, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	out, err := Merge(data, name, cluster, user)
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+	return os.WriteFile(path, out, 0o600)
+}
+
+func (c *Config) setCluster(n NamedCluster) {
+	for i := range c.Clusters {
+		if c.Clusters[i].Name == n.Name {
+			c.Clusters[i] = n
+			return
+		}
+	}
+	c.Clusters = append(c.Clusters, n)
+}
+
+func (c *Config) setUser(n NamedUser) {
+	for i := range c.Users {
+		if c.Users[i].Name == n.Name {
+			c.Users[i] = n
+			return
+		}
+	}
+	c.Users = append(c.Users, n)
+}
+
+// setContext replaces the context called n.Name, the namespace of an existing context is kept
+func (c *Config) setContext(n NamedContext) {
+	for i := range c.Contexts {
+		if c.Contexts[i].Name == n.Name {
+			n.Context.Namespace = c.Contexts[i].Context.Namespace
+			c.Contexts[i] = n
+			return
+		}
+	}
+	c.Contexts = append(c.Contexts, n)
+}
@@ -0,0 +1,93 @@
+package kubeconfig
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+const existing = `apiVersion: v1
+kind: Config
+clusters:
+  - name: other
+    cluster:
+      server: https://other
+      insecure-skip-tls-verify: true
+  - name: prod
+    cluster:
+      server: https://old
+contexts:
+  - name: other
+    context:
+      cluster: other
+      user: other
+  - name: prod
+    context:
+      cluster: prod
+      user: prod
+      namespace: apps
+users:
+  - name: other
+    user:
+      client-certificate-data: Y2VydA==
+  - name: prod
+    user:
+      token: old
+current-context: other
+`
+
+func TestMerge(t *testing.T) {
+	out, err := Merge([]byte(existing), "prod",
+		Cluster{Server: "https://203.0.113.10", CertificateAuthorityData: "Y2E="},
+		User{Exec: &Exec{APIVersion: "client.authentication.k8s.io/v1beta1", Command: "gke-gcloud-auth-plugin"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := &Config{}
+	if err := yaml.Unmarshal(out, c); err != nil {
+		t.Fatal(err)
+	}
+	if c.CurrentContext != "prod" {
+		t.Errorf("current-context = %q", c.CurrentContext)
+	}
+	if len(c.Clusters) != 2 || len(c.Contexts) != 2 || len(c.Users) != 2 {
+		t.Fatalf("entries were added instead of replaced:\n%s", out)
+	}
+	if got := c.Clusters[1].Cluster; got.Server != "https://203.0.113.10" || got.CertificateAuthorityData != "Y2E=" {
+		t.Errorf("cluster = %+v", got)
+	}
+	if got := c.Users[1].User; got.Token != "" || got.Exec == nil || got.Exec.Command != "gke-gcloud-auth-plugin" {
+		t.Errorf("user = %+v", got)
+	}
+	if got := c.Contexts[1].Context.Namespace; got != "apps" {
+		t.Errorf("namespace = %q, want the existing namespace kept", got)
+	}
+	for _, keep := range []string{"insecure-skip-tls-verify: true", "client-certificate-data: Y2VydA=="} {
+		if !strings.Contains(string(out), keep) {
+			t.Errorf("%s was dropped:\n%s", keep, out)
+		}
+	}
+}
+
+func TestWriteCreatesFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), ".kube", "config")
+	if err := Write(path, "dev", Cluster{Server: "https://10.0.0.2"}, User{Token: "secret"}); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0o600 {
+		t.Errorf("mode = %s, want 0600", info.Mode().Perm())
+	}
+	data, _ := os.ReadFile(path)
+	for _, want := range []string{"apiVersion: v1", "kind: Config", "current-context: dev", "token: secret"} {
+		if !strings.Contains(string(data), want) {
+			t.Errorf("missing %q:\n%s", want, data)
+		}
+	}
+}
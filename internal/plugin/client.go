@@ -0,0 +1,253 @@
+package plugin
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"tidalwave/internal/config"
+	"tidalwave/internal/state"
+	"tidalwave/internal/tidalwave"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+// StartTimeout is how long a plugin has to print its handshake
+var StartTimeout = 30 * time.Second
+
+// Client is a controlplane served by a plugin process, it must be closed to stop the plugin
+type Client struct {
+	// Force is sent with Delete, the plugin keeps its own state
+	Force bool
+
+	path  string
+	cmd   *exec.Cmd
+	stdin io.WriteCloser
+	conn  *grpc.ClientConn
+}
+
+// Factory returns a tidalwave.Factory that starts the plugin at path and sends it the config
+func Factory(path string) tidalwave.Factory {
+	return func(ctx context.Context, cfg *config.Config) (tidalwave.Controlplane, error) {
+		c, err := Start(ctx, path)
+		if err != nil {
+			return nil, err
+		}
+		if err := c.Configure(ctx, cfg); err != nil {
+			c.Close()
+			return nil, err
+		}
+		return c, nil
+	}
+}
+
+// Start runs the plugin at path and connects to it
+func Start(ctx context.Context, path string) (*Client, error) {
+	c := &Client{path: path, cmd: exec.Command(path)}
+	c.cmd.Env = append(os.Environ(), fmt.Sprintf("%s=%s", MagicCookieKey, MagicCookieValue))
+	c.cmd.Stderr = os.Stderr
+	stdin, err := c.cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	c.stdin = stdin
+	stdout, err := c.cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := c.cmd.Start(); err != nil {
+		return nil, fmt.Errorf("plugin %s: %w", path, err)
+	}
+
+	out := bufio.NewReader(stdout)
+	lines := make(chan string, 1)
+	errs := make(chan error, 1)
+	go func() {
+		line, err := out.ReadString('\n')
+		if err != nil {
+			errs <- fmt.Errorf("plugin %s exited before the handshake: %w", path, err)
+			return
+		}
+		lines <- strings.TrimSpace(line)
+	}()
+	var line string
+	select {
+	case line = <-lines:
+	case err = <-errs:
+	case <-time.After(StartTimeout):
+		err = fmt.Errorf("plugin %s did not handshake within %s", path, StartTimeout)
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	if err == nil {
+		err = c.dial(ctx, line)
+	}
+	if err != nil {
+		c.Close()
+		return nil, err
+	}
+	// the rest of stdout is the plugin's progress output, it goes through the event bus so
+	// it never mixes with structured output
+	go func() {
+		for {
+			line, err := out.ReadString('\n')
+			if line = strings.TrimRight(line, "\r\n"); line != "" {
+				tidalwave.Infof(ctx, ":electric_plug:", "%s", line)
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+	return c, nil
+}
+
+// dial connects to the address in the handshake line
+func (c *Client) dial(ctx context.Context, handshake string) error {
+	parts := strings.Split(handshake, "|")
+	if len(parts) != 3 {
+		return fmt.Errorf("plugin %s: bad handshake %q, expected version|network|address", c.path, handshake)
+	}
+	version, err := strconv.Atoi(parts[0])
+	if err != nil || version != ProtocolVersion {
+		return fmt.Errorf("plugin %s speaks protocol %s, expected %d", c.path, parts[0], ProtocolVersion)
+	}
+	if parts[1] != "tcp" {
+		return fmt.Errorf("plugin %s: unsupported network %s", c.path, parts[1])
+	}
+	conn, err := grpc.DialContext(ctx, parts[2], grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		return fmt.Errorf("plugin %s: %w", c.path, err)
+	}
+	c.conn = conn
+	return nil
+}
+
+// Close disconnects from the plugin and waits for it to exit, killing it if it takes too long
+func (c *Client) Close() error {
+	if c.conn != nil {
+		c.conn.Close()
+	}
+	c.stdin.Close()
+	done := make(chan error, 1)
+	go func() {
+		done <- c.cmd.Wait()
+	}()
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		_ = c.cmd.Process.Kill()
+		<-done
+	}
+	return nil
+}
+
+// invoke calls a service method, converting gRPC statuses back to errors
+func (c *Client) invoke(ctx context.Context, name string, in, out interface{}) error {
+	err := c.conn.Invoke(ctx, method(name), in, out)
+	if err == nil {
+		return nil
+	}
+	st := status.Convert(err)
+	switch st.Code() {
+	case codes.Unimplemented:
+		return fmt.Errorf("%s: %w", strings.ToLower(name), tidalwave.ErrUnsupported)
+	case codes.Canceled, codes.DeadlineExceeded:
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+	}
+	return errors.New(st.Message())
+}
+
+// Configure sends the config file, with defaults applied, to the plugin
+func (c *Client) Configure(ctx context.Context, cfg *config.Config) error {
+	s, err := toStruct(cfg)
+	if err != nil {
+		return err
+	}
+	return c.invoke(ctx, "Configure", s, &emptypb.Empty{})
+}
+
+// SetState records force, the plugin keeps its own state so store is not used
+func (c *Client) SetState(store *state.Store, force bool) {
+	c.Force = force
+}
+
+// EnableApis enables the cloud APIs the controlplane needs
+func (c *Client) EnableApis(ctx context.Context) error {
+	return c.invoke(ctx, "EnableApis", &emptypb.Empty{}, &emptypb.Empty{})
+}
+
+// Create controlplane
+func (c *Client) Create(ctx context.Context) error {
+	return c.invoke(ctx, "Create", &emptypb.Empty{}, &emptypb.Empty{})
+}
+
+// Update controlplane
+func (c *Client) Update(ctx context.Context) error {
+	return c.invoke(ctx, "Update", &emptypb.Empty{}, &emptypb.Empty{})
+}
+
+// Delete controlplane
+func (c *Client) Delete(ctx context.Context) error {
+	in, err := structpb.NewStruct(map[string]interface{}{"force": c.Force})
+	if err != nil {
+		return err
+	}
+	return c.invoke(ctx, "Delete", in, &emptypb.Empty{})
+}
+
+// Plan compares the config with the live controlplane without changing anything
+func (c *Client) Plan(ctx context.Context) ([]tidalwave.ResourcePlan, error) {
+	out := &structpb.ListValue{}
+	if err := c.invoke(ctx, "Plan", &emptypb.Empty{}, out); err != nil {
+		return nil, err
+	}
+	plan := []tidalwave.ResourcePlan{}
+	if err := fromList(out, &plan); err != nil {
+		return nil, err
+	}
+	return plan, nil
+}
+
+// Status reports the live state of every resource
+func (c *Client) Status(ctx context.Context) ([]tidalwave.ResourceStatus, error) {
+	out := &structpb.ListValue{}
+	if err := c.invoke(ctx, "Status", &emptypb.Empty{}, out); err != nil {
+		return nil, err
+	}
+	st := []tidalwave.ResourceStatus{}
+	if err := fromList(out, &st); err != nil {
+		return nil, err
+	}
+	return st, nil
+}
+
+// Credentials returns the endpoints and credentials of the cluster
+func (c *Client) Credentials(ctx context.Context, token bool) (*tidalwave.ClusterCredentials, error) {
+	in, err := structpb.NewStruct(map[string]interface{}{"token": token})
+	if err != nil {
+		return nil, err
+	}
+	out := &structpb.Struct{}
+	if err := c.invoke(ctx, "Credentials", in, out); err != nil {
+		return nil, err
+	}
+	creds := &tidalwave.ClusterCredentials{}
+	if err := fromStruct(out, creds); err != nil {
+		return nil, err
+	}
+	return creds, nil
+}
@@ -0,0 +1,127 @@
+/*
+Package plugin runs tidalwave providers out of process. A plugin is an executable named
+tidalwave-provider-<name> that serves the tidalwave.plugin.v1.Provider gRPC service, so
+providers can be shipped without changing tidalwave.
+
+tidalwave starts the plugin with MagicCookieKey=MagicCookieValue in its environment and
+reads one handshake line from its stdout:
+
+	<protocol version>|tcp|<host:port>
+
+It then dials host:port and calls the service methods, all of them unary and using the
+protobuf well-known types so plugins can be written in any language:
+
+	Configure(google.protobuf.Struct) google.protobuf.Empty   the config file with defaults
+	EnableApis(google.protobuf.Empty) google.protobuf.Empty
+	Create(google.protobuf.Empty) google.protobuf.Empty
+	Update(google.protobuf.Empty) google.protobuf.Empty
+	Delete(google.protobuf.Struct) google.protobuf.Empty      {"force": bool}
+	Plan(google.protobuf.Empty) google.protobuf.ListValue     resource plans as JSON objects
+	Status(google.protobuf.Empty) google.protobuf.ListValue   resource statuses as JSON objects
+	Credentials(google.protobuf.Struct) google.protobuf.Struct  {"token": bool}, cluster credentials as a JSON object
+
+Errors are returned as gRPC statuses, UNIMPLEMENTED means the provider does not support the
+method. The rest of the plugin's stdout is emitted line by line as info events, its stderr is
+shown to the user as is, and the plugin should exit when its stdin is closed.
+*/
+package plugin
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+const (
+	// ProtocolVersion is the version of the plugin protocol in the handshake
+	ProtocolVersion = 1
+	// ServiceName is the gRPC service plugins serve
+	ServiceName = "tidalwave.plugin.v1.Provider"
+	// MagicCookieKey is set to MagicCookieValue in the environment of plugins so they can
+	// tell they were started by tidalwave
+	MagicCookieKey = "TIDALWAVE_PLUGIN_MAGIC_COOKIE"
+	// MagicCookieValue is the value of MagicCookieKey
+	MagicCookieValue = "8c5b1f7e0d2a4e6b9a3c"
+	// Prefix is the start of the file name of every plugin executable
+	Prefix = "tidalwave-provider-"
+)
+
+// Discover finds plugin executables in dirs, keyed by provider name, the first dir a
+// provider is found in wins
+func Discover(dirs ...string) map[string]string {
+	found := map[string]string{}
+	for _, dir := range dirs {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			continue
+		}
+		for _, e := range entries {
+			name := strings.TrimSuffix(e.Name(), filepath.Ext(e.Name()))
+			if !strings.HasPrefix(name, Prefix) || e.IsDir() {
+				continue
+			}
+			info, err := e.Info()
+			if err != nil || info.Mode().Perm()&0o111 == 0 {
+				continue
+			}
+			provider := strings.TrimPrefix(name, Prefix)
+			if _, ok := found[provider]; !ok && provider != "" {
+				found[provider] = filepath.Join(dir, e.Name())
+			}
+		}
+	}
+	return found
+}
+
+// method is the full gRPC name of a service method
+func method(name string) string {
+	return fmt.Sprintf("/%s/%s", ServiceName, name)
+}
+
+// toStruct converts v to a Struct through JSON so any JSON serializable value can be sent
+func toStruct(v interface{}) (*structpb.Struct, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	m := map[string]interface{}{}
+	if err := json.Unmarshal(b, &m); err != nil {
+		return nil, err
+	}
+	return structpb.NewStruct(m)
+}
+
+// fromStruct converts a Struct back into the value pointed to by v
+func fromStruct(s *structpb.Struct, v interface{}) error {
+	b, err := s.MarshalJSON()
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
+
+// toList converts a slice to a ListValue through JSON
+func toList(v interface{}) (*structpb.ListValue, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	l := []interface{}{}
+	if err := json.Unmarshal(b, &l); err != nil {
+		return nil, err
+	}
+	return structpb.NewList(l)
+}
+
+// fromList converts a ListValue back into the slice pointed to by v
+func fromList(l *structpb.ListValue, v interface{}) error {
+	b, err := json.Marshal(l.AsSlice())
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
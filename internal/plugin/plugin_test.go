@@ -0,0 +1,201 @@
+package plugin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"tidalwave/internal/config"
+	"tidalwave/internal/state"
+	"tidalwave/internal/tidalwave"
+	"time"
+)
+
+// helperEnv makes the test binary serve fakeFactory as a plugin instead of running the tests
+const helperEnv = "TIDALWAVE_PLUGIN_TEST_HELPER"
+
+func TestMain(m *testing.M) {
+	if os.Getenv(helperEnv) == "1" {
+		if err := Serve(fakeFactory); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+// fake is a controlplane that only has a plan, Create fails when spec.plugin.fail is set and Delete
+// fails unless forced
+type fake struct {
+	name  string
+	fail  bool
+	force bool
+}
+
+func fakeFactory(ctx context.Context, cfg *config.Config) (tidalwave.Controlplane, error) {
+	if cfg.Metadata.Name == "" {
+		return nil, fmt.Errorf("metadata.name cannot be nil")
+	}
+	_, fail := cfg.Spec.Plugin["fail"]
+	return &fake{name: cfg.Metadata.Name, fail: fail}, nil
+}
+
+func (f *fake) SetState(store *state.Store, force bool) { f.force = force }
+func (f *fake) EnableApis(ctx context.Context) error    { return nil }
+func (f *fake) Update(ctx context.Context) error        { return nil }
+
+func (f *fake) Create(ctx context.Context) error {
+	if f.fail {
+		return fmt.Errorf("cluster/%s: quota exceeded", f.name)
+	}
+	fmt.Printf("cluster/%s created\n", f.name)
+	return nil
+}
+
+func (f *fake) Delete(ctx context.Context) error {
+	if !f.force {
+		return fmt.Errorf("cluster/%s is not in state", f.name)
+	}
+	return nil
+}
+
+func (f *fake) Plan(ctx context.Context) ([]tidalwave.ResourcePlan, error) {
+	return []tidalwave.ResourcePlan{
+		*tidalwave.NewResourcePlan("cluster", f.name, true, []tidalwave.Change{
+			{Field: "version", Observed: "1.24", Desired: "1.25", ForceNew: true},
+		}),
+	}, nil
+}
+
+func (f *fake) Status(ctx context.Context) ([]tidalwave.ResourceStatus, error) {
+	return nil, fmt.Errorf("status: %w", tidalwave.ErrUnsupported)
+}
+
+func (f *fake) Credentials(ctx context.Context, token bool) (*tidalwave.ClusterCredentials, error) {
+	creds := &tidalwave.ClusterCredentials{
+		Endpoint:             "203.0.113.10",
+		CertificateAuthority: "Y2E=",
+		Exec:                 &tidalwave.ExecCredential{APIVersion: "client.authentication.k8s.io/v1beta1", Command: "fake-auth", Args: []string{f.name}},
+	}
+	if token {
+		creds.Token = "token-" + f.name
+	}
+	return creds, nil
+}
+
+// start starts the test binary as a plugin configured with cfg
+func start(t *testing.T, cfg *config.Config) tidalwave.Controlplane {
+	t.Helper()
+	t.Setenv(helperEnv, "1")
+	c, err := Factory(os.Args[0])(context.Background(), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.(*Client).Close() })
+	return c
+}
+
+func TestPlugin(t *testing.T) {
+	ctx := context.Background()
+	c := start(t, &config.Config{Metadata: config.Metadata{Name: "test"}})
+
+	if err := c.Create(ctx); err != nil {
+		t.Fatal(err)
+	}
+	plan, err := c.Plan(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(plan) != 1 || plan[0].Name != "test" || plan[0].Action != tidalwave.ActionReplace || !plan[0].Changes[0].ForceNew {
+		t.Errorf("unexpected plan %+v", plan)
+	}
+	if _, err := c.Status(ctx); !errors.Is(err, tidalwave.ErrUnsupported) {
+		t.Errorf("expected ErrUnsupported, got %v", err)
+	}
+	creds, err := c.Credentials(ctx, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if creds.Endpoint != "203.0.113.10" || creds.Token != "token-test" || creds.Exec.Args[0] != "test" {
+		t.Errorf("unexpected credentials %+v", creds)
+	}
+
+	if err := c.Delete(ctx); err == nil || !strings.Contains(err.Error(), "not in state") {
+		t.Errorf("expected delete to fail without force, got %v", err)
+	}
+	c.(tidalwave.Stateful).SetState(nil, true)
+	if err := c.Delete(ctx); err != nil {
+		t.Errorf("forced delete: %s", err)
+	}
+}
+
+// eventChan renders events by sending them on the channel
+type eventChan chan tidalwave.Event
+
+func (c eventChan) Render(e tidalwave.Event) { c <- e }
+
+func TestPluginOutput(t *testing.T) {
+	events := make(eventChan, 10)
+	ctx := tidalwave.WithBus(context.Background(), tidalwave.NewBus(events))
+	t.Setenv(helperEnv, "1")
+	c, err := Factory(os.Args[0])(ctx, &config.Config{Metadata: config.Metadata{Name: "test"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.(*Client).Close()
+	if err := c.Create(ctx); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case e := <-events:
+		if e.Phase != tidalwave.PhaseInfo || e.Message != "cluster/test created" {
+			t.Errorf("unexpected event %+v", e)
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("plugin output was not emitted as an event")
+	}
+}
+
+func TestPluginErrors(t *testing.T) {
+	ctx := context.Background()
+	c := start(t, &config.Config{
+		Metadata: config.Metadata{Name: "test"},
+		Spec:     config.Spec{Plugin: map[string]interface{}{"fail": true}},
+	})
+	if err := c.Create(ctx); err == nil || err.Error() != "cluster/test: quota exceeded" {
+		t.Errorf("expected the plugin's error, got %v", err)
+	}
+
+	t.Setenv(helperEnv, "1")
+	_, err := Factory(os.Args[0])(ctx, &config.Config{})
+	if err == nil || !strings.Contains(err.Error(), "metadata.name cannot be nil") {
+		t.Errorf("expected the factory error, got %v", err)
+	}
+}
+
+func TestServeOutsideTidalwave(t *testing.T) {
+	if err := Serve(fakeFactory); err == nil {
+		t.Error("expected Serve to refuse to run without the magic cookie")
+	}
+}
+
+func TestDiscover(t *testing.T) {
+	dir := t.TempDir()
+	for name, mode := range map[string]os.FileMode{
+		"tidalwave-provider-internal": 0o755,
+		"tidalwave-provider-noexec":   0o644,
+		"kubectl":                     0o755,
+	} {
+		if err := os.WriteFile(filepath.Join(dir, name), nil, mode); err != nil {
+			t.Fatal(err)
+		}
+	}
+	found := Discover(dir, "/does/not/exist")
+	if len(found) != 1 || found["internal"] != filepath.Join(dir, "tidalwave-provider-internal") {
+		t.Errorf("unexpected plugins %v", found)
+	}
+}
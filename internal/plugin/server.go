@@ -0,0 +1,208 @@
+package plugin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"tidalwave/internal/config"
+	"tidalwave/internal/tidalwave"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/emptypb"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+// server serves a controlplane built by factory once tidalwave sends the config
+type server struct {
+	factory tidalwave.Factory
+
+	mu sync.Mutex
+	cp tidalwave.Controlplane
+}
+
+// controlplane returns the configured controlplane
+func (s *server) controlplane() (tidalwave.Controlplane, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.cp == nil {
+		return nil, status.Error(codes.FailedPrecondition, "plugin is not configured")
+	}
+	return s.cp, nil
+}
+
+// toStatus converts a provider error to a gRPC status
+func toStatus(err error) error {
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, tidalwave.ErrUnsupported):
+		return status.Error(codes.Unimplemented, err.Error())
+	case errors.Is(err, context.Canceled):
+		return status.Error(codes.Canceled, err.Error())
+	case errors.Is(err, context.DeadlineExceeded):
+		return status.Error(codes.DeadlineExceeded, err.Error())
+	}
+	if _, ok := status.FromError(err); ok {
+		return err
+	}
+	return status.Error(codes.Unknown, err.Error())
+}
+
+func (s *server) configure(ctx context.Context, in proto.Message) (proto.Message, error) {
+	cfg := &config.Config{}
+	if err := fromStruct(in.(*structpb.Struct), cfg); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	cp, err := s.factory(ctx, cfg)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.cp = cp
+	return &emptypb.Empty{}, nil
+}
+
+// call wraps a controlplane method that only returns an error
+func call(fn func(ctx context.Context, cp tidalwave.Controlplane) error) func(s *server, ctx context.Context, in proto.Message) (proto.Message, error) {
+	return func(s *server, ctx context.Context, in proto.Message) (proto.Message, error) {
+		cp, err := s.controlplane()
+		if err != nil {
+			return nil, err
+		}
+		if err := fn(ctx, cp); err != nil {
+			return nil, toStatus(err)
+		}
+		return &emptypb.Empty{}, nil
+	}
+}
+
+func (s *server) delete(ctx context.Context, in proto.Message) (proto.Message, error) {
+	cp, err := s.controlplane()
+	if err != nil {
+		return nil, err
+	}
+	if st, ok := cp.(tidalwave.Stateful); ok {
+		force, _ := in.(*structpb.Struct).AsMap()["force"].(bool)
+		st.SetState(nil, force)
+	}
+	if err := cp.Delete(ctx); err != nil {
+		return nil, toStatus(err)
+	}
+	return &emptypb.Empty{}, nil
+}
+
+func (s *server) plan(ctx context.Context, in proto.Message) (proto.Message, error) {
+	cp, err := s.controlplane()
+	if err != nil {
+		return nil, err
+	}
+	plan, err := cp.Plan(ctx)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return toList(plan)
+}
+
+func (s *server) status(ctx context.Context, in proto.Message) (proto.Message, error) {
+	cp, err := s.controlplane()
+	if err != nil {
+		return nil, err
+	}
+	st, err := cp.Status(ctx)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return toList(st)
+}
+
+func (s *server) credentials(ctx context.Context, in proto.Message) (proto.Message, error) {
+	cp, err := s.controlplane()
+	if err != nil {
+		return nil, err
+	}
+	token := in.(*structpb.Struct).GetFields()["token"].GetBoolValue()
+	creds, err := cp.Credentials(ctx, token)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return toStruct(creds)
+}
+
+// handler adapts fn to a gRPC unary method
+func handler(name string, newIn func() proto.Message, fn func(s *server, ctx context.Context, in proto.Message) (proto.Message, error)) grpc.MethodDesc {
+	return grpc.MethodDesc{
+		MethodName: name,
+		Handler: func(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+			in := newIn()
+			if err := dec(in); err != nil {
+				return nil, err
+			}
+			s := srv.(*server)
+			if interceptor == nil {
+				return fn(s, ctx, in)
+			}
+			info := &grpc.UnaryServerInfo{Server: srv, FullMethod: method(name)}
+			return interceptor(ctx, in, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+				return fn(s, ctx, req.(proto.Message))
+			})
+		},
+	}
+}
+
+func newEmpty() proto.Message {
+	return &emptypb.Empty{}
+}
+
+func newStruct() proto.Message {
+	return &structpb.Struct{}
+}
+
+var serviceDesc = grpc.ServiceDesc{
+	ServiceName: ServiceName,
+	HandlerType: (*interface{})(nil),
+	Methods: []grpc.MethodDesc{
+		handler("Configure", newStruct, (*server).configure),
+		handler("EnableApis", newEmpty, call(func(ctx context.Context, cp tidalwave.Controlplane) error {
+			return cp.EnableApis(ctx)
+		})),
+		handler("Create", newEmpty, call(func(ctx context.Context, cp tidalwave.Controlplane) error {
+			return cp.Create(ctx)
+		})),
+		handler("Update", newEmpty, call(func(ctx context.Context, cp tidalwave.Controlplane) error {
+			return cp.Update(ctx)
+		})),
+		handler("Delete", newStruct, (*server).delete),
+		handler("Plan", newEmpty, (*server).plan),
+		handler("Status", newEmpty, (*server).status),
+		handler("Credentials", newStruct, (*server).credentials),
+	},
+}
+
+// Serve runs a provider plugin, it must be called from the main function of the plugin and
+// returns once tidalwave closes the plugin's stdin
+func Serve(factory tidalwave.Factory) error {
+	if os.Getenv(MagicCookieKey) != MagicCookieValue {
+		return fmt.Errorf("this is a tidalwave provider plugin, it is started by tidalwave and is not meant to be run directly")
+	}
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return err
+	}
+	s := grpc.NewServer()
+	s.RegisterService(&serviceDesc, &server{factory: factory})
+	go func() {
+		// tidalwave closes stdin when it is done with the plugin or exits
+		_, _ = io.Copy(io.Discard, os.Stdin)
+		s.Stop()
+	}()
+	fmt.Printf("%d|tcp|%s\n", ProtocolVersion, lis.Addr())
+	return s.Serve(lis)
+}
@@ -0,0 +1,157 @@
+package state
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+
+	"google.golang.org/api/googleapi"
+	storage "google.golang.org/api/storage/v1"
+)
+
+// GCSBackend stores state in a GCS object. Every write is conditional on the generation
+// that was read, and the lock is a second object that can only be created if it is absent.
+type GCSBackend struct {
+	Bucket string
+	Object string
+
+	service    *storage.Service
+	generation int64
+	lockGen    int64
+}
+
+func (g *GCSBackend) client(ctx context.Context) (*storage.Service, error) {
+	if g.service != nil {
+		return g.service, nil
+	}
+	s, err := storage.NewService(ctx)
+	if err != nil {
+		return nil, err
+	}
+	g.service = s
+	return s, nil
+}
+
+func (g *GCSBackend) lockObject() string {
+	return g.Object + ".lock"
+}
+
+// Lock creates the lock object with a generation precondition of 0, which only succeeds if
+// no other process holds the lock
+func (g *GCSBackend) Lock(ctx context.Context) error {
+	s, err := g.client(ctx)
+	if err != nil {
+		return err
+	}
+	obj, err := s.Objects.Insert(g.Bucket, &storage.Object{Name: g.lockObject()}).
+		IfGenerationMatch(0).
+		Media(bytes.NewReader([]byte("{}"))).
+		Context(ctx).
+		Do()
+	if isPreconditionFailed(err) {
+		return ErrLocked
+	}
+	if err != nil {
+		return err
+	}
+	g.lockGen = obj.Generation
+	return nil
+}
+
+// Unlock deletes the lock object this backend created. A backend that did not take the lock,
+// such as the one behind state unlock, deletes whichever lock object is there.
+func (g *GCSBackend) Unlock(ctx context.Context) error {
+	s, err := g.client(ctx)
+	if err != nil {
+		return err
+	}
+	gen := g.lockGen
+	if gen == 0 {
+		obj, err := s.Objects.Get(g.Bucket, g.lockObject()).Context(ctx).Do()
+		if isNotFound(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		gen = obj.Generation
+	}
+	err = s.Objects.Delete(g.Bucket, g.lockObject()).IfGenerationMatch(gen).Context(ctx).Do()
+	if isPreconditionFailed(err) {
+		return ErrLocked
+	}
+	if err != nil {
+		return err
+	}
+	g.lockGen = 0
+	return nil
+}
+
+// Read the state object and remember its generation
+func (g *GCSBackend) Read(ctx context.Context) (*State, error) {
+	s, err := g.client(ctx)
+	if err != nil {
+		return nil, err
+	}
+	obj, err := s.Objects.Get(g.Bucket, g.Object).Context(ctx).Do()
+	if isNotFound(err) {
+		g.generation = 0
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	resp, err := s.Objects.Get(g.Bucket, g.Object).Generation(obj.Generation).Context(ctx).Download()
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	st := &State{}
+	if err := json.Unmarshal(b, st); err != nil {
+		return nil, err
+	}
+	g.generation = obj.Generation
+	return st, nil
+}
+
+// Write the state object if nobody else wrote it since it was read
+func (g *GCSBackend) Write(ctx context.Context, st *State) error {
+	s, err := g.client(ctx)
+	if err != nil {
+		return err
+	}
+	b, err := json.MarshalIndent(st, "", "  ")
+	if err != nil {
+		return err
+	}
+	obj, err := s.Objects.Insert(g.Bucket, &storage.Object{Name: g.Object, ContentType: "application/json"}).
+		IfGenerationMatch(g.generation).
+		Media(bytes.NewReader(b)).
+		Context(ctx).
+		Do()
+	if isPreconditionFailed(err) {
+		return fmt.Errorf("gs://%s/%s was changed by another process since it was read", g.Bucket, g.Object)
+	}
+	if err != nil {
+		return err
+	}
+	g.generation = obj.Generation
+	return nil
+}
+
+func isPreconditionFailed(err error) bool {
+	var apiErr *googleapi.Error
+	return errors.As(err, &apiErr) && apiErr.Code == 412
+}
+
+func isNotFound(err error) bool {
+	var apiErr *googleapi.Error
+	return errors.As(err, &apiErr) && apiErr.Code == 404
+}
@@ -0,0 +1,67 @@
+package state
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+)
+
+// LocalBackend stores state in a JSON file with a sibling lock file
+type LocalBackend struct {
+	Path string
+}
+
+func (l *LocalBackend) lockPath() string {
+	return l.Path + ".lock"
+}
+
+// Lock creates the lock file, failing if it already exists
+func (l *LocalBackend) Lock(ctx context.Context) error {
+	if err := os.MkdirAll(filepath.Dir(l.Path), 0o700); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(l.lockPath(), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o600)
+	if errors.Is(err, os.ErrExist) {
+		return ErrLocked
+	}
+	if err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// Unlock removes the lock file
+func (l *LocalBackend) Unlock(ctx context.Context) error {
+	return os.Remove(l.lockPath())
+}
+
+// Read the state file
+func (l *LocalBackend) Read(ctx context.Context) (*State, error) {
+	b, err := os.ReadFile(l.Path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	s := &State{}
+	if err := json.Unmarshal(b, s); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Write the state file, replacing it atomically
+func (l *LocalBackend) Write(ctx context.Context, s *State) error {
+	b, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := l.Path + ".tmp"
+	if err := os.WriteFile(tmp, b, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, l.Path)
+}
@@ -0,0 +1,168 @@
+/*
+Package state records which cloud resources tidalwave created
+*/
+package state
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Version is the version of the state file format
+const Version = 1
+
+// ErrLocked is returned when another tidalwave process holds the state lock
+var ErrLocked = errors.New("state is locked by another tidalwave process")
+
+// Resource is a single cloud resource created by tidalwave
+type Resource struct {
+	Kind     string    `json:"kind"`
+	Name     string    `json:"name"`
+	SelfLink string    `json:"selfLink"`
+	Created  time.Time `json:"created"`
+	// Fingerprint is the unique id the cloud provider assigned to the resource, it tells a
+	// resource tidalwave created apart from one recreated with the same name
+	Fingerprint string `json:"fingerprint"`
+	// ConfigHash is the hash of the config that produced the resource
+	ConfigHash string `json:"configHash"`
+}
+
+// ID returns the kind/name address of the resource
+func (r Resource) ID() string {
+	return fmt.Sprintf("%s/%s", r.Kind, r.Name)
+}
+
+// State is the document persisted by a Backend
+type State struct {
+	Version      int        `json:"version"`
+	Serial       int64      `json:"serial"`
+	Controlplane string     `json:"controlplane"`
+	Resources    []Resource `json:"resources"`
+}
+
+// Backend persists state
+type Backend interface {
+	// Lock takes an exclusive lock on the state, returning ErrLocked if it is held elsewhere
+	Lock(ctx context.Context) error
+	// Unlock releases the lock taken by Lock
+	Unlock(ctx context.Context) error
+	// Read returns the stored state or nil if there is none yet
+	Read(ctx context.Context) (*State, error)
+	// Write stores the state
+	Write(ctx context.Context, s *State) error
+}
+
+// Store is a locked, in memory copy of the state that is written back on every change
+type Store struct {
+	mu      sync.Mutex
+	backend Backend
+	state   *State
+}
+
+// Open locks the backend and reads the state for the named controlplane
+func Open(ctx context.Context, backend Backend, controlplane string) (*Store, error) {
+	if err := backend.Lock(ctx); err != nil {
+		return nil, err
+	}
+	s, err := backend.Read(ctx)
+	if err != nil {
+		backend.Unlock(ctx)
+		return nil, err
+	}
+	if s == nil {
+		s = &State{
+			Version:      Version,
+			Controlplane: controlplane,
+		}
+	}
+	if s.Controlplane != controlplane {
+		backend.Unlock(ctx)
+		return nil, fmt.Errorf("state belongs to controlplane %s not %s", s.Controlplane, controlplane)
+	}
+	return &Store{
+		backend: backend,
+		state:   s,
+	}, nil
+}
+
+// Close releases the state lock
+func (s *Store) Close(ctx context.Context) error {
+	return s.backend.Unlock(ctx)
+}
+
+// Get returns the resource with the kind and name
+func (s *Store) Get(kind, name string) (Resource, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, r := range s.state.Resources {
+		if r.Kind == kind && r.Name == name {
+			return r, true
+		}
+	}
+	return Resource{}, false
+}
+
+// Resources returns every resource in the state sorted by kind and name
+func (s *Store) Resources() []Resource {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	resources := append([]Resource{}, s.state.Resources...)
+	sort.Slice(resources, func(i, j int) bool {
+		return resources[i].ID() < resources[j].ID()
+	})
+	return resources
+}
+
+// Put adds or replaces a resource and writes the state
+func (s *Store) Put(ctx context.Context, r Resource) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	resources := []Resource{}
+	for _, existing := range s.state.Resources {
+		if existing.ID() != r.ID() {
+			resources = append(resources, existing)
+		}
+	}
+	s.state.Resources = append(resources, r)
+	return s.write(ctx)
+}
+
+// Remove drops a resource from the state and writes it, it does not delete the resource
+func (s *Store) Remove(ctx context.Context, kind, name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	resources := []Resource{}
+	found := false
+	for _, r := range s.state.Resources {
+		if r.Kind == kind && r.Name == name {
+			found = true
+			continue
+		}
+		resources = append(resources, r)
+	}
+	if !found {
+		return fmt.Errorf("%s/%s is not in the state", kind, name)
+	}
+	s.state.Resources = resources
+	return s.write(ctx)
+}
+
+func (s *Store) write(ctx context.Context) error {
+	s.state.Serial++
+	return s.backend.Write(ctx, s.state)
+}
+
+// Hash returns a stable hash of the config that produced a resource
+func Hash(config interface{}) string {
+	b, err := json.Marshal(config)
+	if err != nil {
+		return ""
+	}
+	return fmt.Sprintf("%x", sha256.Sum256(b))
+}
@@ -0,0 +1,239 @@
+package state
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"google.golang.org/api/option"
+	storage "google.golang.org/api/storage/v1"
+)
+
+func TestOpen(t *testing.T) {
+	ctx := context.Background()
+	backend := &LocalBackend{Path: filepath.Join(t.TempDir(), "state.json")}
+	s, err := Open(ctx, backend, "mycluster")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Put(ctx, Resource{Kind: "vpc", Name: "mycluster", Fingerprint: "1"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Close(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := Open(ctx, backend, "other"); err == nil || !strings.Contains(err.Error(), "belongs to controlplane mycluster") {
+		t.Errorf("got %v, want a controlplane mismatch", err)
+	}
+	// a mismatch releases the lock
+	s, err = Open(ctx, backend, "mycluster")
+	if err != nil {
+		t.Fatalf("lock not released after a mismatch: %s", err)
+	}
+	s.Close(ctx)
+}
+
+func TestStore(t *testing.T) {
+	ctx := context.Background()
+	backend := &LocalBackend{Path: filepath.Join(t.TempDir(), "state.json")}
+	s, err := Open(ctx, backend, "mycluster")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close(ctx)
+
+	if err := s.Put(ctx, Resource{Kind: "vpc", Name: "mycluster", Fingerprint: "1"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Put(ctx, Resource{Kind: "cluster", Name: "mycluster", Fingerprint: "2"}); err != nil {
+		t.Fatal(err)
+	}
+	// putting a resource again replaces it
+	if err := s.Put(ctx, Resource{Kind: "vpc", Name: "mycluster", Fingerprint: "3"}); err != nil {
+		t.Fatal(err)
+	}
+	if r, ok := s.Get("vpc", "mycluster"); !ok || r.Fingerprint != "3" {
+		t.Errorf("got %+v, %t", r, ok)
+	}
+	if got := s.Resources(); len(got) != 2 || got[0].ID() != "cluster/mycluster" {
+		t.Errorf("resources %+v, want cluster and vpc in order", got)
+	}
+
+	if err := s.Remove(ctx, "vpc", "mycluster"); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := s.Get("vpc", "mycluster"); ok {
+		t.Error("vpc still in state after Remove")
+	}
+	if err := s.Remove(ctx, "vpc", "mycluster"); err == nil {
+		t.Error("removing a resource that is not in state succeeded")
+	}
+
+	stored, err := backend.Read(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.Serial != 4 || len(stored.Resources) != 1 {
+		t.Errorf("stored serial %d with %d resources, want 4 writes and 1 resource", stored.Serial, len(stored.Resources))
+	}
+}
+
+func TestLocalLock(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "state", "state.json")
+	a := &LocalBackend{Path: path}
+	b := &LocalBackend{Path: path}
+	if err := a.Lock(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Lock(ctx); !errors.Is(err, ErrLocked) {
+		t.Errorf("got %v, want ErrLocked while another backend holds the lock", err)
+	}
+	if err := b.Unlock(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.Lock(ctx); err != nil {
+		t.Errorf("lock not released by Unlock: %s", err)
+	}
+}
+
+// gcsServer is a fake of the GCS JSON API that stores object generations, enough for the
+// preconditions of GCSBackend
+type gcsServer struct {
+	mu          sync.Mutex
+	generations map[string]int64
+	next        int64
+}
+
+func (s *gcsServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	match := r.URL.Query().Get("ifGenerationMatch")
+	switch {
+	case r.Method == http.MethodPost && strings.HasPrefix(r.URL.Path, "/upload/"):
+		name, err := uploadName(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if match != "" && match != strconv.FormatInt(s.generations[name], 10) {
+			http.Error(w, `{"error":{"code":412,"message":"precondition failed"}}`, http.StatusPreconditionFailed)
+			return
+		}
+		s.next++
+		s.generations[name] = s.next
+		json.NewEncoder(w).Encode(storage.Object{Name: name, Generation: s.next})
+	case strings.HasPrefix(r.URL.Path, "/storage/v1/b/bucket/o/"):
+		name := strings.TrimPrefix(r.URL.Path, "/storage/v1/b/bucket/o/")
+		gen, ok := s.generations[name]
+		if !ok {
+			http.Error(w, `{"error":{"code":404,"message":"not found"}}`, http.StatusNotFound)
+			return
+		}
+		if r.Method == http.MethodGet {
+			json.NewEncoder(w).Encode(storage.Object{Name: name, Generation: gen})
+			return
+		}
+		if match != "" && match != strconv.FormatInt(gen, 10) {
+			http.Error(w, `{"error":{"code":412,"message":"precondition failed"}}`, http.StatusPreconditionFailed)
+			return
+		}
+		delete(s.generations, name)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, fmt.Sprintf("unexpected %s %s", r.Method, r.URL), http.StatusNotImplemented)
+	}
+}
+
+// uploadName returns the object name in the metadata part of a multipart upload
+func uploadName(r *http.Request) (string, error) {
+	_, params, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil {
+		return "", err
+	}
+	part, err := multipart.NewReader(r.Body, params["boundary"]).NextPart()
+	if err != nil {
+		return "", err
+	}
+	obj := storage.Object{}
+	if err := json.NewDecoder(part).Decode(&obj); err != nil {
+		return "", err
+	}
+	return obj.Name, nil
+}
+
+// gcsBackend returns a backend that talks to server
+func gcsBackend(t *testing.T, server *httptest.Server) *GCSBackend {
+	t.Helper()
+	service, err := storage.NewService(context.Background(), option.WithEndpoint(server.URL+"/storage/v1/"), option.WithoutAuthentication())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &GCSBackend{Bucket: "bucket", Object: "tidalwave/mycluster.json", service: service}
+}
+
+func TestGCSLock(t *testing.T) {
+	ctx := context.Background()
+	fake := &gcsServer{generations: map[string]int64{}}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	a := gcsBackend(t, server)
+	if err := a.Lock(ctx); err != nil {
+		t.Fatal(err)
+	}
+	// the lock object exists, so the conditional create fails with 412
+	if err := gcsBackend(t, server).Lock(ctx); !errors.Is(err, ErrLocked) {
+		t.Errorf("got %v, want ErrLocked while another backend holds the lock", err)
+	}
+
+	// a lock taken again by another process cannot be deleted by the old holder
+	fake.mu.Lock()
+	fake.generations[a.lockObject()] += 100
+	fake.mu.Unlock()
+	if err := a.Unlock(ctx); !errors.Is(err, ErrLocked) {
+		t.Errorf("got %v, want ErrLocked for a lock with another generation", err)
+	}
+
+	// state unlock builds a fresh backend that never took the lock
+	if err := gcsBackend(t, server).Unlock(ctx); err != nil {
+		t.Fatalf("explicit unlock of a stale lock: %s", err)
+	}
+	if _, ok := fake.generations[a.lockObject()]; ok {
+		t.Error("lock object not deleted")
+	}
+	if err := gcsBackend(t, server).Unlock(ctx); err != nil {
+		t.Errorf("unlocking with no lock held: %s", err)
+	}
+}
+
+func TestGCSWrite(t *testing.T) {
+	ctx := context.Background()
+	fake := &gcsServer{generations: map[string]int64{}}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	a := gcsBackend(t, server)
+	b := gcsBackend(t, server)
+	if err := a.Write(ctx, &State{Version: Version, Controlplane: "mycluster"}); err != nil {
+		t.Fatal(err)
+	}
+	// b has not read the object so its write would overwrite a's
+	if err := b.Write(ctx, &State{Version: Version, Controlplane: "mycluster"}); err == nil || !strings.Contains(err.Error(), "changed by another process") {
+		t.Errorf("got %v, want a conflict", err)
+	}
+	if err := a.Write(ctx, &State{Version: Version, Controlplane: "mycluster", Serial: 2}); err != nil {
+		t.Errorf("second write with the generation a wrote: %s", err)
+	}
+}
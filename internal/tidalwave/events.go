@@ -0,0 +1,237 @@
+package tidalwave
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kyokomi/emoji/v2"
+)
+
+// Phase is where an event is in the life of a resource or command
+type Phase string
+
+const (
+	// PhaseStarted is sent when a resource starts being applied or destroyed
+	PhaseStarted Phase = "started"
+	// PhaseProgress is sent while an operation is running
+	PhaseProgress Phase = "progress"
+	// PhaseDone is sent when a resource was applied or destroyed
+	PhaseDone Phase = "done"
+	// PhaseFailed is sent when a resource or the command failed
+	PhaseFailed Phase = "failed"
+	// PhaseInfo is a message about the run
+	PhaseInfo Phase = "info"
+	// PhaseWarning is a message about something that may need attention
+	PhaseWarning Phase = "warning"
+)
+
+// Event is something that happened while a command ran
+type Event struct {
+	Time time.Time `json:"time"`
+	// Controlplane is the metadata.name of the controlplane, set when a command works on more
+	// than one
+	Controlplane string `json:"controlplane,omitempty"`
+	// Resource is the graph node, such as vpc or firewall/<name>, or the kind/name of a resource
+	Resource string `json:"resource,omitempty"`
+	// Action is what was being done, such as apply, destroy, create, update, delete or plan
+	Action    string `json:"action,omitempty"`
+	Phase     Phase  `json:"phase"`
+	Operation string `json:"operation,omitempty"`
+	// Progress is the percentage of the operation that is done
+	Progress int `json:"progress,omitempty"`
+	// Duration is how long the resource took, set on done and failed events of the graph
+	Duration time.Duration `json:"-"`
+	Message  string        `json:"message,omitempty"`
+	Error    string        `json:"error,omitempty"`
+	// Changes are the fields a plan would change
+	Changes []Change `json:"changes,omitempty"`
+	// Emoji is the alias the emoji renderer prints before the message, such as :sparkles:
+	Emoji string `json:"-"`
+}
+
+// MarshalJSON writes the duration in seconds
+func (e Event) MarshalJSON() ([]byte, error) {
+	type event Event
+	return json.Marshal(struct {
+		event
+		Duration float64 `json:"duration,omitempty"`
+	}{event: event(e), Duration: e.Duration.Seconds()})
+}
+
+// Renderer writes events for people or programs
+type Renderer interface {
+	Render(e Event)
+}
+
+// Bus hands events to a renderer one at a time
+type Bus struct {
+	mu       sync.Mutex
+	renderer Renderer
+}
+
+// NewBus returns a bus that renders events with r
+func NewBus(r Renderer) *Bus {
+	return &Bus{renderer: r}
+}
+
+// Emit renders e, the time is filled in if it is not set
+func (b *Bus) Emit(e Event) {
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.renderer.Render(e)
+}
+
+// SetRenderer changes how the events after this call are rendered
+func (b *Bus) SetRenderer(r Renderer) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.renderer = r
+}
+
+// defaultBus renders the events of contexts without a bus, for people on stdout unless
+// SetRenderer was called
+var defaultBus = NewBus(&EmojiRenderer{W: os.Stdout})
+
+// SetRenderer changes how events of contexts without a bus are rendered
+func SetRenderer(r Renderer) {
+	defaultBus.SetRenderer(r)
+}
+
+type busKey struct{}
+
+type controlplaneKey struct{}
+
+type resourceKey struct{}
+
+// resource is the resource and action events are about
+type resource struct {
+	name   string
+	action string
+}
+
+// WithBus returns a context whose events are sent to b
+func WithBus(ctx context.Context, b *Bus) context.Context {
+	return context.WithValue(ctx, busKey{}, b)
+}
+
+// WithControlplane returns a context whose events name the controlplane
+func WithControlplane(ctx context.Context, name string) context.Context {
+	return context.WithValue(ctx, controlplaneKey{}, name)
+}
+
+// withResource returns a context whose events are about the resource and action
+func withResource(ctx context.Context, name, action string) context.Context {
+	return context.WithValue(ctx, resourceKey{}, resource{name: name, action: action})
+}
+
+// Emit sends e to the bus of ctx or the default bus if there is none. The controlplane,
+// resource and action of ctx are filled in if e does not set them.
+func Emit(ctx context.Context, e Event) {
+	b, ok := ctx.Value(busKey{}).(*Bus)
+	if !ok {
+		b = defaultBus
+	}
+	if name, ok := ctx.Value(controlplaneKey{}).(string); ok && e.Controlplane == "" {
+		e.Controlplane = name
+	}
+	if r, ok := ctx.Value(resourceKey{}).(resource); ok && e.Resource == "" {
+		e.Resource = r.name
+		if e.Action == "" {
+			e.Action = r.action
+		}
+	}
+	b.Emit(e)
+}
+
+// Infof emits a message, the emoji renderer prints it after the emoji alias
+func Infof(ctx context.Context, alias, format string, a ...interface{}) {
+	Emit(ctx, Event{Phase: PhaseInfo, Emoji: alias, Message: fmt.Sprintf(format, a...)})
+}
+
+// Warnf emits a warning
+func Warnf(ctx context.Context, format string, a ...interface{}) {
+	Emit(ctx, Event{Phase: PhaseWarning, Emoji: ":warning:", Message: fmt.Sprintf(format, a...)})
+}
+
+// EmojiRenderer prints the message of every event with its emoji, events without a message
+// are left out
+type EmojiRenderer struct {
+	W io.Writer
+}
+
+// Render prints e
+func (r *EmojiRenderer) Render(e Event) {
+	if e.Message == "" {
+		return
+	}
+	prefix := ""
+	if e.Controlplane != "" {
+		prefix = fmt.Sprintf("[%s] ", e.Controlplane)
+	}
+	alias := e.Emoji
+	if alias != "" {
+		alias += " "
+	}
+	emoji.Fprintf(r.W, "%s%s%s\n", alias, prefix, e.Message)
+}
+
+// PlainRenderer prints every event on one line without emoji, for log collectors
+type PlainRenderer struct {
+	W io.Writer
+}
+
+// Render prints e
+func (r *PlainRenderer) Render(e Event) {
+	fields := map[string]string{
+		"controlplane": e.Controlplane,
+		"resource":     e.Resource,
+		"action":       e.Action,
+		"operation":    e.Operation,
+		"error":        e.Error,
+	}
+	if e.Progress > 0 {
+		fields["progress"] = fmt.Sprintf("%d%%", e.Progress)
+	}
+	if e.Duration > 0 {
+		fields["duration"] = e.Duration.Round(time.Millisecond).String()
+	}
+	keys := []string{}
+	for k, v := range fields {
+		if v != "" {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	line := []string{e.Time.UTC().Format(time.RFC3339), string(e.Phase)}
+	for _, k := range keys {
+		line = append(line, fmt.Sprintf("%s=%q", k, fields[k]))
+	}
+	if e.Message != "" {
+		line = append(line, fmt.Sprintf("message=%q", e.Message))
+	}
+	fmt.Fprintln(r.W, strings.Join(line, " "))
+}
+
+// JSONRenderer writes every event as a line of JSON
+type JSONRenderer struct {
+	W io.Writer
+}
+
+// Render writes e
+func (r *JSONRenderer) Render(e Event) {
+	b, err := json.Marshal(e)
+	if err != nil {
+		return
+	}
+	fmt.Fprintln(r.W, string(b))
+}
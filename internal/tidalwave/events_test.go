@@ -0,0 +1,122 @@
+package tidalwave
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+// events records every event it renders
+type events struct {
+	list []Event
+}
+
+func (e *events) Render(event Event) {
+	e.list = append(e.list, event)
+}
+
+func TestGraphEvents(t *testing.T) {
+	recorded := &events{}
+	ctx := WithControlplane(WithBus(context.Background(), NewBus(recorded)), "east")
+	g := NewGraph(1)
+	for _, n := range []Node{
+		{Name: "vpc", Create: func(ctx context.Context, in Outputs) (Outputs, error) {
+			Infof(ctx, ":check_mark_button:", "Controlplane VPC created")
+			return nil, nil
+		}},
+		{Name: "cluster", Deps: []string{"vpc"}, Create: func(ctx context.Context, in Outputs) (Outputs, error) {
+			return nil, errors.New("quota")
+		}},
+	} {
+		if err := g.Add(n); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := g.Apply(ctx); err == nil {
+		t.Fatal("expected an error")
+	}
+
+	want := []struct {
+		resource string
+		phase    Phase
+	}{
+		{"vpc", PhaseStarted},
+		{"vpc", PhaseInfo},
+		{"vpc", PhaseDone},
+		{"cluster", PhaseStarted},
+		{"cluster", PhaseFailed},
+	}
+	if len(recorded.list) != len(want) {
+		t.Fatalf("got %d events, want %d: %+v", len(recorded.list), len(want), recorded.list)
+	}
+	for i, w := range want {
+		e := recorded.list[i]
+		if e.Resource != w.resource || e.Phase != w.phase || e.Action != "apply" || e.Controlplane != "east" {
+			t.Errorf("event %d = %+v, want %s %s", i, e, w.resource, w.phase)
+		}
+		if e.Time.IsZero() {
+			t.Errorf("event %d has no time", i)
+		}
+	}
+	if got := recorded.list[4].Error; got != "cluster: quota" {
+		t.Errorf("error = %q", got)
+	}
+}
+
+func TestJSONRenderer(t *testing.T) {
+	out := &bytes.Buffer{}
+	b := NewBus(&JSONRenderer{W: out})
+	b.Emit(Event{Resource: "cluster", Action: "apply", Phase: PhaseDone, Duration: 1500 * time.Millisecond, Emoji: ":sparkles:"})
+	b.Emit(Event{Phase: PhaseProgress, Operation: "op", Progress: 50})
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("got %d lines, want 2:\n%s", len(lines), out)
+	}
+	got := map[string]interface{}{}
+	if err := json.Unmarshal([]byte(lines[0]), &got); err != nil {
+		t.Fatal(err)
+	}
+	if got["resource"] != "cluster" || got["phase"] != "done" || got["duration"] != 1.5 {
+		t.Errorf("got %v", got)
+	}
+	if _, ok := got["Emoji"]; ok {
+		t.Errorf("emoji alias is in the JSON: %v", got)
+	}
+	if !strings.Contains(lines[1], `"operation":"op"`) || !strings.Contains(lines[1], `"progress":50`) {
+		t.Errorf("got %s", lines[1])
+	}
+}
+
+func TestTextRenderers(t *testing.T) {
+	e := Event{
+		Time:         time.Date(2022, 1, 2, 3, 4, 5, 0, time.UTC),
+		Controlplane: "east",
+		Resource:     "vpc",
+		Action:       "apply",
+		Phase:        PhaseInfo,
+		Emoji:        ":check_mark_button:",
+		Message:      "Controlplane VPC created",
+	}
+	out := &bytes.Buffer{}
+	(&PlainRenderer{W: out}).Render(e)
+	want := `2022-01-02T03:04:05Z info action="apply" controlplane="east" resource="vpc" message="Controlplane VPC created"` + "\n"
+	if out.String() != want {
+		t.Errorf("plain = %q, want %q", out, want)
+	}
+
+	out.Reset()
+	(&EmojiRenderer{W: out}).Render(e)
+	if !strings.HasSuffix(out.String(), " [east] Controlplane VPC created\n") || strings.Contains(out.String(), ":check_mark_button:") {
+		t.Errorf("emoji = %q", out)
+	}
+	out.Reset()
+	(&EmojiRenderer{W: out}).Render(Event{Resource: "vpc", Phase: PhaseStarted})
+	if out.Len() != 0 {
+		t.Errorf("event without a message printed %q", out)
+	}
+}
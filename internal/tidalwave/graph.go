@@ -0,0 +1,259 @@
+package tidalwave
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultParallelism is the number of nodes a Graph runs at once when none is configured
+const DefaultParallelism = 4
+
+// Outputs are the values a node makes available to the nodes that depend on it, e.g. a self link.
+// The inputs passed to a node hold the outputs of its dependencies keyed as "<node>.<key>".
+type Outputs map[string]string
+
+// Node is a resource in a Graph
+type Node struct {
+	Name string
+	// Deps are the names of the nodes that must be created before this one
+	Deps []string
+	// Create creates or updates the resource
+	Create func(ctx context.Context, in Outputs) (Outputs, error)
+	// Delete deletes the resource, nil if the resource is never deleted
+	Delete func(ctx context.Context) error
+	// Timeout limits how long Create or Delete may run, zero means no limit
+	Timeout time.Duration
+}
+
+// run calls fn with the node's timeout applied to ctx, emitting an event when the node starts
+// and when it is done or failed. Events fn emits are about the node too.
+func (n *Node) run(ctx context.Context, action string, fn func(context.Context) error) error {
+	ctx = withResource(ctx, n.Name, action)
+	start := time.Now()
+	Emit(ctx, Event{Resource: n.Name, Action: action, Phase: PhaseStarted})
+	err := n.call(ctx, fn)
+	e := Event{Resource: n.Name, Action: action, Phase: PhaseDone, Duration: time.Since(start)}
+	if err != nil {
+		e.Phase = PhaseFailed
+		e.Error = err.Error()
+	}
+	Emit(ctx, e)
+	return err
+}
+
+// call calls fn with the node's timeout applied to ctx
+func (n *Node) call(ctx context.Context, fn func(context.Context) error) error {
+	if n.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, n.Timeout)
+		defer cancel()
+	}
+	err := fn(ctx)
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return fmt.Errorf("%s: timed out after %s: %w", n.Name, n.Timeout, err)
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %w", n.Name, err)
+	}
+	return nil
+}
+
+// Graph runs nodes in dependency order, running independent nodes concurrently
+type Graph struct {
+	Parallelism int
+	nodes       map[string]*Node
+}
+
+// NewGraph returns an empty graph that runs at most parallelism nodes at once
+func NewGraph(parallelism int) *Graph {
+	if parallelism < 1 {
+		parallelism = DefaultParallelism
+	}
+	return &Graph{
+		Parallelism: parallelism,
+		nodes:       map[string]*Node{},
+	}
+}
+
+// Add a node to the graph
+func (g *Graph) Add(n Node) error {
+	if _, ok := g.nodes[n.Name]; ok {
+		return fmt.Errorf("node %s is already in the graph", n.Name)
+	}
+	g.nodes[n.Name] = &n
+	return nil
+}
+
+// Validate checks every dependency exists and there are no cycles
+func (g *Graph) Validate() error {
+	for _, n := range g.nodes {
+		for _, d := range n.Deps {
+			if _, ok := g.nodes[d]; !ok {
+				return fmt.Errorf("node %s depends on unknown node %s", n.Name, d)
+			}
+		}
+	}
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	marks := map[string]int{}
+	var visit func(name string, path []string) error
+	visit = func(name string, path []string) error {
+		switch marks[name] {
+		case visiting:
+			return fmt.Errorf("dependency cycle %s -> %s", strings.Join(path, " -> "), name)
+		case visited:
+			return nil
+		}
+		marks[name] = visiting
+		for _, d := range g.nodes[name].Deps {
+			if err := visit(d, append(path, name)); err != nil {
+				return err
+			}
+		}
+		marks[name] = visited
+		return nil
+	}
+	for _, name := range g.names() {
+		if err := visit(name, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Apply creates every node after its dependencies
+func (g *Graph) Apply(ctx context.Context) error {
+	if err := g.Validate(); err != nil {
+		return err
+	}
+	deps := map[string][]string{}
+	for name, n := range g.nodes {
+		deps[name] = n.Deps
+	}
+	var mu sync.Mutex
+	outputs := map[string]Outputs{}
+	return g.walk(ctx, deps, func(ctx context.Context, name string) error {
+		n := g.nodes[name]
+		in := Outputs{}
+		mu.Lock()
+		for _, d := range n.Deps {
+			for k, v := range outputs[d] {
+				in[fmt.Sprintf("%s.%s", d, k)] = v
+			}
+		}
+		mu.Unlock()
+		if n.Create == nil {
+			return nil
+		}
+		return n.run(ctx, "apply", func(ctx context.Context) error {
+			out, err := n.Create(ctx, in)
+			if err != nil {
+				return err
+			}
+			mu.Lock()
+			outputs[name] = out
+			mu.Unlock()
+			return nil
+		})
+	})
+}
+
+// Destroy deletes every node after the nodes that depend on it
+func (g *Graph) Destroy(ctx context.Context) error {
+	if err := g.Validate(); err != nil {
+		return err
+	}
+	dependents := map[string][]string{}
+	for name, n := range g.nodes {
+		for _, d := range n.Deps {
+			dependents[d] = append(dependents[d], name)
+		}
+	}
+	return g.walk(ctx, dependents, func(ctx context.Context, name string) error {
+		n := g.nodes[name]
+		if n.Delete == nil {
+			return nil
+		}
+		return n.run(ctx, "destroy", n.Delete)
+	})
+}
+
+// walk runs fn for every node once all of the nodes it waits on have finished. The first
+// error cancels the context passed to the nodes still running and no further nodes start.
+func (g *Graph) walk(ctx context.Context, waitsOn map[string][]string, fn func(context.Context, string) error) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	pending := map[string]int{}
+	unblocks := map[string][]string{}
+	for name, w := range waitsOn {
+		pending[name] = len(w)
+		for _, d := range w {
+			unblocks[d] = append(unblocks[d], name)
+		}
+	}
+	ready := []string{}
+	for _, name := range g.names() {
+		if pending[name] == 0 {
+			ready = append(ready, name)
+		}
+	}
+
+	type result struct {
+		name string
+		err  error
+	}
+	results := make(chan result)
+	running := 0
+	var firstErr error
+	for len(ready) > 0 || running > 0 {
+		for firstErr == nil && len(ready) > 0 && running < g.Parallelism {
+			name := ready[0]
+			ready = ready[1:]
+			running++
+			go func() {
+				results <- result{name: name, err: fn(ctx, name)}
+			}()
+		}
+		if running == 0 {
+			break
+		}
+		r := <-results
+		running--
+		if r.err != nil {
+			if firstErr == nil {
+				firstErr = r.err
+				cancel()
+			}
+			continue
+		}
+		for _, next := range unblocks[r.name] {
+			pending[next]--
+			if pending[next] == 0 {
+				ready = append(ready, next)
+			}
+		}
+	}
+	if firstErr != nil {
+		return firstErr
+	}
+	return ctx.Err()
+}
+
+// names returns the node names in a stable order
+func (g *Graph) names() []string {
+	names := []string{}
+	for name := range g.nodes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,64 @@
+package tidalwave
+
+import (
+	"context"
+	"sort"
+	"sync"
+)
+
+// Operation is a long-running operation started on the cloud provider
+type Operation struct {
+	ID          string
+	Description string
+}
+
+// Operations tracks operations that were started but not seen to finish, so that after a
+// cancellation the operations still running server side can be reported
+type Operations struct {
+	mu      sync.Mutex
+	running map[string]Operation
+}
+
+type operationsKey struct{}
+
+// WithOperations returns a context that records the operations started with it
+func WithOperations(ctx context.Context) (context.Context, *Operations) {
+	ops := &Operations{
+		running: map[string]Operation{},
+	}
+	return context.WithValue(ctx, operationsKey{}, ops), ops
+}
+
+// StartOperation records a started operation and returns a func to call once waiting on it
+// returns. The operation stays recorded if ctx was cancelled or timed out while waiting.
+func StartOperation(ctx context.Context, id, description string) func() {
+	ops, ok := ctx.Value(operationsKey{}).(*Operations)
+	if !ok {
+		return func() {}
+	}
+	ops.mu.Lock()
+	ops.running[id] = Operation{ID: id, Description: description}
+	ops.mu.Unlock()
+	return func() {
+		if ctx.Err() != nil {
+			return
+		}
+		ops.mu.Lock()
+		delete(ops.running, id)
+		ops.mu.Unlock()
+	}
+}
+
+// Running returns the operations that have not been seen to finish
+func (o *Operations) Running() []Operation {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	running := []Operation{}
+	for _, op := range o.running {
+		running = append(running, op)
+	}
+	sort.Slice(running, func(i, j int) bool {
+		return running[i].ID < running[j].ID
+	})
+	return running
+}
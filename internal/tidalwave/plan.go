@@ -0,0 +1,123 @@
+package tidalwave
+
+import (
+	"context"
+	"fmt"
+)
+
+// Action describes what applying the config would do to a resource
+type Action string
+
+const (
+	// ActionCreate means the resource does not exist yet
+	ActionCreate Action = "create"
+	// ActionUpdate means the resource can be changed in place
+	ActionUpdate Action = "update"
+	// ActionReplace means the resource must be destroyed and recreated
+	ActionReplace Action = "replace"
+	// ActionDelete means the resource exists but is no longer in the config
+	ActionDelete Action = "delete"
+	// ActionNoop means the resource already matches the config
+	ActionNoop Action = "no-op"
+)
+
+// Change is a single field that differs between the config and the live resource
+type Change struct {
+	Field    string `json:"field"`
+	Observed string `json:"observed"`
+	Desired  string `json:"desired"`
+	// ForceNew is set when the field cannot be changed without replacing the resource
+	ForceNew bool `json:"forceNew,omitempty"`
+}
+
+// ResourcePlan is the desired vs observed comparison for a single resource
+type ResourcePlan struct {
+	Kind    string   `json:"kind"`
+	Name    string   `json:"name"`
+	Action  Action   `json:"action"`
+	Changes []Change `json:"changes,omitempty"`
+}
+
+// NewResourcePlan works out the action for a resource from whether it exists and its changes
+func NewResourcePlan(kind, name string, exists bool, changes []Change) *ResourcePlan {
+	p := &ResourcePlan{
+		Kind:    kind,
+		Name:    name,
+		Action:  ActionNoop,
+		Changes: changes,
+	}
+	if !exists {
+		p.Action = ActionCreate
+		return p
+	}
+	for _, c := range changes {
+		if c.ForceNew {
+			p.Action = ActionReplace
+			return p
+		}
+		p.Action = ActionUpdate
+	}
+	return p
+}
+
+// ClusterPlanner provides a read-only preview of cluster changes
+type ClusterPlanner interface {
+	Plan(ctx context.Context) ([]ResourcePlan, error)
+}
+
+// PlanCluster compares the config with the live cluster and dependencies
+func PlanCluster(ctx context.Context, c ClusterPlanner) ([]ResourcePlan, error) {
+	plan, err := c.Plan(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return plan, nil
+}
+
+// PlanSummary counts the resources in the plan by action
+func PlanSummary(plan []ResourcePlan) string {
+	counts := map[Action]int{}
+	for _, p := range plan {
+		counts[p.Action]++
+	}
+	return fmt.Sprintf("%d to create, %d to update, %d to replace, %d to delete, %d unchanged",
+		counts[ActionCreate], counts[ActionUpdate], counts[ActionReplace], counts[ActionDelete], counts[ActionNoop])
+}
+
+// PrintPlan emits an event for every resource in the plan with a field by field diff, then a
+// summary of the plan
+func PrintPlan(ctx context.Context, plan []ResourcePlan) {
+	for _, p := range plan {
+		e := Event{Resource: fmt.Sprintf("%s/%s", p.Kind, p.Name), Action: string(p.Action), Phase: PhaseInfo, Changes: p.Changes}
+		switch p.Action {
+		case ActionCreate:
+			e.Emoji, e.Message = ":sparkles:", fmt.Sprintf("+ %s %s will be created", p.Kind, p.Name)
+		case ActionUpdate:
+			e.Emoji, e.Message = ":pencil:", fmt.Sprintf("~ %s %s will be updated in place", p.Kind, p.Name)
+		case ActionReplace:
+			e.Emoji, e.Message = ":recycling_symbol:", fmt.Sprintf("-/+ %s %s must be replaced", p.Kind, p.Name)
+		case ActionDelete:
+			e.Emoji, e.Message = ":wastebasket:", fmt.Sprintf("- %s %s will be deleted", p.Kind, p.Name)
+		default:
+			e.Emoji, e.Message = ":check_mark_button:", fmt.Sprintf("%s %s is up to date", p.Kind, p.Name)
+		}
+		if p.Action != ActionCreate {
+			for _, c := range p.Changes {
+				note := ""
+				if c.ForceNew {
+					note = " (forces replacement)"
+				}
+				e.Message += fmt.Sprintf("\n      %s: %s => %s%s", c.Field, display(c.Observed), display(c.Desired), note)
+			}
+		}
+		Emit(ctx, e)
+	}
+	Emit(ctx, Event{Action: "plan", Phase: PhaseDone, Message: fmt.Sprintf("\nPlan: %s", PlanSummary(plan))})
+}
+
+func display(s string) string {
+	if s == "" {
+		return "(none)"
+	}
+	return fmt.Sprintf("%q", s)
+}
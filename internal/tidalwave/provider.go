@@ -0,0 +1,166 @@
+package tidalwave
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"tidalwave/internal/config"
+	"tidalwave/internal/state"
+)
+
+// ErrUnsupported is returned by controlplanes for operations their provider cannot do
+var ErrUnsupported = errors.New("not supported by this provider")
+
+// ResourceStatus is the live state of a single resource
+type ResourceStatus struct {
+	Kind   string `json:"kind" yaml:"kind"`
+	Name   string `json:"name" yaml:"name"`
+	Exists bool   `json:"exists" yaml:"exists"`
+	// InSync is set when the live resource matches the config
+	InSync     bool              `json:"inSync" yaml:"inSync"`
+	Attributes map[string]string `json:"attributes,omitempty" yaml:"attributes,omitempty"`
+}
+
+// ClusterStatuser reports the live state of a cluster and dependencies
+type ClusterStatuser interface {
+	Status(ctx context.Context) ([]ResourceStatus, error)
+}
+
+// StatusFromPlan derives the status of every resource in a plan
+func StatusFromPlan(plan []ResourcePlan) []ResourceStatus {
+	status := make([]ResourceStatus, 0, len(plan))
+	for _, p := range plan {
+		status = append(status, ResourceStatus{
+			Kind:   p.Kind,
+			Name:   p.Name,
+			Exists: p.Action != ActionCreate,
+			InSync: p.Action == ActionNoop,
+		})
+	}
+	return status
+}
+
+// PrintStatus prints a row for every resource with its key attributes
+func PrintStatus(w io.Writer, status []ResourceStatus) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "KIND\tNAME\tSTATUS\tATTRIBUTES")
+	for _, s := range status {
+		health := "ok"
+		switch {
+		case !s.Exists:
+			health = "missing"
+		case !s.InSync:
+			health = "drifted"
+		}
+		keys := make([]string, 0, len(s.Attributes))
+		for k, v := range s.Attributes {
+			if v != "" {
+				keys = append(keys, k)
+			}
+		}
+		sort.Strings(keys)
+		attributes := make([]string, 0, len(keys))
+		for _, k := range keys {
+			attributes = append(attributes, fmt.Sprintf("%s=%s", k, s.Attributes[k]))
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", s.Kind, s.Name, health, strings.Join(attributes, " "))
+	}
+	return tw.Flush()
+}
+
+// ClusterCredentials are what a kubeconfig needs to reach the cluster
+type ClusterCredentials struct {
+	// Endpoint is the public address of the API server, empty if it has none
+	Endpoint string `json:"endpoint,omitempty"`
+	// PrivateEndpoint is the address of the API server inside the VPC
+	PrivateEndpoint string `json:"privateEndpoint,omitempty"`
+	// CertificateAuthority is the base64 encoded CA certificate of the API server
+	CertificateAuthority string `json:"certificateAuthority"`
+	// Exec is the credential plugin kubectl runs to get a token
+	Exec *ExecCredential `json:"exec,omitempty"`
+	// Token is a short-lived bearer token, only set when one was asked for
+	Token string `json:"token,omitempty"`
+}
+
+// ExecCredential is a client-go credential plugin
+type ExecCredential struct {
+	APIVersion  string            `json:"apiVersion"`
+	Command     string            `json:"command"`
+	Args        []string          `json:"args,omitempty"`
+	Env         map[string]string `json:"env,omitempty"`
+	InstallHint string            `json:"installHint,omitempty"`
+}
+
+// ClusterCredentialer returns the endpoints and credentials of a cluster, token asks for a
+// short-lived token from the ambient cloud credentials as well as the credential plugin
+type ClusterCredentialer interface {
+	Credentials(ctx context.Context, token bool) (*ClusterCredentials, error)
+}
+
+// Controlplane is everything a provider can do to a cluster and dependencies
+type Controlplane interface {
+	ClusterCreater
+	ClusterUpdater
+	ClusterDeleter
+	ClusterPlanner
+	ClusterStatuser
+	ClusterCredentialer
+}
+
+// Stateful is implemented by controlplanes that record the resources they create in state,
+// force deletes resources even if they are not recorded
+type Stateful interface {
+	SetState(store *state.Store, force bool)
+}
+
+// Factory turns the parsed config into a controlplane, defaults are applied and the config is
+// valid when it is called
+type Factory func(ctx context.Context, cfg *config.Config) (Controlplane, error)
+
+var (
+	providersMu sync.RWMutex
+	providers   = map[string]Factory{}
+)
+
+// Register makes a provider available by name, registering the same name twice replaces the
+// factory
+func Register(name string, factory Factory) {
+	providersMu.Lock()
+	defer providersMu.Unlock()
+	providers[name] = factory
+}
+
+// Lookup returns the factory registered for a provider
+func Lookup(name string) (Factory, bool) {
+	providersMu.RLock()
+	defer providersMu.RUnlock()
+	factory, ok := providers[name]
+	return factory, ok
+}
+
+// Providers returns the names of the registered providers in order
+func Providers() []string {
+	providersMu.RLock()
+	defer providersMu.RUnlock()
+	names := make([]string, 0, len(providers))
+	for name := range providers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// NewControlplane builds a controlplane from cfg with the factory registered for its provider
+func NewControlplane(ctx context.Context, cfg *config.Config) (Controlplane, error) {
+	provider := cfg.Spec.Provider
+	factory, ok := Lookup(provider)
+	if !ok {
+		return nil, fmt.Errorf("unknown provider %q, expected one of %s", provider, strings.Join(Providers(), ", "))
+	}
+	return factory(ctx, cfg)
+}
@@ -0,0 +1,42 @@
+package tidalwave
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"tidalwave/internal/config"
+)
+
+func TestNewControlplaneUnknownProvider(t *testing.T) {
+	Register("test-a", func(ctx context.Context, cfg *config.Config) (Controlplane, error) {
+		return nil, nil
+	})
+	Register("test-b", func(ctx context.Context, cfg *config.Config) (Controlplane, error) {
+		return nil, nil
+	})
+	_, err := NewControlplane(context.Background(), &config.Config{Spec: config.Spec{Provider: "nope"}})
+	if err == nil {
+		t.Fatal("expected an error for an unknown provider")
+	}
+	if !strings.Contains(err.Error(), `unknown provider "nope"`) || !strings.Contains(err.Error(), "test-a, test-b") {
+		t.Errorf("error does not list the registered providers: %s", err)
+	}
+}
+
+func TestStatusFromPlan(t *testing.T) {
+	plan := []ResourcePlan{
+		*NewResourcePlan("vpc", "a", false, nil),
+		*NewResourcePlan("subnetwork", "a", true, nil),
+		*NewResourcePlan("cluster", "a", true, []Change{{Field: "version", Observed: "1", Desired: "2"}}),
+	}
+	want := []ResourceStatus{
+		{Kind: "vpc", Name: "a"},
+		{Kind: "subnetwork", Name: "a", Exists: true, InSync: true},
+		{Kind: "cluster", Name: "a", Exists: true},
+	}
+	for i, got := range StatusFromPlan(plan) {
+		if got.Exists != want[i].Exists || got.InSync != want[i].InSync {
+			t.Errorf("%s: got %+v, want %+v", got.Kind, got, want[i])
+		}
+	}
+}
@@ -1,22 +1,24 @@
 package tidalwave
 
+import "context"
+
 // ClusterCreater provides cluster creation
 type ClusterCreater interface {
-	Create() error
-	EnableApis() error
+	Create(ctx context.Context) error
+	EnableApis(ctx context.Context) error
 }
 
-//CreateApis implements the ClusterCreater interface to enable apis
-func CheckApis(c ClusterCreater) (error) {
-	if err := c.EnableApis(); err != nil {
+// CheckApis implements the ClusterCreater interface to enable apis
+func CheckApis(ctx context.Context, c ClusterCreater) error {
+	if err := c.EnableApis(ctx); err != nil {
 		return err
 	}
 	return nil
 }
 
 // CreateCluster creates a cluster and dependencies
-func CreateCluster(c ClusterCreater) error {
-	err := c.Create()
+func CreateCluster(ctx context.Context, c ClusterCreater) error {
+	err := c.Create(ctx)
 	if err != nil {
 		return err
 	}
@@ -25,12 +27,12 @@ func CreateCluster(c ClusterCreater) error {
 
 // ClusterDeleter provides cluster deletion
 type ClusterDeleter interface {
-	Delete() error
+	Delete(ctx context.Context) error
 }
 
 // DeleteCluster deletes a cluster and dependencies
-func DeleteCluster(c ClusterDeleter) error {
-	err := c.Delete()
+func DeleteCluster(ctx context.Context, c ClusterDeleter) error {
+	err := c.Delete(ctx)
 	if err != nil {
 		return err
 	}
@@ -39,12 +41,12 @@ func DeleteCluster(c ClusterDeleter) error {
 
 // ClusterUpdater provides cluster updates
 type ClusterUpdater interface {
-	Update() error
+	Update(ctx context.Context) error
 }
 
 // UpdateCluster updates a cluster and dependencies
-func UpdateCluster(c ClusterUpdater) error {
-	err := c.Update()
+func UpdateCluster(ctx context.Context, c ClusterUpdater) error {
+	err := c.Update(ctx)
 	if err != nil {
 		return err
 	}
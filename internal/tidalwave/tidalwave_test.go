@@ -1 +1,200 @@
 package tidalwave
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// recorder records the order nodes run in
+type recorder struct {
+	mu    sync.Mutex
+	order []string
+}
+
+func (r *recorder) add(name string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.order = append(r.order, name)
+}
+
+func (r *recorder) index(name string) int {
+	for i, n := range r.order {
+		if n == name {
+			return i
+		}
+	}
+	return -1
+}
+
+func testGraph(t *testing.T, r *recorder, parallelism int) *Graph {
+	g := NewGraph(parallelism)
+	nodes := map[string][]string{
+		"vpc":        nil,
+		"subnetwork": {"vpc"},
+		"router":     {"vpc"},
+		"keyring":    nil,
+		"cryptokey":  {"keyring"},
+		"cluster":    {"subnetwork", "router", "cryptokey"},
+	}
+	for name, deps := range nodes {
+		name := name
+		err := g.Add(Node{
+			Name: name,
+			Deps: deps,
+			Create: func(ctx context.Context, in Outputs) (Outputs, error) {
+				r.add(name)
+				return Outputs{"selfLink": name}, nil
+			},
+			Delete: func(ctx context.Context) error {
+				r.add(name)
+				return nil
+			},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return g
+}
+
+func TestGraphApplyOrder(t *testing.T) {
+	r := &recorder{}
+	if err := testGraph(t, r, 2).Apply(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	for _, edge := range [][2]string{{"vpc", "subnetwork"}, {"vpc", "router"}, {"keyring", "cryptokey"}, {"subnetwork", "cluster"}, {"router", "cluster"}, {"cryptokey", "cluster"}} {
+		if r.index(edge[0]) > r.index(edge[1]) {
+			t.Errorf("%s ran before its dependency %s: %v", edge[1], edge[0], r.order)
+		}
+	}
+}
+
+func TestGraphDestroyOrder(t *testing.T) {
+	r := &recorder{}
+	if err := testGraph(t, r, 2).Destroy(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	for _, edge := range [][2]string{{"vpc", "subnetwork"}, {"keyring", "cryptokey"}, {"subnetwork", "cluster"}} {
+		if r.index(edge[0]) < r.index(edge[1]) {
+			t.Errorf("%s was deleted before its dependent %s: %v", edge[0], edge[1], r.order)
+		}
+	}
+}
+
+func TestGraphOutputs(t *testing.T) {
+	g := NewGraph(1)
+	g.Add(Node{
+		Name: "vpc",
+		Create: func(ctx context.Context, in Outputs) (Outputs, error) {
+			return Outputs{"selfLink": "projects/p/global/networks/n"}, nil
+		},
+	})
+	var got string
+	g.Add(Node{
+		Name: "subnetwork",
+		Deps: []string{"vpc"},
+		Create: func(ctx context.Context, in Outputs) (Outputs, error) {
+			got = in["vpc.selfLink"]
+			return nil, nil
+		},
+	})
+	if err := g.Apply(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if got != "projects/p/global/networks/n" {
+		t.Errorf("got input %q", got)
+	}
+}
+
+func TestGraphParallelism(t *testing.T) {
+	g := NewGraph(2)
+	var running, max int32
+	for _, name := range []string{"a", "b", "c", "d", "e"} {
+		g.Add(Node{
+			Name: name,
+			Create: func(ctx context.Context, in Outputs) (Outputs, error) {
+				n := atomic.AddInt32(&running, 1)
+				for {
+					m := atomic.LoadInt32(&max)
+					if n <= m || atomic.CompareAndSwapInt32(&max, m, n) {
+						break
+					}
+				}
+				time.Sleep(10 * time.Millisecond)
+				atomic.AddInt32(&running, -1)
+				return nil, nil
+			},
+		})
+	}
+	if err := g.Apply(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if max != 2 {
+		t.Errorf("expected 2 nodes to run at once, got %d", max)
+	}
+}
+
+func TestGraphFailureCancelsSiblings(t *testing.T) {
+	g := NewGraph(4)
+	boom := errors.New("boom")
+	cancelled := make(chan bool, 1)
+	g.Add(Node{
+		Name: "fails",
+		Create: func(ctx context.Context, in Outputs) (Outputs, error) {
+			return nil, boom
+		},
+	})
+	g.Add(Node{
+		Name: "slow",
+		Create: func(ctx context.Context, in Outputs) (Outputs, error) {
+			select {
+			case <-ctx.Done():
+				cancelled <- true
+				return nil, ctx.Err()
+			case <-time.After(5 * time.Second):
+				cancelled <- false
+				return nil, nil
+			}
+		},
+	})
+	ran := false
+	g.Add(Node{
+		Name: "after",
+		Deps: []string{"fails"},
+		Create: func(ctx context.Context, in Outputs) (Outputs, error) {
+			ran = true
+			return nil, nil
+		},
+	})
+	err := g.Apply(context.Background())
+	if !errors.Is(err, boom) {
+		t.Fatalf("expected boom, got %v", err)
+	}
+	if !<-cancelled {
+		t.Error("sibling was not cancelled")
+	}
+	if ran {
+		t.Error("dependent of a failed node ran")
+	}
+}
+
+func TestGraphValidate(t *testing.T) {
+	g := NewGraph(1)
+	g.Add(Node{Name: "a", Deps: []string{"b"}})
+	g.Add(Node{Name: "b", Deps: []string{"a"}})
+	if err := g.Validate(); err == nil {
+		t.Error("expected a cycle error")
+	}
+	g = NewGraph(1)
+	g.Add(Node{Name: "a", Deps: []string{"missing"}})
+	if err := g.Validate(); err == nil {
+		t.Error("expected an unknown dependency error")
+	}
+	if err := g.Add(Node{Name: "a"}); err == nil {
+		t.Error("expected a duplicate node error")
+	}
+}
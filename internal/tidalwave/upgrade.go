@@ -0,0 +1,82 @@
+package tidalwave
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+)
+
+// UpgradeStep moves the controlplane or a node pool of a cluster from one version to another
+type UpgradeStep struct {
+	// Kind is master or nodepool
+	Kind string `json:"kind" yaml:"kind"`
+	Name string `json:"name" yaml:"name"`
+	From string `json:"from" yaml:"from"`
+	To   string `json:"to" yaml:"to"`
+}
+
+// NodePoolVersion is the version the nodes of a pool run
+type NodePoolVersion struct {
+	Name    string `json:"name" yaml:"name"`
+	Version string `json:"version" yaml:"version"`
+}
+
+// Versions are the versions a cluster runs and the versions it can be upgraded to, newest
+// first
+type Versions struct {
+	Master    string            `json:"master" yaml:"master"`
+	NodePools []NodePoolVersion `json:"nodePools,omitempty" yaml:"nodePools,omitempty"`
+	// Channel is the release channel the valid versions are from, empty if not enrolled
+	Channel             string   `json:"channel,omitempty" yaml:"channel,omitempty"`
+	Default             string   `json:"default,omitempty" yaml:"default,omitempty"`
+	ValidMasterVersions []string `json:"validMasterVersions" yaml:"validMasterVersions"`
+	ValidNodeVersions   []string `json:"validNodeVersions" yaml:"validNodeVersions"`
+}
+
+// ClusterUpgrader upgrades the controlplane of a cluster and then each of its node pools in
+// order. Steps already at the version are skipped so an upgrade that failed or paused carries
+// on where it stopped when it is run again, dryRun returns the steps without running them and
+// pause stops after the first step that runs.
+type ClusterUpgrader interface {
+	Versions(ctx context.Context) (*Versions, error)
+	Upgrade(ctx context.Context, version string, dryRun, pause bool) ([]UpgradeStep, error)
+}
+
+// PrintVersions prints the versions of the controlplane and node pools and the versions they
+// can be upgraded to
+func PrintVersions(w io.Writer, v *Versions) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "KIND\tNAME\tVERSION")
+	fmt.Fprintf(tw, "master\t\t%s\n", v.Master)
+	for _, p := range v.NodePools {
+		fmt.Fprintf(tw, "nodepool\t%s\t%s\n", p.Name, p.Version)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	if v.Channel != "" {
+		fmt.Fprintf(w, "\nRelease channel: %s\n", v.Channel)
+	}
+	fmt.Fprintf(w, "Default version: %s\n", v.Default)
+	fmt.Fprintf(w, "Valid master versions: %s\n", strings.Join(v.ValidMasterVersions, ", "))
+	_, err := fmt.Fprintf(w, "Valid node versions: %s\n", strings.Join(v.ValidNodeVersions, ", "))
+	return err
+}
+
+// PrintUpgrade emits an event for every step of an upgrade in the order they run
+func PrintUpgrade(ctx context.Context, steps []UpgradeStep) {
+	for i, s := range steps {
+		Emit(ctx, Event{
+			Resource: fmt.Sprintf("%s/%s", s.Kind, s.Name),
+			Action:   "upgrade",
+			Phase:    PhaseInfo,
+			Emoji:    ":up_arrow:",
+			Message:  fmt.Sprintf("%d. %s %s will be upgraded from %s to %s", i+1, s.Kind, s.Name, s.From, s.To),
+		})
+	}
+	if len(steps) == 0 {
+		Emit(ctx, Event{Action: "upgrade", Phase: PhaseDone, Emoji: ":check_mark_button:", Message: "Nothing to upgrade"})
+	}
+}
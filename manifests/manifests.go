@@ -0,0 +1,13 @@
+/*
+Package manifests holds the bundled add-on manifests, kustomizations of Argo CD Applications
+and ApplicationSets. The files are text/template templates rendered from spec.addons by the
+addons package.
+*/
+package manifests
+
+import "embed"
+
+// FS holds the argocd-apps and argocd-appsets kustomizations
+//
+//go:embed argocd-apps argocd-appsets
+var FS embed.FS